@@ -13,16 +13,19 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/zyhnesmr/godis/internal/acl"
 	"github.com/zyhnesmr/godis/internal/command"
 	"github.com/zyhnesmr/godis/internal/command/commands"
 	"github.com/zyhnesmr/godis/internal/config"
 	"github.com/zyhnesmr/godis/internal/database"
 	"github.com/zyhnesmr/godis/internal/eviction"
 	"github.com/zyhnesmr/godis/internal/expire"
+	"github.com/zyhnesmr/godis/internal/latency"
 	"github.com/zyhnesmr/godis/internal/net"
 	aof2 "github.com/zyhnesmr/godis/internal/persistence/aof"
 	rdb2 "github.com/zyhnesmr/godis/internal/persistence/rdb"
 	"github.com/zyhnesmr/godis/internal/pubsub"
+	"github.com/zyhnesmr/godis/internal/replication"
 	"github.com/zyhnesmr/godis/internal/script"
 	"github.com/zyhnesmr/godis/pkg/log"
 )
@@ -63,9 +66,24 @@ func main() {
 	} else {
 		dbSelector = database.NewDBSelector(int(cfg.Databases))
 		if cfg.MaxMemory > 0 {
+			// Keep the limit so that CONFIG SET maxmemory-policy can turn
+			// eviction on later
+			dbSelector.SetMaxMemory(cfg.MaxMemory)
 			log.Info("Max memory limit: %d bytes (noeviction)", cfg.MaxMemory)
 		}
 	}
+	dbSelector.GetEvictionManager().SetSamples(cfg.MaxMemorySamples)
+
+	// Apply the listpack encoding limits
+	database.SetEncodingLimits(database.EncodingLimits{
+		HashMaxEntries:      cfg.HashMaxZiplistEntries,
+		HashMaxValue:        cfg.HashMaxZiplistValue,
+		ListMaxSize:         cfg.ListMaxZiplistSize,
+		ListCompressDepth:   cfg.ListCompressDepth,
+		ZSetMaxEntries:      cfg.ZSetMaxZiplistEntries,
+		ZSetMaxValue:        cfg.ZSetMaxZiplistValue,
+		SetMaxIntsetEntries: cfg.SetMaxIntsetEntries,
+	})
 
 	// Initialize expire manager
 	expireMgr := expire.NewManager(func(db int, key string) {
@@ -78,6 +96,13 @@ func main() {
 
 	// Start expire scheduler
 	expireScheduler := expire.NewScheduler(expireMgr)
+	expireDBs := make([]expire.ActiveExpireDB, 0, dbSelector.Count())
+	for i := 0; i < dbSelector.Count(); i++ {
+		db, _ := dbSelector.GetDB(i)
+		expireDBs = append(expireDBs, db)
+	}
+	expireScheduler.SetDatabases(expireDBs)
+	commands.SetExpireScheduler(expireScheduler)
 	expireScheduler.Start()
 	log.Info("Expire scheduler started")
 
@@ -85,15 +110,15 @@ func main() {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
-	// Start eviction checker (if eviction is enabled)
-	evictionMgr := dbSelector.GetEvictionManager()
-	if evictionMgr.IsEnabled() {
-		go runEvictionChecker(ctx, dbSelector)
-		log.Info("Eviction checker started")
-	}
+	// Start eviction checker. It runs even while eviction is disabled, since
+	// CONFIG SET can enable it at runtime.
+	go runEvictionChecker(ctx, dbSelector)
 
 	// Create command dispatcher
 	dispatcher := command.NewDispatcher(dbSelector)
+	dispatcher.SlowLog().SetSlowerThan(cfg.SlowLogLogSlowerThan)
+	dispatcher.SlowLog().SetMaxLen(cfg.SlowLogMaxLen)
+	latency.Instance().SetThreshold(cfg.LatencyMonitorThreshold)
 
 	// Register all commands
 	aofMgr := registerCommands(dispatcher, dbSelector, cfg)
@@ -101,6 +126,9 @@ func main() {
 	// Set AOF logger (will check if enabled internally)
 	dispatcher.SetAOFLogger(aofMgr)
 
+	// Refuse writes after a failed background save (stop-writes-on-bgsave-error)
+	dispatcher.SetWriteGuard(commands.CheckWritesAllowed)
+
 	// Load data from persistence files
 	// If AOF file exists, load AOF (it has more recent data)
 	// Otherwise load RDB
@@ -128,11 +156,24 @@ func main() {
 		})
 	} else {
 		// Load RDB file if exists
-		loadRDBOnStartup(dbSelector, cfg)
+		loadRDBOnStartup(dbSelector, commands.GetRDBManager())
 	}
 
+	// The loaded dataset does not count as unsaved changes
+	commands.GetRDBManager().Stats().Reset()
+
+	// Start RDB auto save checker
+	go runRDBSaveChecker(ctx, cfg)
+
+	// Start AOF auto rewrite checker
+	go runAOFRewriteChecker(ctx, dbSelector, aofMgr)
+
 	// Create server
 	srv := net.NewServer(cfg.Bind, int(cfg.Port), dispatcher)
+	commands.SetClientRegistry(srv)
+
+	// Drop the transaction state of disconnected clients
+	srv.SetConnCloseHook(dispatcher.GetTxManager().RemoveConnection)
 
 	// Setup signal handling
 	sigChan := make(chan os.Signal, 1)
@@ -185,6 +226,59 @@ func runEvictionChecker(ctx context.Context, dbSelector *database.DBSelector) {
 	}
 }
 
+// runRDBSaveChecker periodically starts a background save once one of the
+// save rules is met
+func runRDBSaveChecker(ctx context.Context, cfg *config.Config) {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if commands.AutoSave(cfg.ShouldSave) {
+				log.Info("Background saving started by save rules")
+			}
+		}
+	}
+}
+
+// runAOFRewriteChecker periodically triggers an AOF rewrite once the file
+// has grown past the auto-aof-rewrite thresholds
+func runAOFRewriteChecker(ctx context.Context, dbSelector *database.DBSelector, aofMgr *aof2.AOF) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if !aofMgr.ShouldRewrite() {
+				continue
+			}
+
+			dbs := make([]*database.DB, dbSelector.Count())
+			for i := 0; i < dbSelector.Count(); i++ {
+				dbs[i], _ = dbSelector.GetDB(i)
+			}
+
+			errChan := aofMgr.AutoRewrite(dbs)
+			if errChan == nil {
+				continue
+			}
+
+			log.Info("Starting automatic AOF rewrite")
+			if err := <-errChan; err != nil {
+				log.Error("Automatic AOF rewrite failed: %v", err)
+			} else {
+				log.Info("Automatic AOF rewrite completed")
+			}
+		}
+	}
+}
+
 func registerCommands(disp *command.Dispatcher, dbSelector *database.DBSelector, cfg *config.Config) *aof2.AOF {
 	// Initialize pubsub manager
 	mgr := pubsub.NewManager()
@@ -200,11 +294,16 @@ func registerCommands(disp *command.Dispatcher, dbSelector *database.DBSelector,
 
 	// Initialize RDB manager
 	rdbMgr := rdb2.NewRDB(cfg.Dir, cfg.RdbFilename)
+	rdbMgr.SetChecksum(cfg.RdbChecksum)
 	commands.SetRDBManager(rdbMgr)
 	commands.SetDBSelectorForPersistence(dbSelector)
 
+	// Count changes since the last save for the save rules
+	disp.SetChangeCounter(rdbMgr.Stats())
+
 	// Initialize AOF manager
 	aofMgr := aof2.NewAOF(cfg.Dir, cfg.AppendFilename, cfg)
+	aofMgr.SetWriteBarrier(disp.PauseWrites)
 	aof2.SetAOFManager(aofMgr)
 	aof2.SetDBSelectorForAOF(dbSelector)
 
@@ -216,10 +315,10 @@ func registerCommands(disp *command.Dispatcher, dbSelector *database.DBSelector,
 			return err
 		}
 
-		// Get command
+		// Get command. Only write commands are replayed.
 		cmd, ok := disp.Get(cmdName)
-		if !ok {
-			return nil // Skip unknown commands
+		if !ok || !cmd.HasFlag(command.FlagWrite) {
+			return nil // Skip unknown and read-only commands
 		}
 
 		// Create context and execute
@@ -243,8 +342,25 @@ func registerCommands(disp *command.Dispatcher, dbSelector *database.DBSelector,
 	}
 
 	// Register server commands
+	commands.SetServerVersion(Version)
+	commands.SetBuildInfo(GitCommit, BuildTime)
 	commands.RegisterServerCommands(disp)
 
+	// Initialize the ACL users and register the ACL command
+	users := acl.NewUsers()
+	commands.SetACL(users)
+	disp.SetACL(users)
+	commands.RegisterACLCommands(disp)
+
+	// Initialize replication manager and register replication commands
+	replMgr := replication.NewManager()
+	replMgr.SetDataset(commands.ReplicationDataset{})
+	replMgr.SetWriteBarrier(disp.PauseWrites)
+	replMgr.SetBacklogSize(int(cfg.ReplBacklogSize))
+	commands.SetReplicationManager(replMgr)
+	disp.SetReplicationFeed(replMgr)
+	commands.RegisterReplicationCommands(disp)
+
 	// Register key commands
 	commands.RegisterKeyCommands(disp)
 
@@ -295,8 +411,7 @@ func registerCommands(disp *command.Dispatcher, dbSelector *database.DBSelector,
 }
 
 // loadRDBOnStartup loads the RDB file on startup if it exists
-func loadRDBOnStartup(dbSelector *database.DBSelector, cfg *config.Config) {
-	rdbMgr := rdb2.NewRDB(cfg.Dir, cfg.RdbFilename)
+func loadRDBOnStartup(dbSelector *database.DBSelector, rdbMgr *rdb2.RDB) {
 	if !rdbMgr.FileExists() {
 		log.Info("No RDB file found, starting with empty database")
 		return
@@ -0,0 +1,286 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package acl implements access control lists: named users with
+// passwords that may run the commands of some categories only. The
+// default user, which connections use until they authenticate, starts
+// enabled, without a password and allowed every command.
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DefaultUser is the user of connections that did not authenticate
+const DefaultUser = "default"
+
+// AllCategories is the category that matches every command
+const AllCategories = "all"
+
+// categoryRule allows or denies the commands of a category
+type categoryRule struct {
+	category string
+	allow    bool
+}
+
+// User is a user of the server. A User is never modified once created:
+// SetUser replaces it, so that connections can check a command against it
+// without locking.
+type User struct {
+	name      string
+	enabled   bool
+	noPass    bool
+	passwords map[string]struct{} // SHA-256 of the passwords, hex encoded
+
+	// Whether commands are allowed before the rules are applied, and the
+	// +@category/-@category rules since the last +@all or -@all. A later
+	// rule overrides an earlier one for the commands of both categories.
+	allowAll bool
+	rules    []categoryRule
+}
+
+// newUser creates a user the way ACL SETUSER does: disabled, without
+// passwords and denied every command
+func newUser(name string) *User {
+	return &User{name: name, passwords: make(map[string]struct{})}
+}
+
+// clone returns a copy of the user that rules can be applied to
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = make(map[string]struct{}, len(u.passwords))
+	for hash := range u.passwords {
+		c.passwords[hash] = struct{}{}
+	}
+	c.rules = append([]categoryRule(nil), u.rules...)
+	return &c
+}
+
+// hashPassword returns the hex encoded SHA-256 of a password
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+// apply applies one ACL SETUSER rule to the user
+func (u *User) apply(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.noPass = true
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "resetpass":
+		u.noPass = false
+		u.passwords = make(map[string]struct{})
+		return nil
+	case "allcommands":
+		return u.apply("+@" + AllCategories)
+	case "nocommands":
+		return u.apply("-@" + AllCategories)
+	case "reset":
+		*u = *newUser(u.name)
+		return nil
+	}
+
+	switch {
+	case strings.HasPrefix(rule, ">"):
+		u.noPass = false
+		u.passwords[hashPassword(rule[1:])] = struct{}{}
+	case strings.HasPrefix(rule, "<"):
+		hash := hashPassword(rule[1:])
+		if _, ok := u.passwords[hash]; !ok {
+			return errors.New("no such password")
+		}
+		delete(u.passwords, hash)
+	case strings.HasPrefix(rule, "+@"), strings.HasPrefix(rule, "-@"):
+		category := strings.ToLower(rule[2:])
+		allow := rule[0] == '+'
+		if category == "" {
+			return errors.New("Syntax error")
+		}
+		if category == AllCategories {
+			u.allowAll, u.rules = allow, nil
+			return nil
+		}
+		// The rule replaces any earlier one on the same category
+		rules := u.rules[:0]
+		for _, r := range u.rules {
+			if r.category != category {
+				rules = append(rules, r)
+			}
+		}
+		u.rules = append(rules, categoryRule{category, allow})
+	default:
+		return errors.New("Syntax error")
+	}
+	return nil
+}
+
+// Name returns the name of the user
+func (u *User) Name() string {
+	return u.name
+}
+
+// Enabled reports whether the user may authenticate
+func (u *User) Enabled() bool {
+	return u.enabled
+}
+
+// CheckPassword reports whether password is one of the user's passwords.
+// Any password matches a user set nopass.
+func (u *User) CheckPassword(password string) bool {
+	if u.noPass {
+		return true
+	}
+	_, ok := u.passwords[hashPassword(password)]
+	return ok
+}
+
+// Allows reports whether the user may run a command of the given
+// categories
+func (u *User) Allows(categories []string) bool {
+	allowed := u.allowAll
+	for _, r := range u.rules {
+		for _, category := range categories {
+			if strings.EqualFold(category, r.category) {
+				allowed = r.allow
+				break
+			}
+		}
+	}
+	return allowed
+}
+
+// Flags returns the flags of the user as ACL GETUSER reports them
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.noPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// Passwords returns the hashes of the user's passwords, sorted
+func (u *User) Passwords() []string {
+	hashes := make([]string, 0, len(u.passwords))
+	for hash := range u.passwords {
+		hashes = append(hashes, hash)
+	}
+	sort.Strings(hashes)
+	return hashes
+}
+
+// Commands returns the command rules of the user, e.g. "-@all +@string"
+func (u *User) Commands() string {
+	parts := []string{"-@" + AllCategories}
+	if u.allowAll {
+		parts[0] = "+@" + AllCategories
+	}
+	for _, r := range u.rules {
+		sign := "-"
+		if r.allow {
+			sign = "+"
+		}
+		parts = append(parts, sign+"@"+r.category)
+	}
+	return strings.Join(parts, " ")
+}
+
+// String describes the user with rules that recreate it, as ACL LIST
+// reports it
+func (u *User) String() string {
+	parts := append([]string{"user", u.name}, u.Flags()...)
+	for _, hash := range u.Passwords() {
+		parts = append(parts, "#"+hash)
+	}
+	return strings.Join(append(parts, u.Commands()), " ")
+}
+
+// Users holds the users of the server
+type Users struct {
+	mu    sync.RWMutex
+	users map[string]*User
+}
+
+// NewUsers creates the users of a server, holding the default user only
+func NewUsers() *Users {
+	def := newUser(DefaultUser)
+	def.enabled, def.noPass, def.allowAll = true, true, true
+	return &Users{users: map[string]*User{DefaultUser: def}}
+}
+
+// SetUser applies rules to the named user, creating it if needed. Either
+// every rule applies or, if one is invalid, none does.
+func (s *Users) SetUser(name string, rules ...string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	u, ok := s.users[name]
+	if ok {
+		u = u.clone()
+	} else {
+		u = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := u.apply(rule); err != nil {
+			return fmt.Errorf("Error in ACL SETUSER modifier '%s': %v", rule, err)
+		}
+	}
+	s.users[name] = u
+	return nil
+}
+
+// Get returns the named user
+func (s *Users) Get(name string) (*User, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	u, ok := s.users[name]
+	return u, ok
+}
+
+// List returns every user, sorted by name
+func (s *Users) List() []*User {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	users := make([]*User, 0, len(s.users))
+	for _, u := range s.users {
+		users = append(users, u)
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].name < users[j].name })
+	return users
+}
+
+// Authenticate returns the named user if it is enabled and password is
+// one of its passwords
+func (s *Users) Authenticate(name, password string) (*User, bool) {
+	u, ok := s.Get(name)
+	if !ok || !u.enabled || !u.CheckPassword(password) {
+		return nil, false
+	}
+	return u, true
+}
+
+// AuthRequired reports whether connections must authenticate before
+// running commands, i.e. the default user cannot be used without a
+// password
+func (s *Users) AuthRequired() bool {
+	u, _ := s.Get(DefaultUser)
+	return !u.enabled || !u.noPass
+}
@@ -0,0 +1,88 @@
+package acl
+
+import "testing"
+
+func TestSetUserRules(t *testing.T) {
+	users := NewUsers()
+
+	if err := users.SetUser("alice", "on", ">secret", "+@string", "-@fast"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	alice, ok := users.Get("alice")
+	if !ok {
+		t.Fatal("alice was not created")
+	}
+
+	// A later rule wins for the commands in both categories
+	tests := []struct {
+		categories []string
+		allowed    bool
+	}{
+		{[]string{"string"}, true},
+		{[]string{"string", "fast"}, false},
+		{[]string{"list"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := alice.Allows(tt.categories); got != tt.allowed {
+			t.Errorf("Allows(%v) = %v, want %v", tt.categories, got, tt.allowed)
+		}
+	}
+	if got := alice.Commands(); got != "-@all +@string -@fast" {
+		t.Errorf("Commands() = %q", got)
+	}
+
+	// +@all starts over
+	if err := users.SetUser("alice", "+@all", "-@list"); err != nil {
+		t.Fatalf("SetUser failed: %v", err)
+	}
+	alice, _ = users.Get("alice")
+	if !alice.Allows([]string{"string", "fast"}) || alice.Allows([]string{"list"}) {
+		t.Errorf("after +@all -@list, commands are %q", alice.Commands())
+	}
+
+	if _, ok := users.Authenticate("alice", "secret"); !ok {
+		t.Error("alice should authenticate with her password")
+	}
+	if _, ok := users.Authenticate("alice", "wrong"); ok {
+		t.Error("alice authenticated with a wrong password")
+	}
+	_ = users.SetUser("alice", "off")
+	if _, ok := users.Authenticate("alice", "secret"); ok {
+		t.Error("a disabled user authenticated")
+	}
+}
+
+func TestSetUserIsAtomic(t *testing.T) {
+	users := NewUsers()
+	if err := users.SetUser("bob", "on", "+@list", "bogus"); err == nil {
+		t.Fatal("SetUser accepted an invalid rule")
+	}
+	if _, ok := users.Get("bob"); ok {
+		t.Fatal("a failed SetUser created the user")
+	}
+
+	_ = users.SetUser("bob", "on", ">pw")
+	if err := users.SetUser("bob", "off", "<other"); err == nil {
+		t.Fatal("removing a missing password should fail")
+	}
+	if bob, _ := users.Get("bob"); !bob.Enabled() {
+		t.Fatal("a failed SetUser changed the user")
+	}
+}
+
+func TestDefaultUser(t *testing.T) {
+	users := NewUsers()
+	if users.AuthRequired() {
+		t.Fatal("the default user should not need a password")
+	}
+
+	_ = users.SetUser(DefaultUser, ">pw")
+	if !users.AuthRequired() {
+		t.Fatal("a password on the default user should require AUTH")
+	}
+	def, _ := users.Get(DefaultUser)
+	if got := def.String(); got != "user default on #"+hashPassword("pw")+" +@all" {
+		t.Fatalf("default user is %q", got)
+	}
+}
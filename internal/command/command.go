@@ -6,6 +6,10 @@ package command
 
 import (
 	"fmt"
+	"math"
+	"math/big"
+	"sync"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/database"
 	"github.com/zyhnesmr/godis/internal/net"
@@ -18,6 +22,68 @@ type Context struct {
 	Conn    *net.Conn
 	CmdName string
 	Args    []string
+
+	// Commands logged to the AOF in place of this one, once rewritten is set
+	propagated [][]string
+	rewritten  bool
+
+	// Write barrier the command holds, released while it blocks
+	barrier *sync.RWMutex
+
+	// Set for the commands of a transaction, which don't block
+	inExec bool
+
+	// Time spent blocked in Wait, left out of the command's run time
+	blocked time.Duration
+}
+
+// InExec reports whether the command runs as part of a transaction
+func (c *Context) InExec() bool {
+	return c.inExec
+}
+
+// Wait runs wait, which blocks the command until it can go on, and reports
+// whether it did. The commands of a transaction don't block: Wait returns
+// false for them without calling wait. A write command lets snapshots go
+// ahead while it waits, and the wait doesn't count towards the command's
+// time in the slow log and command statistics.
+func (c *Context) Wait(wait func()) bool {
+	if c.inExec {
+		return false
+	}
+	if c.barrier != nil {
+		c.barrier.RUnlock()
+		defer c.barrier.RLock()
+	}
+	start := time.Now()
+	wait()
+	c.blocked += time.Since(start)
+	return true
+}
+
+// Propagate logs cmdName with args to the AOF, and sends it to replicas,
+// in place of the executed command. Handlers whose effect depends on the
+// clock or on randomness use it to log a deterministic equivalent, e.g.
+// SPOP logs an SREM of the popped members and SETEX a SET and PEXPIREAT;
+// each call logs one more command.
+func (c *Context) Propagate(cmdName string, args ...string) {
+	c.rewritten = true
+	c.propagated = append(c.propagated, append([]string{cmdName}, args...))
+}
+
+// PropagateNothing keeps the executed command out of the AOF, for handlers
+// that turned out not to change anything
+func (c *Context) PropagateNothing() {
+	c.rewritten = true
+}
+
+// Propagation returns the commands to log for the executed one, each as
+// its name followed by its arguments
+func (c *Context) Propagation() [][]string {
+	if !c.rewritten {
+		return [][]string{append([]string{c.CmdName}, c.Args...)}
+	}
+	return c.propagated
 }
 
 // Handler is the command handler function
@@ -93,6 +159,11 @@ const (
 	ReplyTypeBulkString
 	ReplyTypeArray
 	ReplyTypeNil
+	ReplyTypeDouble
+	ReplyTypeBigNumber
+	ReplyTypeNone     // Nothing is sent: the handler wrote to the connection itself
+	ReplyTypeMulti    // Several replies sent one after the other
+	ReplyTypeNilArray // Null array, e.g. an aborted EXEC
 )
 
 // NewStatusReply creates a status reply
@@ -166,6 +237,58 @@ func NewNilReply() *Reply {
 	}
 }
 
+// NewNilArrayReply creates a null array reply. It is sent as *-1 to RESP2
+// clients and as a null to RESP3 clients.
+func NewNilArrayReply() *Reply {
+	return &Reply{
+		Type: ReplyTypeNilArray,
+	}
+}
+
+// NewNoReply creates a reply that sends nothing, for handlers that write
+// their response to the connection themselves
+func NewNoReply() *Reply {
+	return &Reply{
+		Type: ReplyTypeNone,
+	}
+}
+
+// NewMultiReply creates a reply made of several replies sent one after the
+// other, like the confirmation SUBSCRIBE sends for each channel
+func NewMultiReply(replies []*Reply) *Reply {
+	return &Reply{
+		Type:  ReplyTypeMulti,
+		Value: replies,
+	}
+}
+
+// NewDoubleReply creates a double reply. It is sent as a bulk string to
+// RESP2 clients and as a double to RESP3 clients.
+func NewDoubleReply(f float64) *Reply {
+	return &Reply{
+		Type:  ReplyTypeDouble,
+		Value: f,
+	}
+}
+
+// NewBigNumberReply creates a big number reply. It is sent as a bulk string
+// to RESP2 clients and as a big number to RESP3 clients.
+func NewBigNumberReply(n *big.Int) *Reply {
+	return &Reply{
+		Type:  ReplyTypeBigNumber,
+		Value: n,
+	}
+}
+
+// NewUnsignedReply creates an integer reply, or a big number reply if n
+// does not fit in an int64
+func NewUnsignedReply(n uint64) *Reply {
+	if n > math.MaxInt64 {
+		return NewBigNumberReply(new(big.Int).SetUint64(n))
+	}
+	return NewIntegerReply(int64(n))
+}
+
 // NewArrayReplyFromAny creates an array reply from interface{} slice
 func NewArrayReplyFromAny(items []interface{}) *Reply {
 	return &Reply{
@@ -176,7 +299,7 @@ func NewArrayReplyFromAny(items []interface{}) *Reply {
 
 // IsNil returns true if the reply is nil
 func (r *Reply) IsNil() bool {
-	return r == nil || r.Type == ReplyTypeNil
+	return r == nil || r.Type == ReplyTypeNil || r.Type == ReplyTypeNilArray
 }
 
 // IsError returns true if the reply is an error
@@ -184,10 +307,16 @@ func (r *Reply) IsError() bool {
 	return r != nil && r.Type == ReplyTypeError
 }
 
-// Marshal converts the reply to RESP bytes
+// Marshal converts the reply to RESP2 bytes
 func (r *Reply) Marshal() []byte {
+	return r.MarshalProto(2)
+}
+
+// MarshalProto converts the reply to RESP bytes for the given protocol
+// version. RESP3 clients get the null, double and big number types.
+func (r *Reply) MarshalProto(proto int) []byte {
 	if r == nil {
-		return resp.BuildNil()
+		return buildNil(proto)
 	}
 
 	switch r.Type {
@@ -218,7 +347,7 @@ func (r *Reply) Marshal() []byte {
 			builder := resp.NewResponseBuilder()
 			builder.WriteArray(len(v))
 			for _, item := range v {
-				builder.WriteBytes(item.Marshal())
+				builder.WriteBytes(item.MarshalProto(proto))
 			}
 			return builder.Bytes()
 		case []string:
@@ -232,13 +361,17 @@ func (r *Reply) Marshal() []byte {
 			for _, item := range v {
 				switch val := item.(type) {
 				case nil:
-					builder.WriteBytes(resp.BuildNil())
+					builder.WriteBytes(buildNil(proto))
 				case string:
 					builder.WriteBulkStringFromString(val)
 				case int64:
 					builder.WriteInteger(val)
 				case int:
 					builder.WriteInteger(int64(val))
+				case []interface{}:
+					builder.WriteBytes(NewArrayReplyFromAny(val).MarshalProto(proto))
+				case *Reply:
+					builder.WriteBytes(val.MarshalProto(proto))
 				default:
 					builder.WriteBulkStringFromString(fmt.Sprintf("%v", val))
 				}
@@ -248,12 +381,45 @@ func (r *Reply) Marshal() []byte {
 			return resp.BuildEmptyArray()
 		}
 	case ReplyTypeNil:
-		return resp.BuildNil()
+		return buildNil(proto)
+	case ReplyTypeNilArray:
+		if proto >= 3 {
+			return resp.BuildNull()
+		}
+		return resp.BuildNilArray()
+	case ReplyTypeDouble:
+		f := r.Value.(float64)
+		if proto >= 3 {
+			return resp.BuildDouble(f)
+		}
+		return resp.BuildBulkString(resp.FormatDouble(f))
+	case ReplyTypeBigNumber:
+		digits := r.Value.(*big.Int).String()
+		if proto >= 3 {
+			return resp.BuildBigNumber(digits)
+		}
+		return resp.BuildBulkString(digits)
+	case ReplyTypeNone:
+		return nil
+	case ReplyTypeMulti:
+		var data []byte
+		for _, item := range r.Value.([]*Reply) {
+			data = append(data, item.MarshalProto(proto)...)
+		}
+		return data
 	default:
 		return resp.BuildErrorString("ERR unknown reply type")
 	}
 }
 
+// buildNil returns the null reply for the given protocol version
+func buildNil(proto int) []byte {
+	if proto >= 3 {
+		return resp.BuildNull()
+	}
+	return resp.BuildNil()
+}
+
 // HasFlag checks if the command has a specific flag
 func (c *Command) HasFlag(flag string) bool {
 	for _, f := range c.Flags {
@@ -292,31 +458,28 @@ func (c *Command) CheckArity(argc int) error {
 	return nil
 }
 
-// GetKeys extracts the keys from the command arguments
-func (c *Command) GetKeys(args []string) []string {
-	if c.FirstKey < 0 || c.LastKey < 0 {
+// GetKeys extracts the keys from argv, the command name followed by its
+// arguments. A negative LastKey counts from the end of argv.
+func (c *Command) GetKeys(argv []string) []string {
+	if c.FirstKey <= 0 {
 		return nil
 	}
 
-	if c.StepCount <= 0 {
-		start := c.FirstKey
-		end := c.LastKey
-		if end >= len(args) {
-			end = len(args) - 1
-		}
-		if start >= len(args) {
-			return nil
-		}
-		if end < start {
-			return nil
-		}
-		return args[start : end+1]
+	last := c.LastKey
+	if last < 0 {
+		last += len(argv)
+	}
+	if last >= len(argv) {
+		last = len(argv) - 1
+	}
+	step := c.StepCount
+	if step <= 0 {
+		step = 1
 	}
 
-	// For commands with step count (like MSET)
-	keys := []string{}
-	for i := c.FirstKey; i < len(args) && i <= c.LastKey; i += c.StepCount {
-		keys = append(keys, args[i])
+	var keys []string
+	for i := c.FirstKey; i <= last; i += step {
+		keys = append(keys, argv[i])
 	}
 	return keys
 }
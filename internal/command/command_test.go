@@ -0,0 +1,49 @@
+package command
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestUnsignedReplyBigNumber(t *testing.T) {
+	tests := []struct {
+		name  string
+		n     uint64
+		proto int
+		want  string
+	}{
+		{"fits int64 under RESP2", 42, 2, ":42\r\n"},
+		{"fits int64 under RESP3", math.MaxInt64, 3, ":9223372036854775807\r\n"},
+		{"exceeds int64 under RESP2", math.MaxUint64, 2, "$20\r\n18446744073709551615\r\n"},
+		{"exceeds int64 under RESP3", math.MaxUint64, 3, "(18446744073709551615\r\n"},
+		{"just past int64 under RESP3", math.MaxInt64 + 1, 3, "(9223372036854775808\r\n"},
+	}
+
+	for _, tt := range tests {
+		if got := string(NewUnsignedReply(tt.n).MarshalProto(tt.proto)); got != tt.want {
+			t.Errorf("%s: expected %q, got %q", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestGetKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		cmd  Command
+		argv []string
+		want []string
+	}{
+		{"single key", Command{FirstKey: 1, LastKey: 1}, []string{"GET", "a"}, []string{"a"}},
+		{"all remaining", Command{FirstKey: 1, LastKey: -1}, []string{"DEL", "a", "b", "c"}, []string{"a", "b", "c"}},
+		{"stepped", Command{FirstKey: 1, LastKey: -1, StepCount: 2}, []string{"MSET", "a", "1", "b", "2"}, []string{"a", "b"}},
+		{"second argument", Command{FirstKey: 2, LastKey: 2}, []string{"OBJECT", "ENCODING", "a"}, []string{"a"}},
+		{"no keys", Command{}, []string{"PING"}, nil},
+	}
+
+	for _, tt := range tests {
+		if got := tt.cmd.GetKeys(tt.argv); strings.Join(got, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/zyhnesmr/godis/internal/acl"
+	"github.com/zyhnesmr/godis/internal/command"
+)
+
+// aclUsers are the users AUTH and ACL work with
+var aclUsers *acl.Users
+
+// SetACL sets the users AUTH and ACL work with
+func SetACL(users *acl.Users) {
+	aclUsers = users
+}
+
+// aclCategories are the categories ACL SETUSER rules may name
+var aclCategories = map[string]bool{
+	acl.AllCategories:      true,
+	command.CatString:      true,
+	command.CatList:        true,
+	command.CatSet:         true,
+	command.CatHash:        true,
+	command.CatZSet:        true,
+	command.CatStream:      true,
+	command.CatPubSub:      true,
+	command.CatTransaction: true,
+	command.CatConnection:  true,
+	command.CatServer:      true,
+	command.CatKey:         true,
+	command.CatGeneric:     true,
+	command.CatHyperLogLog: true,
+	command.CatGeo:         true,
+	command.CatPersistence: true,
+	command.CatFast:        true,
+	command.CatKeySpace:    true,
+	command.CatScript:      true,
+}
+
+// RegisterACLCommands registers the ACL command
+func RegisterACLCommands(disp Dispatcher) {
+	disp.Register(&command.Command{
+		Name:       "ACL",
+		Handler:    aclCmd,
+		Arity:      -2,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript, command.FlagLoading, command.FlagStale},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+}
+
+// ACL WHOAMI / ACL SETUSER username [rule ...] / ACL GETUSER username / ACL LIST
+func aclCmd(ctx *command.Context) (*command.Reply, error) {
+	if aclUsers == nil {
+		return command.NewErrorReplyStr("ERR ACL not initialized"), nil
+	}
+
+	subcmd := strings.ToUpper(ctx.Args[0])
+	switch subcmd {
+	case "WHOAMI":
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'ACL|WHOAMI' command"), nil
+		}
+		name := ctx.Conn.GetUser()
+		if name == "" {
+			name = acl.DefaultUser
+		}
+		return command.NewBulkStringReply(name), nil
+
+	case "SETUSER":
+		if len(ctx.Args) < 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'ACL|SETUSER' command"), nil
+		}
+		rules := ctx.Args[2:]
+		for _, rule := range rules {
+			if (strings.HasPrefix(rule, "+@") || strings.HasPrefix(rule, "-@")) && !aclCategories[strings.ToLower(rule[2:])] {
+				return command.NewErrorReplyStr(fmt.Sprintf("ERR Error in ACL SETUSER modifier '%s': Unknown command or category name in ACL", rule)), nil
+			}
+		}
+		if err := aclUsers.SetUser(ctx.Args[1], rules...); err != nil {
+			return command.NewErrorReplyStr("ERR " + err.Error()), nil
+		}
+		return command.NewStatusReply("OK"), nil
+
+	case "GETUSER":
+		if len(ctx.Args) != 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'ACL|GETUSER' command"), nil
+		}
+		user, ok := aclUsers.Get(ctx.Args[1])
+		if !ok {
+			return command.NewNilReply(), nil
+		}
+		return command.NewArrayReply([]*command.Reply{
+			command.NewBulkStringReply("flags"), command.NewStringArrayReply(user.Flags()),
+			command.NewBulkStringReply("passwords"), command.NewStringArrayReply(user.Passwords()),
+			command.NewBulkStringReply("commands"), command.NewBulkStringReply(user.Commands()),
+		}), nil
+
+	case "LIST":
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'ACL|LIST' command"), nil
+		}
+		users := aclUsers.List()
+		lines := make([]string, len(users))
+		for i, user := range users {
+			lines[i] = user.String()
+		}
+		return command.NewStringArrayReply(lines), nil
+
+	default:
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown subcommand '%s'. Try ACL HELP.", ctx.Args[0])), nil
+	}
+}
@@ -0,0 +1,107 @@
+package commands
+
+import (
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/acl"
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+// setupACL returns a dispatcher checking the permissions of fresh ACL
+// users, with the string, list, server and ACL commands
+func setupACL(t *testing.T) (*command.Dispatcher, *database.DB) {
+	t.Helper()
+
+	selector := database.NewDBSelector(1)
+	disp := command.NewDispatcher(selector)
+	RegisterStringCommands(disp)
+	RegisterListCommands(disp)
+	RegisterServerCommands(disp)
+	RegisterACLCommands(disp)
+
+	users := acl.NewUsers()
+	SetACL(users)
+	disp.SetACL(users)
+	t.Cleanup(func() { SetACL(nil) })
+
+	db, _ := selector.GetDB(0)
+	return disp, db
+}
+
+func TestACLDeniesCommandOutsideCategories(t *testing.T) {
+	disp, db := setupACL(t)
+	admin := newTestContext(t, db).Conn
+	client := newTestContext(t, db).Conn
+
+	if got := dispatch(t, disp, admin, "ACL", "SETUSER", "alice", "on", ">secret", "-@all", "+@string"); got != "+OK\r\n" {
+		t.Fatalf("ACL SETUSER returned %q", got)
+	}
+	if got := dispatch(t, disp, client, "AUTH", "alice", "wrong"); got != "-WRONGPASS invalid username-password pair or user is disabled.\r\n" {
+		t.Fatalf("AUTH with a wrong password returned %q", got)
+	}
+	if got := dispatch(t, disp, client, "AUTH", "alice", "secret"); got != "+OK\r\n" {
+		t.Fatalf("AUTH returned %q", got)
+	}
+
+	if got := dispatch(t, disp, client, "SET", "k", "v"); got != "+OK\r\n" {
+		t.Fatalf("SET in an allowed category returned %q", got)
+	}
+	if got := dispatch(t, disp, client, "LPUSH", "l", "v"); got != "-NOPERM User alice has no permissions to run the 'lpush' command\r\n" {
+		t.Fatalf("LPUSH outside alice's categories returned %q", got)
+	}
+	if _, ok := db.Get("l"); ok {
+		t.Fatal("denied LPUSH created the list")
+	}
+
+	// Removing a category takes effect on the next command
+	dispatch(t, disp, admin, "ACL", "SETUSER", "alice", "-@string")
+	if got := dispatch(t, disp, client, "GET", "k"); got != "-NOPERM User alice has no permissions to run the 'get' command\r\n" {
+		t.Fatalf("GET after -@string returned %q", got)
+	}
+}
+
+func TestACLRequiresAuthForDefaultPassword(t *testing.T) {
+	disp, db := setupACL(t)
+	admin := newTestContext(t, db).Conn
+	client := newTestContext(t, db).Conn
+
+	if got := dispatch(t, disp, client, "AUTH", "pw"); got[0] != '-' {
+		t.Fatalf("AUTH without a default password returned %q", got)
+	}
+	dispatch(t, disp, admin, "ACL", "SETUSER", "default", ">pw")
+
+	if got := dispatch(t, disp, client, "GET", "k"); got != "-NOAUTH Authentication required.\r\n" {
+		t.Fatalf("GET before AUTH returned %q", got)
+	}
+	if got := dispatch(t, disp, client, "AUTH", "pw"); got != "+OK\r\n" {
+		t.Fatalf("AUTH returned %q", got)
+	}
+	if got := dispatch(t, disp, client, "ACL", "WHOAMI"); got != "$7\r\ndefault\r\n" {
+		t.Fatalf("ACL WHOAMI returned %q", got)
+	}
+	if got := dispatch(t, disp, client, "GET", "k"); got != "$-1\r\n" {
+		t.Fatalf("GET after AUTH returned %q", got)
+	}
+}
+
+func TestACLSetUserRejectsUnknownCategory(t *testing.T) {
+	disp, db := setupACL(t)
+	conn := newTestContext(t, db).Conn
+
+	if got := dispatch(t, disp, conn, "ACL", "SETUSER", "bob", "+@nosuch"); got != "-ERR Error in ACL SETUSER modifier '+@nosuch': Unknown command or category name in ACL\r\n" {
+		t.Fatalf("ACL SETUSER returned %q", got)
+	}
+	if got := dispatch(t, disp, conn, "ACL", "GETUSER", "bob"); got != "$-1\r\n" {
+		t.Fatalf("ACL GETUSER of a user never created returned %q", got)
+	}
+
+	dispatch(t, disp, conn, "ACL", "SETUSER", "bob", "on", "nopass", "+@list")
+	want := "*6\r\n$5\r\nflags\r\n*2\r\n$2\r\non\r\n$6\r\nnopass\r\n$9\r\npasswords\r\n*0\r\n$8\r\ncommands\r\n$12\r\n-@all +@list\r\n"
+	if got := dispatch(t, disp, conn, "ACL", "GETUSER", "bob"); got != want {
+		t.Fatalf("ACL GETUSER returned %q", got)
+	}
+	if got := dispatch(t, disp, conn, "ACL", "LIST"); got != "*2\r\n$31\r\nuser bob on nopass -@all +@list\r\n$28\r\nuser default on nopass +@all\r\n" {
+		t.Fatalf("ACL LIST returned %q", got)
+	}
+}
@@ -146,7 +146,7 @@ func getbitCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewIntegerReply(int64(bitValue)), nil
 }
 
-// BITCOUNT key [start end]
+// BITCOUNT key [start end [BYTE|BIT]]
 func bitcountCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 1 {
 		return nil, errors.New("wrong number of arguments")
@@ -154,42 +154,33 @@ func bitcountCmd(ctx *command.Context) (*command.Reply, error) {
 
 	key := ctx.Args[0]
 
-	obj, ok := ctx.DB.Get(key)
-	if !ok {
-		return command.NewIntegerReply(0), nil
-	}
-
-	currentStr := obj.String()
-	str := strpkg.NewString(currentStr)
-
 	// Default: count entire string
-	start := 0
-	end := len(currentStr) - 1
-
-	// Parse start and end if provided
-	if len(ctx.Args) >= 2 {
-		s, err := strconv.Atoi(ctx.Args[1])
+	start, end, bitUnit := 0, -1, false
+	switch len(ctx.Args) {
+	case 1:
+	case 3, 4:
+		var err error
+		start, end, bitUnit, err = parseBitRange(ctx.Args[1:])
 		if err != nil {
-			return nil, errors.New("start is not an integer or out of range")
+			return nil, err
 		}
-		start = s
+	default:
+		return nil, errors.New("syntax error")
 	}
 
-	if len(ctx.Args) >= 3 {
-		e, err := strconv.Atoi(ctx.Args[2])
-		if err != nil {
-			return nil, errors.New("end is not an integer or out of range")
-		}
-		end = e
+	obj, ok := ctx.DB.Get(key)
+	if !ok {
+		return command.NewIntegerReply(0), nil
 	}
 
-	count := str.BitCount(start, end)
+	str := strpkg.NewString(obj.String())
+	count := str.BitCount(start, end, bitUnit)
 	return command.NewIntegerReply(int64(count)), nil
 }
 
-// BITPOS key bit [start end]
+// BITPOS key bit [start [end [BYTE|BIT]]]
 func bitposCmd(ctx *command.Context) (*command.Reply, error) {
-	if len(ctx.Args) < 2 {
+	if len(ctx.Args) < 2 || len(ctx.Args) > 5 {
 		return nil, errors.New("wrong number of arguments")
 	}
 
@@ -199,96 +190,63 @@ func bitposCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("bit is not an integer or out of range")
 	}
 
-	obj, ok := ctx.DB.Get(key)
-	if !ok {
-		// Empty string: bit 0 is at position 0, bit 1 is at -1
-		if bit == 0 {
-			return command.NewIntegerReply(0), nil
+	// Parse start and end
+	start, end, bitUnit := 0, -1, false
+	endGiven := len(ctx.Args) >= 4
+	if len(ctx.Args) == 3 {
+		start, err = strconv.Atoi(ctx.Args[2])
+		if err != nil {
+			return nil, errors.New("start is not an integer or out of range")
+		}
+	} else if endGiven {
+		start, end, bitUnit, err = parseBitRange(ctx.Args[2:])
+		if err != nil {
+			return nil, err
 		}
-		return command.NewIntegerReply(-1), nil
 	}
 
-	currentStr := obj.String()
-	if len(currentStr) == 0 {
+	obj, ok := ctx.DB.Get(key)
+	if !ok || len(obj.String()) == 0 {
+		// Empty string: bit 0 is at position 0, bit 1 is at -1
 		if bit == 0 {
 			return command.NewIntegerReply(0), nil
 		}
 		return command.NewIntegerReply(-1), nil
 	}
 
-	// Parse start and end
-	start := 0
-	end := len(currentStr) - 1
-
-	if len(ctx.Args) >= 3 {
-		s, err := strconv.Atoi(ctx.Args[2])
-		if err != nil {
-			return nil, errors.New("start is not an integer or out of range")
-		}
-		start = s
-	}
+	currentStr := obj.String()
+	pos := strpkg.NewString(currentStr).BitPos(byte(bit), start, end, bitUnit)
 
-	if len(ctx.Args) >= 4 {
-		e, err := strconv.Atoi(ctx.Args[3])
-		if err != nil {
-			return nil, errors.New("end is not an integer or out of range")
-		}
-		end = e
+	// Without an explicit end the string is treated as padded with zeros,
+	// so a clear bit is found right after it (unless start is past the end)
+	if pos == -1 && bit == 0 && !endGiven && start < len(currentStr) {
+		pos = len(currentStr) * 8
 	}
 
-	// Normalize indices
-	runes := []rune(currentStr)
-	length := len(runes)
-
-	if start < 0 {
-		start = length + start
-		if start < 0 {
-			start = 0
-		}
-	}
-	if end < 0 {
-		end = length + end
-		if end < 0 {
-			end = 0
-		}
-	}
+	return command.NewIntegerReply(int64(pos)), nil
+}
 
-	if start >= length {
-		start = length
-	}
-	if end >= length {
-		end = length - 1
-	}
-	if start > end {
-		return command.NewIntegerReply(-1), nil
+// parseBitRange parses "start end [BYTE|BIT]" for BITCOUNT and BITPOS
+func parseBitRange(args []string) (start, end int, bitUnit bool, err error) {
+	start, err = strconv.Atoi(args[0])
+	if err != nil {
+		return 0, 0, false, errors.New("start is not an integer or out of range")
 	}
-
-	// Search for the bit
-	for byteIdx := start; byteIdx <= end; byteIdx++ {
-		b := currentStr[byteIdx]
-		for bitIdx := 0; bitIdx < 8; bitIdx++ {
-			currentBit := (b >> (7 - bitIdx)) & 1
-			if currentBit == byte(bit) {
-				return command.NewIntegerReply(int64(byteIdx*8 + bitIdx)), nil
-			}
-		}
+	end, err = strconv.Atoi(args[1])
+	if err != nil {
+		return 0, 0, false, errors.New("end is not an integer or out of range")
 	}
 
-	// Bit not found in range, check if we should return the next position
-	if bit == 0 && end < length-1 {
-		// Return first 0 bit after the range
-		for byteIdx := end + 1; byteIdx < length; byteIdx++ {
-			b := currentStr[byteIdx]
-			for bitIdx := 0; bitIdx < 8; bitIdx++ {
-				currentBit := (b >> (7 - bitIdx)) & 1
-				if currentBit == 0 {
-					return command.NewIntegerReply(int64(byteIdx*8 + bitIdx)), nil
-				}
-			}
+	if len(args) == 3 {
+		switch strings.ToUpper(args[2]) {
+		case "BYTE":
+		case "BIT":
+			bitUnit = true
+		default:
+			return 0, 0, false, errors.New("syntax error")
 		}
 	}
-
-	return command.NewIntegerReply(-1), nil
+	return start, end, bitUnit, nil
 }
 
 // BITOP operation destkey key [key ...]
@@ -409,7 +367,7 @@ func bitfieldCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	results := make([]interface{}, 0)
-	overflow := "FAIL"
+	overflow := "WRAP"
 
 	for i := 0; i < len(args); i++ {
 		subcommand := strings.ToUpper(args[i])
@@ -420,7 +378,11 @@ func bitfieldCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("GET requires encoding and offset")
 			}
 			encoding := args[i+1]
-			offset, err := parseBitfieldOffset(args[i+2])
+			_, bits, err := parseBitfieldEncoding(encoding)
+			if err != nil {
+				return nil, err
+			}
+			offset, err := parseBitfieldOffset(args[i+2], bits)
 			if err != nil {
 				return nil, err
 			}
@@ -434,7 +396,11 @@ func bitfieldCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("SET requires encoding, offset, and value")
 			}
 			encoding := args[i+1]
-			offset, err := parseBitfieldOffset(args[i+2])
+			signed, bits, err := parseBitfieldEncoding(encoding)
+			if err != nil {
+				return nil, err
+			}
+			offset, err := parseBitfieldOffset(args[i+2], bits)
 			if err != nil {
 				return nil, err
 			}
@@ -444,6 +410,13 @@ func bitfieldCmd(ctx *command.Context) (*command.Reply, error) {
 			}
 			i += 3
 
+			// Out of range values follow the OVERFLOW mode like INCRBY
+			value, err = bitfieldOverflow(value, signed, bits, overflow)
+			if err == errBitfieldOverflow {
+				results = append(results, nil)
+				continue
+			}
+
 			oldValue, newStr, err := setBitfield(currentStr, encoding, offset, value)
 			if err != nil {
 				return nil, err
@@ -456,7 +429,11 @@ func bitfieldCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("INCRBY requires encoding, offset, and increment")
 			}
 			encoding := args[i+1]
-			offset, err := parseBitfieldOffset(args[i+2])
+			_, bits, err := parseBitfieldEncoding(encoding)
+			if err != nil {
+				return nil, err
+			}
+			offset, err := parseBitfieldOffset(args[i+2], bits)
 			if err != nil {
 				return nil, err
 			}
@@ -468,7 +445,7 @@ func bitfieldCmd(ctx *command.Context) (*command.Reply, error) {
 
 			newValue, newStr, err := incrbyBitfield(currentStr, encoding, offset, increment, overflow)
 			if err != nil {
-				if err.Error() == "overflow" {
+				if err == errBitfieldOverflow {
 					results = append(results, nil)
 				} else {
 					return nil, err
@@ -528,7 +505,11 @@ func bitfieldRoCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("GET requires encoding and offset")
 			}
 			encoding := args[i+1]
-			offset, err := parseBitfieldOffset(args[i+2])
+			_, bits, err := parseBitfieldEncoding(encoding)
+			if err != nil {
+				return nil, err
+			}
+			offset, err := parseBitfieldOffset(args[i+2], bits)
 			if err != nil {
 				return nil, err
 			}
@@ -544,14 +525,15 @@ func bitfieldRoCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewArrayReplyFromAny(results), nil
 }
 
-// parseBitfieldOffset parses a bitfield offset which can be like "#1" or just a number
-func parseBitfieldOffset(s string) (int, error) {
+// parseBitfieldOffset parses a bitfield offset which can be like "#1" or just a
+// number. A "#N" offset is N times the encoding width in bits.
+func parseBitfieldOffset(s string, bits int) (int, error) {
 	if strings.HasPrefix(s, "#") {
 		offset, err := strconv.Atoi(s[1:])
 		if err != nil {
 			return 0, errors.New("offset is not an integer")
 		}
-		return offset * 4, nil // Multiplied by 4 bytes (32 bits)
+		return offset * bits, nil
 	}
 	offset, err := strconv.Atoi(s)
 	if err != nil {
@@ -571,87 +553,104 @@ func parseBitfieldEncoding(encoding string) (bool, int, error) {
 		return false, 0, errors.New("invalid encoding")
 	}
 
+	// u64 would not fit the int64 replies
 	bits, err := strconv.Atoi(encoding[1:])
-	if err != nil || bits < 1 || bits > 64 {
+	if err != nil || bits < 1 || bits > 64 || (!signed && bits > 63) {
 		return false, 0, errors.New("invalid encoding")
 	}
 
 	return signed, bits, nil
 }
 
-// getBitfield gets a bitfield value
+// getBitfield gets a bitfield value. Bits are read most significant first,
+// starting at bit offset; bits past the end of the string read as zero.
 func getBitfield(s string, encoding string, offset int) (int64, error) {
 	signed, bits, err := parseBitfieldEncoding(encoding)
 	if err != nil {
 		return 0, err
 	}
 
-	byteLen := (bits + 7) / 8
-	bitOffset := offset % 8
-	byteOffset := offset / 8
-
-	// Get the bytes containing our field
-	value := int64(0)
-	for i := 0; i < byteLen; i++ {
-		byteIdx := byteOffset + i
-		if byteIdx >= len(s) {
-			break
+	var value uint64
+	for i := 0; i < bits; i++ {
+		pos := offset + i
+		var bit uint64
+		if pos/8 < len(s) {
+			bit = uint64(s[pos/8]>>(7-pos%8)) & 1
 		}
-		b := byte(s[byteIdx])
-		value |= int64(b) << (8 * (byteLen - 1 - i))
+		value = value<<1 | bit
 	}
 
-	// Shift to align with our bit offset
-	value >>= (8 - bitOffset - bits%8) % 8
-	if bits%8 != 0 {
-		value >>= 8 - bits%8
+	// Sign extend negative values
+	if signed && bits < 64 && value&(1<<(bits-1)) != 0 {
+		value |= ^uint64(0) << bits
 	}
 
-	// Mask to get only our bits
-	mask := int64(1)<<bits - 1
-	value &= mask
-
-	// Handle signed values
-	if signed && (value&(1<<(bits-1))) != 0 {
-		value |= ^mask
-	}
-
-	return value, nil
+	return int64(value), nil
 }
 
-// setBitfield sets a bitfield value
+// setBitfield sets a bitfield value, growing the string as needed. Only the
+// low bits of newValue that fit the encoding are stored.
 func setBitfield(s string, encoding string, offset int, newValue int64) (int64, string, error) {
 	_, bits, err := parseBitfieldEncoding(encoding)
 	if err != nil {
 		return 0, "", err
 	}
 
+	// Get old value
+	oldValue, _ := getBitfield(s, encoding, offset)
+
 	// Ensure string is long enough
-	byteLen := (bits + 7) / 8
-	byteOffset := offset / 8
-	requiredLen := byteOffset + byteLen
-	for len(s) < requiredLen {
-		s += "\x00"
+	bytes := []byte(s)
+	if requiredLen := (offset + bits + 7) / 8; len(bytes) < requiredLen {
+		bytes = append(bytes, make([]byte, requiredLen-len(bytes))...)
 	}
 
-	// Get old value
-	oldValue, _ := getBitfield(s, encoding, offset)
+	for i := 0; i < bits; i++ {
+		pos := offset + i
+		mask := byte(1) << (7 - pos%8)
+		if uint64(newValue)>>(bits-1-i)&1 == 1 {
+			bytes[pos/8] |= mask
+		} else {
+			bytes[pos/8] &^= mask
+		}
+	}
 
-	// Set new value (simplified implementation)
-	mask := int64(1)<<bits - 1
-	newValue &= mask
+	return oldValue, string(bytes), nil
+}
 
-	// Convert to bytes and set
-	bytes := []byte(s)
-	for i := 0; i < byteLen; i++ {
-		byteIdx := byteOffset + i
-		shift := (byteLen - 1 - i) * 8
-		bytes[byteIdx] = byte(newValue >> shift)
+// errBitfieldOverflow reports a value out of range under OVERFLOW FAIL
+var errBitfieldOverflow = errors.New("overflow")
+
+// bitfieldOverflow fits value into a field of the given signedness and
+// width: WRAP keeps its low bits, SAT clamps it to the nearest bound and
+// FAIL returns errBitfieldOverflow
+func bitfieldOverflow(value int64, signed bool, bits int, overflow string) (int64, error) {
+	maxValue := int64(1)<<bits - 1
+	minValue := int64(0)
+	if signed {
+		maxValue = int64(1<<(bits-1)) - 1
+		minValue = -int64(1 << (bits - 1))
+	}
+	if value >= minValue && value <= maxValue {
+		return value, nil
 	}
 
-	s = string(bytes)
+	switch overflow {
+	case "SAT":
+		if value > maxValue {
+			return maxValue, nil
+		}
+		return minValue, nil
+	case "FAIL":
+		return 0, errBitfieldOverflow
+	}
 
-	return oldValue, s, nil
+	// WRAP: keep the low bits, sign extending signed fields
+	wrapped := uint64(value) & (1<<bits - 1)
+	if signed && wrapped&(1<<(bits-1)) != 0 {
+		wrapped |= ^uint64(0) << bits
+	}
+	return int64(wrapped), nil
 }
 
 // incrbyBitfield increments a bitfield value
@@ -664,34 +663,9 @@ func incrbyBitfield(s string, encoding string, offset int, increment int64, over
 	// Get current value
 	currentValue, _ := getBitfield(s, encoding, offset)
 
-	newValue := currentValue + increment
-
-	// Check for overflow
-	maxValue := int64(1)<<bits - 1
-	minValue := int64(0)
-	if signed {
-		maxValue = int64(1<<(bits-1)) - 1
-		minValue = -int64(1 << (bits - 1))
-	}
-
-	// Handle overflow based on overflow type
-	if newValue > maxValue || newValue < minValue {
-		switch overflow {
-		case "FAIL":
-			return 0, "", errors.New("overflow")
-		case "WRAP":
-			if newValue > maxValue {
-				newValue -= (maxValue - minValue + 1)
-			} else if newValue < minValue {
-				newValue += (maxValue - minValue + 1)
-			}
-		case "SAT":
-			if newValue > maxValue {
-				newValue = maxValue
-			} else if newValue < minValue {
-				newValue = minValue
-			}
-		}
+	newValue, err := bitfieldOverflow(currentValue+increment, signed, bits, overflow)
+	if err != nil {
+		return 0, "", err
 	}
 
 	// Set the new value
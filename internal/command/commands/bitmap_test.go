@@ -0,0 +1,221 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+func TestBitcountUnits(t *testing.T) {
+	db := database.NewDB(0)
+	// 11110000 00001111 10101010
+	db.Set("k", database.NewStringObject("\xf0\x0f\xaa"))
+
+	tests := []struct {
+		args []string
+		want int64
+	}{
+		{[]string{"k"}, 12},
+		{[]string{"k", "0", "0", "BYTE"}, 4},
+		{[]string{"k", "0", "0", "BIT"}, 1},
+		{[]string{"k", "0", "0"}, 4},
+		{[]string{"k", "4", "11", "BIT"}, 0},
+		{[]string{"k", "5", "13", "BIT"}, 2},
+		{[]string{"k", "-8", "-1", "BIT"}, 4},
+		{[]string{"k", "-2", "-1", "bit"}, 1},
+		{[]string{"k", "1", "2", "BYTE"}, 8},
+		{[]string{"k", "30", "40", "BIT"}, 0},
+	}
+	for _, tt := range tests {
+		reply, err := bitcountCmd(newTestContext(t, db, tt.args...))
+		if err != nil {
+			t.Fatalf("BITCOUNT %v failed: %v", tt.args, err)
+		}
+		if reply.Value != tt.want {
+			t.Errorf("BITCOUNT %v expected %d, got %v", tt.args, tt.want, reply.Value)
+		}
+	}
+
+	if _, err := bitcountCmd(newTestContext(t, db, "k", "0", "0", "WORD")); err == nil {
+		t.Error("BITCOUNT with an unknown unit expected error")
+	}
+	if _, err := bitcountCmd(newTestContext(t, db, "k", "0")); err == nil {
+		t.Error("BITCOUNT with start but no end expected error")
+	}
+}
+
+func TestBitposUnits(t *testing.T) {
+	db := database.NewDB(0)
+	// 11110000 00001111 11111111
+	db.Set("k", database.NewStringObject("\xf0\x0f\xff"))
+
+	tests := []struct {
+		args []string
+		want int64
+	}{
+		{[]string{"k", "1"}, 0},
+		{[]string{"k", "0"}, 4},
+		{[]string{"k", "1", "1"}, 12},
+		{[]string{"k", "1", "1", "1", "BYTE"}, 12},
+		{[]string{"k", "1", "5", "13", "BIT"}, 12},
+		{[]string{"k", "0", "2", "3", "BIT"}, -1},
+		{[]string{"k", "0", "16", "23", "BIT"}, -1},
+		{[]string{"k", "0", "2"}, 24}, // No end: zero padding after the string
+		{[]string{"k", "0", "2", "-1"}, -1},
+		{[]string{"k", "0", "5"}, -1},
+	}
+	for _, tt := range tests {
+		reply, err := bitposCmd(newTestContext(t, db, tt.args...))
+		if err != nil {
+			t.Fatalf("BITPOS %v failed: %v", tt.args, err)
+		}
+		if reply.Value != tt.want {
+			t.Errorf("BITPOS %v expected %d, got %v", tt.args, tt.want, reply.Value)
+		}
+	}
+}
+
+func TestBitposClearBitPadding(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("ones", database.NewStringObject("\xff\xff\xff"))
+	// 11111111 11110000
+	db.Set("k", database.NewStringObject("\xff\xf0"))
+
+	tests := []struct {
+		args []string
+		want int64
+	}{
+		// No end: the string is padded with zeros
+		{[]string{"ones", "0"}, 24},
+		{[]string{"ones", "0", "2"}, 24},
+		{[]string{"ones", "0", "-1"}, 24},
+		// Explicit end: only the range counts
+		{[]string{"ones", "0", "0", "-1"}, -1},
+		{[]string{"ones", "0", "0", "-1", "BIT"}, -1},
+		// A clear bit inside the range is found either way
+		{[]string{"k", "0", "0", "-1"}, 12},
+		{[]string{"k", "0", "1"}, 12},
+		// An empty range never matches
+		{[]string{"ones", "0", "3"}, -1},
+		{[]string{"ones", "0", "2", "1"}, -1},
+	}
+	for _, tt := range tests {
+		reply, err := bitposCmd(newTestContext(t, db, tt.args...))
+		if err != nil {
+			t.Fatalf("BITPOS %v failed: %v", tt.args, err)
+		}
+		if reply.Value != tt.want {
+			t.Errorf("BITPOS %v expected %d, got %v", tt.args, tt.want, reply.Value)
+		}
+	}
+}
+
+func TestBitfieldHashOffset(t *testing.T) {
+	db := database.NewDB(0)
+
+	reply, err := bitfieldCmd(newTestContext(t, db, "k", "SET", "u8", "#0", "18", "SET", "u8", "#1", "255"))
+	if err != nil {
+		t.Fatalf("BITFIELD SET failed: %v", err)
+	}
+	if got := string(reply.Marshal()); got != "*2\r\n:0\r\n:0\r\n" {
+		t.Errorf("BITFIELD SET expected old values 0, got %q", got)
+	}
+
+	obj, _ := db.Get("k")
+	if got := obj.String(); got != "\x12\xff" {
+		t.Errorf("expected bytes %q, got %q", "\x12\xff", got)
+	}
+
+	reply, err = bitfieldRoCmd(newTestContext(t, db, "k", "GET", "u8", "#1", "GET", "u8", "8", "GET", "u16", "#0"))
+	if err != nil {
+		t.Fatalf("BITFIELD_RO GET failed: %v", err)
+	}
+	if got := string(reply.Marshal()); got != "*3\r\n:255\r\n:255\r\n:4863\r\n" {
+		t.Errorf("BITFIELD_RO GET expected [255 255 4863], got %q", got)
+	}
+}
+
+func TestBitfieldUnalignedRoundTrip(t *testing.T) {
+	tests := []struct {
+		encoding string
+		value    int64
+	}{
+		{"u1", 1},
+		{"i5", -11},
+		{"i5", 13},
+		{"u13", 5000},
+		{"i16", -12345},
+		{"i16", 32767},
+		{"u31", 1<<31 - 2},
+	}
+
+	for _, tt := range tests {
+		for _, offset := range []int{0, 3, 7, 10, 33} {
+			// Surround the field with set bits to catch stray writes
+			s := strings.Repeat("\xff", 10)
+			_, s, err := setBitfield(s, tt.encoding, offset, tt.value)
+			if err != nil {
+				t.Fatalf("%s at %d: SET failed: %v", tt.encoding, offset, err)
+			}
+
+			if got, _ := getBitfield(s, tt.encoding, offset); got != tt.value {
+				t.Errorf("%s at %d: expected %d, got %d", tt.encoding, offset, tt.value, got)
+			}
+			if offset > 0 {
+				prefix := "u" + strconv.Itoa(offset)
+				if got, _ := getBitfield(s, prefix, 0); got != 1<<offset-1 {
+					t.Errorf("%s at %d: bits before the field changed", tt.encoding, offset)
+				}
+			}
+			bits, _ := strconv.Atoi(tt.encoding[1:])
+			if got, _ := getBitfield(s, "u8", offset+bits); got != 0xff {
+				t.Errorf("%s at %d: bits after the field changed", tt.encoding, offset)
+			}
+		}
+	}
+
+	// Setting past the end grows the string with zero bytes
+	_, s, _ := setBitfield("", "u5", 3, 31)
+	if s != "\x1f" {
+		t.Errorf("expected %q, got %q", "\x1f", s)
+	}
+	_, s, _ = setBitfield("", "i13", 10, -1)
+	if s != "\x00\x3f\xfe" {
+		t.Errorf("expected %q, got %q", "\x00\x3f\xfe", s)
+	}
+}
+
+func TestBitfieldOverflow(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		// SET follows the overflow mode too
+		{[]string{"OVERFLOW", "SAT", "SET", "u8", "0", "300", "GET", "u8", "0"}, "*2\r\n:0\r\n:255\r\n"},
+		{[]string{"OVERFLOW", "SAT", "SET", "i8", "0", "-300", "GET", "i8", "0"}, "*2\r\n:0\r\n:-128\r\n"},
+		{[]string{"OVERFLOW", "FAIL", "SET", "u8", "0", "256", "GET", "u8", "0"}, "*2\r\n$-1\r\n:0\r\n"},
+		{[]string{"SET", "u8", "0", "300", "GET", "u8", "0"}, "*2\r\n:0\r\n:44\r\n"},
+		// INCRBY wraps by default
+		{[]string{"INCRBY", "i8", "0", "200"}, "*1\r\n:-56\r\n"},
+		{[]string{"INCRBY", "u4", "0", "1000"}, "*1\r\n:8\r\n"},
+		{[]string{"OVERFLOW", "FAIL", "INCRBY", "i8", "0", "200"}, "*1\r\n$-1\r\n"},
+		{[]string{"OVERFLOW", "SAT", "INCRBY", "i8", "0", "200", "INCRBY", "i8", "0", "-500"}, "*2\r\n:127\r\n:-128\r\n"},
+	}
+
+	for _, tt := range tests {
+		db := database.NewDB(0)
+		reply, err := bitfieldCmd(newTestContext(t, db, append([]string{"k"}, tt.args...)...))
+		if err != nil {
+			t.Fatalf("BITFIELD %v failed: %v", tt.args, err)
+		}
+		if got := string(reply.Marshal()); got != tt.want {
+			t.Errorf("BITFIELD %v expected %q, got %q", tt.args, tt.want, got)
+		}
+	}
+
+	if _, err := bitfieldCmd(newTestContext(t, database.NewDB(0), "k", "GET", "u64", "0")); err == nil {
+		t.Error("BITFIELD GET u64 expected an error")
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+)
+
+// keyWaiters tracks clients blocked on keys and wakes them when the keys
+// receive new data
+type keyWaiters struct {
+	mu      sync.Mutex
+	waiters map[string]map[chan struct{}]struct{}
+	blocked int
+}
+
+// streamWaiters holds the clients blocked in XREAD and XREADGROUP
+var streamWaiters = newKeyWaiters()
+
+func newKeyWaiters() *keyWaiters {
+	return &keyWaiters{
+		waiters: make(map[string]map[chan struct{}]struct{}),
+	}
+}
+
+// waiterKey scopes a key to its database
+func waiterKey(dbID int, key string) string {
+	return strconv.Itoa(dbID) + ":" + key
+}
+
+// register returns a channel signaled whenever any of the keys is written
+func (w *keyWaiters) register(dbID int, keys []string) chan struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	// Buffered so a signal sent between two reads is not lost
+	ch := make(chan struct{}, 1)
+	for _, key := range keys {
+		wk := waiterKey(dbID, key)
+		if w.waiters[wk] == nil {
+			w.waiters[wk] = make(map[chan struct{}]struct{})
+		}
+		w.waiters[wk][ch] = struct{}{}
+	}
+	w.blocked++
+	return ch
+}
+
+// unregister removes a channel returned by register
+func (w *keyWaiters) unregister(dbID int, keys []string, ch chan struct{}) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for _, key := range keys {
+		wk := waiterKey(dbID, key)
+		delete(w.waiters[wk], ch)
+		if len(w.waiters[wk]) == 0 {
+			delete(w.waiters, wk)
+		}
+	}
+	w.blocked--
+}
+
+// signal wakes every client blocked on key
+func (w *keyWaiters) signal(dbID int, key string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	for ch := range w.waiters[waiterKey(dbID, key)] {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// blockedClients returns the number of clients currently blocked
+func (w *keyWaiters) blockedClients() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.blocked
+}
+
+// blockOnKeys calls read until it returns results, parking the connection
+// between writes to any of keys. It returns a nil reply once timeout
+// elapses, or right away inside a transaction; a zero timeout waits
+// forever.
+func blockOnKeys(ctx *command.Context, w *keyWaiters, keys []string, timeout time.Duration, read func() ([]*command.Reply, error)) (*command.Reply, error) {
+	dbID := ctx.DB.GetID()
+	ch := w.register(dbID, keys)
+	defer w.unregister(dbID, keys, ch)
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	for {
+		results, err := read()
+		if err != nil {
+			return nil, err
+		}
+		if len(results) > 0 {
+			return command.NewArrayReply(results), nil
+		}
+
+		woken := false
+		waited := ctx.Wait(func() {
+			select {
+			case <-ch:
+				woken = true
+			case <-expired:
+			}
+		})
+		if !waited || !woken {
+			return command.NewNilReply(), nil
+		}
+	}
+}
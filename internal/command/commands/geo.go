@@ -78,6 +78,26 @@ func RegisterGeoCommands(disp Dispatcher) {
 		LastKey:    1,
 		Categories: []string{command.CatGeo},
 	})
+
+	disp.Register(&command.Command{
+		Name:       "GEORADIUS_RO",
+		Handler:    georadiusROCmd,
+		Arity:      -6,
+		Flags:      []string{command.FlagReadOnly},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatGeo},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "GEORADIUSBYMEMBER_RO",
+		Handler:    georadiusbymemberROCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagReadOnly},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatGeo},
+	})
 }
 
 // GEOADD key [NX|XX] [CH] longitude latitude member [longitude latitude member ...]
@@ -94,7 +114,7 @@ func geoaddCmd(ctx *command.Context) (*command.Reply, error) {
 	xx := false
 	ch := false
 
-	for len(args) > 0 && (args[0] == "NX" || args[0] == "XX" || args[0] == "CH") {
+	for len(args) > 0 {
 		opt := strings.ToUpper(args[0])
 		if opt == "NX" {
 			nx = true
@@ -102,6 +122,8 @@ func geoaddCmd(ctx *command.Context) (*command.Reply, error) {
 			xx = true
 		} else if opt == "CH" {
 			ch = true
+		} else {
+			break
 		}
 		args = args[1:]
 	}
@@ -153,13 +175,12 @@ func geoaddCmd(ctx *command.Context) (*command.Reply, error) {
 		score := geopkg.EncodeToScore(longitude, latitude)
 
 		// Check if member exists
-		if _, found := zs.Score(member); found {
+		if oldScore, found := zs.Score(member); found {
 			if nx {
 				continue // Skip - NX means only add new elements
 			}
-			// Update existing
-			oldScore, _ := zs.Score(member)
-			if !ch || oldScore != score {
+			// Update existing, unless the encoded position is unchanged
+			if oldScore != score {
 				zs.Remove(member)
 				zs.Add(member, score)
 				updated++
@@ -173,9 +194,12 @@ func geoaddCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 	}
 
-	// Store back
-	obj = database.NewObject(database.ObjTypeZSet, database.ObjEncodingSkiplist, zs)
-	ctx.DB.Set(key, obj)
+	// Store back, only if something changed
+	if added+updated > 0 {
+		obj = database.NewObject(database.ObjTypeZSet, database.ObjEncodingListpack, zs)
+		obj.FitEncoding()
+		ctx.DB.Set(key, obj)
+	}
 
 	// Return number of elements added (or updated if CH is set)
 	if ch {
@@ -197,7 +221,7 @@ func geodistCmd(ctx *command.Context) (*command.Reply, error) {
 	unit := geopkg.Meters
 	if len(ctx.Args) >= 4 {
 		switch strings.ToUpper(ctx.Args[3]) {
-		case "M", "METERS", "METRE", "METRES":
+		case "", "M", "METERS", "METRE", "METRES":
 			unit = geopkg.Meters
 		case "KM", "KILOMETERS", "KILOMETRE", "KILOMETRES":
 			unit = geopkg.Kilometers
@@ -268,7 +292,7 @@ func geohashCmd(ctx *command.Context) (*command.Reply, error) {
 	for i, member := range members {
 		if score, ok := zs.Score(member); ok {
 			lon, lat := geopkg.DecodeFromScore(score)
-			results[i] = geopkg.EncodeToBase32(lon, lat, 10)
+			results[i] = geopkg.EncodeToBase32(lon, lat, 11)
 		}
 		// else: results[i] remains "" (nil in RESP)
 	}
@@ -303,7 +327,7 @@ func geoposCmd(ctx *command.Context) (*command.Reply, error) {
 		if score, ok := zs.Score(member); ok {
 			lon, lat := geopkg.DecodeFromScore(score)
 			// Return array of [longitude, latitude]
-			results[i] = []interface{}{lon, lat}
+			results[i] = coordinatesReply(lon, lat)
 		}
 		// else: results[i] remains nil
 	}
@@ -311,7 +335,7 @@ func geoposCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewArrayReplyFromAny(results), nil
 }
 
-// GEORADIUS key longitude latitude radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count]
+// GEORADIUS key longitude latitude radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count [ANY]] [ASC|DESC] [STORE key] [STOREDIST key]
 func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 5 {
 		return nil, errors.New("wrong number of arguments")
@@ -357,7 +381,9 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 	withHash := false
 	count := 0
 	countSet := false
+	countAny := false
 	asc := true
+	sortSet := false
 	storeKey := ""
 	storeDistKey := ""
 
@@ -380,10 +406,14 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 			}
 			countSet = true
 			i++
+		case "ANY":
+			countAny = true
 		case "ASC":
 			asc = true
+			sortSet = true
 		case "DESC":
 			asc = false
+			sortSet = true
 		case "STORE":
 			if i+1 >= len(args) {
 				return nil, errors.New("syntax error")
@@ -399,6 +429,10 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 	}
 
+	if countAny && count == 0 {
+		return nil, errors.New("ERR the ANY argument requires COUNT argument")
+	}
+
 	// Get ZSet
 	obj, ok := ctx.DB.Get(key)
 	if !ok {
@@ -438,18 +472,24 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 				lon:    lon,
 				lat:    lat,
 				dist:   dist,
-				hash:   uint64(score),
+				hash:   geopkg.HashFromScore(score),
 			})
+			// With ANY, stop as soon as enough matches are found
+			if countAny && len(results) == count {
+				break
+			}
 		}
 	}
 
-	// Sort by distance
-	sort.Slice(results, func(i, j int) bool {
-		if asc {
-			return results[i].dist < results[j].dist
-		}
-		return results[i].dist > results[j].dist
-	})
+	// Sort by distance; ANY results are only sorted on request
+	if !countAny || sortSet {
+		sort.Slice(results, func(i, j int) bool {
+			if asc {
+				return results[i].dist < results[j].dist
+			}
+			return results[i].dist > results[j].dist
+		})
+	}
 
 	// Apply count limit
 	if countSet && count > 0 && count < len(results) {
@@ -462,7 +502,8 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 		for _, r := range results {
 			storeZs.Add(r.member, r.score)
 		}
-		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingSkiplist, storeZs)
+		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingListpack, storeZs)
+		storeObj.FitEncoding()
 		ctx.DB.Set(storeKey, storeObj)
 		return command.NewIntegerReply(int64(len(results))), nil
 	}
@@ -473,7 +514,8 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 			// Store distance as score (converted to meters for consistency)
 			storeZs.Add(r.member, r.dist)
 		}
-		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingSkiplist, storeZs)
+		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingListpack, storeZs)
+		storeObj.FitEncoding()
 		ctx.DB.Set(storeDistKey, storeObj)
 		return command.NewIntegerReply(int64(len(results))), nil
 	}
@@ -487,10 +529,10 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 			items = append(items, fmt.Sprintf("%.4f", geopkg.FromMeters(r.dist, unit)))
 		}
 		if withHash {
-			items = append(items, fmt.Sprintf("%d", r.hash))
+			items = append(items, int64(r.hash))
 		}
 		if withCoord {
-			items = append(items, []interface{}{r.lon, r.lat})
+			items = append(items, coordinatesReply(r.lon, r.lat))
 		}
 
 		if len(items) == 1 {
@@ -503,7 +545,7 @@ func georadiusCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewArrayReplyFromAny(reply), nil
 }
 
-// GEORADIUSBYMEMBER key member radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count]
+// GEORADIUSBYMEMBER key member radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count [ANY]] [ASC|DESC] [STORE key] [STOREDIST key]
 func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 4 {
 		return nil, errors.New("wrong number of arguments")
@@ -539,7 +581,9 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 	withHash := false
 	count := 0
 	countSet := false
+	countAny := false
 	asc := true
+	sortSet := false
 	storeKey := ""
 	storeDistKey := ""
 
@@ -562,10 +606,14 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 			}
 			countSet = true
 			i++
+		case "ANY":
+			countAny = true
 		case "ASC":
 			asc = true
+			sortSet = true
 		case "DESC":
 			asc = false
+			sortSet = true
 		case "STORE":
 			if i+1 >= len(args) {
 				return nil, errors.New("syntax error")
@@ -581,6 +629,10 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 	}
 
+	if countAny && count == 0 {
+		return nil, errors.New("ERR the ANY argument requires COUNT argument")
+	}
+
 	// Get ZSet
 	obj, ok := ctx.DB.Get(key)
 	if !ok {
@@ -630,18 +682,24 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 				lon:    lon,
 				lat:    lat,
 				dist:   dist,
-				hash:   uint64(score),
+				hash:   geopkg.HashFromScore(score),
 			})
+			// With ANY, stop as soon as enough matches are found
+			if countAny && len(results) == count {
+				break
+			}
 		}
 	}
 
-	// Sort by distance
-	sort.Slice(results, func(i, j int) bool {
-		if asc {
-			return results[i].dist < results[j].dist
-		}
-		return results[i].dist > results[j].dist
-	})
+	// Sort by distance; ANY results are only sorted on request
+	if !countAny || sortSet {
+		sort.Slice(results, func(i, j int) bool {
+			if asc {
+				return results[i].dist < results[j].dist
+			}
+			return results[i].dist > results[j].dist
+		})
+	}
 
 	// Apply count limit
 	if countSet && count > 0 && count < len(results) {
@@ -654,7 +712,8 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 		for _, r := range results {
 			storeZs.Add(r.member, r.score)
 		}
-		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingSkiplist, storeZs)
+		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingListpack, storeZs)
+		storeObj.FitEncoding()
 		ctx.DB.Set(storeKey, storeObj)
 		return command.NewIntegerReply(int64(len(results))), nil
 	}
@@ -664,7 +723,8 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 		for _, r := range results {
 			storeZs.Add(r.member, r.dist)
 		}
-		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingSkiplist, storeZs)
+		storeObj := database.NewObject(database.ObjTypeZSet, database.ObjEncodingListpack, storeZs)
+		storeObj.FitEncoding()
 		ctx.DB.Set(storeDistKey, storeObj)
 		return command.NewIntegerReply(int64(len(results))), nil
 	}
@@ -678,10 +738,10 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 			items = append(items, fmt.Sprintf("%.4f", geopkg.FromMeters(r.dist, unit)))
 		}
 		if withHash {
-			items = append(items, fmt.Sprintf("%d", r.hash))
+			items = append(items, int64(r.hash))
 		}
 		if withCoord {
-			items = append(items, []interface{}{r.lon, r.lat})
+			items = append(items, coordinatesReply(r.lon, r.lat))
 		}
 
 		if len(items) == 1 {
@@ -693,3 +753,40 @@ func georadiusbymemberCmd(ctx *command.Context) (*command.Reply, error) {
 
 	return command.NewArrayReplyFromAny(reply), nil
 }
+
+// GEORADIUS_RO key longitude latitude radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count [ANY]] [ASC|DESC]
+func georadiusROCmd(ctx *command.Context) (*command.Reply, error) {
+	if hasStoreOption(ctx.Args[5:]) {
+		return nil, errors.New("ERR syntax error")
+	}
+	return georadiusCmd(ctx)
+}
+
+// GEORADIUSBYMEMBER_RO key member radius unit [WITHCOORD] [WITHDIST] [WITHHASH] [COUNT count [ANY]] [ASC|DESC]
+func georadiusbymemberROCmd(ctx *command.Context) (*command.Reply, error) {
+	if hasStoreOption(ctx.Args[4:]) {
+		return nil, errors.New("ERR syntax error")
+	}
+	return georadiusbymemberCmd(ctx)
+}
+
+// hasStoreOption reports whether options contain STORE or STOREDIST,
+// which the read-only variants do not accept
+func hasStoreOption(options []string) bool {
+	for _, opt := range options {
+		switch strings.ToUpper(opt) {
+		case "STORE", "STOREDIST":
+			return true
+		}
+	}
+	return false
+}
+
+// coordinatesReply returns a [longitude, latitude] pair as bulk strings,
+// in plain decimal notation
+func coordinatesReply(lon, lat float64) []interface{} {
+	return []interface{}{
+		strconv.FormatFloat(lon, 'f', -1, 64),
+		strconv.FormatFloat(lat, 'f', -1, 64),
+	}
+}
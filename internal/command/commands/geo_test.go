@@ -0,0 +1,213 @@
+package commands
+
+import (
+	"math"
+	"strconv"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+// newSicily returns a DB holding the Palermo/Catania geoset from the Redis docs
+func newSicily(t *testing.T) *database.DB {
+	t.Helper()
+
+	db := database.NewDB(0)
+	ctx := newTestContext(t, db, "Sicily",
+		"13.361389", "38.115556", "Palermo",
+		"15.087269", "37.502669", "Catania")
+	if _, err := geoaddCmd(ctx); err != nil {
+		t.Fatalf("GEOADD failed: %v", err)
+	}
+	return db
+}
+
+func TestGeodistSelf(t *testing.T) {
+	db := newSicily(t)
+
+	for _, unit := range []string{"m", "km", "mi", "ft"} {
+		ctx := newTestContext(t, db, "Sicily", "Palermo", "Palermo", unit)
+		reply, err := geodistCmd(ctx)
+		if err != nil {
+			t.Fatalf("GEODIST %s failed: %v", unit, err)
+		}
+		if reply.Value != "0.0000" {
+			t.Errorf("GEODIST self in %s expected 0.0000, got %v", unit, reply.Value)
+		}
+	}
+}
+
+func TestGeodistUnits(t *testing.T) {
+	db := newSicily(t)
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"Sicily", "Palermo", "Catania"}, "166274.1516"},
+		{[]string{"Sicily", "Palermo", "Catania", ""}, "166274.1516"},
+		{[]string{"Sicily", "Palermo", "Catania", "m"}, "166274.1516"},
+		{[]string{"Sicily", "Palermo", "Catania", "KM"}, "166.2742"},
+		{[]string{"Sicily", "Palermo", "Catania", "mi"}, "103.3182"},
+		{[]string{"Sicily", "Palermo", "Catania", "ft"}, "545518.8700"},
+	}
+
+	for _, tt := range tests {
+		ctx := newTestContext(t, db, tt.args...)
+		reply, err := geodistCmd(ctx)
+		if err != nil {
+			t.Fatalf("GEODIST %v failed: %v", tt.args, err)
+		}
+		if reply.Value != tt.expected {
+			t.Errorf("GEODIST %v expected %s, got %v", tt.args, tt.expected, reply.Value)
+		}
+	}
+
+	ctx := newTestContext(t, db, "Sicily", "Palermo", "Catania", "parsecs")
+	if _, err := geodistCmd(ctx); err == nil || err.Error() != "unknown unit" {
+		t.Errorf("GEODIST with bad unit expected unknown unit error, got %v", err)
+	}
+}
+
+func TestGeoHashAndPosGolden(t *testing.T) {
+	db := newSicily(t)
+
+	reply, err := georadiusCmd(newTestContext(t, db, "Sicily", "15", "37", "200", "km", "WITHHASH", "ASC"))
+	if err != nil {
+		t.Fatalf("GEORADIUS failed: %v", err)
+	}
+	want := "*2\r\n*2\r\n$7\r\nCatania\r\n:3479447370796909\r\n*2\r\n$7\r\nPalermo\r\n:3479099956230698\r\n"
+	if got := string(reply.Marshal()); got != want {
+		t.Errorf("GEORADIUS WITHHASH expected %q, got %q", want, got)
+	}
+
+	// GEOPOS values from the Redis docs, printed there with extra digits
+	reply, err = geoposCmd(newTestContext(t, db, "Sicily", "Palermo", "Catania", "Agrigento"))
+	if err != nil {
+		t.Fatalf("GEOPOS failed: %v", err)
+	}
+	golden := [][2]float64{
+		{13.36138933897018433, 38.11555639549629859},
+		{15.08726745843887329, 37.50266842333162032},
+	}
+	positions := reply.Value.([]interface{})
+	for i, want := range golden {
+		pos := positions[i].([]interface{})
+		for j := range want {
+			got, err := strconv.ParseFloat(pos[j].(string), 64)
+			if err != nil || math.Abs(got-want[j]) > 1e-12 {
+				t.Errorf("GEOPOS %d expected %.17f, got %v", i, want[j], pos[j])
+			}
+		}
+	}
+	if positions[2] != nil {
+		t.Errorf("GEOPOS of a missing member expected nil, got %v", positions[2])
+	}
+}
+
+func TestGeoRadiusCountAny(t *testing.T) {
+	db := database.NewDB(0)
+	args := []string{"points"}
+	for i := 0; i < 20; i++ {
+		args = append(args, strconv.FormatFloat(float64(i)*0.001, 'f', -1, 64), "0", "p"+strconv.Itoa(i))
+	}
+	if _, err := geoaddCmd(newTestContext(t, db, args...)); err != nil {
+		t.Fatalf("GEOADD failed: %v", err)
+	}
+
+	reply, err := georadiusCmd(newTestContext(t, db, "points", "0", "0", "10", "km", "COUNT", "5", "ANY"))
+	if err != nil {
+		t.Fatalf("GEORADIUS COUNT ANY failed: %v", err)
+	}
+	members := reply.Value.([]interface{})
+	if len(members) != 5 {
+		t.Fatalf("GEORADIUS COUNT 5 ANY expected 5 members, got %v", members)
+	}
+	seen := make(map[interface{}]bool)
+	for _, m := range members {
+		if seen[m] {
+			t.Errorf("GEORADIUS COUNT ANY returned %v twice", m)
+		}
+		seen[m] = true
+	}
+
+	// ANY with a sort order sorts the matches it found
+	reply, err = georadiusbymemberCmd(newTestContext(t, db, "points", "p0", "10", "km", "WITHDIST", "COUNT", "3", "ANY", "DESC"))
+	if err != nil {
+		t.Fatalf("GEORADIUSBYMEMBER COUNT ANY DESC failed: %v", err)
+	}
+	items := reply.Value.([]interface{})
+	if len(items) != 3 {
+		t.Fatalf("GEORADIUSBYMEMBER COUNT 3 ANY expected 3 members, got %v", items)
+	}
+	prev := math.Inf(1)
+	for _, item := range items {
+		dist, _ := strconv.ParseFloat(item.([]interface{})[1].(string), 64)
+		if dist > prev {
+			t.Errorf("GEORADIUSBYMEMBER DESC results out of order: %v", items)
+		}
+		prev = dist
+	}
+
+	if _, err := georadiusCmd(newTestContext(t, db, "points", "0", "0", "10", "km", "ANY")); err == nil {
+		t.Error("GEORADIUS ANY without COUNT expected error")
+	}
+}
+
+func TestGeoRadiusReadOnly(t *testing.T) {
+	db := newSicily(t)
+
+	reply, err := georadiusROCmd(newTestContext(t, db, "Sicily", "15", "37", "200", "km", "ASC"))
+	if err != nil {
+		t.Fatalf("GEORADIUS_RO failed: %v", err)
+	}
+	if want := "*2\r\n$7\r\nCatania\r\n$7\r\nPalermo\r\n"; string(reply.Marshal()) != want {
+		t.Errorf("GEORADIUS_RO expected %q, got %q", want, reply.Marshal())
+	}
+
+	if _, err := georadiusROCmd(newTestContext(t, db, "Sicily", "15", "37", "200", "km", "STORE", "dst")); err == nil {
+		t.Error("GEORADIUS_RO STORE expected error")
+	}
+	if _, err := georadiusbymemberROCmd(newTestContext(t, db, "Sicily", "Palermo", "200", "km", "STOREDIST", "dst")); err == nil {
+		t.Error("GEORADIUSBYMEMBER_RO STOREDIST expected error")
+	}
+	if _, ok := db.Get("dst"); ok {
+		t.Error("read-only GEORADIUS variants must not store results")
+	}
+}
+
+func TestGeoAddChangedCount(t *testing.T) {
+	db := newSicily(t)
+
+	tests := []struct {
+		args     []string
+		expected int64
+	}{
+		// Same coordinates: nothing changes
+		{[]string{"Sicily", "CH", "13.361389", "38.115556", "Palermo"}, 0},
+		{[]string{"Sicily", "ch", "13.361389", "38.115556", "Palermo", "15.087269", "37.502669", "Catania"}, 0},
+		// One moved member and one new member
+		{[]string{"Sicily", "CH", "13.5", "38.1", "Palermo", "15.087269", "37.502669", "Catania", "13.583333", "37.316667", "Agrigento"}, 2},
+		// Without CH, only additions count
+		{[]string{"Sicily", "13.6", "38.1", "Palermo"}, 0},
+	}
+
+	for _, tt := range tests {
+		reply, err := geoaddCmd(newTestContext(t, db, tt.args...))
+		if err != nil {
+			t.Fatalf("GEOADD %v failed: %v", tt.args, err)
+		}
+		if reply.Value != tt.expected {
+			t.Errorf("GEOADD %v expected %d, got %v", tt.args, tt.expected, reply.Value)
+		}
+	}
+
+	reply, err := geoposCmd(newTestContext(t, db, "Sicily", "Palermo"))
+	if err != nil {
+		t.Fatalf("GEOPOS failed: %v", err)
+	}
+	lon, _ := strconv.ParseFloat(reply.Value.([]interface{})[0].([]interface{})[0].(string), 64)
+	if math.Abs(lon-13.6) > 1e-5 {
+		t.Errorf("Palermo longitude expected 13.6 after update, got %v", lon)
+	}
+}
@@ -6,7 +6,10 @@ package commands
 
 import (
 	"errors"
+	"math"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/command"
 	"github.com/zyhnesmr/godis/internal/database"
@@ -174,6 +177,116 @@ func RegisterHashCommands(disp Dispatcher) {
 		LastKey:    1,
 		Categories: []string{command.CatHash},
 	})
+
+	disp.Register(&command.Command{
+		Name:       "HEXPIRE",
+		Handler:    hexpireCmd,
+		Arity:      -6,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HPEXPIRE",
+		Handler:    hpexpireCmd,
+		Arity:      -6,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HEXPIREAT",
+		Handler:    hexpireatCmd,
+		Arity:      -6,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HPEXPIREAT",
+		Handler:    hpexpireatCmd,
+		Arity:      -6,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HTTL",
+		Handler:    httlCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagReadOnly, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HPTTL",
+		Handler:    hpttlCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagReadOnly, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HEXPIRETIME",
+		Handler:    hexpiretimeCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagReadOnly, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HPEXPIRETIME",
+		Handler:    hpexpiretimeCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagReadOnly, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HPERSIST",
+		Handler:    hpersistCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HGETDEL",
+		Handler:    hgetdelCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "HGETEX",
+		Handler:    hgetexCmd,
+		Arity:      -5,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatHash},
+	})
 }
 
 // HSET key field value [field value ...]
@@ -213,6 +326,7 @@ func hsetCmd(ctx *command.Context) (*command.Reply, error) {
 		field := args[1]
 		value := args[2]
 		added := h.Set(field, value)
+		obj.ConvertIfNeeded(field, value)
 		return command.NewIntegerReply(int64(added)), nil
 	}
 
@@ -229,6 +343,7 @@ func hsetCmd(ctx *command.Context) (*command.Reply, error) {
 		value := args[i+1]
 		added += h.Set(field, value)
 	}
+	obj.ConvertIfNeeded(args[1:]...)
 
 	return command.NewIntegerReply(int64(added)), nil
 }
@@ -257,6 +372,7 @@ func hgetCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	val, exists := h.Get(field)
+	dropEmptyHash(ctx, key, h)
 	if !exists {
 		return command.NewNilReply(), nil
 	}
@@ -300,14 +416,11 @@ func hmsetCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("wrong number of arguments for multiple field-value pairs")
 	}
 
-	pairs := make(map[string]string)
+	// Set in argument order, which a listpack hash keeps
 	for i := 1; i < len(args); i += 2 {
-		field := args[i]
-		value := args[i+1]
-		pairs[field] = value
+		h.Set(args[i], args[i+1])
 	}
-
-	h.MSet(pairs)
+	obj.ConvertIfNeeded(args[1:]...)
 	return command.NewStatusReply("OK"), nil
 }
 
@@ -341,6 +454,7 @@ func hmgetCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	result := h.MGet(fields)
+	dropEmptyHash(ctx, key, h)
 	return command.NewArrayReplyFromAny(result), nil
 }
 
@@ -376,11 +490,13 @@ func hsetnxCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	// Check if field exists
+	dropEmptyHash(ctx, key, h)
 	if h.Exists(field) {
 		return command.NewIntegerReply(0), nil
 	}
 
 	h.Set(field, value)
+	obj.ConvertIfNeeded(field, value)
 	return command.NewIntegerReply(1), nil
 }
 
@@ -485,6 +601,7 @@ func hincrbyCmd(ctx *command.Context) (*command.Reply, error) {
 	if err != nil {
 		return nil, err
 	}
+	obj.ConvertIfNeeded(field, strconv.FormatInt(newVal, 10))
 
 	return command.NewIntegerReply(newVal), nil
 }
@@ -528,7 +645,10 @@ func hincrbyfloatCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, err
 	}
 
-	return command.NewBulkStringReply(strconv.FormatFloat(newVal, 'f', -1, 64)), nil
+	formatted := strconv.FormatFloat(newVal, 'f', -1, 64)
+	obj.ConvertIfNeeded(field, formatted)
+	ctx.Propagate("HSET", key, field, formatted)
+	return command.NewBulkStringReply(formatted), nil
 }
 
 // HKEYS key
@@ -554,6 +674,7 @@ func hkeysCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	keys := h.Keys()
+	dropEmptyHash(ctx, key, h)
 	return command.NewStringArrayReply(keys), nil
 }
 
@@ -580,6 +701,7 @@ func hvalsCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	vals := h.Vals()
+	dropEmptyHash(ctx, key, h)
 	return command.NewStringArrayReply(vals), nil
 }
 
@@ -606,6 +728,7 @@ func hgetallCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	all := h.GetAll()
+	dropEmptyHash(ctx, key, h)
 	return command.NewStringArrayReply(all), nil
 }
 
@@ -631,7 +754,9 @@ func hlenCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("internal error: not a hash object")
 	}
 
-	return command.NewIntegerReply(int64(h.Len())), nil
+	n := h.Len()
+	dropEmptyHash(ctx, key, h)
+	return command.NewIntegerReply(int64(n)), nil
 }
 
 // HSTRLEN key field
@@ -657,10 +782,12 @@ func hstrlenCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("internal error: not a hash object")
 	}
 
-	return command.NewIntegerReply(int64(h.StrLen(field))), nil
+	n := h.StrLen(field)
+	dropEmptyHash(ctx, key, h)
+	return command.NewIntegerReply(int64(n)), nil
 }
 
-// HSCAN key cursor [MATCH pattern] [COUNT count]
+// HSCAN key cursor [MATCH pattern] [COUNT count] [NOVALUES]
 func hscanCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
 	if len(args) < 2 {
@@ -676,11 +803,12 @@ func hscanCmd(ctx *command.Context) (*command.Reply, error) {
 	// Default values
 	count := 10
 	pattern := "*"
+	noValues := false
 
 	// Parse options
 	i := 2
 	for i < len(args) {
-		switch args[i] {
+		switch strings.ToUpper(args[i]) {
 		case "MATCH":
 			if i+1 >= len(args) {
 				return nil, errors.New("syntax error")
@@ -696,6 +824,9 @@ func hscanCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("invalid count")
 			}
 			i += 2
+		case "NOVALUES":
+			noValues = true
+			i++
 		default:
 			return nil, errors.New("syntax error")
 		}
@@ -720,11 +851,18 @@ func hscanCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	newCursor, fields := h.Scan(cursor, count, pattern)
+	dropEmptyHash(ctx, key, h)
 
 	// Build result as nested array: [cursor, [field1, value1, field2, value2, ...]]
 	resultArray := make([]*command.Reply, 2)
 	resultArray[0] = command.NewBulkStringReply(strconv.Itoa(newCursor))
 
+	// NOVALUES returns the field names alone
+	if noValues {
+		resultArray[1] = command.NewStringArrayReply(fields)
+		return command.NewArrayReply(resultArray), nil
+	}
+
 	// Build field-value array
 	fieldValues := make([]string, 0, len(fields)*2)
 	for _, field := range fields {
@@ -779,6 +917,7 @@ func hrandfieldCmd(ctx *command.Context) (*command.Reply, error) {
 
 	// Get all fields and return random ones
 	keys := h.Keys()
+	dropEmptyHash(ctx, key, h)
 	if len(keys) == 0 {
 		if count < 0 {
 			return command.NewStringArrayReply([]string{}), nil
@@ -813,3 +952,357 @@ func hrandfieldCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 	return command.NewStringArrayReply(result), nil
 }
+
+// HEXPIRE key seconds FIELDS numfields field [field ...]
+func hexpireCmd(ctx *command.Context) (*command.Reply, error) {
+	return hexpireGeneric(ctx, 1000, false)
+}
+
+// HPEXPIRE key milliseconds FIELDS numfields field [field ...]
+func hpexpireCmd(ctx *command.Context) (*command.Reply, error) {
+	return hexpireGeneric(ctx, 1, false)
+}
+
+// HEXPIREAT key unix-time-seconds FIELDS numfields field [field ...]
+func hexpireatCmd(ctx *command.Context) (*command.Reply, error) {
+	return hexpireGeneric(ctx, 1000, true)
+}
+
+// HPEXPIREAT key unix-time-milliseconds FIELDS numfields field [field ...]
+func hpexpireatCmd(ctx *command.Context) (*command.Reply, error) {
+	return hexpireGeneric(ctx, 1, true)
+}
+
+// hexpireGeneric sets the expiration of hash fields to a time given in
+// units of msPerUnit milliseconds, relative to now unless absolute. It
+// replies with one result per field: -2 if the field does not exist, 1 if
+// the expiration was set, 2 if the time had passed and the field was
+// deleted. The AOF gets the absolute time the fields expire at.
+func hexpireGeneric(ctx *command.Context, msPerUnit int64, absolute bool) (*command.Reply, error) {
+	key := ctx.Args[0]
+	at, err := parseFieldExpireAt(ctx, ctx.Args[1], msPerUnit, absolute)
+	if err != nil {
+		return command.NewErrorReply(err), nil
+	}
+
+	fields, err := parseHashFields(ctx.Args[2:])
+	if err != nil {
+		return command.NewErrorReply(err), nil
+	}
+
+	h, err := lookupHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, len(fields))
+	var updated, deleted []string
+	for i, field := range fields {
+		result := hash.FieldMissing
+		if h != nil {
+			result = h.SetFieldExpireAt(field, at)
+		}
+		switch result {
+		case hash.FieldUpdated:
+			updated = append(updated, field)
+		case hash.FieldDeleted:
+			deleted = append(deleted, field)
+		}
+		results[i] = int64(result)
+	}
+
+	if h != nil {
+		dropEmptyHash(ctx, key, h)
+	}
+	propagateFieldExpire(ctx, key, at, updated, deleted)
+
+	return command.NewArrayReplyFromAny(results), nil
+}
+
+// parseFieldExpireAt parses a field expiration given in units of msPerUnit
+// milliseconds, relative to now unless absolute, and returns it as a Unix
+// time in milliseconds
+func parseFieldExpireAt(ctx *command.Context, arg string, msPerUnit int64, absolute bool) (int64, error) {
+	t, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errors.New("ERR value is not an integer or out of range")
+	}
+
+	now := time.Now().UnixMilli()
+	if t < 0 || t > (math.MaxInt64-now)/msPerUnit {
+		return 0, errors.New("ERR invalid expire time in '" + strings.ToLower(ctx.CmdName) + "' command")
+	}
+	at := t * msPerUnit
+	if !absolute {
+		at += now
+	}
+	return at, nil
+}
+
+// propagateFieldExpire propagates the expiration at of the updated fields
+// as HPEXPIREAT, and the fields it deleted as HDEL
+func propagateFieldExpire(ctx *command.Context, key string, at int64, updated, deleted []string) {
+	if len(updated) > 0 {
+		args := append([]string{key, strconv.FormatInt(at, 10), "FIELDS", strconv.Itoa(len(updated))}, updated...)
+		ctx.Propagate("HPEXPIREAT", args...)
+	}
+	if len(deleted) > 0 {
+		ctx.Propagate("HDEL", append([]string{key}, deleted...)...)
+	}
+	if len(updated) == 0 && len(deleted) == 0 {
+		ctx.PropagateNothing()
+	}
+}
+
+// HTTL key FIELDS numfields field [field ...]
+func httlCmd(ctx *command.Context) (*command.Reply, error) {
+	return hfieldTTLGeneric(ctx, func(at, now int64) int64 {
+		return (at - now + 999) / 1000
+	})
+}
+
+// HPTTL key FIELDS numfields field [field ...]
+func hpttlCmd(ctx *command.Context) (*command.Reply, error) {
+	return hfieldTTLGeneric(ctx, func(at, now int64) int64 {
+		return at - now
+	})
+}
+
+// HEXPIRETIME key FIELDS numfields field [field ...]
+func hexpiretimeCmd(ctx *command.Context) (*command.Reply, error) {
+	return hfieldTTLGeneric(ctx, func(at, now int64) int64 {
+		return at / 1000
+	})
+}
+
+// HPEXPIRETIME key FIELDS numfields field [field ...]
+func hpexpiretimeCmd(ctx *command.Context) (*command.Reply, error) {
+	return hfieldTTLGeneric(ctx, func(at, now int64) int64 {
+		return at
+	})
+}
+
+// hfieldTTLGeneric replies with the expiration of each field, converted
+// by format from its Unix time in milliseconds, or -2 if the field does not
+// exist and -1 if it has no expiration
+func hfieldTTLGeneric(ctx *command.Context, format func(at, now int64) int64) (*command.Reply, error) {
+	key := ctx.Args[0]
+	fields, err := parseHashFields(ctx.Args[1:])
+	if err != nil {
+		return command.NewErrorReply(err), nil
+	}
+
+	h, err := lookupHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UnixMilli()
+	results := make([]interface{}, len(fields))
+	for i, field := range fields {
+		at := int64(hash.FieldMissing)
+		if h != nil {
+			at = h.FieldExpireAt(field)
+		}
+		if at >= 0 {
+			at = format(at, now)
+		}
+		results[i] = at
+	}
+
+	if h != nil {
+		dropEmptyHash(ctx, key, h)
+	}
+
+	return command.NewArrayReplyFromAny(results), nil
+}
+
+// HPERSIST key FIELDS numfields field [field ...]
+func hpersistCmd(ctx *command.Context) (*command.Reply, error) {
+	key := ctx.Args[0]
+	fields, err := parseHashFields(ctx.Args[1:])
+	if err != nil {
+		return command.NewErrorReply(err), nil
+	}
+
+	h, err := lookupHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]interface{}, len(fields))
+	var persisted []string
+	for i, field := range fields {
+		result := hash.FieldMissing
+		if h != nil {
+			result = h.PersistField(field)
+		}
+		if result == hash.FieldUpdated {
+			persisted = append(persisted, field)
+		}
+		results[i] = int64(result)
+	}
+
+	if h != nil {
+		dropEmptyHash(ctx, key, h)
+	}
+
+	if len(persisted) > 0 {
+		args := append([]string{key, "FIELDS", strconv.Itoa(len(persisted))}, persisted...)
+		ctx.Propagate("HPERSIST", args...)
+	} else {
+		ctx.PropagateNothing()
+	}
+
+	return command.NewArrayReplyFromAny(results), nil
+}
+
+// HGETDEL key FIELDS numfields field [field ...]
+func hgetdelCmd(ctx *command.Context) (*command.Reply, error) {
+	key := ctx.Args[0]
+	fields, err := parseHashFields(ctx.Args[1:])
+	if err != nil {
+		return command.NewErrorReply(err), nil
+	}
+
+	h, err := lookupHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		ctx.PropagateNothing()
+		return command.NewArrayReplyFromAny(make([]interface{}, len(fields))), nil
+	}
+
+	values := h.MGet(fields)
+	var deleted []string
+	for i, field := range fields {
+		if values[i] != nil && h.Del(field) > 0 {
+			deleted = append(deleted, field)
+		}
+	}
+	dropEmptyHash(ctx, key, h)
+
+	if len(deleted) > 0 {
+		ctx.Propagate("HDEL", append([]string{key}, deleted...)...)
+	} else {
+		ctx.PropagateNothing()
+	}
+
+	return command.NewArrayReplyFromAny(values), nil
+}
+
+// HGETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST] FIELDS numfields field [field ...]
+func hgetexCmd(ctx *command.Context) (*command.Reply, error) {
+	key := ctx.Args[0]
+	args := ctx.Args[1:]
+
+	option := strings.ToUpper(args[0])
+	var at int64
+	switch option {
+	case "EX", "PX", "EXAT", "PXAT":
+		msPerUnit := int64(1)
+		if option == "EX" || option == "EXAT" {
+			msPerUnit = 1000
+		}
+		var err error
+		if at, err = parseFieldExpireAt(ctx, args[1], msPerUnit, strings.HasSuffix(option, "AT")); err != nil {
+			return command.NewErrorReply(err), nil
+		}
+		args = args[2:]
+	case "PERSIST":
+		args = args[1:]
+	default:
+		option = ""
+	}
+
+	fields, err := parseHashFields(args)
+	if err != nil {
+		return command.NewErrorReply(err), nil
+	}
+
+	h, err := lookupHash(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	if h == nil {
+		ctx.PropagateNothing()
+		return command.NewArrayReplyFromAny(make([]interface{}, len(fields))), nil
+	}
+
+	values := h.MGet(fields)
+	var updated, deleted []string
+	for i, field := range fields {
+		if values[i] == nil || option == "" {
+			continue
+		}
+		if option == "PERSIST" {
+			if h.PersistField(field) == hash.FieldUpdated {
+				updated = append(updated, field)
+			}
+			continue
+		}
+		switch h.SetFieldExpireAt(field, at) {
+		case hash.FieldUpdated:
+			updated = append(updated, field)
+		case hash.FieldDeleted:
+			deleted = append(deleted, field)
+		}
+	}
+	dropEmptyHash(ctx, key, h)
+
+	if option == "PERSIST" {
+		if len(updated) > 0 {
+			ctx.Propagate("HPERSIST", append([]string{key, "FIELDS", strconv.Itoa(len(updated))}, updated...)...)
+		} else {
+			ctx.PropagateNothing()
+		}
+	} else {
+		propagateFieldExpire(ctx, key, at, updated, deleted)
+	}
+
+	return command.NewArrayReplyFromAny(values), nil
+}
+
+// parseHashFields parses the FIELDS numfields field [field ...] block of
+// the hash field expiration commands
+func parseHashFields(args []string) ([]string, error) {
+	if len(args) < 2 || strings.ToUpper(args[0]) != "FIELDS" {
+		return nil, errors.New("ERR Mandatory argument FIELDS is missing or not at the right position")
+	}
+	n, err := strconv.Atoi(args[1])
+	if err != nil || n <= 0 {
+		return nil, errors.New("ERR Parameter `numFields` should be greater than 0")
+	}
+	if n != len(args)-2 {
+		return nil, errors.New("ERR The `numfields` parameter must match the number of arguments")
+	}
+	return args[2:], nil
+}
+
+// lookupHash returns the hash stored at key, or nil if the key does not exist
+func lookupHash(ctx *command.Context, key string) (*hash.Hash, error) {
+	obj, ok := ctx.DB.Get(key)
+	if !ok {
+		return nil, nil
+	}
+
+	if obj.Type != database.ObjTypeHash {
+		return nil, errors.New("wrong type operation against a key holding another kind of value")
+	}
+
+	h, ok := obj.Ptr.(*hash.Hash)
+	if !ok {
+		return nil, errors.New("internal error: not a hash object")
+	}
+	return h, nil
+}
+
+// dropEmptyHash deletes key once field expiration has removed every field
+// of its hash
+func dropEmptyHash(ctx *command.Context, key string, h *hash.Hash) {
+	if h.Len() == 0 {
+		ctx.DB.Delete(key)
+	}
+}
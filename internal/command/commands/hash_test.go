@@ -0,0 +1,290 @@
+package commands
+
+import (
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+// newHashDB returns a DB holding fields a, b and c under "h"
+func newHashDB(t *testing.T) *database.DB {
+	t.Helper()
+
+	db := database.NewDB(0)
+	if _, err := hsetCmd(newTestContext(t, db, "h", "a", "1", "b", "2", "c", "3")); err != nil {
+		t.Fatalf("HSET failed: %v", err)
+	}
+	return db
+}
+
+func TestHashFieldExpiresIndependently(t *testing.T) {
+	db := newHashDB(t)
+
+	reply, err := hpexpireCmd(newTestContext(t, db, "h", "50", "FIELDS", "2", "a", "missing"))
+	if err != nil {
+		t.Fatalf("HPEXPIRE failed: %v", err)
+	}
+	if want := []interface{}{int64(1), int64(-2)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HPEXPIRE expected %v, got %v", want, reply.Value)
+	}
+
+	reply, _ = httlCmd(newTestContext(t, db, "h", "FIELDS", "3", "a", "b", "missing"))
+	if want := []interface{}{int64(1), int64(-1), int64(-2)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HTTL expected %v, got %v", want, reply.Value)
+	}
+
+	time.Sleep(60 * time.Millisecond)
+
+	if reply, _ := hgetCmd(newTestContext(t, db, "h", "a")); !reply.IsNil() {
+		t.Errorf("HGET of an expired field expected nil, got %v", reply.Value)
+	}
+	reply, _ = hmgetCmd(newTestContext(t, db, "h", "a", "b", "c"))
+	if want := []interface{}{nil, "2", "3"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HMGET expected %v, got %v", want, reply.Value)
+	}
+	if reply, _ := hlenCmd(newTestContext(t, db, "h")); reply.Value != int64(2) {
+		t.Errorf("HLEN expected 2, got %v", reply.Value)
+	}
+}
+
+func TestHashFieldExpireCommands(t *testing.T) {
+	db := newHashDB(t)
+
+	at := time.Now().Add(time.Hour).Unix()
+	ctx := newTestContext(t, db, "h", strconv.FormatInt(at, 10), "FIELDS", "1", "a")
+	ctx.CmdName = "HEXPIREAT"
+	if reply, _ := hexpireatCmd(ctx); !reflect.DeepEqual(reply.Value, []interface{}{int64(1)}) {
+		t.Fatalf("HEXPIREAT expected [1], got %v", reply.Value)
+	}
+	if got := ctx.Propagation(); len(got) != 1 || got[0][0] != "HPEXPIREAT" || got[0][2] != strconv.FormatInt(at*1000, 10) {
+		t.Errorf("HEXPIREAT expected to propagate HPEXPIREAT at %d000, got %v", at, got)
+	}
+
+	reply, _ := hexpiretimeCmd(newTestContext(t, db, "h", "FIELDS", "2", "a", "b"))
+	if want := []interface{}{at, int64(-1)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HEXPIRETIME expected %v, got %v", want, reply.Value)
+	}
+
+	reply, _ = hpersistCmd(newTestContext(t, db, "h", "FIELDS", "3", "a", "b", "missing"))
+	if want := []interface{}{int64(1), int64(-1), int64(-2)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HPERSIST expected %v, got %v", want, reply.Value)
+	}
+
+	// Overwriting a field clears its expiration
+	hexpireCmd(newTestContext(t, db, "h", "100", "FIELDS", "1", "b"))
+	hsetCmd(newTestContext(t, db, "h", "b", "new"))
+	reply, _ = httlCmd(newTestContext(t, db, "h", "FIELDS", "1", "b"))
+	if want := []interface{}{int64(-1)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HTTL after HSET expected %v, got %v", want, reply.Value)
+	}
+
+	// A zero TTL deletes fields right away, and the key with its last field
+	ctx = newTestContext(t, db, "h", "0", "FIELDS", "3", "a", "b", "c")
+	reply, _ = hexpireCmd(ctx)
+	if want := []interface{}{int64(2), int64(2), int64(2)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HEXPIRE 0 expected %v, got %v", want, reply.Value)
+	}
+	if got := ctx.Propagation(); len(got) != 1 || got[0][0] != "HDEL" {
+		t.Errorf("HEXPIRE 0 expected to propagate HDEL, got %v", got)
+	}
+	if db.Exists("h") != 0 {
+		t.Error("hash expected deleted with its last field")
+	}
+
+	for _, args := range [][]string{
+		{"h", "10", "FIELDS", "2", "a"},
+		{"h", "10", "FIELDS", "0"},
+		{"h", "10", "a"},
+		{"h", "-1", "FIELDS", "1", "a"},
+	} {
+		if reply, _ := hexpireCmd(newTestContext(t, db, args...)); !reply.IsError() {
+			t.Errorf("HEXPIRE %v expected error, got %v", args, reply.Value)
+		}
+	}
+}
+
+func TestHScanNoValues(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 0; i < 20; i++ {
+		f := "f" + strconv.Itoa(i)
+		if _, err := hsetCmd(newTestContext(t, db, "h", f, "v"+strconv.Itoa(i))); err != nil {
+			t.Fatalf("HSET failed: %v", err)
+		}
+	}
+
+	scan := func(args ...string) []string {
+		t.Helper()
+		reply, err := hscanCmd(newTestContext(t, db, append([]string{"h", "0", "COUNT", "100"}, args...)...))
+		if err != nil {
+			t.Fatalf("HSCAN %v failed: %v", args, err)
+		}
+		items := reply.Value.([]*command.Reply)
+		if cursor := items[0].Value.(string); cursor != "0" {
+			t.Fatalf("HSCAN %v expected cursor 0, got %s", args, cursor)
+		}
+		return items[1].Value.([]string)
+	}
+
+	withValues := scan()
+	fields := scan("novalues")
+	if len(withValues) != 40 || len(fields) != 20 {
+		t.Fatalf("expected 40 and 20 items, got %d and %d", len(withValues), len(fields))
+	}
+
+	// Iteration order may differ between calls; compare the field sets
+	var pairedFields []string
+	for i := 0; i < len(withValues); i += 2 {
+		if want := "v" + strings.TrimPrefix(withValues[i], "f"); withValues[i+1] != want {
+			t.Errorf("field %s expected value %s, got %s", withValues[i], want, withValues[i+1])
+		}
+		pairedFields = append(pairedFields, withValues[i])
+	}
+	slices.Sort(pairedFields)
+	slices.Sort(fields)
+	if !slices.Equal(fields, pairedFields) {
+		t.Errorf("NOVALUES fields %v differ from HSCAN fields %v", fields, pairedFields)
+	}
+}
+
+func TestListpackHashKeepsInsertionOrder(t *testing.T) {
+	db := database.NewDB(0)
+	if _, err := hsetCmd(newTestContext(t, db, "h", "z", "1", "a", "2", "m", "3")); err != nil {
+		t.Fatalf("HSET failed: %v", err)
+	}
+	if enc := objectEncodingOf(t, db, "h"); enc != "listpack" {
+		t.Fatalf("small hash expected listpack, got %s", enc)
+	}
+
+	reply, _ := hkeysCmd(newTestContext(t, db, "h"))
+	if got := reply.Value.([]string); !slices.Equal(got, []string{"z", "a", "m"}) {
+		t.Errorf("HKEYS expected insertion order, got %v", got)
+	}
+	reply, _ = hgetallCmd(newTestContext(t, db, "h"))
+	if got := reply.Value.([]string); !slices.Equal(got, []string{"z", "1", "a", "2", "m", "3"}) {
+		t.Errorf("HGETALL expected insertion order, got %v", got)
+	}
+}
+
+func TestListpackHashOrderSurvivesReload(t *testing.T) {
+	selector := setupPersistence(t)
+	db, _ := selector.GetDB(0)
+	for i := 0; i < 20; i++ {
+		hsetCmd(newTestContext(t, db, "h", "f"+strconv.Itoa(19-i), strconv.Itoa(i)))
+	}
+	// A field added again after HDEL moves to the end
+	hdelCmd(newTestContext(t, db, "h", "f19"))
+	hsetCmd(newTestContext(t, db, "h", "f19", "again"))
+
+	reply, _ := hkeysCmd(newTestContext(t, db, "h"))
+	want := reply.Value.([]string)
+	if want[0] != "f18" || want[len(want)-1] != "f19" {
+		t.Fatalf("HKEYS expected insertion order, got %v", want)
+	}
+
+	if reply, _ := debugCmd(newTestContext(t, db, "RELOAD", "DB", "0")); reply.IsError() {
+		t.Fatalf("DEBUG RELOAD failed: %v", reply.Value)
+	}
+	reply, _ = hkeysCmd(newTestContext(t, db, "h"))
+	if got := reply.Value.([]string); !slices.Equal(got, want) {
+		t.Errorf("HKEYS after reload expected %v, got %v", want, got)
+	}
+}
+
+func TestHSetCountsOnlyNewFields(t *testing.T) {
+	db := database.NewDB(0)
+	reply, err := hsetCmd(newTestContext(t, db, "h", "a", "1", "b", "2"))
+	if err != nil {
+		t.Fatalf("HSET failed: %v", err)
+	}
+	if reply.Value != int64(2) {
+		t.Errorf("first HSET expected 2, got %v", reply.Value)
+	}
+
+	reply, err = hsetCmd(newTestContext(t, db, "h", "a", "10", "b", "20", "c", "30"))
+	if err != nil {
+		t.Fatalf("HSET failed: %v", err)
+	}
+	if reply.Value != int64(1) {
+		t.Errorf("HSET updating a and b and adding c expected 1, got %v", reply.Value)
+	}
+
+	reply, _ = hsetCmd(newTestContext(t, db, "h", "a", "100"))
+	if reply.Value != int64(0) {
+		t.Errorf("HSET overwriting a expected 0, got %v", reply.Value)
+	}
+	if v, _ := hgetCmd(newTestContext(t, db, "h", "a")); v.Value != "100" {
+		t.Errorf("HGET a expected 100, got %v", v.Value)
+	}
+}
+
+func TestHGetDel(t *testing.T) {
+	db := newHashDB(t)
+
+	ctx := newTestContext(t, db, "h", "FIELDS", "3", "a", "missing", "c")
+	reply, err := hgetdelCmd(ctx)
+	if err != nil {
+		t.Fatalf("HGETDEL failed: %v", err)
+	}
+	if want := []interface{}{"1", nil, "3"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HGETDEL expected %v, got %v", want, reply.Value)
+	}
+	if got := ctx.Propagation(); len(got) != 1 || !slices.Equal(got[0], []string{"HDEL", "h", "a", "c"}) {
+		t.Errorf("HGETDEL expected to propagate HDEL h a c, got %v", got)
+	}
+
+	reply, _ = hgetallCmd(newTestContext(t, db, "h"))
+	if got := reply.Value.([]string); !slices.Equal(got, []string{"b", "2"}) {
+		t.Errorf("HGETDEL expected to leave only b, got %v", got)
+	}
+
+	// Deleting the last field deletes the key
+	hgetdelCmd(newTestContext(t, db, "h", "FIELDS", "1", "b"))
+	if db.Exists("h") != 0 {
+		t.Error("hash expected deleted with its last field")
+	}
+}
+
+func TestHGetEx(t *testing.T) {
+	db := newHashDB(t)
+
+	ctx := newTestContext(t, db, "h", "PX", "50000", "FIELDS", "2", "a", "missing")
+	ctx.CmdName = "HGETEX"
+	reply, err := hgetexCmd(ctx)
+	if err != nil {
+		t.Fatalf("HGETEX failed: %v", err)
+	}
+	if want := []interface{}{"1", nil}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HGETEX expected %v, got %v", want, reply.Value)
+	}
+	if got := ctx.Propagation(); len(got) != 1 || got[0][0] != "HPEXPIREAT" {
+		t.Errorf("HGETEX PX expected to propagate HPEXPIREAT, got %v", got)
+	}
+	reply, _ = hpttlCmd(newTestContext(t, db, "h", "FIELDS", "1", "a"))
+	if ttl := reply.Value.([]interface{})[0].(int64); ttl <= 0 || ttl > 50000 {
+		t.Errorf("HPTTL after HGETEX PX 50000 expected within (0, 50000], got %d", ttl)
+	}
+
+	reply, _ = hgetexCmd(newTestContext(t, db, "h", "PERSIST", "FIELDS", "1", "a"))
+	if want := []interface{}{"1"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HGETEX PERSIST expected %v, got %v", want, reply.Value)
+	}
+	reply, _ = httlCmd(newTestContext(t, db, "h", "FIELDS", "1", "a"))
+	if want := []interface{}{int64(-1)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HTTL after HGETEX PERSIST expected %v, got %v", want, reply.Value)
+	}
+
+	// A time in the past returns the value and deletes the field
+	reply, _ = hgetexCmd(newTestContext(t, db, "h", "EXAT", "1", "FIELDS", "1", "b"))
+	if want := []interface{}{"2"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("HGETEX EXAT 1 expected %v, got %v", want, reply.Value)
+	}
+	if reply, _ := hexistsCmd(newTestContext(t, db, "h", "b")); reply.Value != int64(0) {
+		t.Error("HGETEX EXAT in the past expected to delete the field")
+	}
+}
@@ -8,8 +8,10 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/persistence/rdb"
 )
 
 // RegisterKeyCommands registers all key management commands
@@ -104,6 +106,26 @@ func RegisterKeyCommands(disp Dispatcher) {
 		Categories: []string{command.CatKey},
 	})
 
+	disp.Register(&command.Command{
+		Name:       "PEXPIRE",
+		Handler:    pexpireCmd,
+		Arity:      3,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatKey},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "PEXPIREAT",
+		Handler:    pexpireatCmd,
+		Arity:      3,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatKey},
+	})
+
 	disp.Register(&command.Command{
 		Name:       "TTL",
 		Handler:    ttlCmd,
@@ -163,6 +185,36 @@ func RegisterKeyCommands(disp Dispatcher) {
 		LastKey:    0,
 		Categories: []string{command.CatKey},
 	})
+
+	disp.Register(&command.Command{
+		Name:       "DUMP",
+		Handler:    dumpCmd,
+		Arity:      2,
+		Flags:      []string{command.FlagReadOnly},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatKey},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "RESTORE",
+		Handler:    restoreCmd,
+		Arity:      -4,
+		Flags:      []string{command.FlagWrite, command.FlagDenyOOM},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatKey},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "COPY",
+		Handler:    copyCmd,
+		Arity:      -3,
+		Flags:      []string{command.FlagWrite, command.FlagDenyOOM},
+		FirstKey:   1,
+		LastKey:    2,
+		Categories: []string{command.CatKey},
+	})
 }
 
 // DEL key [key ...]
@@ -180,7 +232,7 @@ func existsCmd(ctx *command.Context) (*command.Reply, error) {
 // TYPE key
 func typeCmd(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[0]
-	return command.NewBulkStringReply(ctx.DB.Type(key)), nil
+	return command.NewStatusReply(ctx.DB.Type(key)), nil
 }
 
 // KEYS pattern
@@ -247,8 +299,10 @@ func expireCmd(ctx *command.Context) (*command.Reply, error) {
 
 	ok := ctx.DB.Expire(key, seconds)
 	if ok {
+		propagateExpire(ctx, key)
 		return command.NewIntegerReply(1), nil
 	}
+	ctx.PropagateNothing()
 	return command.NewIntegerReply(0), nil
 }
 
@@ -270,6 +324,54 @@ func expireatCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewIntegerReply(0), nil
 }
 
+// PEXPIRE key milliseconds
+func pexpireCmd(ctx *command.Context) (*command.Reply, error) {
+	if len(ctx.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments")
+	}
+	key := ctx.Args[0]
+	ms, err := strconv.ParseInt(ctx.Args[1], 10, 64)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+
+	ok := ctx.DB.PExpireAt(key, time.Now().UnixMilli()+ms)
+	if ok {
+		propagateExpire(ctx, key)
+		return command.NewIntegerReply(1), nil
+	}
+	ctx.PropagateNothing()
+	return command.NewIntegerReply(0), nil
+}
+
+// PEXPIREAT key milliseconds-timestamp
+func pexpireatCmd(ctx *command.Context) (*command.Reply, error) {
+	if len(ctx.Args) < 2 {
+		return nil, fmt.Errorf("wrong number of arguments")
+	}
+	key := ctx.Args[0]
+	timestamp, err := strconv.ParseInt(ctx.Args[1], 10, 64)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+
+	ok := ctx.DB.PExpireAt(key, timestamp)
+	if ok {
+		return command.NewIntegerReply(1), nil
+	}
+	return command.NewIntegerReply(0), nil
+}
+
+// propagateExpire logs a relative expiration to the AOF as the absolute
+// time it resolved to, or as a DEL when it expired the key outright
+func propagateExpire(ctx *command.Context, key string) {
+	if at, ok := ctx.DB.PExpireTime(key); ok {
+		ctx.Propagate("PEXPIREAT", key, strconv.FormatInt(at, 10))
+		return
+	}
+	ctx.Propagate("DEL", key)
+}
+
 // TTL key
 func ttlCmd(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[0]
@@ -386,3 +488,144 @@ func scanCmd(ctx *command.Context) (*command.Reply, error) {
 
 	return command.NewArrayReply(arr), nil
 }
+
+// DUMP key
+func dumpCmd(ctx *command.Context) (*command.Reply, error) {
+	obj, ok := ctx.DB.Get(ctx.Args[0])
+	if !ok {
+		return command.NewNilReply(), nil
+	}
+
+	data, err := rdb.DumpObject(obj)
+	if err != nil {
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR %v", err)), nil
+	}
+
+	return command.NewBulkStringReply(string(data)), nil
+}
+
+// RESTORE key ttl serialized-value [REPLACE] [ABSTTL]
+func restoreCmd(ctx *command.Context) (*command.Reply, error) {
+	key := ctx.Args[0]
+
+	ttl, err := strconv.ParseInt(ctx.Args[1], 10, 64)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+	if ttl < 0 {
+		return command.NewErrorReplyStr("ERR Invalid TTL value, must be >= 0"), nil
+	}
+
+	replace := false
+	absTTL := false
+	for _, arg := range ctx.Args[3:] {
+		switch strings.ToUpper(arg) {
+		case "REPLACE":
+			replace = true
+		case "ABSTTL":
+			absTTL = true
+		default:
+			return command.NewErrorReplyStr("ERR syntax error"), nil
+		}
+	}
+
+	if !replace && ctx.DB.Exists(key) > 0 {
+		return command.NewErrorReplyStr("BUSYKEY Target key name already exists."), nil
+	}
+
+	obj, err := rdb.RestoreObject([]byte(ctx.Args[2]))
+	if err != nil {
+		return command.NewErrorReplyStr("ERR " + err.Error()), nil
+	}
+
+	// ttl is in milliseconds, relative unless ABSTTL
+	var expireAt int64
+	if ttl > 0 {
+		expireAt = ttl
+		if !absTTL {
+			expireAt += time.Now().UnixMilli()
+		}
+
+		// An already expired key is not created, but REPLACE still removes
+		// the old value
+		if expireAt <= time.Now().UnixMilli() {
+			ctx.DB.Delete(key)
+			ctx.Propagate("DEL", key)
+			return command.NewStatusReply("OK"), nil
+		}
+	}
+
+	ctx.DB.Set(key, obj)
+	if expireAt > 0 {
+		ctx.DB.PExpireAt(key, expireAt)
+	} else {
+		ctx.DB.Persist(key)
+	}
+
+	// A relative TTL is logged as the absolute time it resolved to
+	if ttl > 0 && !absTTL {
+		ctx.Propagate("RESTORE", key, strconv.FormatInt(expireAt, 10), ctx.Args[2], "REPLACE", "ABSTTL")
+	}
+
+	return command.NewStatusReply("OK"), nil
+}
+
+// COPY source destination [DB destination-db] [REPLACE]
+func copyCmd(ctx *command.Context) (*command.Reply, error) {
+	src := ctx.Args[0]
+	dst := ctx.Args[1]
+
+	dstDB := ctx.DB
+	replace := false
+	for i := 2; i < len(ctx.Args); i++ {
+		switch strings.ToUpper(ctx.Args[i]) {
+		case "REPLACE":
+			replace = true
+		case "DB":
+			if i+1 >= len(ctx.Args) {
+				return command.NewErrorReplyStr("ERR syntax error"), nil
+			}
+			i++
+			index, err := parseDBIndex(ctx.Args[i])
+			if err != nil {
+				return command.NewErrorReply(err), nil
+			}
+			if index != ctx.DB.GetID() {
+				if dbSelector == nil {
+					return command.NewErrorReplyStr("ERR DB index is out of range"), nil
+				}
+				if dstDB, err = dbSelector.GetDB(index); err != nil {
+					return command.NewErrorReply(err), nil
+				}
+			}
+		default:
+			return command.NewErrorReplyStr("ERR syntax error"), nil
+		}
+	}
+
+	if src == dst && dstDB == ctx.DB {
+		return command.NewErrorReplyStr("ERR source and destination objects are the same"), nil
+	}
+
+	obj, ok := ctx.DB.Get(src)
+	if !ok {
+		return command.NewIntegerReply(0), nil
+	}
+	if !replace && dstDB.Exists(dst) > 0 {
+		return command.NewIntegerReply(0), nil
+	}
+
+	clone, err := obj.DeepCopy()
+	if err != nil {
+		return command.NewErrorReplyStr("ERR " + err.Error()), nil
+	}
+
+	dstDB.Set(dst, clone)
+	if expireAt, ok := ctx.DB.PExpireTime(src); ok {
+		dstDB.PExpireAt(dst, expireAt)
+	} else {
+		dstDB.Persist(dst)
+	}
+
+	return command.NewIntegerReply(1), nil
+}
@@ -0,0 +1,365 @@
+package commands
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/hash"
+	"github.com/zyhnesmr/godis/internal/datastruct/list"
+	"github.com/zyhnesmr/godis/internal/datastruct/set"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
+	"github.com/zyhnesmr/godis/internal/datastruct/zset"
+)
+
+func TestDumpRestoreZSet(t *testing.T) {
+	db := database.NewDB(0)
+	zobj := database.NewZSetObject()
+	zs := zobj.Ptr.(*zset.ZSet)
+	zs.Add("one", 1)
+	zs.Add("two", 2.5)
+	zs.Add("three", -3)
+	db.Set("src", zobj)
+
+	reply, _ := dumpCmd(newTestContext(t, db, "src"))
+	payload, ok := reply.Value.(string)
+	if !ok || payload == "" {
+		t.Fatalf("DUMP expected a payload, got %v", reply.Value)
+	}
+
+	reply, _ = restoreCmd(newTestContext(t, db, "dst", "0", payload))
+	if reply.IsError() {
+		t.Fatalf("RESTORE failed: %v", reply.Value)
+	}
+
+	obj, ok := db.Get("dst")
+	if !ok || obj.Type != database.ObjTypeZSet {
+		t.Fatal("RESTORE did not create a zset")
+	}
+	restored := obj.Ptr.(*zset.ZSet)
+	if restored.Len() != 3 {
+		t.Errorf("restored zset expected 3 members, got %d", restored.Len())
+	}
+	for _, m := range zs.Range(0, -1) {
+		if score, ok := restored.Score(m.Member); !ok || score != m.Score {
+			t.Errorf("restored member %s expected score %v, got %v", m.Member, m.Score, score)
+		}
+	}
+	if ttl := db.TTL("dst"); ttl != -1 {
+		t.Errorf("RESTORE with ttl 0 expected no expiry, got %d", ttl)
+	}
+
+	// Existing key without REPLACE
+	reply, _ = restoreCmd(newTestContext(t, db, "dst", "0", payload))
+	if !reply.IsError() || !strings.HasPrefix(reply.Value.(string), "BUSYKEY") {
+		t.Errorf("RESTORE over existing key expected BUSYKEY, got %v", reply.Value)
+	}
+
+	reply, _ = restoreCmd(newTestContext(t, db, "dst", "5000", payload, "REPLACE"))
+	if reply.IsError() {
+		t.Fatalf("RESTORE REPLACE failed: %v", reply.Value)
+	}
+	if ttl := db.TTL("dst"); ttl < 5 || ttl > 6 {
+		t.Errorf("RESTORE with ttl 5000 expected TTL about 5, got %d", ttl)
+	}
+
+	// Corrupt the checksum
+	bad := payload[:len(payload)-1] + string(payload[len(payload)-1]^0xff)
+	reply, _ = restoreCmd(newTestContext(t, db, "other", "0", bad))
+	if !reply.IsError() {
+		t.Error("RESTORE with corrupted payload expected error")
+	}
+	if db.Exists("other") != 0 {
+		t.Error("RESTORE with corrupted payload created the key")
+	}
+}
+
+func TestRestoreKeepsTTLToTheMillisecond(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("src", database.NewStringObject("v"))
+	reply, _ := dumpCmd(newTestContext(t, db, "src"))
+	payload := reply.Value.(string)
+
+	// A sub-second TTL must neither truncate to an immediate expiry nor
+	// be rounded up
+	before := time.Now().UnixMilli()
+	reply, _ = restoreCmd(newTestContext(t, db, "rel", "500", payload))
+	if reply.IsError() {
+		t.Fatalf("RESTORE failed: %v", reply.Value)
+	}
+	now := time.Now().UnixMilli()
+	reply, _ = restoreCmd(newTestContext(t, db, "abs", strconv.FormatInt(now+500, 10), payload, "ABSTTL"))
+	if reply.IsError() {
+		t.Fatalf("RESTORE ABSTTL failed: %v", reply.Value)
+	}
+	if at, ok := db.PExpireTime("rel"); !ok || at < before+500 || at > now+500 {
+		t.Errorf("RESTORE 500 expected to expire 500ms after it ran, got %d", at)
+	}
+	if at, ok := db.PExpireTime("abs"); !ok || at != now+500 {
+		t.Errorf("RESTORE ABSTTL expected to expire at %d, got %d", now+500, at)
+	}
+
+	// An absolute deadline already passed, if only just, is expired
+	reply, _ = restoreCmd(newTestContext(t, db, "past", strconv.FormatInt(time.Now().UnixMilli()-1, 10), payload, "ABSTTL"))
+	if reply.IsError() {
+		t.Fatalf("RESTORE ABSTTL in the past failed: %v", reply.Value)
+	}
+	if db.Exists("past") != 0 {
+		t.Error("RESTORE ABSTTL in the past expected not to create the key")
+	}
+}
+
+func TestExpireOnMissingKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler command.Handler
+		args    []string
+	}{
+		{"EXPIRE", expireCmd, []string{"missing", "100"}},
+		{"PEXPIRE", pexpireCmd, []string{"missing", "100000"}},
+		{"EXPIREAT", expireatCmd, []string{"missing", "4102444800"}},
+		{"PEXPIREAT", pexpireatCmd, []string{"missing", "4102444800000"}},
+		{"PERSIST", persistCmd, []string{"missing"}},
+	}
+
+	for _, tt := range tests {
+		db := database.NewDB(0)
+		reply, err := tt.handler(newTestContext(t, db, tt.args...))
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.name, err)
+		}
+		if reply.Value != int64(0) {
+			t.Errorf("%s on a missing key expected 0, got %v", tt.name, reply.Value)
+		}
+
+		reply, _ = existsCmd(newTestContext(t, db, "missing"))
+		if reply.Value != int64(0) {
+			t.Errorf("EXISTS after %s expected 0, got %v", tt.name, reply.Value)
+		}
+		if n := db.DBSize(); n != 0 {
+			t.Errorf("%s changed the keyspace, %d keys", tt.name, n)
+		}
+	}
+}
+
+func TestPexpireKeepsMilliseconds(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("k", database.NewStringObject("v"))
+
+	before := time.Now().UnixMilli()
+	if reply, _ := pexpireCmd(newTestContext(t, db, "k", "1500")); reply.Value != int64(1) {
+		t.Fatalf("PEXPIRE expected 1, got %v", reply.Value)
+	}
+	at, ok := db.PExpireTime("k")
+	if !ok || at < before+1500 || at > time.Now().UnixMilli()+1500 {
+		t.Errorf("PEXPIRE 1500 expected to expire 1500ms from now, got %d", at)
+	}
+	reply, _ := pttlCmd(newTestContext(t, db, "k"))
+	if pttl := reply.Value.(int64); pttl <= 1400 || pttl > 1500 {
+		t.Errorf("PTTL after PEXPIRE 1500 expected up to 1500, got %d", pttl)
+	}
+
+	deadline := time.Now().UnixMilli() + 1234
+	pexpireatCmd(newTestContext(t, db, "k", strconv.FormatInt(deadline, 10)))
+	if at, ok := db.PExpireTime("k"); !ok || at != deadline {
+		t.Errorf("PEXPIREAT expected to expire at %d, got %d", deadline, at)
+	}
+
+	// A deadline a few milliseconds ahead expires the key once it passes
+	pexpireCmd(newTestContext(t, db, "k", "20"))
+	if db.Exists("k") != 1 {
+		t.Error("PEXPIRE 20 expected the key to exist until the deadline")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if db.Exists("k") != 0 {
+		t.Error("PEXPIRE 20 expected the key to expire after 30ms")
+	}
+}
+
+func TestExpireOnExpiredKey(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("k", database.NewStringObject("v"))
+	db.ExpireAt("k", time.Now().Unix()-1)
+
+	reply, _ := expireCmd(newTestContext(t, db, "k", "100"))
+	if reply.Value != int64(0) {
+		t.Errorf("EXPIRE on an expired key expected 0, got %v", reply.Value)
+	}
+	reply, _ = existsCmd(newTestContext(t, db, "k"))
+	if reply.Value != int64(0) {
+		t.Errorf("EXPIRE revived an expired key")
+	}
+}
+
+// newAllTypesDB returns a DB holding one key of every type, named after it
+func newAllTypesDB() *database.DB {
+	db := database.NewDB(0)
+	db.Set("string", database.NewStringObject("value"))
+
+	lobj := database.NewListObject()
+	for _, v := range []string{"a", "b", "c"} {
+		lobj.Ptr.(*list.List).PushRight(v)
+	}
+	db.Set("list", lobj)
+
+	db.Set("set", database.NewSetObjectFromSlice([]string{"x", "y", "z"}))
+
+	hobj := database.NewHashObject()
+	hobj.Ptr.(*hash.Hash).Set("f1", "v1")
+	hobj.Ptr.(*hash.Hash).Set("f2", "v2")
+	db.Set("hash", hobj)
+
+	zobj := database.NewZSetObject()
+	zobj.Ptr.(*zset.ZSet).Add("m1", 1.5)
+	zobj.Ptr.(*zset.ZSet).Add("m2", -2)
+	db.Set("zset", zobj)
+
+	sobj := database.NewStreamObject()
+	strm := sobj.Ptr.(*stream.Stream)
+	strm.AddWithID(stream.NewStreamID(1700000000000, 0), map[string]string{"f": "v", "g": "w"})
+	strm.AddWithID(stream.NewStreamID(1700000000000, 1), map[string]string{"f": "v2"})
+	strm.SetLastID(stream.NewStreamID(1700000000005, 0))
+	db.Set("stream", sobj)
+
+	return db
+}
+
+// snapshot renders the value of an object in a canonical form for comparison
+func snapshot(t *testing.T, obj *database.Object) string {
+	t.Helper()
+
+	var parts []string
+	switch v := obj.Ptr.(type) {
+	case string:
+		parts = []string{v}
+	case *list.List:
+		parts = v.ToSlice()
+	case *set.Set:
+		parts = v.Members()
+		sort.Strings(parts)
+	case *hash.Hash:
+		for f, val := range v.GetAllMap() {
+			parts = append(parts, f+"="+val)
+		}
+		sort.Strings(parts)
+	case *zset.ZSet:
+		for _, m := range v.Range(0, -1) {
+			parts = append(parts, m.Member+"="+strconv.FormatFloat(m.Score, 'g', -1, 64))
+		}
+	case *stream.Stream:
+		for _, e := range v.GetEntries() {
+			fields := e.GetFields()
+			names := make([]string, 0, len(fields))
+			for name := range fields {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				parts = append(parts, e.ID.String()+":"+name+"="+fields[name])
+			}
+		}
+		parts = append(parts, "last="+v.GetLastID().String())
+	default:
+		t.Fatalf("unexpected value type %T", obj.Ptr)
+	}
+	return obj.Type.String() + "[" + strings.Join(parts, ",") + "]"
+}
+
+var allTypeKeys = []string{"string", "list", "set", "hash", "zset", "stream"}
+
+func TestCopyEveryType(t *testing.T) {
+	db := newAllTypesDB()
+
+	for _, key := range allTypeKeys {
+		reply, _ := copyCmd(newTestContext(t, db, key, key+":copy"))
+		if reply.Value != int64(1) {
+			t.Fatalf("COPY %s expected 1, got %v", key, reply.Value)
+		}
+
+		src, _ := db.Get(key)
+		dst, ok := db.Get(key + ":copy")
+		if !ok {
+			t.Fatalf("COPY %s did not create the destination", key)
+		}
+		if want, got := snapshot(t, src), snapshot(t, dst); want != got {
+			t.Errorf("COPY %s expected %s, got %s", key, want, got)
+		}
+		if src.Ptr == dst.Ptr && key != "string" {
+			t.Errorf("COPY %s shares the value with the source", key)
+		}
+	}
+
+	// Changing the copy leaves the source alone
+	dst, _ := db.Get("hash:copy")
+	dst.Ptr.(*hash.Hash).Set("f3", "v3")
+	src, _ := db.Get("hash")
+	if src.Ptr.(*hash.Hash).Exists("f3") {
+		t.Error("changing the copied hash changed the source")
+	}
+
+	reply, _ := copyCmd(newTestContext(t, db, "list", "hash"))
+	if reply.Value != int64(0) {
+		t.Errorf("COPY onto an existing key without REPLACE expected 0, got %v", reply.Value)
+	}
+	reply, _ = copyCmd(newTestContext(t, db, "list", "hash", "REPLACE"))
+	if obj, _ := db.Get("hash"); reply.Value != int64(1) || obj.Type != database.ObjTypeList {
+		t.Errorf("COPY REPLACE expected the list to replace the hash, got %v", reply.Value)
+	}
+}
+
+func TestDumpRestoreEveryType(t *testing.T) {
+	db := newAllTypesDB()
+
+	for _, key := range allTypeKeys {
+		reply, _ := dumpCmd(newTestContext(t, db, key))
+		if reply.IsError() || reply.IsNil() {
+			t.Fatalf("DUMP %s failed: %v", key, reply.Value)
+		}
+
+		reply, _ = restoreCmd(newTestContext(t, db, key+":restored", "0", reply.Value.(string)))
+		if reply.IsError() {
+			t.Fatalf("RESTORE %s failed: %v", key, reply.Value)
+		}
+
+		src, _ := db.Get(key)
+		dst, _ := db.Get(key + ":restored")
+		if want, got := snapshot(t, src), snapshot(t, dst); want != got {
+			t.Errorf("DUMP/RESTORE %s expected %s, got %s", key, want, got)
+		}
+	}
+}
+
+func TestDeepCopyRejectsUnknownType(t *testing.T) {
+	obj := database.NewObject(database.ObjTypeModule, database.ObjEncodingRaw, nil)
+	if _, err := obj.DeepCopy(); err == nil {
+		t.Error("DeepCopy of a module value expected an error")
+	}
+}
+
+func TestTypeCommand(t *testing.T) {
+	db := database.NewDB(0)
+	setCmd(newTestContext(t, db, "string", "v"))
+	rpushCmd(newTestContext(t, db, "list", "a"))
+	saddCmd(newTestContext(t, db, "set", "a"))
+	zaddCmd(newTestContext(t, db, "zset", "1", "a"))
+	hsetCmd(newTestContext(t, db, "hash", "f", "v"))
+	xaddCmd(newTestContext(t, db, "stream", "*", "f", "v"))
+
+	for _, want := range []string{"string", "list", "set", "zset", "hash", "stream"} {
+		reply, err := typeCmd(newTestContext(t, db, want))
+		if err != nil {
+			t.Fatalf("TYPE %s failed: %v", want, err)
+		}
+		if reply.Type != command.ReplyTypeStatus || reply.Value != want {
+			t.Errorf("TYPE %s expected status %s, got %v", want, want, reply.Value)
+		}
+	}
+
+	if reply, _ := typeCmd(newTestContext(t, db, "missing")); reply.Value != "none" {
+		t.Errorf("TYPE of a missing key expected none, got %v", reply.Value)
+	}
+}
@@ -161,6 +161,7 @@ func lpushCmd(ctx *command.Context) (*command.Reply, error) {
 	for _, value := range values {
 		l.PushLeft(value)
 	}
+	obj.ConvertIfNeeded(values...)
 
 	return command.NewIntegerReply(int64(l.Len())), nil
 }
@@ -200,6 +201,7 @@ func rpushCmd(ctx *command.Context) (*command.Reply, error) {
 	for _, value := range values {
 		l.PushRight(value)
 	}
+	obj.ConvertIfNeeded(values...)
 
 	return command.NewIntegerReply(int64(l.Len())), nil
 }
@@ -365,6 +367,7 @@ func lsetCmd(ctx *command.Context) (*command.Reply, error) {
 	if !ok {
 		return command.NewNilReply(), nil
 	}
+	obj.ConvertIfNeeded(value)
 
 	return command.NewStatusReply("OK"), nil
 }
@@ -446,8 +449,9 @@ func lremCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	key := ctx.Args[0]
+	// Positive counts remove from the head, negative from the tail, 0 all
 	count, err := strconv.Atoi(ctx.Args[1])
-	if err != nil || count < -1 {
+	if err != nil {
 		return nil, errors.New("value is not an integer or out of range")
 	}
 
@@ -518,6 +522,7 @@ func linsertCmd(ctx *command.Context) (*command.Reply, error) {
 	if !inserted {
 		return command.NewNilReply(), nil
 	}
+	obj.ConvertIfNeeded(value)
 
 	return command.NewIntegerReply(int64(l.Len())), nil
 }
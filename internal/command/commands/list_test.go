@@ -0,0 +1,40 @@
+package commands
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/list"
+)
+
+func TestLremCount(t *testing.T) {
+	tests := []struct {
+		count       string
+		wantRemoved int64
+		wantList    []string
+	}{
+		{"2", 2, []string{"b", "c", "a", "b", "a"}},
+		{"-2", 2, []string{"a", "b", "a", "c", "b"}},
+		{"0", 4, []string{"b", "c", "b"}},
+		{"-10", 4, []string{"b", "c", "b"}},
+	}
+
+	for _, tt := range tests {
+		db := database.NewDB(0)
+		rpushCmd(newTestContext(t, db, "k", "a", "b", "a", "c", "a", "b", "a"))
+
+		reply, err := lremCmd(newTestContext(t, db, "k", tt.count, "a"))
+		if err != nil {
+			t.Fatalf("LREM count %s failed: %v", tt.count, err)
+		}
+		if reply.Value != tt.wantRemoved {
+			t.Errorf("LREM count %s expected %d removed, got %v", tt.count, tt.wantRemoved, reply.Value)
+		}
+
+		obj, _ := db.Get("k")
+		if got := obj.Ptr.(*list.List).ToSlice(); !reflect.DeepEqual(got, tt.wantList) {
+			t.Errorf("LREM count %s expected %v remaining, got %v", tt.count, tt.wantList, got)
+		}
+	}
+}
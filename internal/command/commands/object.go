@@ -6,10 +6,17 @@ package commands
 
 import (
 	"fmt"
+	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/config"
 	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/hash"
+	"github.com/zyhnesmr/godis/internal/datastruct/set"
+	"github.com/zyhnesmr/godis/internal/datastruct/zset"
 )
 
 // RegisterObjectCommands registers all object commands
@@ -38,7 +45,7 @@ func RegisterObjectCommands(disp Dispatcher) {
 // OBJECT subcommand implementation
 // OBJECT ENCODING key - returns the internal encoding of the key
 // OBJECT IDLETIME key - returns the idle time in seconds
-// OBJECT REFCOUNT key - returns the reference count (always 1 for us)
+// OBJECT REFCOUNT key - returns the reference count of the value
 // OBJECT HELP - returns help text
 func objectCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 1 {
@@ -90,46 +97,62 @@ func objectEncoding(ctx *command.Context) (*command.Reply, error) {
 	return command.NewBulkStringReply(encoding), nil
 }
 
+// objectIdleTime returns the seconds since the key was last accessed.
+// Under an LFU policy objects keep an access frequency instead.
 func objectIdleTime(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[1]
 
-	_, ok := ctx.DB.Get(key)
+	obj, ok := ctx.DB.Get(key)
 	if !ok {
 		return command.NewIntegerReply(-1), nil
 	}
 
-	// Since we don't track idle time, return 0
-	return command.NewIntegerReply(0), nil
+	if dbSelector != nil && dbSelector.GetEvictionPolicy().IsLFU() {
+		return command.NewErrorReplyStr("ERR An LFU maxmemory policy is selected, idle time not tracked. Please note that when switching between policies at runtime LRU and LFU data will take some time to adjust."), nil
+	}
+
+	idle := time.Now().Unix() - int64(obj.GetLRU())
+	if idle < 0 {
+		idle = 0
+	}
+	return command.NewIntegerReply(idle), nil
 }
 
 func objectRefCount(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[1]
 
-	_, ok := ctx.DB.Get(key)
+	obj, ok := ctx.DB.Get(key)
 	if !ok {
 		return command.NewIntegerReply(0), nil
 	}
 
-	// Reference count is always 1 for our implementation
-	return command.NewIntegerReply(1), nil
+	return command.NewIntegerReply(int64(obj.RefCount())), nil
 }
 
 func getEncoding(obj *database.Object) string {
 	switch obj.Type {
 	case database.ObjTypeString:
-		// Check if it's an integer
-		if _, ok := obj.Ptr.(int64); ok {
-			return "int"
-		}
-		return "embstr"
-	case database.ObjTypeHash:
-		return "hashtable"
+		return obj.Encoding.String()
 	case database.ObjTypeList:
-		return "linkedlist"
+		return obj.Encoding.String()
+	case database.ObjTypeHash:
+		// A hash converts itself as fields are added
+		if h, ok := obj.Ptr.(*hash.Hash); ok {
+			return h.Encoding().String()
+		}
+		return obj.Encoding.String()
+	case database.ObjTypeZSet:
+		// A sorted set converts itself as members are added
+		if zs, ok := obj.Ptr.(*zset.ZSet); ok {
+			return zs.Encoding().String()
+		}
+		return obj.Encoding.String()
 	case database.ObjTypeSet:
+		// A set converts itself as members are added
+		if s, ok := obj.Ptr.(*set.Set); ok {
+			return s.Encoding().String()
+		}
 		return "hashtable"
-	case database.ObjTypeZSet:
-		return "skiplist"
 	case database.ObjTypeStream:
 		return "stream"
 	default:
@@ -139,6 +162,8 @@ func getEncoding(obj *database.Object) string {
 
 // MEMORY command implementation
 // MEMORY USAGE key [SAMPLES count] - returns memory usage in bytes
+// MEMORY STATS - returns memory usage details
+// MEMORY DOCTOR - returns a memory problems report
 func memoryCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 1 {
 		return nil, fmt.Errorf("wrong number of arguments for 'memory' command")
@@ -153,16 +178,158 @@ func memoryCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 		return memoryUsage(ctx)
 
+	case "STATS":
+		if len(ctx.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for 'memory stats' command")
+		}
+		return memoryStatsReply(collectMemoryStats()), nil
+
+	case "DOCTOR":
+		if len(ctx.Args) != 1 {
+			return nil, fmt.Errorf("wrong number of arguments for 'memory doctor' command")
+		}
+		return command.NewBulkStringReply(memoryDoctor(collectMemoryStats())), nil
+
 	case "HELP":
 		return command.NewBulkStringReply("MEMORY <subcommand> <key> [args]\n" +
 			"Subcommands:\n" +
-			"USAGE  Return memory usage in bytes"), nil
+			"USAGE  Return memory usage in bytes\n" +
+			"STATS  Return memory usage details\n" +
+			"DOCTOR  Return memory problems reports"), nil
 
 	default:
 		return nil, fmt.Errorf("unknown MEMORY subcommand '%s'", subcmd)
 	}
 }
 
+// memoryStats is a snapshot of the server memory used by MEMORY STATS and
+// MEMORY DOCTOR
+type memoryStats struct {
+	peak      int64 // Highest dataset size seen
+	dataset   int64 // Incrementally tracked size of all keys
+	allocated int64 // Heap bytes allocated by the process
+	keys      int64
+	maxMemory int64
+	dbs       []database.DBStats
+}
+
+// overhead returns the allocated bytes not accounted to the dataset
+func (s memoryStats) overhead() int64 {
+	if s.allocated > s.dataset {
+		return s.allocated - s.dataset
+	}
+	return 0
+}
+
+func collectMemoryStats() memoryStats {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+
+	stats := memoryStats{
+		dataset:   datasetMemory(),
+		allocated: int64(m.HeapAlloc),
+		maxMemory: config.Instance().MaxMemory,
+	}
+	stats.peak = trackUsedMemoryPeak(stats.dataset)
+
+	if dbSelector != nil {
+		stats.dbs = dbSelector.Stats()
+		for _, db := range stats.dbs {
+			stats.keys += db.Keys
+		}
+	}
+
+	return stats
+}
+
+// memoryStatsReply formats the stats as the flat name/value array returned
+// by MEMORY STATS
+func memoryStatsReply(stats memoryStats) *command.Reply {
+	items := []*command.Reply{
+		command.NewBulkStringReply("peak.allocated"), command.NewIntegerReply(stats.peak),
+		command.NewBulkStringReply("total.allocated"), command.NewIntegerReply(stats.allocated),
+		command.NewBulkStringReply("overhead.total"), command.NewIntegerReply(stats.overhead()),
+	}
+
+	for _, db := range stats.dbs {
+		if db.Keys == 0 {
+			continue
+		}
+		items = append(items,
+			command.NewBulkStringReply(fmt.Sprintf("db.%d", db.ID)),
+			command.NewArrayReply([]*command.Reply{
+				command.NewBulkStringReply("keys.count"), command.NewIntegerReply(db.Keys),
+				command.NewBulkStringReply("expires.count"), command.NewIntegerReply(int64(db.Expires)),
+				command.NewBulkStringReply("dataset.bytes"), command.NewIntegerReply(db.Memory),
+			}),
+		)
+	}
+
+	var bytesPerKey int64
+	if stats.keys > 0 {
+		bytesPerKey = stats.dataset / stats.keys
+	}
+	var datasetPercentage float64
+	if stats.allocated > 0 {
+		datasetPercentage = float64(stats.dataset) * 100 / float64(stats.allocated)
+	}
+
+	items = append(items,
+		command.NewBulkStringReply("keys.count"), command.NewIntegerReply(stats.keys),
+		command.NewBulkStringReply("keys.bytes-per-key"), command.NewIntegerReply(bytesPerKey),
+		command.NewBulkStringReply("dataset.bytes"), command.NewIntegerReply(stats.dataset),
+		command.NewBulkStringReply("dataset.percentage"), command.NewBulkStringReply(strconv.FormatFloat(datasetPercentage, 'f', 2, 64)),
+		command.NewBulkStringReply("maxmemory"), command.NewIntegerReply(stats.maxMemory),
+	)
+
+	return command.NewArrayReply(items)
+}
+
+// memoryDoctorMinDataset is the dataset size below which MEMORY DOCTOR does
+// not try to find issues
+const memoryDoctorMinDataset = 5 * 1024 * 1024
+
+// memoryDoctor returns a human readable assessment of the memory stats
+func memoryDoctor(stats memoryStats) string {
+	if stats.dataset < memoryDoctorMinDataset {
+		return "Hi Sam, this instance is empty or is using very little memory, " +
+			"my issues detector can't be used in these conditions. " +
+			"Please, leave for your mission on Earth and fill it with some data. " +
+			"The new Sam and I will be back to our programming as soon as I finished rebooting."
+	}
+
+	var issues []string
+	if stats.peak > stats.dataset*3/2 {
+		issues = append(issues, "Peak memory: In the past this instance used more than 150% the memory that is currently using. "+
+			"The allocator is normally not able to release memory after a peak, "+
+			"so you can expect to see a big fragmentation ratio.")
+	}
+	if stats.maxMemory > 0 && stats.dataset > stats.maxMemory*9/10 {
+		issues = append(issues, fmt.Sprintf("High memory usage: The dataset uses %s of the %s maxmemory limit. "+
+			"Keys will soon be evicted or writes rejected, depending on the maxmemory policy.",
+			formatBytes(uint64(stats.dataset)), formatBytes(uint64(stats.maxMemory))))
+	}
+	if stats.overhead() > stats.dataset {
+		issues = append(issues, "High overhead: The process allocates more memory for its own structures than for the dataset. "+
+			"Many small keys or a recent large deletion may cause this.")
+	}
+
+	if len(issues) == 0 {
+		return "Hi Sam, I can't find any memory issue in your instance. " +
+			"I can only account for what occurs on this base."
+	}
+
+	var b strings.Builder
+	b.WriteString("Sam, I detected a few issues in this Godis instance memory implants:\n\n")
+	for _, issue := range issues {
+		b.WriteString(" * ")
+		b.WriteString(issue)
+		b.WriteString("\n\n")
+	}
+	b.WriteString("I'm here to keep you safe, Sam. I want to help you.\n")
+	return b.String()
+}
+
 func memoryUsage(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[1]
 
@@ -177,7 +344,7 @@ func memoryUsage(ctx *command.Context) (*command.Reply, error) {
 
 	// Estimate memory usage
 	usage := estimateMemoryUsage(obj)
-	return command.NewIntegerReply(int64(usage)), nil
+	return command.NewUnsignedReply(uint64(usage)), nil
 }
 
 func estimateMemoryUsage(obj *database.Object) int {
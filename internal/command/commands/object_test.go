@@ -0,0 +1,422 @@
+package commands
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/net"
+)
+
+// objectEncodingOf returns the OBJECT ENCODING reply for key
+func objectEncodingOf(t *testing.T, db *database.DB, key string) string {
+	t.Helper()
+
+	reply, err := objectCmd(newTestContext(t, db, "ENCODING", key))
+	if err != nil {
+		t.Fatalf("OBJECT ENCODING %s failed: %v", key, err)
+	}
+	return reply.Value.(string)
+}
+
+func TestOversizedElementConvertsEncoding(t *testing.T) {
+	limits := database.GetEncodingLimits()
+	big := strings.Repeat("x", limits.HashMaxValue+1)
+	bigListElem := strings.Repeat("x", 8*1024+1)
+
+	tests := []struct {
+		name     string
+		handler  command.Handler
+		small    []string
+		oversize []string
+		want     string
+	}{
+		{"hash value", hsetCmd, []string{"k", "f1", "v1"}, []string{"k", "f2", big}, "hashtable"},
+		{"hash field", hsetCmd, []string{"k", "f1", "v1"}, []string{"k", big, "v2"}, "hashtable"},
+		{"zset member", zaddCmd, []string{"k", "1", "a"}, []string{"k", "2", big}, "skiplist"},
+		{"list element", rpushCmd, []string{"k", "a", "b"}, []string{"k", bigListElem}, "quicklist"},
+	}
+
+	for _, tt := range tests {
+		db := database.NewDB(0)
+		if _, err := tt.handler(newTestContext(t, db, tt.small...)); err != nil {
+			t.Fatalf("%s: small insert failed: %v", tt.name, err)
+		}
+		if enc := objectEncodingOf(t, db, "k"); enc != "listpack" {
+			t.Errorf("%s: small object expected listpack, got %s", tt.name, enc)
+		}
+
+		if _, err := tt.handler(newTestContext(t, db, tt.oversize...)); err != nil {
+			t.Fatalf("%s: oversized insert failed: %v", tt.name, err)
+		}
+		if enc := objectEncodingOf(t, db, "k"); enc != tt.want {
+			t.Errorf("%s: after oversized insert expected %s, got %s", tt.name, tt.want, enc)
+		}
+	}
+}
+
+func TestZSetEncodingBySize(t *testing.T) {
+	db := database.NewDB(0)
+	zaddCmd(newTestContext(t, db, "small", "1", "a", "2", "b", "3", "c"))
+	if enc := objectEncodingOf(t, db, "small"); enc != "listpack" {
+		t.Errorf("3 element zset expected listpack, got %s", enc)
+	}
+
+	args := []string{"big"}
+	for i := 0; i < 200; i++ {
+		args = append(args, strconv.Itoa(i), "m"+strconv.Itoa(i))
+	}
+	zaddCmd(newTestContext(t, db, args...))
+	if enc := objectEncodingOf(t, db, "big"); enc != "skiplist" {
+		t.Errorf("200 element zset expected skiplist, got %s", enc)
+	}
+}
+
+func TestEntryCountConvertsEncoding(t *testing.T) {
+	limits := database.GetEncodingLimits()
+	t.Cleanup(func() { database.SetEncodingLimits(limits) })
+
+	small := limits
+	small.HashMaxEntries = 2
+	database.SetEncodingLimits(small)
+
+	db := database.NewDB(0)
+	hsetCmd(newTestContext(t, db, "k", "f1", "v1", "f2", "v2"))
+	if enc := objectEncodingOf(t, db, "k"); enc != "listpack" {
+		t.Errorf("hash at the entry limit expected listpack, got %s", enc)
+	}
+
+	hsetCmd(newTestContext(t, db, "k", "f3", "v3"))
+	if enc := objectEncodingOf(t, db, "k"); enc != "hashtable" {
+		t.Errorf("hash past the entry limit expected hashtable, got %s", enc)
+	}
+
+	// The conversion is one way
+	hdelCmd(newTestContext(t, db, "k", "f2", "f3"))
+	if enc := objectEncodingOf(t, db, "k"); enc != "hashtable" {
+		t.Errorf("hash shrunk after conversion expected hashtable, got %s", enc)
+	}
+}
+
+func TestListEncodingThresholds(t *testing.T) {
+	limits := database.GetEncodingLimits()
+	t.Cleanup(func() { database.SetEncodingLimits(limits) })
+
+	// -2 allows a listpack of 8KB; a 100 byte element takes 103 bytes, and
+	// 79 of them plus the 7 byte header take 8144
+	elem := strings.Repeat("x", 100)
+	db := database.NewDB(0)
+	for i := 0; i < 79; i++ {
+		rpushCmd(newTestContext(t, db, "k", elem))
+	}
+	if enc := objectEncodingOf(t, db, "k"); enc != "listpack" {
+		t.Errorf("list within 8KB expected listpack, got %s", enc)
+	}
+	reply, _ := debugCmd(newTestContext(t, db, "LISTPACK", "k"))
+	if got := reply.Value.(string); got != "encoding:listpack entries:79 bytes:8144" {
+		t.Errorf("DEBUG LISTPACK got %q", got)
+	}
+
+	// A short element still fits, another 100 byte one does not
+	lpushCmd(newTestContext(t, db, "k", "head"))
+	if enc := objectEncodingOf(t, db, "k"); enc != "listpack" {
+		t.Errorf("list of 8150 bytes expected listpack, got %s", enc)
+	}
+	rpushCmd(newTestContext(t, db, "k", elem))
+	if enc := objectEncodingOf(t, db, "k"); enc != "quicklist" {
+		t.Errorf("list past 8KB expected quicklist, got %s", enc)
+	}
+	if reply, _ := debugCmd(newTestContext(t, db, "LISTPACK", "k")); !reply.IsError() {
+		t.Error("DEBUG LISTPACK of a quicklist expected error")
+	}
+
+	// List commands see the same elements across the switch
+	reply, _ = lrangeCmd(newTestContext(t, db, "k", "0", "1"))
+	if got := reply.Value.([]string); len(got) != 2 || got[0] != "head" || got[1] != elem {
+		t.Errorf("LRANGE after conversion got %v", got)
+	}
+	reply, _ = llenCmd(newTestContext(t, db, "k"))
+	if n := reply.Value.(int64); n != 81 {
+		t.Errorf("LLEN after conversion expected 81, got %d", n)
+	}
+
+	// A positive size counts entries
+	small := limits
+	small.ListMaxSize = 3
+	database.SetEncodingLimits(small)
+
+	rpushCmd(newTestContext(t, db, "n", "a", "b", "c"))
+	if enc := objectEncodingOf(t, db, "n"); enc != "listpack" {
+		t.Errorf("list at the entry limit expected listpack, got %s", enc)
+	}
+	rpushCmd(newTestContext(t, db, "n", "d"))
+	if enc := objectEncodingOf(t, db, "n"); enc != "quicklist" {
+		t.Errorf("list past the entry limit expected quicklist, got %s", enc)
+	}
+}
+
+func TestSetIntsetEncoding(t *testing.T) {
+	limits := database.GetEncodingLimits()
+	t.Cleanup(func() { database.SetEncodingLimits(limits) })
+
+	small := limits
+	small.SetMaxIntsetEntries = 3
+	database.SetEncodingLimits(small)
+
+	db := database.NewDB(0)
+	saddCmd(newTestContext(t, db, "ints", "3", "1", "2"))
+	if enc := objectEncodingOf(t, db, "ints"); enc != "intset" {
+		t.Errorf("integer set at the entry limit expected intset, got %s", enc)
+	}
+	reply, _ := smembersCmd(newTestContext(t, db, "ints"))
+	if got := strings.Join(reply.Value.([]string), " "); got != "1 2 3" {
+		t.Errorf("intset members expected in ascending order, got %q", got)
+	}
+
+	// Removing and re-adding stays within the limit
+	sremCmd(newTestContext(t, db, "ints", "2"))
+	saddCmd(newTestContext(t, db, "ints", "-5"))
+	if enc := objectEncodingOf(t, db, "ints"); enc != "intset" {
+		t.Errorf("integer set after SREM and SADD expected intset, got %s", enc)
+	}
+
+	saddCmd(newTestContext(t, db, "ints", "10"))
+	if enc := objectEncodingOf(t, db, "ints"); enc != "hashtable" {
+		t.Errorf("integer set past the entry limit expected hashtable, got %s", enc)
+	}
+
+	// A non-integer member, or a non-canonical one, converts a small set
+	for _, member := range []string{"a", "007", "+1", "1.5", "99999999999999999999"} {
+		saddCmd(newTestContext(t, db, "s", "1"))
+		saddCmd(newTestContext(t, db, "s", member))
+		if enc := objectEncodingOf(t, db, "s"); enc != "hashtable" {
+			t.Errorf("set with member %q expected hashtable, got %s", member, enc)
+		}
+		if reply, _ := sismemberCmd(newTestContext(t, db, "s", member)); reply.Value != int64(1) {
+			t.Errorf("member %q expected present after conversion", member)
+		}
+		db.Delete("s")
+	}
+}
+
+func TestMemoryStats(t *testing.T) {
+	selector := setupPersistence(t)
+	db, _ := selector.GetDB(1)
+	db.Set("a", database.NewStringObject(strings.Repeat("x", 100)))
+	db.Set("b", database.NewStringObject("y"))
+	db.Expire("b", 100)
+
+	stats := memoryStatsOf(t, db)
+
+	if v := stats["keys.count"]; v == nil || v.Value != int64(2) {
+		t.Errorf("keys.count expected 2, got %v", v)
+	}
+	if v := stats["dataset.bytes"]; v == nil || v.Value != selector.GetTotalMemoryUsage() {
+		t.Errorf("dataset.bytes expected %d, got %v", selector.GetTotalMemoryUsage(), v)
+	}
+	if v := stats["maxmemory"]; v == nil || v.Type != command.ReplyTypeInteger {
+		t.Errorf("maxmemory expected an integer, got %v", v)
+	}
+	if v := stats["peak.allocated"]; v == nil || v.Value.(int64) < selector.GetTotalMemoryUsage() {
+		t.Errorf("peak.allocated expected at least the dataset size, got %v", v)
+	}
+
+	db1 := stats["db.1"]
+	if db1 == nil {
+		t.Fatal("MEMORY STATS missing db.1")
+	}
+	fields := db1.Value.([]*command.Reply)
+	if fields[1].Value != int64(2) || fields[3].Value != int64(1) {
+		t.Errorf("db.1 expected 2 keys and 1 expire, got %v", fields)
+	}
+	if fields[4].Value != "dataset.bytes" || fields[5].Value != db.GetMemoryUsage() {
+		t.Errorf("db.1 dataset.bytes expected %d, got %v", db.GetMemoryUsage(), fields[5].Value)
+	}
+	if _, ok := stats["db.0"]; ok {
+		t.Error("MEMORY STATS reported the empty db.0")
+	}
+
+	// The dataset grows with the data
+	before := stats["dataset.bytes"].Value.(int64)
+	db.Set("c", database.NewStringObject(strings.Repeat("z", 4096)))
+	if after := memoryStatsOf(t, db)["dataset.bytes"].Value.(int64); after < before+4096 {
+		t.Errorf("dataset.bytes expected to grow by at least 4096 from %d, got %d", before, after)
+	}
+}
+
+// memoryStatsOf returns the MEMORY STATS reply by field name
+func memoryStatsOf(t *testing.T, db *database.DB) map[string]*command.Reply {
+	t.Helper()
+
+	reply, err := memoryCmd(newTestContext(t, db, "STATS"))
+	if err != nil {
+		t.Fatalf("MEMORY STATS failed: %v", err)
+	}
+
+	items := reply.Value.([]*command.Reply)
+	if len(items)%2 != 0 {
+		t.Fatalf("MEMORY STATS expected name/value pairs, got %d items", len(items))
+	}
+	stats := make(map[string]*command.Reply)
+	for i := 0; i < len(items); i += 2 {
+		stats[items[i].Value.(string)] = items[i+1]
+	}
+	return stats
+}
+
+func TestMemoryDoctor(t *testing.T) {
+	tests := []struct {
+		name  string
+		stats memoryStats
+		want  string
+	}{
+		{"empty", memoryStats{}, "very little memory"},
+		{"healthy", memoryStats{peak: 10 << 20, dataset: 10 << 20, allocated: 12 << 20}, "can't find any memory issue"},
+		{"past peak", memoryStats{peak: 40 << 20, dataset: 10 << 20, allocated: 12 << 20}, "Peak memory"},
+		{"near maxmemory", memoryStats{peak: 10 << 20, dataset: 10 << 20, allocated: 12 << 20, maxMemory: 10 << 20}, "High memory usage"},
+	}
+
+	for _, tt := range tests {
+		if report := memoryDoctor(tt.stats); !strings.Contains(report, tt.want) {
+			t.Errorf("%s: expected report containing %q, got %q", tt.name, tt.want, report)
+		}
+	}
+}
+
+func TestStringEncoding(t *testing.T) {
+	db := database.NewDB(0)
+	run := func(handler command.Handler, args ...string) {
+		t.Helper()
+		if _, err := handler(newTestContext(t, db, args...)); err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+	}
+	expect := func(step, key, want string) {
+		t.Helper()
+		if enc := objectEncodingOf(t, db, key); enc != want {
+			t.Errorf("%s: expected %s, got %s", step, want, enc)
+		}
+	}
+
+	run(setCmd, "s", "hello")
+	expect("SET short string", "s", "embstr")
+	run(setCmd, "long", strings.Repeat("x", 45))
+	expect("SET 45-byte string", "long", "raw")
+	run(setCmd, "n", "12345")
+	expect("SET integer", "n", "int")
+	run(setCmd, "padded", "007")
+	expect("SET non-canonical integer", "padded", "embstr")
+	if reply, _ := getCmd(newTestContext(t, db, "padded")); reply.Value != "007" {
+		t.Errorf("GET padded expected 007, got %v", reply.Value)
+	}
+
+	run(incrCmd, "n")
+	expect("INCR", "n", "int")
+	run(appendCmd, "n", "0")
+	expect("APPEND to int", "n", "raw")
+	run(appendCmd, "s", "!")
+	expect("APPEND to embstr", "s", "raw")
+	run(setCmd, "r", "12")
+	run(setrangeCmd, "r", "0", "3")
+	expect("SETRANGE", "r", "raw")
+}
+
+func TestObjectRefCountSharedIntegers(t *testing.T) {
+	db := database.NewDB(0)
+	refcount := func(key string) int64 {
+		t.Helper()
+		reply, err := objectCmd(newTestContext(t, db, "REFCOUNT", key))
+		if err != nil {
+			t.Fatalf("OBJECT REFCOUNT %s failed: %v", key, err)
+		}
+		return reply.Value.(int64)
+	}
+
+	setCmd(newTestContext(t, db, "k", "100"))
+	setCmd(newTestContext(t, db, "k2", "100"))
+	if refcount("k") <= 1 || refcount("k2") <= 1 {
+		t.Errorf("pooled integers expected refcount > 1, got %d and %d", refcount("k"), refcount("k2"))
+	}
+
+	incrCmd(newTestContext(t, db, "counter"))
+	if refcount("counter") <= 1 {
+		t.Errorf("INCR to 1 expected a pooled integer, got refcount %d", refcount("counter"))
+	}
+
+	setCmd(newTestContext(t, db, "big", "10000"))
+	setCmd(newTestContext(t, db, "s", "hello"))
+	if refcount("big") != 1 || refcount("s") != 1 {
+		t.Errorf("standalone objects expected refcount 1, got %d and %d", refcount("big"), refcount("s"))
+	}
+
+	// Modifying one key leaves the pooled object alone
+	appendCmd(newTestContext(t, db, "k", "0"))
+	if reply, _ := getCmd(newTestContext(t, db, "k2")); reply.Value != "100" {
+		t.Errorf("GET k2 expected 100 after APPEND to k, got %v", reply.Value)
+	}
+}
+
+func TestClientNoTouch(t *testing.T) {
+	selector := database.NewDBSelector(1)
+	disp := command.NewDispatcher(selector)
+	RegisterStringCommands(disp)
+	RegisterObjectCommands(disp)
+	RegisterServerCommands(disp)
+
+	db, _ := selector.GetDB(0)
+	obj := database.NewRawStringObject("value")
+	db.Set("key", obj)
+	obj.LRU -= 100
+
+	conn := newTestContext(t, nil).Conn
+	dispatch := func(name string, args ...string) string {
+		t.Helper()
+		reply, err := disp.Dispatch(context.Background(), conn, name, args)
+		if err != nil {
+			t.Fatalf("%s failed: %v", name, err)
+		}
+		return string(reply)
+	}
+	idleTime := func() int64 {
+		t.Helper()
+		reply := dispatch("OBJECT", "IDLETIME", "key")
+		idle, err := strconv.ParseInt(strings.TrimSuffix(strings.TrimPrefix(reply, ":"), "\r\n"), 10, 64)
+		if err != nil {
+			t.Fatalf("OBJECT IDLETIME = %q", reply)
+		}
+		return idle
+	}
+
+	// OBJECT IDLETIME itself does not count as an access
+	if idle := idleTime(); idle < 100 {
+		t.Fatalf("OBJECT IDLETIME = %d, want at least 100", idle)
+	}
+
+	if reply := dispatch("CLIENT", "NO-TOUCH", "on"); reply != "+OK\r\n" {
+		t.Fatalf("CLIENT NO-TOUCH on = %q", reply)
+	}
+	dispatch("GET", "key")
+	if idle := idleTime(); idle < 100 {
+		t.Errorf("OBJECT IDLETIME after GET under NO-TOUCH = %d, want at least 100", idle)
+	}
+	if reply := dispatch("CLIENT", "INFO"); !strings.Contains(reply, " flags=T ") {
+		t.Errorf("CLIENT INFO under NO-TOUCH = %q, want flags=T", reply)
+	}
+
+	dispatch("CLIENT", "NO-TOUCH", "off")
+	dispatch("GET", "key")
+	if idle := idleTime(); idle > 1 {
+		t.Errorf("OBJECT IDLETIME after GET = %d, want about 0", idle)
+	}
+
+	if reply := dispatch("CLIENT", "NO-EVICT", "maybe"); !strings.HasPrefix(reply, "-ERR") {
+		t.Errorf("CLIENT NO-EVICT maybe = %q, want error", reply)
+	}
+	dispatch("CLIENT", "NO-EVICT", "on")
+	if !conn.HasFlag(net.FlagNoEvict) {
+		t.Error("CLIENT NO-EVICT on did not set the flag")
+	}
+}
@@ -5,15 +5,20 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
 	"os"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 
 	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/config"
 	"github.com/zyhnesmr/godis/internal/database"
 	"github.com/zyhnesmr/godis/internal/persistence/aof"
 	"github.com/zyhnesmr/godis/internal/persistence/rdb"
+	"github.com/zyhnesmr/godis/internal/replication"
 )
 
 var (
@@ -26,6 +31,11 @@ func SetRDBManager(mgr *rdb.RDB) {
 	rdbManager = mgr
 }
 
+// GetRDBManager returns the global RDB manager
+func GetRDBManager() *rdb.RDB {
+	return rdbManager
+}
+
 // SetDBSelectorForPersistence sets the database selector for persistence
 func SetDBSelectorForPersistence(selector *database.DBSelector) {
 	dbSelector = selector
@@ -34,6 +44,15 @@ func SetDBSelectorForPersistence(selector *database.DBSelector) {
 // saveInProgress is used to prevent concurrent saves
 var saveInProgress int32 // 0 = not in progress, 1 = in progress
 
+// Outcome of the most recent BGSAVE, reported by INFO persistence
+var (
+	lastBgsaveFailed  int32 // 1 if the last BGSAVE failed
+	lastBgsaveAttempt int64 // Unix time the last BGSAVE started
+)
+
+// bgsaveRetryDelay is how long AutoSave waits before retrying a failed save
+const bgsaveRetryDelay = 5 * time.Second
+
 // RegisterPersistenceCommands registers all persistence commands
 func RegisterPersistenceCommands(disp Dispatcher) {
 	disp.Register(&command.Command{
@@ -66,22 +85,54 @@ func RegisterPersistenceCommands(disp Dispatcher) {
 		Categories: []string{command.CatPersistence},
 	})
 
+	disp.Register(&command.Command{
+		Name:       "WAITAOF",
+		Handler:    waitaofCmd,
+		Arity:      4,
+		Flags:      []string{command.FlagNoScript},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatConnection},
+	})
+
 	// Register AOF commands
 	aof.RegisterAOFCommands(disp)
 }
 
+// errBgsaveMisconf refuses writes after a failed background save
+var errBgsaveMisconf = errors.New("MISCONF Godis is configured to save RDB snapshots, but it's currently unable to persist to disk. " +
+	"Commands that may modify the data set are disabled, because this instance is configured to report errors during writes " +
+	"if RDB snapshotting fails (stop-writes-on-bgsave-error option). Please check the logs for details about the RDB error.")
+
+// CheckWritesAllowed refuses writes on a replica, and while the last
+// background save failed, snapshotting is enabled and
+// stop-writes-on-bgsave-error is set. It is installed as the dispatcher's
+// write guard.
+func CheckWritesAllowed() error {
+	if err := checkReplicaWrites(); err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&lastBgsaveFailed) == 0 {
+		return nil
+	}
+
+	cfg := config.Instance()
+	stopWrites, _ := cfg.Get("stop-writes-on-bgsave-error")
+	save, _ := cfg.Get("save")
+	if stopWrites == "yes" && save != "" {
+		return errBgsaveMisconf
+	}
+	return nil
+}
+
 // SAVE synchronously saves the dataset to disk
 func saveCmd(ctx *command.Context) (*command.Reply, error) {
 	// Check if another save is in progress
-	if atomic.LoadInt32(&saveInProgress) == 1 {
+	if !atomic.CompareAndSwapInt32(&saveInProgress, 0, 1) {
 		return command.NewErrorReplyStr("ERR Background save already in progress"), nil
 	}
-
-	atomic.StoreInt32(&saveInProgress, 1)
 	defer atomic.StoreInt32(&saveInProgress, 0)
 
-	startTime := time.Now()
-
 	// Collect all databases
 	dbs := make([]*database.DB, dbSelector.Count())
 	for i := 0; i < dbSelector.Count(); i++ {
@@ -92,60 +143,168 @@ func saveCmd(ctx *command.Context) (*command.Reply, error) {
 		dbs[i] = db
 	}
 
-	// Perform save
+	// Perform save. Its outcome also counts as the last background save
+	// status, so a successful SAVE lifts stop-writes-on-bgsave-error.
 	if err := rdbManager.Save(dbs); err != nil {
-		return command.NewErrorReply(err), nil
+		atomic.StoreInt32(&lastBgsaveFailed, 1)
+		return command.NewErrorReplyStr("ERR " + err.Error()), nil
 	}
+	atomic.StoreInt32(&lastBgsaveFailed, 0)
 
-	duration := time.Since(startTime)
-	return command.NewStatusReply(fmt.Sprintf("OK. Duration: %s", duration)), nil
+	return command.NewStatusReply("OK"), nil
 }
 
 // BGSAVE asynchronously saves the dataset to disk
 func bgsaveCmd(ctx *command.Context) (*command.Reply, error) {
+	if err := startBackgroundSave(); err != nil {
+		return command.NewErrorReply(err), nil
+	}
+	return command.NewStatusReply("Background saving started"), nil
+}
+
+// startBackgroundSave saves the dataset in a goroutine. Save writes to a
+// temp file and renames it, so readers never see a partial file.
+func startBackgroundSave() error {
 	// Check if another save is in progress
 	if !atomic.CompareAndSwapInt32(&saveInProgress, 0, 1) {
-		return command.NewErrorReplyStr("ERR Background save already in progress"), nil
+		return errors.New("ERR Background save already in progress")
+	}
+
+	// Collect all databases
+	dbs := make([]*database.DB, dbSelector.Count())
+	for i := 0; i < dbSelector.Count(); i++ {
+		db, err := dbSelector.GetDB(i)
+		if err != nil {
+			atomic.StoreInt32(&saveInProgress, 0)
+			return err
+		}
+		dbs[i] = db
 	}
+	atomic.StoreInt64(&lastBgsaveAttempt, time.Now().Unix())
 
-	// Run save in background
 	go func() {
 		defer atomic.StoreInt32(&saveInProgress, 0)
 
-		// Collect all databases
-		dbs := make([]*database.DB, dbSelector.Count())
-		for i := 0; i < dbSelector.Count(); i++ {
-			db, err := dbSelector.GetDB(i)
-			if err != nil {
-				return
-			}
-			dbs[i] = db
-		}
-
-		// Perform save
 		if err := rdbManager.Save(dbs); err != nil {
-			// Log error - in real implementation would use proper logging
+			atomic.StoreInt32(&lastBgsaveFailed, 1)
 			fmt.Fprintf(os.Stderr, "BGSAVE failed: %v\n", err)
+			return
 		}
+		atomic.StoreInt32(&lastBgsaveFailed, 0)
 	}()
 
-	return command.NewStatusReply("Background saving started"), nil
+	return nil
+}
+
+// AutoSave starts a background save if one of the save rules is met by the
+// changes since the last save. It returns true if a save was started.
+func AutoSave(shouldSave func(lastSaveTime time.Time, changesSinceSave int) bool) bool {
+	if rdbManager == nil || dbSelector == nil {
+		return false
+	}
+
+	// Don't hammer the disk with retries after a failed save
+	if atomic.LoadInt32(&lastBgsaveFailed) == 1 &&
+		time.Since(time.Unix(atomic.LoadInt64(&lastBgsaveAttempt), 0)) < bgsaveRetryDelay {
+		return false
+	}
+
+	stats := rdbManager.Stats()
+	if !shouldSave(stats.LastSave(), int(stats.Dirty())) {
+		return false
+	}
+	return startBackgroundSave() == nil
 }
 
 // LASTSAVE returns the Unix time of the last successful save
 func lastsaveCmd(ctx *command.Context) (*command.Reply, error) {
-	// Get file info
-	info, err := os.Stat(rdbManager.GetFilename())
+	return command.NewIntegerReply(rdbManager.Stats().LastSave().Unix()), nil
+}
+
+// buildSaveStatusInfo returns the RDB and AOF background job fields of
+// INFO persistence
+func buildSaveStatusInfo() string {
+	var b strings.Builder
+
+	if rdbManager != nil {
+		stats := rdbManager.Stats()
+		b.WriteString(fmt.Sprintf("rdb_changes_since_last_save:%d\r\n", stats.Dirty()))
+		b.WriteString(fmt.Sprintf("rdb_last_save_time:%d\r\n", stats.LastSave().Unix()))
+	}
+	b.WriteString(fmt.Sprintf("rdb_bgsave_in_progress:%d\r\n", atomic.LoadInt32(&saveInProgress)))
+	b.WriteString(fmt.Sprintf("rdb_last_bgsave_status:%s\r\n", statusString(atomic.LoadInt32(&lastBgsaveFailed) == 0)))
+	b.WriteString(fmt.Sprintf("aof_enabled:%d\r\n", boolToInt(aof.IsAOFEnabled())))
+	b.WriteString(fmt.Sprintf("aof_rewrite_in_progress:%d\r\n", boolToInt(aof.IsBgRewriteInProgress())))
+	b.WriteString(fmt.Sprintf("aof_last_bgrewrite_status:%s\r\n", statusString(aof.LastBgRewriteOK())))
+	b.WriteString(fmt.Sprintf("aof_last_rewrite_time:%d\r\n", unixOrZero(aof.LastRewriteTime())))
+
+	return b.String()
+}
+
+// statusString formats a job outcome the way Redis INFO does
+func statusString(ok bool) string {
+	if ok {
+		return "ok"
+	}
+	return "err"
+}
+
+// unixOrZero returns t as a Unix time, or 0 for the zero time
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// WAITAOF numlocal numreplicas timeout replies with the number of local
+// AOFs and of replicas that hold the writes made so far. The local AOF is
+// fsynced right away rather than waiting for the fsync policy. Replicas do
+// not acknowledge offsets yet, so none is counted and none is waited for.
+func waitaofCmd(ctx *command.Context) (*command.Reply, error) {
+	numLocal, err := strconv.ParseInt(ctx.Args[0], 10, 64)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+	if _, err := strconv.ParseInt(ctx.Args[1], 10, 64); err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+	timeout, err := strconv.ParseInt(ctx.Args[2], 10, 64)
 	if err != nil {
-		if os.IsNotExist(err) {
-			// No save has been performed yet
-			return command.NewIntegerReply(0), nil
+		return command.NewErrorReplyStr("ERR timeout is not an integer or out of range"), nil
+	}
+	if timeout < 0 {
+		return command.NewErrorReplyStr("ERR timeout is negative"), nil
+	}
+
+	if replicationMgr != nil && replicationMgr.Role() == replication.RoleSlave {
+		return command.NewErrorReplyStr("ERR WAITAOF cannot be used with replica instances. " +
+			"Please also note that writes to replicas are just local and are not propagated."), nil
+	}
+	enabled := IsAOFEnabled()
+	if numLocal > 0 && !enabled {
+		return command.NewErrorReplyStr("ERR WAITAOF cannot be used when numlocal is set but appendonly is disabled."), nil
+	}
+
+	var local int64
+	if enabled {
+		if err := GetAOFManager().Fsync(); err != nil {
+			return command.NewErrorReplyStr("ERR " + err.Error()), nil
 		}
-		return command.NewErrorReply(err), nil
+		local = 1
 	}
+	return command.NewArrayReply([]*command.Reply{
+		command.NewIntegerReply(local),
+		command.NewIntegerReply(0),
+	}), nil
+}
 
-	// Return Unix timestamp
-	return command.NewIntegerReply(info.ModTime().Unix()), nil
+// boolToInt converts a flag to the 0/1 form used by INFO
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
 }
 
 // LogToAOF logs a command to AOF if enabled
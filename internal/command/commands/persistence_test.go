@@ -0,0 +1,562 @@
+package commands
+
+import (
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/config"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/persistence/aof"
+	"github.com/zyhnesmr/godis/internal/persistence/rdb"
+)
+
+// setupPersistence points the persistence commands at a fresh selector and
+// an RDB file in a temp directory
+func setupPersistence(t *testing.T) *database.DBSelector {
+	t.Helper()
+
+	selector := database.NewDBSelector(2)
+	SetDBSelectorForPersistence(selector)
+	SetRDBManager(rdb.NewRDB(t.TempDir(), "dump.rdb"))
+	t.Cleanup(func() {
+		SetDBSelectorForPersistence(nil)
+		SetRDBManager(nil)
+	})
+	return selector
+}
+
+// waitForBgsave waits until no background save is running
+func waitForBgsave(t *testing.T) {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for atomic.LoadInt32(&saveInProgress) == 1 {
+		if time.Now().After(deadline) {
+			t.Fatal("BGSAVE did not finish")
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestBgsaveRejectsConcurrentSave(t *testing.T) {
+	selector := setupPersistence(t)
+	db, _ := selector.GetDB(0)
+	for i := 0; i < 50000; i++ {
+		db.Set("key:"+strconv.Itoa(i), database.NewStringObject("value"))
+	}
+
+	ctx := newTestContext(t, db)
+
+	reply, _ := bgsaveCmd(ctx)
+	if reply.IsError() || reply.Value != "Background saving started" {
+		t.Fatalf("BGSAVE expected Background saving started, got %v", reply.Value)
+	}
+
+	reply, _ = bgsaveCmd(ctx)
+	if !reply.IsError() || !strings.Contains(reply.Value.(string), "already in progress") {
+		t.Errorf("second BGSAVE expected already in progress error, got %v", reply.Value)
+	}
+
+	waitForBgsave(t)
+
+	info := buildPersistenceInfo()
+	if !strings.Contains(info, "rdb_bgsave_in_progress:0\r\n") {
+		t.Errorf("INFO expected rdb_bgsave_in_progress:0, got %q", info)
+	}
+	if !strings.Contains(info, "rdb_last_bgsave_status:ok\r\n") {
+		t.Errorf("INFO expected rdb_last_bgsave_status:ok, got %q", info)
+	}
+	if !rdbManager.FileExists() {
+		t.Error("BGSAVE did not write the RDB file")
+	}
+}
+
+// setupSaveStats points the persistence commands at the databases of a
+// dispatcher counting its changes for the save rules
+func setupSaveStats(t *testing.T) (*command.Dispatcher, *database.DB) {
+	t.Helper()
+
+	setupPersistence(t)
+	disp, db := setupTransactions(t)
+	SetDBSelectorForPersistence(disp.GetDB())
+	disp.SetChangeCounter(rdbManager.Stats())
+	return disp, db
+}
+
+func TestAutoSaveFollowsSaveRules(t *testing.T) {
+	disp, db := setupSaveStats(t)
+
+	cfg := config.Default()
+	cfg.SaveRules = []config.SaveRule{{Seconds: 1, Changes: 1}}
+
+	ctx := newTestContext(t, db)
+	reply, _ := lastsaveCmd(ctx)
+	startSave := reply.Value.(int64)
+
+	if AutoSave(cfg.ShouldSave) {
+		t.Fatal("AutoSave started a save without any changes")
+	}
+
+	dispatch(t, disp, ctx.Conn, "SET", "key", "value")
+	if dirty := rdbManager.Stats().Dirty(); dirty != 1 {
+		t.Fatalf("changes since save expected 1, got %d", dirty)
+	}
+
+	// Wait past the one second rule
+	time.Sleep(1100 * time.Millisecond)
+	if !AutoSave(cfg.ShouldSave) {
+		t.Fatal("AutoSave did not start a save after save 1 1 was met")
+	}
+	waitForBgsave(t)
+
+	if !rdbManager.FileExists() {
+		t.Error("AutoSave did not write the RDB file")
+	}
+	if dirty := rdbManager.Stats().Dirty(); dirty != 0 {
+		t.Errorf("changes since save after save expected 0, got %d", dirty)
+	}
+	reply, _ = lastsaveCmd(ctx)
+	if lastSave := reply.Value.(int64); lastSave <= startSave {
+		t.Errorf("LASTSAVE expected to advance past %d, got %d", startSave, lastSave)
+	}
+	if AutoSave(cfg.ShouldSave) {
+		t.Error("AutoSave started another save without new changes")
+	}
+}
+
+func TestSaveResetsChangesSinceSave(t *testing.T) {
+	disp, db := setupSaveStats(t)
+	ctx := newTestContext(t, db)
+
+	dispatch(t, disp, ctx.Conn, "SET", "a", "1")
+	dispatch(t, disp, ctx.Conn, "SET", "b", "2")
+	if dirty := rdbManager.Stats().Dirty(); dirty != 2 {
+		t.Fatalf("changes since save expected 2, got %d", dirty)
+	}
+
+	reply, _ := saveCmd(ctx)
+	if reply.IsError() || reply.Value != "OK" {
+		t.Fatalf("SAVE expected OK, got %v", reply.Value)
+	}
+	if !rdbManager.FileExists() {
+		t.Error("SAVE did not write the RDB file")
+	}
+	if dirty := rdbManager.Stats().Dirty(); dirty != 0 {
+		t.Errorf("changes since save after SAVE expected 0, got %d", dirty)
+	}
+
+	reply, _ = lastsaveCmd(ctx)
+	if last := reply.Value.(int64); time.Now().Unix()-last > 1 {
+		t.Errorf("LASTSAVE expected the time of the SAVE, got %d", last)
+	}
+
+	// The snapshot reloads into the same dataset
+	db.Set("a", database.NewStringObject("changed"))
+	if err := rdbManager.Load([]*database.DB{db}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if obj, ok := db.Get("a"); !ok || obj.String() != "1" {
+		t.Errorf("key a expected 1 after reload")
+	}
+}
+
+func TestStopWritesOnBgsaveError(t *testing.T) {
+	setupPersistence(t)
+	disp, db := setupTransactions(t)
+	disp.SetWriteGuard(CheckWritesAllowed)
+	conn := newTestContext(t, db).Conn
+
+	cfg := config.Instance()
+	stopWrites, rules := cfg.StopWritesOnBgsaveError, cfg.SaveRules
+	t.Cleanup(func() {
+		cfg.StopWritesOnBgsaveError, cfg.SaveRules = stopWrites, rules
+		atomic.StoreInt32(&lastBgsaveFailed, 0)
+	})
+	cfg.StopWritesOnBgsaveError = true
+	cfg.SaveRules = []config.SaveRule{{Seconds: 60, Changes: 1}}
+
+	atomic.StoreInt32(&lastBgsaveFailed, 1)
+	if got := dispatch(t, disp, conn, "SET", "k", "v"); !strings.HasPrefix(got, "-MISCONF") {
+		t.Errorf("SET after a failed save expected MISCONF, got %q", got)
+	}
+	if got := dispatch(t, disp, conn, "GET", "k"); got != "$-1\r\n" {
+		t.Errorf("GET after a failed save expected nil, got %q", got)
+	}
+
+	// Writes are allowed with snapshotting or the option disabled
+	cfg.SaveRules = nil
+	if got := dispatch(t, disp, conn, "SET", "k", "v"); got != "+OK\r\n" {
+		t.Errorf("SET with save disabled expected OK, got %q", got)
+	}
+	cfg.SaveRules = []config.SaveRule{{Seconds: 60, Changes: 1}}
+	cfg.StopWritesOnBgsaveError = false
+	if got := dispatch(t, disp, conn, "SET", "k", "v"); got != "+OK\r\n" {
+		t.Errorf("SET with stop-writes-on-bgsave-error off expected OK, got %q", got)
+	}
+
+	// A successful save lifts the block
+	cfg.StopWritesOnBgsaveError = true
+	if reply, _ := saveCmd(newTestContext(t, db)); reply.IsError() {
+		t.Fatalf("SAVE failed: %v", reply.Value)
+	}
+	if got := dispatch(t, disp, conn, "SET", "k", "v"); got != "+OK\r\n" {
+		t.Errorf("SET after a successful save expected OK, got %q", got)
+	}
+}
+
+func TestChangesSinceSaveCountInPlaceWrites(t *testing.T) {
+	disp, db := setupSaveStats(t)
+	RegisterListCommands(disp)
+	RegisterHashCommands(disp)
+	RegisterSetCommands(disp)
+	RegisterZSetCommands(disp)
+	conn := newTestContext(t, db).Conn
+
+	writes := [][]string{
+		{"LPUSH", "l", "a"},
+		{"HSET", "h", "f", "v"},
+		{"SADD", "s", "a"},
+		{"ZADD", "z", "1", "a"},
+	}
+	for _, argv := range writes {
+		dispatch(t, disp, conn, argv[0], argv[1:]...)
+	}
+	rdbManager.Stats().Reset()
+
+	// Each write to an existing key counts, even though the key itself
+	// stays in place
+	for i, argv := range [][]string{
+		{"LPUSH", "l", "b"},
+		{"HSET", "h", "f", "w"},
+		{"SADD", "s", "b"},
+		{"ZADD", "z", "2", "b"},
+	} {
+		dispatch(t, disp, conn, argv[0], argv[1:]...)
+		if dirty := rdbManager.Stats().Dirty(); dirty != int64(i+1) {
+			t.Errorf("%v: changes since save expected %d, got %d", argv, i+1, dirty)
+		}
+	}
+
+	// Reads and writes that change nothing don't count
+	dispatch(t, disp, conn, "LLEN", "l")
+	dispatch(t, disp, conn, "SET", "s2", "v")
+	dispatch(t, disp, conn, "SET", "s2", "w", "NX")
+	if dirty := rdbManager.Stats().Dirty(); dirty != 5 {
+		t.Errorf("changes since save expected 5, got %d", dirty)
+	}
+}
+
+// newAOFTestDispatcher returns a dispatcher with the data type commands
+// registered, over a fresh selector
+func newAOFTestDispatcher(t *testing.T) (*command.Dispatcher, *database.DB) {
+	t.Helper()
+
+	disp, db := setupTransactions(t)
+	RegisterKeyCommands(disp)
+	RegisterSetCommands(disp)
+	RegisterHashCommands(disp)
+	return disp, db
+}
+
+func TestAOFLogsDeterministicEffects(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	a := aof.NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	disp, db := newAOFTestDispatcher(t)
+	disp.SetAOFLogger(a)
+	conn := newTestContext(t, db).Conn
+
+	for _, argv := range [][]string{
+		{"SADD", "s", "a", "b", "c", "d", "e"},
+		{"SPOP", "s"},
+		{"SPOP", "s", "2"},
+		{"SPOP", "missing"},
+		{"SET", "f", "1.5"},
+		{"INCRBYFLOAT", "f", "0.1"},
+		{"HINCRBYFLOAT", "h", "x", "2.5"},
+		{"SET", "k", "v"},
+		{"EXPIRE", "k", "100"},
+		{"SET", "p", "v"},
+		{"PEXPIRE", "p", "5000"},
+		{"SET", "gone", "v"},
+		{"EXPIRE", "gone", "-1"},
+		{"EXPIRE", "missing", "10"},
+		{"SETEX", "se", "100", "v"},
+		{"PSETEX", "pse", "100000", "v"},
+		{"SET", "sx", "v", "EX", "100"},
+		{"SET", "sx", "w", "NX", "EX", "5"},
+	} {
+		dispatch(t, disp, conn, argv[0], argv[1:]...)
+	}
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Replay into a fresh dataset, as on startup
+	replayDisp, replayDB := newAOFTestDispatcher(t)
+	var logged []string
+	err := aof.NewAOF(dir, "appendonly.aof", cfg).Load(nil, func(_ int, cmdName string, args []string) error {
+		logged = append(logged, strings.ToUpper(strings.Join(append([]string{cmdName}, args...), " ")))
+		cmd, ok := replayDisp.Get(cmdName)
+		if !ok {
+			return nil
+		}
+		_, err := cmd.Handler(&command.Context{DB: replayDB, CmdName: cmdName, Args: args})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for _, entry := range logged {
+		switch name := strings.Fields(entry)[0]; name {
+		case "SPOP", "INCRBYFLOAT", "HINCRBYFLOAT", "EXPIRE", "PEXPIRE", "SETEX", "PSETEX":
+			t.Errorf("expected %s to be logged as its effect, got %v", name, logged)
+		}
+		if strings.HasSuffix(entry, " NX EX 5") || strings.Contains(entry, " EX 100") {
+			t.Errorf("expected %q to be logged with an absolute expiration, or not at all", entry)
+		}
+	}
+
+	for _, argv := range [][]string{
+		{"SMEMBERS", "s"},
+		{"GET", "f"},
+		{"HGET", "h", "x"},
+		{"EXISTS", "gone"},
+		{"EXISTS", "missing"},
+		{"GET", "sx"},
+	} {
+		live := dispatch(t, disp, conn, argv[0], argv[1:]...)
+		replayed := dispatch(t, replayDisp, conn, argv[0], argv[1:]...)
+		if argv[0] == "SMEMBERS" {
+			live, replayed = sortedMembers(live), sortedMembers(replayed)
+		}
+		if live != replayed {
+			t.Errorf("%v: live %q, replayed %q", argv, live, replayed)
+		}
+	}
+	for _, key := range []string{"k", "p", "se", "pse", "sx"} {
+		live, _ := db.PExpireTime(key)
+		replayed, ok := replayDB.PExpireTime(key)
+		if !ok || live != replayed {
+			t.Errorf("%s: live expires at %d, replayed at %d (%v)", key, live, replayed, ok)
+		}
+	}
+}
+
+// sortedMembers sorts the lines of a RESP array reply so replies listing
+// the same members in a different order compare equal
+func sortedMembers(reply string) string {
+	lines := strings.Split(reply, "\r\n")
+	sort.Strings(lines)
+	return strings.Join(lines, "\r\n")
+}
+
+func TestWaitAOFFsyncsPendingWrites(t *testing.T) {
+	ctx := newTestContext(t, nil, "1", "0", "0")
+	if reply, _ := waitaofCmd(ctx); !reply.IsError() {
+		t.Errorf("WAITAOF 1 without an AOF = %v, want error", reply.Value)
+	}
+
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "no"
+	a := aof.NewAOF(t.TempDir(), "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	aof.SetAOFManager(a)
+	t.Cleanup(func() {
+		aof.SetAOFManager(nil)
+		_ = a.Close()
+	})
+
+	before, err := os.Stat(a.GetFilename())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if err := a.LogCommand(0, "SET", []string{"k", "v"}); err != nil {
+		t.Fatalf("LogCommand failed: %v", err)
+	}
+
+	reply, _ := waitaofCmd(ctx)
+	items, ok := reply.Value.([]*command.Reply)
+	if !ok || len(items) != 2 || items[0].Value != int64(1) || items[1].Value != int64(0) {
+		t.Fatalf("WAITAOF 1 0 0 = %v, want [1 0]", reply.Value)
+	}
+	after, err := os.Stat(a.GetFilename())
+	if err != nil {
+		t.Fatalf("Stat failed: %v", err)
+	}
+	if after.Size() <= before.Size() {
+		t.Errorf("AOF size %d after WAITAOF, want more than %d", after.Size(), before.Size())
+	}
+
+	for _, args := range [][]string{
+		{"x", "0", "0"},
+		{"1", "0", "-1"},
+		{"1", "0", "soon"},
+	} {
+		ctx.Args = args
+		if reply, _ := waitaofCmd(ctx); !reply.IsError() {
+			t.Errorf("WAITAOF %v = %v, want error", args, reply.Value)
+		}
+	}
+}
+
+func TestAOFRestoresStreamsAndExpirations(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	a := aof.NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	disp, db := newAOFTestDispatcher(t)
+	RegisterStreamCommands(disp)
+	disp.SetAOFLogger(a)
+	conn := newTestContext(t, db).Conn
+
+	for _, argv := range [][]string{
+		{"XADD", "s", "1-0", "a", "1"},
+		{"XADD", "s", "2-0", "c", "3"},
+		{"XADD", "s", "3-0", "d", "4"},
+		{"XDEL", "s", "3-0"},
+		{"XGROUP", "CREATE", "s", "g", "0"},
+		{"XREADGROUP", "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"},
+		{"XGROUP", "CREATECONSUMER", "s", "g", "bob"},
+		{"XCLAIM", "s", "g", "bob", "0", "1-0"},
+		{"XGROUP", "CREATE", "s", "g2", "$"},
+		{"PEXPIRE", "s", "100000"},
+		{"XADD", "e", "5-0", "x", "y"},
+		{"XDEL", "e", "5-0"},
+		{"XGROUP", "CREATE", "m", "g", "0", "MKSTREAM"},
+		{"XGROUP", "CREATE", "n", "g", "0", "MKSTREAM"},
+		{"XGROUP", "DESTROY", "n", "g"},
+		{"SET", "k", "v"},
+		{"EXPIRE", "k", "100"},
+		{"XADD", "a", "*", "f", "1"},
+		{"XADD", "a", "*", "f", "2"},
+		{"XADD", "a", "*", "f", "3"},
+		{"XADD", "a", "MAXLEN", "~", "3", "LIMIT", "5", "*", "f", "4"},
+		{"XTRIM", "a", "MAXLEN", "~", "2", "LIMIT", "1"},
+		{"XGROUP", "CREATE", "a", "g", "0"},
+		{"XREADGROUP", "GROUP", "g", "alice", "STREAMS", "a", ">"},
+		{"XADD", "a", "*", "f", "5"},
+	} {
+		dispatch(t, disp, conn, argv[0], argv[1:]...)
+	}
+	// Replaying a generated ID must not generate a new one
+	time.Sleep(2 * time.Millisecond)
+
+	check := func(stage string) {
+		t.Helper()
+
+		replayDisp, replayDB := newAOFTestDispatcher(t)
+		RegisterStreamCommands(replayDisp)
+		err := aof.NewAOF(dir, "appendonly.aof", cfg).Load(nil, func(_ int, cmdName string, args []string) error {
+			cmd, ok := replayDisp.Get(cmdName)
+			if !ok {
+				return nil
+			}
+			_, err := cmd.Handler(&command.Context{DB: replayDB, CmdName: cmdName, Args: args})
+			return err
+		})
+		if err != nil {
+			t.Fatalf("%s: Load failed: %v", stage, err)
+		}
+
+		for _, key := range []string{"s", "e", "m", "n", "a"} {
+			live := dispatch(t, disp, conn, "XINFO", "STREAM", key, "FULL")
+			replayed := dispatch(t, replayDisp, conn, "XINFO", "STREAM", key, "FULL")
+			if live != replayed {
+				t.Errorf("%s: XINFO STREAM %s FULL: live %q, replayed %q", stage, key, live, replayed)
+			}
+		}
+		for _, key := range []string{"s", "k"} {
+			live, _ := db.PExpireTime(key)
+			replayed, ok := replayDB.PExpireTime(key)
+			if !ok || live != replayed {
+				t.Errorf("%s: %s: live expires at %d, replayed at %d (%v)", stage, key, live, replayed, ok)
+			}
+		}
+	}
+
+	check("log")
+	if err := a.Rewrite([]*database.DB{db}); err != nil {
+		t.Fatalf("Rewrite failed: %v", err)
+	}
+	check("rewrite")
+
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+}
+
+func TestAOFRestoresRestoredAndCopiedKeys(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	a := aof.NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	disp, db := newAOFTestDispatcher(t)
+	disp.SetAOFLogger(a)
+	conn := newTestContext(t, db).Conn
+
+	dispatch(t, disp, conn, "SET", "src", "v")
+	reply, _ := dumpCmd(newTestContext(t, db, "src"))
+	payload := reply.Value.(string)
+	dispatch(t, disp, conn, "RESTORE", "kept", "0", payload)
+	dispatch(t, disp, conn, "RESTORE", "ttl", "100000", payload)
+	dispatch(t, disp, conn, "COPY", "ttl", "copy")
+	if err := a.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// Replay into a fresh dataset, as on restart
+	replayDisp, replayDB := newAOFTestDispatcher(t)
+	err := aof.NewAOF(dir, "appendonly.aof", cfg).Load(nil, func(_ int, cmdName string, args []string) error {
+		cmd, ok := replayDisp.Get(cmdName)
+		if !ok || !cmd.HasFlag(command.FlagWrite) {
+			return nil
+		}
+		_, err := cmd.Handler(&command.Context{DB: replayDB, CmdName: cmdName, Args: args})
+		return err
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	for _, key := range []string{"kept", "ttl", "copy"} {
+		if got := dispatch(t, replayDisp, conn, "GET", key); got != "$1\r\nv\r\n" {
+			t.Errorf("GET %s after restart = %q, want v", key, got)
+		}
+	}
+	for _, key := range []string{"ttl", "copy"} {
+		live, _ := db.PExpireTime(key)
+		if replayed, ok := replayDB.PExpireTime(key); !ok || live != replayed {
+			t.Errorf("%s: live expires at %d, replayed at %d (%v)", key, live, replayed, ok)
+		}
+	}
+	if _, ok := replayDB.PExpireTime("kept"); ok {
+		t.Error("kept: restored without a TTL expected no expiration after restart")
+	}
+}
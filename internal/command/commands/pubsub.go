@@ -6,10 +6,12 @@ package commands
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 
 	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/net"
 	"github.com/zyhnesmr/godis/internal/pubsub"
 )
 
@@ -74,6 +76,36 @@ func RegisterPubSubCommands(disp Dispatcher) {
 		Categories: []string{command.CatPubSub},
 	})
 
+	disp.Register(&command.Command{
+		Name:       "SPUBLISH",
+		Handler:    spublishCmd,
+		Arity:      3,
+		Flags:      []string{command.FlagPubSub, command.FlagFast},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatPubSub},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "SSUBSCRIBE",
+		Handler:    ssubscribeCmd,
+		Arity:      -2,
+		Flags:      []string{command.FlagPubSub, command.FlagReadOnly},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatPubSub},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "SUNSUBSCRIBE",
+		Handler:    sunsubscribeCmd,
+		Arity:      -1,
+		Flags:      []string{command.FlagPubSub, command.FlagReadOnly},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatPubSub},
+	})
+
 	disp.Register(&command.Command{
 		Name:       "PUBSUB",
 		Handler:    pubsubCmd,
@@ -104,55 +136,31 @@ func subscribeCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewErrorReplyStr("ERR wrong number of arguments for 'SUBSCRIBE' command"), nil
 	}
 
-	channels := ctx.Args
-	pubsubMgr.Subscribe(ctx.Conn, channels...)
-
-	// For Redis compatibility, each subscription confirmation is a separate 3-element array
-	// Since we can only return one Reply, we'll return the first channel's confirmation
-	// In a full implementation, we'd need to push additional messages for other channels
-	subCount := len(ctx.Conn.GetSubscriptions())
-	channel := channels[0]
-
-	// Return a flat 3-element array: ["subscribe", "channel", count]
-	response := []interface{}{"subscribe", channel, int64(subCount)}
-	return command.NewArrayReplyFromAny(response), nil
+	replies := make([]*command.Reply, len(ctx.Args))
+	for i, channel := range ctx.Args {
+		pubsubMgr.Subscribe(ctx.Conn, channel)
+		replies[i] = subscriptionReply("subscribe", channel, subscriptionCount(ctx.Conn))
+	}
+	return subscriptionReplies(replies), nil
 }
 
 // UNSUBSCRIBE [channel ...]
 func unsubscribeCmd(ctx *command.Context) (*command.Reply, error) {
-	var channels []string
-
-	if len(ctx.Args) == 0 {
-		// Get current subscriptions to unsubscribe from all
-		currentSubs := ctx.Conn.GetSubscriptions()
-		channels = make([]string, 0, len(currentSubs))
-		for channel := range currentSubs {
-			channels = append(channels, channel)
-		}
-	} else {
-		channels = ctx.Args
+	channels := ctx.Args
+	if len(channels) == 0 {
+		// Unsubscribe from all channels
+		channels = sortedKeys(ctx.Conn.GetSubscriptions())
 	}
-
 	if len(channels) == 0 {
-		// No subscriptions, return empty array-like response
-		return command.NewArrayReplyFromAny([]interface{}{}), nil
+		return subscriptionReply("unsubscribe", nil, subscriptionCount(ctx.Conn)), nil
 	}
 
-	// Build response with unsubscribe confirmation messages
-	responses := make([]interface{}, 0, len(channels)*3)
-
-	subCount := len(ctx.Conn.GetSubscriptions())
-	for _, channel := range channels {
-		_, isSubscribed := ctx.Conn.GetSubscriptions()[channel]
-		if isSubscribed {
-			subCount--
-		}
-		responses = append(responses, []interface{}{"unsubscribe", channel, int64(subCount)})
+	replies := make([]*command.Reply, len(channels))
+	for i, channel := range channels {
+		pubsubMgr.Unsubscribe(ctx.Conn, channel)
+		replies[i] = subscriptionReply("unsubscribe", channel, subscriptionCount(ctx.Conn))
 	}
-
-	pubsubMgr.Unsubscribe(ctx.Conn, channels...)
-
-	return command.NewArrayReplyFromAny(responses), nil
+	return subscriptionReplies(replies), nil
 }
 
 // PSUBSCRIBE pattern [pattern ...]
@@ -161,56 +169,98 @@ func psubscribeCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewErrorReplyStr("ERR wrong number of arguments for 'PSUBSCRIBE' command"), nil
 	}
 
-	patterns := ctx.Args
-	pubsubMgr.PSubscribe(ctx.Conn, patterns...)
+	replies := make([]*command.Reply, len(ctx.Args))
+	for i, pattern := range ctx.Args {
+		pubsubMgr.PSubscribe(ctx.Conn, pattern)
+		replies[i] = subscriptionReply("psubscribe", pattern, subscriptionCount(ctx.Conn))
+	}
+	return subscriptionReplies(replies), nil
+}
 
-	// Build response array with psubscribe confirmation messages
-	responses := make([]interface{}, 0, len(patterns)*3)
+// PUNSUBSCRIBE [pattern ...]
+func punsubscribeCmd(ctx *command.Context) (*command.Reply, error) {
+	patterns := ctx.Args
+	if len(patterns) == 0 {
+		// Unsubscribe from all patterns
+		patterns = sortedKeys(ctx.Conn.GetPatterns())
+	}
+	if len(patterns) == 0 {
+		return subscriptionReply("punsubscribe", nil, subscriptionCount(ctx.Conn)), nil
+	}
 
-	patCount := len(ctx.Conn.GetPatterns())
-	for _, pattern := range patterns {
-		patCount++
-		responses = append(responses, []interface{}{"psubscribe", pattern, patCount})
+	replies := make([]*command.Reply, len(patterns))
+	for i, pattern := range patterns {
+		pubsubMgr.PUnsubscribe(ctx.Conn, pattern)
+		replies[i] = subscriptionReply("punsubscribe", pattern, subscriptionCount(ctx.Conn))
 	}
+	return subscriptionReplies(replies), nil
+}
 
-	return command.NewArrayReplyFromAny(responses), nil
+// subscriptionCount returns the count the (P)SUBSCRIBE and (P)UNSUBSCRIBE
+// confirmations report: the channels and patterns the connection is
+// subscribed to
+func subscriptionCount(conn *net.Conn) int {
+	return len(conn.GetSubscriptions()) + len(conn.GetPatterns())
 }
 
-// PUNSUBSCRIBE [pattern ...]
-func punsubscribeCmd(ctx *command.Context) (*command.Reply, error) {
-	var patterns []string
+// subscriptionReply returns the confirmation of a (un)subscribe command
+// for one channel or pattern, nil if there was none to unsubscribe from
+func subscriptionReply(kind string, target interface{}, count int) *command.Reply {
+	return command.NewArrayReplyFromAny([]interface{}{kind, target, int64(count)})
+}
 
-	if len(ctx.Args) == 0 {
-		// Get current pattern subscriptions to unsubscribe from all
-		currentPats := ctx.Conn.GetPatterns()
-		patterns = make([]string, 0, len(currentPats))
-		for pattern := range currentPats {
-			patterns = append(patterns, pattern)
-		}
-	} else {
-		patterns = ctx.Args
+// subscriptionReplies returns the confirmations of a (un)subscribe command,
+// which are sent one after the other rather than as an array
+func subscriptionReplies(replies []*command.Reply) *command.Reply {
+	if len(replies) == 1 {
+		return replies[0]
 	}
+	return command.NewMultiReply(replies)
+}
 
-	if len(patterns) == 0 {
-		// No pattern subscriptions, return empty response
-		return command.NewArrayReplyFromAny([]interface{}{}), nil
+// sortedKeys returns the keys of a set, sorted
+func sortedKeys(set map[string]struct{}) []string {
+	keys := make([]string, 0, len(set))
+	for key := range set {
+		keys = append(keys, key)
 	}
+	sort.Strings(keys)
+	return keys
+}
 
-	// Build response with punsubscribe confirmation messages
-	responses := make([]interface{}, 0, len(patterns)*3)
+// SPUBLISH shardchannel message
+func spublishCmd(ctx *command.Context) (*command.Reply, error) {
+	count := pubsubMgr.SPublish(ctx.Args[0], []byte(ctx.Args[1]))
+	return command.NewIntegerReply(int64(count)), nil
+}
 
-	patCount := len(ctx.Conn.GetPatterns())
-	for _, pattern := range patterns {
-		_, isSubscribed := ctx.Conn.GetPatterns()[pattern]
-		if isSubscribed {
-			patCount--
-		}
-		responses = append(responses, []interface{}{"punsubscribe", pattern, int64(patCount)})
+// SSUBSCRIBE shardchannel [shardchannel ...]
+// The confirmations count the shard channels only
+func ssubscribeCmd(ctx *command.Context) (*command.Reply, error) {
+	replies := make([]*command.Reply, len(ctx.Args))
+	for i, channel := range ctx.Args {
+		pubsubMgr.SSubscribe(ctx.Conn, channel)
+		replies[i] = subscriptionReply("ssubscribe", channel, len(ctx.Conn.GetShardSubscriptions()))
 	}
+	return subscriptionReplies(replies), nil
+}
 
-	pubsubMgr.PUnsubscribe(ctx.Conn, patterns...)
+// SUNSUBSCRIBE [shardchannel ...]
+func sunsubscribeCmd(ctx *command.Context) (*command.Reply, error) {
+	channels := ctx.Args
+	if len(channels) == 0 {
+		channels = sortedKeys(ctx.Conn.GetShardSubscriptions())
+	}
+	if len(channels) == 0 {
+		return subscriptionReply("sunsubscribe", nil, 0), nil
+	}
 
-	return command.NewArrayReplyFromAny(responses), nil
+	replies := make([]*command.Reply, len(channels))
+	for i, channel := range channels {
+		pubsubMgr.SUnsubscribe(ctx.Conn, channel)
+		replies[i] = subscriptionReply("sunsubscribe", channel, len(ctx.Conn.GetShardSubscriptions()))
+	}
+	return subscriptionReplies(replies), nil
 }
 
 // PUBSUB subcommand [argument [argument ...]]
@@ -228,6 +278,10 @@ func pubsubCmd(ctx *command.Context) (*command.Reply, error) {
 		return pubsubNumsub(ctx)
 	case "numpat":
 		return pubsubNumpat(ctx)
+	case "shardchannels":
+		return pubsubShardChannels(ctx)
+	case "shardnumsub":
+		return pubsubShardNumsub(ctx)
 	default:
 		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown PUBSUB subcommand '%s'", subcommand)), nil
 	}
@@ -235,42 +289,23 @@ func pubsubCmd(ctx *command.Context) (*command.Reply, error) {
 
 // PUBSUB CHANNELS [pattern]
 func pubsubChannels(ctx *command.Context) (*command.Reply, error) {
-	// Get all active channels
-	channels := pubsubMgr.ListChannels()
-
-	// If pattern is provided, filter channels
-	if len(ctx.Args) > 1 {
-		pattern := ctx.Args[1]
-		filtered := make([]string, 0)
-		for _, channel := range channels {
-			matched, _ := matchPatternSimple(pattern, channel)
-			if matched {
-				filtered = append(filtered, channel)
-			}
-		}
-		return command.NewStringArrayReply(filtered), nil
+	if len(ctx.Args) > 2 {
+		return command.NewErrorReplyStr("ERR wrong number of arguments for 'PUBSUB CHANNELS' command"), nil
 	}
 
-	return command.NewStringArrayReply(channels), nil
+	pattern := "*"
+	if len(ctx.Args) == 2 {
+		pattern = ctx.Args[1]
+	}
+	return command.NewStringArrayReply(pubsubMgr.ListChannelsMatching(pattern)), nil
 }
 
 // PUBSUB NUMSUB [channel [channel ...]]
 func pubsubNumsub(ctx *command.Context) (*command.Reply, error) {
-	var channels []string
-
-	if len(ctx.Args) > 1 {
-		channels = ctx.Args[1:]
-	} else {
-		channels = pubsubMgr.ListChannels()
-	}
-
-	if len(channels) == 0 {
-		return command.NewArrayReplyFromAny([]interface{}{}), nil
-	}
-
+	channels := ctx.Args[1:]
 	numSubs := pubsubMgr.NumSubscribers(channels...)
 
-	// Build response: ["channel1", "1", "channel2", "2", ...]
+	// Build response: ["channel1", 1, "channel2", 2, ...]
 	result := make([]interface{}, 0, len(channels)*2)
 	for _, channel := range channels {
 		result = append(result, channel, int64(numSubs[channel]))
@@ -279,37 +314,38 @@ func pubsubNumsub(ctx *command.Context) (*command.Reply, error) {
 	return command.NewArrayReplyFromAny(result), nil
 }
 
-// PUBSUB NUMPAT
-func pubsubNumpat(ctx *command.Context) (*command.Reply, error) {
-	return command.NewIntegerReply(int64(pubsubMgr.NumPatterns())), nil
-}
-
-// matchPatternSimple checks if a string matches a simple glob pattern
-// Supports * wildcard only
-func matchPatternSimple(pattern, s string) (bool, error) {
-	if pattern == "*" {
-		return true, nil
+// PUBSUB SHARDCHANNELS [pattern]
+func pubsubShardChannels(ctx *command.Context) (*command.Reply, error) {
+	if len(ctx.Args) > 2 {
+		return command.NewErrorReplyStr("ERR wrong number of arguments for 'PUBSUB SHARDCHANNELS' command"), nil
 	}
 
-	// Simple wildcard matching
-	patternParts := strings.Split(pattern, "*")
-	if len(patternParts) == 1 {
-		return pattern == s, nil
+	pattern := "*"
+	if len(ctx.Args) == 2 {
+		pattern = ctx.Args[1]
 	}
+	return command.NewStringArrayReply(pubsubMgr.ListShardChannelsMatching(pattern)), nil
+}
 
-	// Check if string starts with first part
-	if !strings.HasPrefix(s, patternParts[0]) {
-		return false, nil
-	}
+// PUBSUB SHARDNUMSUB [shardchannel [shardchannel ...]]
+func pubsubShardNumsub(ctx *command.Context) (*command.Reply, error) {
+	channels := ctx.Args[1:]
+	numSubs := pubsubMgr.NumShardSubscribers(channels...)
 
-	// Check if string ends with last part (if not empty due to trailing *)
-	if patternParts[len(patternParts)-1] != "" {
-		if !strings.HasSuffix(s, patternParts[len(patternParts)-1]) {
-			return false, nil
-		}
+	result := make([]interface{}, 0, len(channels)*2)
+	for _, channel := range channels {
+		result = append(result, channel, int64(numSubs[channel]))
 	}
 
-	return true, nil
+	return command.NewArrayReplyFromAny(result), nil
+}
+
+// PUBSUB NUMPAT returns the number of unique patterns subscribed to
+func pubsubNumpat(ctx *command.Context) (*command.Reply, error) {
+	if len(ctx.Args) != 1 {
+		return command.NewErrorReplyStr("ERR wrong number of arguments for 'PUBSUB NUMPAT' command"), nil
+	}
+	return command.NewIntegerReply(int64(pubsubMgr.NumPatterns())), nil
 }
 
 // BuildSubscribeMessage builds a RESP message for subscribe/punsubscribe confirmation
@@ -0,0 +1,304 @@
+package commands
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	gonet "net"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/internal/protocol/resp"
+	"github.com/zyhnesmr/godis/internal/pubsub"
+)
+
+func TestPubSubIntrospection(t *testing.T) {
+	SetPubSubManager(pubsub.NewManager())
+	t.Cleanup(func() { SetPubSubManager(nil) })
+
+	first := newTestContext(t, nil)
+	second := newTestContext(t, nil)
+
+	first.Args = []string{"news.sport", "news.tech", "weather"}
+	subscribeCmd(first)
+	second.Args = []string{"news.sport"}
+	subscribeCmd(second)
+	first.Args = []string{"news.*", "weather?"}
+	psubscribeCmd(first)
+	second.Args = []string{"news.*"}
+	psubscribeCmd(second)
+
+	ctx := newTestContext(t, nil)
+
+	ctx.Args = []string{"CHANNELS"}
+	reply, _ := pubsubCmd(ctx)
+	if want := []string{"news.sport", "news.tech", "weather"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("PUBSUB CHANNELS = %v, want %v", reply.Value, want)
+	}
+
+	ctx.Args = []string{"CHANNELS", "news.*"}
+	reply, _ = pubsubCmd(ctx)
+	if want := []string{"news.sport", "news.tech"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("PUBSUB CHANNELS news.* = %v, want %v", reply.Value, want)
+	}
+
+	ctx.Args = []string{"NUMSUB", "news.sport", "weather", "missing"}
+	reply, _ = pubsubCmd(ctx)
+	want := []interface{}{"news.sport", int64(2), "weather", int64(1), "missing", int64(0)}
+	if !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("PUBSUB NUMSUB = %v, want %v", reply.Value, want)
+	}
+
+	ctx.Args = []string{"NUMSUB"}
+	reply, _ = pubsubCmd(ctx)
+	if items := reply.Value.([]interface{}); len(items) != 0 {
+		t.Errorf("PUBSUB NUMSUB without channels = %v, want empty", items)
+	}
+
+	ctx.Args = []string{"NUMPAT"}
+	if reply, _ := pubsubCmd(ctx); reply.Value != int64(2) {
+		t.Errorf("PUBSUB NUMPAT = %v, want 2", reply.Value)
+	}
+
+	// Channels and patterns without subscribers are no longer reported
+	first.Args = nil
+	unsubscribeCmd(first)
+	punsubscribeCmd(first)
+
+	ctx.Args = []string{"CHANNELS"}
+	reply, _ = pubsubCmd(ctx)
+	if want := []string{"news.sport"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("PUBSUB CHANNELS after UNSUBSCRIBE = %v, want %v", reply.Value, want)
+	}
+
+	ctx.Args = []string{"NUMPAT"}
+	if reply, _ := pubsubCmd(ctx); reply.Value != int64(1) {
+		t.Errorf("PUBSUB NUMPAT after PUNSUBSCRIBE = %v, want 1", reply.Value)
+	}
+
+	ctx.Args = []string{"NUMPAT", "extra"}
+	if reply, _ := pubsubCmd(ctx); reply.Type != command.ReplyTypeError {
+		t.Error("PUBSUB NUMPAT with an argument expected error")
+	}
+}
+
+func TestShardPubSubDelivery(t *testing.T) {
+	SetPubSubManager(pubsub.NewManager())
+	t.Cleanup(func() { SetPubSubManager(nil) })
+
+	client, server := gonet.Pipe()
+	defer client.Close()
+	defer server.Close()
+	subscriber := &command.Context{Conn: net.NewConn(server), Args: []string{"orders"}}
+
+	reply, _ := ssubscribeCmd(subscriber)
+	if want := []interface{}{"ssubscribe", "orders", int64(1)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("SSUBSCRIBE = %v, want %v", reply.Value, want)
+	}
+
+	// Shard channels are isolated from regular channels and patterns
+	publisher := newTestContext(t, nil, "orders", "regular")
+	if reply, _ := publishCmd(publisher); reply.Value != int64(0) {
+		t.Errorf("PUBLISH to a shard channel name = %v, want 0", reply.Value)
+	}
+
+	done := make(chan *command.Reply, 1)
+	go func() {
+		publisher.Args = []string{"orders", "hello"}
+		reply, _ := spublishCmd(publisher)
+		done <- reply
+	}()
+
+	_ = client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	want := "*3\r\n$8\r\nsmessage\r\n$6\r\norders\r\n$5\r\nhello\r\n"
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(client, buf); err != nil {
+		t.Fatalf("reading the shard message failed: %v", err)
+	}
+	if string(buf) != want {
+		t.Errorf("shard message = %q, want %q", buf, want)
+	}
+	if reply := <-done; reply.Value != int64(1) {
+		t.Errorf("SPUBLISH = %v, want 1", reply.Value)
+	}
+
+	ctx := newTestContext(t, nil, "SHARDCHANNELS")
+	reply, _ = pubsubCmd(ctx)
+	if want := []string{"orders"}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("PUBSUB SHARDCHANNELS = %v, want %v", reply.Value, want)
+	}
+	ctx.Args = []string{"CHANNELS"}
+	reply, _ = pubsubCmd(ctx)
+	if channels := reply.Value.([]string); len(channels) != 0 {
+		t.Errorf("PUBSUB CHANNELS with only shard subscriptions = %v, want empty", channels)
+	}
+	ctx.Args = []string{"SHARDNUMSUB", "orders", "missing"}
+	reply, _ = pubsubCmd(ctx)
+	if want := []interface{}{"orders", int64(1), "missing", int64(0)}; !reflect.DeepEqual(reply.Value, want) {
+		t.Errorf("PUBSUB SHARDNUMSUB = %v, want %v", reply.Value, want)
+	}
+
+	subscriber.Args = nil
+	sunsubscribeCmd(subscriber)
+	if subscriber.Conn.IsInPubSub() {
+		t.Error("connection still in pub/sub mode after SUNSUBSCRIBE")
+	}
+	if reply, _ := spublishCmd(publisher); reply.Value != int64(0) {
+		t.Errorf("SPUBLISH after SUNSUBSCRIBE = %v, want 0", reply.Value)
+	}
+}
+
+// pubsubClient is a client talking RESP to a dispatcher served over a pipe
+type pubsubClient struct {
+	t      *testing.T
+	conn   gonet.Conn
+	reader *bufio.Reader
+}
+
+// newPubSubClient serves disp to a new client connection
+func newPubSubClient(t *testing.T, disp *command.Dispatcher) *pubsubClient {
+	t.Helper()
+
+	client, server := gonet.Pipe()
+	t.Cleanup(func() { client.Close() })
+	conn := net.NewConn(server)
+	go func() {
+		defer conn.Close()
+		net.DefaultHandle(context.Background(), conn, disp)
+	}()
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	return &pubsubClient{t: t, conn: client, reader: bufio.NewReader(client)}
+}
+
+// send writes a command without reading its reply
+func (c *pubsubClient) send(argv ...string) {
+	c.t.Helper()
+	if _, err := c.conn.Write(resp.BuildStringArray(argv)); err != nil {
+		c.t.Fatalf("writing %v failed: %v", argv, err)
+	}
+}
+
+// expect reads the next reply and checks it
+func (c *pubsubClient) expect(want string) {
+	c.t.Helper()
+	buf := make([]byte, len(want))
+	if _, err := io.ReadFull(c.reader, buf); err != nil {
+		c.t.Fatalf("reading %q failed: %v", want, err)
+	}
+	if string(buf) != want {
+		c.t.Fatalf("got %q, want %q", buf, want)
+	}
+}
+
+// message returns a message frame as a subscriber receives it
+func message(channel, payload string) string {
+	return fmt.Sprintf("*3\r\n$7\r\nmessage\r\n$%d\r\n%s\r\n$%d\r\n%s\r\n", len(channel), channel, len(payload), payload)
+}
+
+func TestSubscribeModeRestrictsCommandsAndDeliversInOrder(t *testing.T) {
+	SetPubSubManager(pubsub.NewManager())
+	t.Cleanup(func() { SetPubSubManager(nil) })
+
+	disp := command.NewDispatcher(database.NewDBSelector(1))
+	RegisterServerCommands(disp)
+	RegisterStringCommands(disp)
+	RegisterPubSubCommands(disp)
+
+	subscriber := newPubSubClient(t, disp)
+	publisher := newPubSubClient(t, disp)
+
+	// One confirmation per channel, counting the subscriptions so far
+	subscriber.send("SUBSCRIBE", "news", "weather")
+	subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$4\r\nnews\r\n:1\r\n")
+	subscriber.expect("*3\r\n$9\r\nsubscribe\r\n$7\r\nweather\r\n:2\r\n")
+	subscriber.send("PSUBSCRIBE", "n*")
+	subscriber.expect("*3\r\n$10\r\npsubscribe\r\n$2\r\nn*\r\n:3\r\n")
+
+	subscriber.send("GET", "k")
+	subscriber.expect("-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n")
+	subscriber.send("PING")
+	subscriber.expect("*2\r\n$4\r\npong\r\n$0\r\n\r\n")
+
+	publisher.send("PUBLISH", "weather", "sunny")
+	subscriber.expect(message("weather", "sunny"))
+	publisher.expect(":1\r\n")
+
+	// Messages arrive whole and in publish order, while the subscriber's
+	// own commands are answered in between
+	const n = 200
+	go func() {
+		for i := 0; i < n; i++ {
+			_, _ = publisher.conn.Write(resp.BuildStringArray([]string{"PUBLISH", "weather", strconv.Itoa(i)}))
+			if _, err := readFrame(publisher.reader); err != nil {
+				return
+			}
+		}
+	}()
+	frames := make(chan string)
+	go func() {
+		for {
+			frame, err := readFrame(subscriber.reader)
+			if err != nil {
+				close(frames)
+				return
+			}
+			frames <- frame
+		}
+	}()
+	subscriber.send("PING")
+	pongs, next := 0, 0
+	for next < n || pongs < 1 {
+		frame, ok := <-frames
+		if !ok {
+			t.Fatalf("connection closed after %d messages", next)
+		}
+		switch frame {
+		case "*2\r\n$4\r\npong\r\n$0\r\n\r\n":
+			pongs++
+		case message("weather", strconv.Itoa(next)):
+			next++
+		default:
+			t.Fatalf("got %q, want message %d", frame, next)
+		}
+	}
+
+	subscriber.send("UNSUBSCRIBE")
+	if got := []string{<-frames, <-frames}; got[0] != "*3\r\n$11\r\nunsubscribe\r\n$4\r\nnews\r\n:2\r\n" ||
+		got[1] != "*3\r\n$11\r\nunsubscribe\r\n$7\r\nweather\r\n:1\r\n" {
+		t.Fatalf("UNSUBSCRIBE replied %q", got)
+	}
+}
+
+// readFrame reads one reply made of an array of bulk strings, or a
+// simple, error or integer reply
+func readFrame(r *bufio.Reader) (string, error) {
+	header, err := r.ReadString('\n')
+	if err != nil || header[0] != '*' {
+		return header, err
+	}
+	n, _ := strconv.Atoi(header[1 : len(header)-2])
+	frame := header
+	for i := 0; i < n; i++ {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return "", err
+		}
+		frame += line
+		if line[0] != '$' {
+			continue
+		}
+		size, _ := strconv.Atoi(line[1 : len(line)-2])
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return "", err
+		}
+		frame += string(data)
+	}
+	return frame, nil
+}
@@ -0,0 +1,221 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package commands
+
+import (
+	"errors"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/internal/replication"
+)
+
+var (
+	replicationMgr *replication.Manager
+)
+
+// errReadOnlyReplica refuses writes from clients of a replica
+var errReadOnlyReplica = errors.New("READONLY You can't write against a read only replica.")
+
+// SetReplicationManager sets the global replication manager
+func SetReplicationManager(mgr *replication.Manager) {
+	replicationMgr = mgr
+}
+
+// ReplicationDataset is the dataset of the server as replication syncs
+// it: snapshots go through the RDB codec and commands from the master run
+// on the registered handlers
+type ReplicationDataset struct{}
+
+// allDBs returns every database of the server
+func allDBs() ([]*database.DB, error) {
+	dbs := make([]*database.DB, dbSelector.Count())
+	for i := range dbs {
+		db, err := dbSelector.GetDB(i)
+		if err != nil {
+			return nil, err
+		}
+		dbs[i] = db
+	}
+	return dbs, nil
+}
+
+// Save writes an RDB snapshot of every database to w
+func (ReplicationDataset) Save(w io.Writer) error {
+	dbs, err := allDBs()
+	if err != nil {
+		return err
+	}
+	return rdbManager.SaveTo(w, dbs)
+}
+
+// Load replaces every database with the RDB snapshot read from r
+func (ReplicationDataset) Load(r io.Reader) error {
+	dbs, err := allDBs()
+	if err != nil {
+		return err
+	}
+	return rdbManager.LoadFrom(r, dbs)
+}
+
+// Apply executes a write command received from the master on database db,
+// logging it to the AOF and sending it on to the replicas of this server
+func (ReplicationDataset) Apply(db int, cmdName string, args []string) error {
+	reply, err := serverDisp.Apply(db, cmdName, args)
+	if err != nil {
+		return err
+	}
+	if reply.IsError() {
+		return errors.New(reply.Value.(string))
+	}
+	return nil
+}
+
+// checkReplicaWrites refuses writes from clients while the server is a
+// replica: its data follows the master
+func checkReplicaWrites() error {
+	if replicationMgr != nil && replicationMgr.Role() == replication.RoleSlave {
+		return errReadOnlyReplica
+	}
+	return nil
+}
+
+// RegisterReplicationCommands registers all replication commands
+func RegisterReplicationCommands(disp Dispatcher) {
+	disp.Register(&command.Command{
+		Name:       "REPLICAOF",
+		Handler:    replicaofCmd,
+		Arity:      3,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript, command.FlagStale},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "SLAVEOF",
+		Handler:    replicaofCmd,
+		Arity:      3,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript, command.FlagStale},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "PSYNC",
+		Handler:    syncCmd,
+		Arity:      -3,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "SYNC",
+		Handler:    syncCmd,
+		Arity:      1,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "REPLCONF",
+		Handler:    replconfCmd,
+		Arity:      -1,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript, command.FlagStale},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+}
+
+// serverRole returns the current replication role as reported to clients
+func serverRole() string {
+	if replicationMgr == nil {
+		return replication.RoleMaster.String()
+	}
+	return replicationMgr.Role().String()
+}
+
+// REPLICAOF host port | REPLICAOF NO ONE
+func replicaofCmd(ctx *command.Context) (*command.Reply, error) {
+	if replicationMgr == nil {
+		return command.NewErrorReplyStr("ERR replication not initialized"), nil
+	}
+
+	host := ctx.Args[0]
+	if strings.ToUpper(host) == "NO" && strings.ToUpper(ctx.Args[1]) == "ONE" {
+		replicationMgr.SetNoOne()
+		return command.NewStatusReply("OK"), nil
+	}
+
+	port, err := strconv.Atoi(ctx.Args[1])
+	if err != nil || port <= 0 || port > 65535 {
+		return command.NewErrorReplyStr("ERR Invalid master port"), nil
+	}
+
+	replicationMgr.SetMaster(host, port)
+	return command.NewStatusReply("OK"), nil
+}
+
+// PSYNC replicationid offset | SYNC
+// A replica whose offset is still in the backlog resumes the stream from
+// there (+CONTINUE); any other gets a full sync, an RDB snapshot followed
+// by the replication stream. SYNC always gets a full sync.
+func syncCmd(ctx *command.Context) (*command.Reply, error) {
+	if replicationMgr == nil {
+		return command.NewErrorReplyStr("ERR replication not initialized"), nil
+	}
+	if ctx.Conn.HasFlag(net.FlagSlave) {
+		return command.NewErrorReplyStr("ERR Replica already syncing"), nil
+	}
+	// A transaction holds off the snapshot of a full sync until it ends
+	if ctx.Conn.IsInMulti() || ctx.InExec() {
+		return command.NewErrorReplyStr("ERR Command is not allowed inside a transaction"), nil
+	}
+
+	replID, offset := "?", int64(-1)
+	if len(ctx.Args) >= 2 {
+		n, err := strconv.ParseInt(ctx.Args[1], 10, 64)
+		if err != nil {
+			return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+		}
+		replID, offset = ctx.Args[0], n
+	}
+
+	// The snapshot and the stream are written to the connection directly
+	if err := replicationMgr.Sync(ctx.Conn, replID, offset); err != nil {
+		return command.NewErrorReplyStr("ERR " + err.Error()), nil
+	}
+	return command.NewNoReply(), nil
+}
+
+// REPLCONF option value [option value ...]
+// Replicas announce themselves with it during the handshake. The options
+// are accepted but not used; ACK gets no reply.
+func replconfCmd(ctx *command.Context) (*command.Reply, error) {
+	if len(ctx.Args)%2 != 0 {
+		return command.NewErrorReplyStr("ERR syntax error"), nil
+	}
+
+	for i := 0; i < len(ctx.Args); i += 2 {
+		switch strings.ToLower(ctx.Args[i]) {
+		case "listening-port", "ip-address", "capa":
+		case "ack", "getack":
+			return command.NewNoReply(), nil
+		default:
+			return command.NewErrorReplyStr("ERR Unrecognized REPLCONF option: " + ctx.Args[i]), nil
+		}
+	}
+	return command.NewStatusReply("OK"), nil
+}
@@ -5,17 +5,37 @@
 package commands
 
 import (
+	"errors"
 	"fmt"
+	"os"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
+	"github.com/zyhnesmr/godis/internal/acl"
 	"github.com/zyhnesmr/godis/internal/command"
 	"github.com/zyhnesmr/godis/internal/config"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/list"
+	"github.com/zyhnesmr/godis/internal/eviction"
+	"github.com/zyhnesmr/godis/internal/expire"
+	"github.com/zyhnesmr/godis/internal/latency"
+	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/internal/persistence/rdb"
+	"github.com/zyhnesmr/godis/internal/replication"
+	"github.com/zyhnesmr/godis/pkg/utils"
 )
 
+// serverDisp is used to read command statistics
+var serverDisp *command.Dispatcher
+
 // RegisterServerCommands registers all server commands
 func RegisterServerCommands(disp Dispatcher) {
+	serverDisp, _ = disp.(*command.Dispatcher)
+
 	disp.Register(&command.Command{
 		Name:             "PING",
 		Handler:          pingCmd,
@@ -131,12 +151,42 @@ func RegisterServerCommands(disp Dispatcher) {
 		Name:       "HELLO",
 		Handler:    helloCmd,
 		Arity:      -1,
-		Flags:      []string{command.FlagReadOnly, command.FlagFast},
+		Flags:      []string{command.FlagReadOnly, command.FlagFast, command.FlagNoAuth},
 		FirstKey:   0,
 		LastKey:    0,
 		Categories: []string{command.CatConnection},
 	})
 
+	disp.Register(&command.Command{
+		Name:       "CONFIG",
+		Handler:    configCmd,
+		Arity:      -2,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "SLOWLOG",
+		Handler:    slowlogCmd,
+		Arity:      -2,
+		Flags:      []string{command.FlagAdmin, command.FlagLoading, command.FlagStale},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
+	disp.Register(&command.Command{
+		Name:       "LATENCY",
+		Handler:    latencyCmd,
+		Arity:      -2,
+		Flags:      []string{command.FlagAdmin, command.FlagNoScript, command.FlagLoading, command.FlagStale},
+		FirstKey:   0,
+		LastKey:    0,
+		Categories: []string{command.CatServer},
+	})
+
 	disp.Register(&command.Command{
 		Name:       "MODULE",
 		Handler:    moduleCmd,
@@ -150,16 +200,77 @@ func RegisterServerCommands(disp Dispatcher) {
 
 var startTime = time.Now()
 
+// serverVersion is the version reported by INFO and HELLO
+var serverVersion = "1.0.0"
+
+// serverGitCommit and serverBuildTime are reported by INFO server
+var (
+	serverGitCommit = "unknown"
+	serverBuildTime = "unknown"
+)
+
+// SetServerVersion sets the server version reported to clients
+func SetServerVersion(version string) {
+	serverVersion = version
+}
+
+// SetBuildInfo sets the git commit and build time reported by INFO
+func SetBuildInfo(gitCommit, buildTime string) {
+	serverGitCommit = gitCommit
+	serverBuildTime = buildTime
+}
+
+// ClientRegistry tracks the connections of the network server
+type ClientRegistry interface {
+	GetConnectionCount() int
+	TotalConnections() uint64
+	GetConnections() []*net.Conn
+	CloseConnection(conn *net.Conn) error
+}
+
+// clientRegistry is used by INFO to report client counts, and by CLIENT
+// to list and close connections
+var clientRegistry ClientRegistry
+
+// SetClientRegistry sets the registry of the server's connections
+func SetClientRegistry(registry ClientRegistry) {
+	clientRegistry = registry
+}
+
+// expireScheduler runs active expiration; DEBUG SET-ACTIVE-EXPIRE toggles it
+var expireScheduler *expire.Scheduler
+
+// SetExpireScheduler sets the scheduler DEBUG SET-ACTIVE-EXPIRE controls
+func SetExpireScheduler(s *expire.Scheduler) {
+	expireScheduler = s
+}
+
+// usedMemoryPeak is the highest used_memory reported by INFO
+var usedMemoryPeak atomic.Int64
+
 // PING [message]
+// In subscribe mode a RESP2 connection can only receive arrays, so PING
+// replies with ["pong", message] there.
 func pingCmd(ctx *command.Context) (*command.Reply, error) {
-	// Handle 0 or 1 arguments
-	if len(ctx.Args) == 0 {
-		return command.NewStatusReply("PONG"), nil
+	if len(ctx.Args) > 1 {
+		return command.NewErrorReplyStr("ERR wrong number of arguments for 'PING' command"), nil
+	}
+
+	if ctx.Conn != nil && ctx.Conn.IsInPubSub() && ctx.Conn.GetProtocol() < 3 {
+		message := ""
+		if len(ctx.Args) == 1 {
+			message = ctx.Args[0]
+		}
+		return command.NewArrayReply([]*command.Reply{
+			command.NewBulkStringReply("pong"),
+			command.NewBulkStringReply(message),
+		}), nil
 	}
+
 	if len(ctx.Args) == 1 {
 		return command.NewBulkStringReply(ctx.Args[0]), nil
 	}
-	return command.NewBulkStringReply(ctx.Args[0]), nil
+	return command.NewStatusReply("PONG"), nil
 }
 
 // ECHO message
@@ -183,23 +294,47 @@ func selectCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewStatusReply("OK"), nil
 }
 
+// parseDBIndex parses a database index and checks it against the number
+// of databases. That is the number the server was started with: CONFIG SET
+// databases changes the config, not the databases that exist.
 func parseDBIndex(s string) (int, error) {
-	var index int
-	if _, err := fmt.Sscanf(s, "%d", &index); err != nil {
-		return 0, fmt.Errorf("invalid DB index")
+	index, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, errors.New("ERR value is not an integer or out of range")
 	}
 
-	cfg := config.Instance()
-	if index < 0 || index >= cfg.Databases {
-		return 0, fmt.Errorf("DB index out of range")
+	count := config.Instance().Databases
+	if dbSelector != nil {
+		count = dbSelector.Count()
+	}
+	if index < 0 || index >= count {
+		return 0, errors.New("ERR DB index is out of range")
 	}
 
 	return index, nil
 }
 
-// AUTH [password]
+// AUTH [username] password
+// Without a username, authenticates as the default user
 func authCmd(ctx *command.Context) (*command.Reply, error) {
-	// No password configured - just return OK
+	if len(ctx.Args) == 0 || len(ctx.Args) > 2 {
+		return command.NewErrorReplyStr("ERR wrong number of arguments for 'auth' command"), nil
+	}
+	if aclUsers == nil {
+		return command.NewStatusReply("OK"), nil
+	}
+
+	name, password := acl.DefaultUser, ctx.Args[0]
+	if len(ctx.Args) == 2 {
+		name, password = ctx.Args[0], ctx.Args[1]
+	} else if !aclUsers.AuthRequired() {
+		return command.NewErrorReplyStr("ERR AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?"), nil
+	}
+
+	if _, ok := aclUsers.Authenticate(name, password); !ok {
+		return command.NewErrorReplyStr("WRONGPASS invalid username-password pair or user is disabled."), nil
+	}
+	ctx.Conn.SetUser(name)
 	return command.NewStatusReply("OK"), nil
 }
 
@@ -213,10 +348,14 @@ func infoCmd(ctx *command.Context) (*command.Reply, error) {
 	var info string
 
 	switch section {
-	case "default", "all":
+	case "default":
 		info = buildDefaultInfo()
+	case "all", "everything":
+		info = buildDefaultInfo() + "\r\n" + buildCommandStatsInfo() + "\r\n" + buildLatencyStatsInfo()
 	case "server":
 		info = buildServerInfo()
+	case "clients":
+		info = buildClientsInfo()
 	case "memory":
 		info = buildMemoryInfo()
 	case "stats":
@@ -225,55 +364,60 @@ func infoCmd(ctx *command.Context) (*command.Reply, error) {
 		info = buildReplicationInfo()
 	case "persistence":
 		info = buildPersistenceInfo()
-	default:
-		info = buildDefaultInfo()
+	case "keyspace":
+		info = buildKeyspaceInfo()
+	case "commandstats":
+		info = buildCommandStatsInfo()
+	case "latencystats":
+		info = buildLatencyStatsInfo()
 	}
 
 	return command.NewBulkStringReply(info), nil
 }
 
+// buildDefaultInfo returns the sections INFO prints without arguments
 func buildDefaultInfo() string {
+	sections := []string{
+		buildServerInfo(),
+		buildClientsInfo(),
+		buildMemoryInfo(),
+		buildPersistenceInfo(),
+		buildStatsInfo(),
+		buildReplicationInfo(),
+		buildKeyspaceInfo(),
+	}
+	return strings.Join(sections, "\r\n")
+}
+
+func buildServerInfo() string {
 	var b strings.Builder
+	uptime := int64(time.Since(startTime).Seconds())
 
 	b.WriteString("# Server\r\n")
-	b.WriteString(fmt.Sprintf("godis_version:1.0.0\r\n"))
+	b.WriteString(fmt.Sprintf("godis_version:%s\r\n", serverVersion))
+	b.WriteString(fmt.Sprintf("godis_git_sha1:%s\r\n", serverGitCommit))
+	b.WriteString(fmt.Sprintf("godis_build_time:%s\r\n", serverBuildTime))
 	b.WriteString(fmt.Sprintf("os:%s\r\n", runtime.GOOS))
 	b.WriteString(fmt.Sprintf("arch:%s\r\n", runtime.GOARCH))
-	b.WriteString(fmt.Sprintf("process_id:%d\r\n", 1))
-	b.WriteString(fmt.Sprintf("uptime_in_seconds:%d\r\n", int64(time.Since(startTime).Seconds())))
-	b.WriteString(fmt.Sprintf("uptime_in_days:%d\r\n", int64(time.Since(startTime).Seconds()/86400)))
-
-	b.WriteString("\r\n# Clients\r\n")
-	b.WriteString(fmt.Sprintf("connected_clients:%d\r\n", 1))
-	b.WriteString(fmt.Sprintf("blocked_clients:0\r\n"))
-
-	b.WriteString("\r\n# Memory\r\n")
-	var m runtime.MemStats
-	runtime.ReadMemStats(&m)
-	b.WriteString(fmt.Sprintf("used_memory:%d\r\n", m.Alloc))
-	b.WriteString(fmt.Sprintf("used_memory_human:%s\r\n", formatBytes(m.Alloc)))
-
-	b.WriteString("\r\n# Persistence\r\n")
-	b.WriteString("loading:0\r\n")
-
-	b.WriteString("\r\n# Stats\r\n")
-	b.WriteString("total_connections_received:1\r\n")
-	b.WriteString("total_commands_processed:1\r\n")
-
-	b.WriteString("\r\n# Replication\r\n")
-	b.WriteString("role:master\r\n")
+	b.WriteString(fmt.Sprintf("go_version:%s\r\n", runtime.Version()))
+	b.WriteString(fmt.Sprintf("process_id:%d\r\n", os.Getpid()))
+	b.WriteString(fmt.Sprintf("uptime_in_seconds:%d\r\n", uptime))
+	b.WriteString(fmt.Sprintf("uptime_in_days:%d\r\n", uptime/86400))
 
 	return b.String()
 }
 
-func buildServerInfo() string {
+func buildClientsInfo() string {
 	var b strings.Builder
 
-	b.WriteString("# Server\r\n")
-	b.WriteString(fmt.Sprintf("godis_version:1.0.0\r\n"))
-	b.WriteString(fmt.Sprintf("os:%s\r\n", runtime.GOOS))
-	b.WriteString(fmt.Sprintf("arch:%s\r\n", runtime.GOARCH))
-	b.WriteString(fmt.Sprintf("uptime_in_seconds:%d\r\n", int64(time.Since(startTime).Seconds())))
+	connected := 0
+	if clientRegistry != nil {
+		connected = clientRegistry.GetConnectionCount()
+	}
+
+	b.WriteString("# Clients\r\n")
+	b.WriteString(fmt.Sprintf("connected_clients:%d\r\n", connected))
+	b.WriteString(fmt.Sprintf("blocked_clients:%d\r\n", streamWaiters.blockedClients()))
 
 	return b.String()
 }
@@ -283,22 +427,57 @@ func buildMemoryInfo() string {
 	var m runtime.MemStats
 	runtime.ReadMemStats(&m)
 
+	used := datasetMemory()
+	peak := trackUsedMemoryPeak(used)
+
 	b.WriteString("# Memory\r\n")
-	b.WriteString(fmt.Sprintf("used_memory:%d\r\n", m.Alloc))
-	b.WriteString(fmt.Sprintf("used_memory_human:%s\r\n", formatBytes(m.Alloc)))
+	b.WriteString(fmt.Sprintf("used_memory:%d\r\n", used))
+	b.WriteString(fmt.Sprintf("used_memory_human:%s\r\n", formatBytes(uint64(used))))
+	b.WriteString(fmt.Sprintf("used_memory_dataset:%d\r\n", used))
 	b.WriteString(fmt.Sprintf("used_memory_rss:%d\r\n", m.Sys))
-	b.WriteString(fmt.Sprintf("used_memory_peak:%d\r\n", m.Alloc))
+	b.WriteString(fmt.Sprintf("used_memory_peak:%d\r\n", peak))
+	b.WriteString(fmt.Sprintf("used_memory_peak_human:%s\r\n", formatBytes(uint64(peak))))
+	b.WriteString(fmt.Sprintf("maxmemory:%d\r\n", config.Instance().MaxMemory))
+	b.WriteString(fmt.Sprintf("maxmemory_policy:%s\r\n", config.Instance().MaxMemoryPolicy))
 
 	return b.String()
 }
 
+// trackUsedMemoryPeak records used as the new peak if it is higher and
+// returns the peak
+func trackUsedMemoryPeak(used int64) int64 {
+	peak := usedMemoryPeak.Load()
+	for used > peak {
+		if usedMemoryPeak.CompareAndSwap(peak, used) {
+			return used
+		}
+		peak = usedMemoryPeak.Load()
+	}
+	return peak
+}
+
+// datasetMemory returns the incrementally tracked memory of all keys
+func datasetMemory() int64 {
+	if dbSelector == nil {
+		return 0
+	}
+	return dbSelector.GetTotalMemoryUsage()
+}
+
 func buildStatsInfo() string {
 	var b strings.Builder
 
+	var connections, commands uint64
+	if clientRegistry != nil {
+		connections = clientRegistry.TotalConnections()
+	}
+	if serverDisp != nil {
+		commands = serverDisp.Stats().TotalCalls()
+	}
+
 	b.WriteString("# Stats\r\n")
-	b.WriteString("total_connections_received:1\r\n")
-	b.WriteString("total_commands_processed:1\r\n")
-	b.WriteString("instantaneous_ops_per_sec:0\r\n")
+	b.WriteString(fmt.Sprintf("total_connections_received:%d\r\n", connections))
+	b.WriteString(fmt.Sprintf("total_commands_processed:%d\r\n", commands))
 
 	return b.String()
 }
@@ -307,8 +486,33 @@ func buildReplicationInfo() string {
 	var b strings.Builder
 
 	b.WriteString("# Replication\r\n")
-	b.WriteString("role:master\r\n")
-	b.WriteString("connected_slaves:0\r\n")
+	b.WriteString(fmt.Sprintf("role:%s\r\n", serverRole()))
+	if replicationMgr == nil {
+		b.WriteString("connected_slaves:0\r\n")
+		return b.String()
+	}
+
+	if replicationMgr.Role() == replication.RoleSlave {
+		host, port := replicationMgr.MasterAddr()
+		up, syncing := replicationMgr.LinkStatus()
+		status := "down"
+		if up {
+			status = "up"
+		}
+		b.WriteString(fmt.Sprintf("master_host:%s\r\n", host))
+		b.WriteString(fmt.Sprintf("master_port:%d\r\n", port))
+		b.WriteString(fmt.Sprintf("master_link_status:%s\r\n", status))
+		b.WriteString(fmt.Sprintf("master_sync_in_progress:%d\r\n", boolToInt(syncing)))
+	}
+	b.WriteString(fmt.Sprintf("connected_slaves:%d\r\n", replicationMgr.ConnectedReplicas()))
+	b.WriteString(fmt.Sprintf("master_replid:%s\r\n", replicationMgr.ReplID()))
+	b.WriteString(fmt.Sprintf("master_repl_offset:%d\r\n", replicationMgr.Offset()))
+
+	active, size, first, histlen := replicationMgr.BacklogInfo()
+	b.WriteString(fmt.Sprintf("repl_backlog_active:%d\r\n", boolToInt(active)))
+	b.WriteString(fmt.Sprintf("repl_backlog_size:%d\r\n", size))
+	b.WriteString(fmt.Sprintf("repl_backlog_first_byte_offset:%d\r\n", first))
+	b.WriteString(fmt.Sprintf("repl_backlog_histlen:%d\r\n", histlen))
 
 	return b.String()
 }
@@ -318,6 +522,71 @@ func buildPersistenceInfo() string {
 
 	b.WriteString("# Persistence\r\n")
 	b.WriteString("loading:0\r\n")
+	b.WriteString(buildSaveStatusInfo())
+
+	return b.String()
+}
+
+// buildKeyspaceInfo lists the key and expire counts of every non-empty database
+func buildKeyspaceInfo() string {
+	var b strings.Builder
+
+	b.WriteString("# Keyspace\r\n")
+	if dbSelector == nil {
+		return b.String()
+	}
+
+	for _, stats := range dbSelector.Stats() {
+		if stats.Keys == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0\r\n", stats.ID, stats.Keys, stats.Expires))
+	}
+
+	return b.String()
+}
+
+// buildCommandStatsInfo reports the calls of each command and the time
+// they took, since startup or CONFIG RESETSTAT
+func buildCommandStatsInfo() string {
+	var b strings.Builder
+
+	b.WriteString("# Commandstats\r\n")
+	if serverDisp == nil {
+		return b.String()
+	}
+
+	stats := serverDisp.Stats()
+	for _, name := range stats.Names() {
+		h, ok := stats.Histogram(name)
+		if !ok || h.Count() == 0 {
+			continue
+		}
+		calls, usec := h.Count(), h.Usec()
+		b.WriteString(fmt.Sprintf("cmdstat_%s:calls=%d,usec=%d,usec_per_call=%.2f\r\n",
+			name, calls, usec, float64(usec)/float64(calls)))
+	}
+
+	return b.String()
+}
+
+func buildLatencyStatsInfo() string {
+	var b strings.Builder
+
+	b.WriteString("# Latencystats\r\n")
+	if serverDisp == nil {
+		return b.String()
+	}
+
+	stats := serverDisp.Stats()
+	for _, name := range stats.Names() {
+		h, ok := stats.Histogram(name)
+		if !ok || h.Count() == 0 {
+			continue
+		}
+		b.WriteString(fmt.Sprintf("latency_percentiles_usec_%s:p50=%.3f,p99=%.3f,p99.9=%.3f\r\n",
+			name, h.Percentile(50), h.Percentile(99), h.Percentile(99.9)))
+	}
 
 	return b.String()
 }
@@ -354,42 +623,36 @@ func timeCmd(ctx *command.Context) (*command.Reply, error) {
 }
 
 // COMMAND - returns information about commands
-// COMMAND (no args) - returns list of all commands
+// COMMAND (no args) - returns info about every registered command
 // COMMAND COUNT - returns total number of commands
-// COMMAND INFO command1 [command2 ...] - returns info about specified commands
+// COMMAND INFO [command ...] - returns info about the specified commands
+// COMMAND DOCS [command ...] - returns documentation for the specified commands
 // COMMAND GETKEYS - returns keys from a command
 // COMMAND GETKEYSANDFLAGS - returns keys and flags from a command
+// COMMAND HELP - returns help text
 func commandCmd(ctx *command.Context) (*command.Reply, error) {
+	if serverDisp == nil {
+		return command.NewErrorReplyStr("ERR command table not initialized"), nil
+	}
+
 	if len(ctx.Args) == 0 {
-		// Return list of all command names
-		// This is not standard Redis behavior but useful for clients
-		return command.NewErrorReplyStr("ERR COMMAND without args not implemented, use COMMAND COUNT or COMMAND INFO"), nil
+		return commandInfoReply(nil), nil
 	}
 
 	subcmd := strings.ToUpper(ctx.Args[0])
 
 	switch subcmd {
 	case "COUNT":
-		// Return total number of commands
-		// We need to get this from dispatcher somehow
-		// For now, return a reasonable number
-		return command.NewIntegerReply(150), nil
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'COMMAND COUNT'"), nil
+		}
+		return command.NewIntegerReply(int64(len(serverDisp.Commands()))), nil
 
 	case "INFO":
-		if len(ctx.Args) < 2 {
-			// Return info for all commands (empty array for now)
-			return command.NewArrayReplyFromAny([]interface{}{}), nil
-		}
-		// Return info for specific commands
-		cmdNames := ctx.Args[1:]
-		result := make([]interface{}, 0, len(cmdNames))
-		for _, name := range cmdNames {
-			cmdInfo := getCommandInfo(name)
-			if cmdInfo != nil {
-				result = append(result, cmdInfo)
-			}
-		}
-		return command.NewArrayReplyFromAny(result), nil
+		return commandInfoReply(ctx.Args[1:]), nil
+
+	case "DOCS":
+		return commandDocsReply(ctx.Args[1:]), nil
 
 	case "GETKEYS":
 		if len(ctx.Args) < 2 {
@@ -407,80 +670,159 @@ func commandCmd(ctx *command.Context) (*command.Reply, error) {
 		// For simplicity, just return empty array
 		return command.NewArrayReplyFromAny([]interface{}{}), nil
 
+	case "HELP":
+		return command.NewBulkStringReply("COMMAND <subcommand> [<arg> ...]\n" +
+			"Subcommands:\n" +
+			"(no subcommand)  Return details about all commands\n" +
+			"COUNT  Return the total number of commands\n" +
+			"INFO [<command-name> ...]  Return details about the named commands, or all commands\n" +
+			"DOCS [<command-name> ...]  Return documentation for the named commands, or all commands"), nil
+
 	default:
 		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown COMMAND subcommand '%s'", subcmd)), nil
 	}
 }
 
-// getCommandInfo returns command information in Redis format
-// Returns an array of: [name, arity, flags, first_key, last_key, step_count]
-func getCommandInfo(cmdName string) []interface{} {
-	// Map of command info for commonly used commands
-	cmdInfo := map[string][]interface{}{
-		"GET":     {"GET", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"SET":     {"SET", -3, []string{"write", "denyoom"}, 1, 1, 1},
-		"HGET":    {"HGET", 3, []string{"readonly", "fast"}, 1, 1, 1},
-		"HSET":    {"HSET", -4, []string{"write", "denyoom"}, 1, 1, 1},
-		"HGETALL": {"HGETALL", 2, []string{"readonly", "sort_for_script"}, 1, 1, 1},
-		"HKEYS":   {"HKEYS", 2, []string{"readonly", "sort_for_script"}, 1, 1, 1},
-		"HVALS":   {"HVALS", 2, []string{"readonly", "sort_for_script"}, 1, 1, 1},
-		"HLEN":    {"HLEN", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"HDEL":    {"HDEL", -3, []string{"write", "fast"}, 1, 1, 1},
-		"HEXISTS": {"HEXISTS", 3, []string{"readonly", "fast"}, 1, 1, 1},
-		"HINCRBY": {"HINCRBY", 4, []string{"write", "denyoom", "fast"}, 1, 1, 1},
-		"HSCAN":   {"HSCAN", -3, []string{"readonly"}, 1, 1, 1},
-		"SADD":    {"SADD", -3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
-		"SREM":    {"SREM", -3, []string{"write", "fast"}, 1, 1, 1},
-		"SISMEMBER": {"SISMEMBER", 3, []string{"readonly", "fast"}, 1, 1, 1},
-		"SMEMBERS": {"SMEMBERS", 2, []string{"readonly", "sort_for_script"}, 1, 1, 1},
-		"SCARD":   {"SCARD", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"SPOP":    {"SPOP", -2, []string{"write", "random", "fast"}, 1, 1, 1},
-		"SRANDMEMBER": {"SRANDMEMBER", -2, []string{"readonly", "random", "fast"}, 1, 1, 1},
-		"SSCAN":   {"SSCAN", -3, []string{"readonly"}, 1, 1, 1},
-		"SMOVE":   {"SMOVE", 4, []string{"write", "fast"}, 1, 2, 1},
-		"DEL":     {"DEL", -2, []string{"write"}, 1, -1, 1},
-		"EXISTS":  {"EXISTS", -2, []string{"readonly", "fast"}, 1, -1, 1},
-		"TYPE":    {"TYPE", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"KEYS":    {"KEYS", 2, []string{"readonly", "sort_for_script"}, 1, 1, 1},
-		"SCAN":    {"SCAN", -2, []string{"readonly"}, 1, 1, 1},
-		"DBSIZE":  {"DBSIZE", 1, []string{"readonly", "fast"}, 0, 0, 0},
-		"PING":    {"PING", -1, []string{"fast", "stale"}, 0, 0, 0},
-		"INFO":    {"INFO", -1, []string{"readonly", "loading"}, 0, 0, 0},
-		"TTL":     {"TTL", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"PTTL":    {"PTTL", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"EXPIRE":  {"EXPIRE", -3, []string{"write", "fast"}, 1, 1, 1},
-		"LPUSH":   {"LPUSH", -3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
-		"RPUSH":   {"RPUSH", -3, []string{"write", "denyoom", "fast"}, 1, 1, 1},
-		"LPOP":    {"LPOP", -2, []string{"write", "fast"}, 1, 1, 1},
-		"RPOP":    {"RPOP", -2, []string{"write", "fast"}, 1, 1, 1},
-		"LRANGE":  {"LRANGE", 4, []string{"readonly"}, 1, 1, 1},
-		"LLEN":    {"LLEN", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"ZADD":    {"ZADD", -4, []string{"write", "denyoom", "fast"}, 1, 1, 1},
-		"ZREM":    {"ZREM", -3, []string{"write", "fast"}, 1, 1, 1},
-		"ZSCORE":  {"ZSCORE", 3, []string{"readonly", "fast"}, 1, 1, 1},
-		"ZRANGE":  {"ZRANGE", -4, []string{"readonly"}, 1, 1, 1},
-		"ZCARD":   {"ZCARD", 2, []string{"readonly", "fast"}, 1, 1, 1},
-		"ZSCAN":   {"ZSCAN", -3, []string{"readonly"}, 1, 1, 1},
-	}
-
-	nameUpper := strings.ToUpper(cmdName)
-	if info, ok := cmdInfo[nameUpper]; ok {
-		return info
-	}
-
-	// Return generic info for unknown commands
-	return []interface{}{
-		cmdName, // name
-		0,       // arity (unknown)
-		[]string{}, // flags
-		0,       // first_key
-		0,       // last_key
-		0,       // step_count
+// lookupCommands returns the named commands, with nil for unknown names,
+// or every registered command sorted by name when names is empty
+func lookupCommands(names []string) []*command.Command {
+	if len(names) > 0 {
+		cmds := make([]*command.Command, len(names))
+		for i, name := range names {
+			if cmd, ok := serverDisp.Get(name); ok {
+				cmds[i] = cmd
+			}
+		}
+		return cmds
+	}
+
+	all := serverDisp.Commands()
+	cmds := make([]*command.Command, 0, len(all))
+	for _, cmd := range all {
+		cmds = append(cmds, cmd)
 	}
+	sort.Slice(cmds, func(i, j int) bool { return cmds[i].Name < cmds[j].Name })
+	return cmds
+}
+
+// commandInfoReply builds the COMMAND INFO reply for the named commands
+func commandInfoReply(names []string) *command.Reply {
+	cmds := lookupCommands(names)
+	result := make([]*command.Reply, len(cmds))
+	for i, cmd := range cmds {
+		if cmd == nil {
+			result[i] = command.NewNilReply()
+			continue
+		}
+		result[i] = commandInfo(cmd)
+	}
+	return command.NewArrayReply(result)
+}
+
+// commandInfo returns command information in Redis format, an array of:
+// [name, arity, flags, first_key, last_key, step, acl_categories, tips,
+// key_specs, subcommands]
+func commandInfo(cmd *command.Command) *command.Reply {
+	flags := make([]*command.Reply, len(cmd.Flags))
+	for i, flag := range cmd.Flags {
+		flags[i] = command.NewStatusReply(flag)
+	}
+
+	categories := make([]*command.Reply, len(cmd.Categories))
+	for i, cat := range cmd.Categories {
+		categories[i] = command.NewStatusReply("@" + cat)
+	}
+
+	return command.NewArrayReply([]*command.Reply{
+		command.NewBulkStringReply(strings.ToLower(cmd.Name)),
+		command.NewIntegerReply(int64(cmd.Arity)),
+		command.NewArrayReply(flags),
+		command.NewIntegerReply(int64(cmd.FirstKey)),
+		command.NewIntegerReply(int64(cmd.LastKey)),
+		command.NewIntegerReply(int64(commandKeyStep(cmd))),
+		command.NewArrayReply(categories),
+		command.NewArrayReply(nil),
+		commandKeySpecs(cmd),
+		command.NewArrayReply(nil),
+	})
+}
+
+// commandKeyStep returns the step between key arguments, or 0 for a
+// command that takes no keys
+func commandKeyStep(cmd *command.Command) int {
+	if cmd.FirstKey <= 0 {
+		return 0
+	}
+	if cmd.StepCount <= 0 {
+		return 1
+	}
+	return cmd.StepCount
+}
+
+// commandKeySpecs describes the key positions of cmd as a single key spec:
+// keys start at index FirstKey and run up to LastKey. Like in Redis, the
+// range's lastkey is relative to the first key, with -1 meaning the last
+// argument.
+func commandKeySpecs(cmd *command.Command) *command.Reply {
+	if cmd.FirstKey <= 0 {
+		return command.NewArrayReply(nil)
+	}
+
+	lastKey := cmd.LastKey
+	if lastKey >= 0 {
+		lastKey -= cmd.FirstKey
+	}
+
+	spec := command.NewArrayReplyFromAny([]interface{}{
+		"flags", command.NewArrayReply(nil),
+		"begin_search", []interface{}{
+			"type", "index",
+			"spec", []interface{}{"index", cmd.FirstKey},
+		},
+		"find_keys", []interface{}{
+			"type", "range",
+			"spec", []interface{}{
+				"lastkey", lastKey,
+				"keystep", commandKeyStep(cmd),
+				"limit", 0,
+			},
+		},
+	})
+	return command.NewArrayReply([]*command.Reply{spec})
+}
+
+// commandDocsReply builds the COMMAND DOCS reply: a flat array of command
+// names, each followed by its documentation. Godis keeps no summaries, so
+// the documentation only carries the group and arity. Unknown names are
+// skipped.
+func commandDocsReply(names []string) *command.Reply {
+	result := make([]*command.Reply, 0, len(names)*2)
+	for _, cmd := range lookupCommands(names) {
+		if cmd == nil {
+			continue
+		}
+
+		group := "generic"
+		if len(cmd.Categories) > 0 {
+			group = cmd.Categories[0]
+		}
+
+		result = append(result,
+			command.NewBulkStringReply(strings.ToLower(cmd.Name)),
+			command.NewArrayReplyFromAny([]interface{}{
+				"group", group,
+				"arity", cmd.Arity,
+			}),
+		)
+	}
+	return command.NewArrayReply(result)
 }
 
 // DEBUG subcommand implementation
 // DEBUG OBJECT key - returns debugging information about a key
+// DEBUG HTSTATS dbid - shows the hash tables of a database
+// DEBUG RELOAD DB n - reloads a single database through the RDB codec
+// DEBUG SET-ACTIVE-EXPIRE 0|1 - disables or enables active expiration
 // DEBUG HELP - returns help text
 func debugCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 1 {
@@ -496,16 +838,161 @@ func debugCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 		return debugObject(ctx)
 
+	case "RELOAD":
+		if len(ctx.Args) != 3 || strings.ToUpper(ctx.Args[1]) != "DB" {
+			return command.NewErrorReplyStr("ERR syntax error, expected DEBUG RELOAD DB <index>"), nil
+		}
+		return debugReloadDB(ctx)
+
+	case "HTSTATS":
+		if len(ctx.Args) != 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'DEBUG HTSTATS' command"), nil
+		}
+		return debugHTStats(ctx)
+
+	case "LISTPACK":
+		if len(ctx.Args) != 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'DEBUG LISTPACK' command"), nil
+		}
+		return debugListpack(ctx)
+
+	case "SLEEP":
+		if len(ctx.Args) != 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'DEBUG SLEEP' command"), nil
+		}
+		seconds, err := strconv.ParseFloat(ctx.Args[1], 64)
+		if err != nil || seconds < 0 {
+			return command.NewErrorReplyStr("ERR value is not a valid float"), nil
+		}
+		time.Sleep(time.Duration(seconds * float64(time.Second)))
+		return command.NewStatusReply("OK"), nil
+
+	case "SET-ACTIVE-EXPIRE":
+		if len(ctx.Args) != 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'DEBUG SET-ACTIVE-EXPIRE' command"), nil
+		}
+		return debugSetActiveExpire(ctx)
+
 	case "HELP":
 		return command.NewBulkStringReply("DEBUG <subcommand> <key> [args]\n" +
 			"Subcommands:\n" +
-			"OBJECT  Return debugging information about a key"), nil
+			"HTSTATS <dbid>  Show the hash tables of the database, and rehash progress\n" +
+			"OBJECT  Return debugging information about a key\n" +
+			"LISTPACK <key>  Show the size of a listpack encoded list\n" +
+			"RELOAD DB <index>  Serialize and reload a single database\n" +
+			"SET-ACTIVE-EXPIRE <0|1>  Disable or enable active expiration of keys\n" +
+			"SLEEP <seconds>  Stop the server for <seconds>; decimals are allowed"), nil
 
 	default:
 		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown DEBUG subcommand '%s'", subcmd)), nil
 	}
 }
 
+// debugHTStats describes the hash tables of the keys and of the expires of
+// a database, including how far an incremental rehash has got
+func debugHTStats(ctx *command.Context) (*command.Reply, error) {
+	index, err := strconv.Atoi(ctx.Args[1])
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+	if dbSelector == nil {
+		return command.NewErrorReplyStr("ERR database selector not initialized"), nil
+	}
+	db, err := dbSelector.GetDB(index)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR Out of range database"), nil
+	}
+
+	keys, expires := db.HTStats()
+	var info strings.Builder
+	info.WriteString("[Dictionary HT]\n")
+	writeDictStats(&info, keys)
+	info.WriteString("[Expires HT]\n")
+	writeDictStats(&info, expires)
+	return command.NewBulkStringReply(info.String()), nil
+}
+
+// writeDictStats writes the stats of a dictionary in the DEBUG HTSTATS
+// format
+func writeDictStats(info *strings.Builder, stats database.DictStats) {
+	tables := 1
+	if stats.RehashIdx != -1 {
+		tables = 2
+		fmt.Fprintf(info, "Rehashing: bucket %d of %d\n", stats.RehashIdx, stats.Tables[0].Size)
+	}
+	for i := 0; i < tables; i++ {
+		name := "main hash table"
+		if i == 1 {
+			name = "rehashing target"
+		}
+		t := stats.Tables[i]
+		fmt.Fprintf(info, "Hash table %d stats (%s):\n", i, name)
+		fmt.Fprintf(info, " table size: %d\n", t.Size)
+		fmt.Fprintf(info, " number of elements: %d\n", t.Used)
+		fmt.Fprintf(info, " longest chain length: %d\n", t.MaxChainLen)
+	}
+}
+
+// debugListpack reports the entries and bytes of a listpack encoded list
+func debugListpack(ctx *command.Context) (*command.Reply, error) {
+	obj, ok := ctx.DB.Get(ctx.Args[1])
+	if !ok {
+		return command.NewErrorReplyStr("ERR no such key"), nil
+	}
+	l, ok := obj.Ptr.(*list.List)
+	if !ok || obj.Encoding != database.ObjEncodingListpack {
+		return command.NewErrorReplyStr("ERR The value stored at the specified key is not represented using an listpack"), nil
+	}
+
+	elems := l.ToSlice()
+	return command.NewBulkStringReply(fmt.Sprintf("encoding:listpack entries:%d bytes:%d", len(elems), list.ListpackBytes(elems))), nil
+}
+
+// debugSetActiveExpire toggles the active expire cycle. With it disabled,
+// expired keys are only removed lazily, when accessed.
+func debugSetActiveExpire(ctx *command.Context) (*command.Reply, error) {
+	var enabled bool
+	switch ctx.Args[1] {
+	case "0":
+		enabled = false
+	case "1":
+		enabled = true
+	default:
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+
+	if expireScheduler == nil {
+		return command.NewErrorReplyStr("ERR expire scheduler not initialized"), nil
+	}
+
+	expireScheduler.SetActiveExpire(enabled)
+	return command.NewStatusReply("OK"), nil
+}
+
+// debugReloadDB round-trips one database through the RDB codec. Other
+// databases are not touched, which keeps persistence tests isolated.
+func debugReloadDB(ctx *command.Context) (*command.Reply, error) {
+	index, err := strconv.Atoi(ctx.Args[2])
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+
+	if dbSelector == nil {
+		return command.NewErrorReplyStr("ERR database selector not initialized"), nil
+	}
+
+	db, err := dbSelector.GetDB(index)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR DB index is out of range"), nil
+	}
+
+	if err := rdb.ReloadDB(db); err != nil {
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR Error trying to reload DB %d: %v", index, err)), nil
+	}
+
+	return command.NewStatusReply("OK"), nil
+}
+
 func debugObject(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[1]
 
@@ -523,33 +1010,17 @@ func debugObject(ctx *command.Context) (*command.Reply, error) {
 	info.Write([]byte(fmt.Sprintf("%016x", 12345))) // Fake memory address
 
 	info.Write([]byte(" refcount:"))
-	info.Write([]byte(fmt.Sprintf("%d", 1)))
+	info.Write([]byte(fmt.Sprintf("%d", obj.RefCount())))
 
 	info.Write([]byte(" encoding:"))
-	// ObjType: 0=String, 1=List, 2=Hash, 3=Set, 4=ZSet, 5=Stream
-	switch obj.Type {
-	case 0: // String
-		if _, ok := obj.Ptr.(int64); ok {
-			info.Write([]byte("int"))
-		} else {
-			info.Write([]byte("embstr"))
-		}
-	case 1: // List
-		info.Write([]byte("linkedlist"))
-	case 2: // Hash
-		info.Write([]byte("hashtable"))
-	case 3: // Set
-		info.Write([]byte("hashtable"))
-	case 4: // ZSet
-		info.Write([]byte("skiplist"))
-	case 5: // Stream
-		info.Write([]byte("stream"))
-	default:
-		info.Write([]byte("unknown"))
-	}
+	info.Write([]byte(getEncoding(obj)))
 
+	serializedLength, err := rdb.SerializedLength(obj)
+	if err != nil {
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR %v", err)), nil
+	}
 	info.Write([]byte(" serializedlength:"))
-	info.Write([]byte(fmt.Sprintf("%d", 0))) // We don't track this
+	info.Write([]byte(strconv.FormatUint(serializedLength, 10)))
 
 	info.Write([]byte(" lru:"))
 	info.Write([]byte(fmt.Sprintf("%d", 0))) // We don't track LRU
@@ -561,10 +1032,14 @@ func debugObject(ctx *command.Context) (*command.Reply, error) {
 }
 
 // CLIENT subcommand implementation
-// CLIENT LIST - returns information about connected clients
+// CLIENT LIST [ID client-id ...] - returns information about connected clients
+// CLIENT INFO - returns information about the current connection
 // CLIENT GETNAME - returns the name of the current connection
 // CLIENT SETNAME - sets the name of the current connection
 // CLIENT ID - returns the client ID
+// CLIENT KILL addr | CLIENT KILL [ID id] [ADDR addr] [SKIPME yes|no] - closes connections
+// CLIENT NO-EVICT on|off - exempts the connection from client eviction
+// CLIENT NO-TOUCH on|off - stops the connection's commands updating key access times
 func clientCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(ctx.Args) < 1 {
 		return command.NewErrorReplyStr("ERR wrong number of arguments for 'CLIENT' command"), nil
@@ -574,67 +1049,259 @@ func clientCmd(ctx *command.Context) (*command.Reply, error) {
 
 	switch subcmd {
 	case "LIST":
-		// Return list of connected clients
-		// Format: id=... addr=... fd=... name=... age=... idle=...
-		addr := ""
-		if ctx.Conn.RemoteAddr() != nil {
-			addr = ctx.Conn.RemoteAddr().String()
-		}
-		return command.NewBulkStringReply(fmt.Sprintf("id=%d addr=%s fd=%d name=%s age=%d idle=%d\n",
-			ctx.Conn.GetID(),
-			addr,
-			0,
-			ctx.Conn.GetName(),
-			0,
-			0,
-		)), nil
+		return clientList(ctx)
+
+	case "INFO":
+		return command.NewBulkStringReply(clientInfoLine(ctx.Conn, time.Now()) + "\n"), nil
 
 	case "GETNAME":
-		// Return the name of the current connection
 		name := ctx.Conn.GetName()
 		if name == "" {
-			return command.NewBulkStringReply(""), nil
+			return command.NewNilReply(), nil
 		}
 		return command.NewBulkStringReply(name), nil
 
 	case "SETNAME":
-		if len(ctx.Args) < 2 {
+		if len(ctx.Args) != 2 {
 			return command.NewErrorReplyStr("ERR wrong number of arguments for 'CLIENT SETNAME' command"), nil
 		}
+		if !validClientName(ctx.Args[1]) {
+			return errInvalidClientName, nil
+		}
 		ctx.Conn.SetName(ctx.Args[1])
 		return command.NewStatusReply("OK"), nil
 
 	case "ID":
-		// Return the client ID
 		return command.NewIntegerReply(int64(ctx.Conn.GetID())), nil
 
-	case "INFO":
-		// Return client info as a map
-		addr := ""
-		if ctx.Conn.RemoteAddr() != nil {
-			addr = ctx.Conn.RemoteAddr().String()
+	case "KILL":
+		return clientKill(ctx)
+
+	case "NO-EVICT":
+		return clientSetFlag(ctx, net.FlagNoEvict)
+
+	case "NO-TOUCH":
+		return clientSetFlag(ctx, net.FlagNoTouch)
+
+	default:
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown CLIENT subcommand '%s'", subcmd)), nil
+	}
+}
+
+// clientSetFlag turns a connection flag on or off, for CLIENT NO-EVICT
+// and CLIENT NO-TOUCH
+func clientSetFlag(ctx *command.Context, flag uint32) (*command.Reply, error) {
+	if len(ctx.Args) != 2 {
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR wrong number of arguments for 'CLIENT %s' command", strings.ToUpper(ctx.Args[0]))), nil
+	}
+
+	switch strings.ToLower(ctx.Args[1]) {
+	case "on":
+		ctx.Conn.AddFlag(flag)
+	case "off":
+		ctx.Conn.RemoveFlag(flag)
+	default:
+		return command.NewErrorReplyStr("ERR syntax error"), nil
+	}
+	return command.NewStatusReply("OK"), nil
+}
+
+// clientConnections returns the live connections ordered by ID. Without a
+// registry only the current connection is known.
+func clientConnections(ctx *command.Context) []*net.Conn {
+	if clientRegistry == nil {
+		return []*net.Conn{ctx.Conn}
+	}
+
+	conns := clientRegistry.GetConnections()
+	sort.Slice(conns, func(i, j int) bool { return conns[i].GetID() < conns[j].GetID() })
+	return conns
+}
+
+// clientList implements CLIENT LIST, one line per connection
+func clientList(ctx *command.Context) (*command.Reply, error) {
+	var ids map[uint64]bool
+	if len(ctx.Args) > 1 {
+		if strings.ToUpper(ctx.Args[1]) != "ID" || len(ctx.Args) < 3 {
+			return command.NewErrorReplyStr("ERR syntax error"), nil
 		}
-		info := map[string]string{
-			"id":   fmt.Sprintf("%d", ctx.Conn.GetID()),
-			"addr": addr,
-			"name": ctx.Conn.GetName(),
-			"db":   fmt.Sprintf("%d", ctx.Conn.GetDB()),
+		ids = make(map[uint64]bool, len(ctx.Args)-2)
+		for _, arg := range ctx.Args[2:] {
+			id, err := strconv.ParseUint(arg, 10, 64)
+			if err != nil || id == 0 {
+				return command.NewErrorReplyStr("ERR Invalid client ID"), nil
+			}
+			ids[id] = true
 		}
-		// Convert map to alternating keys/values
-		result := make([]string, 0, len(info)*2)
-		for k, v := range info {
-			result = append(result, k, v)
+	}
+
+	now := time.Now()
+	var list strings.Builder
+	for _, conn := range clientConnections(ctx) {
+		if ids != nil && !ids[conn.GetID()] {
+			continue
 		}
-		return command.NewStringArrayReply(result), nil
+		list.WriteString(clientInfoLine(conn, now))
+		list.WriteByte('\n')
+	}
+	return command.NewBulkStringReply(list.String()), nil
+}
 
-	case "KILL":
-		// For now, just return OK
-		// Real implementation would need connection tracking in server
+// clientInfoLine formats a connection the way CLIENT LIST reports it
+func clientInfoLine(conn *net.Conn, now time.Time) string {
+	addr, laddr := "", ""
+	if conn.RemoteAddr() != nil {
+		addr = conn.RemoteAddr().String()
+	}
+	if conn.LocalAddr() != nil {
+		laddr = conn.LocalAddr().String()
+	}
+
+	multi := -1
+	if conn.IsInMulti() && txManager != nil {
+		multi = txManager.GetQueueLength(conn)
+	}
+
+	return fmt.Sprintf("id=%d addr=%s laddr=%s name=%s age=%d idle=%d flags=%s db=%d sub=%d psub=%d ssub=%d multi=%d resp=%d",
+		conn.GetID(),
+		addr,
+		laddr,
+		conn.GetName(),
+		int64(now.Sub(conn.GetCreatedAt()).Seconds()),
+		int64(now.Sub(conn.GetLastActive()).Seconds()),
+		clientFlags(conn),
+		conn.GetDB(),
+		len(conn.GetSubscriptions()),
+		len(conn.GetPatterns()),
+		len(conn.GetShardSubscriptions()),
+		multi,
+		conn.GetProtocol(),
+	)
+}
+
+// clientFlags returns the CLIENT LIST flags of a connection, N when none
+// apply
+func clientFlags(conn *net.Conn) string {
+	var flags strings.Builder
+	if conn.HasFlag(net.FlagSlave) {
+		flags.WriteByte('S')
+	}
+	if conn.HasFlag(net.FlagMaster) {
+		flags.WriteByte('M')
+	}
+	if conn.IsInPubSub() {
+		flags.WriteByte('P')
+	}
+	if conn.IsInMulti() {
+		flags.WriteByte('x')
+	}
+	if conn.IsDirty() {
+		flags.WriteByte('d')
+	}
+	if conn.HasFlag(net.FlagCloseAfterReply) {
+		flags.WriteByte('c')
+	}
+	if conn.HasFlag(net.FlagNoEvict) {
+		flags.WriteByte('e')
+	}
+	if conn.HasFlag(net.FlagNoTouch) {
+		flags.WriteByte('T')
+	}
+	if flags.Len() == 0 {
+		return "N"
+	}
+	return flags.String()
+}
+
+// clientKill implements CLIENT KILL. The old form takes a single address
+// and replies OK; the filter form replies with the number of connections
+// closed and, unless SKIPME no is given, never closes the caller.
+func clientKill(ctx *command.Context) (*command.Reply, error) {
+	if len(ctx.Args) < 2 {
+		return command.NewErrorReplyStr("ERR wrong number of arguments for 'CLIENT KILL' command"), nil
+	}
+
+	var (
+		id      uint64
+		addr    string
+		skipMe  = true
+		oldForm = len(ctx.Args) == 2
+	)
+	if oldForm {
+		addr = ctx.Args[1]
+	} else {
+		if len(ctx.Args)%2 == 0 {
+			return command.NewErrorReplyStr("ERR syntax error"), nil
+		}
+		for i := 1; i < len(ctx.Args); i += 2 {
+			value := ctx.Args[i+1]
+			switch strings.ToUpper(ctx.Args[i]) {
+			case "ID":
+				parsed, err := strconv.ParseUint(value, 10, 64)
+				if err != nil || parsed == 0 {
+					return command.NewErrorReplyStr("ERR client-id should be greater than 0"), nil
+				}
+				id = parsed
+			case "ADDR":
+				addr = value
+			case "SKIPME":
+				switch strings.ToLower(value) {
+				case "yes":
+					skipMe = true
+				case "no":
+					skipMe = false
+				default:
+					return command.NewErrorReplyStr("ERR syntax error"), nil
+				}
+			default:
+				return command.NewErrorReplyStr("ERR syntax error"), nil
+			}
+		}
+	}
+
+	killed := 0
+	for _, conn := range clientConnections(ctx) {
+		if id != 0 && conn.GetID() != id {
+			continue
+		}
+		if addr != "" && (conn.RemoteAddr() == nil || conn.RemoteAddr().String() != addr) {
+			continue
+		}
+
+		if conn == ctx.Conn {
+			if skipMe && !oldForm {
+				continue
+			}
+			// Closing now would lose the reply
+			conn.AddFlag(net.FlagCloseAfterReply)
+		} else if clientRegistry != nil {
+			_ = clientRegistry.CloseConnection(conn)
+		}
+		killed++
+	}
+
+	if oldForm {
+		if killed == 0 {
+			return command.NewErrorReplyStr("ERR No such client"), nil
+		}
 		return command.NewStatusReply("OK"), nil
+	}
+	return command.NewIntegerReply(int64(killed)), nil
+}
 
-	default:
-		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown CLIENT subcommand '%s'", subcmd)), nil
+// errInvalidClientName refuses a client name with spaces or special
+// characters, which CLIENT LIST could not show
+var errInvalidClientName = command.NewErrorReplyStr("ERR Client names cannot contain spaces, newlines or special characters.")
+
+// validClientName reports whether name only has printable characters
+// other than space
+func validClientName(name string) bool {
+	for _, c := range name {
+		if c <= ' ' || c > '~' {
+			return false
+		}
 	}
+	return true
 }
 
 // HELLO [protocol-version [AUTH username password] [SETNAME clientname]]
@@ -654,18 +1321,52 @@ func helloCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewErrorReplyStr("ERR NOPROTO unsupported protocol version"), nil
 	}
 
+	var user, password, clientName string
+	hasAuth, hasName := false, false
+	for i := 1; i < len(ctx.Args); i++ {
+		switch {
+		case strings.EqualFold(ctx.Args[i], "AUTH") && i+2 < len(ctx.Args):
+			user, password, hasAuth = ctx.Args[i+1], ctx.Args[i+2], true
+			i += 2
+		case strings.EqualFold(ctx.Args[i], "SETNAME") && i+1 < len(ctx.Args):
+			if !validClientName(ctx.Args[i+1]) {
+				return errInvalidClientName, nil
+			}
+			clientName, hasName = ctx.Args[i+1], true
+			i++
+		default:
+			return command.NewErrorReplyStr(fmt.Sprintf("ERR Syntax error in HELLO option '%s'", ctx.Args[i])), nil
+		}
+	}
+
+	if aclUsers != nil {
+		if hasAuth {
+			if _, ok := aclUsers.Authenticate(user, password); !ok {
+				return command.NewErrorReplyStr("WRONGPASS invalid username-password pair or user is disabled."), nil
+			}
+			ctx.Conn.SetUser(user)
+		} else if ctx.Conn.GetUser() == "" && aclUsers.AuthRequired() {
+			return command.NewErrorReplyStr("NOAUTH HELLO must be called with the client already authenticated, otherwise the HELLO <proto> AUTH <user> <pass> option can be used to authenticate the client and select the RESP protocol version at the same time"), nil
+		}
+	}
+	if hasName {
+		ctx.Conn.SetName(clientName)
+	}
+	ctx.Conn.SetProtocol(protocol)
+
 	// Return server info as a map
 	// Format: [key, value, key, value, ...]
-	result := make([]interface{}, 0, 20)
-	result = append(result, "server", "godis")
-	result = append(result, "version", "1.0.0")
-	result = append(result, "proto", fmt.Sprintf("%d", protocol))
-	result = append(result, "id", fmt.Sprintf("%d", ctx.Conn.GetID()))
-	result = append(result, "mode", "standalone")
-	result = append(result, "role", "master")
-	result = append(result, "modules", []interface{}{})
+	result := []*command.Reply{
+		command.NewBulkStringReply("server"), command.NewBulkStringReply("godis"),
+		command.NewBulkStringReply("version"), command.NewBulkStringReply(serverVersion),
+		command.NewBulkStringReply("proto"), command.NewIntegerReply(int64(protocol)),
+		command.NewBulkStringReply("id"), command.NewIntegerReply(int64(ctx.Conn.GetID())),
+		command.NewBulkStringReply("mode"), command.NewBulkStringReply("standalone"),
+		command.NewBulkStringReply("role"), command.NewBulkStringReply(serverRole()),
+		command.NewBulkStringReply("modules"), command.NewArrayReply([]*command.Reply{}),
+	}
 
-	return command.NewArrayReplyFromAny(result), nil
+	return command.NewArrayReply(result), nil
 }
 
 // MODULE LIST / MODULE LOAD / MODULE UNLOAD
@@ -691,3 +1392,246 @@ func moduleCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown MODULE subcommand '%s'", subcmd)), nil
 	}
 }
+
+// SLOWLOG GET [count] / SLOWLOG LEN / SLOWLOG RESET
+func slowlogCmd(ctx *command.Context) (*command.Reply, error) {
+	if serverDisp == nil {
+		return command.NewErrorReplyStr("ERR slow log not available"), nil
+	}
+	slowLog := serverDisp.SlowLog()
+	subcmd := strings.ToUpper(ctx.Args[0])
+
+	switch subcmd {
+	case "GET":
+		if len(ctx.Args) > 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'SLOWLOG|GET' command"), nil
+		}
+		count := 10
+		if len(ctx.Args) == 2 {
+			n, err := strconv.Atoi(ctx.Args[1])
+			if err != nil || n < -1 {
+				return command.NewErrorReplyStr("ERR count should be greater than or equal to -1"), nil
+			}
+			count = n
+		}
+		return slowlogEntriesReply(slowLog.Entries(count)), nil
+
+	case "LEN":
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'SLOWLOG|LEN' command"), nil
+		}
+		return command.NewIntegerReply(int64(slowLog.Len())), nil
+
+	case "RESET":
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'SLOWLOG|RESET' command"), nil
+		}
+		slowLog.Reset()
+		return command.NewStatusReply("OK"), nil
+
+	case "HELP":
+		return command.NewStringArrayReply([]string{
+			"SLOWLOG <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"GET [<count>]",
+			"    Return top <count> entries from the slowlog (default: 10, -1 mean all).",
+			"LEN",
+			"    Return the length of the slowlog.",
+			"RESET",
+			"    Reset the slowlog.",
+		}), nil
+
+	default:
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown subcommand '%s'. Try SLOWLOG HELP.", ctx.Args[0])), nil
+	}
+}
+
+// slowlogEntriesReply builds the SLOWLOG GET reply: for each entry its id,
+// start time, duration in microseconds, arguments, client address and name
+func slowlogEntriesReply(entries []command.SlowLogEntry) *command.Reply {
+	result := make([]*command.Reply, 0, len(entries))
+	for _, e := range entries {
+		result = append(result, command.NewArrayReply([]*command.Reply{
+			command.NewIntegerReply(e.ID),
+			command.NewIntegerReply(e.Time.Unix()),
+			command.NewIntegerReply(e.Duration.Microseconds()),
+			command.NewStringArrayReply(e.Args),
+			command.NewBulkStringReply(e.ClientAddr),
+			command.NewBulkStringReply(e.ClientName),
+		}))
+	}
+	return command.NewArrayReply(result)
+}
+
+// LATENCY LATEST / LATENCY HISTORY event / LATENCY RESET [event ...]
+func latencyCmd(ctx *command.Context) (*command.Reply, error) {
+	monitor := latency.Instance()
+	subcmd := strings.ToUpper(ctx.Args[0])
+
+	switch subcmd {
+	case "LATEST":
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'LATENCY|LATEST' command"), nil
+		}
+		latest := monitor.Latest()
+		result := make([]*command.Reply, 0, len(latest))
+		for _, s := range latest {
+			result = append(result, command.NewArrayReply([]*command.Reply{
+				command.NewBulkStringReply(s.Event),
+				command.NewIntegerReply(s.Time),
+				command.NewIntegerReply(s.Latest),
+				command.NewIntegerReply(s.Maximum),
+			}))
+		}
+		return command.NewArrayReply(result), nil
+
+	case "HISTORY":
+		if len(ctx.Args) != 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'LATENCY|HISTORY' command"), nil
+		}
+		history := monitor.History(ctx.Args[1])
+		result := make([]*command.Reply, 0, len(history))
+		for _, s := range history {
+			result = append(result, command.NewArrayReply([]*command.Reply{
+				command.NewIntegerReply(s.Time),
+				command.NewIntegerReply(s.Latency),
+			}))
+		}
+		return command.NewArrayReply(result), nil
+
+	case "RESET":
+		return command.NewIntegerReply(int64(monitor.Reset(ctx.Args[1:]...))), nil
+
+	case "HELP":
+		return command.NewStringArrayReply([]string{
+			"LATENCY <subcommand> [<arg> [value] [opt] ...]. Subcommands are:",
+			"LATEST",
+			"    Return the latest latency samples for all events.",
+			"HISTORY <event>",
+			"    Return time-latency samples for the <event>.",
+			"RESET [<event> ...]",
+			"    Reset latency data of one or more <event> classes.",
+			"    (default: reset all data for all event classes)",
+		}), nil
+
+	default:
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown subcommand '%s'. Try LATENCY HELP.", ctx.Args[0])), nil
+	}
+}
+
+// CONFIG GET pattern [pattern ...] / CONFIG SET parameter value [parameter value ...] / CONFIG RESETSTAT
+func configCmd(ctx *command.Context) (*command.Reply, error) {
+	subcmd := strings.ToUpper(ctx.Args[0])
+
+	switch subcmd {
+	case "GET":
+		if len(ctx.Args) < 2 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'CONFIG|GET' command"), nil
+		}
+		return configGet(ctx.Args[1:])
+
+	case "SET":
+		if len(ctx.Args) < 3 || len(ctx.Args)%2 != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'CONFIG|SET' command"), nil
+		}
+		return configSet(ctx.Args[1:])
+
+	case "RESETSTAT":
+		if len(ctx.Args) != 1 {
+			return command.NewErrorReplyStr("ERR wrong number of arguments for 'CONFIG|RESETSTAT' command"), nil
+		}
+		if dbSelector != nil {
+			dbSelector.GetEvictionManager().ResetStats()
+		}
+		if serverDisp != nil {
+			serverDisp.Stats().Reset()
+		}
+		return command.NewStatusReply("OK"), nil
+
+	default:
+		return command.NewErrorReplyStr(fmt.Sprintf("ERR unknown CONFIG subcommand '%s'", subcmd)), nil
+	}
+}
+
+// configGet returns the name/value pairs of the parameters matching any of
+// the glob patterns
+func configGet(patterns []string) (*command.Reply, error) {
+	cfg := config.Instance()
+
+	result := make([]*command.Reply, 0)
+	for _, name := range cfg.Names() {
+		matched := false
+		for _, pattern := range patterns {
+			if utils.GlobMatch(strings.ToLower(pattern), name) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			continue
+		}
+		value, _ := cfg.Get(name)
+		result = append(result, command.NewBulkStringReply(name), command.NewBulkStringReply(value))
+	}
+
+	return command.NewArrayReply(result), nil
+}
+
+// configSet applies parameter/value pairs, then pushes memory settings to
+// the live eviction manager
+func configSet(pairs []string) (*command.Reply, error) {
+	cfg := config.Instance()
+
+	evictionChanged, encodingChanged := false, false
+	for i := 0; i < len(pairs); i += 2 {
+		name := strings.ToLower(pairs[i])
+		if _, ok := cfg.Get(name); !ok {
+			return command.NewErrorReplyStr(fmt.Sprintf("ERR Unknown option or number of arguments for CONFIG SET - '%s'", pairs[i])), nil
+		}
+		if err := cfg.Set(name, pairs[i+1]); err != nil {
+			return command.NewErrorReplyStr(fmt.Sprintf("ERR Invalid argument '%s' for CONFIG SET '%s' - %v", pairs[i+1], name, err)), nil
+		}
+		if name == "maxmemory" || name == "maxmemory-policy" || name == "maxmemory-samples" {
+			evictionChanged = true
+		}
+		if strings.HasPrefix(name, "slowlog-") && serverDisp != nil {
+			serverDisp.SlowLog().SetSlowerThan(cfg.SlowLogLogSlowerThan)
+			serverDisp.SlowLog().SetMaxLen(cfg.SlowLogMaxLen)
+		}
+		if name == "latency-monitor-threshold" {
+			latency.Instance().SetThreshold(cfg.LatencyMonitorThreshold)
+		}
+		if name == "rdbchecksum" && rdbManager != nil {
+			rdbManager.SetChecksum(cfg.RdbChecksum)
+		}
+		if name == "repl-backlog-size" && replicationMgr != nil {
+			replicationMgr.SetBacklogSize(int(cfg.ReplBacklogSize))
+		}
+		if strings.HasSuffix(name, "-entries") || strings.HasSuffix(name, "-value") || config.CanonicalName(name) == "list-max-ziplist-size" || name == "list-compress-depth" {
+			encodingChanged = true
+		}
+	}
+
+	if encodingChanged {
+		database.SetEncodingLimits(database.EncodingLimits{
+			HashMaxEntries:      cfg.HashMaxZiplistEntries,
+			HashMaxValue:        cfg.HashMaxZiplistValue,
+			ListMaxSize:         cfg.ListMaxZiplistSize,
+			ListCompressDepth:   cfg.ListCompressDepth,
+			ZSetMaxEntries:      cfg.ZSetMaxZiplistEntries,
+			ZSetMaxValue:        cfg.ZSetMaxZiplistValue,
+			SetMaxIntsetEntries: cfg.SetMaxIntsetEntries,
+		})
+	}
+
+	if evictionChanged && dbSelector != nil {
+		policy, err := eviction.PolicyFromString(cfg.MaxMemoryPolicy)
+		if err != nil {
+			return nil, err
+		}
+		dbSelector.SetEvictionPolicy(policy)
+		dbSelector.SetMaxMemory(cfg.MaxMemory)
+		dbSelector.GetEvictionManager().SetSamples(cfg.MaxMemorySamples)
+	}
+
+	return command.NewStatusReply("OK"), nil
+}
@@ -0,0 +1,956 @@
+package commands
+
+import (
+	"context"
+	"math"
+	gonet "net"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/config"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/zset"
+	"github.com/zyhnesmr/godis/internal/eviction"
+	"github.com/zyhnesmr/godis/internal/expire"
+	"github.com/zyhnesmr/godis/internal/latency"
+	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/internal/replication"
+)
+
+// newTestContext creates a command context backed by an in-memory connection
+func newTestContext(t *testing.T, db *database.DB, args ...string) *command.Context {
+	t.Helper()
+
+	client, server := gonet.Pipe()
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+
+	conn := net.NewConn(server)
+	conn.SetID(1)
+	return &command.Context{DB: db, Conn: conn, Args: args}
+}
+
+// helloField returns the value following the given field in a HELLO reply
+func helloField(t *testing.T, reply *command.Reply, field string) *command.Reply {
+	t.Helper()
+
+	items, ok := reply.Value.([]*command.Reply)
+	if !ok {
+		t.Fatalf("HELLO expected array reply, got %T", reply.Value)
+	}
+	for i := 0; i+1 < len(items); i += 2 {
+		if items[i].Value == field {
+			return items[i+1]
+		}
+	}
+	t.Fatalf("HELLO reply missing field %q", field)
+	return nil
+}
+
+func TestHelloRoleFollowsReplicaOf(t *testing.T) {
+	SetReplicationManager(replication.NewManager())
+	defer SetReplicationManager(nil)
+
+	db := database.NewDB(0)
+	ctx := newTestContext(t, db)
+
+	reply, _ := helloCmd(ctx)
+	if role := helloField(t, reply, "role").Value; role != "master" {
+		t.Errorf("HELLO role expected master, got %v", role)
+	}
+	if id := helloField(t, reply, "id").Value; id != int64(1) {
+		t.Errorf("HELLO id expected 1, got %v", id)
+	}
+	if modules := helloField(t, reply, "modules"); modules.Type != command.ReplyTypeArray {
+		t.Errorf("HELLO modules expected array, got %v", modules.Type)
+	}
+
+	ctx.Args = []string{"127.0.0.1", "6380"}
+	if reply, _ := replicaofCmd(ctx); reply.IsError() {
+		t.Fatalf("REPLICAOF failed: %v", reply.Value)
+	}
+
+	ctx.Args = nil
+	reply, _ = helloCmd(ctx)
+	if role := helloField(t, reply, "role").Value; role != "slave" {
+		t.Errorf("HELLO role after REPLICAOF expected slave, got %v", role)
+	}
+
+	ctx.Args = []string{"NO", "ONE"}
+	if reply, _ := replicaofCmd(ctx); reply.IsError() {
+		t.Fatalf("REPLICAOF NO ONE failed: %v", reply.Value)
+	}
+
+	ctx.Args = nil
+	reply, _ = helloCmd(ctx)
+	if role := helloField(t, reply, "role").Value; role != "master" {
+		t.Errorf("HELLO role after REPLICAOF NO ONE expected master, got %v", role)
+	}
+}
+
+func TestReplicaRefusesWrites(t *testing.T) {
+	SetReplicationManager(replication.NewManager())
+	defer SetReplicationManager(nil)
+
+	if err := CheckWritesAllowed(); err != nil {
+		t.Fatalf("master refused writes: %v", err)
+	}
+
+	ctx := newTestContext(t, nil, "127.0.0.1", "1")
+	if reply, _ := replicaofCmd(ctx); reply.IsError() {
+		t.Fatalf("REPLICAOF failed: %v", reply.Value)
+	}
+	if err := CheckWritesAllowed(); err == nil || !strings.HasPrefix(err.Error(), "READONLY") {
+		t.Fatalf("replica expected READONLY, got %v", err)
+	}
+
+	ctx.Args = []string{"NO", "ONE"}
+	if reply, _ := replicaofCmd(ctx); reply.IsError() {
+		t.Fatalf("REPLICAOF NO ONE failed: %v", reply.Value)
+	}
+	if err := CheckWritesAllowed(); err != nil {
+		t.Fatalf("promoted replica refused writes: %v", err)
+	}
+}
+
+func TestInfoCommandStats(t *testing.T) {
+	disp := command.NewDispatcher(database.NewDBSelector(1))
+	RegisterServerCommands(disp)
+	RegisterStringCommands(disp)
+
+	conn := newTestContext(t, nil).Conn
+	const n = 42
+	for i := 0; i < n; i++ {
+		dispatch(t, disp, conn, "GET", "k")
+	}
+	dispatch(t, disp, conn, "SET", "k", "v")
+
+	// cmdstatLine returns the INFO commandstats line of a command
+	cmdstatLine := func(name string) string {
+		info := dispatch(t, disp, conn, "INFO", "commandstats")
+		for _, line := range strings.Split(info, "\r\n") {
+			if strings.HasPrefix(line, "cmdstat_"+name+":") {
+				return line
+			}
+		}
+		return ""
+	}
+
+	line := cmdstatLine("get")
+	fields := make(map[string]float64)
+	for _, field := range strings.Split(strings.TrimPrefix(line, "cmdstat_get:"), ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			t.Fatalf("malformed field %q in %q", field, line)
+		}
+		v, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil {
+			t.Fatalf("malformed field %q in %q", field, line)
+		}
+		fields[parts[0]] = v
+	}
+	if fields["calls"] != n {
+		t.Fatalf("cmdstat_get reports %v calls, want %d: %q", fields["calls"], n, line)
+	}
+	if want := fields["usec"] / n; math.Abs(fields["usec_per_call"]-want) > 0.01 {
+		t.Errorf("usec_per_call=%v, want %v", fields["usec_per_call"], want)
+	}
+	if line := cmdstatLine("set"); !strings.HasPrefix(line, "cmdstat_set:calls=1,") {
+		t.Errorf("cmdstat_set line is %q", line)
+	}
+
+	dispatch(t, disp, conn, "CONFIG", "RESETSTAT")
+	if line := cmdstatLine("get"); line != "" {
+		t.Errorf("CONFIG RESETSTAT left %q", line)
+	}
+	if line := cmdstatLine("info"); !strings.HasPrefix(line, "cmdstat_info:calls=1,") {
+		t.Errorf("after CONFIG RESETSTAT, cmdstat_info line is %q", line)
+	}
+}
+
+func TestInfoLatencyStats(t *testing.T) {
+	disp := command.NewDispatcher(database.NewDBSelector(1))
+	RegisterServerCommands(disp)
+
+	ctx := newTestContext(t, nil)
+	for i := 0; i < 1000; i++ {
+		if _, err := disp.Dispatch(context.Background(), ctx.Conn, "PING", nil); err != nil {
+			t.Fatalf("PING failed: %v", err)
+		}
+	}
+
+	ctx.Args = []string{"latencystats"}
+	reply, _ := infoCmd(ctx)
+	info := reply.Value.(string)
+
+	var line string
+	for _, l := range strings.Split(info, "\r\n") {
+		if strings.HasPrefix(l, "latency_percentiles_usec_ping:") {
+			line = l
+		}
+	}
+	if line == "" {
+		t.Fatalf("INFO latencystats missing ping line:\n%s", info)
+	}
+
+	var prev float64
+	for _, field := range strings.Split(strings.TrimPrefix(line, "latency_percentiles_usec_ping:"), ",") {
+		parts := strings.SplitN(field, "=", 2)
+		if len(parts) != 2 {
+			t.Fatalf("malformed percentile field %q", field)
+		}
+		v, err := strconv.ParseFloat(parts[1], 64)
+		if err != nil || v <= 0 || v > 1e6 {
+			t.Errorf("implausible %s value %q", parts[0], parts[1])
+		}
+		if v < prev {
+			t.Errorf("%s=%v lower than previous percentile %v", parts[0], v, prev)
+		}
+		prev = v
+	}
+}
+
+func TestDebugReloadSingleDB(t *testing.T) {
+	selector := setupPersistence(t)
+	db0, _ := selector.GetDB(0)
+	db1, _ := selector.GetDB(1)
+
+	db0.Set("a", database.NewStringObject("zero"))
+	db1.Set("a", database.NewStringObject("one"))
+	db1.Set("n", database.NewStringObject("42"))
+	zobj := database.NewZSetObject()
+	zobj.Ptr.(*zset.ZSet).Add("m", 1.5)
+	db1.Set("z", zobj)
+
+	// Mutate DB 0 before reloading DB 1
+	db0.Set("a", database.NewStringObject("changed"))
+	db0.Set("b", database.NewStringObject("new"))
+
+	ctx := newTestContext(t, db0, "RELOAD", "DB", "1")
+	reply, _ := debugCmd(ctx)
+	if reply.IsError() {
+		t.Fatalf("DEBUG RELOAD DB 1 failed: %v", reply.Value)
+	}
+
+	if obj, ok := db0.Get("a"); !ok || obj.String() != "changed" {
+		t.Errorf("DB 0 key a expected changed after reload of DB 1")
+	}
+	if _, ok := db0.Get("b"); !ok {
+		t.Errorf("DB 0 key b lost after reload of DB 1")
+	}
+
+	if size := db1.DBSize(); size != 3 {
+		t.Errorf("DB 1 expected 3 keys after reload, got %d", size)
+	}
+	if obj, ok := db1.Get("a"); !ok || obj.String() != "one" {
+		t.Errorf("DB 1 key a expected one after reload")
+	}
+	if obj, ok := db1.Get("n"); !ok || obj.String() != "42" {
+		t.Errorf("DB 1 key n expected 42 after reload")
+	}
+	obj, ok := db1.Get("z")
+	if !ok {
+		t.Fatal("DB 1 key z missing after reload")
+	}
+	if score, ok := obj.Ptr.(*zset.ZSet).Score("m"); !ok || score != 1.5 {
+		t.Errorf("DB 1 zset member m expected score 1.5, got %v", score)
+	}
+
+	ctx.Args = []string{"RELOAD", "DB", "5"}
+	if reply, _ := debugCmd(ctx); !reply.IsError() {
+		t.Error("DEBUG RELOAD of out of range DB expected error")
+	}
+}
+
+// fakeRegistry reports fixed connection counts and connections
+type fakeRegistry struct {
+	connected int
+	total     uint64
+	conns     []*net.Conn
+}
+
+func (r fakeRegistry) GetConnectionCount() int     { return r.connected }
+func (r fakeRegistry) TotalConnections() uint64    { return r.total }
+func (r fakeRegistry) GetConnections() []*net.Conn { return append([]*net.Conn(nil), r.conns...) }
+func (r fakeRegistry) CloseConnection(conn *net.Conn) error {
+	return conn.Close()
+}
+
+func TestInfoSections(t *testing.T) {
+	selector := setupPersistence(t)
+	disp := command.NewDispatcher(selector)
+	RegisterServerCommands(disp)
+	SetClientRegistry(fakeRegistry{connected: 3, total: 7})
+	t.Cleanup(func() { SetClientRegistry(nil) })
+
+	db, _ := selector.GetDB(1)
+	db.Set("a", database.NewStringObject("1"))
+	db.Set("b", database.NewStringObject("2"))
+	db.Expire("b", 100)
+
+	ctx := newTestContext(t, db)
+	for i := 0; i < 5; i++ {
+		if _, err := disp.Dispatch(context.Background(), ctx.Conn, "PING", nil); err != nil {
+			t.Fatalf("PING failed: %v", err)
+		}
+	}
+
+	info := func(section string) string {
+		ctx.Args = []string{section}
+		reply, _ := infoCmd(ctx)
+		return reply.Value.(string)
+	}
+
+	tests := []struct {
+		section string
+		want    string
+	}{
+		{"server", "process_id:" + strconv.Itoa(os.Getpid()) + "\r\n"},
+		{"clients", "connected_clients:3\r\n"},
+		{"memory", "used_memory:" + strconv.FormatInt(selector.GetTotalMemoryUsage(), 10) + "\r\n"},
+		{"persistence", "aof_enabled:0\r\n"},
+		{"stats", "total_commands_processed:5\r\n"},
+		{"stats", "total_connections_received:7\r\n"},
+		{"keyspace", "db1:keys=2,expires=1,avg_ttl=0\r\n"},
+	}
+	for _, tt := range tests {
+		got := info(tt.section)
+		if !strings.Contains(got, tt.want) {
+			t.Errorf("INFO %s expected %q, got:\n%s", tt.section, tt.want, got)
+		}
+		if n := strings.Count(got, "# "); n != 1 {
+			t.Errorf("INFO %s expected a single section, got %d:\n%s", tt.section, n, got)
+		}
+	}
+
+	if got := info("keyspace"); strings.Contains(got, "db0:") {
+		t.Errorf("INFO keyspace listed the empty db0:\n%s", got)
+	}
+
+	ctx.Args = nil
+	reply, _ := infoCmd(ctx)
+	all := reply.Value.(string)
+	for _, header := range []string{"# Server", "# Clients", "# Memory", "# Persistence", "# Stats", "# Keyspace"} {
+		if !strings.Contains(all, header+"\r\n") {
+			t.Errorf("INFO missing %s section", header)
+		}
+	}
+}
+
+func TestDebugObjectSerializedLength(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("k", database.NewStringObject("hello"))
+
+	reply, err := debugCmd(newTestContext(t, db, "OBJECT", "k"))
+	if err != nil {
+		t.Fatalf("DEBUG OBJECT failed: %v", err)
+	}
+	// A 5 byte string is its length byte followed by the bytes
+	if s := reply.Value.(string); !strings.Contains(s, " serializedlength:6 ") {
+		t.Errorf("DEBUG OBJECT expected serializedlength:6, got %q", s)
+	}
+}
+
+func TestConfigSetEnablesEviction(t *testing.T) {
+	selector := setupPersistence(t)
+	db, _ := selector.GetDB(0)
+	for i := 0; i < 100; i++ {
+		db.Set("key:"+strconv.Itoa(i), database.NewStringObject(strings.Repeat("v", 100)))
+	}
+
+	cfg := config.Instance()
+	maxMemory, policy, samples := cfg.MaxMemory, cfg.MaxMemoryPolicy, cfg.MaxMemorySamples
+	t.Cleanup(func() {
+		cfg.MaxMemory, cfg.MaxMemoryPolicy, cfg.MaxMemorySamples = maxMemory, policy, samples
+	})
+
+	ctx := newTestContext(t, db, "SET", "maxmemory", "1kb", "maxmemory-policy", "noeviction")
+	if reply, _ := configCmd(ctx); reply.IsError() {
+		t.Fatalf("CONFIG SET failed: %v", reply.Value)
+	}
+	if selector.ShouldEvict() {
+		t.Fatal("eviction expected to stay off under noeviction")
+	}
+
+	ctx.Args = []string{"SET", "maxmemory-policy", "garbage"}
+	if reply, _ := configCmd(ctx); !reply.IsError() {
+		t.Fatal("CONFIG SET of an invalid policy expected error")
+	}
+	if cfg.MaxMemoryPolicy != "noeviction" {
+		t.Errorf("invalid policy replaced the current one: %s", cfg.MaxMemoryPolicy)
+	}
+
+	ctx.Args = []string{"SET", "maxmemory-policy", "allkeys-lru"}
+	if reply, _ := configCmd(ctx); reply.IsError() {
+		t.Fatalf("CONFIG SET maxmemory-policy failed: %v", reply.Value)
+	}
+	if !selector.ShouldEvict() {
+		t.Fatal("eviction expected to start after switching to allkeys-lru")
+	}
+	if err := selector.CheckAndEvict(); err != nil {
+		t.Fatalf("eviction failed: %v", err)
+	}
+	if size := db.DBSize(); size >= 100 {
+		t.Errorf("expected keys to be evicted, still have %d", size)
+	}
+
+	mgr := selector.GetEvictionManager()
+	ctx.Args = []string{"SET", "maxmemory-samples", "10"}
+	if reply, _ := configCmd(ctx); reply.IsError() {
+		t.Fatalf("CONFIG SET maxmemory-samples failed: %v", reply.Value)
+	}
+	if got := mgr.GetSamples(); got != 10 {
+		t.Errorf("eviction samples expected 10, got %d", got)
+	}
+	if got := mgr.GetPolicy(); got != eviction.PolicyAllKeysLRU {
+		t.Errorf("policy expected to stay allkeys-lru, got %s", got)
+	}
+
+	ctx.Args = []string{"GET", "maxmemory*"}
+	reply, _ := configCmd(ctx)
+	got := make([]string, 0)
+	for _, r := range reply.Value.([]*command.Reply) {
+		got = append(got, r.Value.(string))
+	}
+	want := []string{"maxmemory", "1024", "maxmemory-policy", "allkeys-lru", "maxmemory-samples", "10"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("CONFIG GET maxmemory* = %v, want %v", got, want)
+	}
+}
+
+// configPairs flattens a CONFIG GET reply
+func configPairs(t *testing.T, reply *command.Reply) []string {
+	t.Helper()
+
+	pairs := make([]string, 0)
+	for _, r := range reply.Value.([]*command.Reply) {
+		pairs = append(pairs, r.Value.(string))
+	}
+	return pairs
+}
+
+func TestConfigGetGlob(t *testing.T) {
+	cfg := config.Instance()
+	ctx := newTestContext(t, nil, "GET", "max*")
+
+	reply, _ := configCmd(ctx)
+	got := configPairs(t, reply)
+	want := []string{
+		"maxclients", strconv.FormatInt(cfg.MaxClients, 10),
+		"maxmemory", strconv.FormatInt(cfg.MaxMemory, 10),
+		"maxmemory-policy", cfg.MaxMemoryPolicy,
+		"maxmemory-samples", strconv.Itoa(cfg.MaxMemorySamples),
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("CONFIG GET max* = %v, want %v", got, want)
+	}
+
+	ctx.Args = []string{"GET", "*-max-ziplist-entries", "set-max-intset-[e]ntries"}
+	reply, _ = configCmd(ctx)
+	got = configPairs(t, reply)
+	want = []string{
+		"hash-max-ziplist-entries", strconv.Itoa(cfg.HashMaxZiplistEntries),
+		"set-max-intset-entries", strconv.Itoa(cfg.SetMaxIntsetEntries),
+		"zset-max-ziplist-entries", strconv.Itoa(cfg.ZSetMaxZiplistEntries),
+	}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("CONFIG GET of advanced keys = %v, want %v", got, want)
+	}
+
+	ctx.Args = []string{"GET", "*"}
+	reply, _ = configCmd(ctx)
+	if n := len(configPairs(t, reply)); n != 2*len(cfg.Names()) {
+		t.Errorf("CONFIG GET * returned %d items, want %d", n, 2*len(cfg.Names()))
+	}
+}
+
+func TestConfigSetEncodingLimits(t *testing.T) {
+	cfg := config.Instance()
+	entries := cfg.HashMaxZiplistEntries
+	t.Cleanup(func() {
+		cfg.HashMaxZiplistEntries = entries
+		database.SetEncodingLimits(database.DefaultEncodingLimits())
+	})
+
+	db := database.NewDB(0)
+	ctx := newTestContext(t, db, "SET", "hash-max-ziplist-entries", "2")
+	if reply, _ := configCmd(ctx); reply.IsError() {
+		t.Fatalf("CONFIG SET hash-max-ziplist-entries failed: %v", reply.Value)
+	}
+	if got := database.GetEncodingLimits().HashMaxEntries; got != 2 {
+		t.Errorf("hash entry limit = %d, want 2", got)
+	}
+
+	ctx.Args = []string{"h", "a", "1", "b", "2", "c", "3"}
+	hsetCmd(ctx)
+	if enc := objectEncodingOf(t, db, "h"); enc != "hashtable" {
+		t.Errorf("hash with 3 fields encoding = %s, want hashtable", enc)
+	}
+}
+
+func TestConfigListpackAliases(t *testing.T) {
+	cfg := config.Instance()
+	entries := cfg.ZSetMaxZiplistEntries
+	t.Cleanup(func() {
+		cfg.ZSetMaxZiplistEntries = entries
+		database.SetEncodingLimits(database.DefaultEncodingLimits())
+	})
+
+	ctx := newTestContext(t, nil, "SET", "zset-max-listpack-entries", "2")
+	if reply, _ := configCmd(ctx); reply.IsError() {
+		t.Fatalf("CONFIG SET zset-max-listpack-entries failed: %v", reply.Value)
+	}
+	if got := database.GetEncodingLimits().ZSetMaxEntries; got != 2 {
+		t.Errorf("zset entry limit = %d, want 2", got)
+	}
+
+	ctx.Args = []string{"GET", "zset-max-*-entries"}
+	reply, _ := configCmd(ctx)
+	got := configPairs(t, reply)
+	want := []string{"zset-max-ziplist-entries", "2", "zset-max-listpack-entries", "2"}
+	if strings.Join(got, " ") != strings.Join(want, " ") {
+		t.Errorf("CONFIG GET zset-max-*-entries = %v, want %v", got, want)
+	}
+}
+
+func TestSelectValidatesIndex(t *testing.T) {
+	db := database.NewDB(0)
+
+	ctx := newTestContext(t, db, strconv.Itoa(config.Instance().Databases))
+	reply, _ := selectCmd(ctx)
+	if got := string(reply.Marshal()); got != "-ERR DB index is out of range\r\n" {
+		t.Errorf("SELECT out of range expected DB index error, got %q", got)
+	}
+	if ctx.Conn.GetDB() != 0 {
+		t.Errorf("connection DB expected to stay 0, got %d", ctx.Conn.GetDB())
+	}
+
+	ctx.Args = []string{"1abc"}
+	reply, _ = selectCmd(ctx)
+	if got := string(reply.Marshal()); got != "-ERR value is not an integer or out of range\r\n" {
+		t.Errorf("SELECT 1abc expected integer error, got %q", got)
+	}
+
+	ctx.Args = []string{"1"}
+	if reply, _ := selectCmd(ctx); reply.IsError() {
+		t.Fatalf("SELECT 1 failed: %s", reply.Marshal())
+	}
+	if ctx.Conn.GetDB() != 1 {
+		t.Errorf("connection DB expected 1, got %d", ctx.Conn.GetDB())
+	}
+}
+
+func TestDebugSetActiveExpire(t *testing.T) {
+	db := database.NewDB(0)
+
+	scheduler := expire.NewScheduler(expire.NewManager(nil))
+	scheduler.SetConfig(expire.Config{
+		TickInterval:         time.Millisecond,
+		ActiveExpireInterval: time.Millisecond,
+		FastCycleInterval:    time.Millisecond,
+	})
+	scheduler.SetDatabases([]expire.ActiveExpireDB{db})
+	SetExpireScheduler(scheduler)
+	scheduler.Start()
+	t.Cleanup(func() {
+		scheduler.Stop()
+		SetExpireScheduler(nil)
+	})
+
+	if reply, _ := debugCmd(newTestContext(t, db, "SET-ACTIVE-EXPIRE", "0")); reply.IsError() {
+		t.Fatalf("DEBUG SET-ACTIVE-EXPIRE 0 failed: %s", reply.Marshal())
+	}
+
+	db.Set("k", database.NewStringObject("v"))
+	db.ExpireAt("k", time.Now().Unix()-1)
+	time.Sleep(50 * time.Millisecond)
+	if !db.GetDict().Exists("k") {
+		t.Fatal("expired key removed while active expire is disabled")
+	}
+	if _, ok := db.Get("k"); ok {
+		t.Error("expired key expected missing on access")
+	}
+	if db.GetDict().Exists("k") {
+		t.Error("expired key expected removed lazily on access")
+	}
+
+	if reply, _ := debugCmd(newTestContext(t, db, "SET-ACTIVE-EXPIRE", "1")); reply.IsError() {
+		t.Fatalf("DEBUG SET-ACTIVE-EXPIRE 1 failed: %s", reply.Marshal())
+	}
+
+	db.Set("k", database.NewStringObject("v"))
+	db.ExpireAt("k", time.Now().Unix()-1)
+	deadline := time.Now().Add(time.Second)
+	for db.GetDict().Exists("k") && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if db.GetDict().Exists("k") {
+		t.Error("expired key expected removed by active expire")
+	}
+
+	if reply, _ := debugCmd(newTestContext(t, db, "SET-ACTIVE-EXPIRE", "2")); !reply.IsError() {
+		t.Error("DEBUG SET-ACTIVE-EXPIRE 2 expected error")
+	}
+}
+
+func TestCommandCountAndInfo(t *testing.T) {
+	disp := command.NewDispatcher(database.NewDBSelector(1))
+	RegisterServerCommands(disp)
+	RegisterStringCommands(disp)
+	RegisterKeyCommands(disp)
+
+	ctx := newTestContext(t, nil, "COUNT")
+	reply, _ := commandCmd(ctx)
+	if want := int64(len(disp.Commands())); reply.Value != want {
+		t.Fatalf("COMMAND COUNT = %v, want %d", reply.Value, want)
+	}
+
+	ctx.Args = []string{"INFO", "get", "nosuchcommand"}
+	reply, _ = commandCmd(ctx)
+	infos := reply.Value.([]*command.Reply)
+	if len(infos) != 2 {
+		t.Fatalf("COMMAND INFO returned %d entries, want 2", len(infos))
+	}
+	if infos[1].Type != command.ReplyTypeNil {
+		t.Errorf("COMMAND INFO of an unknown command = %v, want nil", infos[1].Value)
+	}
+
+	get := infos[0].Value.([]*command.Reply)
+	if get[0].Value != "get" {
+		t.Errorf("COMMAND INFO GET name = %v, want get", get[0].Value)
+	}
+	if get[1].Value != int64(2) {
+		t.Errorf("COMMAND INFO GET arity = %v, want 2", get[1].Value)
+	}
+	for i, want := range []int64{1, 1, 1} {
+		if got := get[3+i].Value; got != want {
+			t.Errorf("COMMAND INFO GET key position %d = %v, want %d", i, got, want)
+		}
+	}
+	if specs := get[8].Value.([]*command.Reply); len(specs) != 1 {
+		t.Errorf("COMMAND INFO GET has %d key specs, want 1", len(specs))
+	}
+
+	ctx.Args = nil
+	reply, _ = commandCmd(ctx)
+	if n := len(reply.Value.([]*command.Reply)); n != len(disp.Commands()) {
+		t.Errorf("COMMAND returned %d entries, want %d", n, len(disp.Commands()))
+	}
+
+	ctx.Args = []string{"DOCS", "get"}
+	reply, _ = commandCmd(ctx)
+	if docs := reply.Value.([]*command.Reply); len(docs) != 2 || docs[0].Value != "get" {
+		t.Errorf("COMMAND DOCS GET = %v, want the get entry", reply.Value)
+	}
+}
+
+func TestInlinePing(t *testing.T) {
+	disp := command.NewDispatcher(database.NewDBSelector(1))
+	RegisterServerCommands(disp)
+
+	client, server := gonet.Pipe()
+	defer client.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go net.DefaultHandle(ctx, net.NewConn(server), disp)
+
+	_ = client.SetDeadline(time.Now().Add(5 * time.Second))
+	if _, err := client.Write([]byte("PING\r\n")); err != nil {
+		t.Fatalf("write failed: %v", err)
+	}
+
+	buf := make([]byte, 64)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+	if got := string(buf[:n]); got != "+PONG\r\n" {
+		t.Errorf("inline PING = %q, want %q", got, "+PONG\r\n")
+	}
+}
+
+func TestClientSetNameAndList(t *testing.T) {
+	ctx := newTestContext(t, nil)
+	other := newTestContext(t, nil).Conn
+	other.SetID(2)
+	SetClientRegistry(fakeRegistry{connected: 2, total: 2, conns: []*net.Conn{other, ctx.Conn}})
+	t.Cleanup(func() { SetClientRegistry(nil) })
+
+	ctx.Args = []string{"GETNAME"}
+	if reply, _ := clientCmd(ctx); reply.Type != command.ReplyTypeNil {
+		t.Errorf("CLIENT GETNAME without a name = %v, want nil", reply.Value)
+	}
+
+	ctx.Args = []string{"SETNAME", "bad name"}
+	if reply, _ := clientCmd(ctx); !reply.IsError() {
+		t.Error("CLIENT SETNAME with a space expected error")
+	}
+
+	ctx.Args = []string{"SETNAME", "worker"}
+	if reply, _ := clientCmd(ctx); reply.Value != "OK" {
+		t.Fatalf("CLIENT SETNAME = %v, want OK", reply.Value)
+	}
+
+	ctx.Args = []string{"GETNAME"}
+	if reply, _ := clientCmd(ctx); reply.Value != "worker" {
+		t.Errorf("CLIENT GETNAME = %v, want worker", reply.Value)
+	}
+
+	ctx.Args = []string{"ID"}
+	if reply, _ := clientCmd(ctx); reply.Value != int64(1) {
+		t.Errorf("CLIENT ID = %v, want 1", reply.Value)
+	}
+
+	ctx.Args = []string{"LIST"}
+	reply, _ := clientCmd(ctx)
+	lines := strings.Split(strings.TrimSuffix(reply.Value.(string), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("CLIENT LIST returned %d lines, want 2:\n%s", len(lines), reply.Value)
+	}
+	if !strings.HasPrefix(lines[0], "id=1 ") || !strings.Contains(lines[0], " name=worker ") {
+		t.Errorf("CLIENT LIST first line = %q, want id=1 named worker", lines[0])
+	}
+	if !strings.HasPrefix(lines[1], "id=2 ") || !strings.Contains(lines[1], " name= ") {
+		t.Errorf("CLIENT LIST second line = %q, want unnamed id=2", lines[1])
+	}
+	for _, field := range []string{" age=", " idle=", " db=0 ", " flags=N "} {
+		if !strings.Contains(lines[0], field) {
+			t.Errorf("CLIENT LIST line %q missing %q", lines[0], field)
+		}
+	}
+
+	ctx.Args = []string{"LIST", "ID", "2"}
+	reply, _ = clientCmd(ctx)
+	if list := reply.Value.(string); !strings.HasPrefix(list, "id=2 ") || strings.Count(list, "\n") != 1 {
+		t.Errorf("CLIENT LIST ID 2 = %q, want only id=2", list)
+	}
+
+	ctx.Args = []string{"KILL", "ID", "1"}
+	if reply, _ := clientCmd(ctx); reply.Value != int64(0) {
+		t.Errorf("CLIENT KILL of the caller without SKIPME no = %v, want 0", reply.Value)
+	}
+
+	ctx.Args = []string{"KILL", "ID", "2"}
+	if reply, _ := clientCmd(ctx); reply.Value != int64(1) {
+		t.Errorf("CLIENT KILL ID 2 = %v, want 1", reply.Value)
+	}
+	if !other.IsClosed() {
+		t.Error("CLIENT KILL ID 2 did not close the connection")
+	}
+}
+
+func TestSelectChecksDatabaseCount(t *testing.T) {
+	selector := database.NewDBSelector(16)
+	SetDBSelectorForPersistence(selector)
+	t.Cleanup(func() { SetDBSelectorForPersistence(nil) })
+
+	// Raising databases in the config does not create more databases
+	cfg := config.Instance()
+	saved := cfg.Databases
+	cfg.Databases = 100
+	t.Cleanup(func() { cfg.Databases = saved })
+
+	db, _ := selector.GetDB(0)
+	for _, index := range []string{"99", "16", "-1"} {
+		ctx := newTestContext(t, db, index)
+		reply, _ := selectCmd(ctx)
+		if got := string(reply.Marshal()); got != "-ERR DB index is out of range\r\n" {
+			t.Errorf("SELECT %s expected DB index error, got %q", index, got)
+		}
+		if ctx.Conn.GetDB() != 0 {
+			t.Errorf("SELECT %s changed the connection DB to %d", index, ctx.Conn.GetDB())
+		}
+	}
+
+	if reply, _ := selectCmd(newTestContext(t, db, "15")); reply.IsError() {
+		t.Errorf("SELECT 15 failed: %s", reply.Marshal())
+	}
+}
+
+func TestPing(t *testing.T) {
+	ctx := newTestContext(t, nil)
+
+	reply, _ := pingCmd(ctx)
+	if got := string(reply.Marshal()); got != "+PONG\r\n" {
+		t.Errorf("PING = %q, want +PONG", got)
+	}
+
+	ctx.Args = []string{"hello"}
+	reply, _ = pingCmd(ctx)
+	if got := string(reply.Marshal()); got != "$5\r\nhello\r\n" {
+		t.Errorf("PING hello = %q, want bulk hello", got)
+	}
+
+	ctx.Args = []string{"a", "b"}
+	if reply, _ := pingCmd(ctx); !reply.IsError() {
+		t.Error("PING with two arguments expected error")
+	}
+
+	ctx.Args = []string{"hello world"}
+	reply, _ = echoCmd(ctx)
+	if got := string(reply.Marshal()); got != "$11\r\nhello world\r\n" {
+		t.Errorf("ECHO = %q, want bulk hello world", got)
+	}
+}
+
+func TestPingInSubscribeMode(t *testing.T) {
+	ctx := newTestContext(t, nil)
+	ctx.Conn.Subscribe("news")
+
+	reply, _ := pingCmd(ctx)
+	if got := string(reply.Marshal()); got != "*2\r\n$4\r\npong\r\n$0\r\n\r\n" {
+		t.Errorf("subscribed PING = %q, want [pong, \"\"]", got)
+	}
+
+	ctx.Args = []string{"hello"}
+	reply, _ = pingCmd(ctx)
+	if got := string(reply.Marshal()); got != "*2\r\n$4\r\npong\r\n$5\r\nhello\r\n" {
+		t.Errorf("subscribed PING hello = %q, want [pong, hello]", got)
+	}
+
+	// RESP3 connections can receive replies in subscribe mode
+	ctx.Conn.SetProtocol(3)
+	reply, _ = pingCmd(ctx)
+	if got := string(reply.Marshal()); got != "$5\r\nhello\r\n" {
+		t.Errorf("subscribed RESP3 PING hello = %q, want bulk hello", got)
+	}
+}
+
+func TestSlowLog(t *testing.T) {
+	disp := command.NewDispatcher(database.NewDBSelector(1))
+	RegisterServerCommands(disp)
+	disp.SlowLog().SetSlowerThan(20000)
+	conn := newTestContext(t, nil).Conn
+
+	disp.DispatchCommand(nil, conn, "PING", nil)
+	if reply, _ := disp.DispatchCommand(nil, conn, "DEBUG", []string{"SLEEP", "0.03"}); reply.Value != "OK" {
+		t.Fatalf("DEBUG SLEEP = %v, want OK", reply.Value)
+	}
+
+	reply, _ := disp.DispatchCommand(nil, conn, "SLOWLOG", []string{"LEN"})
+	if reply.Value != int64(1) {
+		t.Fatalf("SLOWLOG LEN = %v, want 1", reply.Value)
+	}
+
+	reply, _ = disp.DispatchCommand(nil, conn, "SLOWLOG", []string{"GET"})
+	entries := reply.Value.([]*command.Reply)
+	if len(entries) != 1 {
+		t.Fatalf("SLOWLOG GET returned %d entries, want 1", len(entries))
+	}
+	entry := entries[0].Value.([]*command.Reply)
+	if id := entry[0].Value.(int64); id != 0 {
+		t.Errorf("entry id = %d, want 0", id)
+	}
+	if usec := entry[2].Value.(int64); usec < 30000 {
+		t.Errorf("entry duration = %dus, want at least 30000", usec)
+	}
+	if args := strings.Join(entry[3].Value.([]string), " "); args != "debug SLEEP 0.03" {
+		t.Errorf("entry args = %q, want %q", args, "debug SLEEP 0.03")
+	}
+
+	// The log keeps only the newest entries past its maximum length
+	disp.SlowLog().SetSlowerThan(0)
+	disp.SlowLog().SetMaxLen(2)
+	disp.DispatchCommand(nil, conn, "ECHO", []string{"a"})
+	disp.DispatchCommand(nil, conn, "ECHO", []string{"b"})
+	logged := disp.SlowLog().Entries(-1)
+	if len(logged) != 2 || logged[0].Args[1] != "b" || logged[1].Args[1] != "a" {
+		t.Errorf("SLOWLOG after wrapping = %v, want the two ECHO calls, newest first", logged)
+	}
+
+	// A negative threshold disables the log
+	disp.SlowLog().SetSlowerThan(-1)
+	if reply, _ := disp.DispatchCommand(nil, conn, "SLOWLOG", []string{"RESET"}); reply.Value != "OK" {
+		t.Fatalf("SLOWLOG RESET = %v, want OK", reply.Value)
+	}
+	if n := disp.SlowLog().Len(); n != 0 {
+		t.Errorf("SLOWLOG LEN after RESET = %d, want 0", n)
+	}
+}
+
+func TestLatencyMonitor(t *testing.T) {
+	monitor := latency.Instance()
+	monitor.Reset()
+	t.Cleanup(func() {
+		monitor.SetThreshold(0)
+		monitor.Reset()
+	})
+
+	// Disabled by default
+	monitor.Add("synthetic", time.Second)
+	if n := len(monitor.Latest()); n != 0 {
+		t.Fatalf("disabled monitor recorded %d events", n)
+	}
+
+	cfg := config.Instance()
+	threshold := cfg.LatencyMonitorThreshold
+	t.Cleanup(func() { cfg.LatencyMonitorThreshold = threshold })
+	ctx := newTestContext(t, nil, "SET", "latency-monitor-threshold", "10")
+	if reply, _ := configCmd(ctx); reply.IsError() {
+		t.Fatalf("CONFIG SET latency-monitor-threshold failed: %v", reply.Value)
+	}
+
+	monitor.Add("synthetic", 5*time.Millisecond) // Under the threshold
+	monitor.Add("synthetic", 30*time.Millisecond)
+	monitor.Add("synthetic", 20*time.Millisecond) // Same second, lower
+
+	ctx.Args = []string{"LATEST"}
+	reply, _ := latencyCmd(ctx)
+	events := reply.Value.([]*command.Reply)
+	if len(events) != 1 {
+		t.Fatalf("LATENCY LATEST returned %d events, want 1", len(events))
+	}
+	event := events[0].Value.([]*command.Reply)
+	if event[0].Value != "synthetic" || event[2].Value != int64(30) || event[3].Value != int64(30) {
+		t.Errorf("LATENCY LATEST = %v %v %v, want synthetic 30 30", event[0].Value, event[2].Value, event[3].Value)
+	}
+	if ts := event[1].Value.(int64); time.Now().Unix()-ts > 1 {
+		t.Errorf("LATENCY LATEST time = %d, want about now", ts)
+	}
+
+	ctx.Args = []string{"HISTORY", "synthetic"}
+	reply, _ = latencyCmd(ctx)
+	if samples := reply.Value.([]*command.Reply); len(samples) < 1 || len(samples) > 2 {
+		t.Errorf("LATENCY HISTORY returned %d samples, want the 30ms one merged per second", len(samples))
+	}
+
+	ctx.Args = []string{"RESET", "synthetic", "missing"}
+	if reply, _ := latencyCmd(ctx); reply.Value != int64(1) {
+		t.Errorf("LATENCY RESET = %v, want 1", reply.Value)
+	}
+	ctx.Args = []string{"LATEST"}
+	if reply, _ := latencyCmd(ctx); len(reply.Value.([]*command.Reply)) != 0 {
+		t.Errorf("LATENCY LATEST after RESET = %v, want empty", reply.Value)
+	}
+}
+
+func TestDebugHTStats(t *testing.T) {
+	selector := setupPersistence(t)
+	db, _ := selector.GetDB(0)
+	for i := 0; i < 5; i++ {
+		db.Set("key:"+strconv.Itoa(i), database.NewStringObject("v"))
+	}
+	db.Expire("key:0", 100)
+
+	reply, _ := debugCmd(newTestContext(t, db, "HTSTATS", "0"))
+	if reply.IsError() {
+		t.Fatalf("DEBUG HTSTATS failed: %v", reply.Value)
+	}
+	s, _ := reply.Value.(string)
+	for _, want := range []string{"[Dictionary HT]\nRehashing: bucket", " number of elements: 1\n", "[Expires HT]\nHash table 0"} {
+		if !strings.Contains(s, want) {
+			t.Errorf("DEBUG HTSTATS expected %q in %q", want, s)
+		}
+	}
+
+	if reply, _ := debugCmd(newTestContext(t, db, "HTSTATS", "100")); !reply.IsError() {
+		t.Error("DEBUG HTSTATS of out of range DB expected error")
+	}
+}
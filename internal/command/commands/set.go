@@ -255,21 +255,27 @@ func spopCmd(ctx *command.Context) (*command.Reply, error) {
 
 	key := args[0]
 
+	// Without a count SPOP replies with a single member, with one it
+	// always replies with an array
+	hasCount := len(args) >= 2
 	count := 1
-	if len(args) >= 2 {
+	if hasCount {
 		c, err := strconv.Atoi(args[1])
 		if err != nil {
 			return nil, errors.New("value is not an integer or out of range")
 		}
 		if c < 0 {
-			return nil, errors.New("value is out of range")
+			return nil, errors.New("value is out of range, must be positive")
 		}
 		count = c
 	}
 
+	// Only the popped members are logged to the AOF, as an SREM
+	ctx.PropagateNothing()
+
 	obj, ok := ctx.DB.Get(key)
 	if !ok {
-		if count == 1 {
+		if !hasCount {
 			return command.NewNilReply(), nil
 		}
 		return command.NewStringArrayReply([]string{}), nil
@@ -284,7 +290,7 @@ func spopCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("internal error: not a set object")
 	}
 
-	if count == 1 {
+	if !hasCount {
 		member, exists := s.Pop()
 		if !exists {
 			return command.NewNilReply(), nil
@@ -293,19 +299,25 @@ func spopCmd(ctx *command.Context) (*command.Reply, error) {
 		if s.Len() == 0 {
 			ctx.DB.Delete(key)
 		}
+		ctx.Propagate("SREM", key, member)
 		return command.NewBulkStringReply(member), nil
 	}
 
+	// A zero count leaves the set untouched
+	if count == 0 {
+		return command.NewStringArrayReply([]string{}), nil
+	}
+
 	members := s.PopMultiple(count)
 
 	// Delete the key if set is empty
 	if s.Len() == 0 {
 		ctx.DB.Delete(key)
 	}
-
-	if len(members) == 0 {
-		return command.NewStringArrayReply([]string{}), nil
+	if len(members) > 0 {
+		ctx.Propagate("SREM", append([]string{key}, members...)...)
 	}
+
 	return command.NewStringArrayReply(members), nil
 }
 
@@ -318,8 +330,11 @@ func srandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 
 	key := args[0]
 
+	// Without a count SRANDMEMBER replies with a single member, with one it
+	// always replies with an array
+	hasCount := len(args) >= 2
 	count := 1
-	if len(args) >= 2 {
+	if hasCount {
 		c, err := strconv.Atoi(args[1])
 		if err != nil {
 			return nil, errors.New("value is not an integer or out of range")
@@ -329,7 +344,7 @@ func srandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 
 	obj, ok := ctx.DB.Get(key)
 	if !ok {
-		if count == 1 {
+		if !hasCount {
 			return command.NewNilReply(), nil
 		}
 		return command.NewStringArrayReply([]string{}), nil
@@ -344,7 +359,7 @@ func srandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("internal error: not a set object")
 	}
 
-	if count == 1 {
+	if !hasCount {
 		member, exists := s.RandomMember()
 		if !exists {
 			return command.NewNilReply(), nil
@@ -352,10 +367,13 @@ func srandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewBulkStringReply(member), nil
 	}
 
+	if count == 0 {
+		return command.NewStringArrayReply([]string{}), nil
+	}
+
 	if count < 0 {
-		// Return with duplicates
-		count = -count
-		members := s.RandomMembers(count)
+		// Return exactly -count members, possibly with duplicates
+		members := s.RandomMembers(-count)
 		if len(members) == 0 {
 			return command.NewStringArrayReply([]string{}), nil
 		}
@@ -0,0 +1,153 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/set"
+)
+
+// newSetDB returns a DB holding a set of five members under "myset"
+func newSetDB() *database.DB {
+	db := database.NewDB(0)
+	db.Set("myset", database.NewSetObjectFromSlice([]string{"a", "b", "c", "d", "e"}))
+	return db
+}
+
+// assertDistinctMembers checks the reply is an array of n distinct set members
+func assertDistinctMembers(t *testing.T, reply *command.Reply, n int) {
+	t.Helper()
+
+	members, ok := reply.Value.([]string)
+	if !ok {
+		t.Fatalf("expected array reply, got %T", reply.Value)
+	}
+	if len(members) != n {
+		t.Fatalf("expected %d members, got %d: %v", n, len(members), members)
+	}
+	seen := make(map[string]bool)
+	for _, m := range members {
+		if seen[m] {
+			t.Errorf("member %q returned twice", m)
+		}
+		seen[m] = true
+	}
+}
+
+func TestSpopCount(t *testing.T) {
+	tests := []struct {
+		count    string
+		returned int
+		left     int
+	}{
+		{"0", 0, 5},
+		{"3", 3, 2},
+		{"5", 5, 0},
+		{"10", 5, 0},
+	}
+
+	for _, tt := range tests {
+		db := newSetDB()
+		reply, err := spopCmd(newTestContext(t, db, "myset", tt.count))
+		if err != nil {
+			t.Fatalf("SPOP %s failed: %v", tt.count, err)
+		}
+		assertDistinctMembers(t, reply, tt.returned)
+
+		obj, ok := db.Get("myset")
+		if tt.left == 0 {
+			if ok {
+				t.Errorf("SPOP %s expected key to be deleted", tt.count)
+			}
+			continue
+		}
+		if !ok {
+			t.Fatalf("SPOP %s deleted the key", tt.count)
+		}
+		if left := obj.Ptr.(*set.Set).Len(); left != tt.left {
+			t.Errorf("SPOP %s expected %d members left, got %d", tt.count, tt.left, left)
+		}
+	}
+}
+
+func TestSpopWithoutCount(t *testing.T) {
+	db := newSetDB()
+
+	reply, _ := spopCmd(newTestContext(t, db, "myset"))
+	if reply.Type != command.ReplyTypeBulkString {
+		t.Errorf("SPOP without count expected bulk string, got %v", reply.Type)
+	}
+
+	reply, _ = spopCmd(newTestContext(t, db, "myset", "1"))
+	assertDistinctMembers(t, reply, 1)
+}
+
+func TestSrandmemberCount(t *testing.T) {
+	db := newSetDB()
+
+	reply, _ := srandmemberCmd(newTestContext(t, db, "myset", "10"))
+	assertDistinctMembers(t, reply, 5)
+
+	reply, _ = srandmemberCmd(newTestContext(t, db, "myset", "0"))
+	assertDistinctMembers(t, reply, 0)
+
+	// Negative counts return exactly that many members, duplicates allowed
+	reply, _ = srandmemberCmd(newTestContext(t, db, "myset", "-20"))
+	if members := reply.Value.([]string); len(members) != 20 {
+		t.Errorf("SRANDMEMBER -20 expected 20 members, got %d", len(members))
+	}
+
+	obj, _ := db.Get("myset")
+	if n := obj.Ptr.(*set.Set).Len(); n != 5 {
+		t.Errorf("SRANDMEMBER modified the set, %d members left", n)
+	}
+}
+
+// feedRecorder records the commands a dispatcher propagates
+type feedRecorder struct {
+	commands []string
+}
+
+func (r *feedRecorder) LogCommand(db int, cmdName string, args []string) error {
+	r.commands = append(r.commands, fmt.Sprintf("%d %s %s", db, cmdName, strings.Join(args, " ")))
+	return nil
+}
+
+func TestSpopPropagatesSrem(t *testing.T) {
+	selector := database.NewDBSelector(1)
+	disp := command.NewDispatcher(selector)
+	RegisterSetCommands(disp)
+	feed := &feedRecorder{}
+	disp.SetReplicationFeed(feed)
+
+	db, _ := selector.GetDB(0)
+	conn := newTestContext(t, db).Conn
+	dispatch(t, disp, conn, "SADD", "myset", "a", "b", "c")
+	feed.commands = nil
+
+	// Replicas must remove the member the master popped, not pick their own
+	reply := dispatch(t, disp, conn, "SPOP", "myset")
+	popped := strings.Split(reply, "\r\n")[1]
+	if want := []string{"0 SREM myset " + popped}; fmt.Sprint(feed.commands) != fmt.Sprint(want) {
+		t.Fatalf("SPOP propagated %q, want %q", feed.commands, want)
+	}
+
+	feed.commands = nil
+	dispatch(t, disp, conn, "SPOP", "myset", "5")
+	if len(feed.commands) != 1 || !strings.HasPrefix(feed.commands[0], "0 SREM myset ") {
+		t.Fatalf("SPOP with count propagated %q", feed.commands)
+	}
+	if members := strings.Fields(feed.commands[0])[3:]; len(members) != 2 {
+		t.Fatalf("SPOP with count propagated SREM of %v, want the 2 members left", members)
+	}
+
+	// Popping from a missing key changes nothing and propagates nothing
+	feed.commands = nil
+	dispatch(t, disp, conn, "SPOP", "myset")
+	if len(feed.commands) != 0 {
+		t.Fatalf("SPOP of a missing key propagated %q", feed.commands)
+	}
+}
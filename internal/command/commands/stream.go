@@ -7,8 +7,10 @@ package commands
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/command"
 	"github.com/zyhnesmr/godis/internal/database"
@@ -66,7 +68,7 @@ func RegisterStreamCommands(disp Dispatcher) {
 		Name:       "XDEL",
 		Handler:    xdelCmd,
 		Arity:      -3,
-		Flags:      []string{command.FlagFast},
+		Flags:      []string{command.FlagWrite, command.FlagFast},
 		FirstKey:   1,
 		LastKey:    1,
 		Categories: []string{command.CatStream},
@@ -80,6 +82,15 @@ func RegisterStreamCommands(disp Dispatcher) {
 		LastKey:    1,
 		Categories: []string{command.CatStream},
 	})
+	disp.Register(&command.Command{
+		Name:       "XSETID",
+		Handler:    xsetidCmd,
+		Arity:      -3,
+		Flags:      []string{command.FlagWrite, command.FlagDenyOOM, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatStream},
+	})
 	disp.Register(&command.Command{
 		Name:       "XGROUP",
 		Handler:    xgroupCmd,
@@ -102,7 +113,7 @@ func RegisterStreamCommands(disp Dispatcher) {
 		Name:       "XACK",
 		Handler:    xackCmd,
 		Arity:      -4,
-		Flags:      []string{command.FlagFast},
+		Flags:      []string{command.FlagWrite, command.FlagFast},
 		FirstKey:   1,
 		LastKey:    1,
 		Categories: []string{command.CatStream},
@@ -144,50 +155,163 @@ func xaddCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	key := args[0]
-	idStr := args[1]
+
+	// Parse options: [NOMKSTREAM] [MAXLEN|MINID [=|~] threshold [LIMIT count]]
+	noMkStream := false
+	var trim streamTrim
+	idx := 1
+OptionsLoop:
+	for idx < len(args) {
+		switch strings.ToUpper(args[idx]) {
+		case "NOMKSTREAM":
+			noMkStream = true
+			idx++
+		case "MAXLEN", "MINID":
+			var err error
+			trim, idx, err = parseStreamTrim(args, idx)
+			if err != nil {
+				return nil, err
+			}
+		default:
+			break OptionsLoop
+		}
+	}
+
+	// The ID must be followed by at least one field-value pair
+	if idx >= len(args) || (len(args)-idx-1) < 2 || (len(args)-idx-1)%2 != 0 {
+		return nil, errors.New("wrong number of arguments for XADD")
+	}
+	idStr := args[idx]
+
+	var id stream.StreamID
+	autoID := idStr == "*"
+	if !autoID {
+		var err error
+		id, err = stream.ParseStreamID(idStr)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid stream ID specified: %w", err)
+		}
+		if id.IsZero() {
+			return nil, errors.New("The ID specified in XADD must be greater than 0-0")
+		}
+	}
 
 	// Parse field-value pairs
 	fields := make(map[string]string)
-	for i := 2; i < len(args); i += 2 {
-		if i+1 >= len(args) {
-			return nil, errors.New("wrong number of arguments for XADD")
-		}
+	for i := idx + 1; i < len(args); i += 2 {
 		fields[args[i]] = args[i+1]
 	}
 
 	// Get or create stream
 	obj, exists := ctx.DB.Get(key)
-	var strm *stream.Stream
 	if !exists {
-		strm = stream.NewStream()
-		ctx.DB.Set(key, database.NewStreamObject())
-		// Re-get to have the proper object
-		obj, _ = ctx.DB.Get(key)
+		if noMkStream {
+			return command.NewNilReply(), nil
+		}
+		obj = database.NewStreamObject()
+		ctx.DB.Set(key, obj)
 	}
 
 	strmVal, ok := obj.GetStream()
 	if !ok {
 		return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
 	}
-	strm = strmVal.(*stream.Stream)
+	strm := strmVal.(*stream.Stream)
 
-	// Parse ID
-	var id stream.StreamID
-	var err error
-	if idStr == "*" || idStr == "0-0" {
-		// Auto-generate ID
+	if autoID {
 		id = strm.Add(fields)
+	} else if err := strm.AddWithID(id, fields); err != nil {
+		return nil, err
+	}
+
+	trim.apply(strm)
+	streamWaiters.signal(ctx.DB.GetID(), key)
+
+	// A generated ID and an approximate trim are logged as the ID and the
+	// length they resolved to, so that the log replays to the same stream
+	propagated := []string{key}
+	if trim.strategy != "" {
+		propagated = append(propagated, exactTrim(strm)...)
+	}
+	propagated = append(propagated, id.String())
+	ctx.Propagate("XADD", append(propagated, args[idx+1:]...)...)
+
+	return command.NewBulkStringReply(id.String()), nil
+}
+
+// streamTrim holds the MAXLEN|MINID [=|~] threshold [LIMIT count] options
+// shared by XADD and XTRIM
+type streamTrim struct {
+	strategy string // "MAXLEN", "MINID", or empty for no trimming
+	maxLen   int64
+	minID    stream.StreamID
+	limit    int64
+}
+
+// parseStreamTrim parses the trim options starting at args[idx], which holds
+// MAXLEN or MINID, and returns the index following them
+func parseStreamTrim(args []string, idx int) (streamTrim, int, error) {
+	trim := streamTrim{strategy: strings.ToUpper(args[idx])}
+	idx++
+
+	approx := false
+	if idx < len(args) && (args[idx] == "~" || args[idx] == "=") {
+		approx = args[idx] == "~"
+		idx++
+	}
+	if idx >= len(args) {
+		return trim, idx, errors.New("syntax error")
+	}
+
+	if trim.strategy == "MAXLEN" {
+		maxLen, err := strconv.ParseInt(args[idx], 10, 64)
+		if err != nil {
+			return trim, idx, errors.New("value is not an integer or out of range")
+		}
+		if maxLen < 0 {
+			return trim, idx, errors.New("The MAXLEN argument must be >= 0.")
+		}
+		trim.maxLen = maxLen
 	} else {
-		id, err = stream.ParseStreamID(idStr)
+		minID, err := stream.ParseStreamID(args[idx])
 		if err != nil {
-			return nil, fmt.Errorf("Invalid stream ID specified: %w", err)
+			return trim, idx, errors.New("Invalid stream ID specified as stream command argument")
 		}
-		if err := strm.AddWithID(id, fields); err != nil {
-			return nil, err
+		trim.minID = minID
+	}
+	idx++
+
+	if idx+1 < len(args) && strings.ToUpper(args[idx]) == "LIMIT" {
+		if !approx {
+			return trim, idx, errors.New("syntax error, LIMIT cannot be used without the special ~ option")
+		}
+		limit, err := strconv.ParseInt(args[idx+1], 10, 64)
+		if err != nil || limit < 0 {
+			return trim, idx, errors.New("The LIMIT argument must be >= 0.")
 		}
+		trim.limit = limit
+		idx += 2
 	}
 
-	return command.NewBulkStringReply(id.String()), nil
+	return trim, idx, nil
+}
+
+// exactTrim returns the trim options that trim a stream to the length strm
+// was trimmed to, whatever the strategy and approximation used
+func exactTrim(strm *stream.Stream) []string {
+	return []string{"MAXLEN", "=", strconv.FormatInt(strm.Length(), 10)}
+}
+
+// apply trims the stream and returns the number of entries removed
+func (t streamTrim) apply(strm *stream.Stream) int64 {
+	switch t.strategy {
+	case "MAXLEN":
+		return strm.TrimByMaxLen(t.maxLen, t.limit)
+	case "MINID":
+		return strm.TrimByMinID(t.minID, t.limit)
+	default:
+		return 0
+	}
 }
 
 // XLEN returns the number of entries in a stream
@@ -286,6 +410,8 @@ func xreadCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	count := int64(0)
+	var block time.Duration
+	blocking := false
 
 	// Parse options
 	idx := 0
@@ -302,10 +428,14 @@ func xreadCmd(ctx *command.Context) (*command.Reply, error) {
 			count = c
 			idx += 2
 		} else if arg == "BLOCK" {
-			// Blocking not implemented yet, just skip
 			if idx+1 >= len(args) {
 				return nil, errors.New("syntax error")
 			}
+			timeout, err := parseBlockTimeout(args[idx+1])
+			if err != nil {
+				return nil, err
+			}
+			block, blocking = timeout, true
 			idx += 2
 		} else if arg == "STREAMS" {
 			idx++
@@ -332,51 +462,74 @@ func xreadCmd(ctx *command.Context) (*command.Reply, error) {
 
 	// Parse streams and IDs
 	streamCount := (len(args) - streamsIdx) / 2
-	if streamCount == 0 {
+	if streamCount == 0 || (len(args)-streamsIdx)%2 != 0 {
 		return nil, errors.New("syntax error")
 	}
 
-	results := make([]*command.Reply, 0)
-
-	for i := 0; i < streamCount; i++ {
-		keyIdx := streamsIdx + i
-		idIdx := streamsIdx + streamCount + i
+	keys := args[streamsIdx : streamsIdx+streamCount]
+	starts := make([]string, streamCount)
 
-		if idIdx >= len(args) {
-			return nil, errors.New("syntax error")
-		}
-
-		key := args[keyIdx]
-		idStr := args[idIdx]
-
-		obj, exists := ctx.DB.Get(key)
-		if !exists {
+	// Resolve $ once, so a blocked read returns only entries added later
+	for i, key := range keys {
+		idStr := args[streamsIdx+streamCount+i]
+		if idStr != "$" {
+			starts[i] = idStr
 			continue
 		}
 
-		strmVal, ok := obj.GetStream()
-		if !ok {
-			return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+		starts[i] = "0-0"
+		if obj, exists := ctx.DB.Get(key); exists {
+			if strmVal, ok := obj.GetStream(); ok {
+				starts[i] = strmVal.(*stream.Stream).GetLastID().String()
+			}
 		}
-		strm := strmVal.(*stream.Stream)
+	}
 
-		var start string
-		if idStr == "$" {
-			lastID := strm.GetLastID()
-			start = lastID.String()
-		} else {
-			start = idStr
-		}
+	read := func() ([]*command.Reply, error) {
+		results := make([]*command.Reply, 0)
+		for i, key := range keys {
+			obj, exists := ctx.DB.Get(key)
+			if !exists {
+				continue
+			}
 
-		entries := readEntriesAfter(strm, start, count)
-		if len(entries) > 0 {
-			results = append(results, formatStreamResult(key, entries))
+			strmVal, ok := obj.GetStream()
+			if !ok {
+				return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+			}
+			strm := strmVal.(*stream.Stream)
+
+			entries := readEntriesAfter(strm, starts[i], count)
+			if len(entries) > 0 {
+				results = append(results, formatStreamResult(key, entries))
+			}
 		}
+		return results, nil
 	}
 
+	if blocking {
+		return blockOnKeys(ctx, streamWaiters, keys, block, read)
+	}
+
+	results, err := read()
+	if err != nil {
+		return nil, err
+	}
 	return command.NewArrayReply(results), nil
 }
 
+// parseBlockTimeout parses the BLOCK milliseconds argument
+func parseBlockTimeout(arg string) (time.Duration, error) {
+	ms, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil {
+		return 0, errors.New("timeout is not an integer or out of range")
+	}
+	if ms < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
 // XDEL deletes entries from a stream
 func xdelCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
@@ -413,29 +566,23 @@ func xdelCmd(ctx *command.Context) (*command.Reply, error) {
 // XTRIM trims a stream to a given size
 func xtrimCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
-	if len(args) < 4 {
+	if len(args) < 3 {
 		return nil, errors.New("wrong number of arguments")
 	}
 
 	key := args[0]
 	strategy := strings.ToUpper(args[1])
 
-	if strategy != "MAXLEN" {
-		return nil, errors.New("unknown XTRIM option")
-	}
-
-	argIdx := 2
-	if args[argIdx] == "~" {
-		argIdx++
-	}
-
-	if argIdx >= len(args) {
+	if strategy != "MAXLEN" && strategy != "MINID" {
 		return nil, errors.New("syntax error")
 	}
 
-	maxLen, err := strconv.ParseInt(args[argIdx], 10, 64)
+	trim, idx, err := parseStreamTrim(args, 1)
 	if err != nil {
-		return nil, errors.New("value is not an integer or out of range")
+		return nil, err
+	}
+	if idx != len(args) {
+		return nil, errors.New("syntax error")
 	}
 
 	obj, exists := ctx.DB.Get(key)
@@ -449,22 +596,77 @@ func xtrimCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 	strm := strmVal.(*stream.Stream)
 
-	currentLen := strm.Length()
-	if currentLen <= maxLen {
-		return command.NewIntegerReply(0), nil
+	removed := trim.apply(strm)
+	if removed == 0 {
+		ctx.PropagateNothing()
+	} else {
+		ctx.Propagate("XTRIM", append([]string{key}, exactTrim(strm)...)...)
 	}
+	return command.NewIntegerReply(removed), nil
+}
 
-	entries := strm.GetEntries()
-	if len(entries) == 0 {
-		return command.NewIntegerReply(0), nil
+// XSETID key last-id [ENTRIESADDED entries-added] [MAXDELETEDID max-deleted-id]
+func xsetidCmd(ctx *command.Context) (*command.Reply, error) {
+	args := ctx.Args
+	key := args[0]
+
+	lastID, err := stream.ParseStreamID(args[1])
+	if err != nil {
+		return nil, errors.New("Invalid stream ID specified as stream command argument")
+	}
+
+	entriesAdded := int64(-1)
+	var maxDeletedID stream.StreamID
+	hasMaxDeletedID := false
+	for i := 2; i < len(args); i += 2 {
+		if i+1 >= len(args) {
+			return nil, errors.New("syntax error")
+		}
+		switch strings.ToUpper(args[i]) {
+		case "ENTRIESADDED":
+			entriesAdded, err = strconv.ParseInt(args[i+1], 10, 64)
+			if err != nil || entriesAdded < 0 {
+				return nil, errors.New("entries_added must be positive")
+			}
+		case "MAXDELETEDID":
+			maxDeletedID, err = stream.ParseStreamID(args[i+1])
+			if err != nil {
+				return nil, errors.New("Invalid stream ID specified as stream command argument")
+			}
+			if lastID.Compare(maxDeletedID) < 0 {
+				return nil, errors.New("The ID specified in XSETID is smaller than the provided max_deleted_entry_id")
+			}
+			hasMaxDeletedID = true
+		default:
+			return nil, errors.New("syntax error")
+		}
 	}
 
-	cutoffIdx := int(currentLen - maxLen)
-	minID := entries[cutoffIdx].ID
-	maxID := entries[len(entries)-1].ID
+	obj, exists := ctx.DB.Get(key)
+	if !exists {
+		return nil, errors.New("no such key")
+	}
+	strmVal, ok := obj.GetStream()
+	if !ok {
+		return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	strm := strmVal.(*stream.Stream)
 
-	removed := strm.XTrim(minID, maxID)
-	return command.NewIntegerReply(removed), nil
+	if entriesAdded >= 0 && entriesAdded < strm.Length() {
+		return nil, errors.New("The entries_added specified in XSETID is smaller than the target stream length")
+	}
+	if last := strm.LastEntry(); last != nil && lastID.Compare(last.ID) < 0 {
+		return nil, errors.New("The ID specified in XSETID is smaller than the target stream top item")
+	}
+
+	strm.SetLastID(lastID)
+	if entriesAdded >= 0 {
+		strm.SetEntriesAdded(entriesAdded)
+	}
+	if hasMaxDeletedID {
+		strm.SetMaxDeletedID(maxDeletedID)
+	}
+	return command.NewStatusReply("OK"), nil
 }
 
 // XGROUP manages consumer groups
@@ -481,6 +683,7 @@ func xgroupCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	// For CREATE: XGROUP CREATE key group id [MKSTREAM]
+	// For SETID: XGROUP SETID key group id [ENTRIESREAD entries-read]
 	// For DESTROY: XGROUP DESTROY key group
 	// For CREATECONSUMER: XGROUP CREATECONSUMER key group consumer
 	// For DELCONSUMER: XGROUP DELCONSUMER key group consumer
@@ -518,26 +721,60 @@ func xgroupCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("syntax error")
 		}
 		groupName := args[2]
-		idStr := args[3]
-
-		var initialID stream.StreamID
-		if idStr == "$" {
-			initialID = strm.GetLastID()
-		} else if idStr == "0" {
-			// "0" means "0-0"
-			initialID = stream.StreamID{Timestamp: 0, Sequence: 0}
-		} else {
-			var err error
-			initialID, err = stream.ParseStreamID(idStr)
-			if err != nil {
-				return nil, fmt.Errorf("Invalid stream ID: %w", err)
-			}
+
+		initialID, err := parseGroupID(strm, args[3])
+		if err != nil {
+			return nil, err
 		}
 
 		cgroups := strm.GetConsumerGroupManager()
+		if _, exists := cgroups.GetGroup(groupName); exists {
+			return command.NewStatusReply("OK"), nil
+		}
 		if err := cgroups.CreateGroup(groupName, initialID); err != nil {
 			return nil, err
 		}
+
+		// The entries read so far are only known when starting from either end
+		group, _ := cgroups.GetGroup(groupName)
+		switch args[3] {
+		case "$":
+			group.SetEntriesRead(strm.EntriesAdded())
+		case "0", "0-0":
+			group.SetEntriesRead(0)
+		}
+		return command.NewStatusReply("OK"), nil
+
+	case "SETID":
+		if len(args) != 4 && len(args) != 6 {
+			return nil, errors.New("syntax error")
+		}
+		groupName := args[2]
+
+		lastID, err := parseGroupID(strm, args[3])
+		if err != nil {
+			return nil, err
+		}
+
+		entriesRead := int64(-1)
+		if len(args) == 6 {
+			if strings.ToUpper(args[4]) != "ENTRIESREAD" {
+				return nil, errors.New("syntax error")
+			}
+			entriesRead, err = strconv.ParseInt(args[5], 10, 64)
+			if err != nil || entriesRead < -1 {
+				return nil, errors.New("value for ENTRIESREAD must be positive or -1")
+			}
+		}
+
+		cgroups := strm.GetConsumerGroupManager()
+		group, ok := cgroups.GetGroup(groupName)
+		if !ok {
+			return nil, errors.New("No such group")
+		}
+
+		group.SetLastID(lastID)
+		group.SetEntriesRead(entriesRead)
 		return command.NewStatusReply("OK"), nil
 
 	case "DESTROY":
@@ -564,8 +801,7 @@ func xgroupCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("No such group")
 		}
 
-		consumer := group.GetOrCreateConsumer(consumerName)
-		if consumer != nil {
+		if group.CreateConsumer(consumerName) {
 			return command.NewIntegerReply(1), nil
 		}
 		return command.NewIntegerReply(0), nil
@@ -583,8 +819,7 @@ func xgroupCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("No such group")
 		}
 
-		group.RemoveConsumer(consumerName)
-		return command.NewIntegerReply(1), nil
+		return command.NewIntegerReply(int64(group.RemoveConsumer(consumerName))), nil
 
 	default:
 		return nil, fmt.Errorf("unknown subcommand '%s'", subcommand)
@@ -605,7 +840,9 @@ func xreadgroupCmd(ctx *command.Context) (*command.Reply, error) {
 	groupName := args[1]
 	consumerName := args[2]
 
-	count := int64(1)
+	count := int64(0)
+	var block time.Duration
+	blocking := false
 
 	idx := 3
 	for idx < len(args) {
@@ -621,13 +858,16 @@ func xreadgroupCmd(ctx *command.Context) (*command.Reply, error) {
 			count = c
 			idx += 2
 		} else if arg == "BLOCK" {
-			// Blocking not implemented yet
 			if idx+1 >= len(args) {
 				return nil, errors.New("syntax error")
 			}
+			timeout, err := parseBlockTimeout(args[idx+1])
+			if err != nil {
+				return nil, err
+			}
+			block, blocking = timeout, true
 			idx += 2
 		} else if arg == "STREAMS" {
-			idx++
 			break
 		} else {
 			idx++
@@ -647,22 +887,41 @@ func xreadgroupCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	streamCount := (len(args) - streamsIdx) / 2
-	if streamCount == 0 {
+	if streamCount == 0 || (len(args)-streamsIdx)%2 != 0 {
 		return nil, errors.New("syntax error")
 	}
 
-	results := make([]*command.Reply, 0)
+	keys := args[streamsIdx : streamsIdx+streamCount]
+	ids := args[streamsIdx+streamCount:]
 
-	for i := 0; i < streamCount; i++ {
-		keyIdx := streamsIdx + i
-		idIdx := streamsIdx + streamCount + i
+	read := func() ([]*command.Reply, error) {
+		return readGroupStreams(ctx, groupName, consumerName, keys, ids, count)
+	}
 
-		if idIdx >= len(args) {
-			return nil, errors.New("syntax error")
+	// Only reads of new messages (>) wait; history reads return at once
+	for _, idStr := range ids {
+		if blocking && idStr == ">" {
+			return blockOnKeys(ctx, streamWaiters, keys, block, read)
 		}
+	}
+
+	results, err := read()
+	if err != nil {
+		return nil, err
+	}
+	return command.NewArrayReply(results), nil
+}
 
-		key := args[keyIdx]
-		idStr := args[idIdx]
+// readGroupStreams delivers the entries after each ID to the consumer and
+// returns one result per stream that had any. Each delivery is logged as the
+// XCLAIM and XGROUP SETID recreating it, since replaying the read itself
+// could deliver other entries.
+func readGroupStreams(ctx *command.Context, groupName, consumerName string, keys, ids []string, count int64) ([]*command.Reply, error) {
+	ctx.PropagateNothing()
+	results := make([]*command.Reply, 0)
+
+	for i, key := range keys {
+		idStr := ids[i]
 
 		obj, exists := ctx.DB.Get(key)
 		if !exists {
@@ -683,34 +942,72 @@ func xreadgroupCmd(ctx *command.Context) (*command.Reply, error) {
 
 		_ = group.GetOrCreateConsumer(consumerName)
 
-		var startID stream.StreamID
-		var err error
-		if idStr == ">" {
-			startID = group.GetLastID()
-		} else if idStr == "0" {
-			startID = stream.StreamID{Timestamp: 0, Sequence: 0}
-		} else {
-			startID, err = stream.ParseStreamID(idStr)
-			if err != nil {
-				return nil, fmt.Errorf("Invalid stream ID: %w", err)
+		// Any ID other than > replays the consumer's own pending entries
+		if idStr != ">" {
+			startID := stream.StreamID{}
+			if idStr != "0" {
+				var err error
+				startID, err = stream.ParseStreamID(idStr)
+				if err != nil {
+					return nil, fmt.Errorf("Invalid stream ID: %w", err)
+				}
 			}
+			results = append(results, command.NewArrayReply([]*command.Reply{
+				command.NewBulkStringReply(key),
+				readPendingAfter(strm, group, consumerName, startID, count),
+			}))
+			continue
 		}
 
-		entries := readEntriesAfter(strm, startID.String(), count)
+		entries := readEntriesAfter(strm, group.GetLastID().String(), count)
 
 		if len(entries) > 0 {
 			newLastID := entries[len(entries)-1].ID
 			group.SetLastID(newLastID)
+			group.AddEntriesRead(int64(len(entries)))
 
+			now := time.Now().UnixMilli()
 			for _, entry := range entries {
-				group.AddPendingID(consumerName, entry.ID, 0)
+				group.AddPendingID(consumerName, entry.ID, now)
+				pe, _ := group.GetPendingEntry(entry.ID)
+				ctx.Propagate("XCLAIM", key, groupName, consumerName, "0", entry.ID.String(),
+					"TIME", strconv.FormatInt(pe.DeliveryTime, 10),
+					"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+					"FORCE", "JUSTID", "LASTID", newLastID.String())
 			}
+			ctx.Propagate("XGROUP", "SETID", key, groupName, newLastID.String(),
+				"ENTRIESREAD", strconv.FormatInt(group.GetEntriesRead(), 10))
 
 			results = append(results, formatStreamResult(key, entries))
 		}
 	}
 
-	return command.NewArrayReply(results), nil
+	return results, nil
+}
+
+// readPendingAfter returns the entries pending for a consumer with an ID
+// greater than start, at most count if positive. Entries deleted from the
+// stream since delivery are returned with nil fields.
+func readPendingAfter(strm *stream.Stream, group *stream.ConsumerGroup, consumerName string, start stream.StreamID, count int64) *command.Reply {
+	result := make([]*command.Reply, 0)
+	for _, pe := range group.GetPending() {
+		if pe.Consumer != consumerName || pe.ID.Compare(start) <= 0 {
+			continue
+		}
+		if count > 0 && int64(len(result)) >= count {
+			break
+		}
+
+		if entry := strm.FindByID(pe.ID); entry != nil {
+			result = append(result, formatStreamEntry(entry))
+		} else {
+			result = append(result, command.NewArrayReply([]*command.Reply{
+				command.NewBulkStringReply(pe.ID.String()),
+				command.NewNilReply(),
+			}))
+		}
+	}
+	return command.NewArrayReply(result)
 }
 
 // XACK acknowledges a message as processed
@@ -740,6 +1037,7 @@ func xackCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewIntegerReply(0), nil
 	}
 
+	// Only IDs removed from the group's pending entries list are counted
 	acknowledged := 0
 	for i := 2; i < len(args); i++ {
 		id, err := stream.ParseStreamID(args[i])
@@ -747,16 +1045,17 @@ func xackCmd(ctx *command.Context) (*command.Reply, error) {
 			continue
 		}
 
-		for _, consumer := range group.GetConsumers() {
-			consumer.RemovePendingID(id)
+		if group.Ack(id) {
+			acknowledged++
 		}
-		acknowledged++
 	}
 
 	return command.NewIntegerReply(int64(acknowledged)), nil
 }
 
-// XCLAIM claims pending messages
+// XCLAIM key group consumer min-idle-time id [id ...] [IDLE ms]
+// [TIME unix-time-milliseconds] [RETRYCOUNT count] [FORCE] [JUSTID] [LASTID id]
+// changes the ownership of pending messages
 func xclaimCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
 	if len(args) < 5 {
@@ -766,16 +1065,75 @@ func xclaimCmd(ctx *command.Context) (*command.Reply, error) {
 	key := args[0]
 	groupName := args[1]
 	consumerName := args[2]
-	minIdleTime := args[3]
 
-	_, err := strconv.ParseInt(minIdleTime, 10, 64)
-	if err != nil {
-		return nil, errors.New("value is not an integer or out of range")
+	minIdle, err := strconv.ParseInt(args[3], 10, 64)
+	if err != nil || minIdle < 0 {
+		return nil, errors.New("Invalid min-idle-time argument for XCLAIM")
+	}
+
+	// IDs run up to the first option
+	ids := make([]stream.StreamID, 0)
+	idx := 4
+	for ; idx < len(args); idx++ {
+		id, err := stream.ParseStreamID(args[idx])
+		if err != nil {
+			break
+		}
+		ids = append(ids, id)
+	}
+	if len(ids) == 0 {
+		return nil, errors.New("Invalid stream ID specified as stream command argument")
+	}
+
+	now := time.Now().UnixMilli()
+	deliveryTime := now
+	retryCount := int64(-1)
+	var force, justID bool
+	var lastID stream.StreamID
+	for ; idx < len(args); idx++ {
+		opt := strings.ToUpper(args[idx])
+		switch opt {
+		case "FORCE":
+			force = true
+			continue
+		case "JUSTID":
+			justID = true
+			continue
+		}
+		if idx+1 >= len(args) {
+			return nil, errors.New("syntax error")
+		}
+		idx++
+		switch opt {
+		case "IDLE":
+			idle, err := strconv.ParseInt(args[idx], 10, 64)
+			if err != nil || idle < 0 {
+				return nil, errors.New("Invalid IDLE option argument for XCLAIM")
+			}
+			deliveryTime = now - idle
+		case "TIME":
+			deliveryTime, err = strconv.ParseInt(args[idx], 10, 64)
+			if err != nil || deliveryTime < 0 {
+				return nil, errors.New("Invalid TIME option argument for XCLAIM")
+			}
+		case "RETRYCOUNT":
+			retryCount, err = strconv.ParseInt(args[idx], 10, 64)
+			if err != nil || retryCount < 0 {
+				return nil, errors.New("Invalid RETRYCOUNT option argument for XCLAIM")
+			}
+		case "LASTID":
+			lastID, err = stream.ParseStreamID(args[idx])
+			if err != nil {
+				return nil, errors.New("Invalid stream ID specified as stream command argument")
+			}
+		default:
+			return nil, fmt.Errorf("Unrecognized XCLAIM option '%s'", args[idx-1])
+		}
 	}
 
 	obj, exists := ctx.DB.Get(key)
 	if !exists {
-		return command.NewArrayReply(nil), nil
+		return nil, errors.New("NOGROUP No such key or consumer group")
 	}
 
 	strmVal, ok := obj.GetStream()
@@ -787,25 +1145,53 @@ func xclaimCmd(ctx *command.Context) (*command.Reply, error) {
 	cgroups := strm.GetConsumerGroupManager()
 	group, ok := cgroups.GetGroup(groupName)
 	if !ok {
-		return nil, errors.New("No such group")
+		return nil, errors.New("NOGROUP No such key or consumer group")
 	}
 
-	ids := make([]stream.StreamID, 0)
-	for i := 4; i < len(args); i++ {
-		id, err := stream.ParseStreamID(args[i])
-		if err != nil {
-			continue
-		}
-		ids = append(ids, id)
+	if lastID.Compare(group.GetLastID()) > 0 {
+		group.SetLastID(lastID)
 	}
 
+	// Log each claim with the values it resolved to, so that replaying it
+	// doesn't depend on the clock or on the entries pending at the time
+	ctx.PropagateNothing()
 	results := make([]*command.Reply, 0)
 	_ = group.GetOrCreateConsumer(consumerName)
 
 	for _, id := range ids {
 		entry := strm.FindByID(id)
-		if entry != nil {
-			group.AddPendingID(consumerName, id, 0)
+		pe, pending := group.GetPendingEntry(id)
+		switch {
+		case !pending:
+			// FORCE creates the pending entry of a message nobody owns
+			if !force || entry == nil {
+				continue
+			}
+			pe = stream.PendingEntry{ID: id, DeliveryCount: 1}
+		case entry == nil:
+			// The message was deleted, so there is nothing left to claim
+			group.Ack(id)
+			continue
+		case minIdle > 0 && now-pe.DeliveryTime < minIdle:
+			continue
+		}
+
+		pe.Consumer = consumerName
+		pe.DeliveryTime = deliveryTime
+		if retryCount >= 0 {
+			pe.DeliveryCount = retryCount
+		} else if !justID {
+			pe.DeliveryCount++
+		}
+		group.RestorePending(pe)
+		ctx.Propagate("XCLAIM", key, groupName, consumerName, "0", id.String(),
+			"TIME", strconv.FormatInt(pe.DeliveryTime, 10),
+			"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+			"FORCE", "JUSTID", "LASTID", group.GetLastID().String())
+
+		if justID {
+			results = append(results, command.NewBulkStringReply(id.String()))
+		} else {
 			results = append(results, formatStreamEntry(entry))
 		}
 	}
@@ -816,7 +1202,7 @@ func xclaimCmd(ctx *command.Context) (*command.Reply, error) {
 // XPENDING shows pending messages
 func xpendingCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
-	if len(args) < 3 {
+	if len(args) < 2 {
 		return nil, errors.New("wrong number of arguments")
 	}
 
@@ -840,22 +1226,22 @@ func xpendingCmd(ctx *command.Context) (*command.Reply, error) {
 		return nil, errors.New("No such group")
 	}
 
-	if len(args) == 3 {
-		totalPending := 0
+	if len(args) == 2 {
+		pending := group.GetPending()
+		totalPending := len(pending)
 		smallestID := stream.StreamID{}
 		largestID := stream.StreamID{}
+		if totalPending > 0 {
+			smallestID = pending[0].ID
+			largestID = pending[totalPending-1].ID
+		}
 
 		consumers := group.GetConsumers()
 		consumerCount := len(consumers)
 
-		for _, consumer := range consumers {
-			pending := consumer.GetPendingIDs()
-			totalPending += len(pending)
-		}
-
 		consumerInfo := make([]*command.Reply, 0, consumerCount)
-		for name, consumer := range consumers {
-			pendingCount := len(consumer.GetPendingIDs())
+		for name := range consumers {
+			pendingCount := len(group.GetPendingIDs(name))
 			consumerInfo = append(consumerInfo, command.NewArrayReply([]*command.Reply{
 				command.NewBulkStringReply(name),
 				command.NewIntegerReply(int64(pendingCount)),
@@ -889,33 +1275,7 @@ func xinfoCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewArrayReply(nil), nil
 
 	case "STREAM":
-		if len(args) < 2 {
-			return nil, errors.New("syntax error")
-		}
-		key := args[1]
-
-		obj, exists := ctx.DB.Get(key)
-		if !exists {
-			return nil, errors.New("No such key")
-		}
-
-		strmVal, ok := obj.GetStream()
-		if !ok {
-			return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
-		}
-		strm := strmVal.(*stream.Stream)
-
-		cgroups := strm.GetConsumerGroupManager()
-		groups := cgroups.GetGroups()
-
-		return command.NewArrayReply([]*command.Reply{
-			command.NewBulkStringReply("length"),
-			command.NewIntegerReply(strm.Length()),
-			command.NewBulkStringReply("groups"),
-			command.NewIntegerReply(int64(len(groups))),
-			command.NewBulkStringReply("last-generated-id"),
-			command.NewBulkStringReply(strm.GetLastID().String()),
-		}), nil
+		return xinfoStream(ctx)
 
 	case "GROUPS":
 		if len(args) < 2 {
@@ -942,10 +1302,14 @@ func xinfoCmd(ctx *command.Context) (*command.Reply, error) {
 			groupInfo := []*command.Reply{
 				command.NewBulkStringReply("name"),
 				command.NewBulkStringReply(group.GetName()),
-				command.NewBulkStringReply("last-delivered-id"),
-				command.NewBulkStringReply(group.GetLastID().String()),
 				command.NewBulkStringReply("consumers"),
 				command.NewIntegerReply(int64(len(group.GetConsumers()))),
+				command.NewBulkStringReply("pending"),
+				command.NewIntegerReply(int64(group.PendingCount())),
+				command.NewBulkStringReply("last-delivered-id"),
+				command.NewBulkStringReply(group.GetLastID().String()),
+				command.NewBulkStringReply("entries-read"),
+				entriesReadReply(group),
 			}
 			result = append(result, command.NewArrayReply(groupInfo))
 		}
@@ -980,7 +1344,7 @@ func xinfoCmd(ctx *command.Context) (*command.Reply, error) {
 		result := make([]*command.Reply, 0, len(consumers))
 
 		for _, consumer := range consumers {
-			pendingCount := len(consumer.GetPendingIDs())
+			pendingCount := len(group.GetPendingIDs(consumer.GetName()))
 			consumerInfo := []*command.Reply{
 				command.NewBulkStringReply("name"),
 				command.NewBulkStringReply(consumer.GetName()),
@@ -997,8 +1361,191 @@ func xinfoCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 }
 
+// parseGroupID parses the last delivered ID given to XGROUP CREATE and SETID,
+// where $ is the last ID of the stream
+func parseGroupID(strm *stream.Stream, idStr string) (stream.StreamID, error) {
+	switch idStr {
+	case "$":
+		return strm.GetLastID(), nil
+	case "0":
+		// "0" means "0-0"
+		return stream.StreamID{Timestamp: 0, Sequence: 0}, nil
+	}
+
+	id, err := stream.ParseStreamID(idStr)
+	if err != nil {
+		return stream.StreamID{}, fmt.Errorf("Invalid stream ID: %w", err)
+	}
+	return id, nil
+}
+
+// XINFO STREAM key [FULL [COUNT count]]
+func xinfoStream(ctx *command.Context) (*command.Reply, error) {
+	args := ctx.Args
+	key := args[1]
+
+	full := false
+	count := int64(10)
+	if len(args) > 2 {
+		if strings.ToUpper(args[2]) != "FULL" {
+			return nil, errors.New("syntax error")
+		}
+		full = true
+
+		switch len(args) {
+		case 3:
+		case 5:
+			if strings.ToUpper(args[3]) != "COUNT" {
+				return nil, errors.New("syntax error")
+			}
+			c, err := strconv.ParseInt(args[4], 10, 64)
+			if err != nil || c < 0 {
+				return nil, errors.New("value is not an integer or out of range")
+			}
+			count = c
+		default:
+			return nil, errors.New("syntax error")
+		}
+	}
+
+	obj, exists := ctx.DB.Get(key)
+	if !exists {
+		return nil, errors.New("No such key")
+	}
+
+	strmVal, ok := obj.GetStream()
+	if !ok {
+		return nil, errors.New("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+	strm := strmVal.(*stream.Stream)
+
+	recordedFirstID := stream.StreamID{}
+	first := strm.FirstEntry()
+	if first != nil {
+		recordedFirstID = first.ID
+	}
+
+	result := []*command.Reply{
+		command.NewBulkStringReply("length"),
+		command.NewIntegerReply(strm.Length()),
+		command.NewBulkStringReply("last-generated-id"),
+		command.NewBulkStringReply(strm.GetLastID().String()),
+		command.NewBulkStringReply("max-deleted-entry-id"),
+		command.NewBulkStringReply(strm.MaxDeletedID().String()),
+		command.NewBulkStringReply("entries-added"),
+		command.NewIntegerReply(strm.EntriesAdded()),
+		command.NewBulkStringReply("recorded-first-entry-id"),
+		command.NewBulkStringReply(recordedFirstID.String()),
+	}
+
+	groups := strm.GetConsumerGroupManager().GetGroups()
+	if !full {
+		firstEntry, lastEntry := command.NewNilReply(), command.NewNilReply()
+		if first != nil {
+			firstEntry = formatStreamEntry(first)
+			lastEntry = formatStreamEntry(strm.LastEntry())
+		}
+		result = append(result,
+			command.NewBulkStringReply("groups"),
+			command.NewIntegerReply(int64(len(groups))),
+			command.NewBulkStringReply("first-entry"),
+			firstEntry,
+			command.NewBulkStringReply("last-entry"),
+			lastEntry,
+		)
+		return command.NewArrayReply(result), nil
+	}
+
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	groupInfo := make([]*command.Reply, 0, len(groups))
+	for _, name := range groupNames {
+		groupInfo = append(groupInfo, xinfoGroupFull(groups[name], count))
+	}
+
+	result = append(result,
+		command.NewBulkStringReply("entries"),
+		formatStreamEntries(strm.Range("-", "+", count)),
+		command.NewBulkStringReply("groups"),
+		command.NewArrayReply(groupInfo),
+	)
+	return command.NewArrayReply(result), nil
+}
+
+// xinfoGroupFull describes a consumer group and its PEL for XINFO STREAM
+// FULL, listing at most count pending entries (all if count is 0)
+func xinfoGroupFull(group *stream.ConsumerGroup, count int64) *command.Reply {
+	pending := group.GetPending()
+
+	groupPEL := make([]*command.Reply, 0, len(pending))
+	consumerPEL := make(map[string][]*command.Reply)
+	for i, pe := range pending {
+		if count == 0 || int64(i) < count {
+			groupPEL = append(groupPEL, command.NewArrayReply([]*command.Reply{
+				command.NewBulkStringReply(pe.ID.String()),
+				command.NewBulkStringReply(pe.Consumer),
+				command.NewIntegerReply(pe.DeliveryTime),
+				command.NewIntegerReply(pe.DeliveryCount),
+			}))
+		}
+		if count == 0 || int64(len(consumerPEL[pe.Consumer])) < count {
+			consumerPEL[pe.Consumer] = append(consumerPEL[pe.Consumer], command.NewArrayReply([]*command.Reply{
+				command.NewBulkStringReply(pe.ID.String()),
+				command.NewIntegerReply(pe.DeliveryTime),
+				command.NewIntegerReply(pe.DeliveryCount),
+			}))
+		}
+	}
+
+	consumers := group.GetConsumers()
+	names := make([]string, 0, len(consumers))
+	for name := range consumers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	consumerInfo := make([]*command.Reply, 0, len(names))
+	for _, name := range names {
+		consumerInfo = append(consumerInfo, command.NewArrayReply([]*command.Reply{
+			command.NewBulkStringReply("name"),
+			command.NewBulkStringReply(name),
+			command.NewBulkStringReply("pel-count"),
+			command.NewIntegerReply(int64(len(group.GetPendingIDs(name)))),
+			command.NewBulkStringReply("pending"),
+			command.NewArrayReply(consumerPEL[name]),
+		}))
+	}
+
+	return command.NewArrayReply([]*command.Reply{
+		command.NewBulkStringReply("name"),
+		command.NewBulkStringReply(group.GetName()),
+		command.NewBulkStringReply("last-delivered-id"),
+		command.NewBulkStringReply(group.GetLastID().String()),
+		command.NewBulkStringReply("entries-read"),
+		entriesReadReply(group),
+		command.NewBulkStringReply("pel-count"),
+		command.NewIntegerReply(int64(len(pending))),
+		command.NewBulkStringReply("pending"),
+		command.NewArrayReply(groupPEL),
+		command.NewBulkStringReply("consumers"),
+		command.NewArrayReply(consumerInfo),
+	})
+}
+
 // Helper functions
 
+// entriesReadReply returns the entries read by a group, nil if unknown
+func entriesReadReply(group *stream.ConsumerGroup) *command.Reply {
+	if n := group.GetEntriesRead(); n >= 0 {
+		return command.NewIntegerReply(n)
+	}
+	return command.NewNilReply()
+}
+
 func formatStreamEntries(entries []*stream.StreamEntry) *command.Reply {
 	if entries == nil {
 		return command.NewArrayReply(nil)
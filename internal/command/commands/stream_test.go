@@ -0,0 +1,523 @@
+package commands
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
+)
+
+// streamIDs returns the entry IDs of the stream stored at key
+func streamIDs(t *testing.T, db *database.DB, key string) []string {
+	t.Helper()
+
+	obj, ok := db.Get(key)
+	if !ok {
+		t.Fatalf("stream %s does not exist", key)
+	}
+	strm, _ := obj.GetStream()
+	var ids []string
+	for _, e := range strm.(*stream.Stream).GetEntries() {
+		ids = append(ids, e.ID.String())
+	}
+	return ids
+}
+
+func TestXaddNoMkStream(t *testing.T) {
+	db := database.NewDB(0)
+
+	reply, err := xaddCmd(newTestContext(t, db, "s", "NOMKSTREAM", "*", "f", "v"))
+	if err != nil {
+		t.Fatalf("XADD NOMKSTREAM failed: %v", err)
+	}
+	if !reply.IsNil() {
+		t.Errorf("XADD NOMKSTREAM on a missing key expected nil, got %v", reply.Value)
+	}
+	if db.Exists("s") != 0 {
+		t.Error("XADD NOMKSTREAM created the stream")
+	}
+
+	xaddCmd(newTestContext(t, db, "s", "1-1", "f", "v"))
+	reply, _ = xaddCmd(newTestContext(t, db, "s", "NOMKSTREAM", "2-1", "f", "v"))
+	if reply.Value != "2-1" {
+		t.Errorf("XADD NOMKSTREAM on an existing stream expected 2-1, got %v", reply.Value)
+	}
+}
+
+func TestXaddRejectsZeroID(t *testing.T) {
+	db := database.NewDB(0)
+
+	if _, err := xaddCmd(newTestContext(t, db, "s", "0-0", "f", "v")); err == nil {
+		t.Error("XADD 0-0 expected an error")
+	}
+	if db.Exists("s") != 0 {
+		t.Error("XADD 0-0 created the stream")
+	}
+}
+
+func TestXaddTrimsOnAdd(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 5; i++ {
+		reply, err := xaddCmd(newTestContext(t, db, "s", "MAXLEN", "3", strconv.Itoa(i)+"-0", "f", "v"))
+		if err != nil {
+			t.Fatalf("XADD MAXLEN failed: %v", err)
+		}
+		if reply.Value != strconv.Itoa(i)+"-0" {
+			t.Errorf("XADD MAXLEN expected ID %d-0, got %v", i, reply.Value)
+		}
+	}
+
+	ids := streamIDs(t, db, "s")
+	if len(ids) != 3 || ids[0] != "3-0" || ids[2] != "5-0" {
+		t.Errorf("XADD MAXLEN 3 expected [3-0 4-0 5-0], got %v", ids)
+	}
+
+	xaddCmd(newTestContext(t, db, "s", "MINID", "~", "5-0", "6-0", "f", "v"))
+	if ids := streamIDs(t, db, "s"); len(ids) != 2 || ids[0] != "5-0" {
+		t.Errorf("XADD MINID 5-0 expected [5-0 6-0], got %v", ids)
+	}
+
+	// Trimmed IDs are not reused
+	if _, err := xaddCmd(newTestContext(t, db, "s", "4-0", "f", "v")); err == nil {
+		t.Error("XADD with an ID below the last ID expected an error")
+	}
+}
+
+func TestXtrimMaxLen(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 5; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+
+	reply, err := xtrimCmd(newTestContext(t, db, "s", "MAXLEN", "2"))
+	if err != nil {
+		t.Fatalf("XTRIM failed: %v", err)
+	}
+	if reply.Value != int64(3) {
+		t.Errorf("XTRIM MAXLEN 2 expected 3 removed, got %v", reply.Value)
+	}
+	if ids := streamIDs(t, db, "s"); len(ids) != 2 || ids[0] != "4-0" {
+		t.Errorf("XTRIM MAXLEN 2 expected [4-0 5-0], got %v", ids)
+	}
+}
+
+func TestXtrimMinID(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 5; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+
+	reply, err := xtrimCmd(newTestContext(t, db, "s", "MINID", "3-0"))
+	if err != nil {
+		t.Fatalf("XTRIM MINID failed: %v", err)
+	}
+	if reply.Value != int64(2) {
+		t.Errorf("XTRIM MINID 3-0 expected 2 removed, got %v", reply.Value)
+	}
+	if ids := streamIDs(t, db, "s"); len(ids) != 3 || ids[0] != "3-0" || ids[2] != "5-0" {
+		t.Errorf("XTRIM MINID 3-0 expected [3-0 4-0 5-0], got %v", ids)
+	}
+}
+
+func TestXtrimMinIDLimit(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 5; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+
+	if _, err := xtrimCmd(newTestContext(t, db, "s", "MINID", "5-0", "LIMIT", "2")); err == nil {
+		t.Error("XTRIM LIMIT without ~ expected an error")
+	}
+
+	reply, err := xtrimCmd(newTestContext(t, db, "s", "MINID", "~", "5-0", "LIMIT", "2"))
+	if err != nil {
+		t.Fatalf("XTRIM MINID ~ LIMIT failed: %v", err)
+	}
+	if reply.Value != int64(2) {
+		t.Errorf("XTRIM MINID ~ 5-0 LIMIT 2 expected 2 removed, got %v", reply.Value)
+	}
+	if ids := streamIDs(t, db, "s"); len(ids) != 3 || ids[0] != "3-0" {
+		t.Errorf("XTRIM MINID ~ 5-0 LIMIT 2 expected [3-0 4-0 5-0], got %v", ids)
+	}
+}
+
+func TestXreadBlockWakesOnXadd(t *testing.T) {
+	db := database.NewDB(0)
+	xaddCmd(newTestContext(t, db, "k", "1-0", "f", "old"))
+
+	go func() {
+		// Add once the reader is parked
+		for streamWaiters.blockedClients() == 0 {
+			time.Sleep(time.Millisecond)
+		}
+		xaddCmd(newTestContext(t, db, "k", "2-0", "f", "new"))
+	}()
+
+	start := time.Now()
+	reply, err := xreadCmd(newTestContext(t, db, "BLOCK", "1000", "STREAMS", "k", "$"))
+	if err != nil {
+		t.Fatalf("XREAD BLOCK failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed >= time.Second {
+		t.Errorf("XREAD BLOCK waited for the timeout (%v) instead of waking on XADD", elapsed)
+	}
+
+	streams, ok := reply.Value.([]*command.Reply)
+	if !ok || len(streams) != 1 {
+		t.Fatalf("XREAD BLOCK expected one stream, got %v", reply.Value)
+	}
+	entries := streams[0].Value.([]*command.Reply)[1].Value.([]*command.Reply)
+	if len(entries) != 1 || entries[0].Value.([]*command.Reply)[0].Value != "2-0" {
+		t.Errorf("XREAD BLOCK $ expected only entry 2-0, got %v", entries)
+	}
+	if n := streamWaiters.blockedClients(); n != 0 {
+		t.Errorf("blocked clients after wakeup expected 0, got %d", n)
+	}
+}
+
+func TestXreadBlockTimeout(t *testing.T) {
+	db := database.NewDB(0)
+
+	reply, err := xreadCmd(newTestContext(t, db, "BLOCK", "20", "STREAMS", "k", "$"))
+	if err != nil {
+		t.Fatalf("XREAD BLOCK failed: %v", err)
+	}
+	if !reply.IsNil() {
+		t.Errorf("XREAD BLOCK timeout expected nil, got %v", reply.Value)
+	}
+
+	if _, err := xreadCmd(newTestContext(t, db, "BLOCK", "-1", "STREAMS", "k", "$")); err == nil {
+		t.Error("XREAD with a negative BLOCK expected an error")
+	}
+}
+
+func TestXackCountsOnlyPendingIDs(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 3; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+	if _, err := xgroupCmd(newTestContext(t, db, "CREATE", "s", "g", "0")); err != nil {
+		t.Fatalf("XGROUP CREATE failed: %v", err)
+	}
+	if _, err := xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c1", "COUNT", "2", "STREAMS", "s", ">")); err != nil {
+		t.Fatalf("XREADGROUP failed: %v", err)
+	}
+
+	tests := []struct {
+		ids  []string
+		want int64
+	}{
+		{[]string{"1-0"}, 1},
+		{[]string{"1-0"}, 0},               // Already acknowledged
+		{[]string{"3-0"}, 0},               // Never delivered
+		{[]string{"1-0", "2-0", "2-0"}, 1}, // Only 2-0 is still pending
+	}
+	for _, tt := range tests {
+		reply, err := xackCmd(newTestContext(t, db, append([]string{"s", "g"}, tt.ids...)...))
+		if err != nil {
+			t.Fatalf("XACK %v failed: %v", tt.ids, err)
+		}
+		if reply.Value != tt.want {
+			t.Errorf("XACK %v expected %d, got %v", tt.ids, tt.want, reply.Value)
+		}
+	}
+
+	reply, _ := xpendingCmd(newTestContext(t, db, "s", "g"))
+	if total := reply.Value.([]*command.Reply)[0].Value; total != int64(0) {
+		t.Errorf("XPENDING after acking everything expected 0 pending, got %v", total)
+	}
+}
+
+func TestXsetid(t *testing.T) {
+	db := database.NewDB(0)
+	if _, err := xsetidCmd(newTestContext(t, db, "s", "5-0")); err == nil {
+		t.Error("XSETID on a missing key expected an error")
+	}
+	xaddCmd(newTestContext(t, db, "s", "1-0", "f", "v"))
+	xaddCmd(newTestContext(t, db, "s", "2-0", "f", "v"))
+
+	for _, args := range [][]string{
+		{"s", "1-5"},                           // Below the top entry
+		{"s", "5-0", "ENTRIESADDED", "1"},      // Below the length
+		{"s", "5-0", "MAXDELETEDID", "6-0"},    // Above the last ID
+		{"s", "5-0", "ENTRIESADDED"},           // Missing value
+		{"s", "5-0", "ENTRIESADDED", "-1"},     // Negative count
+		{"s", "5-0", "NOSUCHOPTION", "1"},      // Unknown option
+		{"s", "not-an-id"},                     // Invalid ID
+		{"s", "5-0", "MAXDELETEDID", "nope-1"}, // Invalid deleted ID
+	} {
+		if _, err := xsetidCmd(newTestContext(t, db, args...)); err == nil {
+			t.Errorf("XSETID %v expected an error", args)
+		}
+	}
+
+	if _, err := xsetidCmd(newTestContext(t, db, "s", "9-0", "ENTRIESADDED", "7", "MAXDELETEDID", "8-0")); err != nil {
+		t.Fatalf("XSETID failed: %v", err)
+	}
+	// Lowering the last ID is allowed down to the top entry
+	if _, err := xsetidCmd(newTestContext(t, db, "s", "2-0")); err != nil {
+		t.Fatalf("XSETID to the top entry failed: %v", err)
+	}
+
+	obj, _ := db.Get("s")
+	strm, _ := obj.GetStream()
+	s := strm.(*stream.Stream)
+	if got := s.GetLastID().String(); got != "2-0" {
+		t.Errorf("last ID expected 2-0, got %s", got)
+	}
+	if got := s.EntriesAdded(); got != 7 {
+		t.Errorf("entries added expected 7, got %d", got)
+	}
+	if got := s.MaxDeletedID().String(); got != "8-0" {
+		t.Errorf("max deleted ID expected 8-0, got %s", got)
+	}
+}
+
+func TestXclaimOptions(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 3; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+	xgroupCmd(newTestContext(t, db, "CREATE", "s", "g", "0"))
+	xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "alice", "COUNT", "1", "STREAMS", "s", ">"))
+
+	group := func() *stream.ConsumerGroup {
+		obj, _ := db.Get("s")
+		strm, _ := obj.GetStream()
+		g, _ := strm.(*stream.Stream).GetConsumerGroupManager().GetGroup("g")
+		return g
+	}
+
+	// A message nobody owns is only claimed with FORCE
+	reply, err := xclaimCmd(newTestContext(t, db, "s", "g", "bob", "0", "2-0"))
+	if err != nil {
+		t.Fatalf("XCLAIM failed: %v", err)
+	}
+	if n := len(reply.Value.([]*command.Reply)); n != 0 {
+		t.Errorf("XCLAIM of an unowned message expected nothing, got %d entries", n)
+	}
+
+	// The message was just delivered, so it isn't idle long enough
+	reply, _ = xclaimCmd(newTestContext(t, db, "s", "g", "bob", "3600000", "1-0"))
+	if n := len(reply.Value.([]*command.Reply)); n != 0 {
+		t.Errorf("XCLAIM with a long min-idle-time expected nothing, got %d entries", n)
+	}
+
+	reply, err = xclaimCmd(newTestContext(t, db, "s", "g", "bob", "0", "1-0", "2-0",
+		"TIME", "1000", "RETRYCOUNT", "5", "FORCE", "JUSTID", "LASTID", "2-0"))
+	if err != nil {
+		t.Fatalf("XCLAIM with options failed: %v", err)
+	}
+	items := reply.Value.([]*command.Reply)
+	if len(items) != 2 || items[0].Value != "1-0" || items[1].Value != "2-0" {
+		t.Errorf("XCLAIM JUSTID expected [1-0 2-0], got %v", items)
+	}
+	for _, pe := range group().GetPending() {
+		if pe.Consumer != "bob" || pe.DeliveryTime != 1000 || pe.DeliveryCount != 5 {
+			t.Errorf("pending entry expected bob at 1000 with count 5, got %+v", pe)
+		}
+	}
+	if got := group().GetLastID().String(); got != "2-0" {
+		t.Errorf("LASTID expected the group's last ID to be 2-0, got %s", got)
+	}
+
+	// Without JUSTID, a claim counts as a delivery
+	xclaimCmd(newTestContext(t, db, "s", "g", "alice", "0", "1-0"))
+	if pe, _ := group().GetPendingEntry(stream.NewStreamID(1, 0)); pe.Consumer != "alice" || pe.DeliveryCount != 6 {
+		t.Errorf("claimed entry expected alice with count 6, got %+v", pe)
+	}
+
+	for _, args := range [][]string{
+		{"s", "g", "bob", "-1", "1-0"},
+		{"s", "g", "bob", "0", "1-0", "IDLE", "x"},
+		{"s", "g", "bob", "0", "1-0", "RETRYCOUNT"},
+		{"s", "g", "bob", "0", "1-0", "BOGUS", "1"},
+		{"s", "nogroup", "bob", "0", "1-0"},
+	} {
+		if _, err := xclaimCmd(newTestContext(t, db, args...)); err == nil {
+			t.Errorf("XCLAIM %v expected an error", args)
+		}
+	}
+}
+
+// replyFields maps the name/value pairs of an XINFO reply
+func replyFields(t *testing.T, reply *command.Reply) map[string]*command.Reply {
+	t.Helper()
+
+	items := reply.Value.([]*command.Reply)
+	fields := make(map[string]*command.Reply, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		fields[items[i].Value.(string)] = items[i+1]
+	}
+	return fields
+}
+
+// entryID returns the ID of an entry reply
+func entryID(reply *command.Reply) string {
+	return reply.Value.([]*command.Reply)[0].Value.(string)
+}
+
+func TestXinfoStream(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 4; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"+strconv.Itoa(i)))
+	}
+	xdelCmd(newTestContext(t, db, "s", "1-0", "3-0"))
+
+	reply, err := xinfoCmd(newTestContext(t, db, "STREAM", "s"))
+	if err != nil {
+		t.Fatalf("XINFO STREAM failed: %v", err)
+	}
+	fields := replyFields(t, reply)
+
+	if got := entryID(fields["first-entry"]); got != "2-0" {
+		t.Errorf("first-entry expected 2-0, got %s", got)
+	}
+	if got := entryID(fields["last-entry"]); got != "4-0" {
+		t.Errorf("last-entry expected 4-0, got %s", got)
+	}
+	first := fields["first-entry"].Value.([]*command.Reply)[1].Value.([]*command.Reply)
+	if first[0].Value != "f" || first[1].Value != "v2" {
+		t.Errorf("first-entry fields expected [f v2], got [%v %v]", first[0].Value, first[1].Value)
+	}
+	if got := fields["length"].Value; got != int64(2) {
+		t.Errorf("length expected 2, got %v", got)
+	}
+	if got := fields["entries-added"].Value; got != int64(4) {
+		t.Errorf("entries-added expected 4, got %v", got)
+	}
+	if got := fields["max-deleted-entry-id"].Value; got != "3-0" {
+		t.Errorf("max-deleted-entry-id expected 3-0, got %v", got)
+	}
+
+	xgroupCmd(newTestContext(t, db, "CREATE", "s", "g", "0"))
+	xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c1", "COUNT", "1", "STREAMS", "s", ">"))
+
+	reply, err = xinfoCmd(newTestContext(t, db, "STREAM", "s", "FULL"))
+	if err != nil {
+		t.Fatalf("XINFO STREAM FULL failed: %v", err)
+	}
+	fields = replyFields(t, reply)
+
+	entries := fields["entries"].Value.([]*command.Reply)
+	if len(entries) != 2 || entryID(entries[0]) != "2-0" || entryID(entries[1]) != "4-0" {
+		t.Errorf("FULL entries expected [2-0 4-0], got %d entries", len(entries))
+	}
+	groups := fields["groups"].Value.([]*command.Reply)
+	if len(groups) != 1 {
+		t.Fatalf("FULL expected 1 group, got %d", len(groups))
+	}
+	group := replyFields(t, groups[0])
+	if got := group["pel-count"].Value; got != int64(1) {
+		t.Errorf("group pel-count expected 1, got %v", got)
+	}
+	pel := group["pending"].Value.([]*command.Reply)
+	if len(pel) != 1 || entryID(pel[0]) != "2-0" {
+		t.Errorf("group PEL expected [2-0], got %d entries", len(pel))
+	}
+	consumers := group["consumers"].Value.([]*command.Reply)
+	if len(consumers) != 1 || replyFields(t, consumers[0])["name"].Value != "c1" {
+		t.Errorf("FULL expected consumer c1, got %d consumers", len(consumers))
+	}
+}
+
+func TestXgroupSetID(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 3; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+	xgroupCmd(newTestContext(t, db, "CREATE", "s", "g", "$"))
+
+	// Nothing new after $
+	reply, _ := xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c", "STREAMS", "s", ">"))
+	if results, _ := reply.Value.([]*command.Reply); len(results) != 0 {
+		t.Fatalf("XREADGROUP after $ expected no entries, got %d streams", len(results))
+	}
+
+	reply, err := xgroupCmd(newTestContext(t, db, "SETID", "s", "g", "1-0", "ENTRIESREAD", "1"))
+	if err != nil || reply.Value != "OK" {
+		t.Fatalf("XGROUP SETID failed: %v %v", reply, err)
+	}
+
+	reply, _ = xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c", "COUNT", "10", "STREAMS", "s", ">"))
+	entries := reply.Value.([]*command.Reply)[0].Value.([]*command.Reply)[1].Value.([]*command.Reply)
+	if len(entries) != 2 || entryID(entries[0]) != "2-0" || entryID(entries[1]) != "3-0" {
+		t.Errorf("XREADGROUP after SETID 1-0 expected [2-0 3-0], got %d entries", len(entries))
+	}
+
+	reply, _ = xinfoCmd(newTestContext(t, db, "GROUPS", "s"))
+	group := replyFields(t, reply.Value.([]*command.Reply)[0])
+	if got := group["entries-read"].Value; got != int64(3) {
+		t.Errorf("entries-read expected 3, got %v", got)
+	}
+
+	if _, err := xgroupCmd(newTestContext(t, db, "SETID", "s", "missing", "0")); err == nil {
+		t.Error("XGROUP SETID on a missing group expected error")
+	}
+}
+
+func TestXgroupCreateConsumer(t *testing.T) {
+	db := database.NewDB(0)
+	xaddCmd(newTestContext(t, db, "s", "1-0", "f", "v"))
+	xgroupCmd(newTestContext(t, db, "CREATE", "s", "g", "0"))
+
+	for _, want := range []int64{1, 0} {
+		reply, err := xgroupCmd(newTestContext(t, db, "CREATECONSUMER", "s", "g", "c"))
+		if err != nil {
+			t.Fatalf("XGROUP CREATECONSUMER failed: %v", err)
+		}
+		if reply.Value != want {
+			t.Errorf("XGROUP CREATECONSUMER expected %d, got %v", want, reply.Value)
+		}
+	}
+
+	xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c", "STREAMS", "s", ">"))
+	reply, _ := xgroupCmd(newTestContext(t, db, "DELCONSUMER", "s", "g", "c"))
+	if reply.Value != int64(1) {
+		t.Errorf("XGROUP DELCONSUMER expected 1 pending message, got %v", reply.Value)
+	}
+}
+
+func TestXreadgroupHistoryReplaysPending(t *testing.T) {
+	db := database.NewDB(0)
+	for i := 1; i <= 3; i++ {
+		xaddCmd(newTestContext(t, db, "s", strconv.Itoa(i)+"-0", "f", "v"))
+	}
+	xgroupCmd(newTestContext(t, db, "CREATE", "s", "g", "0"))
+	xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c1", "COUNT", "2", "STREAMS", "s", ">"))
+	xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c2", "STREAMS", "s", ">"))
+	xackCmd(newTestContext(t, db, "s", "g", "1-0"))
+
+	// pendingIDs re-reads the history of consumer c1 after start
+	pendingIDs := func(start string) []string {
+		reply, err := xreadgroupCmd(newTestContext(t, db, "GROUP", "g", "c1", "STREAMS", "s", start))
+		if err != nil {
+			t.Fatalf("XREADGROUP %s failed: %v", start, err)
+		}
+		var ids []string
+		for _, e := range reply.Value.([]*command.Reply)[0].Value.([]*command.Reply)[1].Value.([]*command.Reply) {
+			ids = append(ids, entryID(e))
+		}
+		return ids
+	}
+
+	if ids := pendingIDs("0"); len(ids) != 1 || ids[0] != "2-0" {
+		t.Errorf("history read from 0 expected [2-0], got %v", ids)
+	}
+	if ids := pendingIDs("2-0"); len(ids) != 0 {
+		t.Errorf("history read after 2-0 expected no entries, got %v", ids)
+	}
+
+	// History reads neither deliver new entries nor move the group
+	reply, _ := xinfoCmd(newTestContext(t, db, "GROUPS", "s"))
+	group := replyFields(t, reply.Value.([]*command.Reply)[0])
+	if got := group["last-delivered-id"].Value; got != "3-0" {
+		t.Errorf("last-delivered-id expected 3-0, got %v", got)
+	}
+	if got := group["pending"].Value; got != int64(2) {
+		t.Errorf("pending expected 2, got %v", got)
+	}
+}
@@ -187,6 +187,16 @@ func RegisterStringCommands(disp Dispatcher) {
 		LastKey:    1,
 		Categories: []string{command.CatString},
 	})
+
+	disp.Register(&command.Command{
+		Name:       "GETEX",
+		Handler:    getexCmd,
+		Arity:      -2,
+		Flags:      []string{command.FlagWrite, command.FlagFast},
+		FirstKey:   1,
+		LastKey:    1,
+		Categories: []string{command.CatString},
+	})
 }
 
 type Dispatcher interface {
@@ -207,8 +217,9 @@ func setCmd(ctx *command.Context) (*command.Reply, error) {
 	nx := false
 	xx := false
 	get := false
-	var exDuration time.Duration
-	var exTime int64
+	keepTTL := false
+	expire := "" // EX, PX, EXAT or PXAT
+	var ttl int64
 
 	i := 2
 	for i < len(args) {
@@ -220,45 +231,17 @@ func setCmd(ctx *command.Context) (*command.Reply, error) {
 			xx = true
 		case "GET":
 			get = true
-		case "EX":
-			if i+1 >= len(args) {
+		case "KEEPTTL":
+			keepTTL = true
+		case "EX", "PX", "EXAT", "PXAT":
+			if expire != "" || i+1 >= len(args) {
 				return nil, errors.New("syntax error")
 			}
-			seconds, err := strconv.Atoi(args[i+1])
+			n, err := strconv.ParseInt(args[i+1], 10, 64)
 			if err != nil {
-				return nil, errors.New("invalid expire time")
+				return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
 			}
-			exDuration = time.Duration(seconds) * time.Second
-			i++
-		case "PX":
-			if i+1 >= len(args) {
-				return nil, errors.New("syntax error")
-			}
-			ms, err := strconv.Atoi(args[i+1])
-			if err != nil {
-				return nil, errors.New("invalid expire time")
-			}
-			exDuration = time.Duration(ms) * time.Millisecond
-			i++
-		case "EXAT":
-			if i+1 >= len(args) {
-				return nil, errors.New("syntax error")
-			}
-			timestamp, err := strconv.ParseInt(args[i+1], 10, 64)
-			if err != nil {
-				return nil, errors.New("invalid expire time")
-			}
-			exTime = timestamp
-			i++
-		case "PXAT":
-			if i+1 >= len(args) {
-				return nil, errors.New("syntax error")
-			}
-			ms, err := strconv.ParseInt(args[i+1], 10, 64)
-			if err != nil {
-				return nil, errors.New("invalid expire time")
-			}
-			exTime = ms / 1000
+			expire, ttl = opt, n
 			i++
 		default:
 			return nil, errors.New("syntax error")
@@ -266,47 +249,64 @@ func setCmd(ctx *command.Context) (*command.Reply, error) {
 		i++
 	}
 
+	// A TTL is in seconds for EX and EXAT and milliseconds for PX and PXAT,
+	// and must be positive
+	msPerUnit := int64(1000)
+	if expire == "PX" || expire == "PXAT" {
+		msPerUnit = 1
+	}
+	absolute := expire == "EXAT" || expire == "PXAT"
+	if expire != "" && (ttl <= 0 || (!absolute && ttl > (math.MaxInt64-time.Now().UnixMilli())/msPerUnit)) {
+		return command.NewErrorReplyStr("ERR invalid expire time in 'set' command"), nil
+	}
+
 	// Check for conflicting options
 	if nx && xx {
 		return nil, errors.New("NX and XX options at the same time")
 	}
+	if keepTTL && expire != "" {
+		return nil, errors.New("syntax error")
+	}
 
-	// Get old value if GET option is set
+	// A single lookup serves both GET and the existence conditions
 	var oldValue string
-	if get {
-		if obj, ok := ctx.DB.Get(key); ok {
-			oldValue = obj.String()
+	var exists bool
+	if get || nx || xx {
+		var oldObj *database.Object
+		if oldObj, exists = ctx.DB.Get(key); exists && get {
+			oldValue = oldObj.String()
 		}
 	}
 
-	// Check existence conditions
-	if nx && ctx.DB.Exists(key) > 0 {
-		if get {
-			return command.NewNilReply(), nil
-		}
-		return command.NewNilReply(), nil
-	}
-	if xx && ctx.DB.Exists(key) == 0 {
-		if get {
-			return command.NewNilReply(), nil
+	// Check existence conditions. With GET, the old value is returned
+	// whether or not the key was set.
+	if (nx && exists) || (xx && !exists) {
+		ctx.PropagateNothing()
+		if get && exists {
+			return command.NewBulkStringReply(oldValue), nil
 		}
 		return command.NewNilReply(), nil
 	}
 
-	// Set the value
+	// Set the value, discarding any previous TTL unless KEEPTTL
 	obj := database.NewStringObject(value)
 	ctx.DB.Set(key, obj)
+	if !keepTTL {
+		ctx.DB.Persist(key)
+	}
 
-	// Set expiration
-	if exDuration > 0 {
-		ctx.DB.Expire(key, int(exDuration.Seconds()))
-	} else if exTime > 0 {
-		ctx.DB.ExpireAt(key, exTime)
+	// Set expiration. A relative one is logged as the absolute time it
+	// resolved to.
+	if expire != "" {
+		ctx.DB.PExpireAt(key, unixMilliExpireAt(ttl, msPerUnit, absolute))
+		if !absolute {
+			propagateSetExpire(ctx, key, value)
+		}
 	}
 
 	// Return old value if GET was set
 	if get {
-		if oldValue == "" {
+		if !exists {
 			return command.NewNilReply(), nil
 		}
 		return command.NewBulkStringReply(oldValue), nil
@@ -363,42 +363,112 @@ func msetCmd(ctx *command.Context) (*command.Reply, error) {
 
 // SETEX key seconds value
 func setexCmd(ctx *command.Context) (*command.Reply, error) {
-	if len(ctx.Args) != 3 {
-		return nil, errors.New("wrong number of arguments")
-	}
+	return setexGeneric(ctx, 1000)
+}
+
+// PSETEX key milliseconds value
+func psetexCmd(ctx *command.Context) (*command.Reply, error) {
+	return setexGeneric(ctx, 1)
+}
 
+// setexGeneric sets a value with a TTL given in units of msPerUnit
+// milliseconds, which must be positive
+func setexGeneric(ctx *command.Context, msPerUnit int64) (*command.Reply, error) {
 	key := ctx.Args[0]
-	seconds, err := strconv.Atoi(ctx.Args[1])
-	if err != nil || seconds < 0 {
-		return nil, errors.New("invalid expire time")
+	ttl, err := strconv.ParseInt(ctx.Args[1], 10, 64)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+	}
+	if ttl <= 0 || ttl > (math.MaxInt64-time.Now().UnixMilli())/msPerUnit {
+		return command.NewErrorReplyStr("ERR invalid expire time in '" + strings.ToLower(ctx.CmdName) + "' command"), nil
 	}
 	value := ctx.Args[2]
 
 	obj := database.NewStringObject(value)
 	ctx.DB.Set(key, obj)
-	ctx.DB.Expire(key, seconds)
+	ctx.DB.PExpireAt(key, unixMilliExpireAt(ttl, msPerUnit, false))
+	propagateSetExpire(ctx, key, value)
 
 	return command.NewStatusReply("OK"), nil
 }
 
-// PSETEX key milliseconds value
-func psetexCmd(ctx *command.Context) (*command.Reply, error) {
-	if len(ctx.Args) != 3 {
-		return nil, errors.New("wrong number of arguments")
+// propagateSetExpire logs a write of value to key with a relative TTL as a
+// SET followed by the absolute expiration time it resolved to
+func propagateSetExpire(ctx *command.Context, key, value string) {
+	ctx.Propagate("SET", key, value)
+	propagateExpire(ctx, key)
+}
+
+// unixMilliExpireAt returns the Unix time in milliseconds at which a TTL
+// of t units of msPerUnit milliseconds ends. t is counted from now unless
+// absolute.
+func unixMilliExpireAt(t, msPerUnit int64, absolute bool) int64 {
+	at := t * msPerUnit
+	if !absolute {
+		at += time.Now().UnixMilli()
 	}
+	return at
+}
 
+// GETEX key [EX seconds | PX milliseconds | EXAT unix-time-seconds |
+// PXAT unix-time-milliseconds | PERSIST]
+func getexCmd(ctx *command.Context) (*command.Reply, error) {
 	key := ctx.Args[0]
-	ms, err := strconv.Atoi(ctx.Args[1])
-	if err != nil || ms < 0 {
-		return nil, errors.New("invalid expire time")
+
+	option := ""
+	var at int64
+	switch {
+	case len(ctx.Args) == 1:
+	case len(ctx.Args) == 2 && strings.ToUpper(ctx.Args[1]) == "PERSIST":
+		option = "PERSIST"
+	case len(ctx.Args) == 3:
+		option = strings.ToUpper(ctx.Args[1])
+		var msPerUnit int64
+		switch option {
+		case "EX", "EXAT":
+			msPerUnit = 1000
+		case "PX", "PXAT":
+			msPerUnit = 1
+		default:
+			return command.NewErrorReplyStr("ERR syntax error"), nil
+		}
+		t, err := strconv.ParseInt(ctx.Args[2], 10, 64)
+		if err != nil {
+			return command.NewErrorReplyStr("ERR value is not an integer or out of range"), nil
+		}
+		if t <= 0 || t > (math.MaxInt64-time.Now().UnixMilli())/msPerUnit {
+			return command.NewErrorReplyStr("ERR invalid expire time in 'getex' command"), nil
+		}
+		at = unixMilliExpireAt(t, msPerUnit, strings.HasSuffix(option, "AT"))
+	default:
+		return command.NewErrorReplyStr("ERR syntax error"), nil
 	}
-	value := ctx.Args[2]
 
-	obj := database.NewStringObject(value)
-	ctx.DB.Set(key, obj)
-	ctx.DB.Expire(key, ms/1000)
+	obj, ok := ctx.DB.Get(key)
+	if !ok {
+		ctx.PropagateNothing()
+		return command.NewNilReply(), nil
+	}
+	if obj.Type != database.ObjTypeString {
+		return nil, errors.New("wrong type operation against a key holding another kind of value")
+	}
+	value := obj.String()
 
-	return command.NewStatusReply("OK"), nil
+	switch option {
+	case "":
+		ctx.PropagateNothing()
+	case "PERSIST":
+		if ctx.DB.Persist(key) {
+			ctx.Propagate("PERSIST", key)
+		} else {
+			ctx.PropagateNothing()
+		}
+	default:
+		ctx.DB.PExpireAt(key, at)
+		propagateExpire(ctx, key)
+	}
+
+	return command.NewBulkStringReply(value), nil
 }
 
 // SETNX key value
@@ -466,10 +536,14 @@ func doIncr(ctx *command.Context, delta int64) (*command.Reply, error) {
 		return command.NewIntegerReply(delta), nil
 	}
 
-	// Get current value
+	if obj.Type != database.ObjTypeString {
+		return nil, errors.New("wrong type operation against a key holding another kind of value")
+	}
+
+	// Get current value; a value set as a string is parsed here
 	current, ok := obj.Int()
 	if !ok {
-		return nil, errors.New("value is not an integer")
+		return nil, errors.New("ERR value is not an integer or out of range")
 	}
 
 	// Check for overflow
@@ -501,6 +575,7 @@ func incrbyfloatCmd(ctx *command.Context) (*command.Reply, error) {
 		newVal := strconv.FormatFloat(delta, 'f', -1, 64)
 		obj = database.NewStringObject(newVal)
 		ctx.DB.Set(key, obj)
+		ctx.Propagate("SET", key, newVal, "KEEPTTL")
 		return command.NewBulkStringReply(newVal), nil
 	}
 
@@ -521,6 +596,10 @@ func incrbyfloatCmd(ctx *command.Context) (*command.Reply, error) {
 	obj = database.NewStringObject(newValStr)
 	ctx.DB.Set(key, obj)
 
+	// Log the result rather than the increment, so float rounding on
+	// replay can't drift
+	ctx.Propagate("SET", key, newValStr, "KEEPTTL")
+
 	return command.NewBulkStringReply(newValStr), nil
 }
 
@@ -539,8 +618,9 @@ func appendCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewIntegerReply(int64(len(value))), nil
 	}
 
+	// An appended string is no longer shared or embedded
 	newValue := obj.String() + value
-	newObj := database.NewStringObject(newValue)
+	newObj := database.NewRawStringObject(newValue)
 	ctx.DB.Set(key, newObj)
 
 	return command.NewIntegerReply(int64(len(newValue))), nil
@@ -656,7 +736,7 @@ func setrangeCmd(ctx *command.Context) (*command.Reply, error) {
 		s = string(runes)
 	}
 
-	newObj := database.NewStringObject(s)
+	newObj := database.NewRawStringObject(s)
 	ctx.DB.Set(key, newObj)
 
 	return command.NewIntegerReply(int64(len(s))), nil
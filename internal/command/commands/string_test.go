@@ -0,0 +1,304 @@
+package commands
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+func TestStringGrowthUpdatesMemory(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("key", database.NewStringObject(strings.Repeat("a", 100)))
+
+	before := db.GetMemoryUsage()
+
+	// Grow by 1000 bytes past the end of the string
+	ctx := newTestContext(t, db, "key", "1000", strings.Repeat("b", 100))
+	if _, err := setrangeCmd(ctx); err != nil {
+		t.Fatalf("SETRANGE failed: %v", err)
+	}
+	if delta := db.GetMemoryUsage() - before; delta != 1000 {
+		t.Errorf("SETRANGE growth expected memory delta 1000, got %d", delta)
+	}
+
+	before = db.GetMemoryUsage()
+	ctx = newTestContext(t, db, "key", strings.Repeat("c", 50))
+	if _, err := appendCmd(ctx); err != nil {
+		t.Fatalf("APPEND failed: %v", err)
+	}
+	if delta := db.GetMemoryUsage() - before; delta != 50 {
+		t.Errorf("APPEND expected memory delta 50, got %d", delta)
+	}
+
+	// Setting bit 8*2000 grows the 1150 byte string to 2001 bytes
+	before = db.GetMemoryUsage()
+	ctx = newTestContext(t, db, "key", "16000", "1")
+	if _, err := setbitCmd(ctx); err != nil {
+		t.Fatalf("SETBIT failed: %v", err)
+	}
+	if delta := db.GetMemoryUsage() - before; delta != 851 {
+		t.Errorf("SETBIT expected memory delta 851, got %d", delta)
+	}
+
+	db.Delete("key")
+	if usage := db.GetMemoryUsage(); usage != 0 {
+		t.Errorf("memory after DEL expected 0, got %d", usage)
+	}
+}
+
+func TestSetGetConditions(t *testing.T) {
+	tests := []struct {
+		name    string
+		initial *string
+		args    []string
+		want    interface{} // nil for a nil reply
+		stored  *string     // nil if the key must not exist
+	}{
+		{"GET on missing key", nil, []string{"GET"}, nil, strPtr("new")},
+		{"GET on existing key", strPtr("old"), []string{"GET"}, "old", strPtr("new")},
+		{"GET on empty value", strPtr(""), []string{"GET"}, "", strPtr("new")},
+		{"NX on missing key", nil, []string{"NX"}, "OK", strPtr("new")},
+		{"NX on existing key", strPtr("old"), []string{"NX"}, nil, strPtr("old")},
+		{"NX GET on missing key", nil, []string{"NX", "GET"}, nil, strPtr("new")},
+		{"NX GET on existing key", strPtr("old"), []string{"NX", "GET"}, "old", strPtr("old")},
+		{"XX on missing key", nil, []string{"XX"}, nil, nil},
+		{"XX GET on existing key", strPtr("old"), []string{"XX", "GET"}, "old", strPtr("new")},
+	}
+
+	for _, tt := range tests {
+		db := database.NewDB(0)
+		if tt.initial != nil {
+			db.Set("k", database.NewStringObject(*tt.initial))
+		}
+
+		reply, err := setCmd(newTestContext(t, db, append([]string{"k", "new"}, tt.args...)...))
+		if err != nil {
+			t.Fatalf("%s: SET failed: %v", tt.name, err)
+		}
+		if tt.want == nil {
+			if !reply.IsNil() {
+				t.Errorf("%s: expected nil, got %v", tt.name, reply.Value)
+			}
+		} else if reply.Value != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, reply.Value)
+		}
+
+		obj, ok := db.Get("k")
+		if tt.stored == nil {
+			if ok {
+				t.Errorf("%s: key should not exist, got %q", tt.name, obj.String())
+			}
+		} else if !ok || obj.String() != *tt.stored {
+			t.Errorf("%s: expected stored value %q", tt.name, *tt.stored)
+		}
+	}
+}
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func BenchmarkSetNXGet(b *testing.B) {
+	db := database.NewDB(0)
+	db.Set("k", database.NewStringObject("v"))
+	ctx := &command.Context{DB: db, Args: []string{"k", "v", "NX", "GET"}}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := setCmd(ctx); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestSetKeepTTL(t *testing.T) {
+	db := database.NewDB(0)
+	db.Set("k", database.NewStringObject("v"))
+	db.Expire("k", 100)
+
+	if _, err := setCmd(newTestContext(t, db, "k", "v2", "KEEPTTL")); err != nil {
+		t.Fatalf("SET KEEPTTL failed: %v", err)
+	}
+	if ttl := db.TTL("k"); ttl <= 0 {
+		t.Errorf("SET KEEPTTL expected the TTL kept, got %d", ttl)
+	}
+
+	if _, err := setCmd(newTestContext(t, db, "k", "v3")); err != nil {
+		t.Fatalf("SET failed: %v", err)
+	}
+	if ttl := db.TTL("k"); ttl != -1 {
+		t.Errorf("SET expected the TTL cleared, got %d", ttl)
+	}
+
+	if _, err := setCmd(newTestContext(t, db, "k", "v", "KEEPTTL", "EX", "10")); err == nil {
+		t.Error("SET KEEPTTL EX expected a syntax error")
+	}
+}
+
+func TestIntEncodedStringOperations(t *testing.T) {
+	db := database.NewDB(0)
+	length := func(handler command.Handler, args ...string) int64 {
+		t.Helper()
+		reply, err := handler(newTestContext(t, db, args...))
+		if err != nil {
+			t.Fatalf("%v failed: %v", args, err)
+		}
+		return reply.Value.(int64)
+	}
+	get := func(key string) interface{} {
+		t.Helper()
+		reply, _ := getCmd(newTestContext(t, db, key))
+		return reply.Value
+	}
+
+	setCmd(newTestContext(t, db, "k", "100"))
+	if n := length(appendCmd, "k", "00"); n != 5 || get("k") != "10000" {
+		t.Errorf("APPEND k 00 expected 10000 of length 5, got %v of length %d", get("k"), n)
+	}
+
+	setCmd(newTestContext(t, db, "n", "12344"))
+	incrCmd(newTestContext(t, db, "n"))
+	if n := length(appendCmd, "n", ""); n != 5 {
+		t.Errorf("APPEND n \"\" expected the digit length 5, got %d", n)
+	}
+	if reply, _ := getrangeCmd(newTestContext(t, db, "n", "1", "2")); reply.Value != "23" {
+		t.Errorf("GETRANGE n 1 2 expected 23, got %v", reply.Value)
+	}
+
+	setCmd(newTestContext(t, db, "m", "100"))
+	setCmd(newTestContext(t, db, "other", "100"))
+	if n := length(setrangeCmd, "m", "1", "9"); n != 3 || get("m") != "190" {
+		t.Errorf("SETRANGE m 1 9 expected 190 of length 3, got %v of length %d", get("m"), n)
+	}
+	if get("other") != "100" {
+		t.Errorf("SETRANGE on m expected other to keep 100, got %v", get("other"))
+	}
+}
+
+func TestIncrParsesStringValues(t *testing.T) {
+	db := database.NewDB(0)
+
+	setCmd(newTestContext(t, db, "k", "10"))
+	reply, err := incrCmd(newTestContext(t, db, "k"))
+	if err != nil || reply.Value != int64(11) {
+		t.Errorf("SET k 10; INCR k expected 11, got %v (err %v)", reply, err)
+	}
+
+	// A raw string holding digits, as built by APPEND
+	setCmd(newTestContext(t, db, "raw", "1"))
+	appendCmd(newTestContext(t, db, "raw", "0000"))
+	reply, err = decrCmd(newTestContext(t, db, "raw"))
+	if err != nil || reply.Value != int64(9999) {
+		t.Errorf("DECR on APPENDed 10000 expected 9999, got %v (err %v)", reply, err)
+	}
+
+	for _, value := range []string{"10.5", " 10", "10 ", "+10", "010", "-0", "abc", ""} {
+		setCmd(newTestContext(t, db, "bad", value))
+		if _, err := incrCmd(newTestContext(t, db, "bad")); err == nil {
+			t.Errorf("INCR on %q expected an error", value)
+		}
+	}
+
+	rpushCmd(newTestContext(t, db, "list", "1"))
+	if _, err := incrCmd(newTestContext(t, db, "list")); err == nil || !strings.Contains(err.Error(), "wrong type") {
+		t.Errorf("INCR on a list expected a wrong type error, got %v", err)
+	}
+}
+
+func TestSetexRejectsNonPositiveTTL(t *testing.T) {
+	db := database.NewDB(0)
+
+	for _, tt := range []struct {
+		handler command.Handler
+		name    string
+		ttl     string
+	}{
+		{setexCmd, "SETEX", "0"},
+		{setexCmd, "SETEX", "-1"},
+		{psetexCmd, "PSETEX", "0"},
+	} {
+		ctx := newTestContext(t, db, "k", tt.ttl, "v")
+		ctx.CmdName = tt.name
+		reply, _ := tt.handler(ctx)
+		want := "ERR invalid expire time in '" + strings.ToLower(tt.name) + "' command"
+		if reply.Type != command.ReplyTypeError || !strings.Contains(reply.Value.(string), want) {
+			t.Errorf("%s %s expected %q, got %v", tt.name, tt.ttl, want, reply.Value)
+		}
+	}
+	if db.Exists("k") != 0 {
+		t.Error("rejected SETEX expected not to create the key")
+	}
+
+	for _, args := range [][]string{{"k", "v", "EX", "0"}, {"k", "v", "PX", "-1"}} {
+		reply, _ := setCmd(newTestContext(t, db, args...))
+		if reply.Type != command.ReplyTypeError || !strings.Contains(reply.Value.(string), "invalid expire time in 'set' command") {
+			t.Errorf("SET %v expected an invalid expire time error, got %v", args, reply.Value)
+		}
+	}
+
+	// A sub-second TTL is kept to the millisecond: it doesn't expire the
+	// key at once, nor outlive its deadline
+	if _, err := psetexCmd(newTestContext(t, db, "k", "500", "v")); err != nil {
+		t.Fatalf("PSETEX failed: %v", err)
+	}
+	if _, err := setCmd(newTestContext(t, db, "k2", "v", "PX", "500")); err != nil {
+		t.Fatalf("SET PX failed: %v", err)
+	}
+	for _, key := range []string{"k", "k2"} {
+		if pttl := db.PTTL(key); pttl <= 400 || pttl > 500 {
+			t.Errorf("%s with a 500ms TTL expected a PTTL of up to 500, got %d", key, pttl)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	for _, key := range []string{"k", "k2"} {
+		if reply, _ := getCmd(newTestContext(t, db, key)); reply.Value != "v" {
+			t.Errorf("%s with a 500ms TTL expected to survive 100ms, got %v", key, reply.Value)
+		}
+	}
+	time.Sleep(450 * time.Millisecond)
+	for _, key := range []string{"k", "k2"} {
+		if reply, _ := getCmd(newTestContext(t, db, key)); reply.Type != command.ReplyTypeNil {
+			t.Errorf("%s with a 500ms TTL expected to expire, got %v", key, reply.Value)
+		}
+	}
+}
+
+func TestGetEx(t *testing.T) {
+	db := database.NewDB(0)
+	setCmd(newTestContext(t, db, "k", "v"))
+
+	ctx := newTestContext(t, db, "k", "EX", "100")
+	if reply, _ := getexCmd(ctx); reply.Value != "v" {
+		t.Fatalf("GETEX EX expected v, got %v", reply.Value)
+	}
+	if ttl := db.TTL("k"); ttl <= 0 || ttl > 100 {
+		t.Errorf("TTL after GETEX EX 100 expected within (0, 100], got %d", ttl)
+	}
+	if got := ctx.Propagation(); len(got) != 1 || got[0][0] != "PEXPIREAT" {
+		t.Errorf("GETEX EX expected to propagate PEXPIREAT, got %v", got)
+	}
+
+	ctx = newTestContext(t, db, "k", "persist")
+	if reply, _ := getexCmd(ctx); reply.Value != "v" {
+		t.Fatalf("GETEX PERSIST expected v, got %v", reply.Value)
+	}
+	if ttl := db.TTL("k"); ttl != -1 {
+		t.Errorf("TTL after GETEX PERSIST expected -1, got %d", ttl)
+	}
+	if got := ctx.Propagation(); len(got) != 1 || got[0][0] != "PERSIST" {
+		t.Errorf("GETEX PERSIST expected to propagate PERSIST, got %v", got)
+	}
+
+	for _, args := range [][]string{{"k", "EX", "0"}, {"k", "EX"}, {"k", "PERSIST", "1"}, {"k", "KEEPTTL"}} {
+		if reply, _ := getexCmd(newTestContext(t, db, args...)); reply.Type != command.ReplyTypeError {
+			t.Errorf("GETEX %v expected an error, got %v", args, reply.Value)
+		}
+	}
+	if reply, _ := getexCmd(newTestContext(t, db, "missing", "PERSIST")); reply.Type != command.ReplyTypeNil {
+		t.Errorf("GETEX of a missing key expected nil, got %v", reply.Value)
+	}
+}
@@ -106,114 +106,30 @@ func execCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewErrorReplyStr("ERR EXEC without MULTI"), nil
 	}
 
-	// Get the queued commands
+	// Check for commands rejected while queuing, and watched keys
+	// modified since WATCH
+	queueError := txManager.HasQueueError(ctx.Conn)
+	aborted := txManager.CheckWatchedKeys(ctx.Conn)
+
+	// IMPORTANT: Clear the queue, MULTI state and watched keys BEFORE
+	// executing commands. This prevents the dispatcher from re-queueing the
+	// commands, and the transaction's own writes from dirtying the client.
 	queued := txManager.GetQueue(ctx.Conn)
-	if queued == nil || len(queued) == 0 {
-		// Empty transaction - just exit MULTI state
-		ctx.Conn.SetInMulti(false)
-		txManager.Discard(ctx.Conn)
-		return command.NewArrayReplyFromAny([]interface{}{}), nil
-	}
-
-	// Check if watched keys were modified
-	if txManager.CheckWatchedKeys(ctx.Conn) {
-		// Clear the watched keys
-		// Note: Skipping UnwatchAll to avoid deadlock
-		txManager.Discard(ctx.Conn)
-		ctx.Conn.SetInMulti(false)
-
-		// Return nil for each queued command
-		results := make([]interface{}, len(queued))
-		for i := range results {
-			results[i] = nil
-		}
-		return command.NewArrayReplyFromAny(results), nil
-	}
-
-	// IMPORTANT: Clear the queue and MULTI state BEFORE executing commands
-	// This prevents the dispatcher from re-queueing the commands
 	txManager.Discard(ctx.Conn)
+	txManager.UnwatchAll(ctx.Conn)
 	ctx.Conn.SetInMulti(false)
 
-	// Execute each queued command
-	replies := make([]interface{}, 0, len(queued))
-	for _, queuedCmd := range queued {
-		// Use the dispatcher to execute the command
-		cmd, ok := txDisp.Get(queuedCmd.CmdName)
-		if !ok {
-			replies = append(replies, "ERR unknown command '"+queuedCmd.CmdName+"'")
-			continue
-		}
-
-		// Create command context
-		cmdCtx := &command.Context{
-			DB:      ctx.DB,
-			Conn:    ctx.Conn,
-			CmdName: queuedCmd.CmdName,
-			Args:    queuedCmd.Args,
-		}
-
-		// Check Arity before executing the command
-		if err := cmd.CheckArity(len(queuedCmd.Args)); err != nil {
-			replies = append(replies, err.Error())
-			continue
-		}
-
-		// Execute the command
-		reply, err := cmd.Handler(cmdCtx)
-		if err != nil {
-			// Error during execution - return error in response
-			replies = append(replies, err.Error())
-		} else {
-			// Convert reply to value
-			val := replyToValue(reply)
-			replies = append(replies, val)
-		}
+	if queueError {
+		return command.NewErrorReply(transaction.ErrExecAbort), nil
 	}
-
-	// Clear dirty keys that are no longer watched by any connection
-	txManager.ClearWatchedDirty(ctx.Conn)
-
-	return command.NewArrayReplyFromAny(replies), nil
-}
-
-// replyToValue converts a Reply to a value suitable for EXEC response
-func replyToValue(reply *command.Reply) interface{} {
-	if reply == nil {
-		return nil
+	if aborted {
+		return command.NewNilArrayReply(), nil
 	}
-
-	switch reply.Type {
-	case command.ReplyTypeStatus:
-		if s, ok := reply.Value.(string); ok {
-			return s
-		}
-		return "OK"
-	case command.ReplyTypeError:
-		if s, ok := reply.Value.(string); ok {
-			return s
-		}
-		return reply.Value
-	case command.ReplyTypeInteger:
-		if i, ok := reply.Value.(int64); ok {
-			return i
-		}
-		return reply.Value
-	case command.ReplyTypeBulkString:
-		if s, ok := reply.Value.(string); ok {
-			return s
-		}
-		if b, ok := reply.Value.([]byte); ok {
-			return string(b)
-		}
-		return reply.Value
-	case command.ReplyTypeArray:
-		return reply.Value
-	case command.ReplyTypeNil:
-		return nil
-	default:
-		return reply.Value
+	if len(queued) == 0 {
+		return command.NewArrayReplyFromAny([]interface{}{}), nil
 	}
+
+	return command.NewArrayReply(txDisp.ExecTransaction(ctx.Conn, queued)), nil
 }
 
 // DISCARD discards all queued commands
@@ -227,8 +143,7 @@ func discardCmd(ctx *command.Context) (*command.Reply, error) {
 	txManager.Discard(ctx.Conn)
 
 	// Clear the watched keys
-	// Note: Skipping UnwatchAll to avoid deadlock
-	// The watched keys will be cleaned up when the connection closes
+	txManager.UnwatchAll(ctx.Conn)
 
 	// Exit MULTI state
 	ctx.Conn.SetInMulti(false)
@@ -248,7 +163,7 @@ func watchCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	// Add keys to watch list
-	txManager.Watch(ctx.Conn, ctx.Args...)
+	txManager.Watch(ctx.Conn, ctx.DB.GetID(), ctx.Args...)
 
 	return command.NewStatusReply("OK"), nil
 }
@@ -0,0 +1,281 @@
+package commands
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/net"
+)
+
+// setupTransactions returns a dispatcher with the string and transaction
+// commands whose writes dirty watched keys
+func setupTransactions(t *testing.T) (*command.Dispatcher, *database.DB) {
+	t.Helper()
+
+	selector := database.NewDBSelector(2)
+	disp := command.NewDispatcher(selector)
+	selector.SetTransactionManager(disp.GetTxManager())
+	SetTxManager(disp.GetTxManager())
+	RegisterStringCommands(disp)
+	RegisterTransactionCommands(disp)
+	t.Cleanup(func() { SetTxManager(nil) })
+
+	db, _ := selector.GetDB(0)
+	return disp, db
+}
+
+// dispatch runs a command on conn and returns the raw RESP reply
+func dispatch(t *testing.T, disp *command.Dispatcher, conn *net.Conn, cmd string, args ...string) string {
+	t.Helper()
+
+	reply, err := disp.Dispatch(context.Background(), conn, cmd, args)
+	if err != nil {
+		t.Fatalf("%s failed: %v", cmd, err)
+	}
+	return string(reply)
+}
+
+func TestWatchAbortsExecOnConcurrentWrite(t *testing.T) {
+	disp, db := setupTransactions(t)
+	client := newTestContext(t, db).Conn
+	other := newTestContext(t, db).Conn
+
+	dispatch(t, disp, client, "WATCH", "k")
+	dispatch(t, disp, client, "MULTI")
+	if got := dispatch(t, disp, client, "SET", "k", "from-tx"); got != "+QUEUED\r\n" {
+		t.Fatalf("SET inside MULTI expected QUEUED, got %q", got)
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		dispatch(t, disp, other, "SET", "k", "concurrent")
+	}()
+	wg.Wait()
+
+	if got := dispatch(t, disp, client, "EXEC"); got != "*-1\r\n" {
+		t.Errorf("EXEC after a write to a watched key expected a null array, got %q", got)
+	}
+	if obj, _ := db.Get("k"); obj.String() != "concurrent" {
+		t.Errorf("aborted transaction wrote k, got %q", obj.String())
+	}
+
+	// EXEC releases the watch, so the next transaction runs
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "SET", "k", "from-tx")
+	if got := dispatch(t, disp, client, "EXEC"); got != "*1\r\n+OK\r\n" {
+		t.Errorf("EXEC without watched keys expected [OK], got %q", got)
+	}
+
+	// RESP3 clients get a null
+	client.SetProtocol(3)
+	dispatch(t, disp, client, "WATCH", "k")
+	dispatch(t, disp, other, "SET", "k", "concurrent")
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "GET", "k")
+	if got := dispatch(t, disp, client, "EXEC"); got != "_\r\n" {
+		t.Errorf("aborted EXEC under RESP3 expected a null, got %q", got)
+	}
+}
+
+func TestWatchIgnoresUnrelatedWrites(t *testing.T) {
+	disp, db := setupTransactions(t)
+	client := newTestContext(t, db).Conn
+	other := newTestContext(t, db).Conn
+
+	dispatch(t, disp, client, "WATCH", "k")
+	dispatch(t, disp, other, "SET", "unrelated", "v")
+
+	// A client watching the same key later does not reset the first watch
+	dispatch(t, disp, other, "SET", "k", "v")
+	dispatch(t, disp, other, "WATCH", "k")
+
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "GET", "k")
+	if got := dispatch(t, disp, client, "EXEC"); got != "*-1\r\n" {
+		t.Errorf("EXEC after k changed expected a null array, got %q", got)
+	}
+
+	dispatch(t, disp, other, "UNWATCH")
+	dispatch(t, disp, client, "SET", "k", "v2")
+	dispatch(t, disp, other, "MULTI")
+	dispatch(t, disp, other, "GET", "k")
+	if got := dispatch(t, disp, other, "EXEC"); got != "*1\r\n$2\r\nv2\r\n" {
+		t.Errorf("EXEC after UNWATCH expected [v2], got %q", got)
+	}
+}
+
+func TestWatchSeesFlushes(t *testing.T) {
+	disp, db := setupTransactions(t)
+	RegisterKeyCommands(disp)
+	client := newTestContext(t, db).Conn
+	other := newTestContext(t, db).Conn
+
+	for _, flush := range []string{"FLUSHDB", "FLUSHALL"} {
+		dispatch(t, disp, other, "SET", "k", "v")
+		dispatch(t, disp, client, "WATCH", "k")
+		dispatch(t, disp, other, flush)
+
+		dispatch(t, disp, client, "MULTI")
+		dispatch(t, disp, client, "SET", "k", "from-tx")
+		if got := dispatch(t, disp, client, "EXEC"); got != "*-1\r\n" {
+			t.Errorf("EXEC after %s removed a watched key expected a null array, got %q", flush, got)
+		}
+		if db.Exists("k") != 0 {
+			t.Errorf("aborted transaction after %s wrote k", flush)
+		}
+	}
+}
+
+func TestWatchSeesInPlaceWrites(t *testing.T) {
+	disp, db := setupTransactions(t)
+	RegisterListCommands(disp)
+	RegisterHashCommands(disp)
+	RegisterSetCommands(disp)
+	RegisterZSetCommands(disp)
+	client := newTestContext(t, db).Conn
+	other := newTestContext(t, db).Conn
+
+	tests := []struct {
+		setup []string
+		write []string
+		read  string
+	}{
+		{[]string{"RPUSH", "l", "a"}, []string{"RPUSH", "l", "b"}, "LLEN"},
+		{[]string{"HSET", "h", "f", "1"}, []string{"HSET", "h", "f", "2"}, "HLEN"},
+		{[]string{"SADD", "s", "a"}, []string{"SADD", "s", "b"}, "SCARD"},
+		{[]string{"ZADD", "z", "1", "a"}, []string{"ZADD", "z", "2", "a"}, "ZCARD"},
+	}
+
+	for _, tt := range tests {
+		key := tt.setup[1]
+		dispatch(t, disp, other, tt.setup[0], tt.setup[1:]...)
+		dispatch(t, disp, client, "WATCH", key)
+		dispatch(t, disp, other, tt.write[0], tt.write[1:]...)
+
+		dispatch(t, disp, client, "MULTI")
+		dispatch(t, disp, client, tt.read, key)
+		if got := dispatch(t, disp, client, "EXEC"); got != "*-1\r\n" {
+			t.Errorf("EXEC after %v expected a null array, got %q", tt.write, got)
+		}
+	}
+
+	// A write that changes nothing leaves the watch alone
+	dispatch(t, disp, other, "SET", "k", "v")
+	dispatch(t, disp, client, "WATCH", "k")
+	dispatch(t, disp, other, "SET", "k", "w", "NX")
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "GET", "k")
+	if got := dispatch(t, disp, client, "EXEC"); got != "*1\r\n$1\r\nv\r\n" {
+		t.Errorf("EXEC after a no-op SET NX expected [v], got %q", got)
+	}
+}
+
+func TestExecPropagatesWritesAsATransaction(t *testing.T) {
+	disp, db := setupTransactions(t)
+	feed := &feedRecorder{}
+	disp.SetReplicationFeed(feed)
+	client := newTestContext(t, db).Conn
+
+	dispatch(t, disp, client, "SET", "k", "v")
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "GET", "k")
+	dispatch(t, disp, client, "SET", "k", "v2")
+	dispatch(t, disp, client, "INCR", "k")
+	dispatch(t, disp, client, "SETEX", "t", "100", "v")
+	dispatch(t, disp, client, "EXEC")
+
+	// The failed INCR is left out and SETEX is logged as its effect
+	want := []string{"0 SET k v", "0 MULTI ", "0 SET k v2", "0 SET t v"}
+	if len(feed.commands) != 6 || fmt.Sprint(feed.commands[:4]) != fmt.Sprint(want) ||
+		!strings.HasPrefix(feed.commands[4], "0 PEXPIREAT t ") || feed.commands[5] != "0 EXEC " {
+		t.Fatalf("propagated %q", feed.commands)
+	}
+
+	// A transaction that only reads propagates nothing
+	feed.commands = nil
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "GET", "k")
+	dispatch(t, disp, client, "EXEC")
+	if len(feed.commands) != 0 {
+		t.Errorf("read-only transaction propagated %q", feed.commands)
+	}
+}
+
+func TestExecAbortsAfterQueueError(t *testing.T) {
+	disp, db := setupTransactions(t)
+	client := newTestContext(t, db).Conn
+
+	tests := []struct {
+		name string
+		cmd  []string
+	}{
+		{"unknown command", []string{"NOSUCHCMD", "k"}},
+		{"wrong arity", []string{"GET"}},
+	}
+
+	for _, tt := range tests {
+		dispatch(t, disp, client, "MULTI")
+		dispatch(t, disp, client, "SET", "k", "v")
+		if got := dispatch(t, disp, client, tt.cmd[0], tt.cmd[1:]...); !strings.HasPrefix(got, "-") {
+			t.Errorf("%s: queuing expected an error, got %q", tt.name, got)
+		}
+
+		want := "-EXECABORT Transaction discarded because of previous errors.\r\n"
+		if got := dispatch(t, disp, client, "EXEC"); got != want {
+			t.Errorf("%s: EXEC expected %q, got %q", tt.name, want, got)
+		}
+		if db.Exists("k") != 0 {
+			t.Errorf("%s: aborted transaction wrote k", tt.name)
+		}
+		if client.IsInMulti() {
+			t.Errorf("%s: client still in MULTI after EXECABORT", tt.name)
+		}
+	}
+}
+
+func TestExecReturnsRuntimeErrorsInPlace(t *testing.T) {
+	disp, db := setupTransactions(t)
+	client := newTestContext(t, db).Conn
+	db.Set("list", database.NewListObject())
+
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "SET", "k", "v")
+	dispatch(t, disp, client, "INCR", "k")
+	dispatch(t, disp, client, "GET", "k")
+
+	got := dispatch(t, disp, client, "EXEC")
+	if !strings.HasPrefix(got, "*3\r\n+OK\r\n-") || !strings.HasSuffix(got, "$1\r\nv\r\n") {
+		t.Errorf("EXEC expected [OK, error, v], got %q", got)
+	}
+}
+
+func TestDiscardClearsQueueAndWatch(t *testing.T) {
+	disp, db := setupTransactions(t)
+	client := newTestContext(t, db).Conn
+	other := newTestContext(t, db).Conn
+
+	dispatch(t, disp, client, "WATCH", "k")
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "SET", "k", "from-tx")
+	dispatch(t, disp, other, "SET", "k", "concurrent")
+	if got := dispatch(t, disp, client, "DISCARD"); got != "+OK\r\n" {
+		t.Fatalf("DISCARD expected OK, got %q", got)
+	}
+	if got := dispatch(t, disp, client, "EXEC"); !strings.HasPrefix(got, "-ERR EXEC without MULTI") {
+		t.Errorf("EXEC after DISCARD expected an error, got %q", got)
+	}
+
+	// The watch was cleared with the queue
+	dispatch(t, disp, client, "MULTI")
+	dispatch(t, disp, client, "GET", "k")
+	if got := dispatch(t, disp, client, "EXEC"); got != "*1\r\n$10\r\nconcurrent\r\n" {
+		t.Errorf("EXEC after DISCARD expected [concurrent], got %q", got)
+	}
+}
@@ -268,7 +268,7 @@ func RegisterZSetCommands(disp Dispatcher) {
 	})
 }
 
-// ZADD key [NX|XX] [CH] [INCR] score member [score member ...]
+// ZADD key [NX|XX] [GT|LT] [CH] [INCR] score member [score member ...]
 func zaddCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
 	if len(args) < 3 {
@@ -280,6 +280,8 @@ func zaddCmd(ctx *command.Context) (*command.Reply, error) {
 	// Parse options
 	nx := false
 	xx := false
+	gt := false
+	lt := false
 	ch := false
 	incr := false
 	idx := 1
@@ -293,6 +295,12 @@ OptionsLoop:
 		case "XX":
 			xx = true
 			idx++
+		case "GT":
+			gt = true
+			idx++
+		case "LT":
+			lt = true
+			idx++
 		case "CH":
 			ch = true
 			idx++
@@ -304,6 +312,12 @@ OptionsLoop:
 		}
 	}
 
+	if nx && xx {
+		return command.NewErrorReplyStr("ERR XX and NX options at the same time are not compatible"), nil
+	}
+	if (gt && lt) || (nx && (gt || lt)) {
+		return command.NewErrorReplyStr("ERR GT, LT, and/or NX options at the same time are not compatible"), nil
+	}
 	if incr && len(args)-idx != 2 {
 		return nil, errors.New("INCR option requires exactly one score-member pair")
 	}
@@ -314,6 +328,9 @@ OptionsLoop:
 	if !ok {
 		if xx {
 			// XX means only update existing elements
+			if incr {
+				return command.NewNilReply(), nil
+			}
 			return command.NewIntegerReply(0), nil
 		}
 		obj = database.NewZSetObject()
@@ -336,25 +353,27 @@ OptionsLoop:
 
 	if incr {
 		// ZADD INCR score member
-		score, err := strconv.ParseFloat(args[idx], 64)
+		score, err := parseScore(args[idx])
 		if err != nil {
 			return nil, errors.New("value is not a valid float")
 		}
 		member := args[idx+1]
 
-		if nx {
-			if _, exists := zs.Score(member); exists {
-				return command.NewNilReply(), nil
+		newScore, applied, err := zs.IncrByIf(member, score, func(oldScore, newScore float64, exists bool) bool {
+			if (nx && exists) || (xx && !exists) {
+				return false
 			}
+			// GT and LT only let the increment move the score in one direction
+			return !exists || !(gt && newScore <= oldScore) && !(lt && newScore >= oldScore)
+		})
+		if err != nil {
+			return command.NewErrorReplyStr("ERR " + err.Error()), nil
 		}
-		if xx {
-			if _, exists := zs.Score(member); !exists {
-				return command.NewNilReply(), nil
-			}
+		if !applied {
+			return command.NewNilReply(), nil
 		}
-
-		newScore := zs.IncrBy(member, score)
-		return command.NewBulkStringReply(strconv.FormatFloat(newScore, 'f', -1, 64)), nil
+		obj.ConvertIfNeeded(member)
+		return command.NewDoubleReply(newScore), nil
 	}
 
 	// Parse score-member pairs
@@ -367,7 +386,7 @@ OptionsLoop:
 			return nil, errors.New("syntax error")
 		}
 
-		score, err := strconv.ParseFloat(args[i], 64)
+		score, err := parseScore(args[i])
 		if err != nil {
 			return nil, errors.New("value is not a valid float")
 		}
@@ -385,6 +404,9 @@ OptionsLoop:
 		}
 
 		oldScore, exists := zs.Score(member)
+		if exists && ((gt && score <= oldScore) || (lt && score >= oldScore)) {
+			continue
+		}
 		if !exists {
 			added++
 		} else if oldScore != score {
@@ -395,6 +417,11 @@ OptionsLoop:
 	}
 
 	zs.AddMultiple(members)
+	names := make([]string, len(members))
+	for i, m := range members {
+		names[i] = m.Member
+	}
+	obj.ConvertIfNeeded(names...)
 
 	if ch {
 		return command.NewIntegerReply(int64(added + changed)), nil
@@ -464,7 +491,7 @@ func zscoreCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewNilReply(), nil
 	}
 
-	return command.NewBulkStringReply(strconv.FormatFloat(score, 'f', -1, 64)), nil
+	return command.NewBulkStringReply(formatScore(score)), nil
 }
 
 // ZMSCORE key member [member ...]
@@ -507,7 +534,7 @@ func zincrbyCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	key := ctx.Args[0]
-	increment, err := strconv.ParseFloat(ctx.Args[1], 64)
+	increment, err := parseScore(ctx.Args[1])
 	if err != nil {
 		return nil, errors.New("value is not a valid float")
 	}
@@ -535,8 +562,12 @@ func zincrbyCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 	}
 
-	newScore := zs.IncrBy(member, increment)
-	return command.NewBulkStringReply(strconv.FormatFloat(newScore, 'f', -1, 64)), nil
+	newScore, err := zs.IncrBy(member, increment)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR " + err.Error()), nil
+	}
+	obj.ConvertIfNeeded(member)
+	return command.NewBulkStringReply(formatScore(newScore)), nil
 }
 
 // ZCARD key
@@ -940,7 +971,7 @@ func zpopmaxCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 		result := []string{
 			member.Member,
-			strconv.FormatFloat(member.Score, 'f', -1, 64),
+			formatScore(member.Score),
 		}
 		return command.NewStringArrayReply(result), nil
 	}
@@ -957,7 +988,7 @@ func zpopmaxCmd(ctx *command.Context) (*command.Reply, error) {
 
 	result := []string{}
 	for _, m := range members {
-		result = append(result, m.Member, strconv.FormatFloat(m.Score, 'f', -1, 64))
+		result = append(result, m.Member, formatScore(m.Score))
 	}
 
 	return command.NewStringArrayReply(result), nil
@@ -1008,7 +1039,7 @@ func zpopminCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 		result := []string{
 			member.Member,
-			strconv.FormatFloat(member.Score, 'f', -1, 64),
+			formatScore(member.Score),
 		}
 		return command.NewStringArrayReply(result), nil
 	}
@@ -1025,7 +1056,7 @@ func zpopminCmd(ctx *command.Context) (*command.Reply, error) {
 
 	result := []string{}
 	for _, m := range members {
-		result = append(result, m.Member, strconv.FormatFloat(m.Score, 'f', -1, 64))
+		result = append(result, m.Member, formatScore(m.Score))
 	}
 
 	return command.NewStringArrayReply(result), nil
@@ -1104,7 +1135,7 @@ func zremrangebyscoreCmd(ctx *command.Context) (*command.Reply, error) {
 	return command.NewIntegerReply(int64(removed)), nil
 }
 
-// ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+// ZUNION numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
 func zunionCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
 	if len(args) < 2 {
@@ -1127,6 +1158,7 @@ func zunionCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	aggregate := "sum"
+	withScores := false
 	idx := 1 + numKeys
 
 	// Parse options
@@ -1137,9 +1169,9 @@ func zunionCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("syntax error")
 			}
 			for i := 0; i < numKeys; i++ {
-				w, err := strconv.ParseFloat(args[idx+1+i], 64)
+				w, err := parseScore(args[idx+1+i])
 				if err != nil {
-					return nil, errors.New("value is not a valid float")
+					return nil, err
 				}
 				weights[i] = w
 			}
@@ -1150,13 +1182,17 @@ func zunionCmd(ctx *command.Context) (*command.Reply, error) {
 			}
 			aggregate = strings.ToLower(args[idx+1])
 			idx += 2
+		case "WITHSCORES":
+			withScores = true
+			idx++
 		default:
 			return nil, errors.New("syntax error")
 		}
 	}
 
-	// Collect zsets
+	// Collect zsets and the weights of the ones that exist
 	sets := []*zset.ZSet{}
+	setWeights := []float64{}
 	for i, key := range keys {
 		obj, ok := ctx.DB.Get(key)
 		if !ok {
@@ -1172,23 +1208,8 @@ func zunionCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("internal error: not a zset object")
 		}
 
-		// Apply weights if needed
-		if weights[i] != 1.0 {
-			// Create a copy with weighted scores
-			members := zs.GetAll()
-			weightedMembers := make([]zset.ZMember, len(members))
-			for j, m := range members {
-				weightedMembers[j] = zset.ZMember{
-					Member: m.Member,
-					Score:  m.Score * weights[i],
-				}
-			}
-			newZs := zset.NewZSet()
-			newZs.AddMultiple(weightedMembers)
-			sets = append(sets, newZs)
-		} else {
-			sets = append(sets, zs)
-		}
+		sets = append(sets, zs)
+		setWeights = append(setWeights, weights[i])
 	}
 
 	if len(sets) == 0 {
@@ -1196,11 +1217,11 @@ func zunionCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	// Compute union
-	result := sets[0].Union(sets[1:], aggregate)
-	return formatZMembers(result, true), nil
+	result := sets[0].Union(sets[1:], setWeights, aggregate)
+	return formatZMembers(result, withScores), nil
 }
 
-// ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
+// ZINTER numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX] [WITHSCORES]
 func zinterCmd(ctx *command.Context) (*command.Reply, error) {
 	args := ctx.Args
 	if len(args) < 2 {
@@ -1223,6 +1244,7 @@ func zinterCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	aggregate := "sum"
+	withScores := false
 	idx := 1 + numKeys
 
 	// Parse options
@@ -1233,9 +1255,9 @@ func zinterCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("syntax error")
 			}
 			for i := 0; i < numKeys; i++ {
-				w, err := strconv.ParseFloat(args[idx+1+i], 64)
+				w, err := parseScore(args[idx+1+i])
 				if err != nil {
-					return nil, errors.New("value is not a valid float")
+					return nil, err
 				}
 				weights[i] = w
 			}
@@ -1246,13 +1268,17 @@ func zinterCmd(ctx *command.Context) (*command.Reply, error) {
 			}
 			aggregate = strings.ToLower(args[idx+1])
 			idx += 2
+		case "WITHSCORES":
+			withScores = true
+			idx++
 		default:
 			return nil, errors.New("syntax error")
 		}
 	}
 
-	// Collect zsets
+	// Collect zsets and the weights of the ones that exist
 	sets := []*zset.ZSet{}
+	setWeights := []float64{}
 	for i, key := range keys {
 		obj, ok := ctx.DB.Get(key)
 		if !ok {
@@ -1269,23 +1295,8 @@ func zinterCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("internal error: not a zset object")
 		}
 
-		// Apply weights if needed
-		if weights[i] != 1.0 {
-			// Create a copy with weighted scores
-			members := zs.GetAll()
-			weightedMembers := make([]zset.ZMember, len(members))
-			for j, m := range members {
-				weightedMembers[j] = zset.ZMember{
-					Member: m.Member,
-					Score:  m.Score * weights[i],
-				}
-			}
-			newZs := zset.NewZSet()
-			newZs.AddMultiple(weightedMembers)
-			sets = append(sets, newZs)
-		} else {
-			sets = append(sets, zs)
-		}
+		sets = append(sets, zs)
+		setWeights = append(setWeights, weights[i])
 	}
 
 	if len(sets) == 0 {
@@ -1293,8 +1304,8 @@ func zinterCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	// Compute intersection
-	result := sets[0].Intersect(sets[1:], aggregate)
-	return formatZMembers(result, true), nil
+	result := sets[0].Intersect(sets[1:], setWeights, aggregate)
+	return formatZMembers(result, withScores), nil
 }
 
 // ZUNIONSTORE destination numkeys key [key ...] [WEIGHTS weight [weight ...]] [AGGREGATE SUM|MIN|MAX]
@@ -1331,9 +1342,9 @@ func zunionstoreCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("syntax error")
 			}
 			for i := 0; i < numKeys; i++ {
-				w, err := strconv.ParseFloat(args[idx+1+i], 64)
+				w, err := parseScore(args[idx+1+i])
 				if err != nil {
-					return nil, errors.New("value is not a valid float")
+					return nil, err
 				}
 				weights[i] = w
 			}
@@ -1349,8 +1360,9 @@ func zunionstoreCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 	}
 
-	// Collect zsets
+	// Collect zsets and the weights of the ones that exist
 	sets := []*zset.ZSet{}
+	setWeights := []float64{}
 	for i, key := range keys {
 		obj, ok := ctx.DB.Get(key)
 		if !ok {
@@ -1366,23 +1378,8 @@ func zunionstoreCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("internal error: not a zset object")
 		}
 
-		// Apply weights if needed
-		if weights[i] != 1.0 {
-			// Create a copy with weighted scores
-			members := zs.GetAll()
-			weightedMembers := make([]zset.ZMember, len(members))
-			for j, m := range members {
-				weightedMembers[j] = zset.ZMember{
-					Member: m.Member,
-					Score:  m.Score * weights[i],
-				}
-			}
-			newZs := zset.NewZSet()
-			newZs.AddMultiple(weightedMembers)
-			sets = append(sets, newZs)
-		} else {
-			sets = append(sets, zs)
-		}
+		sets = append(sets, zs)
+		setWeights = append(setWeights, weights[i])
 	}
 
 	// Compute union
@@ -1390,7 +1387,7 @@ func zunionstoreCmd(ctx *command.Context) (*command.Reply, error) {
 	if len(sets) == 0 {
 		result = []zset.ZMember{}
 	} else {
-		result = sets[0].Union(sets[1:], aggregate)
+		result = sets[0].Union(sets[1:], setWeights, aggregate)
 	}
 
 	// Create new zset with result
@@ -1400,6 +1397,7 @@ func zunionstoreCmd(ctx *command.Context) (*command.Reply, error) {
 	// Store result
 	obj := database.NewZSetObject()
 	obj.Ptr = newZs
+	obj.FitEncoding()
 	ctx.DB.Set(dstKey, obj)
 
 	return command.NewIntegerReply(int64(len(result))), nil
@@ -1439,9 +1437,9 @@ func zinterstoreCmd(ctx *command.Context) (*command.Reply, error) {
 				return nil, errors.New("syntax error")
 			}
 			for i := 0; i < numKeys; i++ {
-				w, err := strconv.ParseFloat(args[idx+1+i], 64)
+				w, err := parseScore(args[idx+1+i])
 				if err != nil {
-					return nil, errors.New("value is not a valid float")
+					return nil, err
 				}
 				weights[i] = w
 			}
@@ -1457,8 +1455,9 @@ func zinterstoreCmd(ctx *command.Context) (*command.Reply, error) {
 		}
 	}
 
-	// Collect zsets
+	// Collect zsets and the weights of the ones that exist
 	sets := []*zset.ZSet{}
+	setWeights := []float64{}
 	for i, key := range keys {
 		obj, ok := ctx.DB.Get(key)
 		if !ok {
@@ -1476,23 +1475,8 @@ func zinterstoreCmd(ctx *command.Context) (*command.Reply, error) {
 			return nil, errors.New("internal error: not a zset object")
 		}
 
-		// Apply weights if needed
-		if weights[i] != 1.0 {
-			// Create a copy with weighted scores
-			members := zs.GetAll()
-			weightedMembers := make([]zset.ZMember, len(members))
-			for j, m := range members {
-				weightedMembers[j] = zset.ZMember{
-					Member: m.Member,
-					Score:  m.Score * weights[i],
-				}
-			}
-			newZs := zset.NewZSet()
-			newZs.AddMultiple(weightedMembers)
-			sets = append(sets, newZs)
-		} else {
-			sets = append(sets, zs)
-		}
+		sets = append(sets, zs)
+		setWeights = append(setWeights, weights[i])
 	}
 
 	if len(sets) == 0 {
@@ -1501,7 +1485,7 @@ func zinterstoreCmd(ctx *command.Context) (*command.Reply, error) {
 	}
 
 	// Compute intersection
-	result := sets[0].Intersect(sets[1:], aggregate)
+	result := sets[0].Intersect(sets[1:], setWeights, aggregate)
 
 	// Create new zset with result
 	newZs := zset.NewZSet()
@@ -1510,6 +1494,7 @@ func zinterstoreCmd(ctx *command.Context) (*command.Reply, error) {
 	// Store result
 	obj := database.NewZSetObject()
 	obj.Ptr = newZs
+	obj.FitEncoding()
 	ctx.DB.Set(dstKey, obj)
 
 	return command.NewIntegerReply(int64(len(result))), nil
@@ -1639,6 +1624,7 @@ func zdiffstoreCmd(ctx *command.Context) (*command.Reply, error) {
 	// Store result
 	obj2 := database.NewZSetObject()
 	obj2.Ptr = newZs
+	obj2.FitEncoding()
 	ctx.DB.Set(dstKey, obj2)
 
 	return command.NewIntegerReply(int64(len(result))), nil
@@ -1704,7 +1690,7 @@ func zscanCmd(ctx *command.Context) (*command.Reply, error) {
 	// Build result: [cursor, member1, score1, member2, score2, ...]
 	result := []string{strconv.Itoa(newCursor)}
 	for _, m := range members {
-		result = append(result, m.Member, strconv.FormatFloat(m.Score, 'f', -1, 64))
+		result = append(result, m.Member, formatScore(m.Score))
 	}
 
 	return command.NewStringArrayReply(result), nil
@@ -1776,7 +1762,7 @@ func zrandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 			idx := i % len(members)
 			result = append(result, members[idx].Member)
 			if withScores {
-				result = append(result, strconv.FormatFloat(members[idx].Score, 'f', -1, 64))
+				result = append(result, formatScore(members[idx].Score))
 			}
 		}
 		return command.NewStringArrayReply(result), nil
@@ -1790,7 +1776,7 @@ func zrandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 	for i := 0; i < count; i++ {
 		result = append(result, members[i].Member)
 		if withScores {
-			result = append(result, strconv.FormatFloat(members[i].Score, 'f', -1, 64))
+			result = append(result, formatScore(members[i].Score))
 		}
 	}
 
@@ -1799,6 +1785,28 @@ func zrandmemberCmd(ctx *command.Context) (*command.Reply, error) {
 
 // Helper functions
 
+// formatScore formats a score for a reply, writing infinities as Redis
+// does
+func formatScore(score float64) string {
+	switch {
+	case math.IsInf(score, 1):
+		return "inf"
+	case math.IsInf(score, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(score, 'f', -1, 64)
+}
+
+// parseScore parses a score or increment, which may be +inf or -inf but
+// not NaN
+func parseScore(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.IsNaN(f) {
+		return 0, errors.New("value is not a valid float")
+	}
+	return f, nil
+}
+
 func parseScoreRange(minStr, maxStr string) (min float64, max float64) {
 	min = parseScoreBound(minStr)
 	max = parseScoreBound(maxStr)
@@ -1840,7 +1848,7 @@ func formatZMembers(members []zset.ZMember, withScores bool) *command.Reply {
 
 	result := make([]string, 0, len(members)*2)
 	for _, m := range members {
-		result = append(result, m.Member, strconv.FormatFloat(m.Score, 'f', -1, 64))
+		result = append(result, m.Member, formatScore(m.Score))
 	}
 	return command.NewStringArrayReply(result)
 }
@@ -0,0 +1,219 @@
+package commands
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/zset"
+)
+
+// newZSetDB returns a DB holding "myzset" with member "a" at score 5
+func newZSetDB() *database.DB {
+	db := database.NewDB(0)
+	obj := database.NewZSetObject()
+	obj.Ptr.(*zset.ZSet).Add("a", 5)
+	db.Set("myzset", obj)
+	return db
+}
+
+func TestZaddIncrBlocked(t *testing.T) {
+	tests := []struct {
+		name string
+		args []string
+	}{
+		{"NX on existing member", []string{"myzset", "NX", "INCR", "1", "a"}},
+		{"XX on missing member", []string{"myzset", "XX", "INCR", "1", "b"}},
+		{"XX on missing key", []string{"nokey", "XX", "INCR", "1", "a"}},
+		{"GT with negative increment", []string{"myzset", "GT", "INCR", "-1", "a"}},
+		{"LT with positive increment", []string{"myzset", "LT", "INCR", "1", "a"}},
+		{"GT with zero increment", []string{"myzset", "GT", "INCR", "0", "a"}},
+	}
+
+	for _, tt := range tests {
+		for _, proto := range []struct {
+			version int
+			want    string
+		}{{2, "$-1\r\n"}, {3, "_\r\n"}} {
+			db := newZSetDB()
+			ctx := newTestContext(t, db, tt.args...)
+			ctx.Conn.SetProtocol(proto.version)
+
+			reply, err := zaddCmd(ctx)
+			if err != nil {
+				t.Fatalf("ZADD %s failed: %v", tt.name, err)
+			}
+			if got := string(reply.MarshalProto(ctx.Conn.GetProtocol())); got != proto.want {
+				t.Errorf("ZADD %s under RESP%d expected %q, got %q", tt.name, proto.version, proto.want, got)
+			}
+
+			obj, _ := db.Get("myzset")
+			if score, _ := obj.Ptr.(*zset.ZSet).Score("a"); score != 5 {
+				t.Errorf("ZADD %s changed the score to %v", tt.name, score)
+			}
+			if db.Exists("nokey") != 0 {
+				t.Errorf("ZADD %s created the key", tt.name)
+			}
+		}
+	}
+}
+
+func TestZaddIncrSuccess(t *testing.T) {
+	tests := []struct {
+		args  []string
+		resp2 string
+		resp3 string
+	}{
+		{[]string{"myzset", "INCR", "2.5", "a"}, "$3\r\n7.5\r\n", ",7.5\r\n"},
+		{[]string{"myzset", "GT", "INCR", "1", "a"}, "$1\r\n6\r\n", ",6\r\n"},
+		{[]string{"myzset", "LT", "INCR", "-1", "a"}, "$1\r\n4\r\n", ",4\r\n"},
+		{[]string{"myzset", "NX", "INCR", "3", "b"}, "$1\r\n3\r\n", ",3\r\n"},
+		{[]string{"myzset", "XX", "INCR", "-5", "a"}, "$1\r\n0\r\n", ",0\r\n"},
+		{[]string{"myzset", "INCR", "+inf", "a"}, "$3\r\ninf\r\n", ",inf\r\n"},
+	}
+
+	for _, tt := range tests {
+		for _, proto := range []struct {
+			version int
+			want    string
+		}{{2, tt.resp2}, {3, tt.resp3}} {
+			ctx := newTestContext(t, newZSetDB(), tt.args...)
+			ctx.Conn.SetProtocol(proto.version)
+
+			reply, err := zaddCmd(ctx)
+			if err != nil {
+				t.Fatalf("ZADD %v failed: %v", tt.args, err)
+			}
+			if got := string(reply.MarshalProto(ctx.Conn.GetProtocol())); got != proto.want {
+				t.Errorf("ZADD %v under RESP%d expected %q, got %q", tt.args, proto.version, proto.want, got)
+			}
+		}
+	}
+}
+
+func TestZaddIncompatibleOptions(t *testing.T) {
+	for _, opts := range [][]string{{"NX", "XX"}, {"GT", "LT"}, {"NX", "GT"}, {"NX", "LT"}} {
+		args := append([]string{"myzset"}, opts...)
+		args = append(args, "1", "a")
+		reply, _ := zaddCmd(newTestContext(t, newZSetDB(), args...))
+		if !reply.IsError() {
+			t.Errorf("ZADD %v expected an error, got %v", opts, reply.Value)
+		}
+	}
+}
+
+func TestZsetInfinityArithmetic(t *testing.T) {
+	db := database.NewDB(0)
+	zaddCmd(newTestContext(t, db, "pos", "+inf", "a"))
+	zaddCmd(newTestContext(t, db, "neg", "-inf", "a"))
+
+	const nanErr = "ERR resulting score is not a number (NaN)"
+	for _, args := range [][]string{
+		{"pos", "INCR", "-inf", "a"},
+		{"pos", "GT", "INCR", "-inf", "a"},
+	} {
+		reply, err := zaddCmd(newTestContext(t, db, args...))
+		if err != nil || !reply.IsError() || reply.Value != nanErr {
+			t.Errorf("ZADD %v expected %q, got %v, %v", args, nanErr, reply, err)
+		}
+	}
+	reply, _ := zincrbyCmd(newTestContext(t, db, "neg", "+inf", "a"))
+	if !reply.IsError() || reply.Value != nanErr {
+		t.Errorf("ZINCRBY -inf by +inf expected %q, got %v", nanErr, reply.Value)
+	}
+	if _, err := zaddCmd(newTestContext(t, db, "pos", "nan", "b")); err == nil {
+		t.Error("ZADD with a nan score expected error")
+	}
+
+	// The members keep their scores
+	if reply, _ := zscoreCmd(newTestContext(t, db, "pos", "a")); reply.Value != "inf" {
+		t.Errorf("pos a expected inf, got %v", reply.Value)
+	}
+	if reply, _ := zscoreCmd(newTestContext(t, db, "neg", "a")); reply.Value != "-inf" {
+		t.Errorf("neg a expected -inf, got %v", reply.Value)
+	}
+
+	// The SUM of +inf and -inf is 0, and so is an infinite score weighted by 0
+	for _, args := range [][]string{
+		{"dst", "2", "pos", "neg"},
+		{"dst", "2", "pos", "neg", "WEIGHTS", "0", "1", "AGGREGATE", "MAX"},
+	} {
+		if _, err := zunionstoreCmd(newTestContext(t, db, args...)); err != nil {
+			t.Fatalf("ZUNIONSTORE %v failed: %v", args, err)
+		}
+		if reply, _ := zscoreCmd(newTestContext(t, db, "dst", "a")); reply.Value != "0" {
+			t.Errorf("ZUNIONSTORE %v expected score 0, got %v", args, reply.Value)
+		}
+	}
+	if _, err := zinterstoreCmd(newTestContext(t, db, "dst", "2", "pos", "neg")); err != nil {
+		t.Fatalf("ZINTERSTORE failed: %v", err)
+	}
+	if reply, _ := zscoreCmd(newTestContext(t, db, "dst", "a")); reply.Value != "0" {
+		t.Errorf("ZINTERSTORE expected score 0, got %v", reply.Value)
+	}
+}
+
+func TestZunionZinterWeights(t *testing.T) {
+	db := database.NewDB(0)
+	zaddCmd(newTestContext(t, db, "z1", "1", "a", "2", "b", "3", "c"))
+	zaddCmd(newTestContext(t, db, "z2", "3", "a", "1", "b", "+inf", "c"))
+
+	tests := []struct {
+		name string
+		cmd  func(*command.Context) (*command.Reply, error)
+		args []string
+		want []string
+	}{
+		{"union", zunionCmd, []string{"2", "z1", "z2", "WITHSCORES"},
+			[]string{"b", "3", "a", "4", "c", "inf"}},
+		{"union without scores", zunionCmd, []string{"2", "z1", "z2"},
+			[]string{"b", "a", "c"}},
+		// Weight 0 turns every score of z2, +inf included, into 0
+		{"union weight 0", zunionCmd, []string{"2", "z1", "z2", "WEIGHTS", "1", "0", "WITHSCORES"},
+			[]string{"a", "1", "b", "2", "c", "3"}},
+		{"union negative weight", zunionCmd, []string{"2", "z1", "z2", "WEIGHTS", "-1", "1", "WITHSCORES"},
+			[]string{"b", "-1", "a", "2", "c", "inf"}},
+		{"union missing key", zunionCmd, []string{"2", "nokey", "z1", "WEIGHTS", "5", "-2", "WITHSCORES"},
+			[]string{"c", "-6", "b", "-4", "a", "-2"}},
+		// Tied scores are ordered by member
+		{"union ties", zunionCmd, []string{"2", "z1", "z2", "WEIGHTS", "0", "0", "WITHSCORES"},
+			[]string{"a", "0", "b", "0", "c", "0"}},
+		{"inter weight 0", zinterCmd, []string{"2", "z2", "z1", "WEIGHTS", "0", "1", "AGGREGATE", "MAX", "WITHSCORES"},
+			[]string{"a", "1", "b", "2", "c", "3"}},
+		{"inter negative weight", zinterCmd, []string{"2", "z1", "z2", "WEIGHTS", "-2", "-1", "AGGREGATE", "MIN", "WITHSCORES"},
+			[]string{"c", "-inf", "b", "-4", "a", "-3"}},
+		{"inter ties", zinterCmd, []string{"2", "z1", "z2", "WEIGHTS", "1", "0", "AGGREGATE", "MIN"},
+			[]string{"a", "b", "c"}},
+	}
+	for _, tt := range tests {
+		reply, err := tt.cmd(newTestContext(t, db, tt.args...))
+		if err != nil {
+			t.Fatalf("%s failed: %v", tt.name, err)
+		}
+		if got, _ := reply.Value.([]string); !slices.Equal(got, tt.want) {
+			t.Errorf("%s expected %v, got %v", tt.name, tt.want, reply.Value)
+		}
+	}
+
+	// The sources keep their scores
+	if reply, _ := zscoreCmd(newTestContext(t, db, "z2", "c")); reply.Value != "inf" {
+		t.Errorf("z2 c expected inf, got %v", reply.Value)
+	}
+	if _, err := zunionCmd(newTestContext(t, db, "2", "z1", "z2", "WEIGHTS", "1", "nan")); err == nil {
+		t.Error("ZUNION with a nan weight expected error")
+	}
+}
+
+func TestZaddGTIncrKeepsHigherScore(t *testing.T) {
+	db := database.NewDB(0)
+	zaddCmd(newTestContext(t, db, "k", "10", "member"))
+
+	reply, err := zaddCmd(newTestContext(t, db, "k", "GT", "INCR", "-3", "member"))
+	if err != nil || reply.Type != command.ReplyTypeNil {
+		t.Fatalf("ZADD GT INCR -3 expected nil, got %v, %v", reply, err)
+	}
+	if reply, _ := zscoreCmd(newTestContext(t, db, "k", "member")); reply.Value != "10" {
+		t.Errorf("score expected to stay 10, got %v", reply.Value)
+	}
+}
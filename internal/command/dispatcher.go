@@ -6,11 +6,15 @@ package command
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/zyhnesmr/godis/internal/acl"
 	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/latency"
 	"github.com/zyhnesmr/godis/internal/net"
 	"github.com/zyhnesmr/godis/internal/protocol/resp"
 	"github.com/zyhnesmr/godis/internal/transaction"
@@ -21,6 +25,11 @@ type AOFLogger interface {
 	LogCommand(db int, cmdName string, args []string) error
 }
 
+// ChangeCounter counts the changes made to the dataset since the last save
+type ChangeCounter interface {
+	AddChanges(n int64)
+}
+
 // Dispatcher dispatches commands to their handlers
 type Dispatcher struct {
 	commands  map[string]*Command
@@ -28,6 +37,18 @@ type Dispatcher struct {
 	db        *database.DBSelector
 	txManager *transaction.Manager
 	aofLogger AOFLogger
+	replFeed  AOFLogger     // Write commands sent to replicas
+	changes   ChangeCounter // Changes since the last save, for the save rules
+	users     *acl.Users    // ACL users, nil to allow every command
+	stats     *CommandStats
+	slowLog   *SlowLog
+
+	// writeGuard, if set, refuses write commands by returning an error
+	writeGuard func() error
+
+	// writes is held shared by write commands from the moment they run
+	// until they are logged, and exclusively by PauseWrites
+	writes sync.RWMutex
 }
 
 // NewDispatcher creates a new command dispatcher
@@ -36,6 +57,8 @@ func NewDispatcher(db *database.DBSelector) *Dispatcher {
 		commands:  make(map[string]*Command),
 		db:        db,
 		txManager: transaction.NewManager(),
+		stats:     NewCommandStats(),
+		slowLog:   NewSlowLog(10000, 128),
 	}
 }
 
@@ -46,11 +69,165 @@ func (d *Dispatcher) SetAOFLogger(logger AOFLogger) {
 	d.aofLogger = logger
 }
 
+// SetReplicationFeed sets where write commands are sent for replicas, in
+// the same form as they are logged to the AOF
+func (d *Dispatcher) SetReplicationFeed(feed AOFLogger) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.replFeed = feed
+}
+
+// SetChangeCounter sets the counter of changes since the last save, fed
+// with each write command that changed something
+func (d *Dispatcher) SetChangeCounter(counter ChangeCounter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.changes = counter
+}
+
+// SetACL sets the users whose permissions are checked before every
+// command
+func (d *Dispatcher) SetACL(users *acl.Users) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.users = users
+}
+
+// checkACL refuses a command to a connection that must authenticate first,
+// or whose user is not allowed the command's categories. Commands flagged
+// no_auth, like AUTH itself, are always allowed.
+func (d *Dispatcher) checkACL(conn *net.Conn, cmd *Command) error {
+	d.mu.RLock()
+	users := d.users
+	d.mu.RUnlock()
+
+	if users == nil || cmd.HasFlag(FlagNoAuth) {
+		return nil
+	}
+
+	name := conn.GetUser()
+	if name == "" {
+		if users.AuthRequired() {
+			return errors.New("NOAUTH Authentication required.")
+		}
+		name = acl.DefaultUser
+	}
+	if user, ok := users.Get(name); !ok || !user.Allows(cmd.Categories) {
+		return fmt.Errorf("NOPERM User %s has no permissions to run the '%s' command", name, strings.ToLower(cmd.Name))
+	}
+	return nil
+}
+
+// SetWriteGuard sets a check run before every write command. Writes are
+// refused with its error while it returns one.
+func (d *Dispatcher) SetWriteGuard(guard func() error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.writeGuard = guard
+}
+
+// checkWrite returns the write guard's error for a write command
+func (d *Dispatcher) checkWrite(cmd *Command) error {
+	if !cmd.HasFlag(FlagWrite) {
+		return nil
+	}
+
+	d.mu.RLock()
+	guard := d.writeGuard
+	d.mu.RUnlock()
+
+	if guard == nil {
+		return nil
+	}
+	return guard()
+}
+
+// subscribeModeCommands are the commands a RESP2 connection may run while
+// subscribed to channels or patterns
+var subscribeModeCommands = map[string]bool{
+	"subscribe":    true,
+	"unsubscribe":  true,
+	"psubscribe":   true,
+	"punsubscribe": true,
+	"ssubscribe":   true,
+	"sunsubscribe": true,
+	"ping":         true,
+	"quit":         true,
+	"reset":        true,
+}
+
+// checkSubscribeMode refuses commands that a subscribed RESP2 connection
+// could not tell apart from published messages. RESP3 connections receive
+// messages as push replies and may run any command.
+func checkSubscribeMode(conn *net.Conn, cmd *Command) error {
+	if conn.GetProtocol() >= 3 || !conn.IsInPubSub() {
+		return nil
+	}
+	name := strings.ToLower(cmd.Name)
+	if subscribeModeCommands[name] {
+		return nil
+	}
+	return fmt.Errorf("ERR Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context", name)
+}
+
+// noTouchCommands inspect keys without counting as an access to them
+var noTouchCommands = map[string]bool{
+	"object": true,
+	"type":   true,
+	"ttl":    true,
+	"pttl":   true,
+	"exists": true,
+}
+
+// touchKeys records an access to the keys of a command for the eviction
+// policy, unless the connection asked not to with CLIENT NO-TOUCH
+func (d *Dispatcher) touchKeys(conn *net.Conn, db *database.DB, cmd *Command, args []string) {
+	if cmd.FirstKey <= 0 || conn.HasFlag(net.FlagNoTouch) || noTouchCommands[strings.ToLower(cmd.Name)] {
+		return
+	}
+
+	keys := cmd.GetKeys(append([]string{cmd.Name}, args...))
+	db.TouchKeys(d.db.GetEvictionPolicy().IsLFU(), keys...)
+}
+
 // GetTxManager returns the transaction manager
 func (d *Dispatcher) GetTxManager() *transaction.Manager {
 	return d.txManager
 }
 
+// Stats returns the per-command call statistics
+func (d *Dispatcher) Stats() *CommandStats {
+	return d.stats
+}
+
+// SlowLog returns the log of slow commands
+func (d *Dispatcher) SlowLog() *SlowLog {
+	return d.slowLog
+}
+
+// record records a call of cmd in the command statistics and, if it was
+// slow enough, the slow log and the latency monitor
+func (d *Dispatcher) record(conn *net.Conn, cmd *Command, args []string, elapsed time.Duration) {
+	d.stats.Record(cmd.Name, elapsed)
+	if cmd.HasFlag(FlagFast) {
+		latency.Instance().Add(latency.EventFastCommand, elapsed)
+	} else {
+		latency.Instance().Add(latency.EventCommand, elapsed)
+	}
+	if cmd.HasFlag(FlagSkipSlowlog) {
+		return
+	}
+
+	var addr, name string
+	if conn != nil {
+		if conn.RemoteAddr() != nil {
+			addr = conn.RemoteAddr().String()
+		}
+		name = conn.GetName()
+	}
+	d.slowLog.Record(append([]string{strings.ToLower(cmd.Name)}, args...), elapsed, addr, name)
+}
+
 // Register registers a new command
 func (d *Dispatcher) Register(cmd *Command) {
 	d.mu.Lock()
@@ -70,14 +247,32 @@ func (d *Dispatcher) Get(name string) (*Command, bool) {
 
 // Dispatch dispatches a command to its handler
 func (d *Dispatcher) Dispatch(ctx context.Context, conn *net.Conn, cmdName string, args []string) ([]byte, error) {
-	// Find command
+	// Find command. Inside MULTI, rejected commands make EXEC fail.
 	cmd, ok := d.Get(cmdName)
 	if !ok {
+		d.txManager.MarkQueueError(conn)
 		return resp.BuildErrorString(fmt.Sprintf("ERR unknown command '%s'", cmdName)), nil
 	}
 
+	// Check the connection may run the command
+	if err := d.checkACL(conn, cmd); err != nil {
+		d.txManager.MarkQueueError(conn)
+		return resp.BuildErrorString(err.Error()), nil
+	}
+
 	// Check arity
 	if err := cmd.CheckArity(len(args)); err != nil {
+		d.txManager.MarkQueueError(conn)
+		return resp.BuildErrorString(err.Error()), nil
+	}
+
+	// Check if writes are currently refused
+	if err := d.checkWrite(cmd); err != nil {
+		d.txManager.MarkQueueError(conn)
+		return resp.BuildErrorString(err.Error()), nil
+	}
+
+	if err := checkSubscribeMode(conn, cmd); err != nil {
 		return resp.BuildErrorString(err.Error()), nil
 	}
 
@@ -101,10 +296,50 @@ func (d *Dispatcher) Dispatch(ctx context.Context, conn *net.Conn, cmdName strin
 
 // dispatchCommand executes a command immediately
 func (d *Dispatcher) dispatchCommand(ctx context.Context, conn *net.Conn, cmd *Command, args []string) ([]byte, error) {
-	// Get database for this connection
+	reply, err := d.execute(conn, cmd, args)
+	if err != nil {
+		return resp.BuildErrorString(err.Error()), nil
+	}
+	return reply.MarshalProto(conn.GetProtocol()), nil
+}
+
+// execute runs cmd and, if it succeeded, logs it to the AOF and sends it
+// to the replicas. A write command holds the write barrier until then.
+func (d *Dispatcher) execute(conn *net.Conn, cmd *Command, args []string) (*Reply, error) {
+	var barrier *sync.RWMutex
+	if cmd.HasFlag(FlagWrite) {
+		barrier = &d.writes
+		barrier.RLock()
+		defer barrier.RUnlock()
+	}
+
+	cmdCtx, reply, err := d.run(conn, cmd, args, barrier, false)
+	if err == nil && cmdCtx != nil && !reply.IsError() {
+		d.propagate(cmdCtx, cmd)
+	}
+	return reply, err
+}
+
+// PauseWrites runs fn while no write command runs, for snapshots that must
+// match the commands logged to the AOF and sent to the replicas: each write
+// is either seen by fn or logged after fn returns. Write commands blocked
+// waiting for a key don't hold it up. fn must not be called from a write
+// command.
+func (d *Dispatcher) PauseWrites(fn func()) {
+	d.writes.Lock()
+	defer d.writes.Unlock()
+	fn()
+}
+
+// run executes cmd on the connection's database and records the call. It
+// returns the context the handler ran with, nil if the database index is
+// invalid. barrier is the write barrier the command holds, if any, which
+// the command releases while it blocks; inExec is set for the commands of
+// a transaction.
+func (d *Dispatcher) run(conn *net.Conn, cmd *Command, args []string, barrier *sync.RWMutex, inExec bool) (*Context, *Reply, error) {
 	db, err := d.db.GetDB(conn.GetDB())
 	if err != nil {
-		return resp.BuildErrorString("ERR invalid DB index"), nil
+		return nil, NewErrorReplyStr("ERR invalid DB index"), nil
 	}
 
 	// Create command context
@@ -113,22 +348,53 @@ func (d *Dispatcher) dispatchCommand(ctx context.Context, conn *net.Conn, cmd *C
 		Conn:    conn,
 		CmdName: cmd.Name,
 		Args:    args,
+		barrier: barrier,
+		inExec:  inExec,
 	}
 
-	// Execute command
+	d.touchKeys(conn, db, cmd, args)
+
+	// Execute command. Time spent blocked isn't time spent running.
+	start := time.Now()
 	reply, err := cmd.Handler(cmdCtx)
+	d.record(conn, cmd, args, time.Since(start)-cmdCtx.blocked)
+	return cmdCtx, reply, err
+}
+
+// Apply executes a write command received from the master on database db.
+// There is no client connection, but the command is logged to the AOF and
+// sent to the replicas of this server like a client's write.
+func (d *Dispatcher) Apply(db int, cmdName string, args []string) (*Reply, error) {
+	cmd, ok := d.Get(cmdName)
+	if !ok {
+		return nil, fmt.Errorf("unknown command '%s'", cmdName)
+	}
+	if err := cmd.CheckArity(len(args)); err != nil {
+		return nil, err
+	}
+	dbInst, err := d.db.GetDB(db)
 	if err != nil {
-		return resp.BuildErrorString(err.Error()), nil
+		return nil, err
 	}
 
-	// Log to AOF if command succeeded and is a write command
-	if !reply.IsError() && d.aofLogger != nil && cmd.HasFlag(FlagWrite) {
-		if !isReadOnlyCommand(cmd.Name) {
-			_ = d.aofLogger.LogCommand(conn.GetDB(), cmd.Name, args)
-		}
+	var barrier *sync.RWMutex
+	if cmd.HasFlag(FlagWrite) {
+		barrier = &d.writes
+		barrier.RLock()
+		defer barrier.RUnlock()
 	}
 
-	return reply.Marshal(), nil
+	cmdCtx := &Context{
+		DB:      dbInst,
+		CmdName: cmd.Name,
+		Args:    args,
+		barrier: barrier,
+	}
+	reply, err := cmd.Handler(cmdCtx)
+	if err == nil && !reply.IsError() {
+		d.logCommands(db, d.written(cmdCtx, cmd))
+	}
+	return reply, err
 }
 
 // DispatchCommand dispatches a single command (used by EXEC)
@@ -148,32 +414,111 @@ func (d *Dispatcher) DispatchCommand(ctx interface{}, conn *net.Conn, cmdName st
 
 // dispatchCommandReply executes a command and returns a Reply
 func (d *Dispatcher) dispatchCommandReply(ctx context.Context, conn *net.Conn, cmd *Command, args []string) (*Reply, error) {
-	// Get database for this connection
-	db, err := d.db.GetDB(conn.GetDB())
-	if err != nil {
-		return NewErrorReplyStr("ERR invalid DB index"), nil
+	return d.execute(conn, cmd, args)
+}
+
+// ExecTransaction runs the commands a connection queued after MULTI and
+// returns their replies. Runtime errors are returned in place and do not
+// stop the transaction. The writes are logged to the AOF and sent to the
+// replicas between MULTI and EXEC, so that they are replayed together.
+// The whole transaction holds the write barrier, and its commands don't
+// block.
+func (d *Dispatcher) ExecTransaction(conn *net.Conn, queued []*transaction.QueuedCommand) []*Reply {
+	d.writes.RLock()
+	defer d.writes.RUnlock()
+
+	replies := make([]*Reply, 0, len(queued))
+	wrote := false
+	for _, queuedCmd := range queued {
+		cmd, ok := d.Get(queuedCmd.CmdName)
+		if !ok {
+			replies = append(replies, NewErrorReplyStr(fmt.Sprintf("ERR unknown command '%s'", queuedCmd.CmdName)))
+			continue
+		}
+		if err := cmd.CheckArity(len(queuedCmd.Args)); err != nil {
+			replies = append(replies, NewErrorReply(err))
+			continue
+		}
+
+		cmdCtx, reply, err := d.run(conn, cmd, queuedCmd.Args, nil, true)
+		if err != nil {
+			replies = append(replies, NewErrorReply(err))
+			continue
+		}
+		replies = append(replies, reply)
+		if cmdCtx == nil || reply.IsError() {
+			continue
+		}
+
+		// MULTI goes out with the first write, so a transaction that
+		// only reads logs nothing
+		if writes := d.written(cmdCtx, cmd); len(writes) > 0 {
+			if !wrote {
+				d.logCommands(conn.GetDB(), [][]string{{"MULTI"}})
+				wrote = true
+			}
+			d.logCommands(conn.GetDB(), writes)
+		}
 	}
+	if wrote {
+		d.logCommands(conn.GetDB(), [][]string{{"EXEC"}})
+	}
+	return replies
+}
 
-	// Create command context
-	cmdCtx := &Context{
-		DB:      db,
-		Conn:    conn,
-		CmdName: cmd.Name,
-		Args:    args,
+// propagate logs an executed write command to the AOF and sends it to the
+// replicas, in the form its handler chose to propagate
+func (d *Dispatcher) propagate(ctx *Context, cmd *Command) {
+	d.logCommands(ctx.Conn.GetDB(), d.written(ctx, cmd))
+}
+
+// written returns the commands to log for an executed command, none unless
+// it is a write that changed something, and flags its keys for the clients
+// watching them
+func (d *Dispatcher) written(ctx *Context, cmd *Command) [][]string {
+	// Skip commands that don't modify data
+	if !cmd.HasFlag(FlagWrite) || isReadOnlyCommand(cmd.Name) {
+		return nil
+	}
+	propagation := ctx.Propagation()
+	if len(propagation) == 0 {
+		return nil
 	}
 
-	// Execute command
-	reply, err := cmd.Handler(cmdCtx)
+	// Writes that change a value in place, like RPUSH or HSET, don't go
+	// through the database calls that dirty keys
+	for _, key := range cmd.GetKeys(append([]string{cmd.Name}, ctx.Args...)) {
+		d.txManager.MarkDirty(ctx.DB.GetID(), key)
+	}
 
-	// Log to AOF if command succeeded and is a write command
-	if err == nil && !reply.IsError() && d.aofLogger != nil && cmd.HasFlag(FlagWrite) {
-		// Skip commands that don't modify data
-		if !isReadOnlyCommand(cmd.Name) {
-			_ = d.aofLogger.LogCommand(conn.GetDB(), cmd.Name, args)
-		}
+	d.mu.RLock()
+	changes := d.changes
+	d.mu.RUnlock()
+	if changes != nil {
+		changes.AddChanges(1)
+	}
+	return propagation
+}
+
+// logCommands logs commands executed on database db to the AOF and sends
+// them to the replicas, each as its name followed by its arguments
+func (d *Dispatcher) logCommands(db int, commands [][]string) {
+	if len(commands) == 0 {
+		return
 	}
 
-	return reply, err
+	d.mu.RLock()
+	aofLogger, replFeed := d.aofLogger, d.replFeed
+	d.mu.RUnlock()
+
+	for _, argv := range commands {
+		if aofLogger != nil {
+			_ = aofLogger.LogCommand(db, argv[0], argv[1:])
+		}
+		if replFeed != nil {
+			_ = replFeed.LogCommand(db, argv[0], argv[1:])
+		}
+	}
 }
 
 // isReadOnlyCommand returns true if the command is read-only (even if marked as write)
@@ -0,0 +1,207 @@
+package command
+
+import (
+	"context"
+	gonet "net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/net"
+)
+
+func TestSubscribeModeRestrictsCommands(t *testing.T) {
+	disp := NewDispatcher(database.NewDBSelector(1))
+	for _, name := range []string{"GET", "PING", "UNSUBSCRIBE"} {
+		disp.Register(&Command{
+			Name:             name,
+			Handler:          func(ctx *Context) (*Reply, error) { return NewStatusReply("OK"), nil },
+			Arity:            -1,
+			OptionalFirstArg: true,
+		})
+	}
+
+	client, server := gonet.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := net.NewConn(server)
+	conn.Subscribe("news")
+
+	reply, _ := disp.Dispatch(context.Background(), conn, "get", []string{"key"})
+	want := "-ERR Can't execute 'get': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT / RESET are allowed in this context\r\n"
+	if string(reply) != want {
+		t.Errorf("GET while subscribed = %q, want %q", reply, want)
+	}
+
+	for _, name := range []string{"PING", "unsubscribe"} {
+		if reply, _ := disp.Dispatch(context.Background(), conn, name, nil); string(reply) != "+OK\r\n" {
+			t.Errorf("%s while subscribed = %q, want +OK", name, reply)
+		}
+	}
+
+	// RESP3 connections may run any command while subscribed
+	conn.SetProtocol(3)
+	if reply, _ := disp.Dispatch(context.Background(), conn, "GET", []string{"key"}); string(reply) != "+OK\r\n" {
+		t.Errorf("GET while subscribed under RESP3 = %q, want +OK", reply)
+	}
+
+	conn.SetProtocol(2)
+	conn.Unsubscribe("news")
+	if reply, _ := disp.Dispatch(context.Background(), conn, "GET", []string{"key"}); string(reply) != "+OK\r\n" {
+		t.Errorf("GET after unsubscribing = %q, want +OK", reply)
+	}
+}
+
+func TestPauseWritesWaitsForRunningWrites(t *testing.T) {
+	disp := NewDispatcher(database.NewDBSelector(1))
+	started, release := make(chan struct{}), make(chan struct{})
+	disp.Register(&Command{
+		Name: "SLOWWRITE",
+		Handler: func(ctx *Context) (*Reply, error) {
+			started <- struct{}{}
+			<-release
+			return NewStatusReply("OK"), nil
+		},
+		Arity: 1,
+		Flags: []string{FlagWrite},
+	})
+	disp.Register(&Command{
+		Name: "BLOCKWRITE",
+		Handler: func(ctx *Context) (*Reply, error) {
+			ctx.Wait(func() {
+				started <- struct{}{}
+				<-release
+			})
+			return NewStatusReply("OK"), nil
+		},
+		Arity: 1,
+		Flags: []string{FlagWrite},
+	})
+
+	client, server := gonet.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := net.NewConn(server)
+
+	paused := func() <-chan struct{} {
+		done := make(chan struct{})
+		go disp.PauseWrites(func() { close(done) })
+		return done
+	}
+
+	// A running write holds the snapshot up until it is done
+	go disp.Dispatch(context.Background(), conn, "SLOWWRITE", nil)
+	<-started
+	done := paused()
+	select {
+	case <-done:
+		t.Fatal("PauseWrites ran during a write")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("PauseWrites did not run after the write")
+	}
+
+	// A write blocked waiting does not
+	go disp.Dispatch(context.Background(), conn, "BLOCKWRITE", nil)
+	<-started
+	select {
+	case <-paused():
+	case <-time.After(5 * time.Second):
+		t.Fatal("PauseWrites held up by a blocked write")
+	}
+	release <- struct{}{}
+}
+
+func TestBlockedTimeIsNotRecorded(t *testing.T) {
+	disp := NewDispatcher(database.NewDBSelector(1))
+	disp.SlowLog().SetSlowerThan(50 * 1000)
+	disp.Register(&Command{
+		Name: "BLOCKREAD",
+		Handler: func(ctx *Context) (*Reply, error) {
+			ctx.Wait(func() { time.Sleep(100 * time.Millisecond) })
+			return NewStatusReply("OK"), nil
+		},
+		Arity: 1,
+	})
+
+	client, server := gonet.Pipe()
+	defer client.Close()
+	defer server.Close()
+	conn := net.NewConn(server)
+
+	if reply, _ := disp.Dispatch(context.Background(), conn, "BLOCKREAD", nil); string(reply) != "+OK\r\n" {
+		t.Fatalf("BLOCKREAD = %q, want +OK", reply)
+	}
+	if n := disp.SlowLog().Len(); n != 0 {
+		t.Errorf("a command blocked for 100ms expected not to be slow logged, got %d entries", n)
+	}
+	h, ok := disp.Stats().Histogram("BLOCKREAD")
+	if !ok || h.Count() != 1 {
+		t.Fatal("BLOCKREAD expected to be counted once in the command statistics")
+	}
+	if usec := h.Usec(); usec >= 50*1000 {
+		t.Errorf("BLOCKREAD expected to be timed without the blocked time, got %dus", usec)
+	}
+}
+
+// logRecorder records the commands logged to it as "db name args..."
+type logRecorder struct {
+	mu      sync.Mutex
+	entries []string
+}
+
+func (r *logRecorder) LogCommand(db int, cmdName string, args []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.entries = append(r.entries, strings.Join(append([]string{strconv.Itoa(db), cmdName}, args...), " "))
+	return nil
+}
+
+func TestApplyLogsReplicatedWrites(t *testing.T) {
+	disp := NewDispatcher(database.NewDBSelector(2))
+	disp.Register(&Command{
+		Name: "SET",
+		Handler: func(ctx *Context) (*Reply, error) {
+			ctx.DB.Set(ctx.Args[0], database.NewStringObject(ctx.Args[1]))
+			return NewStatusReply("OK"), nil
+		},
+		Arity: 3,
+		Flags: []string{FlagWrite},
+	})
+	disp.Register(&Command{
+		Name:    "GET",
+		Handler: func(ctx *Context) (*Reply, error) { return NewNilReply(), nil },
+		Arity:   2,
+		Flags:   []string{FlagReadOnly},
+	})
+	aofLog, replicaLog := &logRecorder{}, &logRecorder{}
+	disp.SetAOFLogger(aofLog)
+	disp.SetReplicationFeed(replicaLog)
+
+	if _, err := disp.Apply(1, "SET", []string{"k", "v"}); err != nil {
+		t.Fatalf("Apply SET failed: %v", err)
+	}
+	if _, err := disp.Apply(1, "GET", []string{"k"}); err != nil {
+		t.Fatalf("Apply GET failed: %v", err)
+	}
+	if _, err := disp.Apply(0, "NOSUCHCMD", nil); err == nil {
+		t.Error("Apply of an unknown command expected an error")
+	}
+
+	db, _ := disp.GetDB().GetDB(1)
+	if db.Exists("k") != 1 {
+		t.Error("Apply SET did not write to db 1")
+	}
+	for name, log := range map[string]*logRecorder{"AOF": aofLog, "replicas": replicaLog} {
+		if got := strings.Join(log.entries, ","); got != "1 SET k v" {
+			t.Errorf("%s got %q, want the write only", name, got)
+		}
+	}
+}
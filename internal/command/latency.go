@@ -0,0 +1,147 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package command
+
+import (
+	"math/bits"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// latencyBuckets is the number of log2 buckets in a latency histogram.
+// Bucket i holds calls that took [2^i, 2^(i+1)) microseconds.
+const latencyBuckets = 40
+
+// LatencyHistogram is a fixed log-scale histogram of call latencies
+type LatencyHistogram struct {
+	buckets [latencyBuckets]atomic.Uint64
+	count   atomic.Uint64
+	usec    atomic.Uint64 // Total time of the calls
+}
+
+// Record adds a single call latency to the histogram
+func (h *LatencyHistogram) Record(d time.Duration) {
+	usec := uint64(d.Microseconds())
+	idx := 0
+	if usec > 0 {
+		idx = bits.Len64(usec) - 1
+	}
+	if idx >= latencyBuckets {
+		idx = latencyBuckets - 1
+	}
+
+	h.buckets[idx].Add(1)
+	h.count.Add(1)
+	h.usec.Add(usec)
+}
+
+// Count returns the number of recorded calls
+func (h *LatencyHistogram) Count() uint64 {
+	return h.count.Load()
+}
+
+// Usec returns the total time of the recorded calls in microseconds
+func (h *LatencyHistogram) Usec() uint64 {
+	return h.usec.Load()
+}
+
+// Percentile returns the latency in microseconds at the given percentile (0-100).
+// The value is the upper bound of the bucket the percentile falls into.
+func (h *LatencyHistogram) Percentile(p float64) float64 {
+	total := h.count.Load()
+	if total == 0 {
+		return 0
+	}
+
+	target := uint64(float64(total) * p / 100)
+	if target == 0 {
+		target = 1
+	}
+
+	var seen uint64
+	for i := 0; i < latencyBuckets; i++ {
+		seen += h.buckets[i].Load()
+		if seen >= target {
+			return float64(uint64(1) << (i + 1))
+		}
+	}
+	return float64(uint64(1) << latencyBuckets)
+}
+
+// CommandStats tracks per-command call statistics
+type CommandStats struct {
+	mu         sync.RWMutex
+	histograms map[string]*LatencyHistogram
+}
+
+// NewCommandStats creates a new command statistics tracker
+func NewCommandStats() *CommandStats {
+	return &CommandStats{
+		histograms: make(map[string]*LatencyHistogram),
+	}
+}
+
+// Record records a call of the named command
+func (s *CommandStats) Record(name string, d time.Duration) {
+	name = strings.ToLower(name)
+
+	s.mu.RLock()
+	h, ok := s.histograms[name]
+	s.mu.RUnlock()
+
+	if !ok {
+		s.mu.Lock()
+		if h, ok = s.histograms[name]; !ok {
+			h = &LatencyHistogram{}
+			s.histograms[name] = h
+		}
+		s.mu.Unlock()
+	}
+
+	h.Record(d)
+}
+
+// Histogram returns the latency histogram of a command
+func (s *CommandStats) Histogram(name string) (*LatencyHistogram, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	h, ok := s.histograms[strings.ToLower(name)]
+	return h, ok
+}
+
+// Names returns the sorted names of all commands that have been called
+func (s *CommandStats) Names() []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	names := make([]string, 0, len(s.histograms))
+	for name := range s.histograms {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// TotalCalls returns the number of calls recorded across all commands
+func (s *CommandStats) TotalCalls() uint64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var total uint64
+	for _, h := range s.histograms {
+		total += h.Count()
+	}
+	return total
+}
+
+// Reset clears all recorded statistics
+func (s *CommandStats) Reset() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.histograms = make(map[string]*LatencyHistogram)
+}
@@ -0,0 +1,159 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package command
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Limits on how much of a command a slow log entry keeps
+const (
+	slowLogMaxArgc   = 32
+	slowLogMaxArgLen = 128
+)
+
+// SlowLogEntry is a command that took longer than the slow log threshold
+type SlowLogEntry struct {
+	ID         int64
+	Time       time.Time
+	Duration   time.Duration
+	Args       []string // Command name followed by its arguments, truncated
+	ClientAddr string
+	ClientName string
+}
+
+// SlowLog keeps the most recent slow commands in a ring buffer
+type SlowLog struct {
+	mu         sync.RWMutex
+	entries    []SlowLogEntry
+	start      int // Index of the oldest entry
+	count      int
+	nextID     int64
+	slowerThan int64 // Microseconds; negative disables the log
+}
+
+// NewSlowLog creates a slow log keeping up to maxLen entries of commands
+// slower than slowerThan microseconds
+func NewSlowLog(slowerThan, maxLen int64) *SlowLog {
+	l := &SlowLog{slowerThan: slowerThan}
+	l.SetMaxLen(maxLen)
+	return l
+}
+
+// SetSlowerThan sets the threshold in microseconds. Zero logs every
+// command and a negative value disables the log.
+func (l *SlowLog) SetSlowerThan(usec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.slowerThan = usec
+}
+
+// SetMaxLen sets the number of entries kept, dropping the oldest ones that
+// no longer fit
+func (l *SlowLog) SetMaxLen(maxLen int64) {
+	if maxLen < 0 {
+		maxLen = 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	kept := l.newestLocked(int(maxLen))
+	entries := make([]SlowLogEntry, maxLen)
+	// Oldest first, so that start is 0
+	for i := range kept {
+		entries[i] = kept[len(kept)-1-i]
+	}
+	l.entries, l.start, l.count = entries, 0, len(kept)
+}
+
+// Record adds a command to the log if it ran for longer than the threshold
+func (l *SlowLog) Record(args []string, d time.Duration, clientAddr, clientName string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.slowerThan < 0 || d.Microseconds() < l.slowerThan || len(l.entries) == 0 {
+		return
+	}
+
+	entry := SlowLogEntry{
+		ID:         l.nextID,
+		Time:       time.Now(),
+		Duration:   d,
+		Args:       slowLogArgs(args),
+		ClientAddr: clientAddr,
+		ClientName: clientName,
+	}
+	l.nextID++
+
+	if l.count < len(l.entries) {
+		l.entries[(l.start+l.count)%len(l.entries)] = entry
+		l.count++
+		return
+	}
+	// Full: overwrite the oldest entry
+	l.entries[l.start] = entry
+	l.start = (l.start + 1) % len(l.entries)
+}
+
+// Entries returns up to n entries, newest first. A negative n returns all.
+func (l *SlowLog) Entries(n int) []SlowLogEntry {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.newestLocked(n)
+}
+
+// newestLocked returns up to n entries, newest first (with l.mu held)
+func (l *SlowLog) newestLocked(n int) []SlowLogEntry {
+	if n < 0 || n > l.count {
+		n = l.count
+	}
+	result := make([]SlowLogEntry, n)
+	for i := 0; i < n; i++ {
+		result[i] = l.entries[(l.start+l.count-1-i)%len(l.entries)]
+	}
+	return result
+}
+
+// Len returns the number of entries in the log
+func (l *SlowLog) Len() int {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.count
+}
+
+// Reset removes every entry from the log
+func (l *SlowLog) Reset() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	clear(l.entries)
+	l.start, l.count = 0, 0
+}
+
+// slowLogArgs copies args, keeping at most slowLogMaxArgc arguments of at
+// most slowLogMaxArgLen bytes each
+func slowLogArgs(args []string) []string {
+	argc := len(args)
+	if argc > slowLogMaxArgc {
+		argc = slowLogMaxArgc
+	}
+
+	result := make([]string, argc)
+	for i := 0; i < argc; i++ {
+		if i == slowLogMaxArgc-1 && len(args) > slowLogMaxArgc {
+			result[i] = fmt.Sprintf("... (%d more arguments)", len(args)-slowLogMaxArgc+1)
+			break
+		}
+		arg := args[i]
+		if len(arg) > slowLogMaxArgLen {
+			arg = fmt.Sprintf("%s... (%d more bytes)", arg[:slowLogMaxArgLen], len(arg)-slowLogMaxArgLen)
+		}
+		result[i] = arg
+	}
+	return result
+}
@@ -12,6 +12,8 @@ import (
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/zyhnesmr/godis/internal/eviction"
 )
 
 // Config holds the server configuration
@@ -55,6 +57,12 @@ type Config struct {
 	SlowLogLogSlowerThan int64
 	SlowLogMaxLen        int64
 
+	// Latency monitor threshold in milliseconds, 0 to disable
+	LatencyMonitorThreshold int64
+
+	// Bytes of the replication stream kept for replicas to resume from
+	ReplBacklogSize int64
+
 	// Advanced configuration for data structure encoding
 	HashMaxZiplistEntries int
 	HashMaxZiplistValue   int
@@ -119,6 +127,12 @@ func Default() *Config {
 		SlowLogLogSlowerThan: 10000, // microseconds
 		SlowLogMaxLen:        128,
 
+		// Latency monitor
+		LatencyMonitorThreshold: 0,
+
+		// Replication
+		ReplBacklogSize: 1 << 20, // 1MB
+
 		// Advanced
 		HashMaxZiplistEntries: 512,
 		HashMaxZiplistValue:   64,
@@ -177,6 +191,9 @@ func (c *Config) Parse(content string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	// The first save line replaces the default save rules, later ones add to it
+	saveSeen := false
+
 	lines := strings.Split(content, "\n")
 	for i, line := range lines {
 		line = strings.TrimSpace(line)
@@ -196,6 +213,19 @@ func (c *Config) Parse(content string) error {
 		key := strings.ToLower(parts[0])
 		value := strings.Join(parts[1:], " ")
 
+		if key == "save" {
+			rules, err := parseSaveRules(value)
+			if err != nil {
+				return fmt.Errorf("line %d: %w", i+1, err)
+			}
+			if !saveSeen || len(rules) == 0 {
+				c.SaveRules = nil
+			}
+			c.SaveRules = append(c.SaveRules, rules...)
+			saveSeen = true
+			continue
+		}
+
 		if err := c.setConfig(key, value); err != nil {
 			return fmt.Errorf("line %d: %w", i+1, err)
 		}
@@ -203,9 +233,28 @@ func (c *Config) Parse(content string) error {
 	return nil
 }
 
+// aliases maps the listpack names used since Redis 7 to the ziplist names
+// the configuration is kept under
+var aliases = map[string]string{
+	"hash-max-listpack-entries": "hash-max-ziplist-entries",
+	"hash-max-listpack-value":   "hash-max-ziplist-value",
+	"list-max-listpack-size":    "list-max-ziplist-size",
+	"zset-max-listpack-entries": "zset-max-ziplist-entries",
+	"zset-max-listpack-value":   "zset-max-ziplist-value",
+}
+
+// CanonicalName returns the name a configuration key is kept under,
+// resolving listpack aliases to their ziplist names
+func CanonicalName(key string) string {
+	if name, ok := aliases[key]; ok {
+		return name
+	}
+	return key
+}
+
 // setConfig sets a single configuration value
 func (c *Config) setConfig(key, value string) error {
-	switch key {
+	switch CanonicalName(key) {
 	case "bind":
 		c.Bind = value
 	case "port":
@@ -241,20 +290,11 @@ func (c *Config) setConfig(key, value string) error {
 		}
 		c.Databases = d
 	case "save":
-		// Format: save <seconds> <changes>
-		parts := strings.Fields(value)
-		if len(parts) != 2 {
-			return fmt.Errorf("invalid save format")
-		}
-		seconds, err := strconv.Atoi(parts[0])
+		rules, err := parseSaveRules(value)
 		if err != nil {
 			return err
 		}
-		changes, err := strconv.Atoi(parts[1])
-		if err != nil {
-			return err
-		}
-		c.SaveRules = append(c.SaveRules, SaveRule{Seconds: seconds, Changes: changes})
+		c.SaveRules = rules
 	case "stop-writes-on-bgsave-error":
 		c.StopWritesOnBgsaveError = strings.ToLower(value) == "yes"
 	case "rdbcompression":
@@ -282,7 +322,11 @@ func (c *Config) setConfig(key, value string) error {
 			c.MaxMemory = m
 		}
 	case "maxmemory-policy":
-		c.MaxMemoryPolicy = strings.ToLower(value)
+		policy := strings.ToLower(value)
+		if _, err := eviction.PolicyFromString(policy); err != nil {
+			return err
+		}
+		c.MaxMemoryPolicy = policy
 	case "maxmemory-samples":
 		s, err := strconv.Atoi(value)
 		if err != nil {
@@ -321,6 +365,24 @@ func (c *Config) setConfig(key, value string) error {
 			return err
 		}
 		c.SlowLogMaxLen = s
+	case "latency-monitor-threshold":
+		s, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		if s < 0 {
+			return fmt.Errorf("argument must be greater than or equal to 0")
+		}
+		c.LatencyMonitorThreshold = s
+	case "repl-backlog-size":
+		s, err := parseMemory(value)
+		if err != nil {
+			return err
+		}
+		if s <= 0 {
+			return fmt.Errorf("argument must be greater than 0")
+		}
+		c.ReplBacklogSize = s
 	case "hash-max-ziplist-entries":
 		h, err := strconv.Atoi(value)
 		if err != nil {
@@ -369,6 +431,34 @@ func (c *Config) setConfig(key, value string) error {
 	return nil
 }
 
+// parseSaveRules parses "<seconds> <changes> [<seconds> <changes> ...]".
+// An empty value, or "", disables snapshotting.
+func parseSaveRules(value string) ([]SaveRule, error) {
+	value = strings.TrimSpace(value)
+	if value == "" || value == `""` {
+		return nil, nil
+	}
+
+	parts := strings.Fields(value)
+	if len(parts)%2 != 0 {
+		return nil, fmt.Errorf("invalid save format")
+	}
+
+	rules := make([]SaveRule, 0, len(parts)/2)
+	for i := 0; i < len(parts); i += 2 {
+		seconds, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return nil, err
+		}
+		changes, err := strconv.Atoi(parts[i+1])
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
 // parseMemory parses memory size strings like "1gb", "500mb", etc.
 func parseMemory(s string) (int64, error) {
 	s = strings.ToLower(s)
@@ -390,12 +480,34 @@ func parseMemory(s string) (int64, error) {
 	return val * multiplier, nil
 }
 
+// getNames lists the keys supported by Get, in CONFIG GET order
+var getNames = []string{
+	"bind", "port", "timeout", "tcp-keepalive", "daemonize", "pidfile",
+	"loglevel", "logfile", "databases", "save", "stop-writes-on-bgsave-error",
+	"rdbcompression", "rdbchecksum", "dbfilename", "dir", "maxclients",
+	"maxmemory", "maxmemory-policy", "maxmemory-samples", "appendonly",
+	"appendfilename", "appendfsync", "no-appendfsync-on-rewrite",
+	"auto-aof-rewrite-percentage", "auto-aof-rewrite-min-size",
+	"slowlog-log-slower-than", "slowlog-max-len", "latency-monitor-threshold",
+	"repl-backlog-size",
+	"hash-max-ziplist-entries",
+	"hash-max-ziplist-value", "list-max-ziplist-size", "list-compress-depth",
+	"set-max-intset-entries", "zset-max-ziplist-entries", "zset-max-ziplist-value",
+	"hash-max-listpack-entries", "hash-max-listpack-value", "list-max-listpack-size",
+	"zset-max-listpack-entries", "zset-max-listpack-value",
+}
+
+// Names returns the configuration keys readable with Get
+func (c *Config) Names() []string {
+	return append([]string(nil), getNames...)
+}
+
 // Get returns a configuration value by key (for CONFIG GET command)
 func (c *Config) Get(key string) (string, bool) {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
-	switch strings.ToLower(key) {
+	switch CanonicalName(strings.ToLower(key)) {
 	case "bind":
 		return c.Bind, true
 	case "port":
@@ -444,10 +556,34 @@ func (c *Config) Get(key string) (string, bool) {
 		return c.AppendFilename, true
 	case "appendfsync":
 		return c.AppendFsync, true
+	case "no-appendfsync-on-rewrite":
+		return boolToStr(c.NoAppendfsyncOnRewrite), true
+	case "auto-aof-rewrite-percentage":
+		return strconv.Itoa(c.AutoAofRewritePercentage), true
+	case "auto-aof-rewrite-min-size":
+		return strconv.FormatInt(c.AutoAofRewriteMinSize, 10), true
 	case "slowlog-log-slower-than":
 		return strconv.FormatInt(c.SlowLogLogSlowerThan, 10), true
 	case "slowlog-max-len":
 		return strconv.FormatInt(c.SlowLogMaxLen, 10), true
+	case "latency-monitor-threshold":
+		return strconv.FormatInt(c.LatencyMonitorThreshold, 10), true
+	case "repl-backlog-size":
+		return strconv.FormatInt(c.ReplBacklogSize, 10), true
+	case "hash-max-ziplist-entries":
+		return strconv.Itoa(c.HashMaxZiplistEntries), true
+	case "hash-max-ziplist-value":
+		return strconv.Itoa(c.HashMaxZiplistValue), true
+	case "list-max-ziplist-size":
+		return strconv.Itoa(c.ListMaxZiplistSize), true
+	case "list-compress-depth":
+		return strconv.Itoa(c.ListCompressDepth), true
+	case "set-max-intset-entries":
+		return strconv.Itoa(c.SetMaxIntsetEntries), true
+	case "zset-max-ziplist-entries":
+		return strconv.Itoa(c.ZSetMaxZiplistEntries), true
+	case "zset-max-ziplist-value":
+		return strconv.Itoa(c.ZSetMaxZiplistValue), true
 	default:
 		return "", false
 	}
@@ -457,7 +593,7 @@ func (c *Config) Get(key string) (string, bool) {
 func (c *Config) Set(key, value string) error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.setConfig(key, value)
+	return c.setConfig(strings.ToLower(key), value)
 }
 
 // boolToStr converts boolean to "yes" or "no"
@@ -0,0 +1,58 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSaveRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []SaveRule
+	}{
+		{"defaults", "port 6379", Default().SaveRules},
+		{"first line replaces defaults", "save 60 100", []SaveRule{{60, 100}}},
+		{"later lines append", "save 900 1\nsave 300 10", []SaveRule{{900, 1}, {300, 10}}},
+		{"empty disables", `save ""`, nil},
+		{"empty clears earlier lines", "save 900 1\nsave \"\"", nil},
+	}
+
+	for _, tt := range tests {
+		c := Default()
+		if err := c.Parse(tt.content); err != nil {
+			t.Fatalf("%s: Parse failed: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(c.SaveRules, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, c.SaveRules)
+		}
+	}
+
+	if err := Default().Parse("save 900"); err == nil {
+		t.Error("expected an error for an odd number of save arguments")
+	}
+}
+
+func TestSetSaveRules(t *testing.T) {
+	c := Default()
+	if err := c.Set("save", "900 1 300 10"); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if want := []SaveRule{{900, 1}, {300, 10}}; !reflect.DeepEqual(c.SaveRules, want) {
+		t.Errorf("expected %v, got %v", want, c.SaveRules)
+	}
+	if got, _ := c.Get("save"); got != "900 1 300 10" {
+		t.Errorf("CONFIG GET save expected %q, got %q", "900 1 300 10", got)
+	}
+
+	if err := c.Set("save", ""); err != nil {
+		t.Fatalf("Set failed: %v", err)
+	}
+	if len(c.SaveRules) != 0 {
+		t.Errorf("expected save rules cleared, got %v", c.SaveRules)
+	}
+}
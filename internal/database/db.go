@@ -19,11 +19,12 @@ type DirtyKeyCallback func(key string)
 type DB struct {
 	id      int
 	dict    *Dict
-	expires *Dict
+	expires *Dict // Key to expiration time, in Unix milliseconds
 	mu      sync.RWMutex
 
 	// Statistics
-	keysCount int64
+	keysCount  int64 // Entries in dict, kept by storeEntryLocked and removeEntryLocked
+	usedMemory int64 // Incremental total of entrySize over all keys
 
 	// Transaction support
 	dirtyKeyCallback DirtyKeyCallback
@@ -87,9 +88,8 @@ func (db *DB) Get(key string) (*Object, bool) {
 	obj, ok = db.dict.Get(key)
 	if ok && db.isExpiredLocked(key) && obj == oldObj {
 		// Lazy delete the expired key (only if not replaced by another goroutine)
-		db.dict.Delete(key)
+		db.removeEntryLocked(key)
 		db.expires.Delete(key)
-		db.keysCount--
 		db.mu.Unlock()
 		return nil, false
 	}
@@ -109,19 +109,11 @@ func (db *DB) Set(key string, value *Object) {
 
 	// If key is expired, delete it first to ensure correct counting
 	if db.isExpiredLocked(key) {
-		db.dict.Delete(key)
+		db.removeEntryLocked(key)
 		db.expires.Delete(key)
-		db.keysCount--
-	}
-
-	// Check if key exists (after potential deletion of expired key)
-	wasNew := !db.dict.Exists(key)
-	db.dict.Set(key, value)
-
-	if wasNew {
-		db.keysCount++
 	}
 
+	db.storeEntryLocked(key, value)
 	db.markDirty(key)
 }
 
@@ -137,13 +129,11 @@ func (db *DB) SetNX(key string, value *Object) bool {
 
 	// Key doesn't exist or is expired - delete expired key if present
 	if db.isExpiredLocked(key) {
-		db.dict.Delete(key)
+		db.removeEntryLocked(key)
 		db.expires.Delete(key)
-		db.keysCount--
 	}
 
-	db.dict.Set(key, value)
-	db.keysCount++
+	db.storeEntryLocked(key, value)
 	db.markDirty(key)
 	return true
 }
@@ -157,7 +147,7 @@ func (db *DB) SetXX(key string, value *Object) bool {
 		return false
 	}
 
-	db.dict.Set(key, value)
+	db.storeEntryLocked(key, value)
 	db.markDirty(key)
 	return true
 }
@@ -171,16 +161,14 @@ func (db *DB) Delete(keys ...string) int {
 	for _, key := range keys {
 		// Check if key exists and is not expired
 		if db.dict.Exists(key) && !db.isExpiredLocked(key) {
-			db.dict.Delete(key)
+			db.removeEntryLocked(key)
 			db.expires.Delete(key)
-			db.keysCount--
 			deleted++
 			db.markDirty(key)
-		} else {
-			// Key doesn't exist or is expired, clean up expires if present
-			if _, ok := db.expires.Get(key); ok {
-				db.expires.Delete(key)
-			}
+		} else if db.dict.Exists(key) {
+			// Key is expired; reclaim it without counting it as deleted
+			db.removeEntryLocked(key)
+			db.expires.Delete(key)
 		}
 	}
 
@@ -202,6 +190,26 @@ func (db *DB) Exists(keys ...string) int {
 	return count
 }
 
+// TouchKeys records an access to each of the keys for the eviction
+// policy: it bumps the LFU counter under an LFU policy, and the access
+// time otherwise. Missing keys are skipped.
+func (db *DB) TouchKeys(lfu bool, keys ...string) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	for _, key := range keys {
+		obj, ok := db.dict.Get(key)
+		if !ok {
+			continue
+		}
+		if lfu {
+			obj.(*Object).IncrementLFU()
+		} else {
+			obj.(*Object).UpdateLRU()
+		}
+	}
+}
+
 // Type returns the type of a key
 func (db *DB) Type(key string) string {
 	db.mu.RLock()
@@ -262,33 +270,16 @@ func (db *DB) Rename(key, newKey string) error {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if key == newKey {
-		return nil
-	}
-
 	obj, ok := db.dict.Get(key)
 	if !ok || db.isExpiredLocked(key) {
 		return fmt.Errorf("no such key")
 	}
 
-	// Get expire time
-	var expireTime int64
-	if exp, ok := db.expires.Get(key); ok {
-		expireTime = exp.(int64)
-	}
-
-	// Delete old keys
-	db.dict.Delete(key)
-	db.expires.Delete(key)
-
-	// Set new key
-	db.dict.Set(newKey, obj)
-	if expireTime > 0 {
-		db.expires.Set(newKey, expireTime)
+	if key == newKey {
+		return nil
 	}
 
-	db.markDirty(key)
-	db.markDirty(newKey)
+	db.moveLocked(key, newKey, obj)
 	return nil
 }
 
@@ -297,69 +288,97 @@ func (db *DB) RenameNX(key, newKey string) (bool, error) {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if key == newKey {
-		return false, nil
-	}
-
 	obj, ok := db.dict.Get(key)
 	if !ok || db.isExpiredLocked(key) {
 		return false, fmt.Errorf("no such key")
 	}
 
-	if db.dict.Exists(newKey) && !db.isExpiredLocked(newKey) {
+	if key == newKey {
 		return false, nil
 	}
 
-	// Get expire time
-	var expireTime int64
-	if exp, ok := db.expires.Get(key); ok {
-		expireTime = exp.(int64)
+	if db.dict.Exists(newKey) && !db.isExpiredLocked(newKey) {
+		return false, nil
 	}
 
-	// Delete old keys
-	db.dict.Delete(key)
+	db.moveLocked(key, newKey, obj)
+	return true, nil
+}
+
+// moveLocked moves obj from key to newKey, overwriting newKey. The object
+// itself is moved, so its access metadata is kept, and so is the expire
+// time of key; any expire time newKey had is dropped. (with db.mu lock
+// held)
+func (db *DB) moveLocked(key, newKey string, obj interface{}) {
+	exp, hasExpire := db.expires.Get(key)
+
+	db.removeEntryLocked(key)
 	db.expires.Delete(key)
 
-	// Set new key
-	db.dict.Set(newKey, obj)
-	if expireTime > 0 {
-		db.expires.Set(newKey, expireTime)
+	db.storeEntryLocked(newKey, obj)
+	db.expires.Delete(newKey)
+	if hasExpire {
+		db.expires.Set(newKey, exp)
 	}
 
 	db.markDirty(key)
 	db.markDirty(newKey)
-	return true, nil
 }
 
 // Expire sets an expiration time for a key (in seconds)
 func (db *DB) Expire(key string, seconds int) bool {
+	return db.PExpireAt(key, time.Now().UnixMilli()+int64(seconds)*1000)
+}
+
+// ExpireAt sets an expiration timestamp for a key (in Unix seconds)
+func (db *DB) ExpireAt(key string, timestamp int64) bool {
+	return db.PExpireAt(key, timestamp*1000)
+}
+
+// PExpireAt sets an expiration timestamp for a key (in Unix
+// milliseconds), the resolution expirations are kept at
+func (db *DB) PExpireAt(key string, timestamp int64) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if !db.dict.Exists(key) {
+	// A logically expired key counts as missing
+	if !db.dict.Exists(key) || db.isExpiredLocked(key) {
 		return false
 	}
 
-	expireTime := time.Now().Add(time.Duration(seconds) * time.Second).Unix()
-	db.expires.Set(key, expireTime)
+	db.expires.Set(key, timestamp)
 	return true
 }
 
-// ExpireAt sets an expiration timestamp for a key
-func (db *DB) ExpireAt(key string, timestamp int64) bool {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// PExpireTime returns the expiration timestamp of a key (in Unix
+// milliseconds) and whether the key has one
+func (db *DB) PExpireTime(key string) (int64, bool) {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
 
-	if !db.dict.Exists(key) {
-		return false
+	if !db.dict.Exists(key) || db.isExpiredLocked(key) {
+		return 0, false
 	}
 
-	db.expires.Set(key, timestamp)
-	return true
+	exp, ok := db.expires.Get(key)
+	if !ok {
+		return 0, false
+	}
+	return exp.(int64), true
 }
 
-// TTL returns the time to live for a key (in seconds)
+// TTL returns the time to live for a key (in seconds), rounded to the
+// nearest second like Redis does
 func (db *DB) TTL(key string) int64 {
+	pttl := db.PTTL(key)
+	if pttl < 0 {
+		return pttl
+	}
+	return (pttl + 500) / 1000
+}
+
+// PTTL returns the time to live for a key (in milliseconds)
+func (db *DB) PTTL(key string) int64 {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
 
@@ -372,17 +391,12 @@ func (db *DB) TTL(key string) int64 {
 		return -1 // No expiration
 	}
 
-	ttl := exp.(int64) - time.Now().Unix()
-	if ttl <= 0 {
+	pttl := exp.(int64) - time.Now().UnixMilli()
+	if pttl <= 0 {
 		return -2 // Already expired
 	}
 
-	return ttl
-}
-
-// PTTL returns the time to live for a key (in milliseconds)
-func (db *DB) PTTL(key string) int64 {
-	return db.TTL(key) * 1000
+	return pttl
 }
 
 // Persist removes the expiration from a key
@@ -390,7 +404,8 @@ func (db *DB) Persist(key string) bool {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
-	if !db.dict.Exists(key) {
+	// A logically expired key counts as missing
+	if !db.dict.Exists(key) || db.isExpiredLocked(key) {
 		return false
 	}
 
@@ -403,30 +418,63 @@ func (db *DB) Persist(key string) bool {
 	return true
 }
 
-// DBSize returns the number of keys in the database
+// DBSize returns the number of keys in the database. As in Redis, keys
+// that have expired but were not reclaimed yet are counted.
 func (db *DB) DBSize() int {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-
-	// Count non-expired keys
-	count := 0
-	for _, key := range db.dict.Keys() {
-		if !db.isExpiredLocked(key) {
-			count++
-		}
-	}
-
-	return count
+	return int(db.keysCount)
 }
 
-// FlushDB removes all keys from the database
+// FlushDB removes all keys from the database. Clients watching any of them
+// are flagged, as for any other change.
 func (db *DB) FlushDB() {
 	db.mu.Lock()
 	defer db.mu.Unlock()
 
+	if db.dirtyKeyCallback != nil {
+		for _, key := range db.dict.Keys() {
+			db.markDirty(key)
+		}
+	}
 	db.dict.Clear()
 	db.expires.Clear()
 	db.keysCount = 0
+	db.usedMemory = 0
+}
+
+// entrySize returns the memory accounted for a key and its value
+func entrySize(key string, value interface{}) int64 {
+	size := int64(len(key)) + 16 // Approximate pointer overhead
+	if o, ok := value.(*Object); ok {
+		size += o.Size()
+	}
+	return size
+}
+
+// storeEntryLocked stores a value in the dict and updates the memory total
+// by the difference between the old and new entry, and the key count if
+// the key is new (with db.mu lock held)
+func (db *DB) storeEntryLocked(key string, value interface{}) {
+	if old, ok := db.dict.Get(key); ok {
+		db.usedMemory -= entrySize(key, old)
+	} else {
+		db.keysCount++
+	}
+	db.dict.Set(key, value)
+	db.usedMemory += entrySize(key, value)
+}
+
+// removeEntryLocked removes a value from the dict and releases its memory
+// and key from the totals (with db.mu lock held)
+func (db *DB) removeEntryLocked(key string) {
+	old, ok := db.dict.Get(key)
+	if !ok {
+		return
+	}
+	db.usedMemory -= entrySize(key, old)
+	db.keysCount--
+	db.dict.Delete(key)
 }
 
 // isExpiredLocked checks if a key is expired (with db.mu lock held)
@@ -436,7 +484,7 @@ func (db *DB) isExpiredLocked(key string) bool {
 		return false
 	}
 
-	return exp.(int64) <= time.Now().Unix()
+	return exp.(int64) <= time.Now().UnixMilli()
 }
 
 // matchPattern checks if a key matches a pattern
@@ -487,33 +535,62 @@ func findContains(s, substr string) bool {
 	return false
 }
 
-// ActiveExpire actively removes expired keys
-func (db *DB) ActiveExpire(limit int) int {
-	db.mu.Lock()
-	defer db.mu.Unlock()
+// activeExpireStaleRatio is the percentage of expired keys in a sample
+// above which the expire cycle samples again
+const activeExpireStaleRatio = 25
 
+// ActiveExpire runs an adaptive expire cycle, like Redis's
+// activeExpireCycle: it samples up to sample random keys with a TTL and
+// removes the expired ones, repeating while more than 25% of a sample was
+// expired and deadline has not passed. Its cost depends on the number of
+// expired keys found, not on the size of the expires dict.
+func (db *DB) ActiveExpire(sample int, deadline time.Time) int {
 	expired := 0
-	now := time.Now().Unix()
+	for {
+		sampled, found := db.expireSample(sample)
+		expired += found
 
-	// Get all keys
-	allKeys := db.expires.Keys()
+		if sampled == 0 || found*100 <= sampled*activeExpireStaleRatio {
+			return expired
+		}
+		if !time.Now().Before(deadline) {
+			return expired
+		}
+	}
+}
 
-	for _, key := range allKeys {
-		if expired >= limit {
+// expireSample checks up to n random keys with a TTL and removes the
+// expired ones. It returns the number of keys sampled and removed.
+func (db *DB) expireSample(n int) (sampled, expired int) {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if size := db.expires.Len(); size < n {
+		n = size
+	}
+
+	now := time.Now().UnixMilli()
+	for ; sampled < n; sampled++ {
+		key, ok := db.expires.RandomKey()
+		if !ok {
 			break
 		}
 
 		exp, ok := db.expires.Get(key)
 		if ok && exp.(int64) <= now {
-			db.dict.Delete(key)
+			db.removeEntryLocked(key)
 			db.expires.Delete(key)
-			db.keysCount--
 			expired++
 			db.markDirty(key)
 		}
 	}
 
-	return expired
+	return sampled, expired
+}
+
+// ScanExpire runs an active expire cycle, for the expire scheduler
+func (db *DB) ScanExpire(sample int, deadline time.Time) int {
+	return db.ActiveExpire(sample, deadline)
 }
 
 // GetExpiresDict returns the expires dictionary
@@ -564,14 +641,22 @@ func (db *DB) Stats() DBStats {
 		ID:      db.id,
 		Keys:    db.keysCount,
 		Expires: db.expires.Len(),
+		Memory:  db.usedMemory,
 	}
 }
 
+// HTStats returns the hash table stats of the keyspace and of the keys
+// with a TTL, for DEBUG HTSTATS
+func (db *DB) HTStats() (keys, expires DictStats) {
+	return db.dict.Stats(), db.expires.Stats()
+}
+
 // DBStats holds database statistics
 type DBStats struct {
 	ID      int
 	Keys    int64
 	Expires int
+	Memory  int64 // Bytes used by the keys and values
 }
 
 // ==================== Eviction Support ====================
@@ -593,12 +678,12 @@ func (db *DB) GetKeyInfo(key string) (*eviction.KeyInfo, bool) {
 
 	var expiresAt int64
 	if exp, ok := db.expires.Get(key); ok {
-		expiresAt = exp.(int64)
+		expiresAt = exp.(int64) / 1000 // Eviction ranks TTLs in seconds
 	}
 
 	return &eviction.KeyInfo{
 		Key:       key,
-		LRU:       object.LRU,
+		LRU:       object.GetLRU(),
 		ExpiresAt: expiresAt,
 		Size:      object.Size(),
 	}, true
@@ -633,17 +718,7 @@ func (db *DB) GetRandomKeyWithExpiration() (string, bool) {
 
 // GetKeysCount returns the total number of keys in the database
 func (db *DB) GetKeysCount() int {
-	db.mu.RLock()
-	defer db.mu.RUnlock()
-
-	// Count non-expired keys
-	count := 0
-	for _, key := range db.dict.Keys() {
-		if !db.isExpiredLocked(key) {
-			count++
-		}
-	}
-	return count
+	return db.DBSize()
 }
 
 // GetKeysWithExpirationCount returns the number of keys with expiration
@@ -670,9 +745,8 @@ func (db *DB) DeleteForEviction(key string) bool {
 		return false
 	}
 
-	db.dict.Delete(key)
+	db.removeEntryLocked(key)
 	db.expires.Delete(key)
-	db.keysCount--
 	return true
 }
 
@@ -681,29 +755,10 @@ func (db *DB) DeleteSingle(key string) bool {
 	return db.DeleteForEviction(key)
 }
 
-// GetMemoryUsage returns the approximate memory usage of the database
+// GetMemoryUsage returns the approximate memory usage of the database.
+// The total is maintained incrementally as keys are stored and removed.
 func (db *DB) GetMemoryUsage() int64 {
 	db.mu.RLock()
 	defer db.mu.RUnlock()
-
-	var total int64
-
-	// Estimate memory from all objects
-	for _, key := range db.dict.Keys() {
-		if db.isExpiredLocked(key) {
-			continue
-		}
-
-		if obj, ok := db.dict.Get(key); ok {
-			if o, ok := obj.(*Object); ok {
-				total += o.Size()
-				total += int64(len(key)) // Add key size
-			}
-		}
-	}
-
-	// Add overhead for dictionaries
-	total += int64(db.dict.Len()) * 16 // Approximate pointer overhead
-
-	return total
+	return db.usedMemory
 }
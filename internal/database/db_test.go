@@ -0,0 +1,264 @@
+package database
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// newExpiringDB returns a DB with live keys expiring in an hour and
+// stale keys already expired
+func newExpiringDB(live, stale int) *DB {
+	db := NewDB(0)
+	now := time.Now().Unix()
+	for i := 0; i < live; i++ {
+		key := "live:" + strconv.Itoa(i)
+		db.Set(key, NewStringObject("v"))
+		db.ExpireAt(key, now+3600)
+	}
+	for i := 0; i < stale; i++ {
+		key := "stale:" + strconv.Itoa(i)
+		db.Set(key, NewStringObject("v"))
+		db.ExpireAt(key, now-1)
+	}
+	return db
+}
+
+func TestActiveExpireSamples(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+
+	// Nothing to expire: a single round of sampling
+	db := newExpiringDB(1000, 0)
+	if n := db.ActiveExpire(20, deadline); n != 0 {
+		t.Errorf("ActiveExpire with no expired keys expected 0, got %d", n)
+	}
+
+	// Only expired keys: every round is fully stale, so the cycle drains them
+	db = newExpiringDB(0, 500)
+	if n := db.ActiveExpire(20, deadline); n != 500 {
+		t.Errorf("ActiveExpire expected to remove 500 keys, got %d", n)
+	}
+	if n := db.GetDict().Len(); n != 0 {
+		t.Errorf("expected no keys left, got %d", n)
+	}
+
+	// Mixed: the cycle stops once few sampled keys are expired, and never
+	// removes live keys
+	db = newExpiringDB(1000, 1000)
+	n := db.ActiveExpire(20, deadline)
+	if n == 0 || n > 1000 {
+		t.Errorf("ActiveExpire on half expired keys removed %d", n)
+	}
+	if got := db.GetDict().Len(); got != 2000-n {
+		t.Errorf("expected %d keys left, got %d", 2000-n, got)
+	}
+	for i := 0; i < 1000; i++ {
+		if !db.GetDict().Exists("live:" + strconv.Itoa(i)) {
+			t.Fatalf("live key %d removed by active expire", i)
+		}
+	}
+
+	// A passed deadline stops after one round
+	db = newExpiringDB(0, 500)
+	if n := db.ActiveExpire(20, time.Now()); n != 20 {
+		t.Errorf("ActiveExpire past its deadline expected one round of 20, got %d", n)
+	}
+}
+
+// BenchmarkActiveExpire shows a cycle costs the same whatever the number
+// of keys with a TTL, when few of them are expired
+func BenchmarkActiveExpire(b *testing.B) {
+	for _, size := range []int{1000, 10000, 100000} {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			db := newExpiringDB(size, 0)
+			deadline := time.Now().Add(time.Hour)
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				db.ActiveExpire(20, deadline)
+			}
+		})
+	}
+}
+
+func TestRenameKeepsTTLAndAccessTime(t *testing.T) {
+	db := NewDB(0)
+	obj := NewStringObject("v")
+	db.Set("src", obj)
+	db.Expire("src", 100)
+	obj.LRU -= 50
+	lru := obj.LRU
+
+	if err := db.Rename("src", "dst"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	if got, ok := db.Get("dst"); !ok || got != obj {
+		t.Fatal("Rename did not move the object")
+	}
+	if ttl := db.TTL("dst"); ttl < 99 || ttl > 100 {
+		t.Errorf("TTL after Rename = %d, want 100", ttl)
+	}
+	if obj.LRU != lru {
+		t.Errorf("Rename changed the access time from %d to %d", lru, obj.LRU)
+	}
+	if db.Exists("src") != 0 || db.TTL("src") != -2 {
+		t.Error("source key still present after Rename")
+	}
+
+	if err := db.Rename("src", "other"); err == nil {
+		t.Error("Rename of a missing key expected error")
+	}
+	if err := db.Rename("src", "src"); err == nil {
+		t.Error("Rename of a missing key onto itself expected error")
+	}
+}
+
+func TestRenameDropsDestinationTTL(t *testing.T) {
+	db := NewDB(0)
+	db.Set("src", NewStringObject("new"))
+	db.Set("dst", NewStringObject("old"))
+	db.Expire("dst", 100)
+
+	if err := db.Rename("src", "dst"); err != nil {
+		t.Fatalf("Rename failed: %v", err)
+	}
+	obj, _ := db.Get("dst")
+	if s := obj.String(); s != "new" {
+		t.Errorf("Rename over an existing key left %q, want new", s)
+	}
+	if ttl := db.TTL("dst"); ttl != -1 {
+		t.Errorf("TTL after Rename over a key with a TTL = %d, want -1", ttl)
+	}
+
+	// An expired destination must not lend its TTL to the renamed key
+	db.Set("a", NewStringObject("a"))
+	db.Set("b", NewStringObject("b"))
+	db.ExpireAt("b", time.Now().Unix()-1)
+	if renamed, err := db.RenameNX("a", "b"); err != nil || !renamed {
+		t.Fatalf("RenameNX onto an expired key = %v, %v", renamed, err)
+	}
+	if _, ok := db.Get("b"); !ok {
+		t.Error("key renamed onto an expired key was lost")
+	}
+	if ttl := db.TTL("b"); ttl != -1 {
+		t.Errorf("TTL after RenameNX onto an expired key = %d, want -1", ttl)
+	}
+}
+
+func TestKeysCountMatchesDict(t *testing.T) {
+	db := NewDB(0)
+	past := time.Now().Unix() - 1
+
+	var wg sync.WaitGroup
+	for w := 0; w < 8; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 2000; i++ {
+				key := "k:" + strconv.Itoa((w*7+i)%50)
+				other := "k:" + strconv.Itoa((w+i*3)%50)
+				switch i % 8 {
+				case 0, 1:
+					db.Set(key, NewStringObject("v"))
+				case 2:
+					db.SetNX(key, NewStringObject("v"))
+				case 3:
+					db.Delete(key)
+				case 4:
+					// Expire in the past, to be reclaimed lazily or actively
+					db.ExpireAt(key, past)
+				case 5:
+					db.Get(key)
+				case 6:
+					db.Rename(key, other)
+				case 7:
+					db.ActiveExpire(10, time.Now().Add(time.Millisecond))
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	if got, want := db.DBSize(), db.dict.Len(); got != want {
+		t.Errorf("DBSize = %d, dict holds %d keys", got, want)
+	}
+
+	// Reclaim the expired keys; the count follows
+	for i := 0; i < 50; i++ {
+		db.Get("k:" + strconv.Itoa(i))
+	}
+	live := 0
+	for i := 0; i < 50; i++ {
+		live += db.Exists("k:" + strconv.Itoa(i))
+	}
+	if got := db.DBSize(); got != live || got != db.dict.Len() {
+		t.Errorf("DBSize after reclaiming = %d, want %d live keys", got, live)
+	}
+}
+
+func TestDeleteExpiredKeyStaysDeleted(t *testing.T) {
+	db := NewDB(0)
+	db.Set("k", NewStringObject("v"))
+	db.ExpireAt("k", time.Now().Unix()-1)
+
+	if n := db.Delete("k"); n != 0 {
+		t.Errorf("DEL of an expired key = %d, want 0", n)
+	}
+	if _, ok := db.Get("k"); ok {
+		t.Error("expired key came back after DEL")
+	}
+	if n := db.DBSize(); n != 0 {
+		t.Errorf("DBSize = %d, want 0", n)
+	}
+}
+
+// TestTouchKeysConcurrentWithReads runs the access tracking of several
+// readers alongside reads of the access time; run it with -race
+func TestTouchKeysConcurrentWithReads(t *testing.T) {
+	db := NewDB(0)
+	db.Set("k", NewStringObject("v"))
+	obj, _ := db.Get("k")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		lfu := i%2 == 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				db.TouchKeys(lfu, "k", "missing")
+				_, _ = db.GetKeyInfo("k")
+				_ = obj.GetLRU()
+			}
+		}()
+	}
+	wg.Wait()
+
+	db.TouchKeys(true, "k")
+	if obj.GetLFU() == 0 {
+		t.Error("LFU access was not counted")
+	}
+}
+
+func TestTouchKeysGrowsLFUCounter(t *testing.T) {
+	db := NewDB(0)
+	db.Set("k", NewStringObject("v"))
+	obj, _ := db.Get("k")
+
+	for i := 0; i < 50; i++ {
+		db.TouchKeys(true, "k")
+	}
+	if freq := obj.GetLFU(); freq < 50 {
+		t.Errorf("LFU counter after 50 accesses expected at least 50, got %d", freq)
+	}
+
+	// The access time is kept in 16-bit minutes: a counter last bumped five
+	// minutes ago decays by half rather than being reset
+	fiveMinutesAgo := uint32(time.Now().Add(-5*time.Minute).Unix()/60) & 0xffff
+	obj.LRU = fiveMinutesAgo<<8 | 100
+	obj.IncrementLFU()
+	if freq := obj.GetLFU(); freq < 41 || freq > 51 {
+		t.Errorf("LFU counter of 100 idle for five minutes expected about 51, got %d", freq)
+	}
+}
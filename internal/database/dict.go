@@ -45,11 +45,15 @@ const (
 	// Initial hash table size
 	dictInitialSize = 4
 
-	// When rehashing, move this many entries per operation
+	// When rehashing, move this many buckets per operation
 	dictRehashSteps = 1
 
-	// Force rehash if used/size ratio exceeds this
-	dictForceResizeRatio = 5
+	// A rehash step visits at most this many empty buckets per bucket to
+	// move, so that a sparse table does not make one operation slow
+	dictEmptyVisits = 10
+
+	// Shrink the table when less than this percentage of it is used
+	dictMinFill = 10
 )
 
 // NewDict creates a new dictionary
@@ -122,9 +126,7 @@ func (d *Dict) Set(key string, value interface{}) {
 	defer d.mu.Unlock()
 
 	// Perform incremental rehash if needed
-	if d.isRehashing() {
-		d.rehash(1)
-	}
+	d.rehashStep()
 
 	// Try to update existing key
 	for i := 0; i < 2; i++ {
@@ -150,13 +152,7 @@ func (d *Dict) Set(key string, value interface{}) {
 	}
 
 	// Key doesn't exist, add new entry
-	d.addToHT(0, key, value)
-	d.size++
-
-	// Check if we need to expand
-	if d.ht[0].used >= d.ht[0].size {
-		d.expand()
-	}
+	d.addEntry(key, value)
 }
 
 // SetNX sets a key-value pair only if key doesn't exist
@@ -164,9 +160,7 @@ func (d *Dict) SetNX(key string, value interface{}) bool {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if d.isRehashing() {
-		d.rehash(1)
-	}
+	d.rehashStep()
 
 	// Check if key exists
 	for i := 0; i < 2; i++ {
@@ -190,12 +184,7 @@ func (d *Dict) SetNX(key string, value interface{}) bool {
 	}
 
 	// Add new entry
-	d.addToHT(0, key, value)
-	d.size++
-
-	if d.ht[0].used >= d.ht[0].size {
-		d.expand()
-	}
+	d.addEntry(key, value)
 
 	return true
 }
@@ -205,9 +194,7 @@ func (d *Dict) Delete(key string) bool {
 	d.mu.Lock()
 	defer d.mu.Unlock()
 
-	if d.isRehashing() {
-		d.rehash(1)
-	}
+	d.rehashStep()
 
 	for i := 0; i < 2; i++ {
 		if d.ht[i].used == 0 {
@@ -228,6 +215,7 @@ func (d *Dict) Delete(key string) bool {
 				}
 				d.ht[i].used--
 				d.size--
+				d.shrink()
 				return true
 			}
 			prev = ent
@@ -361,15 +349,28 @@ func (d *Dict) isRehashing() bool {
 	return d.rehashIdx != -1
 }
 
-// expand expands the hash table
+// expand doubles the hash table
 func (d *Dict) expand() {
-	// Calculate new size (next power of 2)
-	newSize := d.ht[0].size * 2
-	if newSize > dictForceResizeRatio*dictInitialSize && d.ht[0].used < dictForceResizeRatio {
+	// Starting over would drop the entries already moved to the new table
+	if d.isRehashing() {
+		return
+	}
+
+	d.rehashTo(int(d.ht[0].size * 2))
+}
+
+// shrink resizes a sparsely used table to the smallest power of 2 holding
+// its entries
+func (d *Dict) shrink() {
+	if d.isRehashing() || d.ht[0].size <= dictInitialSize || d.ht[0].used*100/d.ht[0].size >= dictMinFill {
 		return
 	}
 
-	d.rehashTo(int(newSize))
+	size := uint64(dictInitialSize)
+	for size < d.ht[0].used {
+		size *= 2
+	}
+	d.rehashTo(int(size))
 }
 
 // rehashTo starts rehashing to a new table of the given size
@@ -385,35 +386,30 @@ func (d *Dict) rehashTo(size int) {
 	d.rehashIdx = 0
 }
 
-// rehash performs incremental rehashing
+// rehashStep moves one step of buckets to the new table, unless an
+// iterator is open: moving entries under it could make it miss some or
+// return them twice
+func (d *Dict) rehashStep() {
+	if d.isRehashing() && atomic.LoadUint32(&d.iterators) == 0 {
+		d.rehash(dictRehashSteps)
+	}
+}
+
+// rehash moves up to steps buckets of table 0 to table 1
 func (d *Dict) rehash(steps int) {
 	if d.rehashIdx == -1 {
 		return
 	}
 
-	for ; steps > 0; steps-- {
-		// Check if rehashing is complete
-		if d.ht[0].used == 0 {
-			// Swap tables
-			d.ht[0] = d.ht[1]
-			d.ht[1] = &dictTable{
-				table:    nil,
-				size:     0,
-				sizemask: 0,
-				used:     0,
-			}
-			d.rehashIdx = -1
-			return
-		}
-
-		// Find next non-empty slot in table 0
-		for uint64(d.rehashIdx) < d.ht[0].size && d.ht[0].table[d.rehashIdx] == nil {
+	emptyVisits := steps * dictEmptyVisits
+	for ; steps > 0 && d.ht[0].used > 0; steps-- {
+		// Find the next non-empty bucket of table 0. There is one, as the
+		// buckets before rehashIdx are all empty.
+		for d.ht[0].table[d.rehashIdx] == nil {
 			d.rehashIdx++
-		}
-		// Check if we've gone past the end
-		if uint64(d.rehashIdx) >= d.ht[0].size {
-			// Shouldn't happen if used > 0, but return safely
-			return
+			if emptyVisits--; emptyVisits == 0 {
+				return
+			}
 		}
 
 		// Move all entries from this slot
@@ -437,6 +433,70 @@ func (d *Dict) rehash(steps int) {
 		d.ht[0].table[d.rehashIdx] = nil
 		d.rehashIdx++
 	}
+
+	// Check if rehashing is complete
+	if d.ht[0].used == 0 {
+		// Swap tables
+		d.ht[0] = d.ht[1]
+		d.ht[1] = &dictTable{
+			table:    nil,
+			size:     0,
+			sizemask: 0,
+			used:     0,
+		}
+		d.rehashIdx = -1
+	}
+}
+
+// DictStats describes the hash tables of a dictionary
+type DictStats struct {
+	// RehashIdx is the next bucket of table 0 to move, or -1 if the
+	// dictionary is not rehashing
+	RehashIdx int
+	Tables    [2]DictTableStats
+}
+
+// DictTableStats describes one hash table
+type DictTableStats struct {
+	Size        uint64 // Number of buckets
+	Used        uint64 // Number of entries
+	MaxChainLen int
+}
+
+// Stats returns the sizes of the hash tables and the rehash progress
+func (d *Dict) Stats() DictStats {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+
+	stats := DictStats{RehashIdx: d.rehashIdx}
+	for i, ht := range d.ht {
+		stats.Tables[i] = DictTableStats{Size: ht.size, Used: ht.used}
+		for _, ent := range ht.table {
+			n := 0
+			for ; ent != nil; ent = ent.next {
+				n++
+			}
+			stats.Tables[i].MaxChainLen = max(stats.Tables[i].MaxChainLen, n)
+		}
+	}
+	return stats
+}
+
+// addEntry adds a new key, growing the table if needed. While rehashing,
+// new keys go to the new table so the old one drains.
+func (d *Dict) addEntry(key string, value interface{}) {
+	if d.isRehashing() {
+		d.addToHT(1, key, value)
+		d.size++
+		return
+	}
+
+	d.addToHT(0, key, value)
+	d.size++
+
+	if d.ht[0].used >= d.ht[0].size {
+		d.expand()
+	}
 }
 
 // addToHT adds an entry to the specified hash table
@@ -521,16 +581,16 @@ func murmur64(data []byte) uint64 {
 // fastrandn returns a random number in [0, n)
 func fastrandn(n uint64) uint64 {
 	// Simple xorshift RNG
-	seed := atomic.LoadUint64(&randSeed)
 	for {
+		old := atomic.LoadUint64(&randSeed)
+		seed := old
 		seed ^= seed << 13
 		seed ^= seed >> 17
 		seed ^= seed << 5
-		if atomic.CompareAndSwapUint64(&randSeed, seed, seed) {
-			break
+		if atomic.CompareAndSwapUint64(&randSeed, old, seed) {
+			return seed % n
 		}
 	}
-	return seed % n
 }
 
 var randSeed uint64 = 1
@@ -559,40 +619,25 @@ type DictIterator struct {
 
 // Next moves to the next entry
 func (it *DictIterator) Next() bool {
-	if it.ent != nil && it.ent.next != nil {
-		it.ent = it.ent.next
-		return true
-	}
-
-	it.dict.mu.Lock()
-	defer it.dict.mu.Unlock()
+	it.dict.mu.RLock()
+	defer it.dict.mu.RUnlock()
 
-	for {
-		if it.table >= 2 {
-			return false
+	if it.ent != nil {
+		if it.ent = it.ent.next; it.ent != nil {
+			return true
 		}
+		it.bucket++
+	}
 
+	for ; it.table < 2; it.table, it.bucket = it.table+1, 0 {
 		table := it.dict.ht[it.table]
-		if table == nil || table.table == nil {
-			it.table++
-			it.bucket = 0
-			continue
-		}
-
-		if it.bucket >= table.size {
-			it.table++
-			it.bucket = 0
-			continue
-		}
-
-		it.bucket++
-		if it.bucket < table.size {
-			it.ent = table.table[it.bucket]
-			if it.ent != nil {
+		for ; it.bucket < table.size; it.bucket++ {
+			if it.ent = table.table[it.bucket]; it.ent != nil {
 				return true
 			}
 		}
 	}
+	return false
 }
 
 // Entry returns the current entry
@@ -0,0 +1,158 @@
+package database
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDictKeepsKeysAcrossRehash(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 1000; i++ {
+		d.Set("key:"+strconv.Itoa(i), i)
+
+		// Every key added so far must still be reachable
+		for j := 0; j <= i; j++ {
+			if v, ok := d.Get("key:" + strconv.Itoa(j)); !ok || v != j {
+				t.Fatalf("after adding %d keys, key:%d expected %d, got %v", i+1, j, j, v)
+			}
+		}
+	}
+
+	if n := d.Len(); n != 1000 {
+		t.Errorf("Len expected 1000, got %d", n)
+	}
+	if n := len(d.Keys()); n != 1000 {
+		t.Errorf("Keys expected 1000 keys, got %d", n)
+	}
+
+	for i := 0; i < 1000; i += 2 {
+		if !d.Delete("key:" + strconv.Itoa(i)) {
+			t.Fatalf("Delete key:%d failed", i)
+		}
+	}
+	if n := d.Len(); n != 500 {
+		t.Errorf("Len after deletes expected 500, got %d", n)
+	}
+}
+
+func TestDictRandomKey(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 100; i++ {
+		d.Set("key:"+strconv.Itoa(i), i)
+	}
+
+	seen := make(map[string]bool)
+	for i := 0; i < 1000; i++ {
+		key, ok := d.RandomKey()
+		if !ok {
+			t.Fatal("RandomKey on a non-empty dict returned no key")
+		}
+		if !d.Exists(key) {
+			t.Fatalf("RandomKey returned unknown key %q", key)
+		}
+		seen[key] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("RandomKey expected varied keys, got %d distinct", len(seen))
+	}
+}
+
+func TestDictRehashIsIncremental(t *testing.T) {
+	d := NewDict()
+	i := 0
+	for ; d.Stats().RehashIdx == -1 || d.Stats().Tables[0].Size < 1024; i++ {
+		d.Set("key:"+strconv.Itoa(i), i)
+	}
+
+	// Growing only allocates the new table; operations move the buckets
+	stats := d.Stats()
+	if stats.Tables[1].Size != 2*stats.Tables[0].Size || stats.Tables[1].Used > 1 {
+		t.Fatalf("rehash started with tables %+v", stats.Tables)
+	}
+	for n := 0; d.Stats().RehashIdx != -1; n++ {
+		if n > int(stats.Tables[0].Size) {
+			t.Fatal("rehash did not finish after one operation per bucket")
+		}
+		d.Set("key:"+strconv.Itoa(i), i)
+		i++
+	}
+	if stats := d.Stats(); stats.Tables[0].Used != uint64(i) || stats.Tables[1].Size != 0 {
+		t.Errorf("after rehashing expected %d keys in table 0, got %+v", i, stats.Tables)
+	}
+
+	// Deleting most keys shrinks the table
+	for j := 8; j < i; j++ {
+		d.Delete("key:" + strconv.Itoa(j))
+	}
+	for d.Stats().RehashIdx != -1 {
+		d.Delete("missing")
+	}
+	if stats := d.Stats(); stats.Tables[0].Size > 256 || stats.Tables[0].Used != 8 {
+		t.Errorf("after deleting expected 8 keys in a smaller table, got %+v", stats.Tables[0])
+	}
+	for j := 0; j < 8; j++ {
+		if v, ok := d.Get("key:" + strconv.Itoa(j)); !ok || v != j {
+			t.Errorf("key:%d expected %d after shrinking, got %v", j, j, v)
+		}
+	}
+}
+
+func TestDictIterator(t *testing.T) {
+	d := NewDict()
+	for i := 0; i < 100; i++ {
+		d.Set("key:"+strconv.Itoa(i), i)
+	}
+
+	it := d.Iterator()
+	seen := make(map[string]bool)
+	for it.Next() {
+		key, value := it.Entry()
+		if value != d.mustGet(t, key) || seen[key] {
+			t.Fatalf("iterator returned %q=%v twice or with the wrong value", key, value)
+		}
+		seen[key] = true
+		// Rehashing is paused while the iterator is open
+		d.Set("new:"+key, 0)
+	}
+	it.Close()
+
+	for i := 0; i < 100; i++ {
+		if !seen["key:"+strconv.Itoa(i)] {
+			t.Errorf("iterator missed key:%d", i)
+		}
+	}
+}
+
+func (d *Dict) mustGet(t *testing.T, key string) interface{} {
+	t.Helper()
+	v, ok := d.Get(key)
+	if !ok {
+		t.Fatalf("key %q not found", key)
+	}
+	return v
+}
+
+// BenchmarkDictSet inserts 1M keys and reports the slowest insert: with
+// incremental rehashing it stays far below the cost of moving the whole
+// table at once
+func BenchmarkDictSet(b *testing.B) {
+	const n = 1000000
+	keys := make([]string, n)
+	for i := range keys {
+		keys[i] = "key:" + strconv.Itoa(i)
+	}
+
+	b.ResetTimer()
+	var slowest time.Duration
+	for i := 0; i < b.N; i++ {
+		d := NewDict()
+		for _, key := range keys {
+			start := time.Now()
+			d.Set(key, nil)
+			slowest = max(slowest, time.Since(start))
+		}
+	}
+	b.ReportMetric(float64(slowest.Nanoseconds()), "max-ns/set")
+	b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*n), "ns/set")
+}
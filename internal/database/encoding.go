@@ -0,0 +1,168 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package database
+
+import (
+	"sync"
+
+	"github.com/zyhnesmr/godis/internal/datastruct/hash"
+	"github.com/zyhnesmr/godis/internal/datastruct/list"
+	"github.com/zyhnesmr/godis/internal/datastruct/set"
+	"github.com/zyhnesmr/godis/internal/datastruct/zset"
+)
+
+// listpackSafetyLimit is the largest element a list keeps in listpack
+// encoding when its size limit is given as an entry count
+const listpackSafetyLimit = 8 * 1024
+
+// EncodingLimits holds the thresholds past which a hash, list or sorted set
+// leaves the compact listpack encoding, and a set leaves the intset encoding
+type EncodingLimits struct {
+	HashMaxEntries      int
+	HashMaxValue        int
+	ListMaxSize         int // Entry count if positive, size class (-1 = 4KB ... -5 = 64KB) if negative
+	ListCompressDepth   int // Nodes left uncompressed at each end of a quicklist; 0 disables compression
+	ZSetMaxEntries      int
+	ZSetMaxValue        int
+	SetMaxIntsetEntries int
+}
+
+// DefaultEncodingLimits returns the limits matching the default configuration
+func DefaultEncodingLimits() EncodingLimits {
+	return EncodingLimits{
+		HashMaxEntries:      hash.DefaultMaxListpackEntries,
+		HashMaxValue:        hash.DefaultMaxListpackValue,
+		ListMaxSize:         list.DefaultFill,
+		ListCompressDepth:   list.DefaultCompressDepth,
+		ZSetMaxEntries:      zset.DefaultMaxListpackEntries,
+		ZSetMaxValue:        zset.DefaultMaxListpackValue,
+		SetMaxIntsetEntries: set.DefaultMaxIntsetEntries,
+	}
+}
+
+var (
+	encodingLimitsMu sync.RWMutex
+	encodingLimits   = DefaultEncodingLimits()
+)
+
+// SetEncodingLimits sets the limits used to convert compact objects
+func SetEncodingLimits(limits EncodingLimits) {
+	encodingLimitsMu.Lock()
+	defer encodingLimitsMu.Unlock()
+	encodingLimits = limits
+
+	// Hashes, sets and sorted sets convert themselves as members are added
+	hash.SetMaxListpack(limits.HashMaxEntries, limits.HashMaxValue)
+	list.SetQuicklistOptions(limits.ListMaxSize, limits.ListCompressDepth)
+	set.SetMaxIntsetEntries(limits.SetMaxIntsetEntries)
+	zset.SetMaxListpack(limits.ZSetMaxEntries, limits.ZSetMaxValue)
+}
+
+// GetEncodingLimits returns the limits used to convert compact objects
+func GetEncodingLimits() EncodingLimits {
+	encodingLimitsMu.RLock()
+	defer encodingLimitsMu.RUnlock()
+	return encodingLimits
+}
+
+// listMaxValue returns the largest list element kept in listpack encoding.
+// With a negative size class it is also the most bytes the whole listpack
+// may take.
+func (l EncodingLimits) listMaxValue() int {
+	if l.ListMaxSize >= 0 {
+		return listpackSafetyLimit
+	}
+	class := -l.ListMaxSize
+	if class > 5 {
+		class = 5
+	}
+	return 4096 << (class - 1)
+}
+
+// listMaxEntries returns the most list elements kept in listpack encoding,
+// or 0 if the count is not limited
+func (l EncodingLimits) listMaxEntries() int {
+	if l.ListMaxSize > 0 {
+		return l.ListMaxSize
+	}
+	return 0
+}
+
+// listFits reports whether l, just extended with elems, still fits a listpack
+func (l EncodingLimits) listFits(lst *list.List, elems []string) bool {
+	if maxEntries := l.listMaxEntries(); maxEntries > 0 && lst.Len() > maxEntries {
+		return false
+	}
+	maxValue := l.listMaxValue()
+	for _, e := range elems {
+		if len(e) > maxValue {
+			return false
+		}
+	}
+	if l.ListMaxSize < 0 {
+		// A size class limits the whole listpack, e.g. -2 allows 8KB
+		return list.ListpackBytes(lst.ToSlice()) <= maxValue
+	}
+	return true
+}
+
+// ConvertIfNeeded moves a listpack list to its full encoding once it holds
+// too many entries or bytes, or any of the given elements (the values just
+// inserted) is too large. A hash or sorted set converts itself as it grows; the
+// object only follows its encoding. The conversion is one way: an object
+// never returns to listpack encoding.
+func (o *Object) ConvertIfNeeded(elems ...string) {
+	if o.Encoding != ObjEncodingListpack {
+		return
+	}
+
+	limits := GetEncodingLimits()
+	switch v := o.Ptr.(type) {
+	case *hash.Hash:
+		// A hash converts itself; follow its encoding
+		if v.Encoding() == hash.HashEncodingHashtable {
+			o.convertFromListpack()
+		}
+	case *list.List:
+		if !limits.listFits(v, elems) {
+			o.convertFromListpack()
+		}
+	case *zset.ZSet:
+		// A sorted set converts itself; follow its encoding
+		if v.Encoding() == zset.ZSetEncodingSkiplist {
+			o.convertFromListpack()
+		}
+	}
+}
+
+// FitEncoding checks every element of a listpack object against the limits,
+// for objects built in bulk such as store command results and loaded keys
+func (o *Object) FitEncoding() {
+	if o.Encoding != ObjEncodingListpack {
+		return
+	}
+
+	// The entry count alone may already decide
+	o.ConvertIfNeeded()
+	if o.Encoding != ObjEncodingListpack {
+		return
+	}
+
+	if l, ok := o.Ptr.(*list.List); ok {
+		o.ConvertIfNeeded(l.ToSlice()...)
+	}
+}
+
+// convertFromListpack switches the object to the full encoding of its type
+func (o *Object) convertFromListpack() {
+	switch o.Type {
+	case ObjTypeHash:
+		o.Encoding = ObjEncodingHashtable
+	case ObjTypeList:
+		o.Encoding = ObjEncodingQuicklist
+	case ObjTypeZSet:
+		o.Encoding = ObjEncodingSkiplist
+	}
+}
@@ -6,7 +6,9 @@ package database
 
 import (
 	"fmt"
+	"math"
 	"strconv"
+	"sync/atomic"
 	"time"
 
 	"github.com/zyhnesmr/godis/internal/datastruct/hash"
@@ -43,6 +45,7 @@ const (
 	ObjEncodingSkiplist
 	ObjEncodingQuicklist
 	ObjEncodingRadixTree
+	ObjEncodingListpack
 )
 
 // String returns the string representation of the object type
@@ -90,6 +93,8 @@ func (e ObjEncoding) String() string {
 		return "quicklist"
 	case ObjEncodingRadixTree:
 		return "radixtree"
+	case ObjEncodingListpack:
+		return "listpack"
 	default:
 		return "unknown"
 	}
@@ -120,7 +125,52 @@ type Object struct {
 	Type     ObjType
 	Encoding ObjEncoding
 	Ptr      interface{}
-	LRU      uint32 // LRU/LFU time field
+	LRU      uint32 // LRU/LFU time field, accessed atomically once the object is stored
+	shared   bool   // One of the pooled integers, referenced by many keys
+}
+
+const (
+	// SharedIntegers is the number of pooled integer objects, for the
+	// values 0 to SharedIntegers-1
+	SharedIntegers = 10000
+	// SharedRefCount is the reference count reported for a pooled object
+	SharedRefCount = math.MaxInt32
+)
+
+// sharedIntegers holds the pooled integer objects. They are immutable:
+// every key set to a small integer references the same object.
+var sharedIntegers [SharedIntegers]*Object
+
+// noSharedIntegers stops NewIntObject from handing out pooled objects. It
+// is set while an LRU or LFU policy evicts keys, which ranks each key by
+// the access data kept in its own object, as Redis does.
+var noSharedIntegers atomic.Bool
+
+// SetSharedIntegers sets whether NewIntObject may return pooled objects
+func SetSharedIntegers(enabled bool) {
+	noSharedIntegers.Store(!enabled)
+}
+
+func init() {
+	now := uint32(time.Now().Unix())
+	for i := range sharedIntegers {
+		sharedIntegers[i] = &Object{
+			Type:     ObjTypeString,
+			Encoding: ObjEncodingInt,
+			Ptr:      int64(i),
+			LRU:      now,
+			shared:   true,
+		}
+	}
+}
+
+// RefCount returns the reference count of the object: SharedRefCount for a
+// pooled integer, 1 otherwise
+func (o *Object) RefCount() int {
+	if o.shared {
+		return SharedRefCount
+	}
+	return 1
 }
 
 // NewObject creates a new object
@@ -133,20 +183,20 @@ func NewObject(objType ObjType, encoding ObjEncoding, ptr interface{}) *Object {
 	}
 }
 
-// NewStringObject creates a string object with optimal encoding
+// embstrMaxLen is the longest string kept in embstr encoding
+const embstrMaxLen = 44
+
+// NewStringObject creates a string object with optimal encoding: int for
+// the canonical form of an int64, embstr for short strings and raw for the
+// rest
 func NewStringObject(s string) *Object {
-	// Try to encode as integer
-	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-		return &Object{
-			Type:     ObjTypeString,
-			Encoding: ObjEncodingInt,
-			Ptr:      i,
-			LRU:      uint32(time.Now().Unix()),
-		}
+	// Try to encode as integer; "007" or "+7" would not read back the same
+	if i, ok := parseCanonicalInt(s); ok {
+		return NewIntObject(i)
 	}
 
 	// Use embstr for short strings
-	if len(s) <= 44 {
+	if len(s) <= embstrMaxLen {
 		return &Object{
 			Type:     ObjTypeString,
 			Encoding: ObjEncodingEmbstr,
@@ -156,6 +206,12 @@ func NewStringObject(s string) *Object {
 	}
 
 	// Use raw for longer strings
+	return NewRawStringObject(s)
+}
+
+// NewRawStringObject creates a string object in raw encoding, for values
+// modified in place such as by APPEND and SETRANGE
+func NewRawStringObject(s string) *Object {
 	return &Object{
 		Type:     ObjTypeString,
 		Encoding: ObjEncodingRaw,
@@ -164,8 +220,12 @@ func NewStringObject(s string) *Object {
 	}
 }
 
-// NewIntObject creates an integer string object
+// NewIntObject creates an integer string object, or returns the pooled
+// object for a small non-negative integer unless sharing is turned off
 func NewIntObject(i int64) *Object {
+	if i >= 0 && i < SharedIntegers && !noSharedIntegers.Load() {
+		return sharedIntegers[i]
+	}
 	return &Object{
 		Type:     ObjTypeString,
 		Encoding: ObjEncodingInt,
@@ -185,34 +245,7 @@ func NewBulkStringObject(b []byte) *Object {
 		}
 	}
 
-	s := string(b)
-
-	// Try to encode as integer
-	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
-		return &Object{
-			Type:     ObjTypeString,
-			Encoding: ObjEncodingInt,
-			Ptr:      i,
-			LRU:      uint32(time.Now().Unix()),
-		}
-	}
-
-	// Use embstr for short strings
-	if len(s) <= 44 {
-		return &Object{
-			Type:     ObjTypeString,
-			Encoding: ObjEncodingEmbstr,
-			Ptr:      s,
-			LRU:      uint32(time.Now().Unix()),
-		}
-	}
-
-	return &Object{
-		Type:     ObjTypeString,
-		Encoding: ObjEncodingRaw,
-		Ptr:      s,
-		LRU:      uint32(time.Now().Unix()),
-	}
+	return NewStringObject(string(b))
 }
 
 // String returns the string value
@@ -255,7 +288,9 @@ func (o *Object) Bytes() []byte {
 	}
 }
 
-// Int returns the value as int64
+// Int returns the value as int64. A string value must be the canonical
+// decimal form of the integer, as Redis requires: signs other than a
+// leading '-', leading zeros and surrounding whitespace are rejected.
 func (o *Object) Int() (int64, bool) {
 	if o == nil {
 		return 0, false
@@ -267,21 +302,33 @@ func (o *Object) Int() (int64, bool) {
 	case int:
 		return int64(v), true
 	case string:
-		i, err := strconv.ParseInt(v, 10, 64)
-		return i, err == nil
+		return parseCanonicalInt(v)
+	case []byte:
+		return parseCanonicalInt(string(v))
 	default:
 		return 0, false
 	}
 }
 
-// UpdateLRU updates the LRU/LFU timestamp
+// parseCanonicalInt parses s if it is the canonical decimal form of an
+// int64
+func parseCanonicalInt(s string) (int64, bool) {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil || strconv.FormatInt(i, 10) != s {
+		return 0, false
+	}
+	return i, true
+}
+
+// UpdateLRU updates the LRU/LFU timestamp. Reads of the key call it
+// concurrently, under the read lock of the database or none at all.
 func (o *Object) UpdateLRU() {
-	o.LRU = uint32(time.Now().Unix())
+	atomic.StoreUint32(&o.LRU, uint32(time.Now().Unix()))
 }
 
 // GetLRU returns the LRU/LFU timestamp
 func (o *Object) GetLRU() uint32 {
-	return o.LRU
+	return atomic.LoadUint32(&o.LRU)
 }
 
 // IncrementLFU increments the LFU counter
@@ -290,12 +337,15 @@ func (o *Object) IncrementLFU() {
 	const lfuDecayTime = 60 // seconds
 	const lfuLogFactor = 10
 
-	now := uint32(time.Now().Unix())
-	counter := o.LRU & 0xff
-	lastTime := o.LRU >> 8
+	// Minutes are kept modulo 2^16, like Redis's LFUGetTimeInMinutes, so
+	// that they fit above the counter
+	now := uint32(time.Now().Unix()/lfuDecayTime) & 0xffff
+	lru := atomic.LoadUint32(&o.LRU)
+	counter := lru & 0xff
+	lastTime := (lru >> 8) & 0xffff
 
-	// Calculate minutes since last access
-	minutes := (now - lastTime*lfuDecayTime) / lfuDecayTime
+	// Calculate minutes since last access, across a wrap of the clock
+	minutes := (now - lastTime) & 0xffff
 	if minutes > 0 {
 		// Decay counter
 		if minutes > lfuLogFactor {
@@ -311,18 +361,20 @@ func (o *Object) IncrementLFU() {
 		counter++
 	}
 
-	// Pack time (in minutes) and counter
-	o.LRU = (now/lfuDecayTime)<<8 | counter
+	// Pack time (in minutes) and counter. An access counted concurrently
+	// may be lost, which the probabilistic counter tolerates.
+	atomic.StoreUint32(&o.LRU, now<<8|counter)
 }
 
 // GetLFU returns the LFU counter
 func (o *Object) GetLFU() uint8 {
-	return uint8(o.LRU & 0xff)
+	return uint8(o.GetLRU() & 0xff)
 }
 
 // TryEncodingRaw tries to convert an object to raw encoding
 func (o *Object) TryEncodingRaw() bool {
-	if o.Encoding != ObjEncodingInt {
+	// A pooled integer is shared by other keys and must not change
+	if o.Encoding != ObjEncodingInt || o.shared {
 		return false
 	}
 
@@ -367,12 +419,12 @@ func (o *Object) Size() int64 {
 	}
 }
 
-// NewHashObject creates a hash object
+// NewHashObject creates an empty hash object in listpack encoding
 func NewHashObject() *Object {
 	h := hash.NewHash()
 	return &Object{
 		Type:     ObjTypeHash,
-		Encoding: ObjEncodingHashtable,
+		Encoding: ObjEncodingListpack,
 		Ptr:      h,
 		LRU:      uint32(time.Now().Unix()),
 	}
@@ -386,12 +438,12 @@ func (o *Object) GetHash() (interface{}, bool) {
 	return o.Ptr, true
 }
 
-// NewListObject creates a list object
+// NewListObject creates an empty list object in listpack encoding
 func NewListObject() *Object {
 	l := list.NewList()
 	return &Object{
 		Type:     ObjTypeList,
-		Encoding: ObjEncodingLinkedList,
+		Encoding: ObjEncodingListpack,
 		Ptr:      l,
 		LRU:      uint32(time.Now().Unix()),
 	}
@@ -402,7 +454,7 @@ func NewSetObject() *Object {
 	s := set.NewSet()
 	return &Object{
 		Type:     ObjTypeSet,
-		Encoding: ObjEncodingHashtable,
+		Encoding: ObjEncodingIntset,
 		Ptr:      s,
 		LRU:      uint32(time.Now().Unix()),
 	}
@@ -419,20 +471,24 @@ func (o *Object) GetSet() (interface{}, bool) {
 // NewSetObjectFromSlice creates a set object from a slice
 func NewSetObjectFromSlice(items []string) *Object {
 	s := set.NewSetFromSlice(items)
+	encoding := ObjEncodingHashtable
+	if s.Encoding() == set.SetEncodingIntset {
+		encoding = ObjEncodingIntset
+	}
 	return &Object{
 		Type:     ObjTypeSet,
-		Encoding: ObjEncodingHashtable,
+		Encoding: encoding,
 		Ptr:      s,
 		LRU:      uint32(time.Now().Unix()),
 	}
 }
 
-// NewZSetObject creates a sorted set object
+// NewZSetObject creates an empty sorted set object in listpack encoding
 func NewZSetObject() *Object {
 	zs := zset.NewZSet()
 	return &Object{
 		Type:     ObjTypeZSet,
-		Encoding: ObjEncodingSkiplist,
+		Encoding: ObjEncodingListpack,
 		Ptr:      zs,
 		LRU:      uint32(time.Now().Unix()),
 	}
@@ -464,3 +520,108 @@ func (o *Object) GetStream() (interface{}, bool) {
 	}
 	return o.Ptr, true
 }
+
+// DeepCopy returns a deep copy of the object, so that changes to the copy
+// never reach the original. Every object type has its own branch; an error
+// is returned for types that cannot be copied.
+func (o *Object) DeepCopy() (*Object, error) {
+	clone := &Object{
+		Type:     o.Type,
+		Encoding: o.Encoding,
+		LRU:      uint32(time.Now().Unix()),
+	}
+
+	switch o.Type {
+	case ObjTypeString:
+		switch v := o.Ptr.(type) {
+		case string, int64:
+			clone.Ptr = v
+		case []byte:
+			clone.Ptr = append([]byte(nil), v...)
+		default:
+			return nil, fmt.Errorf("cannot copy string value of type %T", o.Ptr)
+		}
+	case ObjTypeList:
+		l, ok := o.Ptr.(*list.List)
+		if !ok {
+			return nil, fmt.Errorf("cannot copy list value of type %T", o.Ptr)
+		}
+		nl := list.NewList()
+		for _, v := range l.ToSlice() {
+			nl.PushRight(v)
+		}
+		clone.Ptr = nl
+	case ObjTypeHash:
+		h, ok := o.Ptr.(*hash.Hash)
+		if !ok {
+			return nil, fmt.Errorf("cannot copy hash value of type %T", o.Ptr)
+		}
+		nh := hash.NewHash()
+		pairs := h.GetAll()
+		for i := 0; i+1 < len(pairs); i += 2 {
+			nh.Set(pairs[i], pairs[i+1])
+		}
+		for field, at := range h.FieldExpires() {
+			nh.SetFieldExpireAt(field, at)
+		}
+		clone.Ptr = nh
+	case ObjTypeSet:
+		s, ok := o.Ptr.(*set.Set)
+		if !ok {
+			return nil, fmt.Errorf("cannot copy set value of type %T", o.Ptr)
+		}
+		clone.Ptr = set.NewSetFromSlice(s.Members())
+	case ObjTypeZSet:
+		zs, ok := o.Ptr.(*zset.ZSet)
+		if !ok {
+			return nil, fmt.Errorf("cannot copy zset value of type %T", o.Ptr)
+		}
+		nzs := zset.NewZSet()
+		nzs.AddMultiple(zs.Range(0, -1))
+		clone.Ptr = nzs
+	case ObjTypeStream:
+		s, ok := o.Ptr.(*stream.Stream)
+		if !ok {
+			return nil, fmt.Errorf("cannot copy stream value of type %T", o.Ptr)
+		}
+		ns, err := copyStream(s)
+		if err != nil {
+			return nil, err
+		}
+		clone.Ptr = ns
+	default:
+		return nil, fmt.Errorf("cannot copy value of type %s", o.Type)
+	}
+
+	return clone, nil
+}
+
+// copyStream returns a deep copy of s, including its consumer groups and
+// their pending entries lists
+func copyStream(s *stream.Stream) (*stream.Stream, error) {
+	ns := stream.NewStream()
+	for _, e := range s.GetEntries() {
+		if err := ns.AddWithID(e.ID, e.GetFields()); err != nil {
+			return nil, err
+		}
+	}
+	ns.SetLastID(s.GetLastID())
+	ns.SetEntriesAdded(s.EntriesAdded())
+	ns.SetMaxDeletedID(s.MaxDeletedID())
+
+	cgroups := ns.GetConsumerGroupManager()
+	for name, group := range s.GetConsumerGroupManager().GetGroups() {
+		if err := cgroups.CreateGroup(name, group.GetLastID()); err != nil {
+			return nil, err
+		}
+		ng, _ := cgroups.GetGroup(name)
+		ng.SetEntriesRead(group.GetEntriesRead())
+		for consumer := range group.GetConsumers() {
+			ng.CreateConsumer(consumer)
+		}
+		for _, pe := range group.GetPending() {
+			ng.RestorePending(pe)
+		}
+	}
+	return ns, nil
+}
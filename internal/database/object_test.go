@@ -0,0 +1,176 @@
+package database
+
+import (
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/datastruct/hash"
+	"github.com/zyhnesmr/godis/internal/datastruct/list"
+	"github.com/zyhnesmr/godis/internal/datastruct/set"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
+	"github.com/zyhnesmr/godis/internal/datastruct/zset"
+	"github.com/zyhnesmr/godis/internal/eviction"
+)
+
+// deepCopy copies obj, failing the test on error
+func deepCopy(t *testing.T, obj *Object) *Object {
+	t.Helper()
+	cp, err := obj.DeepCopy()
+	if err != nil {
+		t.Fatalf("DeepCopy: %v", err)
+	}
+	return cp
+}
+
+func TestDeepCopyString(t *testing.T) {
+	obj := NewObject(ObjTypeString, ObjEncodingRaw, []byte("hello"))
+	cp := deepCopy(t, obj)
+
+	cp.Ptr.([]byte)[0] = 'j'
+	if got := string(obj.Ptr.([]byte)); got != "hello" {
+		t.Errorf("original expected hello, got %s", got)
+	}
+}
+
+func TestDeepCopyList(t *testing.T) {
+	obj := NewListObject()
+	l := obj.Ptr.(*list.List)
+	l.PushRight("a")
+	l.PushRight("b")
+
+	cl := deepCopy(t, obj).Ptr.(*list.List)
+	cl.Set(0, "x")
+	cl.PushRight("c")
+
+	if got := l.ToSlice(); len(got) != 2 || got[0] != "a" || got[1] != "b" {
+		t.Errorf("original expected [a b], got %v", got)
+	}
+}
+
+func TestDeepCopyHash(t *testing.T) {
+	obj := NewHashObject()
+	h := obj.Ptr.(*hash.Hash)
+	h.Set("f1", "v1")
+	h.Set("f2", "v2")
+	at := time.Now().Add(time.Hour).UnixMilli()
+	h.SetFieldExpireAt("f2", at)
+
+	ch := deepCopy(t, obj).Ptr.(*hash.Hash)
+	if got := ch.FieldExpireAt("f2"); got != at {
+		t.Errorf("copied field TTL expected %d, got %d", at, got)
+	}
+	ch.Set("f1", "changed")
+	ch.Del("f2")
+	ch.Set("f3", "v3")
+
+	if v, _ := h.Get("f1"); v != "v1" {
+		t.Errorf("original f1 expected v1, got %s", v)
+	}
+	if h.Len() != 2 {
+		t.Errorf("original expected 2 fields, got %d", h.Len())
+	}
+	if got := h.FieldExpireAt("f2"); got != at {
+		t.Errorf("original field TTL expected %d, got %d", at, got)
+	}
+}
+
+func TestDeepCopySet(t *testing.T) {
+	obj := NewSetObjectFromSlice([]string{"a", "b"})
+	s := obj.Ptr.(*set.Set)
+
+	cs := deepCopy(t, obj).Ptr.(*set.Set)
+	cs.Remove("a")
+	cs.Add("c")
+
+	if !s.Contains("a") || s.Contains("c") || s.Len() != 2 {
+		t.Errorf("original expected {a b}, got %v", s.Members())
+	}
+}
+
+func TestDeepCopyZSet(t *testing.T) {
+	obj := NewZSetObject()
+	zs := obj.Ptr.(*zset.ZSet)
+	zs.Add("a", 1)
+	zs.Add("b", 2)
+
+	czs := deepCopy(t, obj).Ptr.(*zset.ZSet)
+	czs.Add("a", 10)
+	czs.Remove("b")
+	czs.Add("c", 3)
+
+	if score, _ := zs.Score("a"); score != 1 {
+		t.Errorf("original score of a expected 1, got %v", score)
+	}
+	if _, ok := zs.Score("b"); !ok || zs.Len() != 2 {
+		t.Errorf("original expected members a and b, got %d members", zs.Len())
+	}
+	if r := zs.Range(0, -1); r[0].Member != "a" || r[1].Member != "b" {
+		t.Errorf("original order expected a b, got %s %s", r[0].Member, r[1].Member)
+	}
+}
+
+func TestDeepCopyStream(t *testing.T) {
+	obj := NewStreamObject()
+	s := obj.Ptr.(*stream.Stream)
+	id := s.Add(map[string]string{"f": "v"})
+	if err := s.GetConsumerGroupManager().CreateGroup("g", stream.NewStreamID(0, 0)); err != nil {
+		t.Fatal(err)
+	}
+	group, _ := s.GetConsumerGroupManager().GetGroup("g")
+	group.AddPendingID("alice", id, 1)
+
+	cs := deepCopy(t, obj).Ptr.(*stream.Stream)
+	cgroup, ok := cs.GetConsumerGroupManager().GetGroup("g")
+	if !ok || cgroup.PendingCount() != 1 {
+		t.Fatal("copy expected group g with one pending entry")
+	}
+	cs.Add(map[string]string{"f": "v2"})
+	cs.DeleteByID([]stream.StreamID{id})
+	cgroup.Ack(id)
+	cs.GetConsumerGroupManager().DeleteGroup("g")
+
+	if s.Length() != 1 || s.FindByID(id) == nil {
+		t.Errorf("original expected entry %s only, got length %d", id, s.Length())
+	}
+	if group.PendingCount() != 1 {
+		t.Errorf("original pending expected 1, got %d", group.PendingCount())
+	}
+	if _, ok := s.GetConsumerGroupManager().GetGroup("g"); !ok {
+		t.Error("original group g expected to remain")
+	}
+}
+
+func TestNoSharedIntegersUnderAccessPolicies(t *testing.T) {
+	t.Cleanup(func() { SetSharedIntegers(true) })
+
+	if NewIntObject(100) != NewIntObject(100) {
+		t.Fatal("small integers expected to be pooled without an eviction policy")
+	}
+
+	s := NewDBSelectorWithEviction(1, eviction.PolicyAllKeysLFU, 1<<30)
+	db, _ := s.GetDB(0)
+	db.Set("a", NewStringObject("100"))
+	db.Set("b", NewStringObject("100"))
+	a, _ := db.Get("a")
+	b, _ := db.Get("b")
+	if a == b || a.RefCount() != 1 {
+		t.Fatal("keys expected their own integer objects under an LFU policy")
+	}
+	for i := 0; i < 10; i++ {
+		db.TouchKeys(true, "a")
+	}
+	if b.GetLFU() == a.GetLFU() {
+		t.Errorf("accesses of a expected not to count for b, both at %d", a.GetLFU())
+	}
+
+	// Pooling comes back with a policy that doesn't rank by access
+	s.SetEvictionPolicy(eviction.PolicyAllKeysRandom)
+	if NewIntObject(100) != NewIntObject(100) {
+		t.Error("small integers expected to be pooled again under allkeys-random")
+	}
+	s.SetEvictionPolicy(eviction.PolicyVolatileLRU)
+	s.SetMaxMemory(0)
+	if NewIntObject(100) != NewIntObject(100) {
+		t.Error("small integers expected to be pooled again without maxmemory")
+	}
+}
@@ -7,6 +7,7 @@ package database
 import (
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/eviction"
 )
@@ -43,6 +44,8 @@ func NewDBSelector(count int) *DBSelector {
 
 	// Initialize eviction manager
 	s.evictionMgr = eviction.NewManager(eviction.PolicyNoEviction, 0, 5)
+	s.evictionMgr.SetMemoryUsageCallback(s.GetTotalMemoryUsage)
+	s.updateSharedIntegers()
 
 	return s
 }
@@ -65,6 +68,8 @@ func NewDBSelectorWithEviction(count int, policyType eviction.PolicyType, maxMem
 	}
 
 	s.evictionMgr = eviction.NewManager(policyType, maxMemory, 5)
+	s.evictionMgr.SetMemoryUsageCallback(s.GetTotalMemoryUsage)
+	s.updateSharedIntegers()
 
 	return s
 }
@@ -136,14 +141,18 @@ func (s *DBSelector) Stats() []DBStats {
 	return stats
 }
 
-// ActiveExpireAll actively expires keys across all databases
-func (s *DBSelector) ActiveExpireAll(limitPerDB int) int {
+// ActiveExpireAll runs an active expire cycle on every database, sampling
+// samplePerDB keys per round, until deadline
+func (s *DBSelector) ActiveExpireAll(samplePerDB int, deadline time.Time) int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
 	totalExpired := 0
 	for _, db := range s.dbs {
-		totalExpired += db.ActiveExpire(limitPerDB)
+		totalExpired += db.ActiveExpire(samplePerDB, deadline)
+		if !time.Now().Before(deadline) {
+			break
+		}
 	}
 
 	return totalExpired
@@ -159,6 +168,7 @@ func (s *DBSelector) GetEvictionManager() *eviction.Manager {
 // SetEvictionPolicy sets the eviction policy
 func (s *DBSelector) SetEvictionPolicy(policyType eviction.PolicyType) {
 	s.evictionMgr.SetPolicy(policyType)
+	s.updateSharedIntegers()
 }
 
 // GetEvictionPolicy returns the current eviction policy
@@ -173,6 +183,14 @@ func (s *DBSelector) SetMaxMemory(maxMemory int64) {
 	s.mu.Unlock()
 
 	s.evictionMgr.SetMaxMemory(maxMemory)
+	s.updateSharedIntegers()
+}
+
+// updateSharedIntegers turns off the pooled integers while an LRU or LFU
+// policy evicts keys: a pooled object would share one key's accesses with
+// every key holding the same value
+func (s *DBSelector) updateSharedIntegers() {
+	SetSharedIntegers(s.GetMaxMemory() == 0 || !s.GetEvictionPolicy().RanksByAccess())
 }
 
 // GetMaxMemory returns the maximum memory limit
@@ -236,18 +254,19 @@ func (s *DBSelector) SetTransactionManager(txManager any) {
 	s.txManager = txManager
 
 	// Set dirty key callback for each database
-	for _, db := range s.dbs {
-		db.SetDirtyKeyCallback(s.createDirtyKeyCallback())
+	for i, db := range s.dbs {
+		db.SetDirtyKeyCallback(s.createDirtyKeyCallback(i))
 	}
 }
 
 // createDirtyKeyCallback creates a callback function for marking dirty keys
-func (s *DBSelector) createDirtyKeyCallback() DirtyKeyCallback {
+// of database index
+func (s *DBSelector) createDirtyKeyCallback(index int) DirtyKeyCallback {
 	return func(key string) {
 		if s.txManager != nil {
 			// Use type assertion to call MarkDirty
-			if mgr, ok := s.txManager.(interface{ MarkDirty(key string) }); ok {
-				mgr.MarkDirty(key)
+			if mgr, ok := s.txManager.(interface{ MarkDirty(db int, key string) }); ok {
+				mgr.MarkDirty(index, key)
 			}
 		}
 	}
@@ -12,10 +12,10 @@ import (
 const (
 	// Earth radius in meters
 	EarthRadius = 6372797.560856
-	// Miles per meter
-	MilesPerMeter = 0.000621371
-	// Feet per meter
-	FeetPerMeter = 3.28084
+	// Meters per mile, as used by Redis
+	MetersPerMile = 1609.34
+	// Meters per foot
+	MetersPerFoot = 0.3048
 	// Longitude range
 	MinLongitude = -180
 	MaxLongitude = 180
@@ -58,9 +58,9 @@ func ToMeters(dist float64, unit DistanceUnit) float64 {
 	case Kilometers:
 		return dist * 1000
 	case Miles:
-		return dist / MilesPerMeter
+		return dist * MetersPerMile
 	case Feet:
-		return dist / FeetPerMeter
+		return dist * MetersPerFoot
 	default: // Meters
 		return dist
 	}
@@ -72,66 +72,83 @@ func FromMeters(meters float64, unit DistanceUnit) float64 {
 	case Kilometers:
 		return meters / 1000
 	case Miles:
-		return meters * MilesPerMeter
+		return meters / MetersPerMile
 	case Feet:
-		return meters * FeetPerMeter
+		return meters / MetersPerFoot
 	default: // Meters
 		return meters
 	}
 }
 
+// GeoHashStep is the number of bits used per coordinate (26 * 2 = 52 bits)
+const GeoHashStep = 26
+
 // EncodeToScore encodes a longitude and latitude to a 52-bit score for ZSet
 // This matches Redis's geohash encoding
 func EncodeToScore(longitude, latitude float64) float64 {
-	// Normalize to 0-1 range
-	lonNorm := (longitude - MinLongitude) / (MaxLongitude - MinLongitude)
-	latNorm := (latitude - MinLatitude) / (MaxLatitude - MinLatitude)
-
-	// Interleave bits: 52 bits total, 26 for each dimension
-	var score uint64
-
-	// We use 26 bits for precision
-	for i := 0; i < 26; i++ {
-		// Get the i-th bit from the high end of normalized values
-		// lonBit is bit i of the 26-bit longitude value
-		lonVal := uint64(lonNorm * float64(1<<26))
-		lonBit := (lonVal >> (25 - i)) & 1
-
-		// latBit is bit i of the 26-bit latitude value
-		latVal := uint64(latNorm * float64(1<<26))
-		latBit := (latVal >> (25 - i)) & 1
-
-		// Interleave: even bits are longitude, odd bits are latitude
-		score = (score << 1) | lonBit
-		score = (score << 1) | latBit
-	}
+	return float64(EncodeHash(longitude, latitude))
+}
 
-	return float64(score)
+// EncodeHash encodes a longitude and latitude to the 52-bit interleaved
+// geohash Redis stores as the sorted set score (and returns for WITHHASH)
+func EncodeHash(longitude, latitude float64) uint64 {
+	return encodeWithRange(longitude, latitude, MinLongitude, MaxLongitude, MinLatitude, MaxLatitude)
 }
 
-// DecodeFromScore decodes a score back to longitude and latitude
-func DecodeFromScore(score float64) (longitude, latitude float64) {
-	bits := uint64(score)
+// encodeWithRange encodes coordinates within the given ranges using
+// GeoHashStep bits per axis. Latitude occupies the even bits and longitude
+// the odd bits, as in Redis's interleave64.
+func encodeWithRange(longitude, latitude, minLon, maxLon, minLat, maxLat float64) uint64 {
+	lonOffset := (longitude - minLon) / (maxLon - minLon)
+	latOffset := (latitude - minLat) / (maxLat - minLat)
 
-	var lonBits uint64
-	var latBits uint64
+	lonBits := uint32(lonOffset * float64(uint64(1)<<GeoHashStep))
+	latBits := uint32(latOffset * float64(uint64(1)<<GeoHashStep))
 
-	// De-interleave bits
-	for i := 0; i < 26; i++ {
-		lonBit := (bits >> (51 - 2*i)) & 1
-		latBit := (bits >> (50 - 2*i)) & 1
+	return interleave(latBits, lonBits)
+}
 
-		lonBits = (lonBits << 1) | lonBit
-		latBits = (latBits << 1) | latBit
+// interleave interleaves the bits of x and y; x takes the even positions
+func interleave(x, y uint32) uint64 {
+	var result uint64
+	for i := 0; i < 32; i++ {
+		result |= uint64((x>>i)&1) << (2 * i)
+		result |= uint64((y>>i)&1) << (2*i + 1)
 	}
+	return result
+}
 
-	// Convert back to normalized values
-	lonNorm := float64(lonBits) / float64(1<<26)
-	latNorm := float64(latBits) / float64(1<<26)
+// deinterleave reverses interleave, returning the even and odd bits
+func deinterleave(bits uint64) (x, y uint32) {
+	for i := 0; i < 32; i++ {
+		x |= uint32((bits>>(2*i))&1) << i
+		y |= uint32((bits>>(2*i+1))&1) << i
+	}
+	return x, y
+}
 
-	// Convert back to actual coordinates
-	longitude = MinLongitude + lonNorm*(MaxLongitude-MinLongitude)
-	latitude = MinLatitude + latNorm*(MaxLatitude-MinLatitude)
+// HashFromScore returns the 52-bit geohash held in a sorted set score. The
+// hash is below 2^53, so the float64 score represents it exactly.
+func HashFromScore(score float64) uint64 {
+	return uint64(score) & (1<<GeoHashBits - 1)
+}
+
+// DecodeFromScore decodes a score back to longitude and latitude
+// The returned point is the center of the geohash cell, as in Redis
+func DecodeFromScore(score float64) (longitude, latitude float64) {
+	latBits, lonBits := deinterleave(HashFromScore(score))
+
+	cells := float64(uint64(1) << GeoHashStep)
+	lonScale := float64(MaxLongitude - MinLongitude)
+	latScale := MaxLatitude - MinLatitude
+
+	lonMin := MinLongitude + (float64(lonBits)/cells)*lonScale
+	lonMax := MinLongitude + (float64(lonBits+1)/cells)*lonScale
+	latMin := MinLatitude + (float64(latBits)/cells)*latScale
+	latMax := MinLatitude + (float64(latBits+1)/cells)*latScale
+
+	longitude = math.Max(math.Min((lonMin+lonMax)/2, MaxLongitude), MinLongitude)
+	latitude = math.Max(math.Min((latMin+latMax)/2, MaxLatitude), MinLatitude)
 
 	return longitude, latitude
 }
@@ -145,17 +162,13 @@ func GetDistance(p1, p2 *Point) float64 {
 	lon1 := toRadians(p1.Longitude)
 	lon2 := toRadians(p2.Longitude)
 
-	dLat := lat2 - lat1
-	dLon := lon2 - lon1
+	// Haversine formula, in the arcsine form Redis uses so results
+	// match it to the last digit
+	u := math.Sin((lat2 - lat1) / 2)
+	v := math.Sin((lon2 - lon1) / 2)
+	a := u*u + math.Cos(lat1)*math.Cos(lat2)*v*v
 
-	// Haversine formula
-	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
-		math.Cos(lat1)*math.Cos(lat2)*
-			math.Sin(dLon/2)*math.Sin(dLon/2)
-
-	c := 2 * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
-
-	return EarthRadius * c
+	return 2 * EarthRadius * math.Asin(math.Sqrt(a))
 }
 
 // toRadians converts degrees to radians
@@ -169,47 +182,20 @@ func toDegrees(radians float64) float64 {
 }
 
 // EncodeToBase32 encodes coordinates to a geohash string (base32)
+// Like Redis, the hash is computed over the standard -180..180 / -90..90
+// ranges with 52 bits, so characters past the 10th are always '0'.
 func EncodeToBase32(longitude, latitude float64, precision int) string {
 	const base32 = "0123456789bcdefghjkmnpqrstuvwxyz"
 
-	// Standard geohash range
-	lonRange := []float64{-180, 180}
-	latRange := []float64{-90, 90}
+	bits := encodeWithRange(longitude, latitude, -180, 180, -90, 90)
 
-	var bits uint8
-	bitCount := 0
 	var hash strings.Builder
-	isEven := true
-
-	for hash.Len() < precision {
-		if isEven {
-			mid := (lonRange[0] + lonRange[1]) / 2
-			if longitude > mid {
-				bits = (bits << 1) | 1
-				lonRange[0] = mid
-			} else {
-				bits = bits << 1
-				lonRange[1] = mid
-			}
-		} else {
-			mid := (latRange[0] + latRange[1]) / 2
-			if latitude > mid {
-				bits = (bits << 1) | 1
-				latRange[0] = mid
-			} else {
-				bits = bits << 1
-				latRange[1] = mid
-			}
-		}
-		isEven = !isEven
-		bitCount++
-
-		if bitCount == 5 {
-			idx := bits & 0x1F // 5 bits
-			hash.WriteByte(base32[idx])
-			bits = 0
-			bitCount = 0
+	for i := 0; i < precision; i++ {
+		idx := 0
+		if shift := GeoHashBits - (i+1)*5; shift >= 0 {
+			idx = int((bits >> shift) & 0x1f)
 		}
+		hash.WriteByte(base32[idx])
 	}
 
 	return hash.String()
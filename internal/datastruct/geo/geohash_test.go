@@ -0,0 +1,86 @@
+package geo
+
+import (
+	"math"
+	"strconv"
+	"testing"
+)
+
+// Golden values from the Redis GEOADD/GEOHASH/GEOPOS documentation
+var sicily = []struct {
+	name      string
+	longitude float64
+	latitude  float64
+	score     uint64
+	hash      string
+	posLon    float64
+	posLat    float64
+}{
+	{"Palermo", 13.361389, 38.115556, 3479099956230698, "sqc8b49rny0", 13.36138933897018433, 38.11555639549629859},
+	{"Catania", 15.087269, 37.502669, 3479447370796909, "sqdtr74hyu0", 15.08726745843887329, 37.50266842333162032},
+}
+
+func TestEncodeHashGolden(t *testing.T) {
+	for _, c := range sicily {
+		if got := EncodeHash(c.longitude, c.latitude); got != c.score {
+			t.Errorf("EncodeHash %s expected %d, got %d", c.name, c.score, got)
+		}
+		if got := EncodeToScore(c.longitude, c.latitude); got != float64(c.score) {
+			t.Errorf("EncodeToScore %s expected %d, got %f", c.name, c.score, got)
+		}
+	}
+}
+
+func TestDecodeFromScoreGolden(t *testing.T) {
+	for _, c := range sicily {
+		lon, lat := DecodeFromScore(float64(c.score))
+		if math.Abs(lon-c.posLon) > 1e-12 || math.Abs(lat-c.posLat) > 1e-12 {
+			t.Errorf("DecodeFromScore %s expected (%.17f, %.17f), got (%.17f, %.17f)",
+				c.name, c.posLon, c.posLat, lon, lat)
+		}
+	}
+}
+
+func TestEncodeToBase32Golden(t *testing.T) {
+	for _, c := range sicily {
+		lon, lat := DecodeFromScore(float64(c.score))
+		if got := EncodeToBase32(lon, lat, 11); got != c.hash {
+			t.Errorf("EncodeToBase32 %s expected %s, got %s", c.name, c.hash, got)
+		}
+	}
+}
+
+func TestUnitRoundTrip(t *testing.T) {
+	for _, unit := range []DistanceUnit{Meters, Kilometers, Miles, Feet} {
+		for _, meters := range []float64{0, 1, 166274.1516, 6372797.560856} {
+			got := ToMeters(FromMeters(meters, unit), unit)
+			if math.Abs(got-meters) > 1e-9 {
+				t.Errorf("unit %d round trip of %f gave %f", unit, meters, got)
+			}
+		}
+	}
+}
+
+func TestGetDistancePalermoCatania(t *testing.T) {
+	// Distances are computed between the stored (decoded) positions, as
+	// GEODIST does; the Redis docs report 166274.1516 m
+	var points [2]*Point
+	for i, c := range sicily {
+		lon, lat := DecodeFromScore(float64(c.score))
+		points[i] = &Point{Longitude: lon, Latitude: lat}
+	}
+
+	meters := GetDistance(points[0], points[1])
+	if got := strconv.FormatFloat(meters, 'f', 4, 64); got != "166274.1516" {
+		t.Errorf("Palermo-Catania expected 166274.1516 m, got %s", got)
+	}
+	if got := strconv.FormatFloat(FromMeters(meters, Kilometers), 'f', 4, 64); got != "166.2742" {
+		t.Errorf("Palermo-Catania expected 166.2742 km, got %s", got)
+	}
+	if d := GetDistance(points[1], points[0]); d != meters {
+		t.Errorf("distance expected symmetric, got %f and %f", meters, d)
+	}
+	if d := GetDistance(points[0], points[0]); d != 0 {
+		t.Errorf("distance to self expected 0, got %f", d)
+	}
+}
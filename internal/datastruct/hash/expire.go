@@ -0,0 +1,133 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hash
+
+import "time"
+
+// Results of the field expiration methods, as returned by HEXPIRE, HTTL
+// and HPERSIST for each field
+const (
+	FieldMissing = -2 // The field does not exist
+	FieldNoTTL   = -1 // The field exists but has no expiration
+	FieldUpdated = 1  // The expiration was set or removed
+	FieldDeleted = 2  // The expiration time had passed; the field was deleted
+)
+
+// nowMs returns the current Unix time in milliseconds
+func nowMs() int64 {
+	return time.Now().UnixMilli()
+}
+
+// SetFieldExpireAt sets the expiration of field to the Unix time at, in
+// milliseconds. A time that has already passed deletes the field. It
+// returns FieldMissing, FieldUpdated or FieldDeleted.
+func (h *Hash) SetFieldExpireAt(field string, at int64) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireFieldsLocked()
+
+	if _, ok := h.get(field); !ok {
+		return FieldMissing
+	}
+
+	if at <= nowMs() {
+		h.del(field)
+		return FieldDeleted
+	}
+
+	if h.expires == nil {
+		h.expires = make(map[string]int64)
+	}
+	if len(h.expires) == 0 || at < h.nextExpire {
+		h.nextExpire = at
+	}
+	h.expires[field] = at
+	return FieldUpdated
+}
+
+// FieldExpireAt returns the expiration of field as a Unix time in
+// milliseconds, or FieldMissing or FieldNoTTL
+func (h *Hash) FieldExpireAt(field string) int64 {
+	h.expireFields()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if _, ok := h.get(field); !ok {
+		return FieldMissing
+	}
+	if at, ok := h.expires[field]; ok {
+		return at
+	}
+	return FieldNoTTL
+}
+
+// PersistField removes the expiration of field. It returns FieldMissing,
+// FieldNoTTL or FieldUpdated.
+func (h *Hash) PersistField(field string) int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.expireFieldsLocked()
+
+	if _, ok := h.get(field); !ok {
+		return FieldMissing
+	}
+	if _, ok := h.expires[field]; !ok {
+		return FieldNoTTL
+	}
+	delete(h.expires, field)
+	return FieldUpdated
+}
+
+// FieldExpires returns the expiration of every field that has one, as Unix
+// times in milliseconds
+func (h *Hash) FieldExpires() map[string]int64 {
+	h.expireFields()
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := make(map[string]int64, len(h.expires))
+	for field, at := range h.expires {
+		result[field] = at
+	}
+	return result
+}
+
+// expireFields removes the fields whose expiration has passed. Every
+// accessor calls it first, which makes field expiration lazy.
+func (h *Hash) expireFields() {
+	h.mu.RLock()
+	due := len(h.expires) > 0 && h.nextExpire <= nowMs()
+	h.mu.RUnlock()
+
+	if due {
+		h.mu.Lock()
+		h.expireFieldsLocked()
+		h.mu.Unlock()
+	}
+}
+
+// expireFieldsLocked removes the fields whose expiration has passed (with
+// h.mu held for writing)
+func (h *Hash) expireFieldsLocked() {
+	if len(h.expires) == 0 {
+		return
+	}
+	now := nowMs()
+	if h.nextExpire > now {
+		return
+	}
+
+	next := int64(0)
+	for field, at := range h.expires {
+		if at <= now {
+			h.del(field)
+		} else if next == 0 || at < next {
+			next = at
+		}
+	}
+	h.nextExpire = next
+}
@@ -0,0 +1,44 @@
+package hash
+
+import (
+	"testing"
+	"time"
+)
+
+func TestFieldExpiration(t *testing.T) {
+	h := NewHash()
+	h.Set("a", "1")
+	h.Set("b", "2")
+
+	if got := h.SetFieldExpireAt("missing", nowMs()+1000); got != FieldMissing {
+		t.Errorf("SetFieldExpireAt of a missing field expected %d, got %d", FieldMissing, got)
+	}
+	if got := h.SetFieldExpireAt("a", nowMs()+20); got != FieldUpdated {
+		t.Errorf("SetFieldExpireAt expected %d, got %d", FieldUpdated, got)
+	}
+	if got := h.FieldExpireAt("b"); got != FieldNoTTL {
+		t.Errorf("FieldExpireAt without TTL expected %d, got %d", FieldNoTTL, got)
+	}
+
+	time.Sleep(30 * time.Millisecond)
+
+	if _, ok := h.Get("a"); ok {
+		t.Error("field a expected expired")
+	}
+	if v, ok := h.Get("b"); !ok || v != "2" {
+		t.Errorf("field b expected 2, got %q", v)
+	}
+	if n := h.Len(); n != 1 {
+		t.Errorf("Len expected 1, got %d", n)
+	}
+	if got := h.FieldExpireAt("a"); got != FieldMissing {
+		t.Errorf("FieldExpireAt of an expired field expected %d, got %d", FieldMissing, got)
+	}
+
+	if got := h.SetFieldExpireAt("b", nowMs()-1); got != FieldDeleted {
+		t.Errorf("SetFieldExpireAt in the past expected %d, got %d", FieldDeleted, got)
+	}
+	if n := h.Len(); n != 0 {
+		t.Errorf("Len expected 0, got %d", n)
+	}
+}
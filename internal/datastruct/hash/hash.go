@@ -5,8 +5,10 @@
 package hash
 
 import (
+	"math/rand/v2"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 // HashEncoding represents the encoding type of a hash
@@ -15,70 +17,218 @@ type HashEncoding byte
 const (
 	// HashEncodingHashtable uses a Go map
 	HashEncodingHashtable HashEncoding = iota
-	// HashEncodingZiplist uses a more compact representation (TODO)
-	HashEncodingZiplist
+	// HashEncodingListpack uses a slice of field-value pairs in insertion
+	// order
+	HashEncodingListpack
 )
 
-// Hash represents a Redis hash data structure
+// String returns the encoding name reported by OBJECT ENCODING
+func (e HashEncoding) String() string {
+	if e == HashEncodingListpack {
+		return "listpack"
+	}
+	return "hashtable"
+}
+
+// Defaults of hash-max-listpack-entries and hash-max-listpack-value
+const (
+	DefaultMaxListpackEntries = 512
+	DefaultMaxListpackValue   = 64
+)
+
+// maxListpackEntries and maxListpackValue are the largest hash, and the
+// longest field or value, kept in listpack encoding
+var maxListpackEntries, maxListpackValue atomic.Int64
+
+func init() {
+	maxListpackEntries.Store(DefaultMaxListpackEntries)
+	maxListpackValue.Store(DefaultMaxListpackValue)
+}
+
+// SetMaxListpack sets the largest hash and the longest field or value kept
+// in listpack encoding (hash-max-listpack-entries and
+// hash-max-listpack-value). Hashes past the limits convert to a hashtable
+// on their next write.
+func SetMaxListpack(entries, value int) {
+	maxListpackEntries.Store(int64(entries))
+	maxListpackValue.Store(int64(value))
+}
+
+// entry is a field-value pair of a listpack hash
+type entry struct {
+	field string
+	value string
+}
+
+// Hash represents a Redis hash data structure. A small hash is kept as a
+// slice of field-value pairs in insertion order (listpack encoding) until
+// it grows past hash-max-listpack-entries or receives a field or value
+// longer than hash-max-listpack-value; it then moves to a map. The
+// conversion is one way.
 type Hash struct {
 	mu       sync.RWMutex
+	listpack []entry // Field-value pairs, in listpack encoding
 	data     map[string]string
 	encoding HashEncoding
+
+	// Field expiration: field -> deadline in Unix milliseconds, allocated
+	// on first use. nextExpire is a lower bound of the deadlines, so
+	// accessors only scan expires once it has passed.
+	expires    map[string]int64
+	nextExpire int64
 }
 
 // NewHash creates a new hash
 func NewHash() *Hash {
 	return &Hash{
-		data:     make(map[string]string),
-		encoding: HashEncodingHashtable,
+		encoding: HashEncodingListpack,
 	}
 }
 
 // NewHashFromMap creates a hash from a map
 func NewHashFromMap(m map[string]string) *Hash {
-	h := &Hash{
-		data:     make(map[string]string, len(m)),
-		encoding: HashEncodingHashtable,
-	}
+	h := NewHash()
 	for k, v := range m {
-		h.data[k] = v
+		h.put(k, v)
 	}
 	return h
 }
 
+// convertToHashtable moves the pairs of a listpack to a map (with h.mu
+// held for writing)
+func (h *Hash) convertToHashtable() {
+	if h.encoding != HashEncodingListpack {
+		return
+	}
+	h.data = make(map[string]string, len(h.listpack)+1)
+	for _, e := range h.listpack {
+		h.data[e.field] = e.value
+	}
+	h.listpack = nil
+	h.encoding = HashEncodingHashtable
+}
+
+// listpackIndex returns the position of field in the listpack, or -1
+func (h *Hash) listpackIndex(field string) int {
+	for i, e := range h.listpack {
+		if e.field == field {
+			return i
+		}
+	}
+	return -1
+}
+
+// get returns the value of field (with h.mu held)
+func (h *Hash) get(field string) (string, bool) {
+	if h.encoding == HashEncodingListpack {
+		if i := h.listpackIndex(field); i >= 0 {
+			return h.listpack[i].value, true
+		}
+		return "", false
+	}
+	val, ok := h.data[field]
+	return val, ok
+}
+
+// put sets field to value, converting the encoding when needed, and
+// returns true if the field is new (with h.mu held for writing)
+func (h *Hash) put(field, value string) bool {
+	if h.encoding == HashEncodingListpack {
+		i := h.listpackIndex(field)
+		maxValue := maxListpackValue.Load()
+		if int64(len(field)) <= maxValue && int64(len(value)) <= maxValue {
+			if i >= 0 {
+				h.listpack[i].value = value
+				return false
+			}
+			if int64(len(h.listpack)) < maxListpackEntries.Load() {
+				h.listpack = append(h.listpack, entry{field: field, value: value})
+				return true
+			}
+		}
+		h.convertToHashtable()
+	}
+
+	_, existed := h.data[field]
+	h.data[field] = value
+	return !existed
+}
+
+// del deletes field and its expiration, and returns true if it was present
+// (with h.mu held for writing)
+func (h *Hash) del(field string) bool {
+	delete(h.expires, field)
+	if h.encoding == HashEncodingListpack {
+		i := h.listpackIndex(field)
+		if i < 0 {
+			return false
+		}
+		h.listpack = append(h.listpack[:i], h.listpack[i+1:]...)
+		return true
+	}
+
+	if _, ok := h.data[field]; !ok {
+		return false
+	}
+	delete(h.data, field)
+	return true
+}
+
+// length returns the number of fields (with h.mu held)
+func (h *Hash) length() int {
+	if h.encoding == HashEncodingListpack {
+		return len(h.listpack)
+	}
+	return len(h.data)
+}
+
+// each calls fn for every field-value pair, in insertion order in listpack
+// encoding (with h.mu held)
+func (h *Hash) each(fn func(field, value string)) {
+	if h.encoding == HashEncodingListpack {
+		for _, e := range h.listpack {
+			fn(e.field, e.value)
+		}
+		return
+	}
+	for k, v := range h.data {
+		fn(k, v)
+	}
+}
+
 // Set sets a field-value pair in the hash
 func (h *Hash) Set(field, value string) int {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.expireFieldsLocked()
 
-	_, existed := h.data[field]
-	h.data[field] = value
-
-	if existed {
-		return 0
+	delete(h.expires, field)
+	if h.put(field, value) {
+		return 1
 	}
-	return 1
+	return 0
 }
 
 // Get returns the value of a field
 func (h *Hash) Get(field string) (string, bool) {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	val, ok := h.data[field]
-	return val, ok
+	return h.get(field)
 }
 
 // MSet sets multiple field-value pairs
 func (h *Hash) MSet(pairs map[string]string) int {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.expireFieldsLocked()
 
 	newFields := 0
 	for field, value := range pairs {
-		_, existed := h.data[field]
-		h.data[field] = value
-		if !existed {
+		delete(h.expires, field)
+		if h.put(field, value) {
 			newFields++
 		}
 	}
@@ -87,12 +237,14 @@ func (h *Hash) MSet(pairs map[string]string) int {
 
 // MGet gets multiple field values
 func (h *Hash) MGet(fields []string) []interface{} {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	result := make([]interface{}, len(fields))
 	for i, field := range fields {
-		if val, ok := h.data[field]; ok {
+		if val, ok := h.get(field); ok {
 			result[i] = val
 		} else {
 			result[i] = nil
@@ -105,11 +257,11 @@ func (h *Hash) MGet(fields []string) []interface{} {
 func (h *Hash) Del(fields ...string) int {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.expireFieldsLocked()
 
 	deleted := 0
 	for _, field := range fields {
-		if _, ok := h.data[field]; ok {
-			delete(h.data, field)
+		if h.del(field) {
 			deleted++
 		}
 	}
@@ -118,66 +270,79 @@ func (h *Hash) Del(fields ...string) int {
 
 // Exists checks if a field exists
 func (h *Hash) Exists(field string) bool {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	_, ok := h.data[field]
+	_, ok := h.get(field)
 	return ok
 }
 
 // Len returns the number of fields
 func (h *Hash) Len() int {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	return len(h.data)
+	return h.length()
 }
 
-// Keys returns all field names
+// Keys returns all field names, in insertion order in listpack encoding.
+// A hashtable has no order.
 func (h *Hash) Keys() []string {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	keys := make([]string, 0, len(h.data))
-	for k := range h.data {
+	keys := make([]string, 0, h.length())
+	h.each(func(k, _ string) {
 		keys = append(keys, k)
-	}
+	})
 	return keys
 }
 
-// Vals returns all values
+// Vals returns all values, in the order of Keys
 func (h *Hash) Vals() []string {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	vals := make([]string, 0, len(h.data))
-	for _, v := range h.data {
+	vals := make([]string, 0, h.length())
+	h.each(func(_, v string) {
 		vals = append(vals, v)
-	}
+	})
 	return vals
 }
 
-// GetAll returns all field-value pairs
+// GetAll returns all field-value pairs, in the order of Keys
 func (h *Hash) GetAll() []string {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	result := make([]string, 0, len(h.data)*2)
-	for k, v := range h.data {
+	result := make([]string, 0, h.length()*2)
+	h.each(func(k, v string) {
 		result = append(result, k, v)
-	}
+	})
 	return result
 }
 
 // GetAllMap returns all field-value pairs as a map
 func (h *Hash) GetAllMap() map[string]string {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	result := make(map[string]string, len(h.data))
-	for k, v := range h.data {
+	result := make(map[string]string, h.length())
+	h.each(func(k, v string) {
 		result[k] = v
-	}
+	})
 	return result
 }
 
@@ -185,10 +350,11 @@ func (h *Hash) GetAllMap() map[string]string {
 func (h *Hash) IncrBy(field string, delta int64) (int64, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.expireFieldsLocked()
 
-	val, ok := h.data[field]
+	val, ok := h.get(field)
 	if !ok {
-		h.data[field] = strconv.FormatInt(delta, 10)
+		h.put(field, strconv.FormatInt(delta, 10))
 		return delta, nil
 	}
 
@@ -199,7 +365,7 @@ func (h *Hash) IncrBy(field string, delta int64) (int64, error) {
 	}
 
 	newVal := current + delta
-	h.data[field] = strconv.FormatInt(newVal, 10)
+	h.put(field, strconv.FormatInt(newVal, 10))
 
 	return newVal, nil
 }
@@ -208,10 +374,11 @@ func (h *Hash) IncrBy(field string, delta int64) (int64, error) {
 func (h *Hash) IncrByFloat(field string, delta float64) (float64, error) {
 	h.mu.Lock()
 	defer h.mu.Unlock()
+	h.expireFieldsLocked()
 
-	val, ok := h.data[field]
+	val, ok := h.get(field)
 	if !ok {
-		h.data[field] = strconv.FormatFloat(delta, 'f', -1, 64)
+		h.put(field, strconv.FormatFloat(delta, 'f', -1, 64))
 		return delta, nil
 	}
 
@@ -222,18 +389,23 @@ func (h *Hash) IncrByFloat(field string, delta float64) (float64, error) {
 	}
 
 	newVal := current + delta
-	h.data[field] = strconv.FormatFloat(newVal, 'f', -1, 64)
+	h.put(field, strconv.FormatFloat(newVal, 'f', -1, 64))
 
 	return newVal, nil
 }
 
 // RandomField returns a random field
 func (h *Hash) RandomField() (string, bool) {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if len(h.data) == 0 {
-		return "", false
+	if h.encoding == HashEncodingListpack {
+		if len(h.listpack) == 0 {
+			return "", false
+		}
+		return h.listpack[rand.IntN(len(h.listpack))].field, true
 	}
 
 	for k := range h.data {
@@ -245,15 +417,17 @@ func (h *Hash) RandomField() (string, bool) {
 
 // Scan iterates over fields with cursor
 func (h *Hash) Scan(cursor int, count int, pattern string) (int, []string) {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	keys := make([]string, 0, count)
-	dataKeys := make([]string, 0, len(h.data))
+	dataKeys := make([]string, 0, h.length())
 
-	for k := range h.data {
+	h.each(func(k, _ string) {
 		dataKeys = append(dataKeys, k)
-	}
+	})
 
 	// Filter by pattern first
 	var filteredKeys []string
@@ -293,10 +467,12 @@ func (h *Hash) Scan(cursor int, count int, pattern string) (int, []string) {
 
 // StrLen returns the length of a field value
 func (h *Hash) StrLen(field string) int {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
-	if val, ok := h.data[field]; ok {
+	if val, ok := h.get(field); ok {
 		return len(val)
 	}
 	return 0
@@ -304,17 +480,25 @@ func (h *Hash) StrLen(field string) int {
 
 // Encoding returns the hash encoding type
 func (h *Hash) Encoding() HashEncoding {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
 	return h.encoding
 }
 
 // Size returns the approximate memory size
 func (h *Hash) Size() int64 {
+	h.expireFields()
+
 	h.mu.RLock()
 	defer h.mu.RUnlock()
 
 	size := int64(0)
-	for k, v := range h.data {
+	h.each(func(k, v string) {
 		size += int64(len(k) + len(v))
+	})
+	if h.encoding == HashEncodingListpack {
+		return size
 	}
 	// Add overhead for map structure
 	size += int64(len(h.data)) * 16
@@ -0,0 +1,78 @@
+package hash
+
+import (
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestListpackKeepsInsertionOrder(t *testing.T) {
+	h := NewHash()
+	for _, f := range []string{"z", "a", "m", "b"} {
+		h.Set(f, "v"+f)
+	}
+	h.Set("a", "updated")
+	h.Del("m")
+	h.Set("m", "again")
+
+	if h.Encoding() != HashEncodingListpack {
+		t.Fatalf("small hash expected listpack, got %s", h.Encoding())
+	}
+	if got := h.Keys(); !slices.Equal(got, []string{"z", "a", "b", "m"}) {
+		t.Errorf("Keys expected insertion order, got %v", got)
+	}
+	want := []string{"z", "vz", "a", "updated", "b", "vb", "m", "again"}
+	if got := h.GetAll(); !slices.Equal(got, want) {
+		t.Errorf("GetAll expected %v, got %v", want, got)
+	}
+}
+
+func TestListpackEntryThreshold(t *testing.T) {
+	t.Cleanup(func() { SetMaxListpack(DefaultMaxListpackEntries, DefaultMaxListpackValue) })
+	SetMaxListpack(4, DefaultMaxListpackValue)
+
+	h := NewHash()
+	for i := 0; i < 4; i++ {
+		h.Set("f"+strconv.Itoa(i), strconv.Itoa(i))
+	}
+	if h.Encoding() != HashEncodingListpack {
+		t.Fatalf("hash of 4 fields expected listpack, got %s", h.Encoding())
+	}
+
+	h.Set("f4", "4")
+	if h.Encoding() != HashEncodingHashtable {
+		t.Fatalf("hash of 5 fields expected hashtable, got %s", h.Encoding())
+	}
+	for i := 0; i < 5; i++ {
+		if v, ok := h.Get("f" + strconv.Itoa(i)); !ok || v != strconv.Itoa(i) {
+			t.Errorf("field f%d lost in conversion, got %q %v", i, v, ok)
+		}
+	}
+
+	// The conversion is one way
+	h.Del("f0", "f1", "f2", "f3")
+	if h.Encoding() != HashEncodingHashtable {
+		t.Errorf("shrunk hash expected to stay hashtable, got %s", h.Encoding())
+	}
+}
+
+func TestListpackValueThreshold(t *testing.T) {
+	t.Cleanup(func() { SetMaxListpack(DefaultMaxListpackEntries, DefaultMaxListpackValue) })
+	SetMaxListpack(DefaultMaxListpackEntries, 8)
+
+	h := NewHash()
+	h.Set("a", strings.Repeat("x", 8))
+	h.Set(strings.Repeat("f", 8), "b")
+	if h.Encoding() != HashEncodingListpack {
+		t.Fatalf("fields and values of 8 bytes expected listpack, got %s", h.Encoding())
+	}
+
+	h.Set("a", strings.Repeat("x", 9))
+	if h.Encoding() != HashEncodingHashtable {
+		t.Fatalf("value of 9 bytes expected hashtable, got %s", h.Encoding())
+	}
+	if h.Len() != 2 || h.StrLen("a") != 9 {
+		t.Errorf("fields lost in conversion: len %d, strlen %d", h.Len(), h.StrLen("a"))
+	}
+}
@@ -1,44 +1,287 @@
 // Copyright 2024 The Godis Authors. All rights reserved.
 // Use of this source code is governed by a BSD-style
-// license that can be found in LICENSE file.
+// license that can be found in the LICENSE file.
 
 package list
 
 import (
+	"encoding/binary"
 	"sync"
+	"sync/atomic"
 )
 
 // ListEncoding represents the encoding type of a list
 type ListEncoding byte
 
 const (
-	// ListEncodingLinkedList uses a linked list
-	ListEncodingLinkedList ListEncoding = iota
-	// ListEncodingQuicklist uses a quicklist (linkedList + ziplist)
-	ListEncodingQuicklist
+	// ListEncodingQuicklist uses a quicklist: a linked list of listpack
+	// nodes
+	ListEncodingQuicklist ListEncoding = iota
 )
 
-// List represents a Redis list data structure
+// Defaults of list-max-ziplist-size and list-compress-depth
+const (
+	DefaultFill          = -2
+	DefaultCompressDepth = 0
+)
+
+const (
+	// sizeSafetyLimit caps the bytes of a node whose fill is an entry
+	// count, as in Redis
+	sizeSafetyLimit = 8192
+
+	// Nodes smaller than minCompressBytes are not compressed, and
+	// compression must save at least minCompressImprove bytes
+	minCompressBytes   = 48
+	minCompressImprove = 8
+
+	// nodeOverhead approximates the memory of a node besides its entries
+	nodeOverhead = 64
+)
+
+// fill and compressDepth are list-max-ziplist-size and list-compress-depth
+var fill, compressDepth atomic.Int64
+
+func init() {
+	fill.Store(DefaultFill)
+	compressDepth.Store(DefaultCompressDepth)
+}
+
+// SetQuicklistOptions sets how many entries (if positive) or bytes (size
+// class -1 = 4KB ... -5 = 64KB, if negative) a node holds, and how many
+// nodes at each end of a list are left uncompressed (0 disables
+// compression). Existing nodes are not resized; lists follow the options
+// as they change.
+func SetQuicklistOptions(nodeFill, depth int) {
+	fill.Store(int64(nodeFill))
+	compressDepth.Store(int64(depth))
+}
+
+// nodeSizeLimit returns the most bytes a node may hold for a negative fill
+func nodeSizeLimit(f int64) int {
+	class := -f
+	if class > 5 {
+		class = 5
+	}
+	return 4096 << (class - 1)
+}
+
+// List represents a Redis list data structure, kept as a quicklist: a
+// doubly linked list of nodes each holding a run of entries (a listpack in
+// Redis). The nodes further than list-compress-depth from both ends are
+// kept LZF compressed.
 type List struct {
 	mu       sync.RWMutex
-	head     *listNode
-	tail     *listNode
+	head     *quicklistNode
+	tail     *quicklistNode
 	length   int
+	nodes    int
 	encoding ListEncoding
 }
 
-// listNode represents a node in linked list
-type listNode struct {
-	value string
-	prev  *listNode
-	next  *listNode
+// quicklistNode is a run of entries of a quicklist
+type quicklistNode struct {
+	prev *quicklistNode
+	next *quicklistNode
+
+	entries []string // nil while compressed
+	count   int      // Number of entries
+	bytes   int      // Listpack size of the entries
+
+	compressed     []byte // LZF compressed entries, while compressed
+	rawLen         int    // Length of the entries once serialized, while compressed
+	incompressible bool   // Compression did not pay off since the last change
 }
 
 // NewList creates a new list
 func NewList() *List {
 	return &List{
-		encoding: ListEncodingLinkedList,
+		encoding: ListEncodingQuicklist,
+	}
+}
+
+// newNode creates an empty node
+func newNode() *quicklistNode {
+	return &quicklistNode{bytes: ListpackHeaderBytes}
+}
+
+// allows reports whether the node can take value without exceeding the
+// fill. An empty node takes any value.
+func (n *quicklistNode) allows(value string) bool {
+	if n.count == 0 {
+		return true
+	}
+	size := n.bytes + listpackEntryBytes(value)
+	if f := fill.Load(); f >= 0 {
+		return n.count < int(max(f, 1)) && size <= sizeSafetyLimit
+	}
+	return size <= nodeSizeLimit(fill.Load())
+}
+
+// full reports whether the node holds more than the fill allows
+func (n *quicklistNode) full() bool {
+	if f := fill.Load(); f >= 0 {
+		return n.count > int(max(f, 1))
+	}
+	return n.bytes > nodeSizeLimit(fill.Load())
+}
+
+// values returns the entries of the node, decoding a compressed node into
+// a copy that the caller must not modify the node through
+func (n *quicklistNode) values() []string {
+	if n.compressed == nil {
+		return n.entries
+	}
+	raw, err := lzfDecompress(n.compressed, n.rawLen)
+	if err != nil {
+		panic("list: " + err.Error())
+	}
+	entries := make([]string, 0, n.count)
+	for len(raw) > 0 {
+		size, k := binary.Uvarint(raw)
+		entries = append(entries, string(raw[k:k+int(size)]))
+		raw = raw[k+int(size):]
+	}
+	return entries
+}
+
+// decompress makes the node writable (with the list locked for writing)
+func (n *quicklistNode) decompress() {
+	if n.compressed != nil {
+		n.entries = n.values()
+		n.compressed = nil
+		n.rawLen = 0
+	}
+}
+
+// compress compresses the node if that saves enough bytes (with the list
+// locked for writing)
+func (n *quicklistNode) compress() {
+	if n.compressed != nil || n.incompressible || n.bytes < minCompressBytes {
+		return
+	}
+
+	raw := make([]byte, 0, n.bytes)
+	for _, e := range n.entries {
+		raw = binary.AppendUvarint(raw, uint64(len(e)))
+		raw = append(raw, e...)
+	}
+	compressed := lzfCompress(raw)
+	if compressed == nil || len(raw)-len(compressed) < minCompressImprove {
+		n.incompressible = true
+		return
+	}
+	n.compressed, n.rawLen, n.entries = compressed, len(raw), nil
+}
+
+// changed recomputes the size of the node after its entries were modified
+func (n *quicklistNode) changed() {
+	n.count = len(n.entries)
+	n.bytes = ListpackBytes(n.entries)
+	n.incompressible = false
+}
+
+// setCompressed compresses or decompresses the node at position idx from
+// the head, so that only nodes further than the compress depth from both
+// ends are compressed
+func (l *List) setCompressed(n *quicklistNode, idx int) {
+	depth := int(compressDepth.Load())
+	if depth > 0 && idx >= depth && idx < l.nodes-depth {
+		n.compress()
+	} else {
+		n.decompress()
+	}
+}
+
+// compressEnds fixes the compression of the nodes a push or pop can
+// affect: the depth nodes at each end, and the first node past them
+func (l *List) compressEnds() {
+	depth := int(compressDepth.Load())
+	if depth <= 0 {
+		return
+	}
+
+	n := l.head
+	for i := 0; i <= depth && n != nil; i++ {
+		l.setCompressed(n, i)
+		n = n.next
+	}
+	n = l.tail
+	for i := 0; i <= depth && n != nil; i++ {
+		l.setCompressed(n, l.nodes-1-i)
+		n = n.prev
+	}
+}
+
+// compressAll fixes the compression of every node, after an operation that
+// may have changed nodes anywhere in the list
+func (l *List) compressAll() {
+	i := 0
+	for n := l.head; n != nil; n = n.next {
+		l.setCompressed(n, i)
+		i++
+	}
+}
+
+// linkAfter inserts node after prev, or at the head if prev is nil
+func (l *List) linkAfter(prev, node *quicklistNode) {
+	node.prev = prev
+	if prev == nil {
+		node.next = l.head
+		l.head = node
+	} else {
+		node.next = prev.next
+		prev.next = node
+	}
+	if node.next != nil {
+		node.next.prev = node
+	} else {
+		l.tail = node
+	}
+	l.nodes++
+}
+
+// unlink removes node from the list
+func (l *List) unlink(node *quicklistNode) {
+	if node.prev != nil {
+		node.prev.next = node.next
+	} else {
+		l.head = node.next
+	}
+	if node.next != nil {
+		node.next.prev = node.prev
+	} else {
+		l.tail = node.prev
 	}
+	node.prev, node.next = nil, nil
+	l.nodes--
+}
+
+// locate returns the node holding the entry at index, the offset of the
+// entry in it and the position of the node, walking from the nearer end
+func (l *List) locate(index int) (*quicklistNode, int, int) {
+	if index < l.length/2 {
+		idx := 0
+		for n := l.head; n != nil; n = n.next {
+			if index < n.count {
+				return n, index, idx
+			}
+			index -= n.count
+			idx++
+		}
+		return nil, 0, 0
+	}
+
+	index = l.length - 1 - index // Index from the tail
+	idx := l.nodes - 1
+	for n := l.tail; n != nil; n = n.prev {
+		if index < n.count {
+			return n, n.count - 1 - index, idx
+		}
+		index -= n.count
+		idx--
+	}
+	return nil, 0, 0
 }
 
 // Len returns the length of list
@@ -53,17 +296,19 @@ func (l *List) PushLeft(value string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	node := &listNode{value: value}
-
-	if l.head == nil {
-		l.head = node
-		l.tail = node
-	} else {
-		node.next = l.head
-		l.head.prev = node
-		l.head = node
-	}
+	if l.head == nil || !l.head.allows(value) {
+		l.linkAfter(nil, newNode())
+	}
+	n := l.head
+	n.decompress()
+	n.entries = append(n.entries, "")
+	copy(n.entries[1:], n.entries)
+	n.entries[0] = value
+	n.count++
+	n.bytes += listpackEntryBytes(value)
+	n.incompressible = false
 	l.length++
+	l.compressEnds()
 }
 
 // PushRight pushes a value to the right (tail) of the list
@@ -71,17 +316,17 @@ func (l *List) PushRight(value string) {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	node := &listNode{value: value}
-
-	if l.tail == nil {
-		l.head = node
-		l.tail = node
-	} else {
-		node.prev = l.tail
-		l.tail.next = node
-		l.tail = node
+	if l.tail == nil || !l.tail.allows(value) {
+		l.linkAfter(l.tail, newNode())
 	}
+	n := l.tail
+	n.decompress()
+	n.entries = append(n.entries, value)
+	n.count++
+	n.bytes += listpackEntryBytes(value)
+	n.incompressible = false
 	l.length++
+	l.compressEnds()
 }
 
 // PopLeft pops a value from the left (head) of the list
@@ -93,14 +338,12 @@ func (l *List) PopLeft() (string, bool) {
 		return "", false
 	}
 
-	value := l.head.value
-	l.head = l.head.next
-	if l.head != nil {
-		l.head.prev = nil
-	} else {
-		l.tail = nil
-	}
-	l.length--
+	n := l.head
+	n.decompress()
+	value := n.entries[0]
+	n.entries[0] = ""
+	n.entries = n.entries[1:]
+	l.popped(n, value)
 	return value, true
 }
 
@@ -113,18 +356,28 @@ func (l *List) PopRight() (string, bool) {
 		return "", false
 	}
 
-	value := l.tail.value
-	l.tail = l.tail.prev
-	if l.tail != nil {
-		l.tail.next = nil
-	} else {
-		l.head = nil
+	n := l.tail
+	n.decompress()
+	value := n.entries[n.count-1]
+	n.entries[n.count-1] = ""
+	n.entries = n.entries[:n.count-1]
+	l.popped(n, value)
+	return value, true
+}
+
+// popped accounts for value having been removed from the end node n
+func (l *List) popped(n *quicklistNode, value string) {
+	n.count--
+	n.bytes -= listpackEntryBytes(value)
+	n.incompressible = false
+	if n.count == 0 {
+		l.unlink(n)
 	}
 	l.length--
-	return value, true
+	l.compressEnds()
 }
 
-// Index returns the index of the first occurrence of a value
+// Index returns the value at index
 func (l *List) Index(index int) (string, bool) {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
@@ -133,18 +386,8 @@ func (l *List) Index(index int) (string, bool) {
 		return "", false
 	}
 
-	node := l.head
-	for i := 0; i < index; i++ {
-		if node == nil {
-			return "", false
-		}
-		node = node.next
-	}
-
-	if node == nil {
-		return "", false
-	}
-	return node.value, true
+	n, offset, _ := l.locate(index)
+	return n.values()[offset], true
 }
 
 // Set sets a value at a given index
@@ -156,18 +399,12 @@ func (l *List) Set(index int, value string) bool {
 		return false
 	}
 
-	node := l.head
-	for i := 0; i < index; i++ {
-		if node == nil {
-			return false
-		}
-		node = node.next
-	}
-
-	if node == nil {
-		return false
-	}
-	node.value = value
+	n, offset, idx := l.locate(index)
+	n.decompress()
+	n.bytes += listpackEntryBytes(value) - listpackEntryBytes(n.entries[offset])
+	n.entries[offset] = value
+	n.incompressible = false
+	l.setCompressed(n, idx)
 	return true
 }
 
@@ -207,13 +444,15 @@ func (l *List) Range(start, end int) []string {
 		return []string{}
 	}
 
-	result := []string{}
-	node := l.head
-	for i := 0; node != nil && i <= end; i++ {
-		if i >= start {
-			result = append(result, node.value)
+	result := make([]string, 0, end-start+1)
+	n, offset, _ := l.locate(start)
+	for ; n != nil && len(result) < cap(result); n = n.next {
+		values := n.values()[offset:]
+		if rest := cap(result) - len(result); len(values) > rest {
+			values = values[:rest]
 		}
-		node = node.next
+		result = append(result, values...)
+		offset = 0
 	}
 	return result
 }
@@ -245,33 +484,37 @@ func (l *List) Trim(start, end int) {
 		end = length - 1
 	}
 
-	// Find new head node
-	newHead := l.head
-	for i := 0; i < start && newHead != nil; i++ {
-		newHead = newHead.next
-	}
-
-	// Find new tail node
-	newTail := newHead
-	for i := start; i <= end && newTail != nil; i++ {
-		newTail = newTail.next
-	}
-	if newTail != nil {
-		newTail = newTail.prev
-	} else if newHead != nil {
-		for newTail = newHead; newTail.next != nil; newTail = newTail.next {
+	// Drop start entries from the head, whole nodes first
+	for drop := start; drop > 0; {
+		n := l.head
+		if n.count <= drop {
+			drop -= n.count
+			l.unlink(n)
+			continue
+		}
+		n.decompress()
+		n.entries = append([]string(nil), n.entries[drop:]...)
+		n.changed()
+		drop = 0
+	}
+
+	// Then the entries past end from the tail
+	for drop := length - 1 - end; drop > 0; {
+		n := l.tail
+		if n.count <= drop {
+			drop -= n.count
+			l.unlink(n)
+			continue
 		}
+		n.decompress()
+		clear(n.entries[n.count-drop:])
+		n.entries = n.entries[:n.count-drop]
+		n.changed()
+		drop = 0
 	}
 
-	l.head = newHead
-	l.tail = newTail
-	if l.head != nil {
-		l.head.prev = nil
-	}
-	if l.tail != nil {
-		l.tail.next = nil
-	}
 	l.length = end - start + 1
+	l.compressEnds()
 }
 
 // Remove removes the first count occurrences of a value (count=0: remove all, count>0: remove first count, count<0: remove last count)
@@ -280,54 +523,65 @@ func (l *List) Remove(value string, count int) int {
 	defer l.mu.Unlock()
 
 	removed := 0
+	limit := count
+	if limit < 0 {
+		limit = -limit
+	}
 
-	if count >= 0 {
-		// Remove from head
-		node := l.head
-		for node != nil && (count == 0 || removed < count) {
-			next := node.next
-			if node.value == value {
-				// Remove node
-				if node.prev != nil {
-					node.prev.next = node.next
-				} else {
-					l.head = node.next
-				}
-				if node.next != nil {
-					node.next.prev = node.prev
-				} else {
-					l.tail = node.prev
-				}
-				removed++
-				l.length--
+	n := l.head
+	if count < 0 {
+		n = l.tail
+	}
+	for n != nil && (limit == 0 || removed < limit) {
+		next := n.next
+		if count < 0 {
+			next = n.prev
+		}
+
+		values := n.values()
+		matches := 0
+		for _, v := range values {
+			if v == value {
+				matches++
 			}
-			node = next
 		}
-	} else {
-		// Remove from tail (negative count)
-		count = -count
-		node := l.tail
-		for node != nil && removed < count {
-			prev := node.prev
-			if node.value == value {
-				// Remove node
-				if node.prev != nil {
-					node.prev.next = node.next
-				} else {
-					l.head = node.next
+		if matches > 0 {
+			n.decompress()
+			kept := make([]string, 0, n.count-matches)
+			if count < 0 {
+				// Remove from the tail of the node
+				for i := n.count - 1; i >= 0; i-- {
+					if n.entries[i] == value && removed < limit {
+						removed++
+						continue
+					}
+					kept = append(kept, n.entries[i])
+				}
+				for i, j := 0, len(kept)-1; i < j; i, j = i+1, j-1 {
+					kept[i], kept[j] = kept[j], kept[i]
 				}
-				if node.next != nil {
-					node.next.prev = node.prev
-				} else {
-					l.tail = node.prev
+			} else {
+				for _, v := range n.entries {
+					if v == value && (limit == 0 || removed < limit) {
+						removed++
+						continue
+					}
+					kept = append(kept, v)
 				}
-				removed++
-				l.length--
 			}
-			node = prev
+			l.length -= n.count - len(kept)
+			n.entries = kept
+			n.changed()
+			if n.count == 0 {
+				l.unlink(n)
+			}
 		}
+		n = next
 	}
 
+	if removed > 0 {
+		l.compressAll()
+	}
 	return removed
 }
 
@@ -337,63 +591,58 @@ func (l *List) LPos(value string) int {
 	defer l.mu.RUnlock()
 
 	index := 0
-	node := l.head
-	for node != nil {
-		if node.value == value {
-			return index
+	for n := l.head; n != nil; n = n.next {
+		for _, v := range n.values() {
+			if v == value {
+				return index
+			}
+			index++
 		}
-		node = node.next
-		index++
 	}
 	return -1
 }
 
 // InsertBefore inserts a value before a pivot value
 func (l *List) InsertBefore(pivot string, value string) bool {
-	l.mu.Lock()
-	defer l.mu.Unlock()
-
-	// Find pivot node
-	node := l.head
-	for node != nil {
-		if node.value == pivot {
-			// Found pivot, insert before it
-			newNode := &listNode{value: value, prev: node.prev, next: node}
-			if node.prev != nil {
-				node.prev.next = newNode
-			} else {
-				l.head = newNode
-			}
-			node.prev = newNode
-			l.length++
-			return true
-		}
-		node = node.next
-	}
-	return false
+	return l.insert(pivot, value, 0)
 }
 
 // InsertAfter inserts a value after a pivot value
 func (l *List) InsertAfter(pivot string, value string) bool {
+	return l.insert(pivot, value, 1)
+}
+
+// insert inserts value at the first occurrence of pivot, shifted by after
+// (0 = before, 1 = after). A node that grows past the fill is split.
+func (l *List) insert(pivot, value string, after int) bool {
 	l.mu.Lock()
 	defer l.mu.Unlock()
 
-	// Find pivot node
-	node := l.head
-	for node != nil {
-		if node.value == pivot {
-			// Found pivot, insert after it
-			newNode := &listNode{value: value, prev: node, next: node.next}
-			if node.next != nil {
-				node.next.prev = newNode
-			} else {
-				l.tail = newNode
+	for n := l.head; n != nil; n = n.next {
+		for i, v := range n.values() {
+			if v != pivot {
+				continue
 			}
-			node.next = newNode
+			n.decompress()
+			pos := i + after
+			n.entries = append(n.entries, "")
+			copy(n.entries[pos+1:], n.entries[pos:])
+			n.entries[pos] = value
+			n.changed()
 			l.length++
+
+			if n.full() && n.count > 1 {
+				half := n.count / 2
+				split := newNode()
+				split.entries = append([]string(nil), n.entries[half:]...)
+				split.changed()
+				n.entries = append([]string(nil), n.entries[:half]...)
+				n.changed()
+				l.linkAfter(n, split)
+			}
+			l.compressAll()
 			return true
 		}
-		node = node.next
 	}
 	return false
 }
@@ -406,6 +655,7 @@ func (l *List) Clear() {
 	l.head = nil
 	l.tail = nil
 	l.length = 0
+	l.nodes = 0
 }
 
 // ToSlice returns all elements as a slice
@@ -413,11 +663,9 @@ func (l *List) ToSlice() []string {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	result := []string{}
-	node := l.head
-	for node != nil {
-		result = append(result, node.value)
-		node = node.next
+	result := make([]string, 0, l.length)
+	for n := l.head; n != nil; n = n.next {
+		result = append(result, n.values()...)
 	}
 	return result
 }
@@ -432,11 +680,14 @@ func (l *List) Size() int64 {
 	l.mu.RLock()
 	defer l.mu.RUnlock()
 
-	size := int64(l.length) * 16 // Base node overhead
-	node := l.head
-	for node != nil {
-		size += int64(len(node.value))
-		node = node.next
+	size := int64(0)
+	for n := l.head; n != nil; n = n.next {
+		size += nodeOverhead
+		if n.compressed != nil {
+			size += int64(len(n.compressed))
+		} else {
+			size += int64(n.count)*16 + int64(n.bytes) // String headers and data
+		}
 	}
 	return size
 }
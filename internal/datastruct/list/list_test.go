@@ -0,0 +1,307 @@
+package list
+
+import (
+	"bytes"
+	"container/list"
+	"math/rand"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestLZFRoundTrip(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	random := make([]byte, 4096)
+	r.Read(random)
+
+	for name, in := range map[string][]byte{
+		"repeated": bytes.Repeat([]byte("abcabcabc"), 500),
+		"long run": bytes.Repeat([]byte{'x'}, 10000),
+		"text":     []byte(strings.Repeat("the quick brown fox jumps over the lazy dog ", 40)),
+	} {
+		compressed := lzfCompress(in)
+		if compressed == nil {
+			t.Fatalf("%s: expected %d bytes to compress", name, len(in))
+		}
+		out, err := lzfDecompress(compressed, len(in))
+		if err != nil || !bytes.Equal(out, in) {
+			t.Fatalf("%s: round trip failed: %v", name, err)
+		}
+	}
+
+	if lzfCompress(random) != nil {
+		t.Fatal("random data should not compress")
+	}
+	if _, err := lzfDecompress([]byte{0x20, 0x00}, 3); err == nil {
+		t.Fatal("expected a reference before the start to be corrupt")
+	}
+}
+
+// withOptions sets the quicklist options for the duration of a test
+func withOptions(t *testing.T, nodeFill, depth int) {
+	SetQuicklistOptions(nodeFill, depth)
+	t.Cleanup(func() { SetQuicklistOptions(DefaultFill, DefaultCompressDepth) })
+}
+
+// compressedNodes returns the number of compressed nodes of l
+func compressedNodes(l *List) int {
+	n := 0
+	for node := l.head; node != nil; node = node.next {
+		if node.compressed != nil {
+			n++
+		}
+	}
+	return n
+}
+
+// checkList verifies l holds want and that its nodes are consistent
+func checkList(t *testing.T, l *List, want []string) {
+	t.Helper()
+	if got := l.ToSlice(); !slices.Equal(got, want) {
+		t.Fatalf("list = %v, want %v", got, want)
+	}
+	if l.Len() != len(want) {
+		t.Fatalf("Len = %d, want %d", l.Len(), len(want))
+	}
+
+	count, nodes := 0, 0
+	var prev *quicklistNode
+	for n := l.head; n != nil; n = n.next {
+		if n.prev != prev {
+			t.Fatal("broken prev link")
+		}
+		if n.count == 0 {
+			t.Fatal("empty node left in the list")
+		}
+		if n.bytes != ListpackBytes(n.values()) {
+			t.Fatalf("node bytes = %d, want %d", n.bytes, ListpackBytes(n.values()))
+		}
+		count += n.count
+		nodes++
+		prev = n
+	}
+	if l.tail != prev || count != l.length || nodes != l.nodes {
+		t.Fatalf("tail/length/nodes out of sync: %d entries, %d nodes", count, nodes)
+	}
+
+	depth := int(compressDepth.Load())
+	i := 0
+	for n := l.head; n != nil; n = n.next {
+		if n.compressed != nil && (i < depth || i >= l.nodes-depth) {
+			t.Fatalf("node %d of %d is compressed within depth %d", i, l.nodes, depth)
+		}
+		i++
+	}
+}
+
+func TestQuicklistMatchesSlice(t *testing.T) {
+	withOptions(t, 4, 1)
+	r := rand.New(rand.NewSource(1))
+	value := func() string {
+		return strings.Repeat(strconv.Itoa(r.Intn(10)), 10+r.Intn(30))
+	}
+
+	l := NewList()
+	var want []string
+	for i := 0; i < 5000; i++ {
+		switch r.Intn(10) {
+		case 0, 1:
+			v := value()
+			l.PushLeft(v)
+			want = append([]string{v}, want...)
+		case 2, 3:
+			v := value()
+			l.PushRight(v)
+			want = append(want, v)
+		case 4:
+			v, ok := l.PopLeft()
+			if ok != (len(want) > 0) || (ok && v != want[0]) {
+				t.Fatalf("PopLeft = %q, %v", v, ok)
+			}
+			if ok {
+				want = want[1:]
+			}
+		case 5:
+			v, ok := l.PopRight()
+			if ok != (len(want) > 0) || (ok && v != want[len(want)-1]) {
+				t.Fatalf("PopRight = %q, %v", v, ok)
+			}
+			if ok {
+				want = want[:len(want)-1]
+			}
+		case 6:
+			if len(want) > 0 {
+				i, v := r.Intn(len(want)), value()
+				l.Set(i, v)
+				want[i] = v
+			}
+		case 7:
+			if len(want) > 0 {
+				pivot, v := want[r.Intn(len(want))], value()
+				l.InsertAfter(pivot, v)
+				i := slices.Index(want, pivot) + 1
+				want = slices.Insert(want, i, v)
+			}
+		case 8:
+			v, count := value(), r.Intn(5)-2
+			removed := l.Remove(v, count)
+			n := 0
+			if count < 0 {
+				for i := len(want) - 1; i >= 0 && n < -count; i-- {
+					if want[i] == v {
+						want = slices.Delete(want, i, i+1)
+						n++
+					}
+				}
+			} else {
+				want = slices.DeleteFunc(want, func(s string) bool {
+					if s == v && (count == 0 || n < count) {
+						n++
+						return true
+					}
+					return false
+				})
+			}
+			if removed != n {
+				t.Fatalf("Remove(%q, %d) = %d, want %d", v, count, removed, n)
+			}
+		case 9:
+			if len(want) > 100 {
+				l.Trim(1, -2)
+				want = want[1 : len(want)-1]
+			}
+		}
+		checkList(t, l, want)
+	}
+
+	if compressedNodes(l) == 0 {
+		t.Fatal("expected interior nodes to be compressed")
+	}
+	for i, v := range want {
+		if got, _ := l.Index(i); got != v {
+			t.Fatalf("Index(%d) = %q, want %q", i, got, v)
+		}
+	}
+	if got := l.Range(-20, -3); !slices.Equal(got, want[len(want)-20:len(want)-2]) {
+		t.Fatalf("Range(-20, -3) = %v", got)
+	}
+}
+
+func TestQuicklistFill(t *testing.T) {
+	withOptions(t, 3, 0)
+	l := NewList()
+	for i := 0; i < 10; i++ {
+		l.PushRight(strconv.Itoa(i))
+	}
+	if l.nodes != 4 {
+		t.Fatalf("10 entries with fill 3 should take 4 nodes, got %d", l.nodes)
+	}
+
+	// A size class limits the bytes of a node instead: -1 allows 4KB
+	SetQuicklistOptions(-1, 0)
+	l = NewList()
+	for i := 0; i < 100; i++ {
+		l.PushRight(strings.Repeat("x", 100))
+	}
+	for n := l.head; n != nil; n = n.next {
+		if n.bytes > 4096 {
+			t.Fatalf("node of %d bytes exceeds 4KB", n.bytes)
+		}
+	}
+	if l.nodes < 3 {
+		t.Fatalf("10KB of entries should take at least 3 nodes, got %d", l.nodes)
+	}
+}
+
+func TestQuicklistCompressDepth(t *testing.T) {
+	withOptions(t, 8, 2)
+	l := NewList()
+	for i := 0; i < 800; i++ {
+		l.PushRight("element-" + strconv.Itoa(i%10))
+	}
+	if l.nodes != 100 {
+		t.Fatalf("expected 100 nodes, got %d", l.nodes)
+	}
+	if got := compressedNodes(l); got != 96 {
+		t.Fatalf("expected all but 2 nodes at each end compressed, got %d", got)
+	}
+	if v, _ := l.Index(403); v != "element-3" {
+		t.Fatalf("Index(403) = %q", v)
+	}
+	if compressedNodes(l) != 96 {
+		t.Fatal("reading a compressed node should not decompress it")
+	}
+}
+
+const benchElements = 100000
+
+// benchValue returns the i-th element pushed by the benchmarks
+func benchValue(i int) string {
+	return "element:" + strconv.Itoa(i%1000)
+}
+
+// newLinkedList returns a list of one element per node, the encoding lists
+// used before quicklists, holding benchElements elements
+func newLinkedList() *list.List {
+	l := list.New()
+	for i := 0; i < benchElements; i++ {
+		l.PushBack(benchValue(i))
+	}
+	return l
+}
+
+// linkedListRange returns the elements from start to end of a linked list
+func linkedListRange(l *list.List, start, end int) []string {
+	result := make([]string, 0, end-start+1)
+	e := l.Front()
+	for i := 0; i < start; i++ {
+		e = e.Next()
+	}
+	for i := start; i <= end; i++ {
+		result = append(result, e.Value.(string))
+		e = e.Next()
+	}
+	return result
+}
+
+func benchmarkQuicklist(b *testing.B, depth int) {
+	SetQuicklistOptions(DefaultFill, depth)
+	defer SetQuicklistOptions(DefaultFill, DefaultCompressDepth)
+
+	l := NewList()
+	for i := 0; i < benchElements; i++ {
+		l.PushRight(benchValue(i))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		l.Range(benchElements/2, benchElements/2+99)
+		l.Range(0, -1)
+	}
+	b.ReportMetric(float64(l.Size())/benchElements, "bytes/elem")
+}
+
+// BenchmarkLRangeQuicklist measures LRANGE of 100 elements from the middle
+// and of the whole list over 100k elements
+func BenchmarkLRangeQuicklist(b *testing.B) {
+	benchmarkQuicklist(b, 0)
+}
+
+func BenchmarkLRangeQuicklistCompressed(b *testing.B) {
+	benchmarkQuicklist(b, 1)
+}
+
+func BenchmarkLRangeLinkedList(b *testing.B) {
+	l := newLinkedList()
+	// 48 bytes per element and 16 per string header, as Size counts
+	size := 0
+	for e := l.Front(); e != nil; e = e.Next() {
+		size += 48 + 16 + len(e.Value.(string))
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		linkedListRange(l, benchElements/2, benchElements/2+99)
+		linkedListRange(l, 0, benchElements-1)
+	}
+	b.ReportMetric(float64(size)/benchElements, "bytes/elem")
+}
@@ -0,0 +1,47 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package list
+
+// ListpackHeaderBytes is the fixed overhead of a listpack: the total bytes
+// and entry count header plus the end byte
+const ListpackHeaderBytes = 7
+
+// ListpackBytes returns the size in bytes of a listpack holding elems, each
+// stored as a string entry
+func ListpackBytes(elems []string) int {
+	size := ListpackHeaderBytes
+	for _, e := range elems {
+		size += listpackEntryBytes(e)
+	}
+	return size
+}
+
+// listpackEntryBytes returns the size in bytes of e as a listpack string
+// entry
+func listpackEntryBytes(e string) int {
+	// Encoding byte(s), then the data, then the entry length
+	n := len(e)
+	switch {
+	case n < 64:
+		n++
+	case n < 4096:
+		n += 2
+	default:
+		n += 5
+	}
+	switch {
+	case n < 128:
+		n++
+	case n < 16384:
+		n += 2
+	case n < 2097152:
+		n += 3
+	case n < 268435456:
+		n += 4
+	default:
+		n += 5
+	}
+	return n
+}
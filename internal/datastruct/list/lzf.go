@@ -0,0 +1,127 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package list
+
+import "errors"
+
+// LZF compression, as used by Redis for the interior nodes of a quicklist.
+// The compressed data is a sequence of runs: a control byte below 32 is
+// followed by that many plus one literal bytes; any other control byte
+// starts a back reference of (length-2)<<5 | offset>>8, with a length byte
+// following if the length field is 7, and the low offset byte last.
+const (
+	lzfHashLog = 14
+	lzfMaxLit  = 1 << 5
+	lzfMaxOff  = 1 << 13
+	lzfMaxRef  = 1<<8 + 1<<3
+)
+
+var errLZFCorrupt = errors.New("corrupt LZF data")
+
+// lzfHash hashes the 3 bytes at the start of b
+func lzfHash(b []byte) uint32 {
+	v := uint32(b[0])<<16 | uint32(b[1])<<8 | uint32(b[2])
+	return (v * 2654435761) >> (32 - lzfHashLog)
+}
+
+// lzfCompress compresses in. It returns nil if the result would not be
+// smaller than in.
+func lzfCompress(in []byte) []byte {
+	var htab [1 << lzfHashLog]int32 // Position+1 of the last 3 bytes with each hash
+	out := make([]byte, 0, len(in))
+	lit := -1 // Index in out of the control byte of the open literal run
+
+	literal := func(b byte) {
+		if lit < 0 {
+			lit = len(out)
+			out = append(out, 0)
+		} else {
+			out[lit]++
+		}
+		out = append(out, b)
+		if out[lit] == lzfMaxLit-1 {
+			lit = -1
+		}
+	}
+
+	ip := 0
+	for ip+2 < len(in) {
+		h := lzfHash(in[ip:])
+		ref := int(htab[h]) - 1
+		htab[h] = int32(ip + 1)
+
+		if ref < 0 || ip-ref-1 >= lzfMaxOff || in[ref] != in[ip] || in[ref+1] != in[ip+1] || in[ref+2] != in[ip+2] {
+			literal(in[ip])
+			ip++
+			continue
+		}
+
+		n := 3
+		for n < lzfMaxRef && ip+n < len(in) && in[ref+n] == in[ip+n] {
+			n++
+		}
+		off := ip - ref - 1
+		if n-2 < 7 {
+			out = append(out, byte((n-2)<<5|off>>8), byte(off))
+		} else {
+			out = append(out, byte(7<<5|off>>8), byte(n-2-7), byte(off))
+		}
+		lit = -1
+		ip += n
+	}
+	for ; ip < len(in); ip++ {
+		literal(in[ip])
+	}
+
+	if len(out) >= len(in) {
+		return nil
+	}
+	return out
+}
+
+// lzfDecompress decompresses in, which expands to n bytes
+func lzfDecompress(in []byte, n int) ([]byte, error) {
+	out := make([]byte, 0, n)
+	for ip := 0; ip < len(in); {
+		ctrl := int(in[ip])
+		ip++
+
+		if ctrl < lzfMaxLit {
+			end := ip + ctrl + 1
+			if end > len(in) {
+				return nil, errLZFCorrupt
+			}
+			out = append(out, in[ip:end]...)
+			ip = end
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if ip >= len(in) {
+				return nil, errLZFCorrupt
+			}
+			length += int(in[ip])
+			ip++
+		}
+		if ip >= len(in) {
+			return nil, errLZFCorrupt
+		}
+		ref := len(out) - (ctrl&0x1f)<<8 - int(in[ip]) - 1
+		ip++
+		if ref < 0 {
+			return nil, errLZFCorrupt
+		}
+		// The reference may overlap the bytes being written
+		for i := 0; i < length+2; i++ {
+			out = append(out, out[ref+i])
+		}
+	}
+
+	if len(out) != n {
+		return nil, errLZFCorrupt
+	}
+	return out, nil
+}
@@ -6,7 +6,10 @@ package set
 
 import (
 	"math/rand/v2"
+	"slices"
+	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 // SetEncoding represents the encoding type of a set
@@ -15,48 +18,182 @@ type SetEncoding byte
 const (
 	// SetEncodingHashtable uses a Go map
 	SetEncodingHashtable SetEncoding = iota
-	// SetEncodingIntset uses an intset for integer-only sets (TODO)
+	// SetEncodingIntset uses a sorted int64 slice for integer-only sets
 	SetEncodingIntset
 )
 
-// Set represents a Redis set data structure
+// String returns the encoding name reported by OBJECT ENCODING
+func (e SetEncoding) String() string {
+	if e == SetEncodingIntset {
+		return "intset"
+	}
+	return "hashtable"
+}
+
+// DefaultMaxIntsetEntries is the default of set-max-intset-entries
+const DefaultMaxIntsetEntries = 512
+
+// maxIntsetEntries is the largest set kept in intset encoding
+var maxIntsetEntries atomic.Int64
+
+func init() {
+	maxIntsetEntries.Store(DefaultMaxIntsetEntries)
+}
+
+// SetMaxIntsetEntries sets the largest set kept in intset encoding
+// (set-max-intset-entries). Larger sets convert to a hashtable on their
+// next insert.
+func SetMaxIntsetEntries(n int) {
+	maxIntsetEntries.Store(int64(n))
+}
+
+// Set represents a Redis set data structure. A set holding only integers
+// is kept as a sorted slice (intset encoding) until it grows past
+// set-max-intset-entries or receives a non-integer member; the conversion
+// to a hashtable is one way.
 type Set struct {
 	mu       sync.RWMutex
-	data     map[string]struct{}
+	data     map[string]struct{} // Members, in hashtable encoding
+	ints     []int64             // Sorted members, in intset encoding
 	encoding SetEncoding
 }
 
 // NewSet creates a new set
 func NewSet() *Set {
 	return &Set{
-		data:     make(map[string]struct{}),
-		encoding: SetEncodingHashtable,
+		encoding: SetEncodingIntset,
 	}
 }
 
 // NewSetFromSlice creates a set from a slice
 func NewSetFromSlice(items []string) *Set {
-	s := &Set{
-		data:     make(map[string]struct{}, len(items)),
-		encoding: SetEncodingHashtable,
-	}
+	s := NewSet()
 	for _, item := range items {
-		s.data[item] = struct{}{}
+		s.insert(item)
 	}
 	return s
 }
 
+// parseIntsetMember returns the integer a member stands for, if it is the
+// canonical decimal form of an int64 and can be stored in an intset
+func parseIntsetMember(member string) (int64, bool) {
+	v, err := strconv.ParseInt(member, 10, 64)
+	if err != nil || strconv.FormatInt(v, 10) != member {
+		return 0, false
+	}
+	return v, true
+}
+
+// convertToHashtable moves the members of an intset to a map (with s.mu
+// held for writing)
+func (s *Set) convertToHashtable() {
+	if s.encoding != SetEncodingIntset {
+		return
+	}
+	s.data = make(map[string]struct{}, len(s.ints)+1)
+	for _, v := range s.ints {
+		s.data[strconv.FormatInt(v, 10)] = struct{}{}
+	}
+	s.ints = nil
+	s.encoding = SetEncodingHashtable
+}
+
+// insert adds member, converting the encoding when needed, and returns
+// true if it was not already present (with s.mu held for writing)
+func (s *Set) insert(member string) bool {
+	if s.encoding == SetEncodingIntset {
+		v, ok := parseIntsetMember(member)
+		if ok {
+			i, found := slices.BinarySearch(s.ints, v)
+			if found {
+				return false
+			}
+			if int64(len(s.ints)) < maxIntsetEntries.Load() {
+				s.ints = slices.Insert(s.ints, i, v)
+				return true
+			}
+		}
+		s.convertToHashtable()
+	}
+
+	if _, exists := s.data[member]; exists {
+		return false
+	}
+	s.data[member] = struct{}{}
+	return true
+}
+
+// remove deletes member and returns true if it was present (with s.mu
+// held for writing)
+func (s *Set) remove(member string) bool {
+	if s.encoding == SetEncodingIntset {
+		v, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		i, found := slices.BinarySearch(s.ints, v)
+		if found {
+			s.ints = slices.Delete(s.ints, i, i+1)
+		}
+		return found
+	}
+
+	if _, exists := s.data[member]; exists {
+		delete(s.data, member)
+		return true
+	}
+	return false
+}
+
+// has reports whether member is in the set (with s.mu held)
+func (s *Set) has(member string) bool {
+	if s.encoding == SetEncodingIntset {
+		v, ok := parseIntsetMember(member)
+		if !ok {
+			return false
+		}
+		_, found := slices.BinarySearch(s.ints, v)
+		return found
+	}
+
+	_, exists := s.data[member]
+	return exists
+}
+
+// size returns the number of members (with s.mu held)
+func (s *Set) size() int {
+	if s.encoding == SetEncodingIntset {
+		return len(s.ints)
+	}
+	return len(s.data)
+}
+
+// members returns all members, in ascending order for an intset (with s.mu
+// held)
+func (s *Set) members() []string {
+	result := make([]string, 0, s.size())
+	if s.encoding == SetEncodingIntset {
+		for _, v := range s.ints {
+			result = append(result, strconv.FormatInt(v, 10))
+		}
+		return result
+	}
+	for member := range s.data {
+		result = append(result, member)
+	}
+	return result
+}
+
 // Add adds a member to the set
 // Returns the number of new members added
 func (s *Set) Add(member string) int {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.data[member]; exists {
-		return 0
+	if s.insert(member) {
+		return 1
 	}
-	s.data[member] = struct{}{}
-	return 1
+	return 0
 }
 
 // AddMultiple adds multiple members to the set
@@ -67,8 +204,7 @@ func (s *Set) AddMultiple(members []string) int {
 
 	added := 0
 	for _, member := range members {
-		if _, exists := s.data[member]; !exists {
-			s.data[member] = struct{}{}
+		if s.insert(member) {
 			added++
 		}
 	}
@@ -81,11 +217,7 @@ func (s *Set) Remove(member string) bool {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if _, exists := s.data[member]; exists {
-		delete(s.data, member)
-		return true
-	}
-	return false
+	return s.remove(member)
 }
 
 // RemoveMultiple removes multiple members from the set
@@ -96,8 +228,7 @@ func (s *Set) RemoveMultiple(members []string) int {
 
 	removed := 0
 	for _, member := range members {
-		if _, exists := s.data[member]; exists {
-			delete(s.data, member)
+		if s.remove(member) {
 			removed++
 		}
 	}
@@ -109,8 +240,7 @@ func (s *Set) Contains(member string) bool {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	_, exists := s.data[member]
-	return exists
+	return s.has(member)
 }
 
 // ContainsMultiple checks if multiple members exist in the set
@@ -121,7 +251,7 @@ func (s *Set) ContainsMultiple(members []string) []int {
 
 	result := make([]int, len(members))
 	for i, member := range members {
-		if _, exists := s.data[member]; exists {
+		if s.has(member) {
 			result[i] = 1
 		} else {
 			result[i] = 0
@@ -135,19 +265,16 @@ func (s *Set) Len() int {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	return len(s.data)
+	return s.size()
 }
 
-// Members returns all members of the set
+// Members returns all members of the set: sorted in intset encoding, in
+// no particular order in a hashtable
 func (s *Set) Members() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	members := make([]string, 0, len(s.data))
-	for member := range s.data {
-		members = append(members, member)
-	}
-	return members
+	return s.members()
 }
 
 // Pop removes and returns a random member from the set
@@ -156,10 +283,17 @@ func (s *Set) Pop() (string, bool) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.data) == 0 {
+	if s.size() == 0 {
 		return "", false
 	}
 
+	if s.encoding == SetEncodingIntset {
+		i := rand.IntN(len(s.ints))
+		member := strconv.FormatInt(s.ints[i], 10)
+		s.ints = slices.Delete(s.ints, i, i+1)
+		return member, true
+	}
+
 	// Get a random member
 	for member := range s.data {
 		delete(s.data, member)
@@ -169,22 +303,34 @@ func (s *Set) Pop() (string, bool) {
 	return "", false
 }
 
-// PopMultiple removes and returns multiple random members
+// PopMultiple removes and returns up to count distinct random members.
+// A count larger than the cardinality pops the whole set.
 func (s *Set) PopMultiple(count int) []string {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
-	if len(s.data) == 0 {
-		return nil
+	if count > s.size() {
+		count = s.size()
 	}
 
-	result := []string{}
-	for i := 0; i < count && len(s.data) > 0; i++ {
-		for member := range s.data {
-			delete(s.data, member)
-			result = append(result, member)
+	if s.encoding == SetEncodingIntset {
+		result := make([]string, 0, count)
+		for _, i := range rand.Perm(len(s.ints))[:count] {
+			result = append(result, strconv.FormatInt(s.ints[i], 10))
+		}
+		for _, member := range result {
+			s.remove(member)
+		}
+		return result
+	}
+
+	result := make([]string, 0, count)
+	for member := range s.data {
+		if len(result) >= count {
 			break
 		}
+		delete(s.data, member)
+		result = append(result, member)
 	}
 
 	return result
@@ -195,10 +341,14 @@ func (s *Set) RandomMember() (string, bool) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.data) == 0 {
+	if s.size() == 0 {
 		return "", false
 	}
 
+	if s.encoding == SetEncodingIntset {
+		return strconv.FormatInt(s.ints[rand.IntN(len(s.ints))], 10), true
+	}
+
 	for member := range s.data {
 		return member, true
 	}
@@ -206,30 +356,24 @@ func (s *Set) RandomMember() (string, bool) {
 	return "", false
 }
 
-// RandomMembers returns multiple random members without removing them
+// RandomMembers returns count random members without removing them.
+// Members are picked independently, so the result may contain duplicates.
 func (s *Set) RandomMembers(count int) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.data) == 0 {
+	if s.size() == 0 {
 		return nil
 	}
 
-	members := make([]string, 0, len(s.data))
-	for member := range s.data {
-		members = append(members, member)
-	}
-
-	// Shuffle and return first count
-	rand.Shuffle(len(members), func(i, j int) {
-		members[i], members[j] = members[j], members[i]
-	})
+	members := s.members()
 
-	if count > len(members) {
-		count = len(members)
+	result := make([]string, count)
+	for i := range result {
+		result[i] = members[rand.IntN(len(members))]
 	}
 
-	return members[:count]
+	return result
 }
 
 // RandomMembersDistinct returns distinct random members
@@ -237,14 +381,11 @@ func (s *Set) RandomMembersDistinct(count int) []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	if len(s.data) == 0 {
+	if s.size() == 0 {
 		return nil
 	}
 
-	members := make([]string, 0, len(s.data))
-	for member := range s.data {
-		members = append(members, member)
-	}
+	members := s.members()
 
 	if count >= len(members) {
 		return members
@@ -269,17 +410,17 @@ func (s *Set) MoveTo(member string, dest *Set) bool {
 	dest.mu.Lock()
 	defer dest.mu.Unlock()
 
-	if _, exists := s.data[member]; !exists {
+	if !s.has(member) {
 		return false
 	}
 
 	// Check if destination already has the member
-	if _, exists := dest.data[member]; exists {
+	if dest.has(member) {
 		return false
 	}
 
-	delete(s.data, member)
-	dest.data[member] = struct{}{}
+	s.remove(member)
+	dest.insert(member)
 	return true
 }
 
@@ -295,10 +436,10 @@ func (s *Set) Diff(others []*Set) []string {
 	}
 
 	result := []string{}
-	for member := range s.data {
+	for _, member := range s.members() {
 		found := false
 		for _, other := range others {
-			if _, exists := other.data[member]; exists {
+			if other.has(member) {
 				found = true
 				break
 			}
@@ -323,10 +464,10 @@ func (s *Set) Intersect(others []*Set) []string {
 	}
 
 	result := []string{}
-	for member := range s.data {
+	for _, member := range s.members() {
 		inAll := true
 		for _, other := range others {
-			if _, exists := other.data[member]; !exists {
+			if !other.has(member) {
 				inAll = false
 				break
 			}
@@ -354,7 +495,7 @@ func (s *Set) Union(others []*Set) []string {
 	result := []string{}
 
 	// Add members from this set
-	for member := range s.data {
+	for _, member := range s.members() {
 		if _, exists := seen[member]; !exists {
 			seen[member] = struct{}{}
 			result = append(result, member)
@@ -363,7 +504,7 @@ func (s *Set) Union(others []*Set) []string {
 
 	// Add members from other sets
 	for _, other := range others {
-		for member := range other.data {
+		for _, member := range other.members() {
 			if _, exists := seen[member]; !exists {
 				seen[member] = struct{}{}
 				result = append(result, member)
@@ -381,12 +522,12 @@ func (s *Set) IsSubset(other *Set) bool {
 	other.mu.RLock()
 	defer other.mu.RUnlock()
 
-	if len(s.data) > len(other.data) {
+	if s.size() > other.size() {
 		return false
 	}
 
-	for member := range s.data {
-		if _, exists := other.data[member]; !exists {
+	for _, member := range s.members() {
+		if !other.has(member) {
 			return false
 		}
 	}
@@ -399,6 +540,10 @@ func (s *Set) Clear() {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
+	if s.encoding == SetEncodingIntset {
+		s.ints = nil
+		return
+	}
 	s.data = make(map[string]struct{})
 }
 
@@ -407,10 +552,7 @@ func (s *Set) Scan(cursor int, count int, pattern string) (int, []string) {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	members := make([]string, 0, len(s.data))
-	for member := range s.data {
-		members = append(members, member)
-	}
+	members := s.members()
 
 	// Filter by pattern first
 	var filteredMembers []string
@@ -453,9 +595,13 @@ func (s *Set) Copy() *Set {
 	defer s.mu.RUnlock()
 
 	newSet := &Set{
-		data:     make(map[string]struct{}, len(s.data)),
 		encoding: s.encoding,
 	}
+	if s.encoding == SetEncodingIntset {
+		newSet.ints = slices.Clone(s.ints)
+		return newSet
+	}
+	newSet.data = make(map[string]struct{}, len(s.data))
 	for member := range s.data {
 		newSet.data[member] = struct{}{}
 	}
@@ -464,6 +610,9 @@ func (s *Set) Copy() *Set {
 
 // Encoding returns the set encoding type
 func (s *Set) Encoding() SetEncoding {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
 	return s.encoding
 }
 
@@ -472,6 +621,10 @@ func (s *Set) Size() int64 {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
+	if s.encoding == SetEncodingIntset {
+		return int64(len(s.ints)) * 8
+	}
+
 	size := int64(0)
 	for member := range s.data {
 		size += int64(len(member))
@@ -486,11 +639,7 @@ func (s *Set) ToSlice() []string {
 	s.mu.RLock()
 	defer s.mu.RUnlock()
 
-	result := make([]string, 0, len(s.data))
-	for member := range s.data {
-		result = append(result, member)
-	}
-	return result
+	return s.members()
 }
 
 // matchPattern checks if a member matches a glob pattern
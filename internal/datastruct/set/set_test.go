@@ -0,0 +1,71 @@
+package set
+
+import (
+	"slices"
+	"strconv"
+	"testing"
+)
+
+func TestIntsetConversionBoundary(t *testing.T) {
+	t.Cleanup(func() { SetMaxIntsetEntries(DefaultMaxIntsetEntries) })
+	SetMaxIntsetEntries(4)
+
+	s := NewSet()
+	for i := 4; i > 0; i-- {
+		s.Add(strconv.Itoa(i))
+	}
+	if s.Encoding() != SetEncodingIntset {
+		t.Fatalf("set of 4 integers expected intset, got %s", s.Encoding())
+	}
+	if got := s.Members(); !slices.Equal(got, []string{"1", "2", "3", "4"}) {
+		t.Errorf("intset members expected sorted, got %v", got)
+	}
+	if s.Add("3") != 0 || s.Len() != 4 {
+		t.Error("adding an existing integer expected no change")
+	}
+
+	s.Add("5")
+	if s.Encoding() != SetEncodingHashtable {
+		t.Fatalf("set of 5 integers expected hashtable, got %s", s.Encoding())
+	}
+	for i := 1; i <= 5; i++ {
+		if !s.Contains(strconv.Itoa(i)) {
+			t.Errorf("member %d lost in conversion", i)
+		}
+	}
+
+	// The conversion is one way
+	s.RemoveMultiple([]string{"1", "2", "3", "4"})
+	if s.Encoding() != SetEncodingHashtable {
+		t.Errorf("shrunk set expected to stay hashtable, got %s", s.Encoding())
+	}
+}
+
+func TestIntsetOperations(t *testing.T) {
+	a := NewSetFromSlice([]string{"1", "2", "3", "-7"})
+	b := NewSetFromSlice([]string{"2", "3", "x"})
+	if a.Encoding() != SetEncodingIntset || b.Encoding() != SetEncodingHashtable {
+		t.Fatalf("expected intset and hashtable, got %s and %s", a.Encoding(), b.Encoding())
+	}
+
+	if got := a.Intersect([]*Set{b}); !slices.Equal(got, []string{"2", "3"}) {
+		t.Errorf("Intersect expected [2 3], got %v", got)
+	}
+	if got := a.Diff([]*Set{b}); !slices.Equal(got, []string{"-7", "1"}) {
+		t.Errorf("Diff expected [-7 1], got %v", got)
+	}
+	if a.Contains("01") || a.Contains("x") {
+		t.Error("intset expected not to contain non-canonical or non-integer members")
+	}
+
+	dst := NewSet()
+	if !a.MoveTo("1", dst) || a.Contains("1") || !dst.Contains("1") {
+		t.Error("MoveTo between intsets failed")
+	}
+
+	popped := a.PopMultiple(10)
+	slices.Sort(popped)
+	if !slices.Equal(popped, []string{"-7", "2", "3"}) || a.Len() != 0 {
+		t.Errorf("PopMultiple expected every member, got %v", popped)
+	}
+}
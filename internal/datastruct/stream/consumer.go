@@ -5,30 +5,41 @@
 package stream
 
 import (
+	"sort"
 	"sync"
 )
 
 // ConsumerGroup represents a consumer group in a stream
 type ConsumerGroup struct {
-	name      string
-	lastID    StreamID // Last delivered ID
-	consumers map[string]*Consumer
-	mu        sync.RWMutex
+	name        string
+	lastID      StreamID // Last delivered ID
+	entriesRead int64    // Entries delivered to the group, -1 if unknown
+	consumers   map[string]*Consumer
+	pending     map[StreamID]*PendingEntry // Pending entries list (PEL) of the group
+	mu          sync.RWMutex
 }
 
 // Consumer represents a consumer within a consumer group
 type Consumer struct {
-	name       string
-	pendingIDs map[StreamID]int64 // Pending IDs with their timestamp
-	mu         sync.RWMutex
+	name string
+}
+
+// PendingEntry is a message delivered to a consumer and not yet acknowledged
+type PendingEntry struct {
+	ID            StreamID
+	Consumer      string
+	DeliveryTime  int64
+	DeliveryCount int64
 }
 
 // NewConsumerGroup creates a new consumer group
 func NewConsumerGroup(name string, initialID StreamID) *ConsumerGroup {
 	return &ConsumerGroup{
-		name:      name,
-		lastID:    initialID,
-		consumers: make(map[string]*Consumer),
+		name:        name,
+		lastID:      initialID,
+		entriesRead: -1,
+		consumers:   make(map[string]*Consumer),
+		pending:     make(map[StreamID]*PendingEntry),
 	}
 }
 
@@ -51,6 +62,45 @@ func (cg *ConsumerGroup) SetLastID(id StreamID) {
 	cg.lastID = id
 }
 
+// GetEntriesRead returns the number of entries delivered to the group, or
+// -1 if it is unknown
+func (cg *ConsumerGroup) GetEntriesRead() int64 {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+	return cg.entriesRead
+}
+
+// SetEntriesRead sets the number of entries delivered to the group
+func (cg *ConsumerGroup) SetEntriesRead(n int64) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.entriesRead = n
+}
+
+// AddEntriesRead counts n newly delivered entries. An unknown count stays
+// unknown.
+func (cg *ConsumerGroup) AddEntriesRead(n int64) {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if cg.entriesRead >= 0 {
+		cg.entriesRead += n
+	}
+}
+
+// CreateConsumer adds a consumer to the group
+// Returns false if the consumer already exists
+func (cg *ConsumerGroup) CreateConsumer(name string) bool {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if _, ok := cg.consumers[name]; ok {
+		return false
+	}
+	cg.consumers[name] = &Consumer{name: name}
+	return true
+}
+
 // GetOrCreateConsumer gets or creates a consumer
 func (cg *ConsumerGroup) GetOrCreateConsumer(name string) *Consumer {
 	cg.mu.Lock()
@@ -60,19 +110,26 @@ func (cg *ConsumerGroup) GetOrCreateConsumer(name string) *Consumer {
 		return consumer
 	}
 
-	consumer := &Consumer{
-		name:       name,
-		pendingIDs: make(map[StreamID]int64),
-	}
+	consumer := &Consumer{name: name}
 	cg.consumers[name] = consumer
 	return consumer
 }
 
-// RemoveConsumer removes a consumer
-func (cg *ConsumerGroup) RemoveConsumer(name string) {
+// RemoveConsumer removes a consumer and its pending entries
+// Returns the number of pending entries the consumer had
+func (cg *ConsumerGroup) RemoveConsumer(name string) int {
 	cg.mu.Lock()
 	defer cg.mu.Unlock()
+
+	removed := 0
+	for id, pe := range cg.pending {
+		if pe.Consumer == name {
+			delete(cg.pending, id)
+			removed++
+		}
+	}
 	delete(cg.consumers, name)
+	return removed
 }
 
 // GetConsumers returns all consumers
@@ -87,33 +144,97 @@ func (cg *ConsumerGroup) GetConsumers() map[string]*Consumer {
 	return result
 }
 
-// AddPendingID adds a pending ID for a consumer
+// AddPendingID records a delivery of id to a consumer. Delivering an ID
+// that is already pending moves it to the consumer and counts the delivery.
 func (cg *ConsumerGroup) AddPendingID(consumerName string, id StreamID, timestamp int64) {
-	consumer := cg.GetOrCreateConsumer(consumerName)
-	consumer.AddPendingID(id, timestamp)
+	cg.GetOrCreateConsumer(consumerName)
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+
+	if pe, ok := cg.pending[id]; ok {
+		pe.Consumer = consumerName
+		pe.DeliveryTime = timestamp
+		pe.DeliveryCount++
+		return
+	}
+	cg.pending[id] = &PendingEntry{
+		ID:            id,
+		Consumer:      consumerName,
+		DeliveryTime:  timestamp,
+		DeliveryCount: 1,
+	}
 }
 
-// RemovePendingID removes a pending ID for a consumer
-func (cg *ConsumerGroup) RemovePendingID(consumerName string, id StreamID) {
-	cg.mu.RLock()
-	consumer, ok := cg.consumers[consumerName]
-	cg.mu.RUnlock()
+// RestorePending adds a pending entry as is, creating its consumer. It is
+// used when loading a group from disk.
+func (cg *ConsumerGroup) RestorePending(pe PendingEntry) {
+	cg.GetOrCreateConsumer(pe.Consumer)
+
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
+	cg.pending[pe.ID] = &pe
+}
+
+// Ack removes id from the pending entries list
+// Returns true if the ID was pending
+func (cg *ConsumerGroup) Ack(id StreamID) bool {
+	cg.mu.Lock()
+	defer cg.mu.Unlock()
 
-	if ok {
-		consumer.RemovePendingID(id)
+	if _, ok := cg.pending[id]; !ok {
+		return false
 	}
+	delete(cg.pending, id)
+	return true
 }
 
-// GetPendingIDs returns pending IDs for a consumer
+// GetPendingIDs returns pending IDs for a consumer with their delivery time
 func (cg *ConsumerGroup) GetPendingIDs(consumerName string) map[StreamID]int64 {
 	cg.mu.RLock()
-	consumer, ok := cg.consumers[consumerName]
-	cg.mu.RUnlock()
+	defer cg.mu.RUnlock()
+
+	result := make(map[StreamID]int64)
+	for id, pe := range cg.pending {
+		if pe.Consumer == consumerName {
+			result[id] = pe.DeliveryTime
+		}
+	}
+	return result
+}
+
+// GetPendingEntry returns a copy of the pending entry of id
+func (cg *ConsumerGroup) GetPendingEntry(id StreamID) (PendingEntry, bool) {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
 
+	pe, ok := cg.pending[id]
 	if !ok {
-		return nil
+		return PendingEntry{}, false
+	}
+	return *pe, true
+}
+
+// GetPending returns a copy of the pending entries list sorted by ID
+func (cg *ConsumerGroup) GetPending() []PendingEntry {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+
+	result := make([]PendingEntry, 0, len(cg.pending))
+	for _, pe := range cg.pending {
+		result = append(result, *pe)
 	}
-	return consumer.GetPendingIDs()
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ID.Compare(result[j].ID) < 0
+	})
+	return result
+}
+
+// PendingCount returns the number of pending entries in the group
+func (cg *ConsumerGroup) PendingCount() int {
+	cg.mu.RLock()
+	defer cg.mu.RUnlock()
+	return len(cg.pending)
 }
 
 // Size returns the approximate memory size
@@ -125,45 +246,18 @@ func (cg *ConsumerGroup) Size() int64 {
 	for _, consumer := range cg.consumers {
 		size += consumer.Size()
 	}
+	size += int64(len(cg.pending)) * 48
 	return size
 }
 
-// AddPendingID adds a pending ID
-func (c *Consumer) AddPendingID(id StreamID, timestamp int64) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	c.pendingIDs[id] = timestamp
-}
-
-// RemovePendingID removes a pending ID
-func (c *Consumer) RemovePendingID(id StreamID) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-	delete(c.pendingIDs, id)
-}
-
 // GetName returns the consumer name
 func (c *Consumer) GetName() string {
 	return c.name
 }
 
-// GetPendingIDs returns all pending IDs
-func (c *Consumer) GetPendingIDs() map[StreamID]int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-
-	result := make(map[StreamID]int64, len(c.pendingIDs))
-	for k, v := range c.pendingIDs {
-		result[k] = v
-	}
-	return result
-}
-
 // Size returns the approximate memory size
 func (c *Consumer) Size() int64 {
-	c.mu.RLock()
-	defer c.mu.RUnlock()
-	return int64(len(c.pendingIDs)) * 32
+	return 32 + int64(len(c.name))
 }
 
 // ConsumerGroupManager manages all consumer groups for a stream
@@ -6,6 +6,7 @@ package stream
 
 import (
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -137,6 +138,9 @@ type Stream struct {
 	length    int64          // Number of entries
 	radixTree *RadixTree     // Index for fast lookup by ID prefix
 	cgroups   *ConsumerGroupManager
+
+	entriesAdded int64    // Entries ever added, including deleted ones
+	maxDeletedID StreamID // Highest ID removed by XDEL
 }
 
 // NewStream creates a new stream
@@ -179,6 +183,7 @@ func (s *Stream) Add(fields map[string]string) StreamID {
 	s.entries = append(s.entries, entry)
 	s.lastID = newID
 	s.length++
+	s.entriesAdded++
 
 	// Add to radix tree for indexing
 	s.radixTree.Add(newID, entry)
@@ -206,6 +211,7 @@ func (s *Stream) AddWithID(id StreamID, fields map[string]string) error {
 	s.entries = append(s.entries, entry)
 	s.lastID = id
 	s.length++
+	s.entriesAdded++
 
 	s.radixTree.Add(id, entry)
 
@@ -356,6 +362,9 @@ func (s *Stream) DeleteByID(ids []StreamID) int64 {
 	for _, id := range ids {
 		if s.radixTree.Find(id) != nil {
 			toDelete[id] = true
+			if id.Compare(s.maxDeletedID) > 0 {
+				s.maxDeletedID = id
+			}
 		}
 	}
 
@@ -375,15 +384,10 @@ func (s *Stream) DeleteByID(ids []StreamID) int64 {
 		}
 	}
 
+	// The last ID is kept, so deleted IDs are never handed out again
 	s.entries = newEntries
 	s.length = int64(len(newEntries))
 
-	if s.length > 0 {
-		s.lastID = s.entries[s.length-1].ID
-	} else {
-		s.lastID = StreamID{}
-	}
-
 	s.rebuildRadixTree()
 
 	return removed
@@ -435,6 +439,46 @@ func (s *Stream) XTrim(minID, maxID StreamID) int64 {
 	return int64(removed)
 }
 
+// TrimByMaxLen removes the oldest entries until at most maxLen remain. A
+// positive limit caps the number of entries removed. The last ID is kept,
+// so trimmed IDs are never handed out again.
+// Returns the number of entries removed
+func (s *Stream) TrimByMaxLen(maxLen, limit int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.removeOldest(s.length-maxLen, limit)
+}
+
+// TrimByMinID removes the entries with an ID lower than minID. A positive
+// limit caps the number of entries removed.
+// Returns the number of entries removed
+func (s *Stream) TrimByMinID(minID StreamID, limit int64) int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	n := sort.Search(len(s.entries), func(i int) bool {
+		return s.entries[i].ID.Compare(minID) >= 0
+	})
+	return s.removeOldest(int64(n), limit)
+}
+
+// removeOldest removes the first n entries, at most limit if positive
+func (s *Stream) removeOldest(n, limit int64) int64 {
+	if n <= 0 {
+		return 0
+	}
+	if limit > 0 && n > limit {
+		n = limit
+	}
+
+	s.entries = append([]*StreamEntry(nil), s.entries[n:]...)
+	s.length -= n
+	s.rebuildRadixTree()
+
+	return n
+}
+
 // GetLastID returns the last ID in the stream
 func (s *Stream) GetLastID() StreamID {
 	s.mu.RLock()
@@ -442,6 +486,72 @@ func (s *Stream) GetLastID() StreamID {
 	return s.lastID
 }
 
+// SetLastID sets the last ID of the stream, e.g. when restoring a stream
+// whose newest entries were deleted. IDs lower than the newest entry are ignored.
+func (s *Stream) SetLastID(id StreamID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.length > 0 && id.Compare(s.entries[s.length-1].ID) < 0 {
+		return
+	}
+	s.lastID = id
+}
+
+// EntriesAdded returns the number of entries ever added to the stream
+func (s *Stream) EntriesAdded() int64 {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.entriesAdded
+}
+
+// SetEntriesAdded sets the number of entries ever added, e.g. when
+// restoring a stream. Values below the current length are ignored.
+func (s *Stream) SetEntriesAdded(n int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if n >= s.length {
+		s.entriesAdded = n
+	}
+}
+
+// MaxDeletedID returns the highest ID deleted from the stream
+func (s *Stream) MaxDeletedID() StreamID {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.maxDeletedID
+}
+
+// SetMaxDeletedID sets the highest deleted ID, e.g. when restoring a stream
+func (s *Stream) SetMaxDeletedID(id StreamID) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.maxDeletedID = id
+}
+
+// FirstEntry returns the oldest entry, or nil if the stream is empty
+func (s *Stream) FirstEntry() *StreamEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.length == 0 {
+		return nil
+	}
+	return s.entries[0]
+}
+
+// LastEntry returns the newest entry, or nil if the stream is empty
+func (s *Stream) LastEntry() *StreamEntry {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if s.length == 0 {
+		return nil
+	}
+	return s.entries[s.length-1]
+}
+
 // GetConsumerGroupManager returns the consumer group manager
 func (s *Stream) GetConsumerGroupManager() *ConsumerGroupManager {
 	return s.cgroups
@@ -495,6 +605,8 @@ func (s *Stream) Clear() {
 	s.length = 0
 	s.lastID = StreamID{}
 	s.radixTree = NewRadixTree()
+	s.entriesAdded = 0
+	s.maxDeletedID = StreamID{}
 }
 
 // Size returns the approximate memory size of the stream in bytes
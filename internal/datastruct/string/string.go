@@ -6,6 +6,7 @@ package str
 
 import (
 	"fmt"
+	"math/bits"
 	"strconv"
 	"strings"
 )
@@ -242,46 +243,99 @@ func (s *String) SetBit(offset int, value int) byte {
 	return oldValue
 }
 
-// BitCount counts the number of bits set in a range
-func (s *String) BitCount(start, end int) int {
-	runes := []rune(s.value)
-	length := len(runes)
+// BitCount counts the number of bits set in the inclusive range [start, end].
+// The range is in bytes, or in bits if bitUnit is set; negative indices
+// count from the end.
+func (s *String) BitCount(start, end int, bitUnit bool) int {
+	length := len(s.value)
+	if bitUnit {
+		length *= 8
+	}
 
-	// Handle negative indices
+	start, end, ok := normalizeRange(start, end, length)
+	if !ok {
+		return 0
+	}
+
+	if bitUnit {
+		// Mask the partial first and last bytes, count the ones in between
+		first, last := start/8, end/8
+		head := s.value[first] & (0xff >> (start % 8))
+		tail := s.value[last] & (0xff << (7 - end%8))
+		if first == last {
+			return bits.OnesCount8(head & tail)
+		}
+		return bits.OnesCount8(head) + popcount(s.value[first+1:last]) + bits.OnesCount8(tail)
+	}
+
+	return popcount(s.value[start : end+1])
+}
+
+// popcount returns the number of set bits in b, counting eight bytes at a
+// time
+func popcount(b string) int {
+	count := 0
+	for len(b) >= 8 {
+		word := uint64(b[0]) | uint64(b[1])<<8 | uint64(b[2])<<16 | uint64(b[3])<<24 |
+			uint64(b[4])<<32 | uint64(b[5])<<40 | uint64(b[6])<<48 | uint64(b[7])<<56
+		count += bits.OnesCount64(word)
+		b = b[8:]
+	}
+	for i := 0; i < len(b); i++ {
+		count += bits.OnesCount8(b[i])
+	}
+	return count
+}
+
+// BitPos returns the position of the first bit equal to bit within the
+// inclusive range [start, end], or -1 if there is none. The range is in
+// bytes, or in bits if bitUnit is set; negative indices count from the end.
+// The position is always in bits from the start of the string.
+func (s *String) BitPos(bit byte, start, end int, bitUnit bool) int {
+	length := len(s.value)
+	if bitUnit {
+		length *= 8
+	}
+
+	start, end, ok := normalizeRange(start, end, length)
+	if !ok {
+		return -1
+	}
+
+	// Scan a bit range directly, a byte range bit by bit from its first byte
+	if !bitUnit {
+		start, end = start*8, end*8+7
+	}
+	for i := start; i <= end; i++ {
+		if s.GetBit(i) == bit {
+			return i
+		}
+	}
+	return -1
+}
+
+// normalizeRange resolves an inclusive range with negative indices against
+// length. It returns false if the range is empty.
+func normalizeRange(start, end, length int) (int, int, bool) {
 	if start < 0 {
-		start = length + start
+		start += length
 		if start < 0 {
 			start = 0
 		}
 	}
 	if end < 0 {
-		end = length + end
+		end += length
 		if end < 0 {
 			end = 0
 		}
 	}
-
-	// Clamp indices
-	if start >= length {
-		return 0
-	}
 	if end >= length {
 		end = length - 1
 	}
-	if start > end {
-		return 0
+	if start >= length || start > end {
+		return 0, 0, false
 	}
-
-	count := 0
-	for i := start; i <= end; i++ {
-		b := s.value[i]
-		for b != 0 {
-			count += int(b & 1)
-			b >>= 1
-		}
-	}
-
-	return count
+	return start, end, true
 }
 
 // BitOp performs bitwise operations on strings
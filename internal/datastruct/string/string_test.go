@@ -0,0 +1,69 @@
+package str
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// naiveBitCount counts the set bits in [start, end] one bit at a time
+func naiveBitCount(s *String, start, end int) int {
+	count := 0
+	for i := start; i <= end; i++ {
+		count += int(s.GetBit(i))
+	}
+	return count
+}
+
+func randomString(n int) *String {
+	rng := rand.New(rand.NewSource(1))
+	b := make([]byte, n)
+	rng.Read(b)
+	return NewString(string(b))
+}
+
+func TestBitCountMatchesNaive(t *testing.T) {
+	s := randomString(37)
+	length := 37 * 8
+
+	for start := 0; start < length; start += 3 {
+		for end := start; end < length; end += 5 {
+			if got, want := s.BitCount(start, end, true), naiveBitCount(s, start, end); got != want {
+				t.Fatalf("BitCount(%d, %d, BIT) = %d, expected %d", start, end, got, want)
+			}
+		}
+	}
+	for start := 0; start < 37; start++ {
+		for end := start; end < 37; end++ {
+			if got, want := s.BitCount(start, end, false), naiveBitCount(s, start*8, end*8+7); got != want {
+				t.Fatalf("BitCount(%d, %d, BYTE) = %d, expected %d", start, end, got, want)
+			}
+		}
+	}
+}
+
+func BenchmarkBitCount(b *testing.B) {
+	s := randomString(1 << 20)
+	b.SetBytes(1 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.BitCount(0, -1, false)
+	}
+}
+
+func BenchmarkBitCountBitRange(b *testing.B) {
+	s := randomString(1 << 20)
+	b.SetBytes(1 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		s.BitCount(3, 8<<20-5, true)
+	}
+}
+
+func BenchmarkBitCountNaive(b *testing.B) {
+	s := randomString(1 << 20)
+	b.SetBytes(1 << 20)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		naiveBitCount(s, 0, 8<<20-1)
+	}
+}
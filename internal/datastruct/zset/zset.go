@@ -5,32 +5,80 @@
 package zset
 
 import (
+	"errors"
 	"math"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
+// ErrNaN is returned when an increment would make a score NaN, such as
+// adding -inf to +inf
+var ErrNaN = errors.New("resulting score is not a number (NaN)")
+
 // ZSetEncoding represents the encoding type of a sorted set
 type ZSetEncoding byte
 
 const (
 	// ZSetEncodingSkiplist uses a skiplist + hashtable
 	ZSetEncodingSkiplist ZSetEncoding = iota
-	// ZSetEncodingZiplist uses a ziplist (TODO)
-	ZSetEncodingZiplist
+	// ZSetEncodingListpack uses a slice of members ordered by score
+	ZSetEncodingListpack
+)
+
+// String returns the encoding name reported by OBJECT ENCODING
+func (e ZSetEncoding) String() string {
+	if e == ZSetEncodingListpack {
+		return "listpack"
+	}
+	return "skiplist"
+}
+
+// Defaults of zset-max-listpack-entries and zset-max-listpack-value
+const (
+	DefaultMaxListpackEntries = 128
+	DefaultMaxListpackValue   = 64
 )
 
+// maxListpackEntries and maxListpackValue are the largest sorted set, and
+// the longest member, kept in listpack encoding
+var maxListpackEntries, maxListpackValue atomic.Int64
+
+func init() {
+	maxListpackEntries.Store(DefaultMaxListpackEntries)
+	maxListpackValue.Store(DefaultMaxListpackValue)
+}
+
+// SetMaxListpack sets the largest sorted set and the longest member kept in
+// listpack encoding (zset-max-listpack-entries and zset-max-listpack-value).
+// Sorted sets past the limits convert to a skiplist on their next insert.
+func SetMaxListpack(entries, value int) {
+	maxListpackEntries.Store(int64(entries))
+	maxListpackValue.Store(int64(value))
+}
+
 // ZMember represents a member with its score
 type ZMember struct {
 	Member string
 	Score  float64
 }
 
-// ZSet represents a Redis sorted set data structure
-// Uses a combination of skiplist (for range operations) and hash map (for O(1) lookups)
+// less reports whether m sorts before (score, member)
+func (m ZMember) less(score float64, member string) bool {
+	return m.Score < score || (m.Score == score && m.Member < member)
+}
+
+// ZSet represents a Redis sorted set data structure. A small sorted set is
+// kept as a slice ordered by score (listpack encoding) until it grows past
+// zset-max-listpack-entries or receives a member longer than
+// zset-max-listpack-value; it then moves to a skiplist (for range
+// operations) and a hash map (for O(1) lookups). The conversion is one way.
 type ZSet struct {
 	mu       sync.RWMutex
+	listpack []ZMember          // Members ordered by score, in listpack encoding
 	dict     map[string]float64 // member -> score for O(1) lookups
 	skiplist *SkipList          // for ordered operations
 	encoding ZSetEncoding
@@ -39,27 +87,230 @@ type ZSet struct {
 // NewZSet creates a new sorted set
 func NewZSet() *ZSet {
 	return &ZSet{
-		dict:     make(map[string]float64),
-		skiplist: NewSkipList(),
-		encoding: ZSetEncodingSkiplist,
+		encoding: ZSetEncodingListpack,
 	}
 }
 
-// Add adds or updates a member with the given score
-// Returns the number of new members added (0 if updated, 1 if new)
-func (z *ZSet) Add(member string, score float64) int {
-	z.mu.Lock()
-	defer z.mu.Unlock()
+// convertToSkiplist moves the members of a listpack to the skiplist and
+// dict (with z.mu held for writing)
+func (z *ZSet) convertToSkiplist() {
+	if z.encoding != ZSetEncodingListpack {
+		return
+	}
+	z.dict = make(map[string]float64, len(z.listpack)+1)
+	z.skiplist = NewSkipList()
+	for _, m := range z.listpack {
+		z.dict[m.Member] = m.Score
+		z.skiplist.Insert(m.Member, m.Score)
+	}
+	z.listpack = nil
+	z.encoding = ZSetEncodingSkiplist
+}
 
-	_, exists := z.dict[member]
+// listpackIndex returns the position of member in the listpack, or -1
+func (z *ZSet) listpackIndex(member string) int {
+	for i, m := range z.listpack {
+		if m.Member == member {
+			return i
+		}
+	}
+	return -1
+}
 
-	// Update dict
-	z.dict[member] = score
+// listpackSearch returns the position where (score, member) belongs in the
+// listpack
+func (z *ZSet) listpackSearch(score float64, member string) int {
+	return sort.Search(len(z.listpack), func(i int) bool {
+		return !z.listpack[i].less(score, member)
+	})
+}
 
-	// Update skiplist
+// insert adds member or updates its score, converting the encoding when
+// needed, and returns true if it was not already present (with z.mu held
+// for writing)
+func (z *ZSet) insert(member string, score float64) bool {
+	if z.encoding == ZSetEncodingListpack {
+		i := z.listpackIndex(member)
+		if i >= 0 {
+			if z.listpack[i].Score == score {
+				return false
+			}
+			z.listpack = append(z.listpack[:i], z.listpack[i+1:]...)
+		}
+		if i >= 0 || (int64(len(z.listpack)) < maxListpackEntries.Load() &&
+			int64(len(member)) <= maxListpackValue.Load()) {
+			j := z.listpackSearch(score, member)
+			z.listpack = append(z.listpack, ZMember{})
+			copy(z.listpack[j+1:], z.listpack[j:])
+			z.listpack[j] = ZMember{Member: member, Score: score}
+			return i < 0
+		}
+		z.convertToSkiplist()
+	}
+
+	old, exists := z.dict[member]
+	if exists {
+		if old == score {
+			return false
+		}
+		z.skiplist.Delete(member, old)
+	}
+	z.dict[member] = score
 	z.skiplist.Insert(member, score)
+	return !exists
+}
+
+// remove deletes member and returns true if it was present (with z.mu
+// held for writing)
+func (z *ZSet) remove(member string) bool {
+	if z.encoding == ZSetEncodingListpack {
+		i := z.listpackIndex(member)
+		if i < 0 {
+			return false
+		}
+		z.listpack = append(z.listpack[:i], z.listpack[i+1:]...)
+		return true
+	}
+
+	score, exists := z.dict[member]
+	if !exists {
+		return false
+	}
+	delete(z.dict, member)
+	z.skiplist.Delete(member, score)
+	return true
+}
+
+// score returns the score of member (with z.mu held)
+func (z *ZSet) score(member string) (float64, bool) {
+	if z.encoding == ZSetEncodingListpack {
+		if i := z.listpackIndex(member); i >= 0 {
+			return z.listpack[i].Score, true
+		}
+		return 0, false
+	}
+	score, exists := z.dict[member]
+	return score, exists
+}
+
+// length returns the number of members (with z.mu held)
+func (z *ZSet) length() int {
+	if z.encoding == ZSetEncodingListpack {
+		return len(z.listpack)
+	}
+	return len(z.dict)
+}
 
+// rank returns the 0-based ascending rank of member, or -1 (with z.mu held)
+func (z *ZSet) rank(member string) int64 {
+	if z.encoding == ZSetEncodingListpack {
+		return int64(z.listpackIndex(member))
+	}
+	score, exists := z.dict[member]
 	if !exists {
+		return -1
+	}
+	return z.skiplist.GetRank(member, score)
+}
+
+// rangeByRank returns the members in the rank range [start, end], where
+// negative ranks count from the end (with z.mu held)
+func (z *ZSet) rangeByRank(start, end int) []ZMember {
+	if z.encoding != ZSetEncodingListpack {
+		return fromNodes(z.skiplist.GetRangeByRank(start, end))
+	}
+
+	n := len(z.listpack)
+	if start < 0 {
+		start = max(n+start, 0)
+	}
+	if end < 0 {
+		end = n + end
+	}
+	end = min(end, n-1)
+	if start > end {
+		return []ZMember{}
+	}
+	result := make([]ZMember, end-start+1)
+	copy(result, z.listpack[start:end+1])
+	return result
+}
+
+// rangeByScore returns the members with a score in [min, max] (with z.mu
+// held)
+func (z *ZSet) rangeByScore(min, max float64) []ZMember {
+	if z.encoding != ZSetEncodingListpack {
+		return fromNodes(z.skiplist.GetRangeByScore(min, max))
+	}
+
+	result := []ZMember{}
+	for _, m := range z.listpack {
+		if m.Score > max {
+			break
+		}
+		if m.Score >= min {
+			result = append(result, m)
+		}
+	}
+	return result
+}
+
+// all returns every member in order (with z.mu held)
+func (z *ZSet) all() []ZMember {
+	return z.rangeByRank(0, -1)
+}
+
+// pop removes and returns the member with the lowest score, or the highest
+// if last is set (with z.mu held for writing)
+func (z *ZSet) pop(last bool) (ZMember, bool) {
+	if z.encoding == ZSetEncodingListpack {
+		n := len(z.listpack)
+		if n == 0 {
+			return ZMember{}, false
+		}
+		var m ZMember
+		if last {
+			m = z.listpack[n-1]
+			z.listpack = z.listpack[:n-1]
+		} else {
+			m = z.listpack[0]
+			z.listpack = append(z.listpack[:0], z.listpack[1:]...)
+		}
+		return m, true
+	}
+
+	if len(z.dict) == 0 {
+		return ZMember{}, false
+	}
+	var node *skipListNode
+	if last {
+		node = z.skiplist.PopLast()
+	} else {
+		node = z.skiplist.PopFirst()
+	}
+	if node == nil {
+		return ZMember{}, false
+	}
+	delete(z.dict, node.member)
+	return ZMember{Member: node.member, Score: node.score}, true
+}
+
+// fromNodes converts skiplist nodes to members
+func fromNodes(nodes []*skipListNode) []ZMember {
+	result := make([]ZMember, len(nodes))
+	for i, node := range nodes {
+		result[i] = ZMember{Member: node.member, Score: node.score}
+	}
+	return result
+}
+
+// Add adds or updates a member with the given score
+// Returns the number of new members added (0 if updated, 1 if new)
+func (z *ZSet) Add(member string, score float64) int {
+	z.mu.Lock()
+	defer z.mu.Unlock()
+
+	if z.insert(member, score) {
 		return 1
 	}
 	return 0
@@ -73,11 +324,9 @@ func (z *ZSet) AddMultiple(members []ZMember) int {
 
 	added := 0
 	for _, m := range members {
-		if _, exists := z.dict[m.Member]; !exists {
+		if z.insert(m.Member, m.Score) {
 			added++
 		}
-		z.dict[m.Member] = m.Score
-		z.skiplist.Insert(m.Member, m.Score)
 	}
 
 	return added
@@ -89,15 +338,7 @@ func (z *ZSet) Remove(member string) bool {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	score, exists := z.dict[member]
-	if !exists {
-		return false
-	}
-
-	delete(z.dict, member)
-	z.skiplist.Delete(member, score)
-
-	return true
+	return z.remove(member)
 }
 
 // RemoveMultiple removes multiple members
@@ -108,9 +349,7 @@ func (z *ZSet) RemoveMultiple(members []string) int {
 
 	removed := 0
 	for _, member := range members {
-		if score, exists := z.dict[member]; exists {
-			delete(z.dict, member)
-			z.skiplist.Delete(member, score)
+		if z.remove(member) {
 			removed++
 		}
 	}
@@ -124,8 +363,7 @@ func (z *ZSet) Score(member string) (float64, bool) {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	score, exists := z.dict[member]
-	return score, exists
+	return z.score(member)
 }
 
 // ScoreMultiple returns scores for multiple members
@@ -135,7 +373,7 @@ func (z *ZSet) ScoreMultiple(members []string) []interface{} {
 
 	result := make([]interface{}, len(members))
 	for i, member := range members {
-		if score, exists := z.dict[member]; exists {
+		if score, exists := z.score(member); exists {
 			result[i] = score
 		} else {
 			result[i] = nil
@@ -151,12 +389,7 @@ func (z *ZSet) Rank(member string) int64 {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	score, exists := z.dict[member]
-	if !exists {
-		return -1
-	}
-
-	return z.skiplist.GetRank(member, score)
+	return z.rank(member)
 }
 
 // RevRank returns the rank of a member (0-based, descending by score)
@@ -165,12 +398,12 @@ func (z *ZSet) RevRank(member string) int64 {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	rank := z.Rank(member)
+	rank := z.rank(member)
 	if rank == -1 {
 		return -1
 	}
 
-	return int64(z.skiplist.Len()) - 1 - rank
+	return int64(z.length()) - 1 - rank
 }
 
 // Range returns members in the rank range [start, end] (0-based, inclusive)
@@ -178,13 +411,7 @@ func (z *ZSet) Range(start, end int) []ZMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	nodes := z.skiplist.GetRangeByRank(start, end)
-	result := make([]ZMember, len(nodes))
-	for i, node := range nodes {
-		result[i] = ZMember{Member: node.member, Score: node.score}
-	}
-
-	return result
+	return z.rangeByRank(start, end)
 }
 
 // RangeWithScores returns members with scores in the rank range [start, end]
@@ -197,12 +424,11 @@ func (z *ZSet) RevRange(start, end int) []ZMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	nodes := z.skiplist.GetRangeByRank(start, end)
-	result := make([]ZMember, len(nodes))
+	result := z.rangeByRank(start, end)
 
 	// Reverse the result
-	for i, node := range nodes {
-		result[len(nodes)-1-i] = ZMember{Member: node.member, Score: node.score}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
 	}
 
 	return result
@@ -213,13 +439,7 @@ func (z *ZSet) RangeByScore(min, max float64) []ZMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	nodes := z.skiplist.GetRangeByScore(min, max)
-	result := make([]ZMember, len(nodes))
-	for i, node := range nodes {
-		result[i] = ZMember{Member: node.member, Score: node.score}
-	}
-
-	return result
+	return z.rangeByScore(min, max)
 }
 
 // Count returns the number of members in the score range [min, max]
@@ -227,6 +447,9 @@ func (z *ZSet) Count(min, max float64) int {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
+	if z.encoding == ZSetEncodingListpack {
+		return len(z.rangeByScore(min, max))
+	}
 	return int(z.skiplist.CountInRange(min, max))
 }
 
@@ -235,27 +458,39 @@ func (z *ZSet) Len() int {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	return len(z.dict)
+	return z.length()
 }
 
 // IncrBy increments the score of a member by delta
 // Returns the new score
-func (z *ZSet) IncrBy(member string, delta float64) float64 {
+func (z *ZSet) IncrBy(member string, delta float64) (float64, error) {
+	newScore, _, err := z.IncrByIf(member, delta, nil)
+	return newScore, err
+}
+
+// IncrByIf increments the score of a member by delta if allow, called with
+// the current score (0 for a new member) and whether the member exists,
+// accepts the new score. A nil allow accepts any score. The check and the
+// update happen under one lock, so a concurrent change cannot slip in
+// between. It returns the new score and whether it was applied.
+func (z *ZSet) IncrByIf(member string, delta float64, allow func(oldScore, newScore float64, exists bool) bool) (float64, bool, error) {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	newScore := delta
-	if score, exists := z.dict[member]; exists {
-		newScore = score + delta
-
-		// Remove old node
-		z.skiplist.Delete(member, score)
+	oldScore, exists := z.score(member)
+	newScore := oldScore + delta
+	if !exists {
+		newScore = delta
 	}
+	if allow != nil && !allow(oldScore, newScore, exists) {
+		return oldScore, false, nil
+	}
+	if math.IsNaN(newScore) {
+		return 0, false, ErrNaN
+	}
+	z.insert(member, newScore)
 
-	z.dict[member] = newScore
-	z.skiplist.Insert(member, newScore)
-
-	return newScore
+	return newScore, true, nil
 }
 
 // PopMax removes and returns the member with the highest score
@@ -263,18 +498,7 @@ func (z *ZSet) PopMax() (ZMember, bool) {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	if len(z.dict) == 0 {
-		return ZMember{}, false
-	}
-
-	node := z.skiplist.PopLast()
-	if node == nil {
-		return ZMember{}, false
-	}
-
-	delete(z.dict, node.member)
-
-	return ZMember{Member: node.member, Score: node.score}, true
+	return z.pop(true)
 }
 
 // PopMaxMultiple removes and returns multiple members with highest scores
@@ -283,13 +507,12 @@ func (z *ZSet) PopMaxMultiple(count int) []ZMember {
 	defer z.mu.Unlock()
 
 	result := []ZMember{}
-	for i := 0; i < count && len(z.dict) > 0; i++ {
-		node := z.skiplist.PopLast()
-		if node == nil {
+	for i := 0; i < count; i++ {
+		m, ok := z.pop(true)
+		if !ok {
 			break
 		}
-		delete(z.dict, node.member)
-		result = append(result, ZMember{Member: node.member, Score: node.score})
+		result = append(result, m)
 	}
 
 	return result
@@ -300,18 +523,7 @@ func (z *ZSet) PopMin() (ZMember, bool) {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	if len(z.dict) == 0 {
-		return ZMember{}, false
-	}
-
-	node := z.skiplist.PopFirst()
-	if node == nil {
-		return ZMember{}, false
-	}
-
-	delete(z.dict, node.member)
-
-	return ZMember{Member: node.member, Score: node.score}, true
+	return z.pop(false)
 }
 
 // PopMinMultiple removes and returns multiple members with lowest scores
@@ -320,13 +532,12 @@ func (z *ZSet) PopMinMultiple(count int) []ZMember {
 	defer z.mu.Unlock()
 
 	result := []ZMember{}
-	for i := 0; i < count && len(z.dict) > 0; i++ {
-		node := z.skiplist.PopFirst()
-		if node == nil {
+	for i := 0; i < count; i++ {
+		m, ok := z.pop(false)
+		if !ok {
 			break
 		}
-		delete(z.dict, node.member)
-		result = append(result, ZMember{Member: node.member, Score: node.score})
+		result = append(result, m)
 	}
 
 	return result
@@ -338,16 +549,11 @@ func (z *ZSet) RemoveRangeByRank(start, end int) int {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	nodes := z.skiplist.GetRangeByRank(start, end)
 	removed := 0
-
-	for _, node := range nodes {
-		if _, exists := z.dict[node.member]; exists {
-			delete(z.dict, node.member)
+	for _, m := range z.rangeByRank(start, end) {
+		if z.remove(m.Member) {
 			removed++
 		}
-		// Delete from skiplist
-		z.skiplist.Delete(node.member, node.score)
 	}
 
 	return removed
@@ -359,16 +565,11 @@ func (z *ZSet) RemoveRangeByScore(min, max float64) int {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	nodes := z.skiplist.GetRangeByScore(min, max)
 	removed := 0
-
-	for _, node := range nodes {
-		if _, exists := z.dict[node.member]; exists {
-			delete(z.dict, node.member)
+	for _, m := range z.rangeByScore(min, max) {
+		if z.remove(m.Member) {
 			removed++
 		}
-		// Delete from skiplist
-		z.skiplist.Delete(node.member, node.score)
 	}
 
 	return removed
@@ -379,9 +580,10 @@ func (z *ZSet) Members() []string {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	members := make([]string, 0, len(z.dict))
-	for member := range z.dict {
-		members = append(members, member)
+	all := z.all()
+	members := make([]string, len(all))
+	for i, m := range all {
+		members[i] = m.Member
 	}
 
 	return members
@@ -392,13 +594,7 @@ func (z *ZSet) GetAll() []ZMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	nodes := z.skiplist.GetAll()
-	result := make([]ZMember, len(nodes))
-	for i, node := range nodes {
-		result[i] = ZMember{Member: node.member, Score: node.score}
-	}
-
-	return result
+	return z.all()
 }
 
 // Scan iterates over members with cursor
@@ -406,28 +602,25 @@ func (z *ZSet) Scan(cursor int, count int) (int, []ZMember) {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
-	nodes := z.skiplist.GetAll()
+	all := z.all()
 
 	if cursor < 0 {
 		cursor = 0
 	}
 
-	if cursor >= len(nodes) {
+	if cursor >= len(all) {
 		return 0, nil
 	}
 
 	end := cursor + count
-	if end > len(nodes) {
-		end = len(nodes)
+	if end > len(all) {
+		end = len(all)
 	}
 
-	result := make([]ZMember, 0, end-cursor)
-	for i := cursor; i < end; i++ {
-		result = append(result, ZMember{Member: nodes[i].member, Score: nodes[i].score})
-	}
+	result := all[cursor:end]
 
 	newCursor := end
-	if newCursor >= len(nodes) {
+	if newCursor >= len(all) {
 		newCursor = 0
 	}
 
@@ -439,12 +632,17 @@ func (z *ZSet) Clear() {
 	z.mu.Lock()
 	defer z.mu.Unlock()
 
-	z.dict = make(map[string]float64)
-	z.skiplist = NewSkipList()
+	z.listpack = nil
+	z.dict = nil
+	z.skiplist = nil
+	z.encoding = ZSetEncodingListpack
 }
 
 // Encoding returns the sorted set encoding type
 func (z *ZSet) Encoding() ZSetEncoding {
+	z.mu.RLock()
+	defer z.mu.RUnlock()
+
 	return z.encoding
 }
 
@@ -454,17 +652,21 @@ func (z *ZSet) Size() int64 {
 	defer z.mu.RUnlock()
 
 	size := int64(0)
-	for member := range z.dict {
-		size += int64(len(member) + 8) // 8 bytes for float64
+	for _, m := range z.all() {
+		size += int64(len(m.Member) + 8) // 8 bytes for float64
+	}
+	if z.encoding == ZSetEncodingListpack {
+		return size
 	}
 	// Add overhead for map and skiplist
 	size += int64(len(z.dict)) * 32
 	return size
 }
 
-// Intersect computes the intersection with other sorted sets
-// For intersection, we take the MIN score for members present in all sets
-func (z *ZSet) Intersect(others []*ZSet, aggregate string) []ZMember {
+// Intersect computes the intersection with other sorted sets. weights holds
+// the factor of each set, this one first; a nil weights leaves the scores
+// as they are.
+func (z *ZSet) Intersect(others []*ZSet, weights []float64, aggregate string) []ZMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
@@ -476,37 +678,24 @@ func (z *ZSet) Intersect(others []*ZSet, aggregate string) []ZMember {
 
 	// Find common members
 	if len(others) == 0 {
-		return z.GetAll()
+		return z.all()
 	}
 
 	// Count occurrences and aggregate scores
 	counts := make(map[string]int)
 	scores := make(map[string]float64)
 
-	for _, member := range z.Members() {
-		counts[member] = 1
-		scores[member] = z.dict[member]
+	for _, m := range z.all() {
+		counts[m.Member] = 1
+		scores[m.Member] = weightScore(m.Score, weights, 0)
 	}
 
-	for _, other := range others {
-		for member, score := range other.dict {
+	for i, other := range others {
+		for _, m := range other.all() {
+			member, score := m.Member, weightScore(m.Score, weights, i+1)
 			counts[member]++
 			if _, exists := scores[member]; exists {
-				switch aggregate {
-				case "sum", "SUM":
-					scores[member] += score
-				case "min", "MIN":
-					if score < scores[member] {
-						scores[member] = score
-					}
-				case "max", "MAX":
-					if score > scores[member] {
-						scores[member] = score
-					}
-				default:
-					// Default to sum
-					scores[member] += score
-				}
+				scores[member] = aggregateScore(aggregate, scores[member], score)
 			}
 		}
 	}
@@ -525,8 +714,38 @@ func (z *ZSet) Intersect(others []*ZSet, aggregate string) []ZMember {
 	return result
 }
 
-// Union computes the union with other sorted sets
-func (z *ZSet) Union(others []*ZSet, aggregate string) []ZMember {
+// weightScore multiplies a score by the weight of the i-th set. An infinite
+// score weighted by 0 is 0, as in Redis.
+func weightScore(score float64, weights []float64, i int) float64 {
+	if weights == nil {
+		return score
+	}
+	if w := score * weights[i]; !math.IsNaN(w) {
+		return w
+	}
+	return 0
+}
+
+// aggregateScore combines the scores of a member found in several sorted
+// sets. A SUM of +inf and -inf is 0, as in Redis.
+func aggregateScore(aggregate string, acc, score float64) float64 {
+	switch strings.ToLower(aggregate) {
+	case "min":
+		return math.Min(acc, score)
+	case "max":
+		return math.Max(acc, score)
+	default:
+		sum := acc + score
+		if math.IsNaN(sum) {
+			return 0
+		}
+		return sum
+	}
+}
+
+// Union computes the union with other sorted sets, weighting the scores as
+// Intersect does
+func (z *ZSet) Union(others []*ZSet, weights []float64, aggregate string) []ZMember {
 	z.mu.RLock()
 	defer z.mu.RUnlock()
 
@@ -539,28 +758,16 @@ func (z *ZSet) Union(others []*ZSet, aggregate string) []ZMember {
 	scores := make(map[string]float64)
 
 	// Add scores from this set
-	for member, score := range z.dict {
-		scores[member] = score
+	for _, m := range z.all() {
+		scores[m.Member] = weightScore(m.Score, weights, 0)
 	}
 
 	// Aggregate scores from other sets
-	for _, other := range others {
-		for member, score := range other.dict {
+	for i, other := range others {
+		for _, m := range other.all() {
+			member, score := m.Member, weightScore(m.Score, weights, i+1)
 			if _, exists := scores[member]; exists {
-				switch aggregate {
-				case "sum", "SUM":
-					scores[member] += score
-				case "min", "MIN":
-					if score < scores[member] {
-						scores[member] = score
-					}
-				case "max", "MAX":
-					if score > scores[member] {
-						scores[member] = score
-					}
-				default:
-					scores[member] += score
-				}
+				scores[member] = aggregateScore(aggregate, scores[member], score)
 			} else {
 				scores[member] = score
 			}
@@ -594,35 +801,29 @@ func (z *ZSet) Diff(others []*ZSet) []ZMember {
 	// Build set of members to exclude
 	exclude := make(map[string]bool)
 	for _, other := range others {
-		for member := range other.dict {
-			exclude[member] = true
+		for _, m := range other.all() {
+			exclude[m.Member] = true
 		}
 	}
 
 	// Filter and build result
 	result := []ZMember{}
-	for _, node := range z.skiplist.GetAll() {
-		if !exclude[node.member] {
-			result = append(result, ZMember{Member: node.member, Score: node.score})
+	for _, m := range z.all() {
+		if !exclude[m.Member] {
+			result = append(result, m)
 		}
 	}
 
 	return result
 }
 
-// sortZMembers sorts members by score (ascending), then by member (lexicographic)
+// sortZMembers sorts members by score (ascending), then by member
+// (lexicographic). Members are unique, so the order is total and does not
+// depend on the stability of the sort.
 func sortZMembers(members []ZMember) {
-	// Simple insertion sort (can be optimized with quicksort for large sets)
-	for i := 1; i < len(members); i++ {
-		for j := i; j > 0; j-- {
-			if members[j].Score < members[j-1].Score ||
-				(members[j].Score == members[j-1].Score && members[j].Member < members[j-1].Member) {
-				members[j], members[j-1] = members[j-1], members[j]
-			} else {
-				break
-			}
-		}
-	}
+	sort.Slice(members, func(i, j int) bool {
+		return members[i].less(members[j].Score, members[j].Member)
+	})
 }
 
 // ZMember represents a member-score pair for range operations
@@ -2,6 +2,9 @@ package zset
 
 import (
 	"fmt"
+	"math"
+	"math/rand"
+	"strconv"
 	"testing"
 )
 
@@ -41,7 +44,7 @@ func TestZSetBasic(t *testing.T) {
 	}
 
 	// Test IncrBy
-	newScore := zs.IncrBy("two", 2.0)
+	newScore, _ := zs.IncrBy("two", 2.0)
 	if newScore != 4.0 {
 		t.Errorf("ZINCRBY two 2 expected 4.0, got %f", newScore)
 	}
@@ -84,3 +87,202 @@ func TestZSetBasic(t *testing.T) {
 
 	fmt.Println("=== All ZSet tests passed! ===")
 }
+
+func TestListpackEntryThreshold(t *testing.T) {
+	t.Cleanup(func() { SetMaxListpack(DefaultMaxListpackEntries, DefaultMaxListpackValue) })
+	SetMaxListpack(4, DefaultMaxListpackValue)
+
+	zs := NewZSet()
+	for i := 4; i > 0; i-- {
+		zs.Add(fmt.Sprintf("m%d", i), float64(i%2))
+	}
+	if zs.Encoding() != ZSetEncodingListpack {
+		t.Fatalf("zset of 4 members expected listpack, got %s", zs.Encoding())
+	}
+	listpackOrder := zs.Range(0, -1)
+
+	// Updating a member does not grow the set
+	zs.Add("m1", 1)
+	if zs.Encoding() != ZSetEncodingListpack {
+		t.Fatalf("score update expected to stay listpack, got %s", zs.Encoding())
+	}
+
+	zs.Add("m5", 2)
+	if zs.Encoding() != ZSetEncodingSkiplist {
+		t.Fatalf("zset of 5 members expected skiplist, got %s", zs.Encoding())
+	}
+	got := zs.Range(0, 3)
+	for i, m := range listpackOrder {
+		if got[i] != m {
+			t.Errorf("rank %d changed in conversion: %v != %v", i, got[i], m)
+		}
+	}
+	if rank := zs.Rank("m5"); rank != 4 {
+		t.Errorf("ZRANK m5 expected 4, got %d", rank)
+	}
+
+	// The conversion is one way
+	zs.RemoveRangeByRank(0, 3)
+	if zs.Encoding() != ZSetEncodingSkiplist {
+		t.Errorf("shrunk zset expected to stay skiplist, got %s", zs.Encoding())
+	}
+}
+
+func TestListpackValueThreshold(t *testing.T) {
+	t.Cleanup(func() { SetMaxListpack(DefaultMaxListpackEntries, DefaultMaxListpackValue) })
+	SetMaxListpack(DefaultMaxListpackEntries, 8)
+
+	zs := NewZSet()
+	zs.Add("12345678", 1)
+	if zs.Encoding() != ZSetEncodingListpack {
+		t.Fatalf("member of 8 bytes expected listpack, got %s", zs.Encoding())
+	}
+	zs.Add("123456789", 2)
+	if zs.Encoding() != ZSetEncodingSkiplist {
+		t.Fatalf("member of 9 bytes expected skiplist, got %s", zs.Encoding())
+	}
+	if score, ok := zs.Score("12345678"); !ok || score != 1 {
+		t.Errorf("member lost in conversion, got %v %v", score, ok)
+	}
+}
+
+func TestListpackOperations(t *testing.T) {
+	zs := NewZSet()
+	zs.AddMultiple([]ZMember{{"c", 2}, {"a", 2}, {"b", 1}, {"d", 3}})
+	if zs.Encoding() != ZSetEncodingListpack {
+		t.Fatalf("small zset expected listpack, got %s", zs.Encoding())
+	}
+
+	want := []string{"b", "a", "c", "d"}
+	for i, m := range zs.Range(0, -1) {
+		if m.Member != want[i] {
+			t.Fatalf("ZRANGE expected %v, got %v", want, zs.Range(0, -1))
+		}
+	}
+	if rank := zs.RevRank("b"); rank != 3 {
+		t.Errorf("ZREVRANK b expected 3, got %d", rank)
+	}
+	if count := zs.Count(2, 3); count != 3 {
+		t.Errorf("ZCOUNT 2 3 expected 3, got %d", count)
+	}
+	if score, _ := zs.IncrBy("b", 5); score != 6 || zs.Rank("b") != 3 {
+		t.Errorf("ZINCRBY b 5 expected score 6 at rank 3, got %f at %d", score, zs.Rank("b"))
+	}
+	if m, ok := zs.PopMin(); !ok || m.Member != "a" {
+		t.Errorf("ZPOPMIN expected a, got %v", m)
+	}
+	if removed := zs.RemoveRangeByScore(3, 10); removed != 2 || zs.Len() != 1 {
+		t.Errorf("ZREMRANGEBYSCORE 3 10 expected 2 removed leaving 1, got %d leaving %d", removed, zs.Len())
+	}
+}
+
+func TestIncrByNaN(t *testing.T) {
+	zs := NewZSet()
+	zs.Add("a", math.Inf(1))
+
+	if _, err := zs.IncrBy("a", math.Inf(-1)); err != ErrNaN {
+		t.Errorf("ZINCRBY +inf by -inf expected ErrNaN, got %v", err)
+	}
+	if score, _ := zs.Score("a"); !math.IsInf(score, 1) {
+		t.Errorf("failed ZINCRBY expected to keep +inf, got %v", score)
+	}
+
+	// SUM of +inf and -inf is 0, while MIN and MAX pick one of them
+	other := NewZSet()
+	other.Add("a", math.Inf(-1))
+	tests := []struct {
+		aggregate string
+		want      float64
+	}{
+		{"sum", 0},
+		{"min", math.Inf(-1)},
+		{"max", math.Inf(1)},
+	}
+	for _, tt := range tests {
+		union := zs.Union([]*ZSet{other}, nil, tt.aggregate)
+		inter := zs.Intersect([]*ZSet{other}, nil, tt.aggregate)
+		if len(union) != 1 || union[0].Score != tt.want {
+			t.Errorf("union %s expected %v, got %v", tt.aggregate, tt.want, union)
+		}
+		if len(inter) != 1 || inter[0].Score != tt.want {
+			t.Errorf("intersection %s expected %v, got %v", tt.aggregate, tt.want, inter)
+		}
+	}
+}
+
+func TestSortZMembers(t *testing.T) {
+	members := randomZMembers(1000)
+	// Add ties, including ties at -inf and +inf
+	for i := 0; i < 10; i++ {
+		members = append(members,
+			ZMember{Member: fmt.Sprintf("tie%d", 9-i), Score: 5},
+			ZMember{Member: fmt.Sprintf("neg%d", 9-i), Score: math.Inf(-1)},
+			ZMember{Member: fmt.Sprintf("pos%d", 9-i), Score: math.Inf(1)})
+	}
+	sortZMembers(members)
+
+	for i := 1; i < len(members); i++ {
+		prev, cur := members[i-1], members[i]
+		if !prev.less(cur.Score, cur.Member) {
+			t.Fatalf("%v sorted before %v", prev, cur)
+		}
+	}
+}
+
+// randomZMembers returns n distinct members with scores in [0, 100) in a
+// random order
+func randomZMembers(n int) []ZMember {
+	rng := rand.New(rand.NewSource(1))
+	members := make([]ZMember, n)
+	for i, p := range rng.Perm(n) {
+		members[i] = ZMember{Member: "m" + strconv.Itoa(p), Score: float64(rng.Intn(100))}
+	}
+	return members
+}
+
+func BenchmarkSortZMembers(b *testing.B) {
+	members := randomZMembers(100000)
+	buf := make([]ZMember, len(members))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(buf, members)
+		sortZMembers(buf)
+	}
+}
+
+// BenchmarkSortZMembersInsertion measures the insertion sort sortZMembers
+// used to do, for comparison
+func BenchmarkSortZMembersInsertion(b *testing.B) {
+	members := randomZMembers(100000)
+	buf := make([]ZMember, len(members))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		copy(buf, members)
+		for j := 1; j < len(buf); j++ {
+			for k := j; k > 0 && buf[k].less(buf[k-1].Score, buf[k-1].Member); k-- {
+				buf[k], buf[k-1] = buf[k-1], buf[k]
+			}
+		}
+	}
+}
+
+func TestIncrByIf(t *testing.T) {
+	zs := NewZSet()
+	zs.Add("a", 10)
+	gt := func(oldScore, newScore float64, exists bool) bool {
+		return !exists || newScore > oldScore
+	}
+
+	if score, applied, err := zs.IncrByIf("a", -3, gt); err != nil || applied || score != 10 {
+		t.Errorf("IncrByIf -3 under GT expected 10 not applied, got %v %v %v", score, applied, err)
+	}
+	if score, applied, err := zs.IncrByIf("a", 2, gt); err != nil || !applied || score != 12 {
+		t.Errorf("IncrByIf 2 under GT expected 12 applied, got %v %v %v", score, applied, err)
+	}
+	if score, applied, err := zs.IncrByIf("b", -3, gt); err != nil || !applied || score != -3 {
+		t.Errorf("IncrByIf of a new member expected -3 applied, got %v %v %v", score, applied, err)
+	}
+	if score, _ := zs.Score("a"); score != 12 {
+		t.Errorf("a expected 12, got %v", score)
+	}
+}
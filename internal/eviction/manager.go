@@ -160,13 +160,16 @@ func (m *Manager) ProcessEvictionForDBs(dbs []DBAccessor, bytesNeeded int64) (in
 	return totalEvicted, nil
 }
 
-// SetPolicy changes the eviction policy
+// SetPolicy changes the eviction policy. A new policy is built, with an
+// empty eviction pool, only when the policy type actually changes.
 func (m *Manager) SetPolicy(policyType PolicyType) {
 	m.Lock()
 	defer m.Unlock()
 
-	m.policyType = policyType
-	m.policy = NewPolicy(policyType)
+	if policyType != m.policyType || m.policy == nil {
+		m.policyType = policyType
+		m.policy = NewPolicy(policyType)
+	}
 	m.enabled = m.maxMemory > 0 && policyType != PolicyNoEviction
 }
 
@@ -193,6 +196,24 @@ func (m *Manager) GetMaxMemory() int64 {
 	return m.maxMemory
 }
 
+// SetSamples sets the number of keys sampled per eviction
+func (m *Manager) SetSamples(samples int) {
+	m.Lock()
+	defer m.Unlock()
+
+	if samples <= 0 {
+		samples = 5 // Default samples
+	}
+	m.samples = samples
+}
+
+// GetSamples returns the number of keys sampled per eviction
+func (m *Manager) GetSamples() int {
+	m.RLock()
+	defer m.RUnlock()
+	return m.samples
+}
+
 // Enable enables or disables eviction
 func (m *Manager) Enable(enabled bool) {
 	m.Lock()
@@ -48,6 +48,23 @@ func (p PolicyType) String() string {
 	}
 }
 
+// IsLFU returns true if the policy evicts by access frequency, in which
+// case objects keep LFU data rather than an access time
+func (p PolicyType) IsLFU() bool {
+	return p == PolicyAllKeysLFU || p == PolicyVolatileLFU
+}
+
+// RanksByAccess returns true if the policy picks keys to evict by their
+// access time or frequency
+func (p PolicyType) RanksByAccess() bool {
+	switch p {
+	case PolicyAllKeysLRU, PolicyVolatileLRU, PolicyAllKeysLFU, PolicyVolatileLFU:
+		return true
+	default:
+		return false
+	}
+}
+
 // PolicyFromString parses a string to PolicyType
 func PolicyFromString(s string) (PolicyType, error) {
 	switch s {
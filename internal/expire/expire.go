@@ -161,34 +161,32 @@ func (m *Manager) Tick() []ExpireEntry {
 	return entries
 }
 
-// ActiveExpire performs active expiration scanning
-// Similar to Redis's activeExpireCycle
-func (m *Manager) ActiveExpire(databases []ActiveExpireDB) int {
-	m.Lock()
-	defer m.Unlock()
+// activeExpireSample is the number of keys with a TTL sampled per round
+// of an active expire cycle, as ACTIVE_EXPIRE_CYCLE_KEYS_PER_LOOP in Redis
+const activeExpireSample = 20
 
-	if !m.enabled {
+// ActiveExpire performs active expiration scanning
+// Similar to Redis's activeExpireCycle: each database samples keys until
+// few of them are expired or the time budget is spent
+func (m *Manager) ActiveExpire(databases []ActiveExpireDB, budget time.Duration) int {
+	if !m.Enabled() {
 		return 0
 	}
 
-	m.activeExpireRuns++
-
-	// Each run, we try to expire some keys
-	// Limit the work we do per cycle
-	effort := 20 // Default effort: check 20 keys
+	deadline := time.Now().Add(budget)
 	totalExpired := 0
-
 	for _, db := range databases {
-		if effort <= 0 {
+		totalExpired += db.ScanExpire(activeExpireSample, deadline)
+		if !time.Now().Before(deadline) {
 			break
 		}
-
-		expired := db.ScanExpire(effort)
-		totalExpired += expired
-		effort -= expired
 	}
 
+	m.Lock()
+	m.activeExpireRuns++
 	m.expiredCount += int64(totalExpired)
+	m.Unlock()
+
 	return totalExpired
 }
 
@@ -262,6 +260,8 @@ type ExpireStats struct {
 
 // ActiveExpireDB represents a database interface for active expiration
 type ActiveExpireDB interface {
-	// ScanExpire scans and expires up to N keys, returns number expired
-	ScanExpire(n int) int
+	// ScanExpire samples n keys with a TTL per round, removing expired
+	// ones, until a round finds few expired keys or deadline passes.
+	// It returns the number of keys expired.
+	ScanExpire(n int, deadline time.Time) int
 }
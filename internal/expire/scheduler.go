@@ -10,6 +10,7 @@ import (
 	"sync/atomic"
 	"time"
 
+	"github.com/zyhnesmr/godis/internal/latency"
 	"github.com/zyhnesmr/godis/pkg/log"
 )
 
@@ -25,6 +26,13 @@ type Scheduler struct {
 
 	// Configuration
 	config Config
+
+	// Databases scanned by the active expire cycle
+	databases []ActiveExpireDB
+
+	// activeExpire is checked on every cycle, so it can be toggled at
+	// runtime (DEBUG SET-ACTIVE-EXPIRE)
+	activeExpire atomic.Bool
 }
 
 // Config holds scheduler configuration
@@ -39,6 +47,13 @@ type Config struct {
 	FastCycleInterval time.Duration
 }
 
+// Time budgets of the active expire cycles, as in Redis: a fast cycle
+// runs for at most 1ms, a slow cycle for 25% of its interval
+const (
+	fastCycleBudget  = time.Millisecond
+	slowCyclePercent = 25
+)
+
 // DefaultConfig returns default scheduler configuration
 func DefaultConfig() Config {
 	return Config{
@@ -50,10 +65,12 @@ func DefaultConfig() Config {
 
 // NewScheduler creates a new expire scheduler
 func NewScheduler(mgr *Manager) *Scheduler {
-	return &Scheduler{
+	s := &Scheduler{
 		mgr:    mgr,
 		config: DefaultConfig(),
 	}
+	s.activeExpire.Store(true)
+	return s
 }
 
 // SetConfig sets the scheduler configuration
@@ -61,6 +78,23 @@ func (s *Scheduler) SetConfig(config Config) {
 	s.config = config
 }
 
+// SetDatabases sets the databases scanned by the active expire cycle.
+// It must be called before Start.
+func (s *Scheduler) SetDatabases(databases []ActiveExpireDB) {
+	s.databases = databases
+}
+
+// SetActiveExpire enables or disables active expiration. While disabled,
+// expired keys are only removed when accessed.
+func (s *Scheduler) SetActiveExpire(enabled bool) {
+	s.activeExpire.Store(enabled)
+}
+
+// ActiveExpireEnabled returns whether active expiration is enabled
+func (s *Scheduler) ActiveExpireEnabled() bool {
+	return s.activeExpire.Load()
+}
+
 // Start starts the scheduler
 func (s *Scheduler) Start() {
 	if s.running.Load() {
@@ -142,46 +176,25 @@ func (s *Scheduler) activeExpireCycle() {
 			return
 		case <-fastTicker.C:
 			if fastCycle {
-				s.runActiveExpireCycle(true)
+				fastCycle = s.runActiveExpireCycle(fastCycleBudget) > 0
 			}
 		case <-slowTicker.C:
-			s.runActiveExpireCycle(false)
+			fastCycle = s.runActiveExpireCycle(s.config.ActiveExpireInterval*slowCyclePercent/100) > 0
 		}
 	}
 }
 
-// runActiveExpireCycle runs a single active expiration cycle
-func (s *Scheduler) runActiveExpireCycle(fast bool) {
-	stats := s.mgr.Stats()
-
-	// Skip if we don't have many expired keys
-	if stats.TrackedKeys == 0 {
-		return
-	}
-
-	// Calculate effort based on expired percentage
-	expiredPercent := float64(stats.ExpiredCount) / float64(stats.TrackedKeys+1) * 100
-
-	// If expired percentage is high, do more aggressive expiration
-	effort := 20
-	if expiredPercent > 10 {
-		effort = 40
-	}
-	if expiredPercent > 25 {
-		effort = 100
-	}
-
-	// The databases to scan would be passed from the DB selector
-	// For now, we'll track this via the manager
-	_ = effort
-
-	// Toggle fast cycle
-	if fast {
-		// After a fast cycle, wait before the next one
-		if expiredPercent < 10 {
-			// If few expired keys, slow down
-		}
+// runActiveExpireCycle runs a single active expiration cycle within budget
+// and returns the number of keys expired. While keys keep expiring, the
+// fast cycle keeps running to catch up.
+func (s *Scheduler) runActiveExpireCycle(budget time.Duration) int {
+	if !s.activeExpire.Load() || len(s.databases) == 0 {
+		return 0
 	}
+	start := time.Now()
+	expired := s.mgr.ActiveExpire(s.databases, budget)
+	latency.Instance().Add(latency.EventExpireCycle, time.Since(start))
+	return expired
 }
 
 // Stats returns scheduler statistics
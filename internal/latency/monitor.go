@@ -0,0 +1,195 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package latency implements the latency monitor: commands and background
+// tasks report how long they took, and operations at or above the
+// threshold are kept as a short per-event history.
+package latency
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event names reported to the monitor
+const (
+	EventCommand     = "command"      // A slow command
+	EventFastCommand = "fast-command" // A slow O(1) or O(log N) command
+	EventExpireCycle = "expire-cycle" // An active expire cycle
+	EventRDBSave     = "rdb-save"     // Writing the RDB file
+	EventAOFRewrite  = "aof-rewrite"  // Rewriting the AOF file
+)
+
+// historyLen is the number of samples kept per event
+const historyLen = 160
+
+// Sample is the worst latency of an event within one second
+type Sample struct {
+	Time    int64 // Unix time in seconds
+	Latency int64 // Milliseconds
+}
+
+// Summary describes the latest and worst latency of an event
+type Summary struct {
+	Event   string
+	Time    int64 // Unix time in seconds of the latest sample
+	Latest  int64 // Milliseconds
+	Maximum int64 // All time maximum in milliseconds
+}
+
+// eventHistory is the ring buffer of samples of an event
+type eventHistory struct {
+	samples [historyLen]Sample
+	next    int // Index of the next sample to write
+	count   int
+	max     int64
+}
+
+// latest returns the most recent sample
+func (h *eventHistory) latest() Sample {
+	return h.samples[(h.next+historyLen-1)%historyLen]
+}
+
+// Monitor records latency samples by event name
+type Monitor struct {
+	mu        sync.RWMutex
+	threshold int64 // Milliseconds; 0 disables the monitor
+	events    map[string]*eventHistory
+}
+
+// NewMonitor creates a disabled latency monitor
+func NewMonitor() *Monitor {
+	return &Monitor{events: make(map[string]*eventHistory)}
+}
+
+var (
+	instance     *Monitor
+	instanceOnce sync.Once
+)
+
+// Instance returns the monitor of the server
+func Instance() *Monitor {
+	instanceOnce.Do(func() {
+		instance = NewMonitor()
+	})
+	return instance
+}
+
+// SetThreshold sets the latency in milliseconds from which operations are
+// recorded; 0 disables the monitor
+func (m *Monitor) SetThreshold(ms int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.threshold = ms
+}
+
+// Threshold returns the latency in milliseconds from which operations are
+// recorded
+func (m *Monitor) Threshold() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.threshold
+}
+
+// Add reports that an operation of event took d. It is recorded only if
+// the monitor is enabled and d reaches the threshold.
+func (m *Monitor) Add(event string, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	ms := d.Milliseconds()
+	if m.threshold <= 0 || ms < m.threshold {
+		return
+	}
+	m.addLocked(event, time.Now().Unix(), ms)
+}
+
+// addLocked records a sample, merging it with one from the same second
+// (with m.mu held)
+func (m *Monitor) addLocked(event string, now, ms int64) {
+	h, ok := m.events[event]
+	if !ok {
+		h = &eventHistory{}
+		m.events[event] = h
+	}
+	if ms > h.max {
+		h.max = ms
+	}
+
+	if h.count > 0 {
+		if last := &h.samples[(h.next+historyLen-1)%historyLen]; last.Time == now {
+			if ms > last.Latency {
+				last.Latency = ms
+			}
+			return
+		}
+	}
+
+	h.samples[h.next] = Sample{Time: now, Latency: ms}
+	h.next = (h.next + 1) % historyLen
+	if h.count < historyLen {
+		h.count++
+	}
+}
+
+// Latest returns the summary of every event, sorted by name
+func (m *Monitor) Latest() []Summary {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make([]Summary, 0, len(m.events))
+	for event, h := range m.events {
+		last := h.latest()
+		result = append(result, Summary{
+			Event:   event,
+			Time:    last.Time,
+			Latest:  last.Latency,
+			Maximum: h.max,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Event < result[j].Event
+	})
+	return result
+}
+
+// History returns the samples of event, oldest first
+func (m *Monitor) History(event string) []Sample {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	h, ok := m.events[event]
+	if !ok {
+		return nil
+	}
+	result := make([]Sample, h.count)
+	start := (h.next + historyLen - h.count) % historyLen
+	for i := range result {
+		result[i] = h.samples[(start+i)%historyLen]
+	}
+	return result
+}
+
+// Reset removes the history of the given events, or of every event if none
+// is given. It returns the number of events removed.
+func (m *Monitor) Reset(events ...string) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(events) == 0 {
+		n := len(m.events)
+		m.events = make(map[string]*eventHistory)
+		return n
+	}
+
+	n := 0
+	for _, event := range events {
+		if _, ok := m.events[event]; ok {
+			delete(m.events, event)
+			n++
+		}
+	}
+	return n
+}
@@ -29,21 +29,31 @@ type Conn struct {
 	mu     sync.Mutex
 	closed bool
 
+	// wmu serializes writes, so that a reply and a message published to
+	// the connection by another client never interleave. It is separate
+	// from mu so that a client slow to read only blocks its own writers.
+	wmu sync.Mutex
+
 	// Client info
 	name  string
 	flags uint32
+	user  string // ACL user, empty until the connection authenticates
 
 	// Database selection
 	db int
 
+	// RESP protocol version negotiated with HELLO
+	protocol int
+
 	// Transaction state
 	inMulti     bool
 	inWatch     bool
 	watchedKeys map[string]struct{}
 
 	// Subscription state
-	subscriptions map[string]struct{}
-	patterns      map[string]struct{}
+	subscriptions      map[string]struct{}
+	patterns           map[string]struct{}
+	shardSubscriptions map[string]struct{}
 
 	// Query buffer
 	queryBuffer []byte
@@ -71,6 +81,22 @@ const (
 	// FlagDirty is set when EXEC should fail due to watched keys
 	FlagDirty
 
+	// FlagDirtyExec is set when EXEC should fail due to a command rejected
+	// while queuing
+	FlagDirtyExec
+
+	// FlagCloseAfterReply is set when the connection should be closed once
+	// the reply to the current command has been written
+	FlagCloseAfterReply
+
+	// FlagNoEvict is set by CLIENT NO-EVICT to exempt the connection from
+	// client eviction
+	FlagNoEvict
+
+	// FlagNoTouch is set by CLIENT NO-TOUCH so the connection's commands
+	// do not update the access time of the keys they read
+	FlagNoTouch
+
 	// Default buffer sizes
 	defaultReadBufferSize  = 16 * 1024   // 16KB
 	defaultWriteBufferSize = 16 * 1024   // 16KB
@@ -80,17 +106,19 @@ const (
 // NewConn creates a new connection wrapper
 func NewConn(rawConn net.Conn) *Conn {
 	return &Conn{
-		rawConn:       rawConn,
-		reader:        bufio.NewReaderSize(rawConn, defaultReadBufferSize),
-		writer:        bufio.NewWriterSize(rawConn, defaultWriteBufferSize),
-		createdAt:     time.Now(),
-		lastActive:    time.Now(),
-		db:            0,
-		watchedKeys:   make(map[string]struct{}),
-		subscriptions: make(map[string]struct{}),
-		patterns:      make(map[string]struct{}),
-		queryBuffer:   make([]byte, 0, 512),
-		flags:         FlagClient,
+		rawConn:            rawConn,
+		reader:             bufio.NewReaderSize(rawConn, defaultReadBufferSize),
+		writer:             bufio.NewWriterSize(rawConn, defaultWriteBufferSize),
+		createdAt:          time.Now(),
+		lastActive:         time.Now(),
+		db:                 0,
+		protocol:           2,
+		watchedKeys:        make(map[string]struct{}),
+		subscriptions:      make(map[string]struct{}),
+		patterns:           make(map[string]struct{}),
+		shardSubscriptions: make(map[string]struct{}),
+		queryBuffer:        make([]byte, 0, 512),
+		flags:              FlagClient,
 	}
 }
 
@@ -117,13 +145,12 @@ func (c *Conn) Read(b []byte) (int, error) {
 
 // Write writes data to the connection
 func (c *Conn) Write(b []byte) (int, error) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.closed {
+	if c.IsClosed() {
 		return 0, io.ErrClosedPipe
 	}
 
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 	return c.writer.Write(b)
 }
 
@@ -183,13 +210,12 @@ func (c *Conn) ReadBytes(n int) ([]byte, error) {
 
 // Flush flushes the write buffer
 func (c *Conn) Flush() error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
-
-	if c.closed {
+	if c.IsClosed() {
 		return io.ErrClosedPipe
 	}
 
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 	return c.writer.Flush()
 }
 
@@ -204,8 +230,12 @@ func (c *Conn) Close() error {
 
 	c.closed = true
 
-	// Flush any pending data
-	_ = c.writer.Flush()
+	// Flush any pending data, unless a write is in progress: it may be
+	// blocked on a client that stopped reading, which closing unblocks
+	if c.wmu.TryLock() {
+		_ = c.writer.Flush()
+		c.wmu.Unlock()
+	}
 
 	return c.rawConn.Close()
 }
@@ -270,6 +300,13 @@ func (c *Conn) GetLastActive() time.Time {
 	return c.lastActive
 }
 
+// Touch records activity on the connection, which resets its idle time
+func (c *Conn) Touch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.lastActive = time.Now()
+}
+
 // GetName returns the client name
 func (c *Conn) GetName() string {
 	c.mu.Lock()
@@ -284,6 +321,21 @@ func (c *Conn) SetName(name string) {
 	c.name = name
 }
 
+// GetUser returns the ACL user the connection authenticated as, or ""
+// if it did not authenticate
+func (c *Conn) GetUser() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.user
+}
+
+// SetUser records the ACL user the connection authenticated as
+func (c *Conn) SetUser(user string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.user = user
+}
+
 // GetDB returns the selected database
 func (c *Conn) GetDB() int {
 	c.mu.Lock()
@@ -298,6 +350,20 @@ func (c *Conn) SetDB(db int) {
 	c.db = db
 }
 
+// GetProtocol returns the RESP protocol version used by the connection
+func (c *Conn) GetProtocol() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.protocol
+}
+
+// SetProtocol sets the RESP protocol version used by the connection
+func (c *Conn) SetProtocol(protocol int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.protocol = protocol
+}
+
 // GetFlags returns the connection flags
 func (c *Conn) GetFlags() uint32 {
 	c.mu.Lock()
@@ -354,7 +420,7 @@ func (c *Conn) SetInMulti(inMulti bool) {
 func (c *Conn) IsInPubSub() bool {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return len(c.subscriptions) > 0 || len(c.patterns) > 0
+	return len(c.subscriptions) > 0 || len(c.patterns) > 0 || len(c.shardSubscriptions) > 0
 }
 
 // GetSubscriptions returns the subscriptions map
@@ -399,11 +465,37 @@ func (c *Conn) PUnsubscribe(pattern string) {
 	delete(c.patterns, pattern)
 }
 
-// GetWatchedKeys returns the watched keys
+// GetShardSubscriptions returns the shard channel subscriptions map
+func (c *Conn) GetShardSubscriptions() map[string]struct{} {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.shardSubscriptions
+}
+
+// SSubscribe subscribes to a shard channel
+func (c *Conn) SSubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.shardSubscriptions[channel] = struct{}{}
+}
+
+// SUnsubscribe unsubscribes from a shard channel
+func (c *Conn) SUnsubscribe(channel string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.shardSubscriptions, channel)
+}
+
+// GetWatchedKeys returns a copy of the watched keys
 func (c *Conn) GetWatchedKeys() map[string]struct{} {
 	c.mu.Lock()
 	defer c.mu.Unlock()
-	return c.watchedKeys
+
+	result := make(map[string]struct{}, len(c.watchedKeys))
+	for k := range c.watchedKeys {
+		result[k] = struct{}{}
+	}
+	return result
 }
 
 // WatchKey adds a key to the watch list
@@ -421,7 +513,7 @@ func (c *Conn) UnwatchAll() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	c.watchedKeys = make(map[string]struct{})
-	c.RemoveFlag(FlagDirty)
+	c.flags &= ^FlagDirty
 }
 
 // MarkDirty marks the transaction as dirty (watched key was modified)
@@ -441,15 +533,23 @@ func (c *Conn) NewRESPParser() *resp.Parser {
 
 // WriteRESP writes a RESP message to the connection
 func (c *Conn) WriteRESP(data []byte) error {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	_, err := c.Write(data)
+	return err
+}
 
-	if c.closed {
+// WriteAndFlush writes a RESP message and sends it to the client at once,
+// with no other write in between
+func (c *Conn) WriteAndFlush(data []byte) error {
+	if c.IsClosed() {
 		return io.ErrClosedPipe
 	}
 
-	_, err := c.writer.Write(data)
-	return err
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	if _, err := c.writer.Write(data); err != nil {
+		return err
+	}
+	return c.writer.Flush()
 }
 
 // WriteRESPMessage writes a RESP message
@@ -475,8 +575,8 @@ func (c *Conn) SetReadBufferSize(size int) {
 
 // SetWriteBufferSize sets the write buffer size
 func (c *Conn) SetWriteBufferSize(size int) {
-	c.mu.Lock()
-	defer c.mu.Unlock()
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
 
 	if size > 0 {
 		_ = c.writer.Flush()
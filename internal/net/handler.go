@@ -8,9 +8,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net"
 	"time"
 
+	"github.com/zyhnesmr/godis/internal/config"
 	"github.com/zyhnesmr/godis/internal/protocol/resp"
+	"github.com/zyhnesmr/godis/pkg/log"
 )
 
 // DefaultHandler is the default connection handler
@@ -42,24 +45,30 @@ func (h *DefaultHandler) Handle(ctx context.Context, conn *Conn) {
 		default:
 		}
 
-		// Set read deadline for blocking read
-		_ = conn.SetReadDeadline(time.Now().Add(300 * time.Second))
+		// Close the connection once it has been idle for timeout seconds
+		_ = conn.SetReadDeadline(idleDeadline(conn))
 
 		// Parse command
-		msg, err := parser.Parse()
+		msg, err := parser.ReadCommand()
 		if err != nil {
 			if errors.Is(err, context.Canceled) {
 				return
 			}
+			if netErr := net.Error(nil); errors.As(err, &netErr) && netErr.Timeout() {
+				log.Debug("Closing idle connection from %s", conn.RemoteAddr())
+				return
+			}
 			if IsConnectionClosed(err) {
 				return
 			}
 			// Send error response
-			_ = conn.WriteRESP(resp.BuildErrorString(fmt.Sprintf("ERR protocol error: %s", err.Error())))
+			_ = conn.WriteRESP(resp.BuildErrorString(fmt.Sprintf("ERR Protocol error: %s", err.Error())))
 			_ = conn.Flush()
 			return
 		}
 
+		conn.Touch()
+
 		// Parse command name and arguments
 		cmdName, args, err := msg.ParseCommand()
 		if err != nil {
@@ -88,7 +97,23 @@ func (h *DefaultHandler) Handle(ctx context.Context, conn *Conn) {
 		if err := conn.Flush(); err != nil {
 			return
 		}
+
+		// CLIENT KILL of the connection itself closes it after the reply
+		if conn.HasFlag(FlagCloseAfterReply) {
+			return
+		}
+	}
+}
+
+// idleDeadline returns the read deadline of the next command: timeout
+// seconds from now, or none if timeout is 0. As in Redis, subscribers and
+// replicas are never timed out, since they only wait for data.
+func idleDeadline(conn *Conn) time.Time {
+	timeout := config.Instance().Timeout
+	if timeout <= 0 || conn.IsInPubSub() || conn.HasFlag(FlagSlave) {
+		return time.Time{}
 	}
+	return time.Now().Add(time.Duration(timeout) * time.Second)
 }
 
 // IsConnectionClosed checks if an error indicates connection is closed
@@ -0,0 +1,74 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/config"
+)
+
+// pongProcessor replies +PONG to every command
+type pongProcessor struct{}
+
+func (pongProcessor) ProcessCommand(ctx context.Context, conn *Conn, cmd string, args []string) ([]byte, error) {
+	return []byte("+PONG\r\n"), nil
+}
+
+// serve runs the default handler on one end of a pipe and returns the
+// other end, and a channel closed when the handler returns
+func serve(t *testing.T) (net.Conn, <-chan struct{}) {
+	t.Helper()
+
+	client, server := net.Pipe()
+	t.Cleanup(func() { client.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		NewDefaultHandler(pongProcessor{}).Handle(context.Background(), NewConn(server))
+		close(done)
+	}()
+	return client, done
+}
+
+func TestIdleConnectionTimeout(t *testing.T) {
+	cfg := config.Instance()
+	saved := cfg.Timeout
+	t.Cleanup(func() { cfg.Timeout = saved })
+	cfg.Timeout = 1
+
+	client, done := serve(t)
+
+	// A command resets the idle time
+	time.Sleep(600 * time.Millisecond)
+	if _, err := client.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		t.Fatalf("writing PING failed: %v", err)
+	}
+	if line, err := bufio.NewReader(client).ReadString('\n'); err != nil || line != "+PONG\r\n" {
+		t.Fatalf("PING = %q, %v", line, err)
+	}
+	select {
+	case <-done:
+		t.Fatal("connection closed less than timeout seconds after a command")
+	case <-time.After(600 * time.Millisecond):
+	}
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle connection was not closed")
+	}
+
+	// A timeout of 0 never closes idle connections
+	cfg.Timeout = 0
+	client, done = serve(t)
+	select {
+	case <-done:
+		t.Fatal("idle connection closed with timeout 0")
+	case <-time.After(1200 * time.Millisecond):
+	}
+	client.Close()
+	<-done
+}
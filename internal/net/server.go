@@ -6,9 +6,12 @@ package net
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/config"
 	"github.com/zyhnesmr/godis/pkg/log"
@@ -26,8 +29,8 @@ type Server struct {
 	wg       sync.WaitGroup
 
 	// Connection management
-	maxClients int
 	activeConn int
+	nextID     atomic.Uint64
 
 	// Event hooks
 	onConnAccept func(*Conn)
@@ -65,12 +68,11 @@ func NewServer(bind string, port int, handler CommandHandler) *Server {
 	handlerAdapter := &handlerAdapter{processor: procAdapter}
 
 	return &Server{
-		config:     cfg,
-		conns:      make(map[net.Conn]*Conn),
-		handler:    handlerAdapter,
-		ctx:        ctx,
-		cancel:     cancel,
-		maxClients: int(cfg.MaxClients),
+		config:  cfg,
+		conns:   make(map[net.Conn]*Conn),
+		handler: handlerAdapter,
+		ctx:     ctx,
+		cancel:  cancel,
 	}
 }
 
@@ -149,53 +151,65 @@ func (s *Server) acceptLoop() {
 
 		rawConn, err := s.listener.Accept()
 		if err != nil {
-			// Check if we're shutting down
+			// Check if we're shutting down. Stop closes the listener, which
+			// may happen before the context passed to Start is canceled.
 			select {
 			case <-s.ctx.Done():
 				return
 			default:
 			}
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
 
 			// Check for temporary errors
 			if netErr, ok := err.(net.Error); ok && netErr.Temporary() {
 				continue
-				continue
 			}
 
 			log.Error("Accept error: %v", err)
 			continue
 		}
 
-		// Check connection limit
-		s.connsMu.Lock()
-		if s.maxClients > 0 && len(s.conns) >= s.maxClients {
-			s.connsMu.Unlock()
-			log.Warn("Max clients reached (%d), rejecting connection from %s", s.maxClients, rawConn.RemoteAddr())
+		// Create connection wrapper, refusing it past maxclients
+		conn := NewConn(rawConn)
+		if !s.addConn(conn) {
+			log.Warn("Max clients reached (%d), rejecting connection from %s", s.config.MaxClients, rawConn.RemoteAddr())
+			_ = rawConn.SetWriteDeadline(time.Now().Add(time.Second))
+			_, _ = rawConn.Write([]byte("-ERR max number of clients reached\r\n"))
 			rawConn.Close()
 			continue
 		}
-		s.connsMu.Unlock()
 
 		// Set TCP keepalive
 		if tcpConn, ok := rawConn.(*net.TCPConn); ok {
 			if s.config.TCPKeepalive > 0 {
 				tcpConn.SetKeepAlive(true)
+				tcpConn.SetKeepAlivePeriod(time.Duration(s.config.TCPKeepalive) * time.Second)
 			}
 		}
 
-		// Create connection wrapper
-		conn := NewConn(rawConn)
-
-		s.connsMu.Lock()
-		s.conns[rawConn] = conn
-		s.activeConn = len(s.conns)
-		s.connsMu.Unlock()
-
 		s.wg.Add(1)
 		go s.handleConnection(conn)
 	}
 }
 
+// addConn registers a new connection unless the server already has
+// maxclients connections. The check and the registration happen under one
+// lock, so that connections accepted together cannot exceed the limit.
+func (s *Server) addConn(conn *Conn) bool {
+	s.connsMu.Lock()
+	defer s.connsMu.Unlock()
+
+	if maxClients := int(s.config.MaxClients); maxClients > 0 && len(s.conns) >= maxClients {
+		return false
+	}
+	conn.SetID(s.nextID.Add(1))
+	s.conns[conn.rawConn] = conn
+	s.activeConn = len(s.conns)
+	return true
+}
+
 // handleConnection handles a single connection
 func (s *Server) handleConnection(conn *Conn) {
 	defer func() {
@@ -234,6 +248,19 @@ func (s *Server) GetConnectionCount() int {
 	return len(s.conns)
 }
 
+// Addr returns the address the server listens on, or nil before Start
+func (s *Server) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// TotalConnections returns the number of connections accepted since start
+func (s *Server) TotalConnections() uint64 {
+	return s.nextID.Load()
+}
+
 // GetConnections returns a copy of active connections
 func (s *Server) GetConnections() []*Conn {
 	s.connsMu.RLock()
@@ -0,0 +1,104 @@
+package net
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/config"
+)
+
+// pongDispatcher replies +PONG to every command
+type pongDispatcher struct{}
+
+func (pongDispatcher) Dispatch(ctx context.Context, conn *Conn, cmdName string, args []string) ([]byte, error) {
+	return []byte("+PONG\r\n"), nil
+}
+
+// startServer starts a server on a free local port
+func startServer(t *testing.T) *Server {
+	t.Helper()
+
+	cfg := config.Instance()
+	bind, port := cfg.Bind, cfg.Port
+	t.Cleanup(func() { cfg.Bind, cfg.Port = bind, port })
+	cfg.Bind, cfg.Port = "127.0.0.1", 0
+
+	s := NewServer(cfg.Bind, int(cfg.Port), pongDispatcher{})
+	if err := s.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(s.Stop)
+	return s
+}
+
+// ping sends PING on conn and returns the reply line
+func ping(t *testing.T, conn net.Conn, reader *bufio.Reader) string {
+	t.Helper()
+	_ = conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte("*1\r\n$4\r\nPING\r\n")); err != nil {
+		return err.Error()
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return err.Error()
+	}
+	return line
+}
+
+func TestMaxClients(t *testing.T) {
+	cfg := config.Instance()
+	saved := cfg.MaxClients
+	t.Cleanup(func() { cfg.MaxClients = saved })
+	cfg.MaxClients = 1
+
+	s := startServer(t)
+
+	first, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	firstReader := bufio.NewReader(first)
+	if line := ping(t, first, firstReader); line != "+PONG\r\n" {
+		t.Fatalf("first client PING = %q", line)
+	}
+
+	// The second client is told why and disconnected
+	second, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+	_ = second.SetDeadline(time.Now().Add(2 * time.Second))
+	reader := bufio.NewReader(second)
+	if line, err := reader.ReadString('\n'); err != nil || line != "-ERR max number of clients reached\r\n" {
+		t.Fatalf("second client got %q, %v", line, err)
+	}
+	if _, err := reader.ReadByte(); err == nil {
+		t.Fatal("rejected connection left open")
+	}
+	if n := s.GetConnectionCount(); n != 1 {
+		t.Fatalf("connection count = %d, want 1", n)
+	}
+
+	// Once the first client leaves, its slot is free again
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.GetConnectionCount() != 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("disconnect did not decrement the connection count")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	third, err := net.Dial("tcp", s.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if line := ping(t, third, bufio.NewReader(third)); line != "+PONG\r\n" {
+		t.Fatalf("third client PING = %q", line)
+	}
+}
@@ -32,14 +32,21 @@ const (
 
 // AOF manages AOF persistence
 type AOF struct {
-	dirname  string
-	dbname   string
-	cfg      *config.Config
-	file     *os.File
-	writer   *bufio.Writer
-	mu       sync.RWMutex
-	enabled  atomic.Bool
-	fsyncStr FsyncStrategy
+	dirname string
+	dbname  string
+	cfg     *config.Config
+	file    *os.File
+	writer  *bufio.Writer
+	mu      sync.RWMutex
+	enabled atomic.Bool
+
+	// dirty is set when the buffer holds commands not yet handed to the
+	// fsync loop
+	dirty bool
+
+	// Database the last appended command ran against, -1 if unknown. A
+	// SELECT is written before any command for another database.
+	selectedDB int
 
 	// Statistics
 	lastRewriteTime    time.Time
@@ -48,21 +55,26 @@ type AOF struct {
 
 	// Rewrite state
 	rewriteInProgress atomic.Bool
+	lastRewriteFailed atomic.Bool
+	rewriteBuf        []byte // commands logged since the rewrite's snapshot
+	rewriteBufDB      int    // database selected at the end of rewriteBuf
 
-	// Fsync channel
-	fsyncChan chan struct{}
+	// writeBarrier runs the function taking a rewrite's snapshot while no
+	// write command runs, nil to take it right away
+	writeBarrier func(func())
+
+	// Closed to stop the fsync loop
 	closeChan chan struct{}
 }
 
 // NewAOF creates a new AOF manager
 func NewAOF(dirname, dbname string, cfg *config.Config) *AOF {
 	a := &AOF{
-		dirname:   dirname,
-		dbname:    dbname,
-		cfg:       cfg,
-		fsyncStr:  parseFsyncStrategy(cfg.AppendFsync),
-		fsyncChan: make(chan struct{}, 1),
-		closeChan: make(chan struct{}),
+		dirname:    dirname,
+		dbname:     dbname,
+		cfg:        cfg,
+		selectedDB: -1,
+		closeChan:  make(chan struct{}),
 	}
 
 	// Check if AOF is enabled
@@ -85,6 +97,29 @@ func parseFsyncStrategy(s string) FsyncStrategy {
 	}
 }
 
+// fsyncStrategy returns the fsync strategy currently configured, so
+// CONFIG SET appendfsync takes effect without reopening the file
+func (a *AOF) fsyncStrategy() FsyncStrategy {
+	value, _ := a.cfg.Get("appendfsync")
+	return parseFsyncStrategy(value)
+}
+
+// fsyncSuppressed returns true while no-appendfsync-on-rewrite holds off
+// fsync for a running rewrite. Commands are still written to the OS.
+func (a *AOF) fsyncSuppressed() bool {
+	return a.cfg.NoAppendfsyncOnRewrite && a.rewriteInProgress.Load()
+}
+
+// SetWriteBarrier sets the function a rewrite takes its snapshot through.
+// It must run its argument while no write command runs, so that each write
+// is either in the snapshot or logged after it. Without one, writes must
+// not run during a rewrite's snapshot.
+func (a *AOF) SetWriteBarrier(barrier func(func())) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.writeBarrier = barrier
+}
+
 // IsEnabled returns true if AOF is enabled
 func (a *AOF) IsEnabled() bool {
 	return a.enabled.Load()
@@ -115,8 +150,17 @@ func (a *AOF) Enable() error {
 	a.writer = bufio.NewWriterSize(file, 32*1024) // 32KB buffer
 	a.enabled.Store(true)
 
+	// The database selected at the end of an existing file is unknown
+	a.selectedDB = -1
+
+	// The file as it is now is the base for auto rewrite growth
+	if info, err := file.Stat(); err == nil {
+		a.baseSize = info.Size()
+	}
+
 	// Start fsync goroutine
-	go a.fsyncLoop()
+	a.closeChan = make(chan struct{})
+	go a.fsyncLoop(a.closeChan)
 
 	return nil
 }
@@ -152,6 +196,7 @@ func (a *AOF) Disable() error {
 
 	a.file = nil
 	a.writer = nil
+	a.dirty = false
 
 	return nil
 }
@@ -177,11 +222,6 @@ func (a *AOF) LogCommand(db int, cmdName string, args []string) error {
 	// Build the command as RESP array
 	// Format: *<count>\r\n$<len>\r\n<cmd>\r\n$<len>\r\n<arg>\r\n...
 	builder := resp.NewResponseBuilder()
-
-	// Switch DB command if needed
-	// We track the current DB and emit SELECT commands when it changes
-
-	// Write the command array
 	totalArgs := 1 + len(args)
 	builder.WriteArray(totalArgs)
 	builder.WriteBulkStringFromString(cmdName)
@@ -189,38 +229,53 @@ func (a *AOF) LogCommand(db int, cmdName string, args []string) error {
 		builder.WriteBulkStringFromString(arg)
 	}
 
-	// Write to buffer
+	// Write to buffer, switching database first if needed
+	if db != a.selectedDB {
+		if _, err := a.writer.Write(selectCommand(db)); err != nil {
+			return fmt.Errorf("failed to write to AOF: %w", err)
+		}
+		a.selectedDB = db
+	}
 	if _, err := a.writer.Write(builder.Bytes()); err != nil {
 		return fmt.Errorf("failed to write to AOF: %w", err)
 	}
 
-	// Flush after each command for now (can be optimized later)
-	if err := a.writer.Flush(); err != nil {
-		return fmt.Errorf("failed to flush AOF: %w", err)
+	// Keep a copy for the rewritten file so it doesn't lose this command.
+	// The copy tracks its own selected database, as it follows the
+	// snapshot rather than the current file.
+	if a.rewriteInProgress.Load() {
+		if db != a.rewriteBufDB {
+			a.rewriteBuf = append(a.rewriteBuf, selectCommand(db)...)
+			a.rewriteBufDB = db
+		}
+		a.rewriteBuf = append(a.rewriteBuf, builder.Bytes()...)
 	}
 
-	// Fsync based on strategy
-	switch a.fsyncStr {
-	case FsyncAlways:
-		if err := a.fsync(); err != nil {
-			return err
-		}
-	case FsyncEverySec:
-		// Signal fsync goroutine
-		select {
-		case a.fsyncChan <- struct{}{}:
-		default:
+	// Under always the command is on disk before we return; otherwise it
+	// stays buffered until the fsync loop's next tick
+	if a.fsyncStrategy() == FsyncAlways {
+		if a.fsyncSuppressed() {
+			if err := a.writer.Flush(); err != nil {
+				return fmt.Errorf("failed to flush AOF: %w", err)
+			}
+		} else if err := a.fsync(); err != nil {
+			return fmt.Errorf("failed to fsync AOF: %w", err)
 		}
-	case FsyncNo:
-		// Let OS handle it
+		a.dirty = false
+		return nil
 	}
 
+	a.dirty = true
 	return nil
 }
 
-// LogSelectDB logs a SELECT command to switch database
-func (a *AOF) LogSelectDB(db int) error {
-	return a.LogCommand(0, "SELECT", []string{strconv.Itoa(db)})
+// selectCommand returns the RESP encoding of SELECT db
+func selectCommand(db int) []byte {
+	builder := resp.NewResponseBuilder()
+	builder.WriteArray(2)
+	builder.WriteBulkStringFromString("SELECT")
+	builder.WriteBulkStringFromString(strconv.Itoa(db))
+	return builder.Bytes()
 }
 
 // fsync performs an fsync on the file
@@ -238,35 +293,50 @@ func (a *AOF) fsync() error {
 	return a.file.Sync()
 }
 
-// fsyncLoop runs fsync every second if needed
-func (a *AOF) fsyncLoop() {
+// Fsync writes out the commands logged so far and fsyncs them, whatever
+// the fsync policy, for WAITAOF
+func (a *AOF) Fsync() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	if a.writer == nil {
+		return nil
+	}
+	if err := a.fsync(); err != nil {
+		return fmt.Errorf("failed to fsync AOF: %w", err)
+	}
+	a.dirty = false
+	return nil
+}
+
+// fsyncLoop writes out buffered commands once per second. Under everysec
+// it also fsyncs them; under no, or while a rewrite suppresses fsync, it
+// leaves flushing to disk to the OS.
+func (a *AOF) fsyncLoop(closeChan chan struct{}) {
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
 	for {
 		select {
-		case <-a.closeChan:
+		case <-closeChan:
 			return
 		case <-ticker.C:
 			a.mu.Lock()
-			if a.enabled.Load() && a.writer != nil {
-				_ = a.writer.Flush()
-				_ = a.file.Sync()
-			}
-			a.mu.Unlock()
-		case <-a.fsyncChan:
-			// Triggered fsync
-			a.mu.Lock()
-			if a.enabled.Load() && a.writer != nil {
-				_ = a.writer.Flush()
-				_ = a.file.Sync()
+			if a.dirty && a.writer != nil {
+				if a.fsyncStrategy() == FsyncNo || a.fsyncSuppressed() {
+					_ = a.writer.Flush()
+				} else {
+					_ = a.fsync()
+				}
+				a.dirty = false
 			}
 			a.mu.Unlock()
 		}
 	}
 }
 
-// Load loads the AOF file and replays commands
+// Load loads the AOF file and replays commands. Every command read is
+// passed to handler, which skips those that don't write.
 func (a *AOF) Load(dbs []*database.DB, handler CommandHandler) error {
 	filename := a.GetFilename()
 	file, err := os.Open(filename)
@@ -283,6 +353,11 @@ func (a *AOF) Load(dbs []*database.DB, handler CommandHandler) error {
 	// Current database
 	currentDB := 0
 
+	// Commands of a transaction, replayed once its EXEC is read. A
+	// transaction cut short by a crash is dropped.
+	var multi []queuedCommand
+	inMulti := false
+
 	// Parse and replay commands
 	for {
 		msg, err := parser.Parse()
@@ -336,42 +411,44 @@ func (a *AOF) Load(dbs []*database.DB, handler CommandHandler) error {
 			continue
 		}
 
-		// Skip non-write commands during replay
-		if !isWriteCommand(cmdName) {
+		switch cmdName {
+		case "MULTI":
+			multi, inMulti = multi[:0], true
+			continue
+		case "EXEC":
+			for _, c := range multi {
+				replayCommand(handler, c.db, c.name, c.args)
+			}
+			multi, inMulti = multi[:0], false
 			continue
 		}
 
-		// Execute command
-		if err := handler(currentDB, cmdName, args); err != nil {
-			// Log error but continue
-			fmt.Fprintf(os.Stderr, "Failed to execute AOF command: %s %v - %v\n", cmdName, args, err)
+		if inMulti {
+			multi = append(multi, queuedCommand{db: currentDB, name: cmdName, args: args})
+			continue
 		}
+		replayCommand(handler, currentDB, cmdName, args)
+	}
+	if inMulti {
+		fmt.Fprintf(os.Stderr, "AOF ends inside a transaction, dropped its %d commands\n", len(multi))
 	}
 
 	return nil
 }
 
-// isWriteCommand returns true if the command modifies data
-func isWriteCommand(cmdName string) bool {
-	writeCommands := []string{
-		"SET", "SETNX", "SETEX", "PSETEX", "MSET", "MSETNX", "GETSET", "APPEND", "SETRANGE",
-		"INCR", "INCRBY", "INCRBYFLOAT", "DECR", "DECRBY",
-		"DEL", "UNLINK", "EXPIRE", "EXPIREAT", "PERSIST",
-		"RPUSH", "LPUSH", "RPUSHX", "LPUSHX", "LINSERT", "LSET", "LTRIM", "RPOP", "LPOP",
-		"SADD", "SREM", "SPOP", "SMOVE", "SINTERSTORE", "SUNIONSTORE", "SDIFFSTORE",
-		"ZADD", "ZINCRBY", "ZREM", "ZREMRANGEBYRANK", "ZREMRANGEBYSCORE", "ZUNIONSTORE", "ZINTERSTORE", "ZDIFFSTORE",
-		"HSET", "HSETNX", "HMSET", "HINCRBY", "HINCRBYFLOAT", "HDEL",
-		"RENAME", "RENAMENX",
-		"FLUSHDB", "FLUSHALL",
-		"PUBLISH",
-	}
-
-	for _, wc := range writeCommands {
-		if cmdName == wc {
-			return true
-		}
+// queuedCommand is a command of a transaction read from the AOF
+type queuedCommand struct {
+	db   int
+	name string
+	args []string
+}
+
+// replayCommand executes a command read from the AOF, logging a failure
+func replayCommand(handler CommandHandler, db int, cmdName string, args []string) {
+	if err := handler(db, cmdName, args); err != nil {
+		// Log error but continue
+		fmt.Fprintf(os.Stderr, "Failed to execute AOF command: %s %v - %v\n", cmdName, args, err)
 	}
-	return false
 }
 
 // FileSize returns the size of the AOF file in bytes
@@ -405,7 +482,13 @@ func (a *AOF) Delete() error {
 
 // ShouldRewrite returns true if AOF rewrite should be triggered
 func (a *AOF) ShouldRewrite() bool {
-	if a.rewriteInProgress.Load() {
+	if !a.enabled.Load() || a.rewriteInProgress.Load() {
+		return false
+	}
+
+	// Check growth percentage (0 disables auto rewrite)
+	percentage := a.cfg.AutoAofRewritePercentage
+	if percentage <= 0 {
 		return false
 	}
 
@@ -415,27 +498,33 @@ func (a *AOF) ShouldRewrite() bool {
 	}
 
 	// Check if file meets minimum size
-	minSize := a.cfg.AutoAofRewriteMinSize
-	if minSize == 0 {
-		minSize = 64 << 20 // Default 64MB
-	}
-	if size < minSize {
+	if size < a.cfg.AutoAofRewriteMinSize {
 		return false
 	}
 
-	// Check growth percentage
-	percentage := a.cfg.AutoAofRewritePercentage
-	if percentage == 0 {
-		percentage = 100
+	a.mu.RLock()
+	base := a.baseSize
+	a.mu.RUnlock()
+	if base == 0 {
+		base = 1
 	}
 
-	if a.baseSize == 0 {
-		a.baseSize = size
-		return false
-	}
+	growth := (size - base) * 100 / base
+	return growth >= int64(percentage)
+}
 
-	growth := (size - a.baseSize) * 100 / a.baseSize
-	return int64(growth) >= int64(percentage)
+// AutoRewrite starts a background rewrite if the AOF file has grown past the
+// auto-aof-rewrite-percentage and auto-aof-rewrite-min-size thresholds.
+// It returns nil if no rewrite was started.
+func (a *AOF) AutoRewrite(dbs []*database.DB) chan error {
+	if !a.ShouldRewrite() {
+		return nil
+	}
+	errChan, err := a.RewriteInBackground(dbs)
+	if err != nil {
+		return nil
+	}
+	return errChan
 }
 
 // CommandHandler is the interface for executing commands during AOF load
@@ -0,0 +1,370 @@
+package aof
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/command"
+	"github.com/zyhnesmr/godis/internal/config"
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+func TestAutoRewriteOnGrowth(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	cfg.AutoAofRewritePercentage = 100
+	cfg.AutoAofRewriteMinSize = 4096
+
+	a := NewAOF(t.TempDir(), "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	defer a.Close()
+
+	db := database.NewDB(0)
+	dbs := []*database.DB{db}
+
+	if errChan := a.AutoRewrite(dbs); errChan != nil {
+		t.Fatalf("AutoRewrite should not trigger on an empty file")
+	}
+
+	// Overwrite the same key many times: the log grows, the dataset doesn't
+	for i := 0; i < 500; i++ {
+		value := "value-of-some-length"
+		db.Set("counter", database.NewStringObject(value))
+		if err := a.LogCommand(0, "SET", []string{"counter", value}); err != nil {
+			t.Fatalf("LogCommand failed: %v", err)
+		}
+	}
+
+	before, _ := a.FileSize()
+	if before < cfg.AutoAofRewriteMinSize {
+		t.Fatalf("AOF size %d below min size %d", before, cfg.AutoAofRewriteMinSize)
+	}
+
+	errChan := a.AutoRewrite(dbs)
+	if errChan == nil {
+		t.Fatalf("AutoRewrite expected to trigger at size %d", before)
+	}
+	if err := <-errChan; err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+
+	after, _ := a.FileSize()
+	if after >= before {
+		t.Errorf("rewrite expected to shrink the file, before=%d after=%d", before, after)
+	}
+
+	// The rewritten file is the new growth base
+	if a.ShouldRewrite() {
+		t.Errorf("ShouldRewrite expected false right after a rewrite")
+	}
+
+	// Appends keep going to the rewritten file
+	if err := a.LogCommand(0, "SET", []string{"other", "1"}); err != nil {
+		t.Fatalf("LogCommand after rewrite failed: %v", err)
+	}
+	if size, _ := a.FileSize(); size <= after {
+		t.Errorf("append after rewrite expected to grow the file, got %d", size)
+	}
+}
+
+// crash drops the AOF without flushing its buffer, as a killed process would
+func crash(a *AOF) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	close(a.closeChan)
+	a.enabled.Store(false)
+	_ = a.file.Close()
+	a.file = nil
+	a.writer = nil
+}
+
+// replay loads the AOF in dir and returns the commands it holds
+func replay(t *testing.T, dir string, cfg *config.Config) []string {
+	t.Helper()
+
+	var cmds []string
+	err := NewAOF(dir, "appendonly.aof", cfg).Load(nil, func(db int, cmdName string, args []string) error {
+		cmds = append(cmds, cmdName+" "+strings.Join(args, " "))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	return cmds
+}
+
+func TestFsyncAlwaysSurvivesCrash(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	a := NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		if err := a.LogCommand(0, "SET", []string{"k" + strconv.Itoa(i), "v"}); err != nil {
+			t.Fatalf("LogCommand failed: %v", err)
+		}
+	}
+	crash(a)
+
+	want := []string{"SET k0 v", "SET k1 v", "SET k2 v"}
+	if got := replay(t, dir, cfg); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("replayed %v after crash, want %v", got, want)
+	}
+}
+
+func TestFsyncEverySecBuffersWrites(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "everysec"
+	dir := t.TempDir()
+
+	a := NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	defer a.Close()
+
+	if err := a.LogCommand(0, "SET", []string{"k", "v"}); err != nil {
+		t.Fatalf("LogCommand failed: %v", err)
+	}
+	if size, _ := a.FileSize(); size != 0 {
+		t.Fatalf("everysec expected the write to stay buffered, file has %d bytes", size)
+	}
+
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		if size, _ := a.FileSize(); size > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("fsync loop never wrote the buffered command")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestNoAppendfsyncOnRewrite(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+
+	a := NewAOF(t.TempDir(), "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	defer a.Close()
+
+	a.rewriteInProgress.Store(true)
+	defer a.rewriteInProgress.Store(false)
+
+	if a.fsyncSuppressed() {
+		t.Error("fsync suppressed with no-appendfsync-on-rewrite off")
+	}
+	cfg.NoAppendfsyncOnRewrite = true
+	if !a.fsyncSuppressed() {
+		t.Error("fsync expected to be suppressed during a rewrite")
+	}
+
+	// Suppressed writes still reach the file
+	if err := a.LogCommand(0, "SET", []string{"k", "v"}); err != nil {
+		t.Fatalf("LogCommand failed: %v", err)
+	}
+	if size, _ := a.FileSize(); size == 0 {
+		t.Error("write under always expected to reach the file while fsync is suppressed")
+	}
+}
+
+func TestRewriteLogsEachWriteOnce(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	a := NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	defer a.Close()
+
+	db := database.NewDB(0)
+	write := func(key string) {
+		db.Set(key, database.NewStringObject("1"))
+		if err := a.LogCommand(0, "SET", []string{key, "1"}); err != nil {
+			t.Fatalf("LogCommand failed: %v", err)
+		}
+	}
+	write("before")
+
+	// A write made after the rewrite started but before its snapshot is in
+	// the snapshot only, and one made after the snapshot is logged only
+	a.rewriteInProgress.Store(true)
+	write("during")
+	a.SetWriteBarrier(func(snapshot func()) {
+		snapshot()
+		write("after-snapshot")
+	})
+	if err := a.rewrite([]*database.DB{db}); err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	write("after")
+
+	count := make(map[string]int)
+	for _, cmd := range replay(t, dir, cfg) {
+		if fields := strings.Fields(cmd); fields[0] == "SET" {
+			count[fields[1]]++
+		}
+	}
+	for _, key := range []string{"before", "during", "after-snapshot", "after"} {
+		if count[key] != 1 {
+			t.Errorf("key %s written %d times in the rewritten AOF, want 1", key, count[key])
+		}
+	}
+}
+
+func TestBgrewriteaofRejectsConcurrentRewrite(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	a := NewAOF(t.TempDir(), "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	defer a.Close()
+
+	SetAOFManager(a)
+	SetDBSelectorForAOF(database.NewDBSelector(1))
+	t.Cleanup(func() {
+		SetAOFManager(nil)
+		SetDBSelectorForAOF(nil)
+	})
+
+	a.rewriteInProgress.Store(true)
+	reply, _ := bgrewriteaofCmd(&command.Context{})
+	if !reply.IsError() {
+		t.Errorf("BGREWRITEAOF during a rewrite expected error, got %v", reply.Value)
+	}
+	if !IsBgRewriteInProgress() {
+		t.Error("IsBgRewriteInProgress expected true during a rewrite")
+	}
+	a.rewriteInProgress.Store(false)
+
+	reply, _ = bgrewriteaofCmd(&command.Context{})
+	if reply.IsError() {
+		t.Fatalf("BGREWRITEAOF failed: %v", reply.Value)
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for IsBgRewriteInProgress() {
+		if time.Now().After(deadline) {
+			t.Fatal("BGREWRITEAOF did not finish")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if !LastBgRewriteOK() {
+		t.Error("LastBgRewriteOK expected true after a successful rewrite")
+	}
+}
+
+func TestSelectBeforeDatabaseSwitch(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	dbs := make([]*database.DB, 4)
+	for i := range dbs {
+		dbs[i] = database.NewDB(i)
+	}
+
+	a := NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	for _, cmd := range []struct {
+		db  int
+		key string
+	}{{0, "a"}, {3, "b"}, {3, "c"}, {0, "d"}} {
+		dbs[cmd.db].Set(cmd.key, database.NewStringObject("v"))
+		if err := a.LogCommand(cmd.db, "SET", []string{cmd.key, "v"}); err != nil {
+			t.Fatalf("LogCommand failed: %v", err)
+		}
+	}
+
+	// Commands logged during a rewrite follow the snapshot's last SELECT,
+	// and those after it follow the rewritten file's
+	a.rewriteInProgress.Store(true)
+	dbs[3].Set("e", database.NewStringObject("v"))
+	if err := a.LogCommand(3, "SET", []string{"e", "v"}); err != nil {
+		t.Fatalf("LogCommand failed: %v", err)
+	}
+	if err := a.rewrite(dbs); err != nil {
+		t.Fatalf("rewrite failed: %v", err)
+	}
+	if err := a.LogCommand(3, "SET", []string{"f", "v"}); err != nil {
+		t.Fatalf("LogCommand failed: %v", err)
+	}
+	if err := a.LogCommand(0, "SET", []string{"g", "v"}); err != nil {
+		t.Fatalf("LogCommand failed: %v", err)
+	}
+	a.Close()
+
+	got := make(map[string]int)
+	err := NewAOF(dir, "appendonly.aof", cfg).Load(dbs, func(db int, cmdName string, args []string) error {
+		got[args[0]] = db
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	want := map[string]int{"a": 0, "b": 3, "c": 3, "d": 0, "e": 3, "f": 3, "g": 0}
+	for key, db := range want {
+		if g, ok := got[key]; !ok || g != db {
+			t.Errorf("key %s replayed into DB %d (%v), expected DB %d", key, g, ok, db)
+		}
+	}
+}
+
+func TestLoadReplaysCompleteTransactionsOnly(t *testing.T) {
+	cfg := config.Default()
+	cfg.AppendOnly = "no"
+	cfg.AppendFsync = "always"
+	dir := t.TempDir()
+
+	a := NewAOF(dir, "appendonly.aof", cfg)
+	if err := a.Enable(); err != nil {
+		t.Fatalf("Enable failed: %v", err)
+	}
+	for _, argv := range [][]string{
+		{"SET", "a", "1"},
+		{"MULTI"}, {"INCR", "a"}, {"SET", "b", "1"}, {"EXEC"},
+		{"MULTI"}, {"SET", "c", "1"},
+	} {
+		if err := a.LogCommand(0, argv[0], argv[1:]); err != nil {
+			t.Fatalf("LogCommand failed: %v", err)
+		}
+	}
+	a.Close()
+
+	var replayed []string
+	err := NewAOF(dir, "appendonly.aof", cfg).Load(nil, func(db int, cmdName string, args []string) error {
+		replayed = append(replayed, cmdName+" "+strings.Join(args, " "))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	// The transaction the file ends in was cut short and is dropped
+	want := []string{"SET a 1", "INCR a", "SET b 1"}
+	if strings.Join(replayed, ",") != strings.Join(want, ",") {
+		t.Errorf("replayed %q, want %q", replayed, want)
+	}
+}
@@ -7,7 +7,6 @@ package aof
 import (
 	"fmt"
 	"os"
-	"sync/atomic"
 	"time"
 
 	"github.com/zyhnesmr/godis/internal/command"
@@ -35,9 +34,6 @@ func SetCommandHandler(handler CommandHandler) {
 	commandHandler = handler
 }
 
-// rewriteInProgress is used to prevent concurrent rewrites
-var rewriteInProgress atomic.Bool
-
 // RegisterAOFCommands registers all AOF commands
 func RegisterAOFCommands(disp interface{}) {
 	type registerer interface {
@@ -101,38 +97,29 @@ func bgrewriteaofCmd(ctx *command.Context) (*command.Reply, error) {
 		return command.NewErrorReplyStr("ERR AOF not initialized"), nil
 	}
 
-	if !rewriteInProgress.CompareAndSwap(false, true) {
-		return command.NewErrorReplyStr("ERR Background append only file rewriting already in progress"), nil
+	// Collect all databases
+	dbs := make([]*database.DB, dbSelector.Count())
+	for i := 0; i < dbSelector.Count(); i++ {
+		db, err := dbSelector.GetDB(i)
+		if err != nil {
+			return command.NewErrorReply(err), nil
+		}
+		dbs[i] = db
 	}
 
-	// Check if AOF is enabled
-	if !aofManager.IsEnabled() {
-		rewriteInProgress.Store(false)
-		return command.NewStatusReply("Background append only file rewriting started"), nil
+	startTime := time.Now()
+	errChan, err := aofManager.RewriteInBackground(dbs)
+	if err != nil {
+		return command.NewErrorReplyStr("ERR Background append only file rewriting already in progress"), nil
 	}
 
-	// Run rewrite in background
+	// Wait for the rewrite in the background and log its outcome
 	go func() {
-		defer rewriteInProgress.Store(false)
-
-		// Collect all databases
-		dbs := make([]*database.DB, dbSelector.Count())
-		for i := 0; i < dbSelector.Count(); i++ {
-			db, err := dbSelector.GetDB(i)
-			if err != nil {
-				fmt.Fprintf(os.Stderr, "BGREWRITEAOF failed: %v\n", err)
-				return
-			}
-			dbs[i] = db
-		}
-
-		startTime := time.Now()
-		if err := aofManager.Rewrite(dbs); err != nil {
+		if err := <-errChan; err != nil {
 			fmt.Fprintf(os.Stderr, "BGREWRITEAOF failed: %v\n", err)
-		} else {
-			duration := time.Since(startTime)
-			fmt.Fprintf(os.Stderr, "BGREWRITEAOF completed in %s\n", duration)
+			return
 		}
+		fmt.Fprintf(os.Stderr, "BGREWRITEAOF completed in %s\n", time.Since(startTime))
 	}()
 
 	return command.NewStatusReply("Background append only file rewriting started"), nil
@@ -159,6 +146,31 @@ func GetAOFManager() *AOF {
 	return aofManager
 }
 
+// IsBgRewriteInProgress returns true if an AOF rewrite is running
+func IsBgRewriteInProgress() bool {
+	if aofManager == nil {
+		return false
+	}
+	return aofManager.IsRewriteInProgress()
+}
+
+// LastBgRewriteOK returns true if the last AOF rewrite succeeded
+func LastBgRewriteOK() bool {
+	if aofManager == nil {
+		return true
+	}
+	return aofManager.LastRewriteOK()
+}
+
+// LastRewriteTime returns when the last AOF rewrite finished, or the zero
+// time if none has run
+func LastRewriteTime() time.Time {
+	if aofManager == nil {
+		return time.Time{}
+	}
+	return aofManager.GetLastRewriteTime()
+}
+
 // ShouldRewriteAOF returns true if AOF rewrite should be triggered
 func ShouldRewriteAOF() bool {
 	if aofManager == nil {
@@ -5,9 +5,11 @@
 package aof
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"sync"
 	"time"
@@ -16,20 +18,37 @@ import (
 	"github.com/zyhnesmr/godis/internal/datastruct/hash"
 	"github.com/zyhnesmr/godis/internal/datastruct/list"
 	"github.com/zyhnesmr/godis/internal/datastruct/set"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
 	"github.com/zyhnesmr/godis/internal/datastruct/zset"
+	"github.com/zyhnesmr/godis/internal/latency"
 	"github.com/zyhnesmr/godis/internal/protocol/resp"
 )
 
+// ErrRewriteInProgress is returned when a rewrite is started while another
+// one is running
+var ErrRewriteInProgress = errors.New("AOF rewrite already in progress")
+
 // Rewrite performs an AOF rewrite
 func (a *AOF) Rewrite(dbs []*database.DB) error {
-	if a.rewriteInProgress.Load() {
-		return fmt.Errorf("AOF rewrite already in progress")
+	if !a.rewriteInProgress.CompareAndSwap(false, true) {
+		return ErrRewriteInProgress
 	}
+	return a.rewrite(dbs)
+}
 
-	a.rewriteInProgress.Store(true)
+// rewrite performs an AOF rewrite with rewriteInProgress already set, and
+// records its outcome
+func (a *AOF) rewrite(dbs []*database.DB) (err error) {
+	start := time.Now()
 	defer func() {
-		a.rewriteInProgress.Store(false)
+		latency.Instance().Add(latency.EventAOFRewrite, time.Since(start))
+		a.mu.Lock()
+		a.rewriteBuf = nil
+		a.rewriteBufDB = 0
 		a.lastRewriteTime = time.Now()
+		a.mu.Unlock()
+		a.lastRewriteFailed.Store(err != nil)
+		a.rewriteInProgress.Store(false)
 	}()
 
 	// Create temporary file
@@ -40,26 +59,27 @@ func (a *AOF) Rewrite(dbs []*database.DB) error {
 	}
 	defer tmpFile.Close()
 
-	// Create serializer
+	// Serialize the databases while no write runs, so that the snapshot is
+	// a point in time: writes logged from then on are the only ones added
+	// after it
 	builder := resp.NewResponseBuilder()
-
-	// Rewrite all databases
-	for dbIdx, db := range dbs {
-		// Write SELECT command
-		a.writeSelectCommand(builder, dbIdx)
-
-		// Get all keys from this database
-		keys := db.Keys("*")
-		if len(keys) == 0 {
-			continue
-		}
-
-		// Rewrite each key
-		for _, key := range keys {
-			if err := a.rewriteKey(db, builder, key); err != nil {
-				return fmt.Errorf("failed to rewrite key %s: %w", key, err)
-			}
-		}
+	a.mu.RLock()
+	barrier := a.writeBarrier
+	a.mu.RUnlock()
+	snapshot := func() {
+		err = a.writeSnapshot(builder, dbs)
+		a.mu.Lock()
+		a.rewriteBuf = nil
+		a.rewriteBufDB = 0
+		a.mu.Unlock()
+	}
+	if barrier != nil {
+		barrier(snapshot)
+	} else {
+		snapshot()
+	}
+	if err != nil {
+		return err
 	}
 
 	// Write buffer to file
@@ -67,6 +87,17 @@ func (a *AOF) Rewrite(dbs []*database.DB) error {
 		return fmt.Errorf("failed to write rewrite file: %w", err)
 	}
 
+	// Block new appends while the rewritten file replaces the current one
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	// Append commands logged since the snapshot
+	if len(a.rewriteBuf) > 0 {
+		if _, err := tmpFile.Write(a.rewriteBuf); err != nil {
+			return fmt.Errorf("failed to write rewrite file: %w", err)
+		}
+	}
+
 	// Sync to disk
 	if err := tmpFile.Sync(); err != nil {
 		return fmt.Errorf("failed to sync rewrite file: %w", err)
@@ -78,6 +109,22 @@ func (a *AOF) Rewrite(dbs []*database.DB) error {
 		return fmt.Errorf("failed to rename rewrite file: %w", err)
 	}
 
+	// Point the appender at the rewritten file
+	if a.file != nil {
+		_ = a.writer.Flush()
+		_ = a.file.Close()
+
+		file, err := os.OpenFile(finalFilename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			a.file = nil
+			a.writer = nil
+			return fmt.Errorf("failed to reopen AOF file: %w", err)
+		}
+		a.file = file
+		a.writer.Reset(file)
+	}
+	a.selectedDB = a.rewriteBufDB
+
 	// Update base size
 	if info, err := os.Stat(finalFilename); err == nil {
 		a.baseSize = info.Size()
@@ -86,16 +133,42 @@ func (a *AOF) Rewrite(dbs []*database.DB) error {
 	return nil
 }
 
-// RewriteInBackground performs an AOF rewrite in the background
-func (a *AOF) RewriteInBackground(dbs []*database.DB) chan error {
-	errChan := make(chan error, 1)
+// writeSnapshot writes commands recreating the databases to builder,
+// ending with a SELECT of DB 0
+func (a *AOF) writeSnapshot(builder *resp.ResponseBuilder, dbs []*database.DB) error {
+	for dbIdx, db := range dbs {
+		// Write SELECT command
+		a.writeSelectCommand(builder, dbIdx)
 
+		// Rewrite each key
+		for _, key := range db.Keys("*") {
+			if err := a.rewriteKey(db, builder, key); err != nil {
+				return fmt.Errorf("failed to rewrite key %s: %w", key, err)
+			}
+		}
+	}
+
+	// Commands appended after the rewrite are logged against DB 0, which
+	// rewriteBufDB starts from
+	a.writeSelectCommand(builder, 0)
+	return nil
+}
+
+// RewriteInBackground starts an AOF rewrite in a goroutine and returns a
+// channel receiving its result. It fails with ErrRewriteInProgress if a
+// rewrite is already running.
+func (a *AOF) RewriteInBackground(dbs []*database.DB) (chan error, error) {
+	if !a.rewriteInProgress.CompareAndSwap(false, true) {
+		return nil, ErrRewriteInProgress
+	}
+
+	errChan := make(chan error, 1)
 	go func() {
 		defer close(errChan)
-		errChan <- a.Rewrite(dbs)
+		errChan <- a.rewrite(dbs)
 	}()
 
-	return errChan
+	return errChan, nil
 }
 
 // rewriteKey rewrites a single key to the AOF file
@@ -107,20 +180,33 @@ func (a *AOF) rewriteKey(db *database.DB, builder *resp.ResponseBuilder, key str
 	}
 
 	// Get type and rewrite accordingly
+	var err error
 	switch obj.Type {
 	case database.ObjTypeString:
-		return a.rewriteString(builder, key, obj)
+		err = a.rewriteString(builder, key, obj)
 	case database.ObjTypeList:
-		return a.rewriteList(builder, key, obj)
+		err = a.rewriteList(builder, key, obj)
 	case database.ObjTypeSet:
-		return a.rewriteSet(builder, key, obj)
+		err = a.rewriteSet(builder, key, obj)
 	case database.ObjTypeHash:
-		return a.rewriteHash(builder, key, obj)
+		err = a.rewriteHash(builder, key, obj)
 	case database.ObjTypeZSet:
-		return a.rewriteZSet(builder, key, obj)
+		err = a.rewriteZSet(builder, key, obj)
+	case database.ObjTypeStream:
+		err = a.rewriteStream(builder, key, obj)
 	default:
-		return fmt.Errorf("unknown object type: %s", obj.Type)
+		err = fmt.Errorf("unknown object type: %s", obj.Type)
+	}
+	if err != nil {
+		return err
+	}
+
+	// Restore the key's expiration
+	// PEXPIREAT key unix-time-milliseconds
+	if at, ok := db.PExpireTime(key); ok {
+		writeCommand(builder, "PEXPIREAT", key, strconv.FormatInt(at, 10))
 	}
+	return nil
 }
 
 // rewriteString rewrites a string key
@@ -211,9 +297,9 @@ func (a *AOF) rewriteHash(builder *resp.ResponseBuilder, key string, obj *databa
 		return fmt.Errorf("not a hash object")
 	}
 
-	// Get all fields and values
-	hashMap := h.GetAllMap()
-	if len(hashMap) == 0 {
+	// Get all fields and values, in insertion order for a listpack hash
+	args := h.GetAll()
+	if len(args) == 0 {
 		// Empty hash, use HSET
 		builder.WriteArray(2)
 		builder.WriteBulkStringFromString("HSET")
@@ -223,11 +309,6 @@ func (a *AOF) rewriteHash(builder *resp.ResponseBuilder, key string, obj *databa
 
 	// Write HSET command with all fields
 	// HSET key field1 value1 field2 value2 ...
-	args := make([]string, 0, len(hashMap)*2)
-	for field, value := range hashMap {
-		args = append(args, field, value)
-	}
-
 	builder.WriteArray(2 + len(args))
 	builder.WriteBulkStringFromString("HSET")
 	builder.WriteBulkStringFromString(key)
@@ -235,6 +316,18 @@ func (a *AOF) rewriteHash(builder *resp.ResponseBuilder, key string, obj *databa
 		builder.WriteBulkStringFromString(arg)
 	}
 
+	// Restore field expirations
+	// HPEXPIREAT key unix-time-milliseconds FIELDS 1 field
+	for field, at := range h.FieldExpires() {
+		builder.WriteArray(6)
+		builder.WriteBulkStringFromString("HPEXPIREAT")
+		builder.WriteBulkStringFromString(key)
+		builder.WriteBulkStringFromString(strconv.FormatInt(at, 10))
+		builder.WriteBulkStringFromString("FIELDS")
+		builder.WriteBulkStringFromString("1")
+		builder.WriteBulkStringFromString(field)
+	}
+
 	return nil
 }
 
@@ -268,6 +361,95 @@ func (a *AOF) rewriteZSet(builder *resp.ResponseBuilder, key string, obj *databa
 	return nil
 }
 
+// rewriteStream rewrites a stream key with its consumer groups
+func (a *AOF) rewriteStream(builder *resp.ResponseBuilder, key string, obj *database.Object) error {
+	strmVal, ok := obj.GetStream()
+	if !ok {
+		return fmt.Errorf("not a stream object")
+	}
+	strm := strmVal.(*stream.Stream)
+
+	// XADD key id field1 value1 field2 value2 ...
+	entries := strm.GetEntries()
+	for _, entry := range entries {
+		fields := entry.GetFields()
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		args := []string{"XADD", key, entry.ID.String()}
+		for _, name := range names {
+			args = append(args, name, fields[name])
+		}
+		writeCommand(builder, args...)
+	}
+
+	groups := strm.GetConsumerGroupManager().GetGroups()
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	if len(entries) == 0 {
+		// An empty stream is created by adding an entry that is trimmed
+		// right away, or by a group when it never had an entry
+		lastID := strm.GetLastID()
+		switch {
+		case !lastID.IsZero():
+			writeCommand(builder, "XADD", key, "MAXLEN", "0", lastID.String(), "x", "y")
+		case len(groupNames) > 0:
+			writeCommand(builder, "XGROUP", "CREATE", key, groupNames[0], "0", "MKSTREAM")
+		default:
+			writeCommand(builder, "XGROUP", "CREATE", key, "x", "0", "MKSTREAM")
+			writeCommand(builder, "XGROUP", "DESTROY", key, "x")
+		}
+	}
+
+	// XSETID key last-id ENTRIESADDED entries-added MAXDELETEDID max-deleted-id
+	writeCommand(builder, "XSETID", key, strm.GetLastID().String(),
+		"ENTRIESADDED", strconv.FormatInt(strm.EntriesAdded(), 10),
+		"MAXDELETEDID", strm.MaxDeletedID().String())
+
+	for _, name := range groupNames {
+		group := groups[name]
+		lastID := group.GetLastID().String()
+		writeCommand(builder, "XGROUP", "CREATE", key, name, lastID)
+		writeCommand(builder, "XGROUP", "SETID", key, name, lastID,
+			"ENTRIESREAD", strconv.FormatInt(group.GetEntriesRead(), 10))
+
+		consumers := group.GetConsumers()
+		consumerNames := make([]string, 0, len(consumers))
+		for consumer := range consumers {
+			consumerNames = append(consumerNames, consumer)
+		}
+		sort.Strings(consumerNames)
+		for _, consumer := range consumerNames {
+			writeCommand(builder, "XGROUP", "CREATECONSUMER", key, name, consumer)
+		}
+
+		// Restore each pending entry with its owner, delivery time and count
+		for _, pe := range group.GetPending() {
+			writeCommand(builder, "XCLAIM", key, name, pe.Consumer, "0", pe.ID.String(),
+				"TIME", strconv.FormatInt(pe.DeliveryTime, 10),
+				"RETRYCOUNT", strconv.FormatInt(pe.DeliveryCount, 10),
+				"FORCE", "JUSTID")
+		}
+	}
+
+	return nil
+}
+
+// writeCommand writes a command as an array of bulk strings
+func writeCommand(builder *resp.ResponseBuilder, args ...string) {
+	builder.WriteArray(len(args))
+	for _, arg := range args {
+		builder.WriteBulkStringFromString(arg)
+	}
+}
+
 // writeSelectCommand writes a SELECT command
 func (a *AOF) writeSelectCommand(builder *resp.ResponseBuilder, db int) {
 	builder.WriteArray(2)
@@ -382,9 +564,16 @@ func (a *AOF) RewriteWithProgress(dbs []*database.DB) (*RewriteProgress, error)
 
 // GetLastRewriteTime returns the time of the last rewrite
 func (a *AOF) GetLastRewriteTime() time.Time {
+	a.mu.RLock()
+	defer a.mu.RUnlock()
 	return a.lastRewriteTime
 }
 
+// LastRewriteOK returns true unless the last rewrite failed
+func (a *AOF) LastRewriteOK() bool {
+	return !a.lastRewriteFailed.Load()
+}
+
 // IsRewriteInProgress returns true if a rewrite is in progress
 func (a *AOF) IsRewriteInProgress() bool {
 	return a.rewriteInProgress.Load()
@@ -7,20 +7,32 @@ package rdb
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
 	"fmt"
 	"hash"
 	"hash/crc64"
 	"io"
 	"math"
+	"strconv"
 	"time"
 
 	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
+)
+
+// Load errors
+var (
+	ErrBadHeader        = errors.New("invalid RDB header")
+	ErrTruncated        = errors.New("truncated RDB file")
+	ErrChecksumMismatch = errors.New("RDB checksum mismatch")
 )
 
 // Decoder decodes RDB format to database state
 type Decoder struct {
 	r   *bufio.Reader
 	crc hash.Hash64
+
+	skipChecksum bool // don't verify the trailing checksum
 }
 
 // NewDecoder creates a new RDB decoder
@@ -31,8 +43,22 @@ func NewDecoder(r io.Reader) *Decoder {
 	}
 }
 
+// SetChecksum sets whether the trailing CRC64 checksum is verified
+func (d *Decoder) SetChecksum(enabled bool) {
+	d.skipChecksum = !enabled
+}
+
 // Decode reads the RDB file and loads data into databases
 func (d *Decoder) Decode(dbs []*database.DB) error {
+	err := d.decode(dbs)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %v", ErrTruncated, err)
+	}
+	return err
+}
+
+// decode reads the header and the databases up to the EOF opcode
+func (d *Decoder) decode(dbs []*database.DB) error {
 	// Read and verify header
 	if err := d.readHeader(); err != nil {
 		return err
@@ -97,7 +123,9 @@ func (d *Decoder) Decode(dbs []*database.DB) error {
 		case OpcodeExpireTime, OpcodeExpireMS:
 			// This should be followed by a key-value pair
 			// Read the key-value pair with expiration
-			return d.readKeyValuePairWithExpire(dbs[0], opcode)
+			if err := d.readKeyValuePairWithExpire(dbs[0], opcode); err != nil {
+				return err
+			}
 		default:
 			// Unknown opcode, might be a value type
 			// Unread the byte and try as value type
@@ -106,28 +134,24 @@ func (d *Decoder) Decode(dbs []*database.DB) error {
 	}
 }
 
-// readHeader reads and verifies the RDB file header
+// readHeader reads and verifies the RDB file header: the magic string
+// followed by the version as four ASCII digits
 func (d *Decoder) readHeader() error {
-	// Read magic string
-	magic := make([]byte, 5)
-	if _, err := io.ReadFull(d.r, magic); err != nil {
+	header := make([]byte, len(Magic)+4)
+	if _, err := io.ReadFull(d.r, header); err != nil {
 		return err
 	}
-	if string(magic) != Magic {
-		return fmt.Errorf("invalid RDB magic: %s", string(magic))
+	if string(header[:len(Magic)]) != Magic {
+		return fmt.Errorf("%w: bad magic %q", ErrBadHeader, header[:len(Magic)])
 	}
-	d.crc.Write(magic)
-
-	// Read version
-	versionBytes := make([]byte, 4)
-	if _, err := io.ReadFull(d.r, versionBytes); err != nil {
-		return err
+	version, err := strconv.Atoi(string(header[len(Magic):]))
+	if err != nil || version < 1 {
+		return fmt.Errorf("%w: bad version %q", ErrBadHeader, header[len(Magic):])
 	}
-	version := binary.BigEndian.Uint32(versionBytes)
 	if version > RDBVersion {
-		return fmt.Errorf("unsupported RDB version: %d", version)
+		return fmt.Errorf("%w: can't handle RDB format version %d", ErrBadHeader, version)
 	}
-	d.crc.Write(versionBytes)
+	d.crc.Write(header)
 
 	return nil
 }
@@ -155,24 +179,17 @@ func (d *Decoder) readLength() (uint64, error) {
 		d.crc.Write([]byte{b2})
 		return uint64(b&0x3F)<<8 | uint64(b2), nil
 
-	case b&0xC0 == 0x80:
-		// 32-bit or 64-bit length
-		b2, err := d.r.ReadByte()
-		if err != nil {
+	case b == 0x80:
+		// 32-bit length
+		bytes := make([]byte, 4)
+		if _, err := io.ReadFull(d.r, bytes); err != nil {
 			return 0, err
 		}
-		d.crc.Write([]byte{b2})
+		d.crc.Write(bytes)
+		return uint64(binary.BigEndian.Uint32(bytes)), nil
 
-		if b2&0x80 == 0 {
-			// 32-bit
-			bytes := make([]byte, 4)
-			if _, err := io.ReadFull(d.r, bytes); err != nil {
-				return 0, err
-			}
-			d.crc.Write(bytes)
-			return uint64(binary.BigEndian.Uint32(bytes)), nil
-		}
-		// 64-bit
+	case b == 0x81:
+		// 64-bit length
 		bytes := make([]byte, 8)
 		if _, err := io.ReadFull(d.r, bytes); err != nil {
 			return 0, err
@@ -222,16 +239,17 @@ func (d *Decoder) readKeyValuePairs(db *database.DB) error {
 		if b == OpcodeEOF || b == OpcodeSelectDB || b == OpcodeAux || b == OpcodeResizeDB {
 			// Unread the opcode
 			d.r.UnreadByte()
-			break
+			return nil
 		}
 
-		// Unread and check for expiration opcode
-		d.r.UnreadByte()
-
-		// Check for expiration
+		// Check for expiration, which prefixes its key-value pair
 		if b == OpcodeExpireTime || b == OpcodeExpireMS {
-			return d.readKeyValuePairWithExpire(db, b)
+			if err := d.readKeyValuePairWithExpire(db, b); err != nil {
+				return err
+			}
+			continue
 		}
+		d.r.UnreadByte()
 
 		// Read key
 		key, err := d.readString()
@@ -239,52 +257,19 @@ func (d *Decoder) readKeyValuePairs(db *database.DB) error {
 			return err
 		}
 
-		// Read value type
-		valueType, err := d.r.ReadByte()
-		if err != nil {
-			return err
-		}
-		d.crc.Write([]byte{valueType})
-
-		// Read value based on type
-		var obj *database.Object
-		switch valueType {
-		case TypeString:
-			obj, err = d.readStringValue()
-		case TypeHash:
-			obj, err = d.readHashValue()
-		case TypeList:
-			obj, err = d.readListValue()
-		case TypeSet:
-			obj, err = d.readSetValue()
-		case TypeZSet, TypeZSet2:
-			obj, err = d.readZSetValue(valueType)
-		default:
-			return fmt.Errorf("unsupported value type: %d", valueType)
-		}
-
+		// Read value
+		obj, err := d.readObject()
 		if err != nil {
 			return err
 		}
 
 		// Store in database
 		db.Set(key, obj)
-
-		// Check for more
-		b, err = d.r.ReadByte()
-		if err != nil {
-			return err
-		}
-		d.r.UnreadByte()
-		if b == OpcodeEOF || b == OpcodeSelectDB {
-			break
-		}
 	}
-
-	return nil
 }
 
-// readKeyValuePairWithExpire reads a key-value pair with expiration
+// readKeyValuePairWithExpire reads a key-value pair with expiration, after
+// its expiration opcode
 func (d *Decoder) readKeyValuePairWithExpire(db *database.DB, opcode byte) error {
 	d.crc.Write([]byte{opcode})
 
@@ -296,7 +281,7 @@ func (d *Decoder) readKeyValuePairWithExpire(db *database.DB, opcode byte) error
 			return err
 		}
 		d.crc.Write(bytes)
-		expireTime = int64(binary.LittleEndian.Uint64(bytes)) / 1000
+		expireTime = int64(binary.LittleEndian.Uint64(bytes))
 	} else {
 		// Read 4 byte second timestamp
 		bytes := make([]byte, 4)
@@ -304,7 +289,7 @@ func (d *Decoder) readKeyValuePairWithExpire(db *database.DB, opcode byte) error
 			return err
 		}
 		d.crc.Write(bytes)
-		expireTime = int64(binary.BigEndian.Uint32(bytes))
+		expireTime = int64(binary.BigEndian.Uint32(bytes)) * 1000
 	}
 
 	// Read key
@@ -313,43 +298,46 @@ func (d *Decoder) readKeyValuePairWithExpire(db *database.DB, opcode byte) error
 		return err
 	}
 
-	// Read value type
-	valueType, err := d.r.ReadByte()
+	// Read value
+	obj, err := d.readObject()
 	if err != nil {
 		return err
 	}
+
+	// Keys already expired at load time are skipped
+	if expireTime <= time.Now().UnixMilli() {
+		return nil
+	}
+	db.Set(key, obj)
+	db.PExpireAt(key, expireTime)
+
+	return nil
+}
+
+// readObject reads a value type opcode and the payload that follows it
+func (d *Decoder) readObject() (*database.Object, error) {
+	valueType, err := d.r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
 	d.crc.Write([]byte{valueType})
 
-	// Read value based on type
-	var obj *database.Object
 	switch valueType {
 	case TypeString:
-		obj, err = d.readStringValue()
+		return d.readStringValue()
 	case TypeHash:
-		obj, err = d.readHashValue()
+		return d.readHashValue()
 	case TypeList:
-		obj, err = d.readListValue()
+		return d.readListValue()
 	case TypeSet:
-		obj, err = d.readSetValue()
+		return d.readSetValue()
 	case TypeZSet, TypeZSet2:
-		obj, err = d.readZSetValue(valueType)
+		return d.readZSetValue(valueType)
+	case TypeStream:
+		return d.readStreamValue()
 	default:
-		return fmt.Errorf("unsupported value type: %d", valueType)
-	}
-
-	if err != nil {
-		return err
-	}
-
-	// Store in database
-	db.Set(key, obj)
-
-	// Set expiration if in the future
-	if expireTime > time.Now().Unix() {
-		db.ExpireAt(key, expireTime)
+		return nil, fmt.Errorf("unsupported value type: %d", valueType)
 	}
-
-	return nil
 }
 
 // readStringValue reads a string value
@@ -400,6 +388,7 @@ func (d *Decoder) readHashValue() (*database.Object, error) {
 		}
 	}
 
+	hashObj.FitEncoding()
 	return hashObj, nil
 }
 
@@ -431,6 +420,7 @@ func (d *Decoder) readListValue() (*database.Object, error) {
 		listPtr.PushRight(elem)
 	}
 
+	list.FitEncoding()
 	return list, nil
 }
 
@@ -494,9 +484,159 @@ func (d *Decoder) readZSetValue(_ byte) (*database.Object, error) {
 		zsetImpl.Add(member, score)
 	}
 
+	zset.FitEncoding()
 	return zset, nil
 }
 
+// readStreamValue reads a stream value written by writeStreamValue
+func (d *Decoder) readStreamValue() (*database.Object, error) {
+	length, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+
+	obj := database.NewStreamObject()
+	strm := obj.Ptr.(*stream.Stream)
+
+	for i := 0; i < int(length); i++ {
+		id, err := d.readStreamID()
+		if err != nil {
+			return nil, err
+		}
+
+		count, err := d.readLength()
+		if err != nil {
+			return nil, err
+		}
+		fields := make(map[string]string, count)
+		for j := 0; j < int(count); j++ {
+			name, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			value, err := d.readString()
+			if err != nil {
+				return nil, err
+			}
+			fields[name] = value
+		}
+
+		if err := strm.AddWithID(id, fields); err != nil {
+			return nil, err
+		}
+	}
+
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	strm.SetLastID(lastID)
+
+	maxDeletedID, err := d.readStreamID()
+	if err != nil {
+		return nil, err
+	}
+	strm.SetMaxDeletedID(maxDeletedID)
+
+	entriesAdded, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	strm.SetEntriesAdded(int64(entriesAdded))
+
+	groupCount, err := d.readLength()
+	if err != nil {
+		return nil, err
+	}
+	for i := 0; i < int(groupCount); i++ {
+		if err := d.readConsumerGroup(strm); err != nil {
+			return nil, err
+		}
+	}
+
+	return obj, nil
+}
+
+// readConsumerGroup reads a consumer group written by writeConsumerGroup
+// and adds it to the stream
+func (d *Decoder) readConsumerGroup(strm *stream.Stream) error {
+	name, err := d.readString()
+	if err != nil {
+		return err
+	}
+	lastID, err := d.readStreamID()
+	if err != nil {
+		return err
+	}
+	entriesRead, err := d.readLength()
+	if err != nil {
+		return err
+	}
+
+	cgroups := strm.GetConsumerGroupManager()
+	if err := cgroups.CreateGroup(name, lastID); err != nil {
+		return err
+	}
+	group, _ := cgroups.GetGroup(name)
+	group.SetEntriesRead(int64(entriesRead) - 1)
+
+	consumerCount, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(consumerCount); i++ {
+		consumer, err := d.readString()
+		if err != nil {
+			return err
+		}
+		group.CreateConsumer(consumer)
+	}
+
+	pendingCount, err := d.readLength()
+	if err != nil {
+		return err
+	}
+	for i := 0; i < int(pendingCount); i++ {
+		id, err := d.readStreamID()
+		if err != nil {
+			return err
+		}
+		consumer, err := d.readString()
+		if err != nil {
+			return err
+		}
+		deliveryTime, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		deliveryCount, err := d.readLength()
+		if err != nil {
+			return err
+		}
+		group.RestorePending(stream.PendingEntry{
+			ID:            id,
+			Consumer:      consumer,
+			DeliveryTime:  int64(deliveryTime),
+			DeliveryCount: int64(deliveryCount),
+		})
+	}
+
+	return nil
+}
+
+// readStreamID reads a stream ID written by writeStreamID
+func (d *Decoder) readStreamID() (stream.StreamID, error) {
+	ts, err := d.readLength()
+	if err != nil {
+		return stream.StreamID{}, err
+	}
+	seq, err := d.readLength()
+	if err != nil {
+		return stream.StreamID{}, err
+	}
+	return stream.NewStreamID(int64(ts), int64(seq)), nil
+}
+
 // readCRC reads and verifies the CRC64 checksum
 func (d *Decoder) readCRC() error {
 	// Read 8 byte CRC64
@@ -505,11 +645,11 @@ func (d *Decoder) readCRC() error {
 		return err
 	}
 
-	// Verify CRC
+	// Verify CRC. A zero checksum means the file was saved without one.
 	crc := d.crc.Sum64()
 	fileCRC := binary.LittleEndian.Uint64(bytes)
-	if crc != fileCRC {
-		return fmt.Errorf("CRC mismatch: calculated=%x, file=%x", crc, fileCRC)
+	if !d.skipChecksum && fileCRC != 0 && crc != fileCRC {
+		return fmt.Errorf("%w: calculated=%x, file=%x", ErrChecksumMismatch, crc, fileCRC)
 	}
 
 	return nil
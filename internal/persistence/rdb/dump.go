@@ -0,0 +1,86 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc64"
+	"io"
+
+	"github.com/zyhnesmr/godis/internal/database"
+)
+
+// dumpFooterSize is the 2 byte RDB version plus the 8 byte CRC64
+const dumpFooterSize = 10
+
+// ErrBadDumpPayload is returned when a DUMP payload fails verification
+var ErrBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// DumpObject serializes a single value in the format used by DUMP: the RDB
+// value type and payload, followed by the RDB version (2 bytes, little
+// endian) and a CRC64 of everything before it (8 bytes, little endian)
+func DumpObject(obj *database.Object) ([]byte, error) {
+	var buf bytes.Buffer
+	encoder := NewEncoder(&buf)
+	if err := encoder.writeObject(obj); err != nil {
+		return nil, err
+	}
+	if err := encoder.w.Flush(); err != nil {
+		return nil, err
+	}
+
+	version := make([]byte, 2)
+	binary.LittleEndian.PutUint16(version, RDBVersion)
+	buf.Write(version)
+
+	crc := make([]byte, 8)
+	binary.LittleEndian.PutUint64(crc, crc64.Checksum(buf.Bytes(), crc64.MakeTable(crc64.ISO)))
+	buf.Write(crc)
+
+	return buf.Bytes(), nil
+}
+
+// SerializedLength returns the size of the RDB encoding of a value,
+// without the type byte and the DUMP footer
+func SerializedLength(obj *database.Object) (uint64, error) {
+	payload, err := DumpObject(obj)
+	if err != nil {
+		return 0, err
+	}
+	return uint64(len(payload) - dumpFooterSize - 1), nil
+}
+
+// RestoreObject deserializes a value produced by DumpObject after checking
+// its version and checksum
+func RestoreObject(data []byte) (*database.Object, error) {
+	if len(data) < dumpFooterSize {
+		return nil, ErrBadDumpPayload
+	}
+
+	footer := data[len(data)-dumpFooterSize:]
+	if binary.LittleEndian.Uint16(footer[:2]) > RDBVersion {
+		return nil, ErrBadDumpPayload
+	}
+
+	body := data[:len(data)-8]
+	if crc64.Checksum(body, crc64.MakeTable(crc64.ISO)) != binary.LittleEndian.Uint64(footer[2:]) {
+		return nil, ErrBadDumpPayload
+	}
+
+	payload := data[:len(data)-dumpFooterSize]
+	decoder := NewDecoder(bytes.NewReader(payload))
+	obj, err := decoder.readObject()
+	if err != nil {
+		return nil, ErrBadDumpPayload
+	}
+	// The payload must hold exactly one value
+	if _, err := decoder.r.ReadByte(); err != io.EOF {
+		return nil, ErrBadDumpPayload
+	}
+
+	return obj, nil
+}
@@ -13,9 +13,11 @@ import (
 	"hash/crc64"
 	"io"
 	"math"
+	"sort"
 	"time"
 
 	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
 	"github.com/zyhnesmr/godis/internal/datastruct/zset"
 )
 
@@ -36,7 +38,8 @@ const (
 	TypeSet    = 2
 	TypeZSet   = 3
 	TypeHash   = 4
-	TypeZSet2  = 5 // ZSet with double scores
+	TypeZSet2  = 5  // ZSet with double scores
+	TypeStream = 15 // Stream entries, metadata and consumer groups
 )
 
 // RDB version
@@ -50,6 +53,8 @@ type Encoder struct {
 	w   *bufio.Writer
 	crc hash.Hash64
 	pos int // track position for CRC
+
+	noChecksum bool // write a zero checksum
 }
 
 // NewEncoder creates a new RDB encoder
@@ -60,6 +65,11 @@ func NewEncoder(w io.Writer) *Encoder {
 	}
 }
 
+// SetChecksum sets whether the file ends with a CRC64 checksum
+func (e *Encoder) SetChecksum(enabled bool) {
+	e.noChecksum = !enabled
+}
+
 // Encode writes the database to RDB format
 func (e *Encoder) Encode(dbs []*database.DB) error {
 	// Write magic string and version
@@ -87,24 +97,14 @@ func (e *Encoder) Encode(dbs []*database.DB) error {
 	return nil
 }
 
-// writeHeader writes the RDB file header
+// writeHeader writes the RDB file header: the magic string followed by the
+// version as four ASCII digits, e.g. "REDIS0009"
 func (e *Encoder) writeHeader() error {
-	// Write magic string "REDIS"
-	if _, err := e.w.WriteString(Magic); err != nil {
-		return err
-	}
-	e.updateCRC([]byte("REDIS"))
-
-	// Write RDB version (4 bytes, big endian)
-	version := make([]byte, 4)
-	version[0] = byte(RDBVersion >> 24)
-	version[1] = byte(RDBVersion >> 16)
-	version[2] = byte(RDBVersion >> 8)
-	version[3] = byte(RDBVersion)
-	if _, err := e.w.Write(version); err != nil {
+	header := []byte(fmt.Sprintf("%s%04d", Magic, RDBVersion))
+	if _, err := e.w.Write(header); err != nil {
 		return err
 	}
-	e.updateCRC(version)
+	e.updateCRC(header)
 
 	return nil
 }
@@ -153,14 +153,14 @@ func (e *Encoder) writeDatabase(dbIndex int, db *database.DB) error {
 			continue
 		}
 
-		// Check expiration
+		// Check expiration, skipping keys that already expired
 		if exp, ok := expiresDict.Get(key); ok {
 			expireTime := exp.(int64)
-			// Only write expiration if in the future
-			if expireTime > time.Now().Unix() {
-				if err := e.writeExpireTime(expireTime); err != nil {
-					return err
-				}
+			if expireTime <= time.Now().UnixMilli() {
+				continue
+			}
+			if err := e.writeExpireTime(expireTime); err != nil {
+				return err
 			}
 		}
 
@@ -173,7 +173,7 @@ func (e *Encoder) writeDatabase(dbIndex int, db *database.DB) error {
 	return nil
 }
 
-// writeExpireTime writes the expiration time in milliseconds
+// writeExpireTime writes the expiration time, in Unix milliseconds
 func (e *Encoder) writeExpireTime(expireTime int64) error {
 	// Use millisecond precision (newer format)
 	if err := e.w.WriteByte(OpcodeExpireMS); err != nil {
@@ -182,9 +182,8 @@ func (e *Encoder) writeExpireTime(expireTime int64) error {
 	e.updateCRC([]byte{OpcodeExpireMS})
 
 	// Write 8 byte millisecond timestamp (little endian)
-	expireMS := expireTime * 1000
 	bytes := make([]byte, 8)
-	binary.LittleEndian.PutUint64(bytes, uint64(expireMS))
+	binary.LittleEndian.PutUint64(bytes, uint64(expireTime))
 	if _, err := e.w.Write(bytes); err != nil {
 		return err
 	}
@@ -200,7 +199,11 @@ func (e *Encoder) writeValue(key string, obj *database.Object) error {
 		return err
 	}
 
-	// Write value based on type
+	return e.writeObject(obj)
+}
+
+// writeObject writes the value type opcode and payload of an object
+func (e *Encoder) writeObject(obj *database.Object) error {
 	switch obj.Type {
 	case database.ObjTypeString:
 		return e.writeStringValue(obj)
@@ -213,9 +216,7 @@ func (e *Encoder) writeValue(key string, obj *database.Object) error {
 	case database.ObjTypeZSet:
 		return e.writeZSetValue(obj)
 	case database.ObjTypeStream:
-		// Stream: encode as List of IDs for now (simplified)
-		// Skip Stream in RDB for now - Streams can be reconstructed
-		return nil
+		return e.writeStreamValue(obj)
 	default:
 		return fmt.Errorf("unsupported type: %d", obj.Type)
 	}
@@ -252,29 +253,23 @@ func (e *Encoder) writeHashValue(obj *database.Object) error {
 	}
 	e.updateCRC([]byte{TypeHash})
 
-	// Get hash data via HGETALL-like approach
-	// We need to get field-value pairs from the hash
-	// Use a simple approach: try to get hash data through the object
-
-	// Get hash pointer directly
+	// Get hash data via HGETALL-like approach, which keeps the insertion
+	// order of a listpack hash across a save and load
 	type hashData interface {
-		GetAllMap() map[string]string
+		GetAll() []string
 	}
 
 	if ptr, ok := obj.Ptr.(hashData); ok {
-		hashMap := ptr.GetAllMap()
+		pairs := ptr.GetAll()
 
 		// Write length
-		if err := e.writeLength(uint64(len(hashMap))); err != nil {
+		if err := e.writeLength(uint64(len(pairs) / 2)); err != nil {
 			return err
 		}
 
 		// Write field-value pairs
-		for field, value := range hashMap {
-			if err := e.writeString(field); err != nil {
-				return err
-			}
-			if err := e.writeString(value); err != nil {
+		for _, s := range pairs {
+			if err := e.writeString(s); err != nil {
 				return err
 			}
 		}
@@ -412,6 +407,139 @@ func (e *Encoder) writeZSetValue(obj *database.Object) error {
 	return nil
 }
 
+// writeStreamValue writes a stream value: the entry count, each entry as
+// its ID and field-value pairs, then the last ID, the highest deleted ID and
+// the number of entries ever added
+func (e *Encoder) writeStreamValue(obj *database.Object) error {
+	if err := e.w.WriteByte(TypeStream); err != nil {
+		return err
+	}
+	e.updateCRC([]byte{TypeStream})
+
+	strm, ok := obj.Ptr.(*stream.Stream)
+	if !ok {
+		return errors.New("stream is not *stream.Stream type")
+	}
+
+	entries := strm.GetEntries()
+	if err := e.writeLength(uint64(len(entries))); err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if err := e.writeStreamID(entry.ID); err != nil {
+			return err
+		}
+
+		// Write fields in a stable order
+		fields := entry.GetFields()
+		names := make([]string, 0, len(fields))
+		for name := range fields {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		if err := e.writeLength(uint64(len(names))); err != nil {
+			return err
+		}
+		for _, name := range names {
+			if err := e.writeString(name); err != nil {
+				return err
+			}
+			if err := e.writeString(fields[name]); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := e.writeStreamID(strm.GetLastID()); err != nil {
+		return err
+	}
+	if err := e.writeStreamID(strm.MaxDeletedID()); err != nil {
+		return err
+	}
+	if err := e.writeLength(uint64(strm.EntriesAdded())); err != nil {
+		return err
+	}
+
+	// Write consumer groups in a stable order
+	groups := strm.GetConsumerGroupManager().GetGroups()
+	groupNames := make([]string, 0, len(groups))
+	for name := range groups {
+		groupNames = append(groupNames, name)
+	}
+	sort.Strings(groupNames)
+
+	if err := e.writeLength(uint64(len(groupNames))); err != nil {
+		return err
+	}
+	for _, name := range groupNames {
+		if err := e.writeConsumerGroup(groups[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeConsumerGroup writes a consumer group: its name, last delivered ID,
+// entries read, consumers and pending entries list
+func (e *Encoder) writeConsumerGroup(group *stream.ConsumerGroup) error {
+	if err := e.writeString(group.GetName()); err != nil {
+		return err
+	}
+	if err := e.writeStreamID(group.GetLastID()); err != nil {
+		return err
+	}
+	// Shifted by one so an unknown count (-1) fits a length
+	if err := e.writeLength(uint64(group.GetEntriesRead() + 1)); err != nil {
+		return err
+	}
+
+	consumers := group.GetConsumers()
+	consumerNames := make([]string, 0, len(consumers))
+	for name := range consumers {
+		consumerNames = append(consumerNames, name)
+	}
+	sort.Strings(consumerNames)
+
+	if err := e.writeLength(uint64(len(consumerNames))); err != nil {
+		return err
+	}
+	for _, name := range consumerNames {
+		if err := e.writeString(name); err != nil {
+			return err
+		}
+	}
+
+	pending := group.GetPending()
+	if err := e.writeLength(uint64(len(pending))); err != nil {
+		return err
+	}
+	for _, pe := range pending {
+		if err := e.writeStreamID(pe.ID); err != nil {
+			return err
+		}
+		if err := e.writeString(pe.Consumer); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(pe.DeliveryTime)); err != nil {
+			return err
+		}
+		if err := e.writeLength(uint64(pe.DeliveryCount)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeStreamID writes a stream ID as its timestamp and sequence lengths
+func (e *Encoder) writeStreamID(id stream.StreamID) error {
+	if err := e.writeLength(uint64(id.Timestamp)); err != nil {
+		return err
+	}
+	return e.writeLength(uint64(id.Sequence))
+}
+
 // writeString writes a string with length encoding
 func (e *Encoder) writeString(s string) error {
 	// Write string length
@@ -473,8 +601,12 @@ func (e *Encoder) writeEOF() error {
 	}
 	e.updateCRC([]byte{OpcodeEOF})
 
-	// Write CRC64 checksum (8 bytes, little endian)
-	crc := e.crc.Sum64()
+	// Write CRC64 checksum (8 bytes, little endian). A zero checksum tells
+	// the loader the file was saved with checksums disabled.
+	var crc uint64
+	if !e.noChecksum {
+		crc = e.crc.Sum64()
+	}
 	bytes := make([]byte, 8)
 	binary.LittleEndian.PutUint64(bytes, crc)
 	if _, err := e.w.Write(bytes); err != nil {
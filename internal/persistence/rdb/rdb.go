@@ -5,17 +5,24 @@
 package rdb
 
 import (
+	"bytes"
 	"fmt"
 	"io"
 	"os"
+	"sync/atomic"
+	"time"
 
 	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/latency"
 )
 
 // RDB manages RDB persistence
 type RDB struct {
 	dirname string
 	dbname  string
+	stats   *SaveStats
+
+	noChecksum atomic.Bool // rdbchecksum no
 }
 
 // NewRDB creates a new RDB manager
@@ -23,9 +30,16 @@ func NewRDB(dirname, dbname string) *RDB {
 	return &RDB{
 		dirname: dirname,
 		dbname:  dbname,
+		stats:   NewSaveStats(),
 	}
 }
 
+// SetChecksum sets whether saved files carry a CRC64 checksum and loaded
+// files have theirs verified
+func (r *RDB) SetChecksum(enabled bool) {
+	r.noChecksum.Store(!enabled)
+}
+
 // Save saves the database to RDB file
 func (r *RDB) Save(dbs []*database.DB) error {
 	// Ensure directory exists
@@ -33,6 +47,11 @@ func (r *RDB) Save(dbs []*database.DB) error {
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	// Only changes counted before the save starts are guaranteed to be in the file
+	dirtyAtStart := r.stats.Dirty()
+	start := time.Now()
+	defer func() { latency.Instance().Add(latency.EventRDBSave, time.Since(start)) }()
+
 	// Open file for writing
 	filename := r.GetFilename()
 	tmpFilename := filename + ".tmp"
@@ -44,21 +63,31 @@ func (r *RDB) Save(dbs []*database.DB) error {
 
 	// Create encoder and encode
 	encoder := NewEncoder(file)
+	encoder.SetChecksum(!r.noChecksum.Load())
 	if err := encoder.Encode(dbs); err != nil {
 		os.Remove(tmpFilename)
 		return fmt.Errorf("failed to encode: %w", err)
 	}
 
+	// Make the snapshot durable before it replaces the previous one
+	if err := file.Sync(); err != nil {
+		os.Remove(tmpFilename)
+		return fmt.Errorf("failed to sync file: %w", err)
+	}
+
 	// Rename to final filename (atomic operation)
 	if err := os.Rename(tmpFilename, filename); err != nil {
 		os.Remove(tmpFilename)
 		return fmt.Errorf("failed to rename file: %w", err)
 	}
 
+	r.stats.SaveCompleted(dirtyAtStart)
 	return nil
 }
 
-// Load loads the database from RDB file
+// Load loads the database from RDB file. The file is decoded in full
+// before any database is touched, so a corrupt or truncated file leaves
+// the databases as they were.
 func (r *RDB) Load(dbs []*database.DB) error {
 	filename := r.GetFilename()
 	file, err := os.Open(filename)
@@ -67,26 +96,82 @@ func (r *RDB) Load(dbs []*database.DB) error {
 	}
 	defer file.Close()
 
-	// Clear all databases first
-	for _, db := range dbs {
-		db.FlushDB()
+	if err := r.LoadFrom(file, dbs); err != nil {
+		return err
 	}
 
-	// Create decoder and decode
-	decoder := NewDecoder(file)
-	if err := decoder.Decode(dbs); err != nil {
+	// The loaded dataset matches the file
+	r.stats.Reset()
+	return nil
+}
+
+// LoadFrom loads the database from an RDB snapshot read from rd, e.g. one
+// sent by a master. Like Load, it leaves the databases as they were if the
+// snapshot is corrupt.
+func (r *RDB) LoadFrom(rd io.Reader, dbs []*database.DB) error {
+	// Create decoder and decode into scratch databases
+	staged := make([]*database.DB, len(dbs))
+	for i := range staged {
+		staged[i] = database.NewDB(i)
+	}
+	decoder := NewDecoder(rd)
+	decoder.SetChecksum(!r.noChecksum.Load())
+	if err := decoder.Decode(staged); err != nil {
 		return fmt.Errorf("failed to decode: %w", err)
 	}
 
+	// Replace the databases with the loaded data
+	for i, db := range dbs {
+		db.FlushDB()
+		moveKeys(db, staged[i])
+	}
 	return nil
 }
 
+// moveKeys copies every key of src, with its expiration, into dst
+func moveKeys(dst, src *database.DB) {
+	dict, expires := src.GetDict(), src.GetExpiresDict()
+	for _, key := range dict.Keys() {
+		obj, ok := dict.Get(key)
+		if !ok {
+			continue
+		}
+		dst.Set(key, obj.(*database.Object))
+		if exp, ok := expires.Get(key); ok {
+			dst.PExpireAt(key, exp.(int64))
+		}
+	}
+}
+
 // SaveTo writes the database to a specific writer
 func (r *RDB) SaveTo(w io.Writer, dbs []*database.DB) error {
 	encoder := NewEncoder(w)
+	encoder.SetChecksum(!r.noChecksum.Load())
 	return encoder.Encode(dbs)
 }
 
+// ReloadDB serializes a single database with the RDB codec and loads it
+// back in place, leaving every other database untouched
+func ReloadDB(db *database.DB) error {
+	var buf bytes.Buffer
+	if err := NewEncoder(&buf).Encode([]*database.DB{db}); err != nil {
+		return fmt.Errorf("failed to encode: %w", err)
+	}
+
+	db.FlushDB()
+
+	if err := NewDecoder(&buf).Decode([]*database.DB{db}); err != nil {
+		return fmt.Errorf("failed to decode: %w", err)
+	}
+
+	return nil
+}
+
+// Stats returns the save stats tracking changes since the last save
+func (r *RDB) Stats() *SaveStats {
+	return r.stats
+}
+
 // GetFilename returns the full path to the RDB file
 func (r *RDB) GetFilename() string {
 	return r.dirname + "/" + r.dbname
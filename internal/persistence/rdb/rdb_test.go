@@ -0,0 +1,197 @@
+package rdb
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/database"
+	"github.com/zyhnesmr/godis/internal/datastruct/stream"
+)
+
+// saveSample writes an RDB file holding a few keys and returns its bytes
+func saveSample(t *testing.T, r *RDB) []byte {
+	t.Helper()
+
+	db := database.NewDB(0)
+	db.Set("a", database.NewStringObject("1"))
+	db.Set("b", database.NewStringObject("2"))
+	db.Set("ttl", database.NewStringObject("3"))
+	db.ExpireAt("ttl", time.Now().Add(time.Hour).Unix())
+	if err := r.Save([]*database.DB{db}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	data, err := os.ReadFile(r.GetFilename())
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+	return data
+}
+
+func TestLoadRoundTrip(t *testing.T) {
+	r := NewRDB(t.TempDir(), "dump.rdb")
+	data := saveSample(t, r)
+
+	if got := string(data[:9]); got != "REDIS0009" {
+		t.Errorf("expected header %q, got %q", "REDIS0009", got)
+	}
+
+	db := database.NewDB(0)
+	if err := r.Load([]*database.DB{db}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if db.DBSize() != 3 {
+		t.Errorf("expected 3 keys, got %d", db.DBSize())
+	}
+	if ttl := db.TTL("ttl"); ttl <= 0 {
+		t.Errorf("expected a TTL on key ttl, got %d", ttl)
+	}
+}
+
+func TestLoadRejectsBadFiles(t *testing.T) {
+	r := NewRDB(t.TempDir(), "dump.rdb")
+	data := saveSample(t, r)
+
+	// Change a value, and separately the stored checksum
+	corrupt := append([]byte(nil), data...)
+	corrupt[bytes.LastIndexByte(data[:len(data)-9], '2')] = '9'
+	badCRC := append([]byte(nil), data...)
+	badCRC[len(badCRC)-1] ^= 0xff
+
+	tests := []struct {
+		name string
+		data []byte
+		want error
+	}{
+		{"empty", nil, ErrTruncated},
+		{"truncated", data[:len(data)-4], ErrTruncated},
+		{"bad magic", append([]byte("RADIS"), data[5:]...), ErrBadHeader},
+		{"future version", append([]byte("REDIS0099"), data[9:]...), ErrBadHeader},
+		{"corrupted value", corrupt, ErrChecksumMismatch},
+		{"corrupted checksum", badCRC, ErrChecksumMismatch},
+	}
+
+	for _, tt := range tests {
+		if err := os.WriteFile(r.GetFilename(), tt.data, 0644); err != nil {
+			t.Fatalf("WriteFile failed: %v", err)
+		}
+
+		db := database.NewDB(0)
+		db.Set("existing", database.NewStringObject("kept"))
+		err := r.Load([]*database.DB{db})
+		if !errors.Is(err, tt.want) {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, err)
+		}
+		// A failed load leaves the database untouched
+		if db.DBSize() != 1 || db.Exists("existing") != 1 {
+			t.Errorf("%s: expected the database to be untouched, got %v", tt.name, db.Keys("*"))
+		}
+	}
+}
+
+func TestLoadZeroChecksum(t *testing.T) {
+	r := NewRDB(t.TempDir(), "dump.rdb")
+	r.SetChecksum(false)
+	data := saveSample(t, r)
+
+	for _, b := range data[len(data)-8:] {
+		if b != 0 {
+			t.Fatalf("expected a zero checksum with checksums disabled, got %x", data[len(data)-8:])
+		}
+	}
+
+	// A zero checksum loads even when verification is on
+	r.SetChecksum(true)
+	db := database.NewDB(0)
+	if err := r.Load([]*database.DB{db}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+	if db.DBSize() != 3 {
+		t.Errorf("expected 3 keys, got %d", db.DBSize())
+	}
+}
+
+func TestStreamAndTTLRoundTrip(t *testing.T) {
+	db := database.NewDB(0)
+
+	obj := database.NewStreamObject()
+	strm := obj.Ptr.(*stream.Stream)
+	for i := int64(1); i <= 3; i++ {
+		if err := strm.AddWithID(stream.NewStreamID(i, 0), map[string]string{"n": strconv.FormatInt(i, 10)}); err != nil {
+			t.Fatalf("AddWithID failed: %v", err)
+		}
+	}
+	strm.DeleteByID([]stream.StreamID{stream.NewStreamID(1, 0)})
+
+	cgroups := strm.GetConsumerGroupManager()
+	cgroups.CreateGroup("g", stream.NewStreamID(0, 0))
+	group, _ := cgroups.GetGroup("g")
+	group.SetLastID(stream.NewStreamID(2, 0))
+	group.SetEntriesRead(2)
+	group.CreateConsumer("idle")
+	group.AddPendingID("alice", stream.NewStreamID(2, 0), 12345)
+	group.AddPendingID("alice", stream.NewStreamID(2, 0), 23456)
+	cgroups.CreateGroup("fresh", stream.NewStreamID(0, 0))
+	db.Set("s", obj)
+
+	db.Set("ttl", database.NewStringObject("v"))
+	// Expirations are kept to the millisecond
+	expireAt := time.Now().Add(time.Hour).UnixMilli() + 123
+	db.PExpireAt("ttl", expireAt)
+	db.Set("expired", database.NewStringObject("v"))
+	db.ExpireAt("expired", time.Now().Add(-time.Second).Unix())
+
+	r := NewRDB(t.TempDir(), "dump.rdb")
+	if err := r.Save([]*database.DB{db}); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded := database.NewDB(0)
+	if err := r.Load([]*database.DB{loaded}); err != nil {
+		t.Fatalf("Load failed: %v", err)
+	}
+
+	if got, ok := loaded.PExpireTime("ttl"); !ok || got != expireAt {
+		t.Errorf("expected ttl to expire at %d, got %d (%v)", expireAt, got, ok)
+	}
+	if loaded.GetDict().Exists("expired") {
+		t.Error("expected the expired key to be skipped")
+	}
+
+	sobj, ok := loaded.Get("s")
+	if !ok {
+		t.Fatal("stream missing after load")
+	}
+	got := sobj.Ptr.(*stream.Stream)
+	if got.Length() != 2 || got.EntriesAdded() != 3 || got.MaxDeletedID() != stream.NewStreamID(1, 0) {
+		t.Errorf("stream metadata mismatch: length=%d entries-added=%d max-deleted=%s",
+			got.Length(), got.EntriesAdded(), got.MaxDeletedID())
+	}
+	if v, _ := got.FindByID(stream.NewStreamID(3, 0)).GetField("n"); v != "3" {
+		t.Errorf("expected entry 3-0 field n=3, got %q", v)
+	}
+
+	g, ok := got.GetConsumerGroupManager().GetGroup("g")
+	if !ok {
+		t.Fatal("group g missing after load")
+	}
+	if g.GetLastID() != stream.NewStreamID(2, 0) || g.GetEntriesRead() != 2 {
+		t.Errorf("group g: last-id=%s entries-read=%d", g.GetLastID(), g.GetEntriesRead())
+	}
+	if len(g.GetConsumers()) != 2 {
+		t.Errorf("group g: expected 2 consumers, got %d", len(g.GetConsumers()))
+	}
+	pending := g.GetPending()
+	want := stream.PendingEntry{ID: stream.NewStreamID(2, 0), Consumer: "alice", DeliveryTime: 23456, DeliveryCount: 2}
+	if len(pending) != 1 || pending[0] != want {
+		t.Errorf("group g: expected pending %+v, got %+v", want, pending)
+	}
+
+	fresh, ok := got.GetConsumerGroupManager().GetGroup("fresh")
+	if !ok || fresh.GetEntriesRead() != -1 {
+		t.Errorf("group fresh: expected unknown entries-read after load")
+	}
+}
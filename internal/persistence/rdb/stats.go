@@ -0,0 +1,52 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rdb
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// SaveStats tracks the changes made since the last successful save and
+// when that save happened, for the save rules and LASTSAVE
+type SaveStats struct {
+	dirty    atomic.Int64
+	lastSave atomic.Int64 // Unix time
+}
+
+// NewSaveStats creates save stats with the last save time set to now
+func NewSaveStats() *SaveStats {
+	s := &SaveStats{}
+	s.lastSave.Store(time.Now().Unix())
+	return s
+}
+
+// AddChanges counts n changes to the dataset, one per write command that
+// changed something
+func (s *SaveStats) AddChanges(n int64) {
+	s.dirty.Add(n)
+}
+
+// Dirty returns the number of changes since the last successful save
+func (s *SaveStats) Dirty() int64 {
+	return s.dirty.Load()
+}
+
+// LastSave returns the time of the last successful save
+func (s *SaveStats) LastSave() time.Time {
+	return time.Unix(s.lastSave.Load(), 0)
+}
+
+// SaveCompleted records a successful save. Changes counted after the save
+// started (dirtyAtStart) are kept, as they are not in the saved file.
+func (s *SaveStats) SaveCompleted(dirtyAtStart int64) {
+	s.dirty.Add(-dirtyAtStart)
+	s.lastSave.Store(time.Now().Unix())
+}
+
+// Reset clears the change counter, e.g. after loading a dataset from disk
+func (s *SaveStats) Reset() {
+	s.dirty.Store(0)
+}
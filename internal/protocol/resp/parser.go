@@ -10,6 +10,7 @@ import (
 	"fmt"
 	"io"
 	"strconv"
+	"strings"
 )
 
 var (
@@ -19,10 +20,13 @@ var (
 	ErrIncomplete       = errors.New("incomplete message")
 	ErrCRLFExpected     = errors.New("CRLF expected")
 	ErrBulkStringTooBig = errors.New("bulk string too big")
+	ErrUnbalancedQuotes = errors.New("unbalanced quotes in request")
+	ErrInlineTooBig     = errors.New("too big inline request")
 )
 
 const (
 	maxBulkStringSize = 512 * 1024 * 1024 // 512MB
+	maxInlineSize     = 64 * 1024         // 64KB, including the newline
 )
 
 // Parser parses RESP protocol messages
@@ -149,6 +153,161 @@ func (p *Parser) Parse() (*Message, error) {
 	}
 }
 
+// ReadCommand reads a single client request. Requests normally arrive as
+// RESP arrays, but a line that does not start with '*' is an inline
+// command, as typed into telnet: it is split into arguments on whitespace,
+// honouring quotes, and returned as an array of bulk strings. Empty inline
+// lines are skipped.
+func (p *Parser) ReadCommand() (*Message, error) {
+	for {
+		b, err := p.reader.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if Type(b[0]) == TypeArray {
+			return p.Parse()
+		}
+
+		// Inline commands may end with a bare \n
+		line, err := p.readInlineLine()
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimSuffix(line[:len(line)-1], "\r")
+
+		args, err := SplitInlineArgs(line)
+		if err != nil {
+			return nil, err
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		items := make([]*Message, len(args))
+		for i, arg := range args {
+			items[i] = NewBulkString([]byte(arg))
+		}
+		return NewArray(items), nil
+	}
+}
+
+// readInlineLine reads an inline command up to and including its \n. It
+// fails with ErrInlineTooBig once the line grows past maxInlineSize, so a
+// client can't make the server buffer an endless line.
+func (p *Parser) readInlineLine() (string, error) {
+	var line []byte
+	for {
+		chunk, err := p.reader.ReadSlice('\n')
+		if len(line)+len(chunk) > maxInlineSize {
+			return "", ErrInlineTooBig
+		}
+		line = append(line, chunk...)
+		if err == nil {
+			return string(line), nil
+		}
+		if !errors.Is(err, bufio.ErrBufferFull) {
+			return "", err
+		}
+	}
+}
+
+// SplitInlineArgs splits an inline command line into arguments, like
+// Redis' sdssplitargs. Arguments are separated by whitespace and may be
+// quoted: double quotes support the escapes \n, \r, \t, \b, \a, \\, \"
+// and \xHH, single quotes only \'. A closing quote must be followed by
+// whitespace or the end of the line.
+func SplitInlineArgs(line string) ([]string, error) {
+	var args []string
+	i := 0
+	for {
+		for i < len(line) && isInlineSpace(line[i]) {
+			i++
+		}
+		if i == len(line) {
+			return args, nil
+		}
+
+		var arg []byte
+		inDouble, inSingle := false, false
+		for done := false; !done; {
+			if i == len(line) {
+				if inDouble || inSingle {
+					return nil, ErrUnbalancedQuotes
+				}
+				break
+			}
+			c := line[i]
+			switch {
+			case inDouble:
+				if c == '\\' && i+3 < len(line) && line[i+1] == 'x' && isHexDigit(line[i+2]) && isHexDigit(line[i+3]) {
+					v, _ := strconv.ParseUint(line[i+2:i+4], 16, 8)
+					arg = append(arg, byte(v))
+					i += 3
+				} else if c == '\\' && i+1 < len(line) {
+					i++
+					switch line[i] {
+					case 'n':
+						arg = append(arg, '\n')
+					case 'r':
+						arg = append(arg, '\r')
+					case 't':
+						arg = append(arg, '\t')
+					case 'b':
+						arg = append(arg, '\b')
+					case 'a':
+						arg = append(arg, '\a')
+					default:
+						arg = append(arg, line[i])
+					}
+				} else if c == '"' {
+					// The closing quote must be followed by a space
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			case inSingle:
+				if c == '\\' && i+1 < len(line) && line[i+1] == '\'' {
+					i++
+					arg = append(arg, '\'')
+				} else if c == '\'' {
+					if i+1 < len(line) && !isInlineSpace(line[i+1]) {
+						return nil, ErrUnbalancedQuotes
+					}
+					done = true
+				} else {
+					arg = append(arg, c)
+				}
+			default:
+				switch c {
+				case ' ', '\t', '\r', '\n':
+					done = true
+				case '"':
+					inDouble = true
+				case '\'':
+					inSingle = true
+				default:
+					arg = append(arg, c)
+				}
+			}
+			i++
+		}
+		args = append(args, string(arg))
+	}
+}
+
+// isInlineSpace returns true if c separates inline command arguments
+func isInlineSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// isHexDigit returns true if c is a hexadecimal digit
+func isHexDigit(c byte) bool {
+	return (c >= '0' && c <= '9') || (c >= 'a' && c <= 'f') || (c >= 'A' && c <= 'F')
+}
+
 // ParseCommand parses a RESP array as a command
 // Returns the command name and arguments
 func (m *Message) ParseCommand() (string, []string, error) {
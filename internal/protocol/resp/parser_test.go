@@ -0,0 +1,83 @@
+package resp
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSplitInlineArgs(t *testing.T) {
+	tests := []struct {
+		line string
+		want []string
+	}{
+		{"PING", []string{"PING"}},
+		{"  set  key   value ", []string{"set", "key", "value"}},
+		{`set key "hello world"`, []string{"set", "key", "hello world"}},
+		{`set key "a\tb\x41\"c"`, []string{"set", "key", "a\tbA\"c"}},
+		{`set key 'it\'s "raw"\n'`, []string{"set", "key", `it's "raw"\n`}},
+		{`set key ""`, []string{"set", "key", ""}},
+		{"", nil},
+	}
+	for _, tt := range tests {
+		got, err := SplitInlineArgs(tt.line)
+		if err != nil {
+			t.Errorf("SplitInlineArgs(%q) failed: %v", tt.line, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("SplitInlineArgs(%q) = %q, want %q", tt.line, got, tt.want)
+		}
+	}
+
+	for _, line := range []string{`set key "open`, `set key 'open`, `set key "a"b`} {
+		if _, err := SplitInlineArgs(line); !errors.Is(err, ErrUnbalancedQuotes) {
+			t.Errorf("SplitInlineArgs(%q) error = %v, want %v", line, err, ErrUnbalancedQuotes)
+		}
+	}
+}
+
+func TestReadCommandInlineAndMultibulk(t *testing.T) {
+	input := "PING\r\n\r\n*2\r\n$4\r\nECHO\r\n$2\r\nhi\r\nget \"my key\"\n"
+	p := NewParser(strings.NewReader(input))
+
+	want := [][]string{{"PING"}, {"ECHO", "hi"}, {"get", "my key"}}
+	for _, w := range want {
+		msg, err := p.ReadCommand()
+		if err != nil {
+			t.Fatalf("ReadCommand failed: %v", err)
+		}
+		cmd, args, err := msg.ParseCommand()
+		if err != nil {
+			t.Fatalf("ParseCommand failed: %v", err)
+		}
+		if got := append([]string{cmd}, args...); !reflect.DeepEqual(got, w) {
+			t.Errorf("ReadCommand = %q, want %q", got, w)
+		}
+	}
+}
+
+func TestReadCommandRejectsTooBigInline(t *testing.T) {
+	// A line of exactly the limit is still accepted
+	fits := "SET k " + strings.Repeat("v", maxInlineSize-len("SET k \r\n")) + "\r\n"
+	p := NewParser(strings.NewReader(fits + "PING\r\n"))
+	msg, err := p.ReadCommand()
+	if err != nil {
+		t.Fatalf("ReadCommand of a %d byte inline request failed: %v", len(fits), err)
+	}
+	if _, args, _ := msg.ParseCommand(); len(args) != 2 || len(args[1]) != maxInlineSize-len("SET k \r\n") {
+		t.Errorf("ReadCommand returned %d args", len(args))
+	}
+
+	// One byte more fails, even before the newline arrives
+	for _, input := range []string{
+		"SET k " + strings.Repeat("v", maxInlineSize-len("SET k \r\n")+1) + "\r\n",
+		strings.Repeat("x", 1024*1024),
+	} {
+		p := NewParser(strings.NewReader(input))
+		if _, err := p.ReadCommand(); !errors.Is(err, ErrInlineTooBig) {
+			t.Errorf("ReadCommand of %d bytes error = %v, want %v", len(input), err, ErrInlineTooBig)
+		}
+	}
+}
@@ -7,6 +7,7 @@ package resp
 import (
 	"fmt"
 	"io"
+	"math"
 	"strconv"
 )
 
@@ -294,6 +295,33 @@ func BuildNil() []byte {
 	return []byte("$-1\r\n")
 }
 
+// BuildNull creates a RESP3 null response
+func BuildNull() []byte {
+	return []byte("_\r\n")
+}
+
+// BuildDouble creates a RESP3 double response
+func BuildDouble(f float64) []byte {
+	return []byte("," + FormatDouble(f) + "\r\n")
+}
+
+// BuildBigNumber creates a RESP3 big number response from its decimal digits
+func BuildBigNumber(digits string) []byte {
+	return []byte("(" + digits + "\r\n")
+}
+
+// FormatDouble formats a float the way Redis replies with scores, using
+// inf and -inf for infinities
+func FormatDouble(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "inf"
+	case math.IsInf(f, -1):
+		return "-inf"
+	}
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
 // BuildZero creates a zero integer response
 func BuildZero() []byte {
 	return []byte(":0\r\n")
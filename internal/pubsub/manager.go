@@ -5,20 +5,22 @@
 package pubsub
 
 import (
-	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
 
 	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/pkg/utils"
 )
 
 // Manager manages publish/subscribe subscriptions
 type Manager struct {
-	mu           sync.RWMutex
-	channels     map[string]*channelSubscribers    // channel -> subscribers
-	patternConns map[string]map[*net.Conn]struct{} // pattern -> connections
-	connPatterns map[*net.Conn]map[string]struct{} // connection -> patterns
+	mu            sync.RWMutex
+	channels      map[string]*channelSubscribers    // channel -> subscribers
+	patternConns  map[string]map[*net.Conn]struct{} // pattern -> connections
+	connPatterns  map[*net.Conn]map[string]struct{} // connection -> patterns
+	shardChannels map[string]*channelSubscribers    // shard channel -> subscribers
 }
 
 // channelSubscribers manages subscribers for a single channel
@@ -37,9 +39,10 @@ func newChannelSubscribers() *channelSubscribers {
 // NewManager creates a new pubsub manager
 func NewManager() *Manager {
 	return &Manager{
-		channels:     make(map[string]*channelSubscribers),
-		patternConns: make(map[string]map[*net.Conn]struct{}),
-		connPatterns: make(map[*net.Conn]map[string]struct{}),
+		channels:      make(map[string]*channelSubscribers),
+		patternConns:  make(map[string]map[*net.Conn]struct{}),
+		connPatterns:  make(map[*net.Conn]map[string]struct{}),
+		shardChannels: make(map[string]*channelSubscribers),
 	}
 }
 
@@ -141,6 +144,49 @@ func (m *Manager) punsubscribe(conn *net.Conn, pattern string) {
 	conn.PUnsubscribe(pattern)
 }
 
+// SSubscribe adds a connection to a shard channel's subscribers
+func (m *Manager) SSubscribe(conn *net.Conn, channels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, ch := range channels {
+		if m.shardChannels[ch] == nil {
+			m.shardChannels[ch] = newChannelSubscribers()
+		}
+		m.shardChannels[ch].add(conn)
+		conn.SSubscribe(ch)
+	}
+}
+
+// SUnsubscribe removes a connection from shard channel subscribers, from
+// all of its shard channels if none are given
+func (m *Manager) SUnsubscribe(conn *net.Conn, channels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(channels) == 0 {
+		for channel := range conn.GetShardSubscriptions() {
+			m.sunsubscribe(conn, channel)
+		}
+		return
+	}
+
+	for _, channel := range channels {
+		m.sunsubscribe(conn, channel)
+	}
+}
+
+// sunsubscribe removes a connection from a specific shard channel
+func (m *Manager) sunsubscribe(conn *net.Conn, channel string) {
+	if subs, ok := m.shardChannels[channel]; ok {
+		subs.remove(conn)
+		if subs.isEmpty() {
+			delete(m.shardChannels, channel)
+		}
+	}
+	conn.SUnsubscribe(channel)
+}
+
 // Publish sends a message to all subscribers of a channel
 // Returns the number of subscribers that received the message
 func (m *Manager) Publish(channel string, message []byte) int {
@@ -148,10 +194,34 @@ func (m *Manager) Publish(channel string, message []byte) int {
 	subs, ok := m.channels[channel]
 	m.mu.RUnlock()
 
+	count := 0
+	if ok {
+		count = m.publishToSubscribers(subs, "message", channel, message)
+	}
+
+	// Also publish to matching pattern subscriptions
+	m.publishToPatterns(channel, message)
+
+	return count
+}
+
+// SPublish sends a message to all subscribers of a shard channel. Pattern
+// subscriptions never match shard channels. Returns the number of
+// subscribers that received the message.
+func (m *Manager) SPublish(channel string, message []byte) int {
+	m.mu.RLock()
+	subs, ok := m.shardChannels[channel]
+	m.mu.RUnlock()
+
 	if !ok {
 		return 0
 	}
+	return m.publishToSubscribers(subs, "smessage", channel, message)
+}
 
+// publishToSubscribers sends a message of the given kind to every
+// subscriber of a channel and returns how many received it
+func (m *Manager) publishToSubscribers(subs *channelSubscribers, kind, channel string, message []byte) int {
 	// Get list of subscribers to notify
 	subs.mu.RLock()
 	conns := make([]*net.Conn, 0, len(subs.subscribers))
@@ -164,25 +234,25 @@ func (m *Manager) Publish(channel string, message []byte) int {
 	count := 0
 	for _, conn := range conns {
 		if !conn.IsClosed() {
-			if m.publishToConn(conn, channel, message) {
+			if m.publishToConn(conn, kind, channel, message) {
 				count++
 			}
 		}
 	}
-
-	// Also publish to matching pattern subscriptions
-	m.publishToPatterns(channel, message)
-
 	return count
 }
 
 // publishToConn sends a message to a single connection
-func (m *Manager) publishToConn(conn *net.Conn, channel string, message []byte) bool {
-	// Build the message array: ["message", "channel", "payload"]
+func (m *Manager) publishToConn(conn *net.Conn, kind, channel string, message []byte) bool {
+	// Build the message array: [kind, "channel", "payload"]
 	// Use strings.Builder for efficiency
 	var builder strings.Builder
 	builder.WriteString("*3\r\n")
-	builder.WriteString("$7\r\nmessage\r\n")
+	builder.WriteString("$")
+	builder.WriteString(strconv.Itoa(len(kind)))
+	builder.WriteString("\r\n")
+	builder.WriteString(kind)
+	builder.WriteString("\r\n")
 	builder.WriteString("$")
 	builder.WriteString(strconv.Itoa(len(channel)))
 	builder.WriteString("\r\n")
@@ -194,30 +264,34 @@ func (m *Manager) publishToConn(conn *net.Conn, channel string, message []byte)
 	builder.Write(message)
 	builder.WriteString("\r\n")
 
-	data := builder.String()
-
-	err := conn.WriteRESP([]byte(data))
-	if err != nil {
-		return false
-	}
-	return conn.Flush() == nil
+	return conn.WriteAndFlush([]byte(builder.String())) == nil
 }
 
 // publishToPatterns sends a message to matching pattern subscriptions
 func (m *Manager) publishToPatterns(channel string, message []byte) {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+	type delivery struct {
+		conn    *net.Conn
+		pattern string
+	}
 
-	// Find matching patterns and send to subscribed connections
+	// Find matching patterns and their connections, then send without the
+	// lock so that a subscriber slow to read does not block subscribing
+	var deliveries []delivery
+	m.mu.RLock()
 	for pattern, conns := range m.patternConns {
 		if matchPattern(pattern, channel) {
 			for conn := range conns {
-				if !conn.IsClosed() {
-					_ = m.PublishToPattern(conn, pattern, channel, message)
-				}
+				deliveries = append(deliveries, delivery{conn, pattern})
 			}
 		}
 	}
+	m.mu.RUnlock()
+
+	for _, d := range deliveries {
+		if !d.conn.IsClosed() {
+			_ = m.PublishToPattern(d.conn, d.pattern, channel, message)
+		}
+	}
 }
 
 // PublishToPattern sends a message to a specific pattern subscriber
@@ -242,21 +316,30 @@ func (m *Manager) PublishToPattern(conn *net.Conn, pattern, channel string, mess
 	builder.Write(message)
 	builder.WriteString("\r\n")
 
-	err := conn.WriteRESP([]byte(builder.String()))
-	if err != nil {
-		return err
-	}
-	return conn.Flush()
+	return conn.WriteAndFlush([]byte(builder.String()))
 }
 
 // NumSubscribers returns the number of subscribers for the given channels
 func (m *Manager) NumSubscribers(channels ...string) map[string]int {
 	m.mu.RLock()
 	defer m.mu.RUnlock()
+	return countSubscribers(m.channels, channels)
+}
+
+// NumShardSubscribers returns the number of subscribers for the given
+// shard channels
+func (m *Manager) NumShardSubscribers(channels ...string) map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return countSubscribers(m.shardChannels, channels)
+}
 
+// countSubscribers returns the number of subscribers of each channel in
+// the given namespace (with m.mu held)
+func countSubscribers(namespace map[string]*channelSubscribers, channels []string) map[string]int {
 	result := make(map[string]int)
 	for _, channel := range channels {
-		if subs, ok := m.channels[channel]; ok {
+		if subs, ok := namespace[channel]; ok {
 			subs.mu.RLock()
 			result[channel] = len(subs.subscribers)
 			subs.mu.RUnlock()
@@ -293,6 +376,35 @@ func (m *Manager) ListChannels() []string {
 	return channels
 }
 
+// ListChannelsMatching returns the active channels matching the glob
+// pattern, sorted
+func (m *Manager) ListChannelsMatching(pattern string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return channelsMatching(m.channels, pattern)
+}
+
+// ListShardChannelsMatching returns the active shard channels matching the
+// glob pattern, sorted
+func (m *Manager) ListShardChannelsMatching(pattern string) []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return channelsMatching(m.shardChannels, pattern)
+}
+
+// channelsMatching returns the channels of the given namespace matching
+// the glob pattern, sorted (with m.mu held)
+func channelsMatching(namespace map[string]*channelSubscribers, pattern string) []string {
+	channels := make([]string, 0)
+	for channel := range namespace {
+		if matchPattern(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	sort.Strings(channels)
+	return channels
+}
+
 // RemoveConn removes a connection from all subscriptions (called when connection closes)
 func (m *Manager) RemoveConn(conn *net.Conn) {
 	m.mu.Lock()
@@ -306,6 +418,14 @@ func (m *Manager) RemoveConn(conn *net.Conn) {
 		}
 	}
 
+	// Remove from all shard channels
+	for channel, subs := range m.shardChannels {
+		subs.remove(conn)
+		if subs.isEmpty() {
+			delete(m.shardChannels, channel)
+		}
+	}
+
 	// Remove from all patterns
 	for pattern, conns := range m.patternConns {
 		delete(conns, conn)
@@ -339,10 +459,7 @@ func (c *channelSubscribers) isEmpty() bool {
 
 // matchPattern checks if a channel matches a glob pattern
 func matchPattern(pattern, channel string) bool {
-	// Handle glob-style patterns: *, ?, [], etc.
-	// For simplicity, we'll use filepath.Match which supports similar patterns
-	matched, _ := filepath.Match(pattern, channel)
-	return matched
+	return utils.GlobMatch(pattern, channel)
 }
 
 // GetConnSubscriptions returns the channels a connection is subscribed to
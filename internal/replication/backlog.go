@@ -0,0 +1,96 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package replication
+
+// DefaultBacklogSize is the default of repl-backlog-size
+const DefaultBacklogSize = 1 << 20
+
+// ReplicationBacklog keeps the latest bytes of the replication stream in a
+// ring buffer, so that a replica that lost its connection can resume from
+// its offset instead of syncing the whole dataset again.
+//
+// Offsets count the bytes of the stream: a replica that applied offset
+// bytes needs the stream from byte offset on. The backlog is not safe for
+// concurrent use; the Manager guards it.
+type ReplicationBacklog struct {
+	buf    []byte
+	start  int   // Index in buf of the oldest byte
+	length int   // Bytes held
+	offset int64 // Offset of the stream after the newest byte
+}
+
+// NewReplicationBacklog creates a backlog of size bytes for a stream
+// currently at offset
+func NewReplicationBacklog(size int, offset int64) *ReplicationBacklog {
+	return &ReplicationBacklog{
+		buf:    make([]byte, max(size, 1)),
+		offset: offset,
+	}
+}
+
+// Write appends data to the backlog, dropping the oldest bytes that no
+// longer fit
+func (b *ReplicationBacklog) Write(data []byte) {
+	b.offset += int64(len(data))
+	if len(data) >= len(b.buf) {
+		data = data[len(data)-len(b.buf):]
+		copy(b.buf, data)
+		b.start, b.length = 0, len(b.buf)
+		return
+	}
+
+	end := (b.start + b.length) % len(b.buf)
+	n := copy(b.buf[end:], data)
+	copy(b.buf, data[n:])
+	b.length += len(data)
+	if b.length > len(b.buf) {
+		b.start = (b.start + b.length - len(b.buf)) % len(b.buf)
+		b.length = len(b.buf)
+	}
+}
+
+// Offset returns the offset of the stream after the newest byte
+func (b *ReplicationBacklog) Offset() int64 {
+	return b.offset
+}
+
+// Size returns the most bytes the backlog holds
+func (b *ReplicationBacklog) Size() int {
+	return len(b.buf)
+}
+
+// Len returns the bytes the backlog holds
+func (b *ReplicationBacklog) Len() int {
+	return b.length
+}
+
+// Since returns a copy of the stream from offset to the newest byte. It
+// fails if those bytes are no longer, or not yet, in the backlog.
+func (b *ReplicationBacklog) Since(offset int64) ([]byte, bool) {
+	if offset < b.offset-int64(b.length) || offset > b.offset {
+		return nil, false
+	}
+
+	n := int(b.offset - offset)
+	result := make([]byte, n)
+	from := (b.start + b.length - n) % len(b.buf)
+	copied := copy(result, b.buf[from:])
+	copy(result[copied:], b.buf)
+	return result, true
+}
+
+// Resize changes the most bytes the backlog holds, keeping the newest ones
+func (b *ReplicationBacklog) Resize(size int) {
+	size = max(size, 1)
+	if size == len(b.buf) {
+		return
+	}
+
+	kept := min(b.length, size)
+	data, _ := b.Since(b.offset - int64(kept))
+	b.buf = make([]byte, size)
+	copy(b.buf, data)
+	b.start, b.length = 0, kept
+}
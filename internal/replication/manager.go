@@ -0,0 +1,183 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package replication implements master-replica replication. A replica
+// connects to its master, receives an RDB snapshot of the dataset (a full
+// sync), then applies the stream of write commands the master executes.
+package replication
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"io"
+	"sync"
+)
+
+// Role represents the replication role of the server
+type Role int
+
+const (
+	RoleMaster Role = iota
+	RoleSlave
+)
+
+// String returns the string representation of the role
+func (r Role) String() string {
+	switch r {
+	case RoleMaster:
+		return "master"
+	case RoleSlave:
+		return "slave"
+	default:
+		return "unknown"
+	}
+}
+
+// Dataset is the data replication keeps in sync. A master saves it for a
+// new replica; a replica loads the master's snapshot, then applies the
+// master's write commands.
+type Dataset interface {
+	// Save writes an RDB snapshot of every database to w
+	Save(w io.Writer) error
+
+	// Load replaces every database with the RDB snapshot read from r
+	Load(r io.Reader) error
+
+	// Apply executes a write command received from the master on database db
+	Apply(db int, cmdName string, args []string) error
+}
+
+// Manager holds the replication state of the server
+type Manager struct {
+	mu         sync.RWMutex
+	role       Role
+	masterHost string
+	masterPort int
+	dataset    Dataset
+
+	replID   string // ID of the replication stream served or followed
+	offset   int64  // Bytes of the replication stream sent or applied
+	seldb    int    // Database selected in the stream to replicas, -1 if none
+	replicas map[*replica]struct{}
+
+	backlog     *ReplicationBacklog // Created when the first replica connects
+	backlogSize int
+
+	streamDB int         // Database selected in the stream from the master
+	link     *masterLink // Connection to the master while a replica
+
+	writeBarrier func(func()) // Runs a function while no write command runs
+}
+
+// NewManager creates a new replication manager (starts as master)
+func NewManager() *Manager {
+	return &Manager{
+		role:        RoleMaster,
+		replID:      newReplID(),
+		seldb:       -1,
+		replicas:    make(map[*replica]struct{}),
+		backlogSize: DefaultBacklogSize,
+	}
+}
+
+// newReplID returns a random 40 character replication ID
+func newReplID() string {
+	b := make([]byte, 20)
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// SetDataset sets the data the manager syncs
+func (m *Manager) SetDataset(ds Dataset) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dataset = ds
+}
+
+// SetWriteBarrier sets the function running its argument while no write
+// command runs, so that the snapshot of a full sync is taken at a point in
+// the replication stream
+func (m *Manager) SetWriteBarrier(barrier func(func())) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.writeBarrier = barrier
+}
+
+// Role returns the current replication role
+func (m *Manager) Role() Role {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.role
+}
+
+// SetMaster turns the server into a replica of the given master and starts
+// syncing with it in the background
+func (m *Manager) SetMaster(host string, port int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.link != nil {
+		m.link.stop()
+	}
+
+	m.role = RoleSlave
+	m.masterHost = host
+	m.masterPort = port
+	m.link = newMasterLink(host, port)
+	go m.runLink(m.link)
+}
+
+// SetNoOne turns the server back into a master
+func (m *Manager) SetNoOne() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.link != nil {
+		m.link.stop()
+		m.link = nil
+	}
+
+	m.role = RoleMaster
+	m.masterHost = ""
+	m.masterPort = 0
+
+	// Writes to this server start a history of its own: a replica must not
+	// resume from it as if it were the old master's stream
+	m.replID = newReplID()
+}
+
+// MasterAddr returns the address of the master (empty if not a replica)
+func (m *Manager) MasterAddr() (string, int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.masterHost, m.masterPort
+}
+
+// ReplID returns the ID of the replication stream served or followed
+func (m *Manager) ReplID() string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.replID
+}
+
+// Offset returns the bytes of the replication stream sent or applied
+func (m *Manager) Offset() int64 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.offset
+}
+
+// LinkStatus returns whether the connection to the master is up, and
+// whether a full sync is in progress. Both are false for a master.
+func (m *Manager) LinkStatus() (up, syncing bool) {
+	m.mu.RLock()
+	link := m.link
+	m.mu.RUnlock()
+
+	if link == nil {
+		return false, false
+	}
+	state := link.getState()
+	return state == linkConnected, state == linkSyncing
+}
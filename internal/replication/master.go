@@ -0,0 +1,230 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/internal/protocol/resp"
+	"github.com/zyhnesmr/godis/pkg/log"
+)
+
+// replicaQueueLen is the number of writes queued for a replica that has
+// not sent the previous ones yet, e.g. during its full sync. A replica
+// falling further behind is disconnected and has to sync again.
+const replicaQueueLen = 16 * 1024
+
+// replica is a replica connected to this server
+type replica struct {
+	conn *net.Conn
+	out  chan []byte // Replication stream not yet written to conn
+}
+
+func newReplica(conn *net.Conn) *replica {
+	return &replica{conn: conn, out: make(chan []byte, replicaQueueLen)}
+}
+
+// Sync serves a replica that sent PSYNC replID offset on conn, offset
+// being the position (from 1) of the first byte of the stream it needs.
+// If this server serves replID and its backlog still holds the stream from
+// there, the replica resumes (a partial resync); otherwise it gets a full
+// sync. SYNC is served as PSYNC ? -1.
+func (m *Manager) Sync(conn *net.Conn, replID string, offset int64) error {
+	if m.partialSync(conn, replID, offset-1) {
+		return nil
+	}
+	return m.fullSync(conn)
+}
+
+// partialSync resumes the stream of a replica that applied offset bytes of
+// it, if the backlog allows
+func (m *Manager) partialSync(conn *net.Conn, replID string, offset int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.backlog == nil || replID != m.replID {
+		return false
+	}
+	data, ok := m.backlog.Since(offset)
+	if !ok {
+		return false
+	}
+
+	// The missed stream is queued first, ahead of any later write
+	r := newReplica(conn)
+	r.out <- append([]byte("+CONTINUE\r\n"), data...)
+	m.replicas[r] = struct{}{}
+	conn.AddFlag(net.FlagSlave)
+	log.Info("Replica %s resumed at offset %d, sending %d bytes of backlog", conn.RemoteAddr(), offset, len(data))
+	go m.sendStream(r)
+	return true
+}
+
+// fullSync sends the dataset to a replica that connected on conn, then
+// registers it to receive the replication stream. The snapshot is taken
+// and the replica registered while writes are paused, so each write is
+// either in the snapshot or queued to follow it, never both.
+func (m *Manager) fullSync(conn *net.Conn) error {
+	m.mu.RLock()
+	ds, barrier := m.dataset, m.writeBarrier
+	m.mu.RUnlock()
+	if ds == nil {
+		return errors.New("replication dataset not set")
+	}
+
+	var (
+		snapshot bytes.Buffer
+		r        *replica
+		replID   string
+		offset   int64
+		err      error
+	)
+	cut := func() {
+		if err = ds.Save(&snapshot); err != nil {
+			return
+		}
+
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		r = newReplica(conn)
+		m.replicas[r] = struct{}{}
+		if m.backlog == nil {
+			m.backlog = NewReplicationBacklog(m.backlogSize, m.offset)
+		}
+		replID, offset = m.replID, m.offset
+		m.seldb = -1 // The replica must be told the database of the next write
+	}
+	if barrier != nil {
+		barrier(cut)
+	} else {
+		cut()
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+
+	conn.AddFlag(net.FlagSlave)
+	header := fmt.Sprintf("+FULLRESYNC %s %d\r\n$%d\r\n", replID, offset, snapshot.Len())
+	if err := conn.WriteRESP(append([]byte(header), snapshot.Bytes()...)); err != nil {
+		m.dropReplica(r)
+		return err
+	}
+	if err := conn.Flush(); err != nil {
+		m.dropReplica(r)
+		return err
+	}
+
+	log.Info("Replica %s synced with %d bytes", conn.RemoteAddr(), snapshot.Len())
+	go m.sendStream(r)
+	return nil
+}
+
+// sendStream writes the replication stream to a replica until it is
+// dropped or its connection fails
+func (m *Manager) sendStream(r *replica) {
+	for data := range r.out {
+		err := r.conn.WriteRESP(data)
+		if err == nil {
+			err = r.conn.Flush()
+		}
+		if err != nil {
+			log.Info("Lost connection to replica %s: %v", r.conn.RemoteAddr(), err)
+			m.dropReplica(r)
+			return
+		}
+	}
+}
+
+// dropReplica disconnects a replica
+func (m *Manager) dropReplica(r *replica) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.dropReplicaLocked(r)
+}
+
+// dropReplicaLocked disconnects a replica (with m.mu held)
+func (m *Manager) dropReplicaLocked(r *replica) {
+	if _, ok := m.replicas[r]; !ok {
+		return
+	}
+	delete(m.replicas, r)
+	close(r.out)
+	_ = r.conn.Close()
+}
+
+// LogCommand sends a write command executed on database db to every
+// replica and the backlog, preceded by a SELECT if the stream was on
+// another database
+func (m *Manager) LogCommand(db int, cmdName string, args []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Until a replica first connects there is no one to send to, nor a
+	// backlog to keep
+	if len(m.replicas) == 0 && m.backlog == nil {
+		return nil
+	}
+
+	builder := resp.NewResponseBuilder()
+	if db != m.seldb {
+		builder.WriteArray(2)
+		builder.WriteBulkStringFromString("SELECT")
+		builder.WriteBulkStringFromString(strconv.Itoa(db))
+		m.seldb = db
+	}
+	builder.WriteArray(1 + len(args))
+	builder.WriteBulkStringFromString(cmdName)
+	for _, arg := range args {
+		builder.WriteBulkStringFromString(arg)
+	}
+	data := builder.Bytes()
+	m.offset += int64(len(data))
+	m.backlog.Write(data)
+
+	for r := range m.replicas {
+		select {
+		case r.out <- data:
+		default:
+			log.Warn("Replica %s is too far behind, disconnecting it", r.conn.RemoteAddr())
+			m.dropReplicaLocked(r)
+		}
+	}
+	return nil
+}
+
+// ConnectedReplicas returns the number of replicas receiving the stream
+func (m *Manager) ConnectedReplicas() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.replicas)
+}
+
+// SetBacklogSize sets the bytes of the stream kept for replicas to resume
+// from (repl-backlog-size)
+func (m *Manager) SetBacklogSize(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.backlogSize = size
+	if m.backlog != nil {
+		m.backlog.Resize(size)
+	}
+}
+
+// BacklogInfo returns whether the backlog exists, its size, and the range
+// of stream offsets it holds
+func (m *Manager) BacklogInfo() (active bool, size int, first int64, histlen int) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if m.backlog == nil {
+		return false, m.backlogSize, 0, 0
+	}
+	return true, m.backlog.Size(), m.backlog.Offset() - int64(m.backlog.Len()) + 1, m.backlog.Len()
+}
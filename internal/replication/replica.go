@@ -0,0 +1,286 @@
+// Copyright 2024 The Godis Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package replication
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/config"
+	"github.com/zyhnesmr/godis/internal/protocol/resp"
+	"github.com/zyhnesmr/godis/pkg/log"
+)
+
+// Timeouts of the connection to the master
+const (
+	connectTimeout   = 5 * time.Second
+	handshakeTimeout = 60 * time.Second // Includes receiving the snapshot
+	reconnectDelay   = time.Second
+)
+
+// linkState is the state of a replica's connection to its master
+type linkState int
+
+const (
+	linkConnecting linkState = iota
+	linkSyncing              // Handshake done, receiving the snapshot
+	linkConnected            // Applying the replication stream
+)
+
+// masterLink is a replica's connection to its master. It reconnects and
+// syncs again whenever the connection is lost, until stopped.
+type masterLink struct {
+	addr string
+	done chan struct{}
+
+	mu    sync.Mutex
+	conn  net.Conn
+	state linkState
+}
+
+// newMasterLink creates the link to the master at host:port
+func newMasterLink(host string, port int) *masterLink {
+	return &masterLink{
+		addr: net.JoinHostPort(host, strconv.Itoa(port)),
+		done: make(chan struct{}),
+	}
+}
+
+// stop closes the link for good
+func (l *masterLink) stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	select {
+	case <-l.done:
+		return
+	default:
+	}
+	close(l.done)
+	if l.conn != nil {
+		_ = l.conn.Close()
+	}
+}
+
+// stopped reports whether the link was stopped
+func (l *masterLink) stopped() bool {
+	select {
+	case <-l.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// setConn records the current connection, so that stop can close it. It
+// fails if the link was stopped meanwhile.
+func (l *masterLink) setConn(conn net.Conn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.stopped() {
+		return false
+	}
+	l.conn = conn
+	return true
+}
+
+func (l *masterLink) getState() linkState {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.state
+}
+
+func (l *masterLink) setState(state linkState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.state = state
+}
+
+// runLink syncs with the master until the link is stopped
+func (m *Manager) runLink(l *masterLink) {
+	for {
+		err := m.syncWithMaster(l)
+		if l.stopped() {
+			return
+		}
+		log.Warn("Connection with master %s lost: %v", l.addr, err)
+		l.setState(linkConnecting)
+
+		select {
+		case <-l.done:
+			return
+		case <-time.After(reconnectDelay):
+		}
+	}
+}
+
+// syncWithMaster connects to the master, loads its snapshot and applies
+// its replication stream until the connection fails
+func (m *Manager) syncWithMaster(l *masterLink) error {
+	m.mu.RLock()
+	ds := m.dataset
+	m.mu.RUnlock()
+	if ds == nil {
+		return errors.New("replication dataset not set")
+	}
+
+	conn, err := net.DialTimeout("tcp", l.addr, connectTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if !l.setConn(conn) {
+		return nil
+	}
+	log.Info("Connected to master %s", l.addr)
+
+	reader := bufio.NewReader(conn)
+	_ = conn.SetDeadline(time.Now().Add(handshakeTimeout))
+	m.mu.RLock()
+	replID, offset := m.replID, m.offset
+	m.mu.RUnlock()
+	full, replID, offset, err := handshake(conn, reader, replID, offset)
+	if err != nil {
+		return err
+	}
+
+	if full {
+		l.setState(linkSyncing)
+		if err := m.loadSnapshot(reader, ds); err != nil {
+			return err
+		}
+		log.Info("Full sync with master %s done", l.addr)
+		m.mu.Lock()
+		m.replID, m.offset, m.streamDB = replID, offset, 0
+		m.mu.Unlock()
+	} else {
+		log.Info("Resumed replication from master %s at offset %d", l.addr, offset)
+	}
+	l.setState(linkConnected)
+
+	_ = conn.SetDeadline(time.Time{})
+	return m.applyStream(reader, ds)
+}
+
+// loadSnapshot receives the master's snapshot, sent as a bulk string
+// without the final CRLF, and loads it
+func (m *Manager) loadSnapshot(reader *bufio.Reader, ds Dataset) error {
+	line, err := readLine(reader)
+	if err != nil {
+		return err
+	}
+	size, err := strconv.Atoi(strings.TrimPrefix(line, "$"))
+	if !strings.HasPrefix(line, "$") || err != nil || size < 0 {
+		return fmt.Errorf("bad snapshot header %q", line)
+	}
+	snapshot := make([]byte, size)
+	if _, err := io.ReadFull(reader, snapshot); err != nil {
+		return err
+	}
+	if err := ds.Load(bytes.NewReader(snapshot)); err != nil {
+		return fmt.Errorf("failed to load snapshot: %w", err)
+	}
+	return nil
+}
+
+// handshake announces the replica to the master and asks to resume the
+// stream replID after the offset bytes already applied. It reports whether
+// the master starts a full sync instead, with the replication ID and
+// offset its snapshot matches.
+func handshake(conn net.Conn, reader *bufio.Reader, replID string, offset int64) (bool, string, int64, error) {
+	port := strconv.Itoa(int(config.Instance().Port))
+	steps := [][]string{
+		{"PING"},
+		{"REPLCONF", "listening-port", port},
+		{"PSYNC", replID, strconv.FormatInt(offset+1, 10)},
+	}
+
+	for _, argv := range steps {
+		if _, err := conn.Write(resp.BuildStringArray(argv)); err != nil {
+			return false, "", 0, err
+		}
+		line, err := readLine(reader)
+		if err != nil {
+			return false, "", 0, err
+		}
+		if strings.HasPrefix(line, "-") {
+			return false, "", 0, fmt.Errorf("master refused %s: %s", argv[0], line[1:])
+		}
+		if argv[0] != "PSYNC" {
+			continue
+		}
+
+		// +FULLRESYNC <replid> <offset> or +CONTINUE
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] == "+CONTINUE" {
+			return false, replID, offset, nil
+		}
+		if len(fields) != 3 || fields[0] != "+FULLRESYNC" {
+			return false, "", 0, fmt.Errorf("unexpected PSYNC reply %q", line)
+		}
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return false, "", 0, fmt.Errorf("unexpected PSYNC reply %q", line)
+		}
+		return true, fields[1], offset, nil
+	}
+	return false, "", 0, errors.New("no PSYNC reply")
+}
+
+// readLine reads a line of a reply without its CRLF
+func readLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// applyStream applies the master's write commands as they arrive. The
+// selected database carries over from the previous connection, as a
+// resumed stream continues where it stopped.
+func (m *Manager) applyStream(reader *bufio.Reader, ds Dataset) error {
+	parser := resp.NewParser(reader)
+	m.mu.RLock()
+	db := m.streamDB
+	m.mu.RUnlock()
+	for {
+		msg, err := parser.ReadCommand()
+		if err != nil {
+			return err
+		}
+		cmdName, args, err := msg.ParseCommand()
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.EqualFold(cmdName, "SELECT") && len(args) == 1:
+			if n, err := strconv.Atoi(args[0]); err == nil {
+				db = n
+			}
+		case strings.EqualFold(cmdName, "MULTI"), strings.EqualFold(cmdName, "EXEC"):
+			// The writes of a transaction are applied as they arrive
+		default:
+			if err := ds.Apply(db, cmdName, args); err != nil {
+				log.Warn("Failed to apply %s from master: %v", cmdName, err)
+			}
+		}
+
+		m.mu.Lock()
+		m.offset += int64(len(msg.Marshal()))
+		m.streamDB = db
+		m.mu.Unlock()
+	}
+}
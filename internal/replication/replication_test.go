@@ -0,0 +1,380 @@
+package replication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	stdnet "net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/zyhnesmr/godis/internal/net"
+	"github.com/zyhnesmr/godis/internal/protocol/resp"
+)
+
+// mapDataset is a dataset of strings keyed by database and key, saved as
+// JSON, that only applies SET
+type mapDataset struct {
+	mu    sync.Mutex
+	data  map[string]string
+	loads int // Snapshots loaded
+}
+
+func newMapDataset() *mapDataset {
+	return &mapDataset{data: make(map[string]string)}
+}
+
+func (d *mapDataset) Save(w io.Writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return json.NewEncoder(w).Encode(d.data)
+}
+
+func (d *mapDataset) Load(r io.Reader) error {
+	data := make(map[string]string)
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data = data
+	d.loads++
+	return nil
+}
+
+func (d *mapDataset) Apply(db int, cmdName string, args []string) error {
+	if !strings.EqualFold(cmdName, "SET") || len(args) != 2 {
+		return fmt.Errorf("unsupported command %s", cmdName)
+	}
+	d.set(db, args[0], args[1])
+	return nil
+}
+
+func (d *mapDataset) set(db int, key, value string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.data[fmt.Sprintf("%d:%s", db, key)] = value
+}
+
+func (d *mapDataset) get(db int, key string) string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.data[fmt.Sprintf("%d:%s", db, key)]
+}
+
+// masterProcessor answers the replica handshake the way the PSYNC and
+// REPLCONF commands do
+type masterProcessor struct {
+	mgr *Manager
+}
+
+func (p masterProcessor) ProcessCommand(ctx context.Context, conn *net.Conn, cmd string, args []string) ([]byte, error) {
+	switch strings.ToUpper(cmd) {
+	case "PING":
+		return resp.BuildPong(), nil
+	case "REPLCONF":
+		return resp.BuildOK(), nil
+	case "PSYNC":
+		offset, err := strconv.ParseInt(args[1], 10, 64)
+		if err != nil {
+			return resp.BuildErrorString("ERR bad offset"), nil
+		}
+		if err := p.mgr.Sync(conn, args[0], offset); err != nil {
+			return resp.BuildErrorString("ERR " + err.Error()), nil
+		}
+		return nil, nil
+	}
+	return resp.BuildErrorString("ERR unknown command"), nil
+}
+
+// startMaster serves the replication handshake of mgr on a local port
+func startMaster(t *testing.T, mgr *Manager) int {
+	ln, err := stdnet.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			raw, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				conn := net.NewConn(raw)
+				defer conn.Close()
+				net.DefaultHandle(context.Background(), conn, masterProcessor{mgr})
+			}()
+		}
+	}()
+	return ln.Addr().(*stdnet.TCPAddr).Port
+}
+
+// waitFor fails the test if cond does not hold within a few seconds
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestReplicaSyncsFromMaster(t *testing.T) {
+	masterData := newMapDataset()
+	masterData.set(0, "before", "snapshot")
+	master := NewManager()
+	master.SetDataset(masterData)
+	port := startMaster(t, master)
+
+	replicaData := newMapDataset()
+	replica := NewManager()
+	replica.SetDataset(replicaData)
+	replica.SetMaster("127.0.0.1", port)
+	defer replica.SetNoOne()
+
+	// The key written before the sync arrives with the snapshot
+	waitFor(t, "full sync", func() bool {
+		up, _ := replica.LinkStatus()
+		return up && master.ConnectedReplicas() == 1
+	})
+	if got := replicaData.get(0, "before"); got != "snapshot" {
+		t.Fatalf("replica has before=%q after the full sync", got)
+	}
+	if replica.ReplID() != master.ReplID() {
+		t.Fatalf("replica follows %s, master serves %s", replica.ReplID(), master.ReplID())
+	}
+
+	// Later writes arrive through the stream, on their database
+	masterData.set(0, "after", "stream")
+	_ = master.LogCommand(0, "SET", []string{"after", "stream"})
+	masterData.set(3, "other", "db")
+	_ = master.LogCommand(3, "SET", []string{"other", "db"})
+
+	waitFor(t, "the replication stream", func() bool {
+		return replicaData.get(3, "other") == "db"
+	})
+	if got := replicaData.get(0, "after"); got != "stream" {
+		t.Fatalf("replica has after=%q", got)
+	}
+	if got := replicaData.get(0, "other"); got != "" {
+		t.Fatalf("write to db 3 applied to db 0: %q", got)
+	}
+	waitFor(t, "matching offsets", func() bool {
+		return replica.Offset() == master.Offset()
+	})
+
+	// Once promoted, the replica leaves the master
+	replica.SetNoOne()
+	if replica.Role() != RoleMaster {
+		t.Fatal("REPLICAOF NO ONE should make the replica a master")
+	}
+	_ = master.LogCommand(0, "SET", []string{"late", "write"})
+	waitFor(t, "the replica to disconnect", func() bool {
+		_ = master.LogCommand(0, "SET", []string{"late", "write"})
+		return master.ConnectedReplicas() == 0
+	})
+	if got := replicaData.get(0, "late"); got != "" {
+		t.Fatalf("promoted replica applied late=%q", got)
+	}
+}
+
+func (d *mapDataset) loadCount() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.loads
+}
+
+func TestBacklog(t *testing.T) {
+	b := NewReplicationBacklog(8, 100)
+	if _, ok := b.Since(100); !ok {
+		t.Fatal("an empty backlog should serve its current offset")
+	}
+
+	b.Write([]byte("abcde"))
+	if data, ok := b.Since(102); !ok || string(data) != "cde" {
+		t.Fatalf("Since(102) = %q, %v", data, ok)
+	}
+
+	// Wrapping around drops the oldest bytes
+	b.Write([]byte("fghij"))
+	if b.Len() != 8 || b.Offset() != 110 {
+		t.Fatalf("len %d, offset %d after wrapping", b.Len(), b.Offset())
+	}
+	if _, ok := b.Since(101); ok {
+		t.Fatal("Since should fail for dropped bytes")
+	}
+	if _, ok := b.Since(111); ok {
+		t.Fatal("Since should fail for bytes not written yet")
+	}
+	if data, ok := b.Since(102); !ok || string(data) != "cdefghij" {
+		t.Fatalf("Since(102) = %q, %v", data, ok)
+	}
+
+	// A write larger than the backlog keeps its tail
+	b.Write([]byte("0123456789"))
+	if data, _ := b.Since(b.Offset() - 8); string(data) != "23456789" {
+		t.Fatalf("backlog holds %q", data)
+	}
+
+	b.Resize(4)
+	if data, ok := b.Since(b.Offset() - 4); !ok || string(data) != "6789" || b.Len() != 4 {
+		t.Fatalf("after shrinking, backlog holds %q", data)
+	}
+	b.Resize(16)
+	b.Write([]byte("xy"))
+	if data, ok := b.Since(b.Offset() - 6); !ok || string(data) != "6789xy" {
+		t.Fatalf("after growing, backlog holds %q", data)
+	}
+}
+
+func TestReplicaResumesFromBacklog(t *testing.T) {
+	masterData := newMapDataset()
+	master := NewManager()
+	master.SetDataset(masterData)
+	port := startMaster(t, master)
+
+	replicaData := newMapDataset()
+	replica := NewManager()
+	replica.SetDataset(replicaData)
+	replica.SetMaster("127.0.0.1", port)
+	defer replica.SetNoOne()
+
+	waitFor(t, "full sync", func() bool {
+		up, _ := replica.LinkStatus()
+		return up && master.ConnectedReplicas() == 1
+	})
+	_ = master.LogCommand(2, "SET", []string{"first", "1"})
+	waitFor(t, "the replication stream", func() bool {
+		return replicaData.get(2, "first") == "1"
+	})
+
+	// Drop the connection; the writes made meanwhile stay in the backlog
+	replica.mu.RLock()
+	link := replica.link
+	replica.mu.RUnlock()
+	link.mu.Lock()
+	_ = link.conn.Close()
+	link.mu.Unlock()
+	_ = master.LogCommand(2, "SET", []string{"second", "2"})
+
+	waitFor(t, "the missed write", func() bool {
+		return replicaData.get(2, "second") == "2"
+	})
+	if n := replicaData.loadCount(); n != 1 {
+		t.Fatalf("replica loaded %d snapshots, want a single one", n)
+	}
+
+	// The resumed stream keeps its selected database
+	_ = master.LogCommand(2, "SET", []string{"third", "3"})
+	waitFor(t, "the resumed stream", func() bool {
+		return replicaData.get(2, "third") == "3"
+	})
+	waitFor(t, "matching offsets", func() bool {
+		return replica.Offset() == master.Offset()
+	})
+}
+
+// savingDataset is a mapDataset that runs a hook while it saves and keeps
+// the snapshots it saved
+type savingDataset struct {
+	*mapDataset
+	onSave    func()
+	snapshots []string
+}
+
+func (d *savingDataset) Save(w io.Writer) error {
+	d.onSave()
+	var buf strings.Builder
+	if err := d.mapDataset.Save(&buf); err != nil {
+		return err
+	}
+	d.snapshots = append(d.snapshots, buf.String())
+	_, err := io.WriteString(w, buf.String())
+	return err
+}
+
+func TestFullSyncSnapshotIsAPointInTheStream(t *testing.T) {
+	masterData := &savingDataset{mapDataset: newMapDataset()}
+	master := NewManager()
+	master.SetDataset(masterData)
+
+	// A write made while the snapshot is saved waits for it, then reaches
+	// the replica through the stream only
+	var writes sync.RWMutex
+	master.SetWriteBarrier(func(fn func()) {
+		writes.Lock()
+		defer writes.Unlock()
+		fn()
+	})
+	written := make(chan struct{})
+	masterData.onSave = func() {
+		masterData.onSave = func() {}
+		go func() {
+			defer close(written)
+			writes.RLock()
+			defer writes.RUnlock()
+			masterData.set(0, "during", "sync")
+			_ = master.LogCommand(0, "SET", []string{"during", "sync"})
+		}()
+		time.Sleep(50 * time.Millisecond)
+	}
+	port := startMaster(t, master)
+
+	replicaData := newMapDataset()
+	replica := NewManager()
+	replica.SetDataset(replicaData)
+	replica.SetMaster("127.0.0.1", port)
+	defer replica.SetNoOne()
+
+	<-written
+	waitFor(t, "the write made during the sync", func() bool {
+		return replicaData.get(0, "during") == "sync"
+	})
+	if len(masterData.snapshots) != 1 || strings.Contains(masterData.snapshots[0], "during") {
+		t.Fatalf("snapshots %q should not hold the write queued for the stream", masterData.snapshots)
+	}
+	waitFor(t, "matching offsets", func() bool {
+		return replica.Offset() == master.Offset()
+	})
+}
+
+// loadingDataset is a mapDataset whose Load waits to be released
+type loadingDataset struct {
+	*mapDataset
+	release chan struct{}
+}
+
+func (d *loadingDataset) Load(r io.Reader) error {
+	<-d.release
+	return d.mapDataset.Load(r)
+}
+
+func TestLinkStatusReportsFullSync(t *testing.T) {
+	master := NewManager()
+	master.SetDataset(newMapDataset())
+	port := startMaster(t, master)
+
+	replicaData := &loadingDataset{mapDataset: newMapDataset(), release: make(chan struct{})}
+	replica := NewManager()
+	replica.SetDataset(replicaData)
+	replica.SetMaster("127.0.0.1", port)
+	defer replica.SetNoOne()
+
+	waitFor(t, "the snapshot to load", func() bool {
+		up, syncing := replica.LinkStatus()
+		return !up && syncing
+	})
+	close(replicaData.release)
+	waitFor(t, "the end of the full sync", func() bool {
+		up, syncing := replica.LinkStatus()
+		return up && !syncing
+	})
+}
@@ -26,18 +26,24 @@ type Manager struct {
 	queues map[*net.Conn][]*QueuedCommand
 
 	// WATCHed keys: connection -> set of watched keys
-	watchedKeys map[*net.Conn]map[string]struct{}
+	watchedKeys map[*net.Conn]map[watchedKey]struct{}
 
-	// Dirty keys: keys that have been modified (for WATCH)
-	dirtyKeys map[string]struct{}
+	// Watchers: watched key -> connections watching it
+	watchers map[watchedKey]map[*net.Conn]struct{}
+}
+
+// watchedKey is a key scoped to its database
+type watchedKey struct {
+	db  int
+	key string
 }
 
 // NewManager creates a new transaction manager
 func NewManager() *Manager {
 	return &Manager{
 		queues:      make(map[*net.Conn][]*QueuedCommand),
-		watchedKeys: make(map[*net.Conn]map[string]struct{}),
-		dirtyKeys:   make(map[string]struct{}),
+		watchedKeys: make(map[*net.Conn]map[watchedKey]struct{}),
+		watchers:    make(map[watchedKey]map[*net.Conn]struct{}),
 	}
 }
 
@@ -59,6 +65,7 @@ func (m *Manager) Begin(conn *net.Conn) error {
 	}
 
 	m.queues[conn] = make([]*QueuedCommand, 0, 10)
+	conn.RemoveFlag(net.FlagDirtyExec)
 	return nil
 }
 
@@ -107,124 +114,81 @@ func (m *Manager) Discard(conn *net.Conn) {
 	defer m.mu.Unlock()
 
 	delete(m.queues, conn)
+	conn.RemoveFlag(net.FlagDirtyExec)
 }
 
-// Watch adds keys to the watch list for a connection
-func (m *Manager) Watch(conn *net.Conn, keys ...string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// MarkQueueError records that a command was rejected while queuing, so
+// the connection's EXEC fails. It does nothing outside MULTI.
+func (m *Manager) MarkQueueError(conn *net.Conn) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	if m.watchedKeys[conn] == nil {
-		m.watchedKeys[conn] = make(map[string]struct{})
+	if _, ok := m.queues[conn]; ok {
+		conn.AddFlag(net.FlagDirtyExec)
 	}
+}
 
-	// Also add to connection's watched keys
-	watched := conn.GetWatchedKeys()
-	for _, key := range keys {
-		m.watchedKeys[conn][key] = struct{}{}
-		watched[key] = struct{}{}
-		// Clear dirty flag for this key - we start watching from now
-		delete(m.dirtyKeys, key)
-	}
+// HasQueueError returns true if a command was rejected while queuing
+func (m *Manager) HasQueueError(conn *net.Conn) bool {
+	return conn.HasFlag(net.FlagDirtyExec)
 }
 
-// Unwatch removes keys from the watch list for a connection
-func (m *Manager) Unwatch(conn *net.Conn, keys ...string) {
+// Watch adds keys of database db to the watch list for a connection
+func (m *Manager) Watch(conn *net.Conn, db int, keys ...string) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
 	if m.watchedKeys[conn] == nil {
-		return
+		m.watchedKeys[conn] = make(map[watchedKey]struct{})
 	}
 
-	// Remove from connection's watched keys
-	watched := conn.GetWatchedKeys()
 	for _, key := range keys {
-		delete(m.watchedKeys[conn], key)
-		delete(watched, key)
-	}
-
-	// Clean up if empty
-	if len(m.watchedKeys[conn]) == 0 {
-		delete(m.watchedKeys, conn)
+		wk := watchedKey{db: db, key: key}
+		m.watchedKeys[conn][wk] = struct{}{}
+		if m.watchers[wk] == nil {
+			m.watchers[wk] = make(map[*net.Conn]struct{})
+		}
+		m.watchers[wk][conn] = struct{}{}
+		conn.WatchKey(key)
 	}
 }
 
-// UnwatchAll removes all watched keys for a connection
+// UnwatchAll removes all watched keys for a connection and clears its
+// dirty flag
 func (m *Manager) UnwatchAll(conn *net.Conn) {
 	m.mu.Lock()
-	delete(m.watchedKeys, conn)
+	m.unwatchAllLocked(conn)
 	m.mu.Unlock()
 
-	// Call conn.UnwatchAll() outside the lock to avoid potential deadlock
-	// if conn.UnwatchAll() tries to access transaction manager methods
 	conn.UnwatchAll()
 }
 
-// MarkDirty marks a key as dirty (modified)
-func (m *Manager) MarkDirty(key string) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.dirtyKeys[key] = struct{}{}
-	// Debug: log dirty key
-	// println("[DEBUG] MarkDirty:", key)
-}
-
-// CheckWatchedKeys checks if any watched keys have been modified
-// Returns true if the transaction should be aborted
-func (m *Manager) CheckWatchedKeys(conn *net.Conn) bool {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	watched, ok := m.watchedKeys[conn]
-	if !ok || len(watched) == 0 {
-		return false
-	}
-
-	// Check if any watched key is dirty
-	for key := range watched {
-		if _, dirty := m.dirtyKeys[key]; dirty {
-			return true
+// unwatchAllLocked removes the connection from the watch indexes (with m.mu held)
+func (m *Manager) unwatchAllLocked(conn *net.Conn) {
+	for wk := range m.watchedKeys[conn] {
+		delete(m.watchers[wk], conn)
+		if len(m.watchers[wk]) == 0 {
+			delete(m.watchers, wk)
 		}
 	}
-
-	return false
-}
-
-// ClearDirty clears the dirty keys list
-func (m *Manager) ClearDirty() {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-
-	m.dirtyKeys = make(map[string]struct{})
+	delete(m.watchedKeys, conn)
 }
 
-// ClearWatchedDirty clears dirty keys that are no longer watched by any connection
-func (m *Manager) ClearWatchedDirty(conn *net.Conn) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
+// MarkDirty flags every connection watching key in database db, so their
+// next EXEC fails
+func (m *Manager) MarkDirty(db int, key string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
 
-	// Get the keys that were watched by this connection
-	watched, ok := m.watchedKeys[conn]
-	if !ok {
-		return
+	for conn := range m.watchers[watchedKey{db: db, key: key}] {
+		conn.MarkDirty()
 	}
+}
 
-	// For each watched key, check if any other connection is still watching it
-	for key := range watched {
-		stillWatched := false
-		for _, otherWatched := range m.watchedKeys {
-			if _, ok := otherWatched[key]; ok {
-				stillWatched = true
-				break
-			}
-		}
-		// If no other connection is watching this key, clear the dirty flag
-		if !stillWatched {
-			delete(m.dirtyKeys, key)
-		}
-	}
+// CheckWatchedKeys checks if any watched keys have been modified
+// Returns true if the transaction should be aborted
+func (m *Manager) CheckWatchedKeys(conn *net.Conn) bool {
+	return conn.IsDirty()
 }
 
 // RemoveConnection cleans up transaction state for a closed connection
@@ -233,7 +197,7 @@ func (m *Manager) RemoveConnection(conn *net.Conn) {
 	defer m.mu.Unlock()
 
 	delete(m.queues, conn)
-	delete(m.watchedKeys, conn)
+	m.unwatchAllLocked(conn)
 }
 
 // Execute executes the queued commands for a connection
@@ -258,10 +222,10 @@ func (m *Manager) Execute(conn *net.Conn, db *database.DB, dispatcherFunc interf
 	m.queues[conn] = nil
 
 	// Clear watched keys for this connection (EXEC clears WATCH)
-	watchedKeys := m.watchedKeys[conn]
-	delete(m.watchedKeys, conn)
+	m.unwatchAllLocked(conn)
 
 	m.mu.Unlock()
+	conn.UnwatchAll()
 
 	// Execute commands outside the lock
 	replies := make([]interface{}, len(commands))
@@ -274,25 +238,6 @@ func (m *Manager) Execute(conn *net.Conn, db *database.DB, dispatcherFunc interf
 		replies[i] = []interface{}{cmd.CmdName, cmd.Args}
 	}
 
-	// If all commands succeeded, clear the watched keys
-	if watchedKeys != nil {
-		for key := range watchedKeys {
-			// Only clear if no other connection is watching this key
-			m.mu.Lock()
-			stillWatched := false
-			for _, wk := range m.watchedKeys {
-				if _, ok := wk[key]; ok {
-					stillWatched = true
-					break
-				}
-			}
-			if !stillWatched {
-				delete(m.dirtyKeys, key)
-			}
-			m.mu.Unlock()
-		}
-	}
-
 	return replies, nil
 }
 
@@ -305,4 +250,6 @@ var (
 	ErrExecWatch = errors.New("WATCH inside MULTI is not allowed")
 
 	ErrWatch = errors.New("EXECABORT Transaction discarded because of a previous error.")
+
+	ErrExecAbort = errors.New("EXECABORT Transaction discarded because of previous errors.")
 )